@@ -0,0 +1,289 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// ExecuteBatch executes a query's metadata statements as a single broadcast
+// message. Every statement is validated before any is applied so either all
+// of them are applied or none are, which makes provisioning scripts safe to
+// retry. Only CREATE DATABASE, CREATE RETENTION POLICY, CREATE USER and GRANT
+// statements can be batched. A retention policy created with DEFAULT is also
+// set as the default policy of its database.
+//
+// Returns a result for each statement. If the batch is rejected then the
+// failed statement reports its error and the others report ErrNotExecuted.
+func (s *Server) ExecuteBatch(q *influxql.Query, user *User) Results {
+	results := make(Results, len(q.Statements))
+	for i := range results {
+		results[i] = &Result{}
+	}
+
+	// Standby data nodes don't execute queries.
+	if s.Standby() {
+		return batchResults(results, 0, ErrDataNodeStandby)
+	}
+
+	// Convert each statement to the commands it broadcasts.
+	c := &metadataBatchCommand{}
+	for i, stmt := range q.Statements {
+		err := s.authorize(stmt, "", user)
+		if err == nil {
+			err = c.add(i, stmt)
+		}
+		if err != nil {
+			return batchResults(results, i, err)
+		}
+	}
+	if len(c.Commands) == 0 {
+		return results
+	}
+
+	// Apply the batch and attribute any error to its statement.
+	if _, err := s.broadcast(metadataBatchMessageType, c); err != nil {
+		if e, ok := err.(*batchError); ok {
+			return batchResults(results, e.statement, e.err)
+		}
+		return batchResults(results, 0, err)
+	}
+	return results
+}
+
+// batchResults sets the error of statement i and marks all other statements
+// as not executed.
+func batchResults(results Results, i int, err error) Results {
+	for j, res := range results {
+		if j == i {
+			res.Err = err
+		} else {
+			res.Err = ErrNotExecuted
+		}
+	}
+	return results
+}
+
+// batchError represents the error of a single statement in a batch.
+type batchError struct {
+	statement int
+	err       error
+}
+
+// Error returns the statement's position and error.
+func (e *batchError) Error() string {
+	return fmt.Sprintf("statement %d: %s", e.statement, e.err)
+}
+
+type metadataBatchCommand struct {
+	Commands []*batchCommand `json:"commands"`
+}
+
+// batchCommand represents a single broadcast command within a batch.
+type batchCommand struct {
+	Statement int                   `json:"statement"` // position of the statement in the query
+	Type      messaging.MessageType `json:"type"`
+	Data      json.RawMessage       `json:"data"`
+}
+
+// add appends the commands for statement i to the batch.
+// Returns ErrInvalidBatchStatement if the statement can't be batched.
+func (c *metadataBatchCommand) add(i int, stmt influxql.Statement) error {
+	switch stmt := stmt.(type) {
+	case *influxql.CreateDatabaseStatement:
+		c.addCreateDatabase(i, stmt.Name, createDatabaseRetentionPolicy(stmt))
+	case *influxql.CreateRetentionPolicyStatement:
+		c.append(i, createRetentionPolicyMessageType, &createRetentionPolicyCommand{
+			Database:  stmt.Database,
+			Name:      stmt.Name,
+			Duration:  stmt.Duration,
+			ReplicaN:  uint32(stmt.Replication),
+			SplitN:    uint32(stmt.Split),
+			ShardHash: stmt.Hash,
+		})
+		if stmt.Default {
+			c.append(i, setDefaultRetentionPolicyMessageType, &setDefaultRetentionPolicyCommand{Database: stmt.Database, Name: stmt.Name})
+		}
+	case *influxql.CreateUserStatement:
+		admin := stmt.Privilege != nil && *stmt.Privilege == influxql.AllPrivileges
+		c.append(i, createUserMessageType, &createUserCommand{Username: stmt.Name, Password: stmt.Password, Admin: admin})
+	case *influxql.GrantStatement:
+		c.append(i, grantPrivilegeMessageType, &privilegeCommand{Username: stmt.User, Database: stmt.On, Privilege: stmt.Privilege})
+	default:
+		return ErrInvalidBatchStatement
+	}
+	return nil
+}
+
+// addCreateDatabase appends the commands to create a database and, if rp
+// isn't nil, to create rp and set it as the database's default policy.
+func (c *metadataBatchCommand) addCreateDatabase(i int, name string, rp *RetentionPolicy) {
+	c.append(i, createDatabaseMessageType, &createDatabaseCommand{Name: name})
+	if rp == nil {
+		return
+	}
+	c.append(i, createRetentionPolicyMessageType, &createRetentionPolicyCommand{
+		Database:  name,
+		Name:      rp.Name,
+		Duration:  rp.Duration,
+		ReplicaN:  rp.ReplicaN,
+		SplitN:    rp.SplitN,
+		ShardHash: rp.ShardHash,
+	})
+	c.append(i, setDefaultRetentionPolicyMessageType, &setDefaultRetentionPolicyCommand{Database: name, Name: rp.Name})
+}
+
+// append encodes a command and appends it to the batch.
+func (c *metadataBatchCommand) append(i int, typ messaging.MessageType, v interface{}) {
+	c.Commands = append(c.Commands, &batchCommand{Statement: i, Type: typ, Data: mustMarshalJSON(v)})
+}
+
+func (s *Server) applyMetadataBatch(m *messaging.Message) error {
+	var c metadataBatchCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate every command against the changes made by earlier commands.
+	if err := s.validateMetadataBatch(c.Commands); err != nil {
+		return err
+	}
+
+	// Apply every command and record the message's index in a single
+	// metastore transaction so that a restart can't replay part of a batch.
+	err := s.meta.update(func(tx *metatx) error {
+		for _, cmd := range c.Commands {
+			if err := s.applyBatchCommand(tx, cmd); err != nil {
+				return &batchError{statement: cmd.Statement, err: err}
+			}
+		}
+		return tx.setIndex(m.Index)
+	})
+	if _, ok := err.(*batchError); err != nil && !ok {
+		panic("metastore update: " + err.Error())
+	}
+	return err
+}
+
+// applyBatchCommand applies a single command of a batch in tx.
+// This function must be called under a lock.
+func (s *Server) applyBatchCommand(tx *metatx, cmd *batchCommand) error {
+	switch cmd.Type {
+	case createDatabaseMessageType:
+		var c createDatabaseCommand
+		mustUnmarshalJSON(cmd.Data, &c)
+		return s.createDatabase(tx, &c)
+	case createRetentionPolicyMessageType:
+		var c createRetentionPolicyCommand
+		mustUnmarshalJSON(cmd.Data, &c)
+		return s.createRetentionPolicy(tx, &c)
+	case setDefaultRetentionPolicyMessageType:
+		var c setDefaultRetentionPolicyCommand
+		mustUnmarshalJSON(cmd.Data, &c)
+		return s.setDefaultRetentionPolicy(tx, &c)
+	case createUserMessageType:
+		var c createUserCommand
+		mustUnmarshalJSON(cmd.Data, &c)
+		return s.createUser(tx, &c)
+	case grantPrivilegeMessageType:
+		var c privilegeCommand
+		mustUnmarshalJSON(cmd.Data, &c)
+		return s.grantPrivilege(tx, &c)
+	default:
+		return ErrInvalidBatchStatement
+	}
+}
+
+// validateMetadataBatch returns the error the first failing command of a
+// batch would return if the batch were applied.
+// This function must be called under a read lock.
+func (s *Server) validateMetadataBatch(commands []*batchCommand) error {
+	databases := make(map[string]map[string]bool) // policies of databases created by the batch
+	users := make(map[string]bool)                // users created by the batch
+
+	databaseExists := func(name string) bool {
+		return s.databases[name] != nil || databases[name] != nil
+	}
+	policyExists := func(database, name string) bool {
+		if db := s.databases[database]; db != nil && db.policies[name] != nil {
+			return true
+		}
+		return databases[database][name]
+	}
+
+	for _, cmd := range commands {
+		var err error
+		switch cmd.Type {
+		case createDatabaseMessageType:
+			var c createDatabaseCommand
+			mustUnmarshalJSON(cmd.Data, &c)
+			if databaseExists(c.Name) {
+				err = ErrDatabaseExists
+			} else {
+				databases[c.Name] = make(map[string]bool)
+			}
+
+		case createRetentionPolicyMessageType:
+			var c createRetentionPolicyCommand
+			mustUnmarshalJSON(cmd.Data, &c)
+			if !databaseExists(c.Database) {
+				err = ErrDatabaseNotFound
+			} else if c.Name == "" {
+				err = ErrRetentionPolicyNameRequired
+			} else if policyExists(c.Database, c.Name) {
+				err = ErrRetentionPolicyExists
+			} else if !validShardHash(c.ShardHash) {
+				err = ErrInvalidShardHash
+			} else {
+				if databases[c.Database] == nil {
+					databases[c.Database] = make(map[string]bool)
+				}
+				databases[c.Database][c.Name] = true
+			}
+
+		case setDefaultRetentionPolicyMessageType:
+			var c setDefaultRetentionPolicyCommand
+			mustUnmarshalJSON(cmd.Data, &c)
+			if !databaseExists(c.Database) {
+				err = ErrDatabaseNotFound
+			} else if !policyExists(c.Database, c.Name) {
+				err = ErrRetentionPolicyNotFound
+			}
+
+		case createUserMessageType:
+			var c createUserCommand
+			mustUnmarshalJSON(cmd.Data, &c)
+			if c.Username == "" {
+				err = ErrUsernameRequired
+			} else if s.users[c.Username] != nil || users[c.Username] {
+				err = ErrUserExists
+			} else if e := s.passwordPolicy.Validate(c.Username, c.Password); e != nil {
+				err = e
+			} else {
+				users[c.Username] = true
+			}
+
+		case grantPrivilegeMessageType:
+			var c privilegeCommand
+			mustUnmarshalJSON(cmd.Data, &c)
+			if s.users[c.Username] == nil && !users[c.Username] {
+				err = ErrUserNotFound
+			} else if c.Database == "" && c.Privilege != influxql.AllPrivileges {
+				err = ErrDatabaseRequired
+			} else if c.Database != "" && !databaseExists(c.Database) {
+				err = ErrDatabaseNotFound
+			}
+
+		default:
+			err = ErrInvalidBatchStatement
+		}
+
+		if err != nil {
+			return &batchError{statement: cmd.Statement, err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package influxdb
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// WriteBlock represents a rule that rejects writes of points with a matching
+// measurement name or tag value. Blocks are broadcast to every data node so a
+// point is rejected no matter which node or input receives it.
+type WriteBlock struct {
+	TagKey string `json:"tagKey,omitempty"` // blocked tag, empty when blocking measurements
+	Value  string `json:"value"`            // measurement name, tag value or regular expression
+	Regex  bool   `json:"regex,omitempty"`  // true if Value is a regular expression
+
+	re *regexp.Regexp
+}
+
+// newWriteBlock returns a block from the pattern of a BLOCK or UNBLOCK statement.
+func newWriteBlock(tagKey string, value influxql.Expr) *WriteBlock {
+	b := &WriteBlock{TagKey: tagKey}
+	switch value := value.(type) {
+	case *influxql.VarRef:
+		b.Value = value.Val
+	case *influxql.StringLiteral:
+		b.Value = value.Val
+	case *influxql.RegexLiteral:
+		b.Value, b.Regex = value.Val.String(), true
+	}
+	return b
+}
+
+// String returns the block's pattern as it is written in a BLOCK statement.
+func (b *WriteBlock) String() string {
+	s := "MEASUREMENT"
+	if b.TagKey != "" {
+		s = "TAG " + b.TagKey
+	}
+
+	switch {
+	case b.Regex:
+		return s + " =~ /" + strings.Replace(b.Value, "/", `\/`, -1) + "/"
+	case b.TagKey != "":
+		return s + " = " + influxql.QuoteString(b.Value)
+	default:
+		return s + " = " + b.Value
+	}
+}
+
+// compile validates the block and compiles its regular expression.
+// Returns ErrInvalidWriteBlock if the value is blank or not a valid expression.
+func (b *WriteBlock) compile() error {
+	if b.Value == "" {
+		return ErrInvalidWriteBlock
+	} else if !b.Regex {
+		return nil
+	}
+
+	re, err := regexp.Compile(b.Value)
+	if err != nil {
+		return ErrInvalidWriteBlock
+	}
+	b.re = re
+	return nil
+}
+
+// matches returns true if a point with a measurement name and tags is blocked.
+func (b *WriteBlock) matches(name string, tags map[string]string) bool {
+	v := name
+	if b.TagKey != "" {
+		var ok bool
+		if v, ok = tags[b.TagKey]; !ok {
+			return false
+		}
+	}
+
+	if b.re != nil {
+		return b.re.MatchString(v)
+	}
+	return v == b.Value
+}
+
+// WriteBlocks returns the write blocks of the cluster sorted by pattern.
+func (s *Server) WriteBlocks() []*WriteBlock {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a := make(writeBlocks, 0, len(s.writeBlocks))
+	for _, b := range s.writeBlocks {
+		other := *b
+		a = append(a, &other)
+	}
+	sort.Sort(a)
+	return a
+}
+
+// BlockWrites rejects writes of points matching a block on every data node.
+// Writes of blocked points return ErrWriteBlocked and don't create series.
+func (s *Server) BlockWrites(b *WriteBlock) error {
+	other := *b
+	if err := other.compile(); err != nil {
+		return err
+	}
+
+	c := &blockWritesCommand{Block: b}
+	_, err := s.broadcast(blockWritesMessageType, c)
+	return err
+}
+
+func (s *Server) applyBlockWrites(m *messaging.Message) error {
+	var c blockWritesCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	// Validate command.
+	b := c.Block
+	if b == nil {
+		return ErrInvalidWriteBlock
+	} else if err := b.compile(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := b.String()
+	if s.writeBlocks[key] != nil {
+		return ErrWriteBlockExists
+	}
+
+	// Persist to metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveWriteBlock(key, b)
+	})
+	s.writeBlocks[key] = b
+
+	return nil
+}
+
+type blockWritesCommand struct {
+	Block *WriteBlock `json:"block"`
+}
+
+// UnblockWrites removes a write block from the cluster. The block must match
+// an existing block exactly or ErrWriteBlockNotFound is returned.
+func (s *Server) UnblockWrites(b *WriteBlock) error {
+	c := &unblockWritesCommand{Block: b}
+	_, err := s.broadcast(unblockWritesMessageType, c)
+	return err
+}
+
+func (s *Server) applyUnblockWrites(m *messaging.Message) error {
+	var c unblockWritesCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	if c.Block == nil {
+		return ErrWriteBlockNotFound
+	}
+	key := c.Block.String()
+	if s.writeBlocks[key] == nil {
+		return ErrWriteBlockNotFound
+	}
+
+	// Remove from metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.deleteWriteBlock(key)
+	})
+	delete(s.writeBlocks, key)
+
+	return nil
+}
+
+type unblockWritesCommand struct {
+	Block *WriteBlock `json:"block"`
+}
+
+// writeBlocked returns true if any block matches a point.
+// This function must be called under a read lock.
+func (s *Server) writeBlocked(name string, tags map[string]string) bool {
+	for _, b := range s.writeBlocks {
+		if b.matches(name, tags) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) executeBlockStatement(q *influxql.BlockStatement, user *User) *Result {
+	return &Result{Err: s.BlockWrites(newWriteBlock(q.TagKey, q.Value))}
+}
+
+func (s *Server) executeUnblockStatement(q *influxql.UnblockStatement, user *User) *Result {
+	return &Result{Err: s.UnblockWrites(newWriteBlock(q.TagKey, q.Value))}
+}
+
+func (s *Server) executeListBlocksStatement(q *influxql.ListBlocksStatement, user *User) *Result {
+	row := &influxql.Row{Columns: []string{"pattern"}}
+	for _, b := range s.WriteBlocks() {
+		row.Values = append(row.Values, []interface{}{b.String()})
+	}
+	return &Result{Rows: []*influxql.Row{row}}
+}
+
+// writeBlocks represents a list of blocks sortable by pattern.
+type writeBlocks []*WriteBlock
+
+func (p writeBlocks) Len() int           { return len(p) }
+func (p writeBlocks) Less(i, j int) bool { return p[i].String() < p[j].String() }
+func (p writeBlocks) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,116 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// CompactBroadcastTopic removes the broadcast messages that every data node has
+// applied to its metastore from the broker. Data nodes that join afterwards
+// start from a copy of a metastore and data nodes that restart skip messages
+// already in their metastore, so neither replays the removed messages.
+// Returns the index of the last message removed, or zero if none were.
+func (s *Server) CompactBroadcastTopic() (uint64, error) {
+	s.mu.RLock()
+	index := s.metaIndex
+	var peers []*url.URL
+	for id, n := range s.dataNodes {
+		if id != s.id {
+			peers = append(peers, n.URL)
+		}
+	}
+	client, mc := s.peerClient(), s.client
+	s.mu.RUnlock()
+
+	if mc == nil {
+		return 0, ErrServerClosed
+	}
+
+	// Only remove messages that every data node has applied.
+	for _, u := range peers {
+		i, err := fetchMetastoreIndex(client, u)
+		if err != nil {
+			return 0, fmt.Errorf("metastore index: %s: %s", u, err)
+		}
+		if i < index {
+			index = i
+		}
+	}
+	if index == 0 {
+		return 0, nil
+	}
+
+	if err := mc.TruncateTopic(messaging.BroadcastTopicID, index); err != nil {
+		return 0, err
+	}
+	return index, nil
+}
+
+// StartBroadcastCompaction starts a background loop that compacts the
+// broadcast topic on every interval. Any previous loop is stopped.
+func (s *Server) StartBroadcastCompaction(interval time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.opened() {
+		return ErrServerClosed
+	} else if interval <= 0 {
+		return ErrInvalidBroadcastCompactionInterval
+	}
+
+	// Stop previous loop, if running.
+	if s.broadcastDone != nil {
+		close(s.broadcastDone)
+	}
+
+	done := make(chan struct{}, 0)
+	s.broadcastDone = done
+	go s.compactBroadcastTopic(s.clock.NewTicker(interval), done)
+
+	return nil
+}
+
+// compactBroadcastTopic runs in a separate goroutine and compacts the
+// broadcast topic on every tick until done is closed.
+func (s *Server) compactBroadcastTopic(ticker Ticker, done chan struct{}) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C():
+			if _, err := s.CompactBroadcastTopic(); err != nil {
+				s.Logger.Errorf("broadcast compaction: %s", err)
+			}
+		}
+	}
+}
+
+// fetchMetastoreIndex retrieves the index of the last broadcast message
+// applied to a peer's metastore.
+func fetchMetastoreIndex(client *http.Client, u *url.URL) (uint64, error) {
+	u = copyURL(u)
+	u.Path = "/metastore/index"
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var body struct {
+		Index uint64 `json:"index"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+	return body.Index, nil
+}
@@ -0,0 +1,225 @@
+package influxdb
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultCardinalityThresholds are the distinct tag value counts at which a
+// tag key is reported by the tag cardinality check.
+var DefaultCardinalityThresholds = []int{10000, 100000, 1000000}
+
+// CardinalityMeasurement is the measurement that cardinality alerts are
+// written to.
+const CardinalityMeasurement = "tag_cardinality"
+
+// CardinalityAlert reports a tag key whose number of distinct values has
+// passed a threshold.
+type CardinalityAlert struct {
+	Database    string `json:"database"`
+	Measurement string `json:"measurement"`
+	TagKey      string `json:"tagKey"`
+	N           int    `json:"n"`         // distinct values
+	Threshold   int    `json:"threshold"` // highest threshold passed
+}
+
+// cardinalityKey identifies a tag key within a database.
+type cardinalityKey struct {
+	database, measurement, tagKey string
+}
+
+// CheckTagCardinality returns an alert for every tag key whose number of
+// distinct values has passed a higher threshold than on any previous check.
+// Tag keys that fall back under a threshold are reported again if they pass it
+// later. Thresholds must be ascending.
+func (s *Server) CheckTagCardinality(thresholds []int) []*CardinalityAlert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var alerts []*CardinalityAlert
+	seen := make(map[cardinalityKey]struct{})
+	for _, db := range s.databases {
+		for _, m := range db.measurements {
+			for tagKey, values := range m.seriesByTagKeyValue {
+				k := cardinalityKey{db.name, m.Name, tagKey}
+
+				// Find the highest threshold passed.
+				n := len(values)
+				i := sort.SearchInts(thresholds, n+1)
+				if i == 0 {
+					continue
+				}
+				seen[k] = struct{}{}
+
+				// Only report each threshold once.
+				threshold := thresholds[i-1]
+				if s.cardinality[k] >= threshold {
+					continue
+				}
+				s.cardinality[k] = threshold
+
+				alerts = append(alerts, &CardinalityAlert{
+					Database:    db.name,
+					Measurement: m.Name,
+					TagKey:      tagKey,
+					N:           n,
+					Threshold:   threshold,
+				})
+			}
+		}
+	}
+
+	// Forget tag keys that are back under every threshold.
+	for k := range s.cardinality {
+		if _, ok := seen[k]; !ok {
+			delete(s.cardinality, k)
+		}
+	}
+
+	return alerts
+}
+
+// SetMaxValuesPerTag limits the number of distinct values of each tag key in
+// a measurement. Points that would create a series with a new value for a tag
+// key already at the limit are rejected with a MaxValuesPerTagError or, if
+// drop is set, written without the tags over the limit. A limit of zero
+// disables the check.
+func (s *Server) SetMaxValuesPerTag(n int, drop bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxValuesPerTag = n
+	s.dropTagsOverLimit = drop
+}
+
+// limitTagValues returns the tags of a point after checking them against the
+// tag value limit. Points for existing series are always allowed.
+// This function must be called under a read lock.
+func (s *Server) limitTagValues(database, name string, tags map[string]string) (map[string]string, error) {
+	if s.maxValuesPerTag <= 0 {
+		return tags, nil
+	}
+
+	// New measurements have no values yet.
+	db := s.databases[database]
+	if db == nil {
+		return tags, nil
+	}
+	m, series := db.MeasurementAndSeries(name, tags)
+	if m == nil || series != nil {
+		return tags, nil
+	}
+
+	var other map[string]string
+	for k, v := range tags {
+		values := m.seriesByTagKeyValue[k]
+		if _, ok := values[v]; ok || len(values) < s.maxValuesPerTag {
+			continue
+		} else if !s.dropTagsOverLimit {
+			return nil, &MaxValuesPerTagError{Measurement: name, TagKey: k, Limit: s.maxValuesPerTag}
+		}
+
+		// Copy the tags before dropping the first one.
+		if other == nil {
+			other = make(map[string]string, len(tags))
+			for k, v := range tags {
+				other[k] = v
+			}
+		}
+		delete(other, k)
+	}
+	if other != nil {
+		return other, nil
+	}
+	return tags, nil
+}
+
+// StartCardinalityChecks starts a background loop that checks tag cardinality
+// on every interval and logs a warning for every alert. Alerts are also
+// written to the default retention policy of database, if set. Any previous
+// loop is stopped.
+func (s *Server) StartCardinalityChecks(interval time.Duration, thresholds []int, database string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.opened() {
+		return ErrServerClosed
+	} else if interval <= 0 {
+		return ErrInvalidCardinalityCheckInterval
+	} else if !validCardinalityThresholds(thresholds) {
+		return ErrInvalidCardinalityThresholds
+	}
+
+	// Stop previous loop, if running.
+	if s.cardinalityDone != nil {
+		close(s.cardinalityDone)
+	}
+
+	thresholds = append([]int(nil), thresholds...)
+	done := make(chan struct{}, 0)
+	s.cardinalityDone = done
+	go s.checkTagCardinality(s.clock.NewTicker(interval), thresholds, database, done)
+
+	return nil
+}
+
+// checkTagCardinality runs in a separate goroutine and checks tag cardinality
+// on every tick until done is closed.
+func (s *Server) checkTagCardinality(ticker Ticker, thresholds []int, database string, done chan struct{}) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C():
+			alerts := s.CheckTagCardinality(thresholds)
+			for _, a := range alerts {
+				s.Logger.Warnf("tag cardinality: db=%s, measurement=%s, tag=%s, values=%d, threshold=%d",
+					a.Database, a.Measurement, a.TagKey, a.N, a.Threshold)
+			}
+			if database != "" && len(alerts) > 0 {
+				if err := s.writeCardinalityAlerts(database, alerts); err != nil {
+					s.Logger.Errorf("write cardinality alerts: %s", err)
+				}
+			}
+		}
+	}
+}
+
+// writeCardinalityAlerts writes alerts as points to the default retention
+// policy of a database.
+func (s *Server) writeCardinalityAlerts(database string, alerts []*CardinalityAlert) error {
+	now := s.clock.Now()
+	points := make([]Point, len(alerts))
+	for i, a := range alerts {
+		points[i] = Point{
+			Name: CardinalityMeasurement,
+			Tags: map[string]string{
+				"database":    a.Database,
+				"measurement": a.Measurement,
+				"tag_key":     a.TagKey,
+			},
+			Timestamp: now,
+			Values: map[string]interface{}{
+				"n":         float64(a.N),
+				"threshold": float64(a.Threshold),
+			},
+		}
+	}
+	_, err := s.WriteSeries(database, "", points)
+	return err
+}
+
+// validCardinalityThresholds returns true if thresholds are set, positive and
+// strictly ascending.
+func validCardinalityThresholds(thresholds []int) bool {
+	if len(thresholds) == 0 || thresholds[0] <= 0 {
+		return false
+	}
+	for i := 1; i < len(thresholds); i++ {
+		if thresholds[i] <= thresholds[i-1] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package influxdb
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock represents the source of time for the server's time-dependent
+// subsystems such as retention enforcement and continuous query scheduling.
+type Clock interface {
+	// Returns the current time.
+	Now() time.Time
+
+	// Returns a ticker that delivers the time every interval.
+	NewTicker(interval time.Duration) Ticker
+}
+
+// Ticker represents a channel of periodic ticks.
+type Ticker interface {
+	// The channel on which ticks are delivered.
+	C() <-chan time.Time
+
+	// Stops delivering ticks.
+	Stop()
+}
+
+// systemClock is the Clock backed by the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now().UTC() }
+
+func (systemClock) NewTicker(interval time.Duration) Ticker {
+	return &systemTicker{time.NewTicker(interval)}
+}
+
+// systemTicker wraps a time.Ticker.
+type systemTicker struct{ t *time.Ticker }
+
+func (t *systemTicker) C() <-chan time.Time { return t.t.C }
+func (t *systemTicker) Stop()               { t.t.Stop() }
+
+// MockClock represents a Clock whose time only moves when it is advanced.
+// It allows tests and simulations to fast-forward time deterministically.
+type MockClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*mockTicker
+}
+
+// NewMockClock returns a new instance of MockClock set to now.
+func NewMockClock(now time.Time) *MockClock {
+	return &MockClock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (c *MockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker returns a ticker that fires as the clock is advanced.
+func (c *MockClock) NewTicker(interval time.Duration) Ticker {
+	if interval <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &mockTicker{
+		clock:    c,
+		interval: interval,
+		next:     c.now.Add(interval),
+		c:        make(chan time.Time),
+		stopped:  make(chan struct{}),
+	}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Add advances the clock by d. Every tick that falls within the period is
+// delivered in time order and Add blocks until each one has been received,
+// unless its ticker is stopped.
+func (c *MockClock) Add(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		// Find the next tick due before the end of the period.
+		c.mu.Lock()
+		sort.Stable(mockTickers(c.tickers))
+		if len(c.tickers) == 0 || c.tickers[0].next.After(end) {
+			c.now = end
+			c.mu.Unlock()
+			return
+		}
+		t := c.tickers[0]
+		c.now = t.next
+		t.next = t.next.Add(t.interval)
+		now := c.now
+		c.mu.Unlock()
+
+		// Deliver the tick outside the lock so the receiver can read the time.
+		select {
+		case t.c <- now:
+		case <-t.stopped:
+		}
+	}
+}
+
+// mockTicker is a ticker driven by a MockClock.
+type mockTicker struct {
+	clock    *MockClock
+	interval time.Duration
+	next     time.Time
+	c        chan time.Time
+	stopped  chan struct{}
+}
+
+func (t *mockTicker) C() <-chan time.Time { return t.c }
+
+func (t *mockTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, other := range t.clock.tickers {
+		if other == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			close(t.stopped)
+			return
+		}
+	}
+}
+
+// mockTickers represents a list of tickers sorted by their next tick.
+type mockTickers []*mockTicker
+
+func (p mockTickers) Len() int           { return len(p) }
+func (p mockTickers) Less(i, j int) bool { return p[i].next.Before(p[j].next) }
+func (p mockTickers) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
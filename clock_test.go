@@ -0,0 +1,45 @@
+package influxdb_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure the mock clock delivers ticks in order as it is advanced.
+func TestMockClock_Add(t *testing.T) {
+	clock := influxdb.NewMockClock(mustParseTime("2000-01-01T00:00:00Z"))
+	t0 := clock.NewTicker(10 * time.Second)
+	t1 := clock.NewTicker(15 * time.Second)
+
+	// Record ticks from both tickers.
+	var ticks []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for len(ticks) < 5 {
+			select {
+			case now := <-t0.C():
+				ticks = append(ticks, "t0@"+now.Format("04:05"))
+			case now := <-t1.C():
+				ticks = append(ticks, "t1@"+now.Format("04:05"))
+			}
+		}
+	}()
+
+	clock.Add(30 * time.Second)
+	<-done
+	if exp := []string{"t0@00:10", "t1@00:15", "t0@00:20", "t0@00:30", "t1@00:30"}; !reflect.DeepEqual(exp, ticks) {
+		t.Fatalf("unexpected ticks: %v", ticks)
+	}
+	if now := clock.Now(); !now.Equal(mustParseTime("2000-01-01T00:00:30Z")) {
+		t.Fatalf("unexpected time: %s", now)
+	}
+
+	// Stopped tickers no longer receive ticks.
+	t0.Stop()
+	t1.Stop()
+	clock.Add(time.Minute)
+}
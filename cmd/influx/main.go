@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterh/liner"
+)
+
+// These variables are populated via the Go linker.
+var (
+	version string = "0.9"
+)
+
+// DefaultHost is the data node the shell connects to by default.
+const DefaultHost = "localhost:8086"
+
+func main() {
+	log.SetFlags(0)
+
+	// Parse command flags.
+	c := NewCommandLine()
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	fs.StringVar(&c.Host, "host", DefaultHost, "")
+	fs.StringVar(&c.Username, "username", "", "")
+	fs.StringVar(&c.Password, "password", "", "")
+	fs.BoolVar(&c.SSL, "ssl", false, "")
+	fs.StringVar(&c.Database, "database", "", "")
+	fs.StringVar(&c.Format, "format", c.Format, "")
+	execute := fs.String("execute", "", "")
+	showVersion := fs.Bool("version", false, "")
+	fs.Usage = printUsage
+	fs.Parse(os.Args[1:])
+
+	if *showVersion {
+		log.Printf("InfluxDB shell %s", version)
+		return
+	} else if err := validateFormat(c.Format); err != nil {
+		log.Fatalf("influx: %s", err)
+	}
+
+	if err := c.Connect(); err != nil {
+		log.Fatalf("influx: %s", err)
+	}
+
+	// Execute a single command if one was passed on the command line.
+	if *execute != "" {
+		if err := c.Execute(*execute); err != nil {
+			log.Fatalf("ERR: %s", err)
+		}
+		return
+	}
+
+	// Read commands from stdin without prompting when it isn't a terminal so
+	// that queries can be piped in from scripts.
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice == 0 {
+		if err := c.Run(os.Stdin); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(c.Stdout, "Connected to %s version %s\n", c.Host, c.ServerVersion)
+	fmt.Fprintf(c.Stdout, "InfluxDB shell %s\n", version)
+	if err := c.RunInteractive(); err != nil {
+		log.Fatalf("influx: %s", err)
+	}
+}
+
+func printUsage() {
+	log.Printf(`usage: influx [flags]
+
+influx is an interactive shell for querying a data node over its HTTP API.
+Queries are read from stdin without a prompt when it isn't a terminal.
+
+        -host <host:port>
+                          The data node to connect to. Defaults to %s.
+                          An http:// or https:// prefix sets the scheme.
+
+        -ssl
+                          Connect to the data node over HTTPS.
+
+        -username <name>
+                          The name of the user, if authentication is enabled.
+
+        -password <password>
+                          The password of the user.
+
+        -database <name>
+                          The database to query. It can be changed with USE.
+
+        -format <column|csv|json>
+                          The output format. Defaults to column.
+
+        -execute <query>
+                          Execute a single command and exit.
+
+        -version
+                          Print the shell version and exit.
+`, DefaultHost)
+}
+
+// CommandLine represents a shell connected to a data node.
+type CommandLine struct {
+	Host     string
+	Username string
+	Password string
+	Database string
+
+	// If true, connect over HTTPS unless Host sets the scheme.
+	SSL bool
+
+	// Output format: "column", "csv" or "json".
+	Format string
+
+	// If true, the time taken by each query is printed after its results.
+	Timing bool
+
+	// Version reported by the data node when connecting.
+	ServerVersion string
+
+	Client *http.Client
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// NewCommandLine returns a new instance of CommandLine with default settings.
+func NewCommandLine() *CommandLine {
+	return &CommandLine{
+		Host:   DefaultHost,
+		Format: "column",
+		Client: http.DefaultClient,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+}
+
+// Connect pings the data node and records its version.
+func (c *CommandLine) Connect() error {
+	resp, err := c.get("/ping", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unable to connect to %s: %s", c.Host, resp.Status)
+	}
+	c.ServerVersion = resp.Header.Get("X-Influxdb-Version")
+	return nil
+}
+
+// Run executes each line read from r as a command until r is exhausted or
+// the shell is exited. Errors are printed and the remaining commands still
+// run. Returns the first error from any command.
+func (c *CommandLine) Run(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	return c.run(func() (string, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		return scanner.Text(), nil
+	})
+}
+
+// RunInteractive prompts for commands on the terminal, with line editing and
+// history, until the input ends or the shell is exited. History is kept in
+// the home directory between sessions.
+func (c *CommandLine) RunInteractive() error {
+	l := liner.NewLiner()
+	defer l.Close()
+	l.SetCtrlCAborts(true)
+
+	// Load the history of previous sessions and save it on exit.
+	if f, err := os.Open(historyPath()); err == nil {
+		l.ReadHistory(f)
+		f.Close()
+	}
+	defer func() {
+		if f, err := os.Create(historyPath()); err == nil {
+			l.WriteHistory(f)
+			f.Close()
+		}
+	}()
+
+	return c.run(func() (string, error) {
+		for {
+			line, err := l.Prompt("> ")
+			if err == liner.ErrPromptAborted {
+				continue
+			} else if err == io.EOF {
+				fmt.Fprintln(c.Stdout)
+				return "", io.EOF
+			} else if err != nil {
+				return "", err
+			}
+			if strings.TrimSpace(line) != "" {
+				l.AppendHistory(line)
+			}
+			return line, nil
+		}
+	})
+}
+
+// historyPath returns the file that stores the history of interactive sessions.
+func historyPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".influx_history")
+}
+
+// run executes each line returned by readLine as a command until it returns
+// io.EOF or the shell is exited. Returns the first error from any command or
+// any other error from readLine.
+func (c *CommandLine) run(readLine func() (string, error)) error {
+	var first error
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			return first
+		} else if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		} else if isExit(line) {
+			return first
+		}
+		if err := c.Execute(line); err != nil {
+			fmt.Fprintf(c.Stderr, "ERR: %s\n", err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+}
+
+// isExit returns true if the line exits the shell.
+func isExit(line string) bool {
+	switch strings.ToLower(line) {
+	case "exit", "quit", `\q`:
+		return true
+	}
+	return false
+}
+
+// Execute runs a single shell command or query.
+func (c *CommandLine) Execute(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	switch strings.ToLower(fields[0]) {
+	case "use":
+		if len(fields) != 2 {
+			return errors.New("usage: use <database>")
+		}
+		c.Database = strings.Trim(fields[1], `"`)
+		fmt.Fprintf(c.Stdout, "Using database %s\n", c.Database)
+		return nil
+	case `\timing`:
+		c.Timing = !c.Timing
+		if c.Timing {
+			fmt.Fprintln(c.Stdout, "Timing is on")
+		} else {
+			fmt.Fprintln(c.Stdout, "Timing is off")
+		}
+		return nil
+	case `\format`:
+		if len(fields) != 2 {
+			return errors.New(`usage: \format <column|csv|json>`)
+		} else if err := validateFormat(fields[1]); err != nil {
+			return err
+		}
+		c.Format = fields[1]
+		fmt.Fprintf(c.Stdout, "Using format %s\n", c.Format)
+		return nil
+	case "help", `\?`:
+		fmt.Fprint(c.Stdout, helpText)
+		return nil
+	}
+
+	start := time.Now()
+	if err := c.Query(line); err != nil {
+		return err
+	}
+	if c.Timing {
+		fmt.Fprintf(c.Stdout, "Query took %s\n", time.Since(start))
+	}
+	return nil
+}
+
+const helpText = `Commands:
+        use <database>            Set the database for queries.
+        \format <column|csv|json> Set the output format.
+        \timing                   Toggle printing the time taken by queries.
+        exit, quit, \q            Exit the shell.
+
+Any other input is sent to the data node as a query.
+`
+
+// validateFormat returns an error if format isn't a known output format.
+func validateFormat(format string) error {
+	switch format {
+	case "column", "csv", "json":
+		return nil
+	}
+	return fmt.Errorf("unknown format: %q", format)
+}
+
+// Query sends a query to the data node and writes its results to stdout in
+// the current format. Returns the first statement error, if any.
+func (c *CommandLine) Query(q string) error {
+	params := url.Values{"q": {q}}
+	if c.Database != "" {
+		params.Set("db", c.Database)
+	}
+	if c.Format == "csv" {
+		params.Set("format", "csv")
+	}
+
+	resp, err := c.get("/query", params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// CSV is streamed by the data node with errors written as records.
+	if c.Format == "csv" {
+		if resp.StatusCode != http.StatusOK {
+			return decodeError(resp)
+		}
+		_, err := io.Copy(c.Stdout, resp.Body)
+		return err
+	}
+
+	// Statement errors are returned with the results. Any other failure
+	// only returns an error.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusInternalServerError {
+		return decodeError(resp)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var results []*result
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&results); err != nil {
+		return fmt.Errorf("unable to decode results: %s", err)
+	}
+
+	if c.Format == "json" {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, b, "", "    "); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.Stdout, strings.TrimSpace(buf.String()))
+	} else {
+		writeColumns(c.Stdout, results)
+	}
+
+	for _, r := range results {
+		if r.Err != "" {
+			return errors.New(r.Err)
+		}
+	}
+	return nil
+}
+
+// get sends a GET request for a path on the data node. Credentials are sent
+// with Basic Authentication so they aren't logged with the URL.
+func (c *CommandLine) get(path string, params url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.url(path, params), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	return c.Client.Do(req)
+}
+
+// url returns the URL of a path on the data node. The scheme is taken from
+// the host if it has one, otherwise it's https if SSL is set.
+func (c *CommandLine) url(path string, params url.Values) string {
+	u := &url.URL{Scheme: "http", Host: c.Host, Path: path, RawQuery: params.Encode()}
+	if i := strings.Index(c.Host, "://"); i != -1 {
+		u.Scheme, u.Host = c.Host[:i], c.Host[i+len("://"):]
+	} else if c.SSL {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+// decodeError returns the error from a response that failed.
+func decodeError(resp *http.Response) error {
+	var body struct {
+		Err string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Err == "" {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return errors.New(body.Err)
+}
+
+// result is the JSON encoding of a statement's result.
+type result struct {
+	Rows []*row `json:"rows"`
+	Err  string `json:"error"`
+}
+
+// row is the JSON encoding of a row in a result.
+type row struct {
+	Name    string            `json:"name"`
+	Tags    map[string]string `json:"tags"`
+	Columns []string          `json:"columns"`
+	Values  [][]interface{}   `json:"values"`
+}
+
+// writeColumns writes each row as a table with a heading for its name and
+// tags. Rows are separated by a blank line.
+func writeColumns(w io.Writer, results []*result) {
+	var n int
+	for _, r := range results {
+		for _, row := range r.Rows {
+			if n > 0 {
+				fmt.Fprintln(w)
+			}
+			n++
+
+			if row.Name != "" {
+				fmt.Fprintf(w, "name: %s\n", row.Name)
+			}
+			if len(row.Tags) > 0 {
+				keys := make([]string, 0, len(row.Tags))
+				for k := range row.Tags {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				tags := make([]string, len(keys))
+				for i, k := range keys {
+					tags[i] = k + "=" + row.Tags[k]
+				}
+				fmt.Fprintf(w, "tags: %s\n", strings.Join(tags, ", "))
+			}
+
+			tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+			dashes := make([]string, len(row.Columns))
+			for i, col := range row.Columns {
+				dashes[i] = strings.Repeat("-", len(col))
+			}
+			fmt.Fprintln(tw, strings.Join(row.Columns, "\t"))
+			fmt.Fprintln(tw, strings.Join(dashes, "\t"))
+			for _, values := range row.Values {
+				a := make([]string, len(values))
+				for i, v := range values {
+					if v != nil {
+						a[i] = fmt.Sprint(v)
+					}
+				}
+				fmt.Fprintln(tw, strings.Join(a, "\t"))
+			}
+			tw.Flush()
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	main "github.com/influxdb/influxdb/cmd/influx"
+)
+
+// Ensure the shell connects and records the server version.
+func TestCommandLine_Connect(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ping" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+		w.Header().Set("X-Influxdb-Version", "0.9.1")
+	}))
+	defer s.Close()
+
+	c := NewCommandLine(s.URL)
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	} else if c.ServerVersion != "0.9.1" {
+		t.Fatalf("unexpected version: %s", c.ServerVersion)
+	}
+}
+
+// Ensure the shell connects over HTTPS if SSL is set or the host has a scheme.
+func TestCommandLine_Connect_SSL(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Influxdb-Version", "0.9.1")
+	}))
+	defer s.Close()
+
+	c := NewCommandLine(s.URL)
+	c.Client = s.Client()
+	if err := c.Connect(); err == nil {
+		t.Fatal("expected error connecting over http")
+	}
+
+	c.SSL = true
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.SSL, c.Host = false, s.URL
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	} else if c.ServerVersion != "0.9.1" {
+		t.Fatalf("unexpected version: %s", c.ServerVersion)
+	}
+}
+
+// Ensure query results are printed as tables with a heading for each row.
+func TestCommandLine_Execute_Column(t *testing.T) {
+	var params url.Values
+	var username, password string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params = r.URL.Query()
+		username, password, _ = r.BasicAuth()
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`[{"rows":[{"name":"cpu","tags":{"region":"us","host":"a"},"columns":["time","value"],"values":[[0,1.5],[1000000,100]]},{"name":"mem","columns":["time","free"],"values":[[0,null]]}]},{"error":"field not found: bar.value"}]`))
+	}))
+	defer s.Close()
+
+	c := NewCommandLine(s.URL)
+	c.Username, c.Password = "susy", "pass"
+	if err := c.Execute(`USE "foo"`); err != nil {
+		t.Fatal(err)
+	}
+	c.Stdout.(*bytes.Buffer).Reset()
+
+	if err := c.Execute("SELECT value FROM cpu; SELECT value FROM bar"); err == nil || err.Error() != "field not found: bar.value" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if params.Get("q") != "SELECT value FROM cpu; SELECT value FROM bar" || params.Get("db") != "foo" || params.Get("u") != "" {
+		t.Fatalf("unexpected params: %v", params)
+	} else if username != "susy" || password != "pass" {
+		t.Fatalf("unexpected credentials: %s, %s", username, password)
+	} else if s := c.Stdout.(*bytes.Buffer).String(); s != `name: cpu
+tags: host=a, region=us
+time     value
+----     -----
+0        1.5
+1000000  100
+
+name: mem
+time  free
+----  ----
+0     
+` {
+		t.Fatalf("unexpected output: %q", s)
+	}
+}
+
+// Ensure the output format can be switched.
+func TestCommandLine_Execute_Format(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") == "csv" {
+			w.Write([]byte("name,time,sum\ncpu,0,2\n"))
+			return
+		}
+		w.Write([]byte(`[{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,2]]}]}]`))
+	}))
+	defer s.Close()
+
+	c := NewCommandLine(s.URL)
+	for _, tt := range []struct {
+		format string
+		exp    string
+	}{
+		{format: "csv", exp: "name,time,sum\ncpu,0,2\n"},
+		{format: "json", exp: "[\n    {\n        \"rows\": [\n            {\n                \"name\": \"cpu\",\n                \"columns\": [\n                    \"time\",\n                    \"sum\"\n                ],\n                \"values\": [\n                    [\n                        0,\n                        2\n                    ]\n                ]\n            }\n        ]\n    }\n]\n"},
+	} {
+		if err := c.Execute(`\format ` + tt.format); err != nil {
+			t.Fatal(err)
+		}
+		c.Stdout.(*bytes.Buffer).Reset()
+		if err := c.Execute("SELECT sum(value) FROM cpu"); err != nil {
+			t.Fatal(err)
+		} else if s := c.Stdout.(*bytes.Buffer).String(); s != tt.exp {
+			t.Fatalf("%s: unexpected output: %q", tt.format, s)
+		}
+	}
+
+	if err := c.Execute(`\format xml`); err == nil || err.Error() != `unknown format: "xml"` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure blank commands are ignored without querying the data node.
+func TestCommandLine_Execute_Blank(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("unexpected request: %s", r.URL)
+	}))
+	defer s.Close()
+
+	c := NewCommandLine(s.URL)
+	for _, line := range []string{"", " ", "\t\n"} {
+		if err := c.Execute(line); err != nil {
+			t.Fatalf("%q: unexpected error: %v", line, err)
+		}
+	}
+}
+
+// Ensure commands are read until exit and errors don't stop later commands.
+func TestCommandLine_Run(t *testing.T) {
+	var queries []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("q"))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"error parsing query"}`))
+	}))
+	defer s.Close()
+
+	c := NewCommandLine(s.URL)
+	err := c.Run(strings.NewReader("\\timing\nSELEC 1\n\nuse\nSHOW DATABASES\nexit\nSHOW USERS\n"))
+	if err == nil || err.Error() != "error parsing query" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if strings.Join(queries, ";") != "SELEC 1;SHOW DATABASES" {
+		t.Fatalf("unexpected queries: %v", queries)
+	} else if s := c.Stdout.(*bytes.Buffer).String(); s != "Timing is on\n" {
+		t.Fatalf("unexpected output: %q", s)
+	} else if s := c.Stderr.(*bytes.Buffer).String(); s != "ERR: error parsing query\nERR: usage: use <database>\nERR: error parsing query\n" {
+		t.Fatalf("unexpected errors: %q", s)
+	}
+}
+
+// NewCommandLine returns a shell connected to a test server that writes to buffers.
+func NewCommandLine(rawurl string) *main.CommandLine {
+	u, _ := url.Parse(rawurl)
+	c := main.NewCommandLine()
+	c.Host = u.Host
+	c.Stdout, c.Stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	return c
+}
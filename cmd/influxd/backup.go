@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/influxdb/influxdb"
+)
+
+// execBackup runs the "backup" command.
+// Downloads a snapshot of a data node's metastore and shards to an archive.
+func execBackup(args []string) {
+	// Parse command flags.
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		host     = fs.String("host", fmt.Sprintf("localhost:%d", DefaultDataPort), "")
+		username = fs.String("username", "", "")
+		password = fs.String("password", "", "")
+	)
+	fs.Usage = printBackupUsage
+	fs.Parse(args)
+
+	// Ensure there's exactly one archive path.
+	if fs.NArg() != 1 {
+		printBackupUsage()
+		os.Exit(1)
+	}
+	path := fs.Arg(0)
+
+	// Build the backup URL.
+	u := &url.URL{Scheme: "http", Host: *host, Path: "/backup"}
+	if *username != "" {
+		u.RawQuery = url.Values{"u": {*username}, "p": {*password}}.Encode()
+	}
+
+	// Request the snapshot from the data node.
+	resp, err := http.Get(u.String())
+	if err != nil {
+		log.Fatalf("backup: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("backup: unexpected status: %s", resp.Status)
+	}
+
+	// Write the archive to a new file.
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatalf("backup: %s", err)
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		log.Fatalf("backup: %s", err)
+	}
+	log.Printf("backup written to %s (%d bytes)", path, n)
+}
+
+func printBackupUsage() {
+	log.Printf(`usage: backup [flags] PATH
+
+backup downloads a consistent snapshot of a running data node's metastore and
+all of the shards stored on the node to a new archive at PATH.
+
+        -host <host:port>
+                          The data node to back up. Defaults to localhost:%d.
+
+        -username <name>
+                          The name of an admin user, if authentication is enabled.
+
+        -password <password>
+                          The password of the admin user.
+`, DefaultDataPort)
+}
+
+// execRestore runs the "restore" command.
+// Rebuilds a data directory from an archive written by the backup command.
+func execRestore(args []string) {
+	// Parse command flags.
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		configPath = fs.String("config", "", "")
+		dataDir    = fs.String("data-dir", "", "")
+	)
+	fs.Usage = printRestoreUsage
+	fs.Parse(args)
+
+	// Ensure there's exactly one archive path.
+	if fs.NArg() != 1 {
+		printRestoreUsage()
+		os.Exit(1)
+	}
+	path := fs.Arg(0)
+
+	// Use the data directory from the configuration unless overridden.
+	dir := *dataDir
+	if dir == "" {
+		dir = parseConfig(*configPath, "").Data.Dir
+	}
+
+	// Open the archive and restore it into the data directory.
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("restore: %s", err)
+	}
+	defer f.Close()
+
+	if err := influxdb.Restore(f, dir); err != nil {
+		log.Fatalf("restore: %s", err)
+	}
+	log.Printf("restored %s to %s", path, dir)
+}
+
+func printRestoreUsage() {
+	log.Printf(`usage: restore [flags] PATH
+
+restore rebuilds a data node's data directory from an archive written by the
+backup command. The data node must be stopped and its data directory must not
+contain a metastore.
+
+        -config <path>
+                          Read the data directory from the configuration file.
+
+        -data-dir <path>
+                          Restore into this directory instead of the
+                          configured data directory.
+`)
+}
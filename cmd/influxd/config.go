@@ -1,7 +1,12 @@
 package main
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/url"
 	"os"
@@ -12,8 +17,12 @@ import (
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/influxdb/influxdb"
 	"github.com/influxdb/influxdb/collectd"
 	"github.com/influxdb/influxdb/graphite"
+	"github.com/influxdb/influxdb/messaging"
+	"github.com/influxdb/influxdb/opentsdb"
+	"github.com/influxdb/influxdb/statsd"
 )
 
 const (
@@ -35,6 +44,17 @@ const (
 
 	// DefaultDataPort represents the default port the data server runs on.
 	DefaultDataPort = 8086
+
+	// BrokerTransport distributes messages through a raft-backed broker.
+	BrokerTransport = "broker"
+
+	// LocalTransport delivers messages in-process for a single-node server
+	// without running a broker.
+	LocalTransport = "local"
+
+	// MinTagMaxLength is the smallest maximum tag length that leaves room for
+	// part of the original string alongside its hash.
+	MinTagMaxLength = 16
 )
 
 // Config represents the configuration format for the influxd binary.
@@ -47,6 +67,25 @@ type Config struct {
 
 	Authentication struct {
 		Enabled bool `toml:"enabled"`
+
+		// Rejects authenticated requests that are not made over HTTPS.
+		HTTPSRequired bool `toml:"https-required"`
+
+		// Cost of the bcrypt hashes of user passwords. Zero uses the default.
+		BcryptCost int `toml:"bcrypt-cost"`
+
+		// Rules for passwords of new users and changed passwords. Blank
+		// passwords are always rejected.
+		PasswordMinLength      int  `toml:"password-min-length"`
+		PasswordRejectUsername bool `toml:"password-reject-username"`
+
+		// Admin user created when the cluster is first initialized.
+		AdminUsername string `toml:"admin-username"`
+		AdminPassword string `toml:"admin-password"`
+
+		// Secret sent between data nodes to authenticate requests for
+		// shards, repairs and the metastore. Must match on every node.
+		ClusterSecret string `toml:"cluster-secret"`
 	} `toml:"authentication"`
 
 	Admin struct {
@@ -58,11 +97,50 @@ type Config struct {
 		Port        int      `toml:"port"`
 		SSLPort     int      `toml:"ssl-port"`
 		SSLCertPath string   `toml:"ssl-cert"`
+		SSLKeyPath  string   `toml:"ssl-key"` // defaults to the cert file
 		ReadTimeout Duration `toml:"read-timeout"`
+
+		// HTTP/2 is negotiated with clients on the SSL port unless disabled.
+		HTTP2Disabled bool `toml:"http2-disabled"`
 	} `toml:"api"`
 
-	Graphites []Graphite `toml:"graphite"`
-	Collectd  Collectd   `toml:"collectd"`
+	// Rules applied to the tags of incoming points before series are created.
+	TagNormalization struct {
+		Lowercase bool `toml:"lowercase"`
+		TrimSpace bool `toml:"trim-space"`
+		MaxLength int  `toml:"max-length"`
+	} `toml:"tag-normalization"`
+
+	// Periodic check for tag keys with too many distinct values. Alerts are
+	// logged and, if a database is set, written to its default retention
+	// policy. A zero period disables the check.
+	//
+	// Writes that would add a value to a tag key already at MaxValuesPerTag
+	// are rejected, or written without that tag if DropTagsOverLimit is set.
+	// A zero limit disables it.
+	TagCardinality struct {
+		CheckPeriod       Duration `toml:"check-period"`
+		Thresholds        []int    `toml:"thresholds"`
+		Database          string   `toml:"database"`
+		MaxValuesPerTag   int      `toml:"max-values-per-tag"`
+		DropTagsOverLimit bool     `toml:"drop-tags-over-limit"`
+	} `toml:"tag-cardinality"`
+
+	Graphites   []Graphite   `toml:"graphite"`
+	Collectd    Collectd     `toml:"collectd"`
+	OpenTSDB    OpenTSDB     `toml:"opentsdb"`
+	Statsd      Statsd       `toml:"statsd"`
+	Downsamples []Downsample `toml:"downsample"`
+
+	// Databases whose queries read from downsampled retention policies when
+	// their GROUP BY interval is coarser than a downsample policy's interval
+	// and the rollup covers their time range.
+	DownsampleQueries struct {
+		Databases []string `toml:"databases"`
+	} `toml:"downsample-queries"`
+
+	// Keys used to encrypt the shards of a database at rest.
+	ShardEncryption []ShardEncryption `toml:"shard-encryption"`
 
 	InputPlugins struct {
 		UDPInput struct {
@@ -81,17 +159,75 @@ type Config struct {
 		Port    int      `toml:"port"`
 		Dir     string   `toml:"dir"`
 		Timeout Duration `toml:"election-timeout"`
+
+		// Requests beyond this many concurrent requests are rejected.
+		// Message and raft streams are not counted. Zero is unlimited.
+		MaxConcurrentRequests int `toml:"max-concurrent-requests"`
+
+		// The messaging transport used by the data node: "broker" or "local".
+		Transport string `toml:"transport"`
+
+		// The broker port only accepts HTTPS when a certificate is set.
+		SSLCertPath string `toml:"ssl-cert"`
+		SSLKeyPath  string `toml:"ssl-key"` // defaults to the cert file
+
+		// Messages for a disconnected data node are held up to this size and
+		// age and handed off when it reconnects.
+		MaxHintSize Size     `toml:"max-hint-size"`
+		MaxHintAge  Duration `toml:"max-hint-age"`
 	} `toml:"broker"`
 
 	Data struct {
-		Dir                  string                    `toml:"dir"`
-		Port                 int                       `toml:"port"`
-		WriteBufferSize      int                       `toml:"write-buffer-size"`
-		MaxOpenShards        int                       `toml:"max-open-shards"`
-		PointBatchSize       int                       `toml:"point-batch-size"`
-		WriteBatchSize       int                       `toml:"write-batch-size"`
-		Engines              map[string]toml.Primitive `toml:"engines"`
-		RetentionSweepPeriod Duration                  `toml:"retention-sweep-period"`
+		Dir                   string                    `toml:"dir"`
+		Port                  int                       `toml:"port"`
+		WriteBufferSize       int                       `toml:"write-buffer-size"`
+		MaxOpenShards         int                       `toml:"max-open-shards"`
+		PointBatchSize        int                       `toml:"point-batch-size"`
+		WriteBatchSize        int                       `toml:"write-batch-size"`
+		Engines               map[string]toml.Primitive `toml:"engines"`
+		RetentionSweepPeriod  Duration                  `toml:"retention-sweep-period"`
+		DropExpiredSeries     bool                      `toml:"drop-expired-series"`
+		ExpiredSeriesGrace    Duration                  `toml:"expired-series-grace-period"`
+		CompactionPeriod      Duration                  `toml:"compaction-period"`
+		ContinuousQueryPeriod Duration                  `toml:"continuous-query-period"`
+		MetastoreBackupDir    string                    `toml:"metastore-backup-dir"`
+		MetastoreBackupPeriod Duration                  `toml:"metastore-backup-period"`
+		MetastoreBackupCount  int                       `toml:"metastore-backup-count"`
+		PublishFlushInterval  Duration                  `toml:"publish-flush-interval"`
+		PublishMaxBatchSize   Size                      `toml:"publish-max-batch-size"`
+
+		// Requests beyond this many concurrent requests to the data node
+		// are rejected so a flood of clients cannot exhaust its memory and
+		// file descriptors. Zero is unlimited.
+		MaxConcurrentRequests int `toml:"max-concurrent-requests"`
+
+		// Joins the cluster as a standby that stores every shard but owns
+		// none and serves no queries until it is promoted.
+		Standby bool `toml:"standby"`
+
+		// Writes held per shard and sorted by timestamp before they are
+		// written. Held writes are logged, and the log is synced to disk
+		// after each write, so they are replayed on startup. Zero disables
+		// buffering.
+		ReorderBufferSize int `toml:"reorder-buffer-size"`
+
+		// Broadcast messages applied by every data node are removed from the
+		// broker once per period. Zero disables compaction.
+		BroadcastCompactionPeriod Duration `toml:"broadcast-compaction-period"`
+
+		// Writes sent with async=true are held in a durable queue of up to
+		// the maximum size and published in the background. Publishing is
+		// retried every interval while the broker is unavailable.
+		WriteQueueEnabled       bool     `toml:"write-queue-enabled"`
+		WriteQueueMaxSize       Size     `toml:"write-queue-max-size"`
+		WriteQueueRetryInterval Duration `toml:"write-queue-retry-interval"`
+
+		// Base64 encoded AES keys used to encrypt the metastore at rest.
+		// The key file is read instead of the key when set, which allows the
+		// key to be provisioned by an external key management service.
+		MetastoreKey          string   `toml:"metastore-key"`
+		MetastoreKeyFile      string   `toml:"metastore-key-file"`
+		MetastorePreviousKeys []string `toml:"metastore-previous-keys"`
 	} `toml:"data"`
 
 	Cluster struct {
@@ -104,12 +240,46 @@ type Config struct {
 		WriteBufferSize           int      `toml:"write-buffer-size"`
 		ConcurrentShardQueryLimit int      `toml:"concurrent-shard-query-limit"`
 		MaxResponseBufferSize     int      `toml:"max-response-buffer-size"`
+
+		// Select statements estimated to read more than this many shard,
+		// series and time bucket combinations are rejected. Zero is unlimited.
+		MaxQueryCost int64 `toml:"max-query-cost"`
+
+		// Select statements running longer than the timeout are cancelled.
+		// Statements beyond the concurrent limit wait in a queue of up to
+		// MaxQueuedQueries and are rejected once it is full. Zero is unlimited.
+		QueryTimeout         Duration `toml:"query-timeout"`
+		MaxConcurrentQueries int      `toml:"max-concurrent-queries"`
+		MaxQueuedQueries     int      `toml:"max-queued-queries"`
+
+		// Writes requesting a consistency level other than "any" fail if
+		// their replicas don't apply them within the timeout.
+		ConsistencyTimeout Duration `toml:"consistency-timeout"`
+
+		// Verification of HTTPS peers when joining a cluster. The CA file is
+		// a PEM bundle and pins are base64 encoded SHA-256 hashes of peer
+		// public keys. Skipping verification requires pins.
+		PeerCAFile             string   `toml:"peer-ca-file"`
+		PeerPins               []string `toml:"peer-pins"`
+		PeerInsecureSkipVerify bool     `toml:"peer-insecure-skip-verify"`
 	} `toml:"cluster"`
 
+	// Logging writes to standard error unless a file is set. The file may
+	// also be "stdout" or "stderr". A log file is rotated once it grows past
+	// max-size or is older than max-age; zero disables either limit.
 	Logging struct {
-		File  string `toml:"file"`
-		Level string `toml:"level"`
+		File    string   `toml:"file"`
+		Level   string   `toml:"level"`
+		Format  string   `toml:"format"`
+		MaxSize Size     `toml:"max-size"`
+		MaxAge  Duration `toml:"max-age"`
 	} `toml:"logging"`
+
+	Metrics struct {
+		// Upper bounds, in seconds, of the write, query and publish latency
+		// histogram buckets. Defaults to influxdb.DefaultLatencyBuckets.
+		LatencyBuckets []float64 `toml:"latency-buckets"`
+	} `toml:"metrics"`
 }
 
 // NewConfig returns an instance of Config with reasonable defaults.
@@ -118,10 +288,22 @@ func NewConfig() *Config {
 
 	c := &Config{}
 	c.Data.RetentionSweepPeriod = Duration(10 * time.Minute)
+	c.Data.ExpiredSeriesGrace = Duration(1 * time.Hour)
+	c.Data.CompactionPeriod = Duration(1 * time.Hour)
+	c.Data.ContinuousQueryPeriod = Duration(1 * time.Second)
+	c.Data.MetastoreBackupPeriod = Duration(1 * time.Hour)
+	c.Data.MetastoreBackupCount = 24
+	c.Data.WriteQueueRetryInterval = Duration(1 * time.Second)
+	c.TagCardinality.CheckPeriod = Duration(10 * time.Minute)
+	c.TagCardinality.Thresholds = append([]int(nil), influxdb.DefaultCardinalityThresholds...)
+	c.Statsd.FlushInterval = Duration(statsd.DefaultFlushInterval)
 	c.Cluster.ConcurrentShardQueryLimit = DefaultConcurrentShardQueryLimit
 	c.Broker.Dir = filepath.Join(u.HomeDir, ".influxdb/broker")
 	c.Broker.Port = DefaultBrokerPort
 	c.Broker.Timeout = Duration(1 * time.Second)
+	c.Broker.Transport = BrokerTransport
+	c.Broker.MaxHintSize = messaging.DefaultMaxHintSize
+	c.Broker.MaxHintAge = Duration(messaging.DefaultMaxHintAge)
 	c.Cluster.MinBackoff = Duration(1 * time.Second)
 	c.Cluster.MaxBackoff = Duration(10 * time.Second)
 	c.Cluster.ProtobufHeartbeatInterval = Duration(10 * time.Millisecond)
@@ -130,6 +312,7 @@ func NewConfig() *Config {
 	c.Data.WriteBufferSize = 1000
 	c.Cluster.WriteBufferSize = 1000
 	c.Cluster.MaxResponseBufferSize = 100
+	c.Cluster.ConsistencyTimeout = Duration(influxdb.DefaultConsistencyTimeout)
 
 	// Detect hostname (or set to localhost).
 	if c.Hostname, _ = os.Hostname(); c.Hostname == "" {
@@ -171,15 +354,172 @@ func (c *Config) MaxOpenShards() int {
 	return c.Data.MaxOpenShards
 }
 
+// MetastoreKeys returns the decoded metastore encryption key and any previous
+// keys used for rotation. Returns a nil key if encryption is not configured.
+func (c *Config) MetastoreKeys() (key []byte, previousKeys [][]byte, err error) {
+	s := c.Data.MetastoreKey
+	if c.Data.MetastoreKeyFile != "" {
+		b, err := ioutil.ReadFile(c.Data.MetastoreKeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read metastore key file: %s", err)
+		}
+		s = string(b)
+	}
+
+	if s = strings.TrimSpace(s); s != "" {
+		if key, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, nil, fmt.Errorf("decode metastore key: %s", err)
+		}
+	}
+
+	for _, s := range c.Data.MetastorePreviousKeys {
+		k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, nil, fmt.Errorf("decode previous metastore key: %s", err)
+		}
+		previousKeys = append(previousKeys, k)
+	}
+	return
+}
+
+// ShardKeys returns the decoded shard encryption keys by database name.
+// A key is read from its key file or, if not set, from its environment variable.
+func (c *Config) ShardKeys() (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, e := range c.ShardEncryption {
+		if e.Database == "" {
+			return nil, fmt.Errorf("shard encryption database required")
+		}
+
+		var s string
+		if e.KeyFile != "" {
+			b, err := ioutil.ReadFile(e.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("read shard key file: %s", err)
+			}
+			s = string(b)
+		} else if e.KeyEnv != "" {
+			s = os.Getenv(e.KeyEnv)
+		}
+
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return nil, fmt.Errorf("shard key not set: %s", e.Database)
+		}
+		key, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("decode shard key: %s: %s", e.Database, err)
+		}
+		keys[e.Database] = key
+	}
+	return keys, nil
+}
+
+// PeerTLSConfig returns the configuration used to verify HTTPS peers.
+// Returns an error if chain verification is skipped without pins since any
+// peer would then be trusted.
+func (c *Config) PeerTLSConfig() (*influxdb.PeerTLSConfig, error) {
+	config := &influxdb.PeerTLSConfig{
+		Pins:               c.Cluster.PeerPins,
+		InsecureSkipVerify: c.Cluster.PeerInsecureSkipVerify,
+	}
+	if config.InsecureSkipVerify && len(config.Pins) == 0 {
+		return nil, fmt.Errorf("peer-insecure-skip-verify requires peer-pins")
+	}
+
+	if c.Cluster.PeerCAFile != "" {
+		b, err := ioutil.ReadFile(c.Cluster.PeerCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read peer ca file: %s", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in peer ca file: %s", c.Cluster.PeerCAFile)
+		}
+	}
+
+	for _, pin := range config.Pins {
+		if b, err := base64.StdEncoding.DecodeString(pin); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid peer pin: %s", pin)
+		}
+	}
+
+	return config, nil
+}
+
+// TagNormalizer returns the rules used to normalize incoming tags.
+// Returns nil if no rules are enabled.
+func (c *Config) TagNormalizer() (*influxdb.TagNormalizer, error) {
+	n := c.TagNormalization
+	if n.MaxLength < 0 || (n.MaxLength > 0 && n.MaxLength < MinTagMaxLength) {
+		return nil, fmt.Errorf("tag max length must be zero or at least %d: %d", MinTagMaxLength, n.MaxLength)
+	} else if !n.Lowercase && !n.TrimSpace && n.MaxLength == 0 {
+		return nil, nil
+	}
+	return &influxdb.TagNormalizer{Lowercase: n.Lowercase, TrimSpace: n.TrimSpace, MaxLength: n.MaxLength}, nil
+}
+
+// Logger returns the root logger described by the logging section.
+// Log files are opened for appending and are never closed.
+func (c *Config) Logger() (*influxdb.Logger, error) {
+	level := influxdb.InfoLevel
+	if c.Logging.Level != "" {
+		l, err := influxdb.ParseLogLevel(c.Logging.Level)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level: %s", c.Logging.Level)
+		}
+		level = l
+	}
+
+	var json bool
+	switch strings.ToLower(c.Logging.Format) {
+	case "", "text":
+	case "json":
+		json = true
+	default:
+		return nil, fmt.Errorf("log format must be \"text\" or \"json\": %s", c.Logging.Format)
+	}
+
+	var w io.Writer
+	switch c.Logging.File {
+	case "", "stderr":
+		w = os.Stderr
+	case "stdout":
+		w = os.Stdout
+	default:
+		f := influxdb.NewRotatingFile(c.Logging.File, int64(c.Logging.MaxSize), time.Duration(c.Logging.MaxAge))
+		if err := f.Open(); err != nil {
+			return nil, fmt.Errorf("open log file: %s", err)
+		}
+		w = f
+	}
+
+	return influxdb.NewLogger(w, "", level, json), nil
+}
+
 // DataAddr returns the binding address the data server
 func (c *Config) DataAddr() string {
 	return net.JoinHostPort(c.BindAddress, strconv.Itoa(c.Data.Port))
 }
 
+// DataSSLAddr returns the binding address for the data server's SSL listener.
+// Returns an empty string if SSL is not configured.
+func (c *Config) DataSSLAddr() string {
+	if c.HTTPAPI.SSLPort == 0 || c.HTTPAPI.SSLCertPath == "" {
+		return ""
+	}
+	return net.JoinHostPort(c.BindAddress, strconv.Itoa(c.HTTPAPI.SSLPort))
+}
+
 // DataURL returns the URL required to contact the data server.
+// The data server shares the broker's scheme when they share a port.
 func (c *Config) DataURL() *url.URL {
+	scheme := "http"
+	if c.DataAddr() == c.BrokerAddr() {
+		scheme = c.BrokerURL().Scheme
+	}
 	return &url.URL{
-		Scheme: "http",
+		Scheme: scheme,
 		Host:   net.JoinHostPort(c.Hostname, strconv.Itoa(c.Data.Port)),
 	}
 }
@@ -191,8 +531,12 @@ func (c *Config) BrokerAddr() string {
 
 // BrokerURL returns the URL required to contact the Broker server.
 func (c *Config) BrokerURL() *url.URL {
+	scheme := "http"
+	if c.Broker.SSLCertPath != "" {
+		scheme = "https"
+	}
 	return &url.URL{
-		Scheme: "http",
+		Scheme: scheme,
 		Host:   net.JoinHostPort(c.Hostname, strconv.Itoa(c.Broker.Port)),
 	}
 }
@@ -272,9 +616,10 @@ type Collectd struct {
 	Addr string `toml:"address"`
 	Port uint16 `toml:"port"`
 
-	Database string `toml:"database"`
-	Enabled  bool   `toml:"enabled"`
-	TypesDB  string `toml:"typesdb"`
+	Database    string `toml:"database"`
+	Enabled     bool   `toml:"enabled"`
+	TypesDB     string `toml:"typesdb"`
+	FieldNaming string `toml:"field-naming"`
 }
 
 // ConnnectionString returns the connection string for this collectd config in the form host:port.
@@ -294,15 +639,69 @@ func (c *Collectd) ConnectionString(defaultBindAddr string) string {
 	return fmt.Sprintf("%s:%d", addr, port)
 }
 
+type OpenTSDB struct {
+	Addr string `toml:"address"`
+	Port uint16 `toml:"port"`
+
+	Database        string `toml:"database"`
+	RetentionPolicy string `toml:"retention-policy"`
+	Enabled         bool   `toml:"enabled"`
+}
+
+// ConnnectionString returns the connection string for this OpenTSDB config in the form host:port.
+func (o *OpenTSDB) ConnectionString(defaultBindAddr string) string {
+	addr := o.Addr
+	// If no address specified, use default.
+	if addr == "" {
+		addr = defaultBindAddr
+	}
+
+	port := o.Port
+	// If no port specified, use default.
+	if port == 0 {
+		port = opentsdb.DefaultPort
+	}
+
+	return fmt.Sprintf("%s:%d", addr, port)
+}
+
+type Statsd struct {
+	Addr string `toml:"address"`
+	Port uint16 `toml:"port"`
+
+	Database        string   `toml:"database"`
+	RetentionPolicy string   `toml:"retention-policy"`
+	Enabled         bool     `toml:"enabled"`
+	FlushInterval   Duration `toml:"flush-interval"`
+}
+
+// ConnnectionString returns the connection string for this StatsD config in the form host:port.
+func (c *Statsd) ConnectionString(defaultBindAddr string) string {
+	addr := c.Addr
+	// If no address specified, use default.
+	if addr == "" {
+		addr = defaultBindAddr
+	}
+
+	port := c.Port
+	// If no port specified, use default.
+	if port == 0 {
+		port = statsd.DefaultPort
+	}
+
+	return fmt.Sprintf("%s:%d", addr, port)
+}
+
 type Graphite struct {
 	Addr string `toml:"address"`
 	Port uint16 `toml:"port"`
 
-	Database      string `toml:"database"`
-	Enabled       bool   `toml:"enabled"`
-	Protocol      string `toml:"protocol"`
-	NamePosition  string `toml:"name-position"`
-	NameSeparator string `toml:"name-separator"`
+	Database      string   `toml:"database"`
+	Enabled       bool     `toml:"enabled"`
+	Protocol      string   `toml:"protocol"`
+	NamePosition  string   `toml:"name-position"`
+	NameSeparator string   `toml:"name-separator"`
+	Templates     []string `toml:"templates"`
 }
 
 // ConnnectionString returns the connection string for this Graphite config in the form host:port.
@@ -337,5 +736,23 @@ func (g *Graphite) LastEnabled() bool {
 	return g.NamePosition == strings.ToLower("last")
 }
 
+// Downsample represents a declarative rollup from one retention policy into another.
+type Downsample struct {
+	Database   string            `toml:"database"`
+	Name       string            `toml:"name"`
+	Source     string            `toml:"source"`
+	Target     string            `toml:"target"`
+	Interval   Duration          `toml:"interval"`
+	Aggregates map[string]string `toml:"aggregates"`
+}
+
+// ShardEncryption represents the key used to encrypt the shards of a database.
+// The key is base64 encoded and read from a file or an environment variable.
+type ShardEncryption struct {
+	Database string `toml:"database"`
+	KeyFile  string `toml:"key-file"`
+	KeyEnv   string `toml:"key-env"`
+}
+
 // maxInt is the largest integer representable by a word (architeture dependent).
 const maxInt = int64(^uint(0) >> 1)
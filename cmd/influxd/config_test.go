@@ -1,11 +1,13 @@
 package main_test
 
 import (
+	"os"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/influxdb/influxdb"
 	main "github.com/influxdb/influxdb/cmd/influxd"
 )
 
@@ -46,10 +48,32 @@ func TestParseConfig(t *testing.T) {
 		t.Fatalf("logging file mismatch: %v", c.Logging.File)
 	} else if c.Logging.Level != "info" {
 		t.Fatalf("logging level mismatch: %v", c.Logging.Level)
+	} else if c.Logging.Format != "json" {
+		t.Fatalf("logging format mismatch: %v", c.Logging.Format)
+	} else if c.Logging.MaxSize != main.Size(10*1024*1024) {
+		t.Fatalf("logging max size mismatch: %v", c.Logging.MaxSize)
+	} else if time.Duration(c.Logging.MaxAge) != 12*time.Hour {
+		t.Fatalf("logging max age mismatch: %v", c.Logging.MaxAge)
+	}
+
+	if !reflect.DeepEqual(c.Metrics.LatencyBuckets, []float64{0.01, 0.1, 1}) {
+		t.Fatalf("latency buckets mismatch: %v", c.Metrics.LatencyBuckets)
 	}
 
 	if !c.Authentication.Enabled {
 		t.Fatalf("authentication enabled mismatch: %v", c.Authentication.Enabled)
+	} else if !c.Authentication.HTTPSRequired {
+		t.Fatalf("authentication https required mismatch: %v", c.Authentication.HTTPSRequired)
+	} else if c.Authentication.BcryptCost != 12 {
+		t.Fatalf("authentication bcrypt cost mismatch: %v", c.Authentication.BcryptCost)
+	} else if c.Authentication.PasswordMinLength != 8 {
+		t.Fatalf("authentication password min length mismatch: %v", c.Authentication.PasswordMinLength)
+	} else if !c.Authentication.PasswordRejectUsername {
+		t.Fatalf("authentication password reject username mismatch: %v", c.Authentication.PasswordRejectUsername)
+	} else if c.Authentication.AdminUsername != "root" || c.Authentication.AdminPassword != "secret" {
+		t.Fatalf("authentication admin mismatch: %v, %v", c.Authentication.AdminUsername, c.Authentication.AdminPassword)
+	} else if c.Authentication.ClusterSecret != "s3cr3t" {
+		t.Fatalf("authentication cluster secret mismatch: %v", c.Authentication.ClusterSecret)
 	}
 
 	if c.Admin.Port != 8083 {
@@ -58,6 +82,16 @@ func TestParseConfig(t *testing.T) {
 		t.Fatalf("admin assets mismatch: %v", c.Admin.Assets)
 	}
 
+	if c.HTTPAPI.SSLPort != 8087 {
+		t.Fatalf("api ssl port mismatch: %v", c.HTTPAPI.SSLPort)
+	} else if c.HTTPAPI.SSLKeyPath != "../key.pem" {
+		t.Fatalf("api ssl key mismatch: %v", c.HTTPAPI.SSLKeyPath)
+	} else if !c.HTTPAPI.HTTP2Disabled {
+		t.Fatalf("api http2 disabled mismatch: %v", c.HTTPAPI.HTTP2Disabled)
+	} else if addr := c.DataSSLAddr(); addr != ":8087" {
+		t.Fatalf("data ssl addr mismatch: %v", addr)
+	}
+
 	if c.Data.Port != main.DefaultBrokerPort {
 		t.Fatalf("data port mismatch: %v", c.Data.Port)
 	}
@@ -82,6 +116,8 @@ func TestParseConfig(t *testing.T) {
 		t.Fatalf("graphite tcp name-position mismatch: expected %v, got %v", "last", tcpGraphite.NamePosition)
 	case tcpGraphite.NameSeparatorString() != "-":
 		t.Fatalf("graphite tcp name-separator mismatch: expected %v, got %v", "-", tcpGraphite.NameSeparatorString())
+	case !reflect.DeepEqual(tcpGraphite.Templates, []string{"servers.* .host.measurement*", "env.host.measurement.field"}):
+		t.Fatalf("graphite tcp templates mismatch: %v", tcpGraphite.Templates)
 	}
 
 	udpGraphite := c.Graphites[1]
@@ -109,6 +145,43 @@ func TestParseConfig(t *testing.T) {
 		t.Errorf("collectdabase mismatch: expected %v, got %v", "collectd_database", c.Collectd.Database)
 	case c.Collectd.TypesDB != "foo-db-type":
 		t.Errorf("collectd typesdb mismatch: expected %v, got %v", "foo-db-type", c.Collectd.TypesDB)
+	case c.Collectd.FieldNaming != "type_dsname":
+		t.Errorf("collectd field-naming mismatch: expected %v, got %v", "type_dsname", c.Collectd.FieldNaming)
+	}
+
+	switch {
+	case c.OpenTSDB.Enabled != true:
+		t.Errorf("opentsdb enabled mismatch: expected: %v, got %v", true, c.OpenTSDB.Enabled)
+	case c.OpenTSDB.ConnectionString("") != "192.168.0.4:4243":
+		t.Errorf("opentsdb connection string mismatch: expected %v, got %v", "192.168.0.4:4243", c.OpenTSDB.ConnectionString(""))
+	case c.OpenTSDB.Database != "opentsdb_database":
+		t.Errorf("opentsdb database mismatch: expected %v, got %v", "opentsdb_database", c.OpenTSDB.Database)
+	case c.OpenTSDB.RetentionPolicy != "raw":
+		t.Errorf("opentsdb retention policy mismatch: expected %v, got %v", "raw", c.OpenTSDB.RetentionPolicy)
+	}
+
+	switch {
+	case c.Statsd.Enabled != true:
+		t.Errorf("statsd enabled mismatch: expected: %v, got %v", true, c.Statsd.Enabled)
+	case c.Statsd.ConnectionString("127.0.0.1") != "127.0.0.1:8126":
+		t.Errorf("statsd connection string mismatch: expected %v, got %v", "127.0.0.1:8126", c.Statsd.ConnectionString("127.0.0.1"))
+	case c.Statsd.Database != "statsd_database":
+		t.Errorf("statsd database mismatch: expected %v, got %v", "statsd_database", c.Statsd.Database)
+	case time.Duration(c.Statsd.FlushInterval) != time.Minute:
+		t.Errorf("statsd flush interval mismatch: expected %v, got %v", time.Minute, c.Statsd.FlushInterval)
+	}
+
+	if len(c.Downsamples) != 1 {
+		t.Fatalf("downsamples mismatch: %v", len(c.Downsamples))
+	} else if d := c.Downsamples[0]; d.Database != "foo" || d.Name != "hourly" || d.Source != "raw" || d.Target != "archive" {
+		t.Fatalf("downsample mismatch: %#v", d)
+	} else if time.Duration(d.Interval) != time.Hour {
+		t.Fatalf("downsample interval mismatch: %v", d.Interval)
+	} else if !reflect.DeepEqual(d.Aggregates, map[string]string{"value": "mean", "*": "max"}) {
+		t.Fatalf("downsample aggregates mismatch: %v", d.Aggregates)
+	}
+	if !reflect.DeepEqual(c.DownsampleQueries.Databases, []string{"foo"}) {
+		t.Fatalf("downsample queries databases mismatch: %v", c.DownsampleQueries.Databases)
 	}
 
 	if c.Broker.Port != 8090 {
@@ -117,10 +190,90 @@ func TestParseConfig(t *testing.T) {
 		t.Fatalf("broker dir mismatch: %v", c.Broker.Dir)
 	} else if time.Duration(c.Broker.Timeout) != time.Second {
 		t.Fatalf("broker duration mismatch: %v", c.Broker.Timeout)
+	} else if c.Broker.MaxConcurrentRequests != 100 {
+		t.Fatalf("broker max concurrent requests mismatch: %v", c.Broker.MaxConcurrentRequests)
+	} else if c.Broker.Transport != "local" {
+		t.Fatalf("broker transport mismatch: %v", c.Broker.Transport)
+	} else if c.Broker.SSLCertPath != "../broker.pem" {
+		t.Fatalf("broker ssl cert mismatch: %v", c.Broker.SSLCertPath)
+	} else if u := c.BrokerURL(); u.Scheme != "https" {
+		t.Fatalf("broker url scheme mismatch: %v", u)
+	} else if c.Broker.MaxHintSize != 10*(1<<20) {
+		t.Fatalf("broker max hint size mismatch: %v", c.Broker.MaxHintSize)
+	} else if time.Duration(c.Broker.MaxHintAge) != time.Hour {
+		t.Fatalf("broker max hint age mismatch: %v", c.Broker.MaxHintAge)
 	}
 
 	if c.Data.Dir != "/tmp/influxdb/development/db" {
 		t.Fatalf("data dir mismatch: %v", c.Data.Dir)
+	} else if time.Duration(c.Data.PublishFlushInterval) != 500*time.Microsecond {
+		t.Fatalf("publish flush interval mismatch: %v", c.Data.PublishFlushInterval)
+	} else if c.Data.PublishMaxBatchSize != 2*(1<<20) {
+		t.Fatalf("publish max batch size mismatch: %v", c.Data.PublishMaxBatchSize)
+	} else if time.Duration(c.Data.CompactionPeriod) != 2*time.Hour {
+		t.Fatalf("compaction period mismatch: %v", c.Data.CompactionPeriod)
+	} else if time.Duration(c.Data.ContinuousQueryPeriod) != 5*time.Second {
+		t.Fatalf("continuous query period mismatch: %v", c.Data.ContinuousQueryPeriod)
+	} else if c.Data.MetastoreBackupDir != "/tmp/influxdb/development/meta-backups" {
+		t.Fatalf("metastore backup dir mismatch: %v", c.Data.MetastoreBackupDir)
+	} else if time.Duration(c.Data.MetastoreBackupPeriod) != 30*time.Minute {
+		t.Fatalf("metastore backup period mismatch: %v", c.Data.MetastoreBackupPeriod)
+	} else if c.Data.MetastoreBackupCount != 48 {
+		t.Fatalf("metastore backup count mismatch: %v", c.Data.MetastoreBackupCount)
+	} else if !c.Data.DropExpiredSeries {
+		t.Fatalf("drop expired series mismatch: %v", c.Data.DropExpiredSeries)
+	} else if time.Duration(c.Data.ExpiredSeriesGrace) != 30*time.Minute {
+		t.Fatalf("expired series grace period mismatch: %v", c.Data.ExpiredSeriesGrace)
+	} else if c.Data.MaxConcurrentRequests != 200 {
+		t.Fatalf("data max concurrent requests mismatch: %v", c.Data.MaxConcurrentRequests)
+	} else if c.Data.ReorderBufferSize != 64 {
+		t.Fatalf("reorder buffer size mismatch: %v", c.Data.ReorderBufferSize)
+	} else if !c.Data.WriteQueueEnabled {
+		t.Fatalf("write queue enabled mismatch: %v", c.Data.WriteQueueEnabled)
+	} else if c.Data.WriteQueueMaxSize != main.Size(100*1024*1024) {
+		t.Fatalf("write queue max size mismatch: %v", c.Data.WriteQueueMaxSize)
+	} else if time.Duration(c.Data.WriteQueueRetryInterval) != 5*time.Second {
+		t.Fatalf("write queue retry interval mismatch: %v", c.Data.WriteQueueRetryInterval)
+	}
+
+	if key, previousKeys, err := c.MetastoreKeys(); err != nil {
+		t.Fatalf("metastore keys: %s", err)
+	} else if string(key) != "0123456789abcdef" {
+		t.Fatalf("metastore key mismatch: %q", key)
+	} else if len(previousKeys) != 1 || string(previousKeys[0]) != "fedcba9876543210" {
+		t.Fatalf("previous metastore keys mismatch: %q", previousKeys)
+	}
+
+	os.Setenv("INFLUXDB_TEST_SHARD_KEY", "MDEyMzQ1Njc4OWFiY2RlZg==")
+	defer os.Unsetenv("INFLUXDB_TEST_SHARD_KEY")
+	if keys, err := c.ShardKeys(); err != nil {
+		t.Fatalf("shard keys: %s", err)
+	} else if len(keys) != 1 || string(keys["foo"]) != "0123456789abcdef" {
+		t.Fatalf("shard keys mismatch: %q", keys)
+	}
+
+	if peerTLS, err := c.PeerTLSConfig(); err != nil {
+		t.Fatalf("peer tls: %s", err)
+	} else if !reflect.DeepEqual(peerTLS.Pins, []string{"UNhY4JhezH9gQYqvDMWrWH9CwlcKiECVqejMrND2VFw="}) || !peerTLS.InsecureSkipVerify || peerTLS.RootCAs != nil {
+		t.Fatalf("peer tls mismatch: %#v", peerTLS)
+	}
+
+	if n, err := c.TagNormalizer(); err != nil {
+		t.Fatalf("tag normalizer: %s", err)
+	} else if !reflect.DeepEqual(n, &influxdb.TagNormalizer{Lowercase: true, TrimSpace: true, MaxLength: 64}) {
+		t.Fatalf("tag normalizer mismatch: %#v", n)
+	}
+
+	if time.Duration(c.TagCardinality.CheckPeriod) != 5*time.Minute {
+		t.Fatalf("tag cardinality check period mismatch: %v", c.TagCardinality.CheckPeriod)
+	} else if !reflect.DeepEqual(c.TagCardinality.Thresholds, []int{100, 1000}) {
+		t.Fatalf("tag cardinality thresholds mismatch: %v", c.TagCardinality.Thresholds)
+	} else if c.TagCardinality.Database != "_internal" {
+		t.Fatalf("tag cardinality database mismatch: %v", c.TagCardinality.Database)
+	} else if c.TagCardinality.MaxValuesPerTag != 5000 {
+		t.Fatalf("max values per tag mismatch: %v", c.TagCardinality.MaxValuesPerTag)
+	} else if !c.TagCardinality.DropTagsOverLimit {
+		t.Fatalf("drop tags over limit mismatch: %v", c.TagCardinality.DropTagsOverLimit)
 	}
 
 	if c.Cluster.ProtobufPort != 8099 {
@@ -135,6 +288,16 @@ func TestParseConfig(t *testing.T) {
 		t.Fatalf("max backoff mismatch: %v", c.Cluster.MaxBackoff)
 	} else if c.Cluster.MaxResponseBufferSize != 5 {
 		t.Fatalf("max response buffer size mismatch: %v", c.Cluster.MaxResponseBufferSize)
+	} else if c.Cluster.MaxQueryCost != 1000000 {
+		t.Fatalf("max query cost mismatch: %v", c.Cluster.MaxQueryCost)
+	} else if time.Duration(c.Cluster.QueryTimeout) != 30*time.Second {
+		t.Fatalf("query timeout mismatch: %v", c.Cluster.QueryTimeout)
+	} else if c.Cluster.MaxConcurrentQueries != 8 {
+		t.Fatalf("max concurrent queries mismatch: %v", c.Cluster.MaxConcurrentQueries)
+	} else if c.Cluster.MaxQueuedQueries != 16 {
+		t.Fatalf("max queued queries mismatch: %v", c.Cluster.MaxQueuedQueries)
+	} else if time.Duration(c.Cluster.ConsistencyTimeout) != 5*time.Second {
+		t.Fatalf("consistency timeout mismatch: %v", c.Cluster.ConsistencyTimeout)
 	}
 
 	// TODO: UDP Servers testing.
@@ -158,11 +321,24 @@ hostname = "myserver.com"
 # Control authentication
 [authentication]
 enabled = true
+https-required = true
+bcrypt-cost = 12
+password-min-length = 8
+password-reject-username = true
+admin-username = "root"
+admin-password = "secret"
+cluster-secret = "s3cr3t"
 
 [logging]
 # logging level can be one of "debug", "info", "warn" or "error"
 level  = "info"
 file   = "influxdb.log"
+format = "json"
+max-size = "10m"
+max-age = "12h"
+
+[metrics]
+latency-buckets = [0.01, 0.1, 1.0]
 
 # Configure the admin server
 [admin]
@@ -173,12 +349,26 @@ assets = "./admin"
 [api]
 ssl-port = 8087    # Ssl support is enabled if you set a port and cert
 ssl-cert = "../cert.pem"
+ssl-key = "../key.pem"
+http2-disabled = true
 
 # connections will timeout after this amount of time. Ensures that clients that misbehave
 # and keep alive connections they don't use won't end up connection a million times.
 # However, if a request is taking longer than this to complete, could be a problem.
 read-timeout = "5s"
 
+[tag-normalization]
+lowercase = true
+trim-space = true
+max-length = 64
+
+[tag-cardinality]
+check-period = "5m"
+thresholds = [100, 1000]
+database = "_internal"
+max-values-per-tag = 5000
+drop-tags-over-limit = true
+
 [input_plugins]
 
   [input_plugins.udp]
@@ -195,6 +385,7 @@ port = 2003
 database = "graphite_tcp"  # store graphite data in this database
 name-position = "last"
 name-separator = "-"
+templates = ["servers.* .host.measurement*", "env.host.measurement.field"]
 
 [[graphite]]
 protocol = "udP"
@@ -210,6 +401,41 @@ address = "192.168.0.3"
 port = 25827
 database = "collectd_database"
 typesdb = "foo-db-type"
+field-naming = "type_dsname"
+
+# Configure OpenTSDB server
+[opentsdb]
+enabled = true
+address = "192.168.0.4"
+port = 4243
+database = "opentsdb_database"
+retention-policy = "raw"
+
+# Configure StatsD server
+[statsd]
+enabled = true
+port = 8126
+database = "statsd_database"
+flush-interval = "1m"
+
+# Roll up raw data into an archive retention policy
+[[downsample]]
+database = "foo"
+name = "hourly"
+source = "raw"
+target = "archive"
+interval = "1h"
+  [downsample.aggregates]
+  value = "mean"
+  "*" = "max"
+
+# Read rollups for coarse queries on these databases
+[downsample-queries]
+databases = ["foo"]
+
+[[shard-encryption]]
+database = "foo"
+key-env = "INFLUXDB_TEST_SHARD_KEY"
 
 # Raft configuration
 [raft]
@@ -224,6 +450,14 @@ port = 8090
 # Where the broker logs are stored. The user running InfluxDB will need read/write access.
 dir  = "/tmp/influxdb/development/broker"
 
+# Requests beyond this many concurrent requests are rejected.
+max-concurrent-requests = 100
+
+transport = "local"
+ssl-cert = "../broker.pem"
+max-hint-size = "10m"
+max-hint-age = "1h"
+
 # election-timeout = "2s"
 
 [data]
@@ -237,6 +471,41 @@ write-buffer-size = 10000
 # The server will check this often for shards that have expired and should be cleared.
 retention-sweep-period = "10m"
 
+# Series without data are dropped from the index after the grace period.
+drop-expired-series = true
+expired-series-grace-period = "30m"
+
+# Shards that were not written to during the period are compacted.
+compaction-period = "2h"
+
+# Continuous queries are checked once per period.
+continuous-query-period = "5s"
+
+# The metastore is snapshotted once per period.
+metastore-backup-dir = "/tmp/influxdb/development/meta-backups"
+metastore-backup-period = "30m"
+metastore-backup-count = 48
+
+# Writes are published to the broker in batches. A batch is sent once the flush
+# interval has elapsed or its size reaches the max batch size.
+publish-flush-interval = "500us"
+publish-max-batch-size = "2m"
+
+# Requests beyond this many concurrent requests are rejected.
+max-concurrent-requests = 200
+
+# Writes are sorted in batches of this size.
+reorder-buffer-size = 64
+
+write-queue-enabled = true
+write-queue-max-size = "100m"
+write-queue-retry-interval = "5s"
+
+# Metastore values are encrypted with the key. Previous keys are used to decrypt
+# values written before the key was rotated.
+metastore-key = "MDEyMzQ1Njc4OWFiY2RlZg=="
+metastore-previous-keys = ["ZmVkY2JhOTg3NjU0MzIxMA=="]
+
 [cluster]
 # A comma separated list of servers to seed
 # this server. this is only relevant when the
@@ -275,6 +544,17 @@ max-response-buffer-size = 5
 # that you don't need to buffer in memory, but you won't get the best performance.
 concurrent-shard-query-limit = 10
 
+# Queries with a larger estimated cost are rejected.
+max-query-cost = 1000000
+
+query-timeout = "30s"
+max-concurrent-queries = 8
+max-queued-queries = 16
+consistency-timeout = "5s"
+
+peer-pins = ["UNhY4JhezH9gQYqvDMWrWH9CwlcKiECVqejMrND2VFw="]
+peer-insecure-skip-verify = true
+
 [leveldb]
 
 # Maximum mmap open files, this will affect the virtual memory used by
@@ -292,6 +572,15 @@ lru-cache-size = "200m"
 point-batch-size = 50
 `
 
+// Ensure that skipping peer verification without pins is rejected.
+func TestConfig_PeerTLSConfig_InsecureSkipVerifyWithoutPins(t *testing.T) {
+	var c main.Config
+	c.Cluster.PeerInsecureSkipVerify = true
+	if _, err := c.PeerTLSConfig(); err == nil || err.Error() != "peer-insecure-skip-verify requires peer-pins" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func TestCollectd_ConnectionString(t *testing.T) {
 	var tests = []struct {
 		name             string
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
+	"path"
 	"strings"
+	"sync/atomic"
 )
 
+// rejectedRequests publishes the number of requests rejected by each limit
+// handler on /debug/vars.
+var rejectedRequests = expvar.NewMap("rejected_requests")
+
 // Handler represents an HTTP handler for InfluxDB node.
 // Depending on its role, it will serve many different endpoints.
 type Handler struct {
@@ -40,3 +47,68 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	h.serverHandler.ServeHTTP(w, r)
 }
+
+// LimitHandler wraps a handler and rejects requests while the maximum number
+// of requests are already being served. Rejected requests receive a 503 so
+// clients can back off and retry.
+type LimitHandler struct {
+	handler  http.Handler
+	sem      chan struct{}
+	rejected uint64
+
+	// Exempt reports whether a request bypasses the limit, such as a
+	// long-lived stream that would otherwise hold a slot indefinitely.
+	Exempt func(r *http.Request) bool
+}
+
+// NewLimitHandler returns a handler that serves at most n concurrent requests.
+func NewLimitHandler(h http.Handler, n int) *LimitHandler {
+	return &LimitHandler{
+		handler: h,
+		sem:     make(chan struct{}, n),
+	}
+}
+
+// Rejected returns the number of requests rejected because the limit was reached.
+func (h *LimitHandler) Rejected() uint64 { return atomic.LoadUint64(&h.rejected) }
+
+// ServeHTTP serves the request if the limit has not been reached.
+func (h *LimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Exempt != nil && h.Exempt(r) {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	select {
+	case h.sem <- struct{}{}:
+		defer func() { <-h.sem }()
+		h.handler.ServeHTTP(w, r)
+	default:
+		atomic.AddUint64(&h.rejected, 1)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many concurrent requests", http.StatusServiceUnavailable)
+	}
+}
+
+// limitHandler wraps a handler with a LimitHandler if n is positive and
+// publishes its rejected request count under name.
+func limitHandler(name string, h http.Handler, n int, exempt func(*http.Request) bool) http.Handler {
+	if n <= 0 {
+		return h
+	}
+	lh := NewLimitHandler(h, n)
+	lh.Exempt = exempt
+	rejectedRequests.Set(name, expvar.Func(func() interface{} { return lh.Rejected() }))
+	return lh
+}
+
+// isStreamRequest returns true if r opens a broker or raft stream. Data nodes
+// and raft peers hold these open for as long as they are connected.
+func isStreamRequest(r *http.Request) bool {
+	if r.Method != "GET" {
+		return false
+	} else if r.URL.Path == "/messaging/messages" {
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, "/raft") && path.Base(r.URL.Path) == "stream"
+}
@@ -0,0 +1,75 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	main "github.com/influxdb/influxdb/cmd/influxd"
+)
+
+// Ensure the limit handler rejects requests beyond its concurrency limit.
+func TestLimitHandler(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	h := main.NewLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}), 1)
+
+	// Hold the only slot open.
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), &http.Request{})
+		close(done)
+	}()
+	<-started
+
+	// Ensure the next request is rejected and counted.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, &http.Request{})
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status: %d", w.Code)
+	} else if n := h.Rejected(); n != 1 {
+		t.Fatalf("unexpected rejected count: %d", n)
+	}
+
+	// Ensure requests are served once the slot is released.
+	close(release)
+	<-done
+	go func() { <-started }()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, &http.Request{})
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+}
+
+// Ensure exempt requests are served when the limit has been reached.
+func TestLimitHandler_Exempt(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	h := main.NewLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			return
+		}
+		started <- struct{}{}
+		<-release
+	}), 1)
+	h.Exempt = func(r *http.Request) bool { return r.Method == "GET" }
+
+	// Hold the only slot open.
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), &http.Request{Method: "POST"})
+		close(done)
+	}()
+	<-started
+	defer func() { close(release); <-done }()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, &http.Request{Method: "GET"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	} else if n := h.Rejected(); n != 0 {
+		t.Fatalf("unexpected rejected count: %d", n)
+	}
+}
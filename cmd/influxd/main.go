@@ -59,6 +59,12 @@ func main() {
 		execRun(args[1:])
 	case "":
 		execRun(args)
+	case "backup":
+		execBackup(args[1:])
+	case "repair":
+		execRepair(args[1:])
+	case "restore":
+		execRestore(args[1:])
 	case "version":
 		execVersion(args[1:])
 	case "help":
@@ -98,7 +104,10 @@ Usage:
 
 The commands are:
 
+    backup               downloads a snapshot of a data node to an archive
     join-cluster         create a new node that will join an existing cluster
+    repair               copies missing data to a data node from its replicas
+    restore              rebuilds a data directory from a backup archive
     run                  run node with existing configuration
     version              displays the InfluxDB version
 
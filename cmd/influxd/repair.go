@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/influxdb/influxdb"
+)
+
+// execRepair runs the "repair" command.
+// Copies missing data to a data node's shards from their other replicas.
+func execRepair(args []string) {
+	// Parse command flags.
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		host     = fs.String("host", fmt.Sprintf("localhost:%d", DefaultDataPort), "")
+		username = fs.String("username", "", "")
+		password = fs.String("password", "", "")
+		shardID  = fs.Uint64("shard", 0, "")
+	)
+	fs.Usage = printRepairUsage
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		printRepairUsage()
+		os.Exit(1)
+	}
+
+	// Build the repair URL.
+	params := url.Values{}
+	if *username != "" {
+		params.Set("u", *username)
+		params.Set("p", *password)
+	}
+	if *shardID != 0 {
+		params.Set("shard", strconv.FormatUint(*shardID, 10))
+	}
+	u := &url.URL{Scheme: "http", Host: *host, Path: "/repair", RawQuery: params.Encode()}
+
+	// Run the repair on the data node.
+	resp, err := http.Post(u.String(), "", nil)
+	if err != nil {
+		log.Fatalf("repair: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		log.Fatalf("repair: unexpected status: %s: %s", resp.Status, b)
+	}
+
+	var a []*influxdb.ShardRepair
+	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		log.Fatalf("repair: %s", err)
+	}
+	for _, r := range a {
+		log.Printf("shard %d: %d series repaired, %d points copied", r.ShardID, r.SeriesRepaired, r.PointsCopied)
+	}
+}
+
+func printRepairUsage() {
+	log.Printf(`usage: repair [flags]
+
+repair compares the shards stored on a running data node with the other data
+nodes that own the same shards and copies any points missing from the node.
+
+        -host <host:port>
+                          The data node to repair. Defaults to localhost:%d.
+
+        -shard <id>
+                          Only repair this shard. Defaults to every shard
+                          stored on the node with more than one owner.
+
+        -username <name>
+                          The name of an admin user, if authentication is enabled.
+
+        -password <password>
+                          The password of the admin user.
+`, DefaultDataPort)
+}
@@ -1,20 +1,25 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/influxdb/influxdb"
 	"github.com/influxdb/influxdb/collectd"
 	"github.com/influxdb/influxdb/graphite"
 	"github.com/influxdb/influxdb/messaging"
+	"github.com/influxdb/influxdb/opentsdb"
+	"github.com/influxdb/influxdb/statsd"
 )
 
 // execRun runs the "run" command.
@@ -48,24 +53,52 @@ func execRun(args []string) {
 	configExists := *configPath != ""
 	initializing := !fileExists(config.Broker.Dir) && !fileExists(config.Data.Dir)
 
+	// Route the remaining standard library logging through the configured logger.
+	logger, err := config.Logger()
+	if err != nil {
+		log.Fatalf("logging: %s", err)
+	}
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(influxdb.InfoLevel))
+
+	// Validate the messaging transport. A local node has no broker to join.
+	local := config.Broker.Transport == LocalTransport
+	if !local && config.Broker.Transport != BrokerTransport {
+		log.Fatalf("broker transport must be %q or %q", BrokerTransport, LocalTransport)
+	} else if local && len(joinURLs) > 0 {
+		log.Fatalf("cannot join a cluster using the %q transport", LocalTransport)
+	}
+
 	// Open broker, initialize or join as necessary.
-	b := openBroker(config.Broker.Dir, config.BrokerURL(), initializing, joinURLs)
+	var b *messaging.Broker
+	if !local {
+		b = openBroker(config, initializing, joinURLs, logger)
+	}
 
 	// Start the broker handler.
 	var h *Handler
 	if b != nil {
-		h = &Handler{brokerHandler: messaging.NewHandler(b)}
-		go func() { log.Fatal(http.ListenAndServe(config.BrokerAddr(), h)) }()
-		log.Printf("broker listening on %s", config.BrokerAddr())
+		h = &Handler{brokerHandler: limitHandler("broker", messaging.NewHandler(b), config.Broker.MaxConcurrentRequests, isStreamRequest)}
+		if config.Broker.SSLCertPath != "" {
+			go func() {
+				log.Fatal(listenAndServeTLS(config.BrokerAddr(), config.Broker.SSLCertPath, config.Broker.SSLKeyPath, h, false))
+			}()
+			log.Printf("broker listening on %s (ssl)", config.BrokerAddr())
+		} else {
+			go func() { log.Fatal(http.ListenAndServe(config.BrokerAddr(), h)) }()
+			log.Printf("broker listening on %s", config.BrokerAddr())
+		}
 	}
 
 	// Open server, initialize or join as necessary.
-	s := openServer(config.Data.Dir, config.DataURL(), b, initializing, configExists, joinURLs)
+	s := openServer(config, b, initializing, configExists, joinURLs, logger)
 
 	// Start the server handler. Attach to broker if listening on the same port.
 	if s != nil {
-		sh := influxdb.NewHandler(s)
-		sh.AuthenticationEnabled = config.Authentication.Enabled
+		ih := influxdb.NewHandler(s)
+		ih.AuthenticationEnabled = config.Authentication.Enabled
+		ih.HTTPSRequired = config.Authentication.HTTPSRequired
+		sh := limitHandler("data", ih, config.Data.MaxConcurrentRequests, nil)
 		if h != nil && config.BrokerAddr() == config.DataAddr() {
 			h.serverHandler = sh
 		} else {
@@ -73,16 +106,105 @@ func execRun(args []string) {
 		}
 		log.Printf("data node #%d listening on %s", s.ID(), config.DataAddr())
 
+		// Start the SSL listener, if configured.
+		if addr := config.DataSSLAddr(); addr != "" {
+			go func() {
+				log.Fatal(listenAndServeTLS(addr, config.HTTPAPI.SSLCertPath, config.HTTPAPI.SSLKeyPath, sh, !config.HTTPAPI.HTTP2Disabled))
+			}()
+			log.Printf("data node #%d listening on %s (ssl)", s.ID(), addr)
+		}
+
+		// Create any downsample policies declared in the config.
+		createDownsamplePolicies(s, config.Downsamples)
+
+		// Periodically delete shard groups that have expired.
+		if err := s.StartRetentionPolicyEnforcement(time.Duration(config.Data.RetentionSweepPeriod)); err != nil {
+			log.Fatalf("retention policy enforcement: %s", err)
+		}
+
+		// Periodically compact shards that have not been written to recently.
+		if err := s.StartShardCompaction(time.Duration(config.Data.CompactionPeriod)); err != nil {
+			log.Fatalf("shard compaction: %s", err)
+		}
+
+		// Periodically run continuous queries whose intervals have completed.
+		if err := s.StartContinuousQueries(time.Duration(config.Data.ContinuousQueryPeriod)); err != nil {
+			log.Fatalf("continuous queries: %s", err)
+		}
+
+		// Periodically snapshot the metastore, if a backup directory is set.
+		if config.Data.MetastoreBackupDir != "" {
+			if err := s.StartMetastoreBackups(config.Data.MetastoreBackupDir, time.Duration(config.Data.MetastoreBackupPeriod), config.Data.MetastoreBackupCount); err != nil {
+				log.Fatalf("metastore backups: %s", err)
+			}
+		}
+
+		// Periodically check for tag keys with too many distinct values.
+		if config.TagCardinality.CheckPeriod > 0 {
+			c := config.TagCardinality
+			if err := s.StartCardinalityChecks(time.Duration(c.CheckPeriod), c.Thresholds, c.Database); err != nil {
+				log.Fatalf("tag cardinality checks: %s", err)
+			}
+		}
+
+		// Publish writes queued by asynchronous write requests.
+		if config.Data.WriteQueueEnabled {
+			if err := s.StartWriteQueue(int64(config.Data.WriteQueueMaxSize), time.Duration(config.Data.WriteQueueRetryInterval)); err != nil {
+				log.Fatalf("write queue: %s", err)
+			}
+		}
+
+		// Periodically remove broadcast messages every data node has applied.
+		if config.Data.BroadcastCompactionPeriod > 0 {
+			if err := s.StartBroadcastCompaction(time.Duration(config.Data.BroadcastCompactionPeriod)); err != nil {
+				log.Fatalf("broadcast compaction: %s", err)
+			}
+		}
+
 		// Spin up the collectd server
 		if config.Collectd.Enabled {
 			c := config.Collectd
 			cs := collectd.NewServer(s, c.TypesDB)
 			cs.Database = c.Database
+			cs.FieldNaming = collectd.FieldNaming(c.FieldNaming)
+			cs.Stats = influxdb.NewInputStats("collectd", c.Database)
+			cs.Logger = s.Logger.New("collectd")
+			s.RegisterInput(cs.Stats)
 			err := collectd.ListenAndServe(cs, c.ConnectionString(config.BindAddress))
 			if err != nil {
 				log.Printf("failed to start collectd Server: %v\n", err.Error())
 			}
 		}
+
+		// Spin up the OpenTSDB server
+		if config.OpenTSDB.Enabled {
+			c := config.OpenTSDB
+			ts := opentsdb.NewServer(s)
+			ts.Database = c.Database
+			ts.RetentionPolicy = c.RetentionPolicy
+			ts.Stats = influxdb.NewInputStats("opentsdb", c.Database)
+			ts.Logger = s.Logger.New("opentsdb")
+			s.RegisterInput(ts.Stats)
+			if err := ts.ListenAndServe(c.ConnectionString(config.BindAddress)); err != nil {
+				log.Printf("failed to start OpenTSDB Server: %v\n", err.Error())
+			}
+		}
+
+		// Spin up the StatsD server
+		if config.Statsd.Enabled {
+			c := config.Statsd
+			ss := statsd.NewServer(s)
+			ss.Database = c.Database
+			ss.RetentionPolicy = c.RetentionPolicy
+			ss.FlushInterval = time.Duration(c.FlushInterval)
+			ss.Stats = influxdb.NewInputStats("statsd", c.Database)
+			ss.Logger = s.Logger.New("statsd")
+			s.RegisterInput(ss.Stats)
+			if err := ss.ListenAndServe(c.ConnectionString(config.BindAddress)); err != nil {
+				log.Printf("failed to start StatsD Server: %v\n", err.Error())
+			}
+		}
+
 		// Spin up any Graphite servers
 		for _, c := range config.Graphites {
 			if !c.Enabled {
@@ -93,11 +215,20 @@ func execRun(args []string) {
 			parser := graphite.NewParser()
 			parser.Separator = c.NameSeparatorString()
 			parser.LastEnabled = c.LastEnabled()
+			for _, t := range c.Templates {
+				if err := parser.AddTemplate(t); err != nil {
+					log.Fatalf("graphite template: %s", err)
+				}
+			}
 
 			// Start the relevant server.
+			stats := influxdb.NewInputStats("graphite", c.Database)
+			s.RegisterInput(stats)
 			if strings.ToLower(c.Protocol) == "tcp" {
 				g := graphite.NewTCPServer(parser, s)
 				g.Database = c.Database
+				g.Stats = stats
+				g.Logger = s.Logger.New("graphite")
 				err := g.ListenAndServe(c.ConnectionString(config.BindAddress))
 				if err != nil {
 					log.Printf("failed to start TCP Graphite Server: %v\n", err.Error())
@@ -105,6 +236,8 @@ func execRun(args []string) {
 			} else if strings.ToLower(c.Protocol) == "udp" {
 				g := graphite.NewUDPServer(parser, s)
 				g.Database = c.Database
+				g.Stats = stats
+				g.Logger = s.Logger.New("graphite")
 				err := g.ListenAndServe(c.ConnectionString(config.BindAddress))
 				if err != nil {
 					log.Printf("failed to start UDP Graphite Server: %v\n", err.Error())
@@ -119,6 +252,30 @@ func execRun(args []string) {
 	<-(chan struct{})(nil)
 }
 
+// listenAndServeTLS listens for HTTPS connections on addr and serves them
+// with ServeTLS.
+func listenAndServeTLS(addr, certPath, keyPath string, h http.Handler, http2 bool) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return ServeTLS(ln, certPath, keyPath, h, http2)
+}
+
+// ServeTLS serves HTTPS connections accepted on ln. The private key is read
+// from the certificate file if no key file is given. Clients can multiplex
+// requests over a single connection with HTTP/2 when enabled.
+func ServeTLS(ln net.Listener, certPath, keyPath string, h http.Handler, http2 bool) error {
+	if keyPath == "" {
+		keyPath = certPath
+	}
+	srv := &http.Server{Handler: h}
+	if !http2 {
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	return srv.ServeTLS(ln, certPath, keyPath)
+}
+
 // write the current process id to a file specified by path.
 func writePIDFile(path string) {
 	if path == "" {
@@ -154,15 +311,19 @@ func parseConfig(path, hostname string) *Config {
 }
 
 // creates and initializes a broker.
-func openBroker(path string, u *url.URL, initializing bool, joinURLs []*url.URL) *messaging.Broker {
+func openBroker(config *Config, initializing bool, joinURLs []*url.URL, logger *influxdb.Logger) *messaging.Broker {
 	// Ignore if there's no existing broker and we're not initializing or joining.
-	if !fileExists(path) && !initializing && len(joinURLs) == 0 {
+	if !fileExists(config.Broker.Dir) && !initializing && len(joinURLs) == 0 {
 		return nil
 	}
 
 	// Create broker.
 	b := messaging.NewBroker()
-	if err := b.Open(path, u); err != nil {
+	b.MaxHintSize = int64(config.Broker.MaxHintSize)
+	b.MaxHintAge = time.Duration(config.Broker.MaxHintAge)
+	b.Logger = logger.New("broker").StdLogger(influxdb.InfoLevel)
+	b.SetRaftLogger(logger.New("raft").StdLogger(influxdb.InfoLevel))
+	if err := b.Open(config.Broker.Dir, config.BrokerURL()); err != nil {
 		log.Fatalf("failed to open broker: %s", err)
 	}
 
@@ -202,40 +363,115 @@ func joinBroker(b *messaging.Broker, joinURLs []*url.URL) {
 }
 
 // creates and initializes a server.
-func openServer(path string, u *url.URL, b *messaging.Broker, initializing, configExists bool, joinURLs []*url.URL) *influxdb.Server {
+func openServer(config *Config, b *messaging.Broker, initializing, configExists bool, joinURLs []*url.URL, logger *influxdb.Logger) *influxdb.Server {
 	// Ignore if there's no existing server and we're not initializing or joining.
-	if !fileExists(path) && !initializing && len(joinURLs) == 0 {
+	if !fileExists(config.Data.Dir) && !initializing && len(joinURLs) == 0 {
 		return nil
 	}
 
 	// Create and open the server.
 	s := influxdb.NewServer()
-	if err := s.Open(path); err != nil {
+	s.Logger = logger.New("server")
+	s.SetVersion(version)
+	key, previousKeys, err := config.MetastoreKeys()
+	if err != nil {
+		log.Fatalf("metastore key: %s", err)
+	}
+	if err := s.SetMetastoreKeys(key, previousKeys...); err != nil {
+		log.Fatalf("metastore key: %s", err)
+	}
+	shardKeys, err := config.ShardKeys()
+	if err != nil {
+		log.Fatalf("shard key: %s", err)
+	}
+	if err := s.SetShardKeys(shardKeys); err != nil {
+		log.Fatalf("shard key: %s", err)
+	}
+	peerTLS, err := config.PeerTLSConfig()
+	if err != nil {
+		log.Fatalf("peer tls: %s", err)
+	}
+	s.SetPeerTLSConfig(peerTLS)
+	tagNormalizer, err := config.TagNormalizer()
+	if err != nil {
+		log.Fatalf("tag normalization: %s", err)
+	}
+	s.SetTagNormalizer(tagNormalizer)
+	s.SetMaxValuesPerTag(config.TagCardinality.MaxValuesPerTag, config.TagCardinality.DropTagsOverLimit)
+	s.SetDropExpiredSeries(config.Data.DropExpiredSeries, time.Duration(config.Data.ExpiredSeriesGrace))
+	for _, database := range config.DownsampleQueries.Databases {
+		s.SetDownsampleQueries(database, true)
+	}
+	if config.Authentication.BcryptCost != 0 {
+		if err := s.SetBcryptCost(config.Authentication.BcryptCost); err != nil {
+			log.Fatalf("bcrypt cost: %s", err)
+		}
+	}
+	s.SetPasswordPolicy(influxdb.PasswordPolicy{
+		MinLength:      config.Authentication.PasswordMinLength,
+		RejectUsername: config.Authentication.PasswordRejectUsername,
+	})
+	s.SetBootstrapAdmin(config.Authentication.AdminUsername, config.Authentication.AdminPassword)
+	s.SetClusterSecret(config.Authentication.ClusterSecret)
+	if config.Authentication.Enabled && config.Authentication.ClusterSecret == "" {
+		log.Printf("warning: authentication is enabled without a cluster secret; requests between data nodes will be rejected")
+	}
+	s.SetMaxQueryCost(config.Cluster.MaxQueryCost)
+	s.SetQueryTimeout(time.Duration(config.Cluster.QueryTimeout))
+	s.SetConsistencyTimeout(time.Duration(config.Cluster.ConsistencyTimeout))
+	if err := s.SetMaxConcurrentQueries(config.Cluster.MaxConcurrentQueries, config.Cluster.MaxQueuedQueries); err != nil {
+		log.Fatalf("query limits: %s", err)
+	}
+	if len(config.Metrics.LatencyBuckets) > 0 {
+		if err := s.SetLatencyBuckets(config.Metrics.LatencyBuckets); err != nil {
+			log.Fatalf("latency buckets: %s", err)
+		}
+	}
+	if err := s.SetShardReorderBufferSize(config.Data.ReorderBufferSize); err != nil {
+		log.Fatalf("reorder buffer: %s", err)
+	}
+	if err := s.Open(config.Data.Dir); err != nil {
 		log.Fatalf("failed to open data server: %v", err.Error())
 	}
 
+	// Single-node servers deliver messages in-process instead of via a broker.
+	if config.Broker.Transport == LocalTransport {
+		openLocalServerClient(s, initializing, config)
+		announceDataNode(s)
+		return s
+	}
+
 	// If the server is uninitialized then initialize or join it.
 	if initializing {
 		if len(joinURLs) == 0 {
-			initializeServer(s, b)
+			initializeServer(s, b, config)
 		} else {
-			joinServer(s, u, joinURLs)
-			openServerClient(s, joinURLs)
+			joinServer(s, config.DataURL(), joinURLs, config.Data.Standby)
+			openServerClient(s, joinURLs, config)
 		}
 	} else if !configExists {
 		// We are spining up an server that has no config,
 		// but already has an initialized data directory
 		joinURLs = []*url.URL{b.URL()}
-		openServerClient(s, joinURLs)
+		openServerClient(s, joinURLs, config)
 	} else {
-		openServerClient(s, joinURLs)
+		openServerClient(s, joinURLs, config)
 	}
+	announceDataNode(s)
 
 	return s
 }
 
+// reports the data node's version and message format to the cluster so newer
+// messages are used once every data node has been upgraded.
+func announceDataNode(s *influxdb.Server) {
+	if err := s.AnnounceDataNode(); err != nil {
+		log.Printf("announce: failed to report data node version: %s", err)
+	}
+}
+
 // initializes a new server that does not yet have an ID.
-func initializeServer(s *influxdb.Server, b *messaging.Broker) {
+func initializeServer(s *influxdb.Server, b *messaging.Broker, config *Config) {
 	// TODO: Create replica using the messaging client.
 
 	// Create replica on broker.
@@ -244,7 +480,7 @@ func initializeServer(s *influxdb.Server, b *messaging.Broker) {
 	}
 
 	// Create messaging client.
-	c := messaging.NewClient(1)
+	c := newMessagingClient(1, config, s.Logger)
 	if err := c.Open(filepath.Join(s.Path(), messagingClientFile), []*url.URL{b.URL()}); err != nil {
 		log.Fatalf("messaging client error: %s", err)
 	}
@@ -258,13 +494,17 @@ func initializeServer(s *influxdb.Server, b *messaging.Broker) {
 	}
 }
 
-// joins a server to an existing cluster.
-func joinServer(s *influxdb.Server, u *url.URL, joinURLs []*url.URL) {
+// joins a server to an existing cluster, optionally as a standby.
+func joinServer(s *influxdb.Server, u *url.URL, joinURLs []*url.URL, standby bool) {
 	// TODO: Use separate broker and data join urls.
 
 	// Create data node on an existing data node.
+	join := s.Join
+	if standby {
+		join = s.JoinStandby
+	}
 	for _, joinURL := range joinURLs {
-		if err := s.Join(u, joinURL); err != nil {
+		if err := join(u, joinURL); err != nil {
 			log.Printf("join: failed to connect data node: %s: %s", u, err)
 		} else {
 			log.Printf("join: connected data node to %s", u)
@@ -275,8 +515,8 @@ func joinServer(s *influxdb.Server, u *url.URL, joinURLs []*url.URL) {
 }
 
 // opens the messaging client and attaches it to the server.
-func openServerClient(s *influxdb.Server, joinURLs []*url.URL) {
-	c := messaging.NewClient(s.ID())
+func openServerClient(s *influxdb.Server, joinURLs []*url.URL, config *Config) {
+	c := newMessagingClient(s.ID(), config, s.Logger)
 	if err := c.Open(filepath.Join(s.Path(), messagingClientFile), joinURLs); err != nil {
 		log.Fatalf("messaging client error: %s", err)
 	}
@@ -285,6 +525,47 @@ func openServerClient(s *influxdb.Server, joinURLs []*url.URL) {
 	}
 }
 
+// attaches an in-process messaging client to the server and initializes the
+// server if it is new.
+func openLocalServerClient(s *influxdb.Server, initializing bool, config *Config) {
+	// Continue numbering messages after those already in the metastore.
+	c := messaging.NewLocalClient()
+	c.SetIndex(s.MetastoreIndex())
+	if err := s.SetClient(c); err != nil {
+		log.Fatalf("set client error: %s", err)
+	}
+	if initializing {
+		if err := s.Initialize(config.DataURL()); err != nil {
+			log.Fatalf("server initialization error: %s", err)
+		}
+	}
+}
+
+// creates downsample policies that do not already exist on the server.
+func createDownsamplePolicies(s *influxdb.Server, a []Downsample) {
+	for _, c := range a {
+		err := s.CreateDownsamplePolicy(c.Database, &influxdb.DownsamplePolicy{
+			Name:       c.Name,
+			Source:     c.Source,
+			Target:     c.Target,
+			Interval:   time.Duration(c.Interval),
+			Aggregates: c.Aggregates,
+		})
+		if err != nil && err != influxdb.ErrDownsamplePolicyExists {
+			log.Printf("downsample: failed to create policy %s on %s: %s", c.Name, c.Database, err)
+		}
+	}
+}
+
+// returns a messaging client configured with the publish batching settings.
+func newMessagingClient(replicaID uint64, config *Config, logger *influxdb.Logger) *messaging.Client {
+	c := messaging.NewClient(replicaID)
+	c.Logger = logger.New("messaging").StdLogger(influxdb.InfoLevel)
+	c.BatchFlushInterval = time.Duration(config.Data.PublishFlushInterval)
+	c.MaxBatchSize = int(config.Data.PublishMaxBatchSize)
+	return c
+}
+
 // parses a comma-delimited list of URLs.
 func parseURLs(s string) (a []*url.URL) {
 	if s == "" {
@@ -3,8 +3,8 @@ package collectd
 import (
 	"errors"
 	"fmt"
-	"log"
 	"net"
+	"os"
 	"sync"
 	"time"
 
@@ -15,6 +15,29 @@ import (
 // DefaultPort for collectd is 25826
 const DefaultPort = 25826
 
+// FieldNaming determines how the fields of a multi-value type are named.
+type FieldNaming string
+
+const (
+	// FieldNamingDataSource names each field after its data source,
+	// e.g. "rx" and "tx" for the if_octets type.
+	FieldNamingDataSource = FieldNaming("dsname")
+
+	// FieldNamingPluginDataSource prefixes data source names with the plugin,
+	// e.g. "interface_rx" and "interface_tx".
+	FieldNamingPluginDataSource = FieldNaming("plugin_dsname")
+
+	// FieldNamingTypeDataSource prefixes data source names with the type,
+	// e.g. "if_octets_rx" and "if_octets_tx".
+	FieldNamingTypeDataSource = FieldNaming("type_dsname")
+
+	// DefaultFieldNaming is the field naming used when none is set.
+	DefaultFieldNaming = FieldNamingDataSource
+)
+
+// ErrInvalidFieldNaming is returned when starting a server with an unknown field naming.
+var ErrInvalidFieldNaming = errors.New("invalid field naming")
+
 // SeriesWriter defines the interface for the destination of the data.
 type SeriesWriter interface {
 	WriteSeries(database, retentionPolicy string, points []influxdb.Point) (uint64, error)
@@ -28,6 +51,9 @@ type Server struct {
 
 	writer      SeriesWriter
 	Database    string
+	FieldNaming FieldNaming
+	Stats       *influxdb.InputStats // optional, records the state of the server for diagnostics
+	Logger      *influxdb.Logger
 	typesdb     gollectd.Types
 	typesdbpath string
 }
@@ -37,6 +63,7 @@ func NewServer(w SeriesWriter, typesDBPath string) *Server {
 		writer:      w,
 		typesdbpath: typesDBPath,
 		typesdb:     make(gollectd.Types),
+		Logger:      influxdb.NewLogger(os.Stderr, "collectd", influxdb.InfoLevel, false),
 	}
 
 	return &s
@@ -49,6 +76,15 @@ func ListenAndServe(s *Server, iface string) error {
 		return errors.New("database was not specified in config")
 	}
 
+	// Validate the field naming.
+	switch s.FieldNaming {
+	case "":
+		s.FieldNaming = DefaultFieldNaming
+	case FieldNamingDataSource, FieldNamingPluginDataSource, FieldNamingTypeDataSource:
+	default:
+		return ErrInvalidFieldNaming
+	}
+
 	addr, err := net.ResolveUDPAddr("udp", iface)
 	if err != nil {
 		return fmt.Errorf("unable to resolve UDP address: %v", err)
@@ -64,6 +100,7 @@ func ListenAndServe(s *Server, iface string) error {
 		return fmt.Errorf("unable to listen on UDP: %v", err)
 	}
 	s.conn = conn
+	s.Stats.Listening(conn.LocalAddr())
 
 	s.wg.Add(1)
 	go s.serve(conn)
@@ -87,10 +124,10 @@ func (s *Server) serve(conn *net.UDPConn) {
 	for {
 		n, _, err := conn.ReadFromUDP(buffer)
 		if err != nil && s.conn != nil {
-			log.Printf("Collectd ReadFromUDP error: %s", err)
+			s.Logger.Errorf("Collectd ReadFromUDP error: %s", err)
 			continue
 		}
-		log.Printf("received %d bytes", n)
+		s.Logger.Debugf("received %d bytes", n)
 		if n > 0 {
 			s.handleMessage(buffer[:n])
 		}
@@ -102,19 +139,22 @@ func (s *Server) serve(conn *net.UDPConn) {
 }
 
 func (s *Server) handleMessage(buffer []byte) {
-	log.Printf("handling message")
+	s.Logger.Debugf("handling message")
 	packets, err := gollectd.Packets(buffer, s.typesdb)
 	if err != nil {
-		log.Printf("Collectd parse error: %s", err)
+		s.Logger.Warnf("Collectd parse error: %s", err)
+		s.Stats.ParseError()
 		return
 	}
 
 	for _, packet := range *packets {
-		points := Unmarshal(&packet)
+		points := UnmarshalWithFieldNaming(&packet, s.FieldNaming)
+		s.Stats.Received(len(points))
 		for _, p := range points {
 			_, err := s.writer.WriteSeries(s.Database, "", []influxdb.Point{p})
 			if err != nil {
-				log.Printf("Collectd cannot write data: %s", err)
+				s.Logger.Errorf("Collectd cannot write data: %s", err)
+				s.Stats.WriteError()
 				continue
 			}
 		}
@@ -134,12 +174,23 @@ func (s *Server) Close() error {
 
 	// Wait for all goroutines to shutdown.
 	s.wg.Wait()
-	log.Printf("all waitgroups finished")
+	s.Logger.Debugf("all waitgroups finished")
 
 	return nil
 }
 
+// Unmarshal translates a collectd packet into points using the default field naming.
 func Unmarshal(data *gollectd.Packet) []influxdb.Point {
+	return UnmarshalWithFieldNaming(data, DefaultFieldNaming)
+}
+
+// UnmarshalWithFieldNaming translates a collectd packet into points.
+//
+// A single-value type produces a point named after the plugin and data
+// source, e.g. "cpu_value", with the value stored in a field of the same name.
+// A multi-value type, e.g. if_octets, produces a single point named after the
+// plugin with one field per data source, named by the field naming.
+func UnmarshalWithFieldNaming(data *gollectd.Packet, naming FieldNaming) []influxdb.Point {
 	// Prefer high resolution timestamp.
 	var timestamp time.Time
 	if data.TimeHR > 0 {
@@ -154,34 +205,48 @@ func Unmarshal(data *gollectd.Packet) []influxdb.Point {
 		timestamp = time.Unix(int64(data.Time), 0).UTC()
 	}
 
-	var points []influxdb.Point
-	for i, _ := range data.Values {
-		name := fmt.Sprintf("%s_%s", data.Plugin, data.Values[i].Name)
-		tags := make(map[string]string)
-		values := make(map[string]interface{})
-
-		values[name] = data.Values[i].Value
+	tags := make(map[string]string)
+	if data.Hostname != "" {
+		tags["host"] = data.Hostname
+	}
+	if data.PluginInstance != "" {
+		tags["instance"] = data.PluginInstance
+	}
+	if data.Type != "" {
+		tags["type"] = data.Type
+	}
+	if data.TypeInstance != "" {
+		tags["type_instance"] = data.TypeInstance
+	}
 
-		if data.Hostname != "" {
-			tags["host"] = data.Hostname
-		}
-		if data.PluginInstance != "" {
-			tags["instance"] = data.PluginInstance
-		}
-		if data.Type != "" {
-			tags["type"] = data.Type
-		}
-		if data.TypeInstance != "" {
-			tags["type_instance"] = data.TypeInstance
-		}
-		p := influxdb.Point{
+	switch len(data.Values) {
+	case 0:
+		return nil
+	case 1:
+		name := fmt.Sprintf("%s_%s", data.Plugin, data.Values[0].Name)
+		return []influxdb.Point{{
 			Name:      name,
 			Tags:      tags,
 			Timestamp: timestamp,
-			Values:    values,
-		}
+			Values:    map[string]interface{}{name: data.Values[0].Value},
+		}}
+	}
 
-		points = append(points, p)
+	values := make(map[string]interface{}, len(data.Values))
+	for _, v := range data.Values {
+		switch naming {
+		case FieldNamingPluginDataSource:
+			values[fmt.Sprintf("%s_%s", data.Plugin, v.Name)] = v.Value
+		case FieldNamingTypeDataSource:
+			values[fmt.Sprintf("%s_%s", data.Type, v.Name)] = v.Value
+		default:
+			values[v.Name] = v.Value
+		}
 	}
-	return points
+	return []influxdb.Point{{
+		Name:      data.Plugin,
+		Tags:      tags,
+		Timestamp: timestamp,
+		Values:    values,
+	}}
 }
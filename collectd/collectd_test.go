@@ -3,7 +3,12 @@ package collectd_test
 import (
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -83,6 +88,19 @@ func TestServer_ListenAndServe_ErrCouldNotParseTypesDBFile(t *testing.T) {
 	}
 }
 
+func TestServer_ListenAndServe_ErrInvalidFieldNaming(t *testing.T) {
+	var (
+		ts testServer
+		s  = collectd.NewServer(ts, "./collectd_test.conf")
+	)
+
+	s.Database = "foo"
+	s.FieldNaming = "bad"
+	if err := collectd.ListenAndServe(s, "127.0.0.1:25831"); err != collectd.ErrInvalidFieldNaming {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func TestServer_ListenAndServe_Success(t *testing.T) {
 	var (
 		ts testServer
@@ -220,20 +238,6 @@ func TestUnmarshal_Points(t *testing.T) {
 				},
 			},
 		},
-		{
-			name: "multi value",
-			points: []influxdb.Point{
-				influxdb.Point{Name: "disk_read", Values: map[string]interface{}{"disk_read": float64(1)}},
-				influxdb.Point{Name: "disk_write", Values: map[string]interface{}{"disk_write": float64(5)}},
-			},
-			packet: gollectd.Packet{
-				Plugin: "disk",
-				Values: []gollectd.Value{
-					gollectd.Value{Name: "read", Value: 1},
-					gollectd.Value{Name: "write", Value: 5},
-				},
-			},
-		},
 		{
 			name: "tags",
 			points: []influxdb.Point{
@@ -291,6 +295,106 @@ func TestUnmarshal_Points(t *testing.T) {
 	}
 }
 
+// Ensure multi-value types are written as multiple fields on a single point.
+func TestUnmarshalWithFieldNaming(t *testing.T) {
+	packet := &gollectd.Packet{
+		Hostname: "server01",
+		Plugin:   "interface",
+		Type:     "if_octets",
+		Values: []gollectd.Value{
+			{Name: "rx", Value: 1},
+			{Name: "tx", Value: 5},
+		},
+	}
+
+	for _, tt := range []struct {
+		naming collectd.FieldNaming
+		values map[string]interface{}
+	}{
+		{naming: collectd.FieldNamingDataSource, values: map[string]interface{}{"rx": float64(1), "tx": float64(5)}},
+		{naming: collectd.FieldNamingPluginDataSource, values: map[string]interface{}{"interface_rx": float64(1), "interface_tx": float64(5)}},
+		{naming: collectd.FieldNamingTypeDataSource, values: map[string]interface{}{"if_octets_rx": float64(1), "if_octets_tx": float64(5)}},
+	} {
+		points := collectd.UnmarshalWithFieldNaming(packet, tt.naming)
+		if len(points) != 1 {
+			t.Fatalf("%s: unexpected point count: %d", tt.naming, len(points))
+		} else if p := points[0]; p.Name != "interface" {
+			t.Errorf("%s: unexpected name: %s", tt.naming, p.Name)
+		} else if !reflect.DeepEqual(p.Tags, map[string]string{"host": "server01", "type": "if_octets"}) {
+			t.Errorf("%s: unexpected tags: %v", tt.naming, p.Tags)
+		} else if !reflect.DeepEqual(p.Values, tt.values) {
+			t.Errorf("%s: unexpected values: %v", tt.naming, p.Values)
+		}
+	}
+}
+
+// Ensure packets in the collectd binary protocol are translated into points.
+func TestUnmarshal_Packets(t *testing.T) {
+	types, err := gollectd.TypesDBFile("./collectd_test.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		file   string
+		points string
+	}{
+		{
+			file:   "if_octets.hex",
+			points: `interface{host=server01,instance=eth0,type=if_octets} rx=123456,tx=654321`,
+		},
+		{
+			file:   "load.hex",
+			points: `load{host=server01,type=load} longterm=0.125,midterm=0.25,shortterm=0.5`,
+		},
+		{
+			file: "disk_octets.hex",
+			points: `disk{host=server01,instance=sda,type=disk_octets} read=1024,write=2048` + "\n" +
+				`disk{host=server01,instance=sdb,type=disk_octets} read=10,write=20`,
+		},
+	} {
+		buf, err := ioutil.ReadFile(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := hex.DecodeString(strings.TrimSpace(string(buf)))
+		if err != nil {
+			t.Fatalf("%s: %s", tt.file, err)
+		}
+		packets, err := gollectd.Packets(b, types)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.file, err)
+		}
+
+		var a []string
+		for _, packet := range *packets {
+			for _, p := range collectd.Unmarshal(&packet) {
+				if !p.Timestamp.Equal(time.Unix(1430000000, 0)) {
+					t.Errorf("%s: unexpected timestamp: %s", tt.file, p.Timestamp)
+				}
+				a = append(a, formatPoint(p))
+			}
+		}
+		if s := strings.Join(a, "\n"); s != tt.points {
+			t.Errorf("%s: unexpected points:\n\nexp: %s\n\ngot: %s", tt.file, tt.points, s)
+		}
+	}
+}
+
+// formatPoint returns a point's name, sorted tags & sorted values as a string.
+func formatPoint(p influxdb.Point) string {
+	var tags, values []string
+	for k, v := range p.Tags {
+		tags = append(tags, k+"="+v)
+	}
+	for k, v := range p.Values {
+		values = append(values, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(tags)
+	sort.Strings(values)
+	return fmt.Sprintf("%s{%s} %s", p.Name, strings.Join(tags, ","), strings.Join(values, ","))
+}
+
 func TestUnmarshal_Time(t *testing.T) {
 	// Its important to remember that collectd stores high resolution time
 	// as "near" nanoseconds (2^30) so we have to take that into account
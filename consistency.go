@@ -0,0 +1,184 @@
+package influxdb
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsistencyLevel represents the number of shard replicas that must apply
+// a write before it returns.
+type ConsistencyLevel int
+
+const (
+	// ConsistencyLevelAny returns once the write is published to the broker.
+	ConsistencyLevelAny ConsistencyLevel = iota
+
+	// ConsistencyLevelOne waits for one replica to apply the write.
+	ConsistencyLevelOne
+
+	// ConsistencyLevelQuorum waits for a majority of replicas to apply the write.
+	ConsistencyLevelQuorum
+
+	// ConsistencyLevelAll waits for every replica to apply the write.
+	ConsistencyLevelAll
+)
+
+// String returns the lowercase name of the level.
+func (l ConsistencyLevel) String() string {
+	switch l {
+	case ConsistencyLevelAny:
+		return "any"
+	case ConsistencyLevelOne:
+		return "one"
+	case ConsistencyLevelQuorum:
+		return "quorum"
+	case ConsistencyLevelAll:
+		return "all"
+	}
+	return fmt.Sprintf("ConsistencyLevel(%d)", int(l))
+}
+
+// ParseConsistencyLevel returns the level for a name. A blank name is "any".
+func ParseConsistencyLevel(s string) (ConsistencyLevel, error) {
+	switch strings.ToLower(s) {
+	case "", "any":
+		return ConsistencyLevelAny, nil
+	case "one":
+		return ConsistencyLevelOne, nil
+	case "quorum":
+		return ConsistencyLevelQuorum, nil
+	case "all":
+		return ConsistencyLevelAll, nil
+	}
+	return 0, ErrInvalidConsistencyLevel
+}
+
+// required returns the number of acknowledgements needed from n replicas.
+func (l ConsistencyLevel) required(n int) int {
+	switch l {
+	case ConsistencyLevelOne:
+		return 1
+	case ConsistencyLevelQuorum:
+		return n/2 + 1
+	case ConsistencyLevelAll:
+		return n
+	}
+	return 0
+}
+
+// ConsistencyError is returned when too few replicas apply a write to meet
+// the requested consistency level. The write may still be applied later.
+type ConsistencyError struct {
+	Level    ConsistencyLevel
+	Acks     int   // replicas that applied the write
+	Required int   // replicas required by the level
+	Err      error // first replica error, if any
+}
+
+// Error returns a description of the unmet level.
+func (e *ConsistencyError) Error() string {
+	s := fmt.Sprintf("write consistency %s not met: %d of %d replicas", e.Level, e.Acks, e.Required)
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// SetConsistencyTimeout sets the time a write waits for its replicas to
+// apply it. A zero timeout waits indefinitely.
+func (s *Server) SetConsistencyTimeout(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consistencyTimeout = d
+}
+
+// waitForReplicas blocks until enough owners of a shard have applied the
+// message at index to satisfy level. The local replica is synced directly
+// and remote replicas are asked to sync over HTTP, all in parallel.
+func (s *Server) waitForReplicas(sh *Shard, index uint64, level ConsistencyLevel) error {
+	if level == ConsistencyLevelAny {
+		return nil
+	}
+
+	// Determine the local and remote owners of the shard.
+	s.mu.RLock()
+	owners := len(sh.DataNodeIDs)
+	local := sh.HasDataNodeID(s.id)
+	var urls []*url.URL
+	for _, id := range sh.DataNodeIDs {
+		if id == s.id {
+			continue
+		} else if n := s.dataNodes[id]; n != nil {
+			urls = append(urls, n.URL)
+		}
+	}
+	timeout := s.consistencyTimeout
+	s.mu.RUnlock()
+
+	// Ask every owner to sync and count the acknowledgements.
+	ch := make(chan error, len(urls)+1)
+	if local {
+		go func() { ch <- s.SyncTimeout(index, timeout) }()
+	}
+	client := s.peerClient()
+	client.Timeout = timeout
+	for _, u := range urls {
+		go func(u *url.URL) { ch <- syncPeerShard(client, u, sh.ID, index, timeout) }(u)
+	}
+
+	e := &ConsistencyError{Level: level, Required: level.required(owners)}
+	n := len(urls)
+	if local {
+		n++
+	}
+	for i := 0; i < n && e.Acks < e.Required; i++ {
+		if err := <-ch; err != nil {
+			if e.Err == nil {
+				e.Err = err
+			}
+			continue
+		}
+		e.Acks++
+	}
+	if e.Required == 0 || e.Acks < e.Required {
+		return e
+	}
+	return nil
+}
+
+// syncPeerShard asks a peer data node to wait until it has applied index.
+func syncPeerShard(client *http.Client, u *url.URL, shardID, index uint64, timeout time.Duration) error {
+	params := url.Values{"index": {strconv.FormatUint(index, 10)}}
+	if timeout > 0 {
+		params.Set("timeout", timeout.String())
+	}
+	resp, err := client.Get(peerShardURL(u, shardID, "sync", params))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status: %s", u, resp.Status)
+	}
+	return nil
+}
+
+// SyncShard blocks until a shard stored on this server has applied index or
+// the timeout elapses. It is used by peers waiting for write consistency.
+func (s *Server) SyncShard(shardID, index uint64, timeout time.Duration) error {
+	s.mu.RLock()
+	sh := s.shards[shardID]
+	local := sh != nil && sh.HasDataNodeID(s.id)
+	s.mu.RUnlock()
+
+	if sh == nil {
+		return ErrShardNotFound
+	} else if !local {
+		return ErrShardNotLocal
+	}
+	return s.SyncTimeout(index, timeout)
+}
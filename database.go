@@ -2,13 +2,17 @@ package influxdb
 
 import (
 	"encoding/json"
+	"fmt"
+	"hash/fnv"
 	"math"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
-	"github.com/boltdb/bolt"
 	"github.com/influxdb/influxdb/influxql"
 )
 
@@ -23,6 +27,8 @@ type database struct {
 
 	defaultRetentionPolicy string
 
+	downsamplePolicies map[string]*DownsamplePolicy // downsample policies by name
+
 	// in memory indexing structures
 	measurements map[string]*Measurement // measurement name to object and index
 	series       map[uint32]*Series      // map series id to the Series object
@@ -32,10 +38,11 @@ type database struct {
 // newDatabase returns an instance of database.
 func newDatabase() *database {
 	return &database{
-		policies:     make(map[string]*RetentionPolicy),
-		measurements: make(map[string]*Measurement),
-		series:       make(map[uint32]*Series),
-		names:        make([]string, 0),
+		policies:           make(map[string]*RetentionPolicy),
+		downsamplePolicies: make(map[string]*DownsamplePolicy),
+		measurements:       make(map[string]*Measurement),
+		series:             make(map[uint32]*Series),
+		names:              make([]string, 0),
 	}
 }
 
@@ -62,6 +69,9 @@ func (db *database) MarshalJSON() ([]byte, error) {
 	for _, rp := range db.policies {
 		o.Policies = append(o.Policies, rp)
 	}
+	for _, dp := range db.downsamplePolicies {
+		o.DownsamplePolicies = append(o.DownsamplePolicies, dp)
+	}
 	return json.Marshal(&o)
 }
 
@@ -83,14 +93,21 @@ func (db *database) UnmarshalJSON(data []byte) error {
 		db.policies[rp.Name] = rp
 	}
 
+	// Copy downsample policies.
+	db.downsamplePolicies = make(map[string]*DownsamplePolicy)
+	for _, dp := range o.DownsamplePolicies {
+		db.downsamplePolicies[dp.Name] = dp
+	}
+
 	return nil
 }
 
 // databaseJSON represents the JSON-serialization format for a database.
 type databaseJSON struct {
-	Name                   string             `json:"name,omitempty"`
-	DefaultRetentionPolicy string             `json:"defaultRetentionPolicy,omitempty"`
-	Policies               []*RetentionPolicy `json:"policies,omitempty"`
+	Name                   string              `json:"name,omitempty"`
+	DefaultRetentionPolicy string              `json:"defaultRetentionPolicy,omitempty"`
+	Policies               []*RetentionPolicy  `json:"policies,omitempty"`
+	DownsamplePolicies     []*DownsamplePolicy `json:"downsamplePolicies,omitempty"`
 }
 
 // Measurement represents a collection of time series in a database. It also contains in memory
@@ -122,10 +139,16 @@ func NewMeasurement(name string) *Measurement {
 }
 
 // createFieldIfNotExists creates a new field with an autoincrementing ID.
-// Returns an error if 255 fields have already been created on the measurement.
-func (m *Measurement) createFieldIfNotExists(name string, typ influxql.DataType) (*Field, error) {
-	// Ignore if the field already exists.
+// The creation time is passed in by the caller so that every replica applying
+// the same command records the same time.
+// Returns an error if 255 fields have already been created on the measurement
+// or if the field exists with a different type.
+func (m *Measurement) createFieldIfNotExists(name string, typ influxql.DataType, createdAt time.Time) (*Field, error) {
+	// Ignore if the field already exists with the same type.
 	if f := m.FieldByName(name); f != nil {
+		if typ != influxql.Unknown && f.Type != typ {
+			return nil, &FieldTypeConflictError{Measurement: m.Name, Field: f, Type: typ}
+		}
 		return f, nil
 	}
 
@@ -136,9 +159,10 @@ func (m *Measurement) createFieldIfNotExists(name string, typ influxql.DataType)
 
 	// Create and append a new field.
 	f := &Field{
-		ID:   uint8(len(m.Fields) + 1),
-		Name: name,
-		Type: typ,
+		ID:        uint8(len(m.Fields) + 1),
+		Name:      name,
+		Type:      typ,
+		CreatedAt: createdAt,
 	}
 	m.Fields = append(m.Fields, f)
 
@@ -201,6 +225,34 @@ func (m *Measurement) addSeries(s *Series) bool {
 	return true
 }
 
+// dropSeries removes a series from the measurement's index.
+// Tag values that no longer belong to any series are removed as well.
+func (m *Measurement) dropSeries(id uint32) {
+	s := m.seriesByID[id]
+	if s == nil {
+		return
+	}
+	delete(m.seriesByID, id)
+	delete(m.series, string(marshalTags(s.Tags)))
+	m.ids = m.ids.Reject(SeriesIDs{id})
+
+	// remove the series id from the tag index on the measurement
+	for k, v := range s.Tags {
+		valueMap := m.seriesByTagKeyValue[k]
+		if valueMap == nil {
+			continue
+		}
+		if ids := valueMap[v].Reject(SeriesIDs{id}); len(ids) > 0 {
+			valueMap[v] = ids
+		} else {
+			delete(valueMap, v)
+		}
+		if len(valueMap) == 0 {
+			delete(m.seriesByTagKeyValue, k)
+		}
+	}
+}
+
 // seriesByTags returns the Series that matches the given tagset.
 func (m *Measurement) seriesByTags(tags map[string]string) *Series {
 	return m.series[string(marshalTags(tags))]
@@ -253,6 +305,16 @@ func (m *Measurement) seriesIDs(filter *TagFilter) (ids SeriesIDs) {
 	return
 }
 
+// tagKeys returns the sorted tag keys of the measurement's series.
+func (m *Measurement) tagKeys() []string {
+	keys := make([]string, 0, len(m.seriesByTagKeyValue))
+	for k := range m.seriesByTagKeyValue {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // tagValues returns a map of unique tag values for the given key
 func (m *Measurement) tagValues(key string) TagValues {
 	tags := m.seriesByTagKeyValue[key]
@@ -263,13 +325,56 @@ func (m *Measurement) tagValues(key string) TagValues {
 	return TagValues(values)
 }
 
+// validateFieldTypes checks that each value matches the type of its existing field.
+// Values for fields that do not exist yet or that have an unknown type are ignored.
+func (m *Measurement) validateFieldTypes(values map[string]interface{}) error {
+	for k, v := range values {
+		f := m.FieldByName(k)
+		if f == nil {
+			continue
+		}
+		if typ := influxql.InspectDataType(v); typ != influxql.Unknown && typ != f.Type {
+			return &FieldTypeConflictError{Measurement: m.Name, Field: f, Type: typ}
+		}
+	}
+	return nil
+}
+
+// normalizeValues returns a copy of values with untyped numbers converted to
+// the type of their existing field. Numbers for integer fields become int64;
+// all other numbers become float64.
+func (m *Measurement) normalizeValues(values map[string]interface{}) map[string]interface{} {
+	other := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		var integer bool
+		if f := m.FieldByName(k); f != nil && f.Type == influxql.Integer {
+			integer = true
+		}
+
+		switch n := v.(type) {
+		case int:
+			if integer {
+				v = int64(n)
+			} else {
+				v = float64(n)
+			}
+		case json.Number:
+			if i, err := n.Int64(); err == nil && integer {
+				v = i
+			} else if f, err := n.Float64(); err == nil {
+				v = f
+			}
+		}
+		other[k] = v
+	}
+	return other
+}
+
 // mapValues converts a map of values with string keys to field id keys.
 // Returns nil if any field doesn't exist.
 func (m *Measurement) mapValues(values map[string]interface{}) map[uint8]interface{} {
 	other := make(map[uint8]interface{}, len(values))
 	for k, v := range values {
-		// TODO: Cast value to original field type.
-
 		f := m.FieldByName(k)
 		if f == nil {
 			return nil
@@ -283,9 +388,10 @@ type Measurements []*Measurement
 
 // Field represents a series field.
 type Field struct {
-	ID   uint8             `json:"id,omitempty"`
-	Name string            `json:"name,omitempty"`
-	Type influxql.DataType `json:"type,omitempty"`
+	ID        uint8             `json:"id,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Type      influxql.DataType `json:"type,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
 }
 
 // Fields represents a list of fields.
@@ -299,6 +405,55 @@ type Series struct {
 	measurement *Measurement
 }
 
+// matchExpr returns true if the series' tags satisfy an expression.
+// Only equality & inequality comparisons against tags combined with AND & OR are supported.
+func (s *Series) matchExpr(expr influxql.Expr) (bool, error) {
+	switch expr := expr.(type) {
+	case nil:
+		return true, nil
+	case *influxql.ParenExpr:
+		return s.matchExpr(expr.Expr)
+	case *influxql.BinaryExpr:
+		switch expr.Op {
+		case influxql.AND, influxql.OR:
+			lhs, err := s.matchExpr(expr.LHS)
+			if err != nil {
+				return false, err
+			}
+			rhs, err := s.matchExpr(expr.RHS)
+			if err != nil {
+				return false, err
+			}
+			if expr.Op == influxql.AND {
+				return lhs && rhs, nil
+			}
+			return lhs || rhs, nil
+
+		case influxql.EQ, influxql.NEQ:
+			// Allow the tag key on either side of the comparison.
+			ref, ok := expr.LHS.(*influxql.VarRef)
+			lit, lok := expr.RHS.(*influxql.StringLiteral)
+			if !ok || !lok {
+				ref, ok = expr.RHS.(*influxql.VarRef)
+				lit, lok = expr.LHS.(*influxql.StringLiteral)
+			}
+			if !ok || !lok {
+				return false, fmt.Errorf("invalid series condition: %s", expr)
+			}
+			return (s.Tags[ref.Val] == lit.Val) == (expr.Op == influxql.EQ), nil
+
+		case influxql.EQREGEX, influxql.NEQREGEX:
+			ref, ok := expr.LHS.(*influxql.VarRef)
+			re, rok := expr.RHS.(*influxql.RegexLiteral)
+			if !ok || !rok {
+				return false, fmt.Errorf("invalid series condition: %s", expr)
+			}
+			return re.Val.MatchString(s.Tags[ref.Val]) == (expr.Op == influxql.EQREGEX), nil
+		}
+	}
+	return false, fmt.Errorf("invalid series condition: %s", expr)
+}
+
 // match returns true if all tags match the series' tags.
 func (s *Series) match(tags map[string]string) bool {
 	for k, v := range tags {
@@ -309,6 +464,87 @@ func (s *Series) match(tags map[string]string) bool {
 	return true
 }
 
+// TagNormalizer represents rules for normalizing tag keys and values before
+// series are created. This prevents duplicate series that differ only in case
+// or in stray whitespace. Tags that normalize to the same key are merged and
+// keep the value of the first original key in sorted order.
+type TagNormalizer struct {
+	// Convert keys and values to lowercase.
+	Lowercase bool
+
+	// Remove leading and trailing whitespace from keys and values.
+	TrimSpace bool
+
+	// Maximum length of a key or value, in bytes. Longer strings are
+	// truncated and suffixed with a hash of the original string so that
+	// distinct strings remain distinct. Unlimited if zero.
+	MaxLength int
+}
+
+// Normalize returns a normalized copy of tags.
+// Returns tags unchanged if the normalizer is nil.
+func (n *TagNormalizer) Normalize(tags map[string]string) map[string]string {
+	if n == nil || len(tags) == 0 {
+		return tags
+	}
+
+	// Sort the original keys so that colliding keys resolve the same way
+	// for every point.
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	other := make(map[string]string, len(tags))
+	for _, k := range keys {
+		nk := n.normalize(k)
+		if _, ok := other[nk]; !ok {
+			other[nk] = n.normalize(tags[k])
+		}
+	}
+	return other
+}
+
+// normalize applies the rules to a single string.
+func (n *TagNormalizer) normalize(s string) string {
+	if n.TrimSpace {
+		s = strings.TrimSpace(s)
+	}
+	if n.Lowercase {
+		s = strings.ToLower(s)
+	}
+	if n.MaxLength > 0 && len(s) > n.MaxLength {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(s))
+		suffix := fmt.Sprintf("-%08x", h.Sum32())
+
+		// Truncate on a rune boundary to fit the suffix within the maximum length.
+		i := n.MaxLength - len(suffix)
+		if i < 0 {
+			i = 0
+		}
+		for i > 0 && !utf8.RuneStart(s[i]) {
+			i--
+		}
+		s = s[:i] + suffix
+	}
+	return s
+}
+
+// Pseudo tags can be used in GROUP BY and WHERE clauses to show where series
+// data is read from. They are computed at query time and are not stored.
+const (
+	RetentionPolicyPseudoTag = "_retentionPolicy"
+	ShardPseudoTag           = "_shard"
+	DataNodePseudoTag        = "_dataNode"
+)
+
+// isPseudoTag returns true if key is the name of a pseudo tag.
+func isPseudoTag(key string) bool {
+	return key == RetentionPolicyPseudoTag || key == ShardPseudoTag || key == DataNodePseudoTag
+}
+
 // RetentionPolicy represents a policy for creating new shards in a database and how long they're kept around for.
 type RetentionPolicy struct {
 	// Unique name within database. Required.
@@ -320,6 +556,14 @@ type RetentionPolicy struct {
 	// The number of copies to make of each shard.
 	ReplicaN uint32
 
+	// The number of shards per replica set in each shard group.
+	// Zero uses DefaultSplitN.
+	SplitN uint32
+
+	// The strategy used to assign series to the shards in a group.
+	// Empty uses ModuloShardHash.
+	ShardHash string
+
 	shardGroups []*ShardGroup
 }
 
@@ -332,6 +576,16 @@ func NewRetentionPolicy(name string) *RetentionPolicy {
 	}
 }
 
+// RetentionPolicyUpdate represents changes to a retention policy.
+// Nil fields leave the policy's value unchanged.
+type RetentionPolicyUpdate struct {
+	Name      *string
+	Duration  *time.Duration
+	ReplicaN  *uint32
+	SplitN    *uint32
+	ShardHash *string
+}
+
 // shardGroupByTimestamp returns the group in the policy that owns a timestamp.
 // Returns nil group does not exist.
 func (rp *RetentionPolicy) shardGroupByTimestamp(timestamp time.Time) *ShardGroup {
@@ -343,12 +597,45 @@ func (rp *RetentionPolicy) shardGroupByTimestamp(timestamp time.Time) *ShardGrou
 	return nil
 }
 
+// removeShardGroupByID removes a group from the policy by id.
+// Returns the removed group or nil if the group does not exist.
+func (rp *RetentionPolicy) removeShardGroupByID(id uint64) *ShardGroup {
+	for i, g := range rp.shardGroups {
+		if g.ID == id {
+			rp.shardGroups = append(rp.shardGroups[:i], rp.shardGroups[i+1:]...)
+			return g
+		}
+	}
+	return nil
+}
+
+// expiredShardGroups returns the groups in the policy that ended before the
+// policy's duration as of now. Returns nil if the policy keeps data forever.
+func (rp *RetentionPolicy) expiredShardGroups(now time.Time) (a []*ShardGroup) {
+	if rp.Duration <= 0 {
+		return nil
+	}
+	for _, g := range rp.shardGroups {
+		if rp.expired(g, now) {
+			a = append(a, g)
+		}
+	}
+	return
+}
+
+// expired returns true if a group ended before the policy's duration as of now.
+func (rp *RetentionPolicy) expired(g *ShardGroup, now time.Time) bool {
+	return rp.Duration > 0 && g.EndTime.Before(now.Add(-rp.Duration))
+}
+
 // MarshalJSON encodes a retention policy to a JSON-encoded byte slice.
 func (rp *RetentionPolicy) MarshalJSON() ([]byte, error) {
 	var o retentionPolicyJSON
 	o.Name = rp.Name
 	o.Duration = rp.Duration
 	o.ReplicaN = rp.ReplicaN
+	o.SplitN = rp.SplitN
+	o.ShardHash = rp.ShardHash
 	for _, g := range rp.shardGroups {
 		o.ShardGroups = append(o.ShardGroups, g)
 	}
@@ -366,6 +653,8 @@ func (rp *RetentionPolicy) UnmarshalJSON(data []byte) error {
 	// Copy over properties from intermediate type.
 	rp.Name = o.Name
 	rp.ReplicaN = o.ReplicaN
+	rp.SplitN = o.SplitN
+	rp.ShardHash = o.ShardHash
 	rp.Duration = o.Duration
 	rp.shardGroups = o.ShardGroups
 
@@ -377,10 +666,168 @@ type retentionPolicyJSON struct {
 	Name        string        `json:"name"`
 	ReplicaN    uint32        `json:"replicaN,omitempty"`
 	SplitN      uint32        `json:"splitN,omitempty"`
+	ShardHash   string        `json:"shardHash,omitempty"`
 	Duration    time.Duration `json:"duration,omitempty"`
 	ShardGroups []*ShardGroup `json:"shardGroups,omitempty"`
 }
 
+// DownsamplePolicy represents a rollup of data from one retention policy into another.
+// The server compiles each policy into one continuous query per measurement.
+type DownsamplePolicy struct {
+	// Unique name within database. Required.
+	Name string `json:"name"`
+
+	// Retention policies to read from and write into.
+	Source string `json:"source"`
+	Target string `json:"target"`
+
+	// Width of each aggregated interval.
+	Interval time.Duration `json:"interval"`
+
+	// Aggregate function by field name. The "*" key applies to all other fields.
+	Aggregates map[string]string `json:"aggregates"`
+}
+
+// downsamplePolicies represents a list of downsample policies sortable by name.
+type downsamplePolicies []*DownsamplePolicy
+
+func (p downsamplePolicies) Len() int           { return len(p) }
+func (p downsamplePolicies) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p downsamplePolicies) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// continuousQuery returns the continuous query that downsamples a measurement.
+// Returns nil if no aggregates apply to the measurement's fields.
+func (dp *DownsamplePolicy) continuousQuery(database string, m *Measurement) *influxql.CreateContinuousQueryStatement {
+	stmt := &influxql.SelectStatement{
+		Target: &influxql.Target{Measurement: influxql.QuoteIdent([]string{dp.Target, m.Name}), Database: database},
+		Source: &influxql.Measurement{Name: influxql.QuoteIdent([]string{database, dp.Source, m.Name})},
+		Dimensions: influxql.Dimensions{{
+			Expr: &influxql.Call{Name: "time", Args: []influxql.Expr{&influxql.DurationLiteral{Val: dp.Interval}}},
+		}},
+	}
+
+	// Aggregate each field using its own function or the wildcard function.
+	for _, f := range m.Fields {
+		fn := dp.Aggregates[f.Name]
+		if fn == "" {
+			fn = dp.Aggregates["*"]
+		}
+		if fn == "" {
+			continue
+		}
+
+		stmt.Fields = append(stmt.Fields, &influxql.Field{
+			Expr:  &influxql.Call{Name: fn, Args: []influxql.Expr{&influxql.VarRef{Val: f.Name}}},
+			Alias: f.Name,
+		})
+	}
+	if len(stmt.Fields) == 0 {
+		return nil
+	}
+
+	return &influxql.CreateContinuousQueryStatement{
+		Name:     dp.Name + "_" + m.Name,
+		Database: database,
+		Source:   stmt,
+	}
+}
+
+// downsampleQueries returns the continuous queries compiled from the downsample
+// policies of the database, one per policy and measurement, sorted by policy.
+func (db *database) downsampleQueries(database string) []*influxql.CreateContinuousQueryStatement {
+	policies := make(downsamplePolicies, 0, len(db.downsamplePolicies))
+	for _, dp := range db.downsamplePolicies {
+		policies = append(policies, dp)
+	}
+	sort.Sort(policies)
+
+	var a []*influxql.CreateContinuousQueryStatement
+	for _, dp := range policies {
+		for _, name := range db.names {
+			if stmt := dp.continuousQuery(database, db.measurements[name]); stmt != nil {
+				a = append(a, stmt)
+			}
+		}
+	}
+	return a
+}
+
+// rollupFunctions are the aggregates that can be recomputed from their own
+// results over wider intervals. Means of means are approximate when the
+// rolled up intervals hold different numbers of points.
+var rollupFunctions = map[string]bool{"sum": true, "min": true, "max": true, "mean": true, "first": true, "last": true}
+
+// downsampleTarget returns the retention policy of the coarsest downsample
+// policy of rp that can answer a statement in its place. The statement must
+// group by a multiple of the rollup interval and aggregate every field with
+// the function the rollup used. Rollups don't keep tags so statements that
+// filter or group by tags aren't answered from them. Policies whose rollup
+// doesn't cover the statement's time range, as reported by covers, are skipped.
+// Returns an empty string if no downsample policy can be used.
+func (db *database) downsampleTarget(rp string, stmt *influxql.SelectStatement, covers func(*DownsamplePolicy) bool) string {
+	interval := stmt.GroupByInterval()
+	if interval <= 0 || len(stmt.Dimensions) != 1 {
+		return ""
+	}
+
+	// Only time can be referenced by the condition.
+	var tagged bool
+	influxql.WalkFunc(stmt.Condition, func(n influxql.Node) {
+		if ref, ok := n.(*influxql.VarRef); ok && strings.ToLower(ref.Val) != "time" {
+			tagged = true
+		}
+	})
+	if tagged {
+		return ""
+	}
+
+	// Every field must be a single aggregate of a field.
+	calls := make([]*influxql.Call, len(stmt.Fields))
+	for i, f := range stmt.Fields {
+		call, ok := f.Expr.(*influxql.Call)
+		if !ok || len(call.Args) != 1 {
+			return ""
+		} else if _, ok := call.Args[0].(*influxql.VarRef); !ok {
+			return ""
+		}
+		calls[i] = call
+	}
+
+	var target *DownsamplePolicy
+	for _, dp := range db.downsamplePolicies {
+		if dp.Source != rp || db.policies[dp.Target] == nil || dp.Interval <= 0 || interval%dp.Interval != 0 {
+			continue
+		} else if !dp.aggregates(calls) || !covers(dp) {
+			continue
+		}
+
+		// Prefer the coarsest rollup, breaking ties by name.
+		if target == nil || dp.Interval > target.Interval || (dp.Interval == target.Interval && dp.Name < target.Name) {
+			target = dp
+		}
+	}
+	if target == nil {
+		return ""
+	}
+	return target.Target
+}
+
+// aggregates returns true if the policy rolls up each call's field with the
+// call's function and the function can be rolled up again.
+func (dp *DownsamplePolicy) aggregates(calls []*influxql.Call) bool {
+	for _, call := range calls {
+		field := call.Args[0].(*influxql.VarRef).Val
+		fn := dp.Aggregates[field]
+		if fn == "" {
+			fn = dp.Aggregates["*"]
+		}
+		if !strings.EqualFold(fn, call.Name) || !rollupFunctions[strings.ToLower(fn)] {
+			return false
+		}
+	}
+	return true
+}
+
 // TagFilter represents a tag filter when looking up other tags or measurements.
 type TagFilter struct {
 	Not   bool
@@ -515,6 +962,18 @@ func (d *database) addSeriesToIndex(measurementName string, s *Series) bool {
 	return idx.addSeries(s)
 }
 
+// replaceIndex replaces the in-memory index with the index of other. Fields
+// are carried over to measurements that still exist since they are not
+// rebuilt from the series.
+func (d *database) replaceIndex(other *database) {
+	for name, m := range other.measurements {
+		if prev := d.measurements[name]; prev != nil {
+			m.Fields = prev.Fields
+		}
+	}
+	d.measurements, d.series, d.names = other.measurements, other.series, other.names
+}
+
 // createMeasurementIfNotExists will either add a measurement object to the index or return the existing one.
 func (d *database) createMeasurementIfNotExists(name string) *Measurement {
 	idx := d.measurements[name]
@@ -565,6 +1024,137 @@ func (d *database) SeriesIDs(names []string, filters []*TagFilter) SeriesIDs {
 	return ids
 }
 
+// sourceNames returns the measurement names referenced by a query source.
+// Returns all measurement names in the database if source is nil.
+func (d *database) sourceNames(source influxql.Source) ([]string, error) {
+	switch source := source.(type) {
+	case nil:
+		return d.names, nil
+	case *influxql.Measurement:
+		if source.Regex != nil {
+			return d.measurementNamesByRegex(source.Regex.Val), nil
+		}
+		return []string{source.Name}, nil
+	case influxql.Measurements:
+		names := make([]string, 0, len(source))
+		for _, m := range source {
+			if m.Regex != nil {
+				names = append(names, d.measurementNamesByRegex(m.Regex.Val)...)
+				continue
+			}
+			names = append(names, m.Name)
+		}
+		return names, nil
+	case *influxql.Join:
+		return d.sourceNames(source.Measurements)
+	case *influxql.Merge:
+		return d.sourceNames(source.Measurements)
+	default:
+		return nil, fmt.Errorf("invalid source: %s", source)
+	}
+}
+
+// measurementNamesByRegex returns the sorted measurement names matching re.
+func (d *database) measurementNamesByRegex(re *regexp.Regexp) []string {
+	var names []string
+	for _, name := range d.names {
+		if re.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// selectCost estimates the cost of a select statement as the number of shards
+// it reads multiplied by the number of series and the number of time buckets.
+// Statements without a lower time bound are estimated from the start of the
+// oldest shard group. The default retention policy is used if rp is blank.
+// The condition must already be folded and not yet planned.
+func (d *database) selectCost(stmt *influxql.SelectStatement, rp string, now time.Time) (*QueryCost, error) {
+	cost := &QueryCost{Buckets: 1}
+
+	// Determine the time range.
+	min, max := influxql.TimeRange(stmt.Condition)
+	if max.IsZero() {
+		max = now
+	}
+
+	// Count shards in the groups overlapping the time range.
+	if rp == "" {
+		rp = d.defaultRetentionPolicy
+	}
+	if rp := d.policies[rp]; rp != nil {
+		start := max
+		for _, g := range rp.shardGroups {
+			if g.EndTime.Before(min) || g.StartTime.After(max) {
+				continue
+			}
+			cost.Shards += int64(len(g.Shards))
+			if g.StartTime.Before(start) {
+				start = g.StartTime
+			}
+		}
+		if min.IsZero() || min.Before(start) {
+			min = start
+		}
+	}
+
+	// Count series matching the tags in the condition. Series are counted if
+	// the condition cannot be evaluated against tags alone.
+	names, err := d.sourceNames(stmt.Source)
+	if err != nil {
+		return nil, err
+	}
+	expr := tagExpr(stmt.Condition)
+	for _, name := range names {
+		m := d.measurements[name]
+		if m == nil {
+			continue
+		}
+		for _, series := range m.seriesByID {
+			if ok, err := series.matchExpr(expr); ok || err != nil {
+				cost.Series++
+			}
+		}
+	}
+
+	// Count the time buckets in the range.
+	if interval := stmt.GroupByInterval(); interval > 0 && max.After(min) {
+		cost.Buckets = int64((max.Sub(min) + interval - 1) / interval)
+	}
+
+	return cost, nil
+}
+
+// tagExpr returns an expression with all comparisons against time removed.
+// Returns nil if only time comparisons remain.
+func tagExpr(expr influxql.Expr) influxql.Expr {
+	switch expr := expr.(type) {
+	case *influxql.ParenExpr:
+		if e := tagExpr(expr.Expr); e != nil {
+			return &influxql.ParenExpr{Expr: e}
+		}
+		return nil
+	case *influxql.BinaryExpr:
+		switch expr.Op {
+		case influxql.AND, influxql.OR:
+			lhs, rhs := tagExpr(expr.LHS), tagExpr(expr.RHS)
+			if lhs == nil {
+				return rhs
+			} else if rhs == nil {
+				return lhs
+			}
+			return &influxql.BinaryExpr{Op: expr.Op, LHS: lhs, RHS: rhs}
+		}
+		for _, e := range []influxql.Expr{expr.LHS, expr.RHS} {
+			if ref, ok := e.(*influxql.VarRef); ok && strings.ToLower(ref.Val) == "time" {
+				return nil
+			}
+		}
+	}
+	return expr
+}
+
 // TagKeys returns a sorted array of unique tag keys for the given measurements.
 // If an empty or nil slice is passed in, the tag keys for the entire database will be returned.
 func (d *database) TagKeys(names []string) []string {
@@ -717,7 +1307,14 @@ func (d *database) Names() []string {
 
 // DropSeries will clear the index of all references to a series.
 func (d *database) DropSeries(id uint32) {
-	panic("not implemented")
+	s := d.series[id]
+	if s == nil {
+		return
+	}
+	if s.measurement != nil {
+		s.measurement.dropSeries(id)
+	}
+	delete(d.series, id)
 }
 
 // DropMeasurement will clear the index of all references to a measurement and its child series.
@@ -742,9 +1339,89 @@ func marshalTags(tags map[string]string) []byte {
 }
 
 // dbi is an interface the query engine uses to communicate with the database during planning.
+//
+// Each statement reads a local shard through a single read transaction that
+// is started the first time the statement reads the shard and is held until
+// the statement finishes. Every series in the shard is read from the same
+// snapshot so writes that arrive while the statement runs are not visible.
+// The snapshot stays readable if the shard is compacted or deleted before
+// the statement finishes.
 type dbi struct {
 	server *Server
 	db     *database
+	rp     string // retention policy, blank for the default
+
+	group *ShardGroup // shard group the shard pseudo tags are read from
+
+	mu      sync.Mutex
+	txs     map[uint64]StorageTx            // snapshot of each local shard, by shard id
+	remotes map[remoteShardKey]*remoteShard // reads of each remote shard
+}
+
+// remoteShardKey identifies a read of a remote shard over a time range.
+type remoteShardKey struct {
+	shardID  uint64
+	min, max int64
+}
+
+// remote returns the statement's reader for a shard stored on other data
+// nodes so that the series read from it are fetched together.
+// Must be called while holding the snapshot lock and the server's read lock.
+func (dbi *dbi) remote(sh *Shard, min, max int64) *remoteShard {
+	key := remoteShardKey{shardID: sh.ID, min: min, max: max}
+	if r := dbi.remotes[key]; r != nil {
+		return r
+	}
+
+	r := dbi.server.newRemoteShard(sh, min, max)
+	if dbi.remotes == nil {
+		dbi.remotes = make(map[remoteShardKey]*remoteShard)
+	}
+	dbi.remotes[key] = r
+	return r
+}
+
+// begin returns the statement's snapshot of a local shard.
+// Must be called while holding the snapshot lock.
+func (dbi *dbi) begin(sh *Shard) (StorageTx, error) {
+	if tx := dbi.txs[sh.ID]; tx != nil {
+		return tx, nil
+	}
+
+	tx, err := sh.begin()
+	if err != nil {
+		return nil, err
+	}
+	if dbi.txs == nil {
+		dbi.txs = make(map[uint64]StorageTx)
+	}
+	dbi.txs[sh.ID] = tx
+	return tx, nil
+}
+
+// Close releases the statement's shard snapshots and remote shard reads.
+func (dbi *dbi) Close() error {
+	dbi.mu.Lock()
+	defer dbi.mu.Unlock()
+
+	var err error
+	for id, tx := range dbi.txs {
+		if e := tx.Rollback(); e != nil && err == nil {
+			err = e
+		}
+		delete(dbi.txs, id)
+	}
+	dbi.remotes = nil
+	return err
+}
+
+// policy returns the name of the retention policy being read and the policy.
+func (dbi *dbi) policy() (string, *RetentionPolicy) {
+	name := dbi.rp
+	if name == "" {
+		name = dbi.db.defaultRetentionPolicy
+	}
+	return name, dbi.db.policies[name]
 }
 
 // MatchSeries returns a list of series data ids matching a name and tags.
@@ -755,12 +1432,29 @@ func (dbi *dbi) MatchSeries(name string, tags map[string]string) (a []uint32) {
 		return nil
 	}
 
+	// Separate pseudo tags from the tags stored on the series.
+	filters, pseudo := make(map[string]string), make(map[string]string)
+	for k, v := range tags {
+		if isPseudoTag(k) {
+			pseudo[k] = v
+		} else {
+			filters[k] = v
+		}
+	}
+
 	// Match each series on the measurement by tagset.
 	// TODO: Use paul's fancy index.
+loop:
 	for _, s := range m.seriesByID {
-		if s.match(tags) {
-			a = append(a, s.ID)
+		if !s.match(filters) {
+			continue
+		}
+		for k, v := range pseudo {
+			if dbi.pseudoTagValue(s.ID, k) != v {
+				continue loop
+			}
 		}
+		a = append(a, s.ID)
 	}
 	return
 }
@@ -773,11 +1467,81 @@ func (dbi *dbi) SeriesTagValues(seriesID uint32, keys []string) []string {
 	// Lookup value for each key.
 	values := make([]string, len(keys))
 	for i, key := range keys {
-		values[i] = s.Tags[key]
+		if isPseudoTag(key) {
+			values[i] = dbi.pseudoTagValue(seriesID, key)
+		} else {
+			values[i] = s.Tags[key]
+		}
 	}
 	return values
 }
 
+// TagKeys returns the sorted tag keys of a measurement.
+func (dbi *dbi) TagKeys(name string) []string {
+	m := dbi.db.measurements[name]
+	if m == nil {
+		return nil
+	}
+	return m.tagKeys()
+}
+
+// pseudoTagValue returns the value of a pseudo tag for a series.
+// The shard is resolved in the statement's shard group, the same way
+// CreateIterator resolves it.
+func (dbi *dbi) pseudoTagValue(seriesID uint32, key string) string {
+	name, _ := dbi.policy()
+	if key == RetentionPolicyPseudoTag {
+		return name
+	} else if dbi.group == nil {
+		return ""
+	}
+
+	sh := dbi.group.ShardBySeriesID(seriesID)
+	switch key {
+	case ShardPseudoTag:
+		return strconv.FormatUint(sh.ID, 10)
+	case DataNodePseudoTag:
+		ids := make([]string, len(sh.DataNodeIDs))
+		for i, id := range sh.DataNodeIDs {
+			ids[i] = strconv.FormatUint(id, 10)
+		}
+		return strings.Join(ids, ",")
+	}
+	return ""
+}
+
+// setShardGroup sets the shard group used by the shard pseudo tags to the
+// one group of the retention policy that overlaps the statement's time range.
+// Returns ErrShardPseudoTagTimeRange if more than one group overlaps.
+func (dbi *dbi) setShardGroup(min, max time.Time) error {
+	_, rp := dbi.policy()
+	if rp == nil {
+		return nil
+	}
+	for _, g := range rp.shardGroups {
+		if !g.StartTime.After(max) && (min.IsZero() || !g.EndTime.Before(min)) {
+			if dbi.group != nil {
+				return ErrShardPseudoTagTimeRange
+			}
+			dbi.group = g
+		}
+	}
+	return nil
+}
+
+// usesShardPseudoTags returns true if a statement groups or filters by the
+// _shard or _dataNode pseudo tags.
+func usesShardPseudoTags(stmt *influxql.SelectStatement) (ok bool) {
+	fn := func(n influxql.Node) {
+		if ref, isRef := n.(*influxql.VarRef); isRef && (ref.Val == ShardPseudoTag || ref.Val == DataNodePseudoTag) {
+			ok = true
+		}
+	}
+	influxql.WalkFunc(stmt.Dimensions, fn)
+	influxql.WalkFunc(stmt.Condition, fn)
+	return
+}
+
 // Field returns the id and data type for a series field.
 // Returns id of zero if not a field.
 func (dbi *dbi) Field(name, field string) (fieldID uint8, typ influxql.DataType) {
@@ -798,8 +1562,6 @@ func (dbi *dbi) Field(name, field string) (fieldID uint8, typ influxql.DataType)
 
 // CreateIterator returns an iterator to iterate over the field values in a series.
 func (dbi *dbi) CreateIterator(seriesID uint32, fieldID uint8, typ influxql.DataType, min, max time.Time, interval time.Duration) influxql.Iterator {
-	// TODO: Add retention policy to the arguments.
-
 	// Create an iterator to hold the transaction and series ids.
 	itr := &iterator{
 		seriesID: seriesID,
@@ -807,6 +1569,7 @@ func (dbi *dbi) CreateIterator(seriesID uint32, fieldID uint8, typ influxql.Data
 		typ:      typ,
 		imin:     -1,
 		interval: int64(interval),
+		now:      dbi.server.clock.Now().UnixNano(),
 	}
 	if !min.IsZero() {
 		itr.min = min.UnixNano()
@@ -815,37 +1578,58 @@ func (dbi *dbi) CreateIterator(seriesID uint32, fieldID uint8, typ influxql.Data
 		itr.max = max.UnixNano()
 	}
 
+	dbi.server.mu.RLock()
+	defer dbi.server.mu.RUnlock()
+
 	// Retrieve the policy.
 	// Ignore if there are no shard groups created on the retention policy.
-	rp := dbi.db.policies[dbi.db.defaultRetentionPolicy]
-	if len(rp.shardGroups) == 0 {
+	_, rp := dbi.policy()
+	if rp == nil || len(rp.shardGroups) == 0 {
 		return itr
 	}
 
-	// Find all shards which match the the time range and series id.
-	// TODO: Support multiple groups.
-	g := rp.shardGroups[0]
-
-	// Ignore shard groups that our time range does not cross.
-	if !timeBetweenInclusive(g.StartTime, min, max) &&
-		!timeBetweenInclusive(g.EndTime, min, max) {
-		return itr
+	// Find the shard groups that our time range crosses, in time order.
+	var groups shardGroups
+	for _, g := range rp.shardGroups {
+		if !g.StartTime.After(max) && (min.IsZero() || !g.EndTime.Before(min)) {
+			groups = append(groups, g)
+		}
 	}
+	sort.Sort(groups)
+
+	// Read the series from its shard in each group. Shards stored on other
+	// data nodes are fetched from their owners when the iterator starts,
+	// together with the statement's other series in the same shard.
+	var cursors []StorageCursor
+	for _, g := range groups {
+		sh := g.ShardBySeriesID(seriesID)
+		if sh.store == nil {
+			dbi.mu.Lock()
+			c := dbi.remote(sh, itr.min, itr.max).cursor(seriesID)
+			dbi.mu.Unlock()
+			cursors = append(cursors, c)
+			continue
+		}
 
-	// Find appropriate shard by series id.
-	sh := g.ShardBySeriesID(seriesID)
-
-	// Open a transaction on the shard.
-	tx, err := sh.store.Begin(false)
-	assert(err == nil, "read-only tx error: %s", err)
-	itr.tx = tx
+		// Read from the statement's snapshot of the local shard.
+		dbi.mu.Lock()
+		tx, err := dbi.begin(sh)
+		assert(err == nil, "read-only tx error: %s", err)
+		cur := tx.Cursor(seriesID)
+		dbi.mu.Unlock()
+		if cur != nil {
+			cursors = append(cursors, cur)
+		}
+	}
 
-	// Open and position cursor.
-	b := tx.Bucket(u32tob(seriesID))
-	if b != nil {
-		cur := b.Cursor()
-		itr.k, itr.v = cur.Seek(u64tob(uint64(itr.min)))
-		itr.cur = cur
+	// The cursor is positioned on the first interval.
+	itr.cursors = cursors
+	switch len(cursors) {
+	case 0:
+	case 1:
+		itr.cur = cursors[0]
+	default:
+		itr.cur = &chainCursor{cursors: cursors}
 	}
 
 	return itr
@@ -854,23 +1638,32 @@ func (dbi *dbi) CreateIterator(seriesID uint32, fieldID uint8, typ influxql.Data
 // iterator represents a series data iterator for a shard.
 // It can iterate over all data for a given time range for multiple series in a shard.
 type iterator struct {
-	tx       *bolt.Tx
-	cur      *bolt.Cursor
+	cursors  []StorageCursor // shard cursors, checked for read errors
+	cur      StorageCursor
 	seriesID uint32
 	fieldID  uint8
 	typ      influxql.DataType
 
-	k, v []byte // lookahead buffer
+	k int64  // lookahead buffer
+	v []byte // lookahead buffer, nil when empty
 
 	min, max   int64 // time range
 	imin, imax int64 // interval time range
 	interval   int64 // interval duration
+	now        int64 // values that expired by now are skipped
+
+	err error // error decoding local values
 }
 
-// close closes the iterator.
-func (i *iterator) Close() error {
-	if i.tx != nil {
-		return i.tx.Rollback()
+// Err returns the first error from decoding values or reading a shard.
+func (i *iterator) Err() error {
+	if i.err != nil {
+		return i.err
+	}
+	for _, c := range i.cursors {
+		if err := cursorErr(c); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -880,22 +1673,27 @@ func (i *iterator) Next() (key int64, value interface{}) {
 	for {
 		// Read raw key/value from lookhead buffer, if available.
 		// Otherwise read from cursor.
-		var k, v []byte
-		if i.k != nil {
+		var k int64
+		var v []byte
+		if i.v != nil {
 			k, v = i.k, i.v
-			i.k, i.v = nil, nil
+			i.k, i.v = 0, nil
 		} else if i.cur != nil {
 			k, v = i.cur.Next()
 		}
 
 		// Exit at the end of the cursor.
-		if k == nil {
+		if v == nil {
 			return 0, nil
 		}
 
 		// Extract timestamp & field value.
-		key = int64(btou64(k))
-		value = unmarshalValue(v, i.fieldID)
+		key = k
+		value, i.err = unmarshalValue(v, i.fieldID)
+		if i.err != nil {
+			i.cur = nil
+			return 0, nil
+		}
 
 		// If timestamp is beyond interval time range then push onto lookahead buffer.
 		if key >= i.imax && i.imax != 0 {
@@ -903,10 +1701,12 @@ func (i *iterator) Next() (key int64, value interface{}) {
 			return 0, nil
 		}
 
-		// Return value if it is non-nil.
+		// Return value if it is non-nil and has not expired.
 		// Otherwise loop again and try the next point.
 		if value != nil {
-			return
+			if expires := unmarshalExpiry(v); expires == 0 || expires > i.now {
+				return
+			}
 		}
 	}
 }
@@ -919,6 +1719,12 @@ func (i *iterator) NextIterval() bool {
 	// Initialize or move interval forward.
 	if i.imin == -1 { // initialize first interval
 		i.imin = i.min
+
+		// Position the cursor. Remote shards are fetched here so that
+		// iterators started together read them concurrently.
+		if i.cur != nil {
+			i.k, i.v = i.cur.SeekTo(i.min)
+		}
 	} else if i.interval != 0 && (i.max == 0 || imin < i.max) { // move forward
 		i.imin = imin
 	} else { // no interval or beyond max time.
@@ -119,6 +119,27 @@ func TestDatabase_MeasurementAndSeries(t *testing.T) {
 	}
 }
 
+// Ensure tags can be normalized by case, whitespace and length.
+func TestTagNormalizer_Normalize(t *testing.T) {
+	for i, tt := range []struct {
+		n    *TagNormalizer
+		tags map[string]string
+		exp  map[string]string
+	}{
+		{n: nil, tags: map[string]string{" Host": "ServerA "}, exp: map[string]string{" Host": "ServerA "}},
+		{n: &TagNormalizer{Lowercase: true}, tags: map[string]string{"Host": "ServerA"}, exp: map[string]string{"host": "servera"}},
+		{n: &TagNormalizer{TrimSpace: true}, tags: map[string]string{" Host": "ServerA\t"}, exp: map[string]string{"Host": "ServerA"}},
+		{n: &TagNormalizer{Lowercase: true, TrimSpace: true}, tags: map[string]string{"host": "servera", " HOST ": "servera"}, exp: map[string]string{"host": "servera"}},
+		{n: &TagNormalizer{Lowercase: true}, tags: map[string]string{"host": "servera", "HOST": "serverb", "Host": "serverc"}, exp: map[string]string{"host": "serverb"}},
+		{n: &TagNormalizer{MaxLength: 16}, tags: map[string]string{"path": "/usr/local/bin/influxd"}, exp: map[string]string{"path": "/usr/lo-e8849c35"}},
+		{n: &TagNormalizer{MaxLength: 16}, tags: map[string]string{"path": "/usr/local"}, exp: map[string]string{"path": "/usr/local"}},
+	} {
+		if tags := tt.n.Normalize(tt.tags); !reflect.DeepEqual(tt.exp, tags) {
+			t.Errorf("%d. unexpected tags: %v", i, tags)
+		}
+	}
+}
+
 // Ensure that we can get the series IDs for measurements without any filters.
 func TestDatabase_SeriesIDs(t *testing.T) {
 	idx := newDatabase()
@@ -422,7 +443,16 @@ func TestDatabase_TagValuesWhereTagFilter(t *testing.T) {
 }
 
 func TestDatabase_DropSeries(t *testing.T) {
-	t.Skip("pending")
+	idx := databaseWithFixtureData()
+	idx.DropSeries(3)
+
+	if idx.SeriesByID(3) != nil {
+		t.Fatal("series not removed")
+	} else if ids := idx.SeriesIDs([]string{"key_count"}, nil); !ids.Equals(SeriesIDs{1, 2, 4, 5, 6, 7, 8}) {
+		t.Fatalf("unexpected series ids: %v", ids)
+	} else if v := idx.TagValues([]string{"key_count"}, "host", nil).ToSlice(); !reflect.DeepEqual(v, []string{"serverd.influx.com"}) {
+		t.Fatalf("unexpected tag values: %v", v)
+	}
 }
 
 func TestDatabase_DropMeasurement(t *testing.T) {
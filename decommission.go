@@ -0,0 +1,172 @@
+package influxdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// ShardMove represents a shard that is moved off of a decommissioned data node.
+// To is zero if the shard's other owners keep it and no data node is added.
+type ShardMove struct {
+	ShardID uint64 `json:"shardID"`
+	From    uint64 `json:"from"`
+	To      uint64 `json:"to,omitempty"`
+}
+
+// DecommissionDataNode moves every shard owned by a data node to the remaining
+// data nodes and then deletes the data node. Each shard is assigned to the
+// least loaded data node that doesn't already own it. The new owner copies the
+// shard's data from its replicas before the decommissioned node is removed
+// from the shard's owners. Returns the shards moved before the first error.
+func (s *Server) DecommissionDataNode(id uint64) ([]*ShardMove, error) {
+	moves, err := s.planDecommission(id)
+	if err != nil {
+		return nil, err
+	}
+
+	a := make([]*ShardMove, 0, len(moves))
+	for _, mv := range moves {
+		if err := s.moveShard(mv); err != nil {
+			return a, fmt.Errorf("shard(%d): %s", mv.ShardID, err)
+		}
+		a = append(a, mv)
+	}
+
+	// Remove the node once it no longer owns any shards.
+	if err := s.DeleteDataNode(id); err != nil {
+		return a, err
+	}
+	return a, nil
+}
+
+// planDecommission determines the new owner of each shard owned by a data node.
+func (s *Server) planDecommission(id uint64) ([]*ShardMove, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.dataNodes[id] == nil {
+		return nil, ErrDataNodeNotFound
+	}
+
+	// Count the shards owned by each remaining node. Standby nodes take over
+	// shards through promotion instead.
+	load := make(map[uint64]int)
+	for nodeID, n := range s.dataNodes {
+		if nodeID != id && !n.Standby {
+			load[nodeID] = 0
+		}
+	}
+	var ids []uint64
+	for shardID, sh := range s.shards {
+		for _, nodeID := range sh.DataNodeIDs {
+			if _, ok := load[nodeID]; ok {
+				load[nodeID]++
+			}
+		}
+		if sh.HasDataNodeID(id) {
+			ids = append(ids, shardID)
+		}
+	}
+	sort.Sort(uint64Slice(ids))
+
+	// Assign each shard to the node with the fewest shards, by lowest id.
+	var moves []*ShardMove
+	for _, shardID := range ids {
+		sh := s.shards[shardID]
+		mv := &ShardMove{ShardID: shardID, From: id}
+		for nodeID, n := range load {
+			if sh.HasDataNodeID(nodeID) {
+				continue
+			}
+			if mv.To == 0 || n < load[mv.To] || (n == load[mv.To] && nodeID < mv.To) {
+				mv.To = nodeID
+			}
+		}
+
+		// A shard can only be left without a new owner if it has other owners.
+		if mv.To == 0 && len(sh.DataNodeIDs) < 2 {
+			return nil, ErrNoReplacementDataNode
+		} else if mv.To != 0 {
+			load[mv.To]++
+		}
+		moves = append(moves, mv)
+	}
+	return moves, nil
+}
+
+// moveShard adds the new owner to a shard, copies the shard's data to it and
+// then removes the old owner.
+func (s *Server) moveShard(mv *ShardMove) error {
+	s.mu.RLock()
+	sh := s.shards[mv.ShardID]
+	if sh == nil {
+		s.mu.RUnlock()
+		return ErrShardNotFound
+	}
+	owners := make([]uint64, len(sh.DataNodeIDs))
+	copy(owners, sh.DataNodeIDs)
+	s.mu.RUnlock()
+
+	// Add the new owner and copy the data from the existing owners.
+	if mv.To != 0 {
+		index, err := s.SetShardOwners(mv.ShardID, append(owners, mv.To))
+		if err != nil {
+			return err
+		}
+		if err := s.repairShardOn(mv.To, mv.ShardID, index); err != nil {
+			return fmt.Errorf("copy: %s", err)
+		}
+	}
+
+	// Remove the old owner.
+	var a []uint64
+	for _, nodeID := range owners {
+		if nodeID != mv.From {
+			a = append(a, nodeID)
+		}
+	}
+	if mv.To != 0 {
+		a = append(a, mv.To)
+	}
+	_, err := s.SetShardOwners(mv.ShardID, a)
+	return err
+}
+
+// repairShardOn repairs a shard on a data node once the node has applied the
+// broadcast message at index.
+func (s *Server) repairShardOn(nodeID, shardID, index uint64) error {
+	s.mu.RLock()
+	n := s.dataNodes[nodeID]
+	local := nodeID == s.id
+	client := s.peerClient()
+	s.mu.RUnlock()
+
+	if n == nil {
+		return ErrDataNodeNotFound
+	} else if local {
+		_, err := s.RepairShard(shardID)
+		return err
+	}
+
+	// Ask the remote node to run the repair.
+	u := copyURL(n.URL)
+	u.Path = "/repair"
+	u.RawQuery = url.Values{
+		"shard": {strconv.FormatUint(shardID, 10)},
+		"index": {strconv.FormatUint(index, 10)},
+	}.Encode()
+	resp, err := client.Post(u.String(), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: unexpected status: %s: %s", n.URL, resp.Status, b)
+	}
+	return nil
+}
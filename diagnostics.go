@@ -0,0 +1,132 @@
+package influxdb
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// InputStats represents the state of an input service that receives data
+// over another protocol, such as Graphite or collectd. Input services record
+// what they receive so the server can report it in its diagnostics.
+// Updates to a nil *InputStats are ignored.
+type InputStats struct {
+	Name     string // protocol of the input, e.g. "graphite"
+	Database string // database the input writes to
+
+	counters *serverCounters // server's dropped point counters, once registered
+
+	mu           sync.Mutex
+	addr         string    // listening address, empty until listening
+	lastReceived time.Time // last time data was received
+	receivedN    int64     // values parsed
+	parseErrorN  int64     // values that could not be parsed
+	writeErrorN  int64     // points that could not be written
+}
+
+// NewInputStats returns the stats of an input service writing to a database.
+func NewInputStats(name, database string) *InputStats {
+	return &InputStats{Name: name, Database: database}
+}
+
+// Listening records the address the input service is listening on.
+func (st *InputStats) Listening(addr net.Addr) {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.addr = addr.String()
+}
+
+// Received records that n values were received and parsed.
+func (st *InputStats) Received(n int) {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.lastReceived = time.Now().UTC()
+	st.receivedN += int64(n)
+}
+
+// ParseError records that a value was received but could not be parsed.
+// The value is counted as a dropped point of the input's database.
+func (st *InputStats) ParseError() {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	st.lastReceived = time.Now().UTC()
+	st.parseErrorN++
+	counters := st.counters
+	st.mu.Unlock()
+
+	if counters != nil {
+		counters.addDropped(st.Database, dropParseError, 1)
+	}
+}
+
+// WriteError records that a point could not be written.
+func (st *InputStats) WriteError() {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.writeErrorN++
+}
+
+// values returns the diagnostics row values of the input. The parse error
+// rate is the fraction of the values received that could not be parsed.
+func (st *InputStats) values() []interface{} {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var lastReceived interface{}
+	if !st.lastReceived.IsZero() {
+		lastReceived = st.lastReceived
+	}
+	var rate float64
+	if n := st.receivedN + st.parseErrorN; n > 0 {
+		rate = float64(st.parseErrorN) / float64(n)
+	}
+	return []interface{}{st.Name, st.Database, st.addr, lastReceived, st.receivedN, st.parseErrorN, rate, st.writeErrorN}
+}
+
+// RegisterInput adds an input service to the server's diagnostics and counts
+// the values it can't parse as dropped points.
+func (s *Server) RegisterInput(st *InputStats) {
+	st.mu.Lock()
+	st.counters = &s.counters
+	st.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inputs = append(s.inputs, st)
+}
+
+func (s *Server) executeListDiagnosticsStatement(q *influxql.ListDiagnosticsStatement, user *User) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Report the state of the data node.
+	server := &influxql.Row{
+		Name:    "server",
+		Columns: []string{"id", "version", "index", "messageFormat", "upgradeRequired"},
+		Values:  [][]interface{}{{s.id, s.version, s.index, s.clusterMessageFormat(), s.upgradeIndex != 0}},
+	}
+
+	// Report the state of each input service in the order they were registered.
+	inputs := &influxql.Row{
+		Name:    "inputs",
+		Columns: []string{"name", "database", "address", "lastReceived", "received", "parseErrors", "parseErrorRate", "writeErrors"},
+	}
+	for _, st := range s.inputs {
+		inputs.Values = append(inputs.Values, st.values())
+	}
+
+	return &Result{Rows: []*influxql.Row{server, inputs}}
+}
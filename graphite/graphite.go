@@ -42,6 +42,8 @@ type SeriesWriter interface {
 type Parser struct {
 	Separator   string
 	LastEnabled bool
+
+	templates templates
 }
 
 // NewParser returns a GraphiteParser instance.
@@ -49,6 +51,17 @@ func NewParser() *Parser {
 	return &Parser{Separator: DefaultGraphiteNameSeparator}
 }
 
+// AddTemplate adds a template used to map metric names to measurements, tags
+// and fields. Names not matching any template are decoded as key/value pairs.
+func (p *Parser) AddTemplate(s string) error {
+	t, err := NewTemplate(s)
+	if err != nil {
+		return err
+	}
+	p.templates = append(p.templates, t)
+	return nil
+}
+
 // Parse performs Graphite parsing of a single line.
 func (p *Parser) Parse(line string) (influxdb.Point, error) {
 	// Break into 3 fields (name, value, timestamp).
@@ -57,10 +70,21 @@ func (p *Parser) Parse(line string) (influxdb.Point, error) {
 		return influxdb.Point{}, fmt.Errorf("received %q which doesn't have three fields", line)
 	}
 
-	// decode the name and tags
-	name, tags, err := p.DecodeNameAndTags(fields[0])
-	if err != nil {
-		return influxdb.Point{}, err
+	// Decode the name and tags using the best matching template, if any.
+	var name, field string
+	var tags map[string]string
+	parts := strings.Split(fields[0], p.Separator)
+	if t := p.templates.match(parts); t != nil {
+		name, tags, field = t.Apply(parts, p.Separator)
+		if name == "" {
+			return influxdb.Point{}, fmt.Errorf("no name specified for metric. %q", fields[0])
+		}
+	} else {
+		var err error
+		if name, tags, err = p.DecodeNameAndTags(fields[0]); err != nil {
+			return influxdb.Point{}, err
+		}
+		field = name
 	}
 
 	// Parse value.
@@ -72,9 +96,9 @@ func (p *Parser) Parse(line string) (influxdb.Point, error) {
 	values := make(map[string]interface{})
 	// Determine if value is a float or an int.
 	if i := int64(v); float64(i) == v {
-		values[name] = int64(v)
+		values[field] = int64(v)
 	} else {
-		values[name] = v
+		values[field] = v
 	}
 
 	// Parse timestamp.
@@ -2,8 +2,8 @@ package graphite
 
 import (
 	"bufio"
-	"log"
 	"net"
+	"os"
 	"strings"
 
 	"github.com/influxdb/influxdb"
@@ -15,6 +15,11 @@ type TCPServer struct {
 	parser *Parser
 
 	Database string
+
+	// Stats records the state of the server for diagnostics. Optional.
+	Stats *influxdb.InputStats
+
+	Logger *influxdb.Logger
 }
 
 // NewTCPServer returns a new instance of a TCPServer.
@@ -22,6 +27,7 @@ func NewTCPServer(p *Parser, w SeriesWriter) *TCPServer {
 	return &TCPServer{
 		parser: p,
 		writer: w,
+		Logger: influxdb.NewLogger(os.Stderr, "graphite", influxdb.InfoLevel, false),
 	}
 }
 
@@ -38,11 +44,13 @@ func (t *TCPServer) ListenAndServe(iface string) error {
 	if err != nil {
 		return err
 	}
+	t.Stats.Listening(ln.Addr())
+
 	go func() {
 		for {
 			conn, err := ln.Accept()
 			if err != nil {
-				log.Println("error accepting TCP connection", err.Error())
+				t.Logger.Errorf("error accepting TCP connection: %s", err)
 				continue
 			}
 			go t.handleConnection(conn)
@@ -69,11 +77,15 @@ func (t *TCPServer) handleConnection(conn net.Conn) {
 		// Parse it.
 		point, err := t.parser.Parse(line)
 		if err != nil {
-			log.Printf("unable to parse data: %s", err)
+			t.Logger.Warnf("unable to parse data: %s", err)
+			t.Stats.ParseError()
 			continue
 		}
+		t.Stats.Received(1)
 
 		// Send the data to database
-		t.writer.WriteSeries(t.Database, "", []influxdb.Point{point})
+		if _, err := t.writer.WriteSeries(t.Database, "", []influxdb.Point{point}); err != nil {
+			t.Stats.WriteError()
+		}
 	}
 }
@@ -0,0 +1,148 @@
+package graphite
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultTemplateField is the field name used when a template does not
+// specify a field.
+const DefaultTemplateField = "value"
+
+// Template maps the parts of a Graphite metric name to a measurement, tags and
+// a field. Each dot-separated part of the template names the role of the
+// matching part of the metric name:
+//
+//	measurement   appended to the measurement name
+//	measurement*  the rest of the metric name is used as the measurement
+//	field         appended to the field name
+//	<tag>         the part is stored as the value of the tag
+//	(empty)       the part is ignored
+//
+// For example, "env.host.measurement.field" maps "prod.server01.cpu.idle" to
+// the measurement "cpu" with the tags env=prod and host=server01, and the
+// value stored in the "idle" field.
+//
+// A template may be restricted to metric names matching a filter by prefixing
+// it with the filter and a space, e.g. "servers.* .host.measurement*". Filter
+// parts match exactly or with "*" for any part.
+type Template struct {
+	Filter string
+	Parts  []string
+
+	filter []string
+}
+
+// NewTemplate parses a template in the form "[filter ]template".
+func NewTemplate(s string) (*Template, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid template %q", s)
+	}
+
+	t := &Template{}
+	if len(fields) == 2 {
+		t.Filter = fields[0]
+		t.filter = strings.Split(t.Filter, ".")
+	}
+	t.Parts = strings.Split(fields[len(fields)-1], ".")
+
+	// A template must produce a measurement name.
+	var hasMeasurement bool
+	for _, part := range t.Parts {
+		if part == "measurement" || part == "measurement*" {
+			hasMeasurement = true
+		}
+	}
+	if !hasMeasurement {
+		return nil, fmt.Errorf("template %q has no measurement", s)
+	}
+
+	return t, nil
+}
+
+// Match returns true if the template's filter matches the metric name parts.
+// Templates without a filter match every name.
+func (t *Template) Match(parts []string) bool {
+	if len(t.filter) > len(parts) {
+		return false
+	}
+	for i, f := range t.filter {
+		if f != "*" && f != parts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns the measurement, tags and field name from the metric name parts.
+func (t *Template) Apply(parts []string, separator string) (string, map[string]string, string) {
+	var (
+		measurement []string
+		field       []string
+		tags        = make(map[string]string)
+	)
+
+	for i, tmpl := range t.Parts {
+		if i >= len(parts) {
+			break
+		}
+
+		switch tmpl {
+		case "":
+		case "measurement":
+			measurement = append(measurement, parts[i])
+		case "measurement*":
+			measurement = append(measurement, parts[i:]...)
+		case "field":
+			field = append(field, parts[i])
+		default:
+			tags[tmpl] = parts[i]
+		}
+
+		if tmpl == "measurement*" {
+			break
+		}
+	}
+
+	name := DefaultTemplateField
+	if len(field) > 0 {
+		name = strings.Join(field, separator)
+	}
+	return strings.Join(measurement, separator), tags, name
+}
+
+// templates represents a list of templates.
+type templates []*Template
+
+// match returns the template with the most specific filter matching the
+// metric name parts. Longer filters are more specific and, between filters
+// of the same length, the one with fewer wildcards wins.
+// Returns nil if no template matches.
+func (a templates) match(parts []string) *Template {
+	var t *Template
+	for _, tmpl := range a {
+		if tmpl.Match(parts) && (t == nil || tmpl.moreSpecific(t)) {
+			t = tmpl
+		}
+	}
+	return t
+}
+
+// moreSpecific returns true if t's filter is more specific than other's.
+func (t *Template) moreSpecific(other *Template) bool {
+	if len(t.filter) != len(other.filter) {
+		return len(t.filter) > len(other.filter)
+	}
+	return t.wildcards() < other.wildcards()
+}
+
+// wildcards returns the number of wildcard parts in the filter.
+func (t *Template) wildcards() (n int) {
+	for _, f := range t.filter {
+		if f == "*" {
+			n++
+		}
+	}
+	return
+}
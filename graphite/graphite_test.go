@@ -1,6 +1,7 @@
 package graphite_test
 
 import (
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
@@ -241,6 +242,66 @@ func Test_DecodeMetric(t *testing.T) {
 	}
 }
 
+func Test_DecodeMetric_Templates(t *testing.T) {
+	p := graphite.NewParser()
+	for _, s := range []string{
+		"env.host.measurement.field",
+		"servers.* .host.measurement*",
+		"servers.web ..host.measurement.measurement",
+	} {
+		if err := p.AddTemplate(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		line  string
+		name  string
+		tags  map[string]string
+		field string
+		value interface{}
+	}{
+		{line: `prod.server01.cpu.idle 50 1419972457825`, name: "cpu", tags: map[string]string{"env": "prod", "host": "server01"}, field: "idle", value: int64(50)},
+		{line: `servers.db01.disk.sda.used 1.5 1419972457825`, name: "disk.sda.used", tags: map[string]string{"host": "db01"}, field: "value", value: 1.5},
+		{line: `servers.web.web01.http.requests 3 1419972457825`, name: "http.requests", tags: map[string]string{"host": "web01"}, field: "value", value: int64(3)},
+	}
+
+	for i, tt := range tests {
+		point, err := p.Parse(tt.line)
+		if err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		}
+		if point.Name != tt.name {
+			t.Errorf("%d. name mismatch.  expected %v, got %v", i, tt.name, point.Name)
+		}
+		if !reflect.DeepEqual(point.Tags, tt.tags) {
+			t.Errorf("%d. tags mismatch.  expected %v, got %v", i, tt.tags, point.Tags)
+		}
+		if !reflect.DeepEqual(point.Values, map[string]interface{}{tt.field: tt.value}) {
+			t.Errorf("%d. values mismatch.  expected %s=%v, got %v", i, tt.field, tt.value, point.Values)
+		}
+	}
+}
+
+func Test_NewTemplate(t *testing.T) {
+	var tests = []struct {
+		template string
+		err      string
+	}{
+		{template: "host.measurement"},
+		{template: "servers.* host.measurement*"},
+		{template: "", err: `invalid template ""`},
+		{template: "a b c", err: `invalid template "a b c"`},
+		{template: "host.field", err: `template "host.field" has no measurement`},
+	}
+
+	for i, tt := range tests {
+		if _, err := graphite.NewTemplate(tt.template); errstr(err) != tt.err {
+			t.Errorf("%d. err does not match.  expected %v, got %v", i, tt.err, err)
+		}
+	}
+}
+
 // Test Helpers
 func errstr(err error) string {
 	if err != nil {
@@ -2,6 +2,7 @@ package graphite
 
 import (
 	"net"
+	"os"
 	"strings"
 
 	"github.com/influxdb/influxdb"
@@ -17,6 +18,11 @@ type UDPServer struct {
 	parser *Parser
 
 	Database string
+
+	// Stats records the state of the server for diagnostics. Optional.
+	Stats *influxdb.InputStats
+
+	Logger *influxdb.Logger
 }
 
 // NewUDPServer returns a new instance of a UDPServer
@@ -24,6 +30,7 @@ func NewUDPServer(p *Parser, w SeriesWriter) *UDPServer {
 	u := UDPServer{
 		parser: p,
 		writer: w,
+		Logger: influxdb.NewLogger(os.Stderr, "graphite", influxdb.InfoLevel, false),
 	}
 	return &u
 }
@@ -46,6 +53,7 @@ func (u *UDPServer) ListenAndServe(iface string) error {
 	if err != nil {
 		return err
 	}
+	u.Stats.Listening(conn.LocalAddr())
 
 	buf := make([]byte, udpBufferSize)
 	go func() {
@@ -55,13 +63,21 @@ func (u *UDPServer) ListenAndServe(iface string) error {
 				return
 			}
 			for _, line := range strings.Split(string(buf[:n]), "\n") {
+				if line == "" {
+					continue
+				}
 				point, err := u.parser.Parse(line)
 				if err != nil {
+					u.Logger.Warnf("unable to parse data: %s", err)
+					u.Stats.ParseError()
 					continue
 				}
+				u.Stats.Received(1)
 
 				// Send the data to database
-				u.writer.WriteSeries(u.Database, "", []influxdb.Point{point})
+				if _, err := u.writer.WriteSeries(u.Database, "", []influxdb.Point{point}); err != nil {
+					u.Stats.WriteError()
+				}
 			}
 		}
 	}()
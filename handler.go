@@ -1,24 +1,38 @@
 package influxdb
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bmizerany/pat"
 	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/messaging"
 )
 
 // TODO: Standard response headers (see: HeaderHandler)
-// TODO: Compression (see: CompressionHeaderHandler)
 
 // TODO: Check HTTP response codes: 400, 401, 403, 409.
 
+// RequestIDHeader is the header a client can use to supply an id for tracing
+// a request. The id is echoed on the response, logged with errors, and carried
+// in the broker messages for writes.
+const RequestIDHeader = "X-Request-Id"
+
+// ClusterSecretHeader is the header data nodes use to send the cluster secret
+// to each other. Requests carrying the secret are accepted as an admin user.
+const ClusterSecretHeader = "X-Influxdb-Cluster-Secret"
+
 // getUsernameAndPassword returns the username and password encoded in
 // a request. The credentials may be present as URL query params, or as
 // a Basic Authentication header.
@@ -47,6 +61,16 @@ func getUsernameAndPassword(r *http.Request) (string, string, error) {
 	return fields[0], fields[1], nil
 }
 
+// getToken returns the API token secret from a Token Authorization header.
+// Returns a blank string if the request doesn't use token authentication.
+func getToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Token ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(auth, "Token "))
+}
+
 // Handler represents an HTTP handler for the InfluxDB server.
 type Handler struct {
 	server *Server
@@ -55,6 +79,10 @@ type Handler struct {
 	// Whether endpoints require authentication.
 	AuthenticationEnabled bool
 
+	// Whether authenticated requests must be made over HTTPS so credentials
+	// are never sent in plaintext.
+	HTTPSRequired bool
+
 	// The InfluxDB verion returned by the HTTP response header.
 	Version string
 }
@@ -67,20 +95,51 @@ func NewHandler(s *Server) *Handler {
 	}
 
 	// Query serving route.
-	h.mux.Get("/query", h.makeAuthenticationHandler(h.serveQuery))
+	h.mux.Get("/query", gzipFilter(h.makeAuthenticationHandler(h.serveQuery)))
 
 	// Data-ingest route.
-	h.mux.Post("/write", h.makeAuthenticationHandler(h.serveWrite))
+	h.mux.Post("/write", gzipFilter(h.makeAuthenticationHandler(h.serveWrite)))
+
+	// Event routes.
+	h.mux.Get("/events", h.makeAuthenticationHandler(h.serveEvents))
+	h.mux.Post("/events", h.makeAuthenticationHandler(h.serveCreateEvent))
 
 	// Data node routes.
 	h.mux.Get("/data_nodes", h.makeAuthenticationHandler(h.serveDataNodes))
 	h.mux.Post("/data_nodes", h.makeAuthenticationHandler(h.serveCreateDataNode))
 	h.mux.Del("/data_nodes/:id", h.makeAuthenticationHandler(h.serveDeleteDataNode))
+	h.mux.Post("/data_nodes/:id/decommission", h.makeAuthenticationHandler(h.serveDecommissionDataNode))
+	h.mux.Post("/data_nodes/:id/promote", h.makeAuthenticationHandler(h.servePromoteDataNode))
+
+	// User routes.
+	h.mux.Put("/users/:user/privileges", h.makeAuthenticationHandler(h.serveSetPrivilege))
+
+	// Apply error routes.
+	h.mux.Get("/errors", h.makeAuthenticationHandler(h.serveApplyErrors))
+	h.mux.Del("/errors", h.makeAuthenticationHandler(h.serveClearApplyErrors))
+
+	// Shard replica routes.
+	h.mux.Get("/shards/:id/digest", h.makeAuthenticationHandler(h.serveShardDigest))
+	h.mux.Get("/shards/:id/series", h.makeAuthenticationHandler(h.serveShardSeries))
+	h.mux.Get("/shards/:id/sync", h.makeAuthenticationHandler(h.serveShardSync))
 
 	// Utilities
 	h.mux.Get("/metastore", h.makeAuthenticationHandler(h.serveMetastore))
+	h.mux.Get("/metastore/index", h.makeAuthenticationHandler(h.serveMetastoreIndex))
+	h.mux.Post("/broadcast/compact", h.makeAuthenticationHandler(h.serveCompactBroadcast))
+	h.mux.Post("/shards/:id/compact", h.makeAuthenticationHandler(h.serveCompactShard))
+	h.mux.Get("/backup", h.makeAuthenticationHandler(h.serveBackup))
+	h.mux.Get("/shard_stats", h.makeAuthenticationHandler(h.serveShardStats))
+	h.mux.Get("/metrics", h.makeAuthenticationHandler(h.serveMetrics))
+	h.mux.Get("/debug/vars", h.makeAuthenticationHandler(h.serveDebugVars))
+	h.mux.Get("/tail", h.makeAuthenticationHandler(h.serveTail))
+	h.mux.Post("/repair", h.makeAuthenticationHandler(h.serveRepair))
 	h.mux.Get("/ping", h.makeAuthenticationHandler(h.servePing))
 
+	// Health probes don't require authentication so orchestrators can use them.
+	h.mux.Get("/health", http.HandlerFunc(h.serveHealth))
+	h.mux.Get("/ready", http.HandlerFunc(h.serveReady))
+
 	return h
 }
 
@@ -89,9 +148,15 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Access-Control-Allow-Origin", "*")
 	w.Header().Add("Access-Control-Max-Age", "2592000")
 	w.Header().Add("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
-	w.Header().Add("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept")
+	w.Header().Add("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, "+RequestIDHeader)
+	w.Header().Add("Access-Control-Expose-Headers", RequestIDHeader)
 	w.Header().Add("X-Influxdb-Version", h.Version)
 
+	// Echo the client's request id so responses can be matched to traces.
+	if id := r.Header.Get(RequestIDHeader); id != "" {
+		w.Header().Set(RequestIDHeader, id)
+	}
+
 	// If this is a CORS OPTIONS request then send back okie-dokie.
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
@@ -112,6 +177,28 @@ func (h *Handler) makeAuthenticationHandler(fn func(http.ResponseWriter, *http.R
 	return func(w http.ResponseWriter, r *http.Request) {
 		var user *User
 		if h.AuthenticationEnabled && len(h.server.Users()) > 0 {
+			if h.HTTPSRequired && r.TLS == nil {
+				h.error(w, "https required", http.StatusForbidden)
+				return
+			}
+
+			// Requests from other data nodes carry the cluster secret.
+			if h.server.authenticatePeer(r) {
+				fn(w, r, nil)
+				return
+			}
+
+			// Authenticate with an API token if one is supplied.
+			if token := getToken(r); token != "" {
+				u, err := h.server.AuthenticateToken(token)
+				if err != nil {
+					h.error(w, err.Error(), http.StatusUnauthorized)
+					return
+				}
+				fn(w, r, u)
+				return
+			}
+
 			username, password, err := getUsernameAndPassword(r)
 			if err != nil {
 				h.error(w, err.Error(), http.StatusUnauthorized)
@@ -133,10 +220,33 @@ func (h *Handler) makeAuthenticationHandler(fn func(http.ResponseWriter, *http.R
 }
 
 // serveQuery parses an incoming query and, if valid, executes the query.
+//
+// The "timeColumn" parameter renames the time column of every row. Setting
+// "format" to "flat" returns each result's rows as one object per value,
+// keyed by column name, instead of columns and values arrays. Setting it to
+// "csv", or accepting "text/csv", streams rows as CSV. Setting it to
+// "msgpack", or accepting "application/x-msgpack", encodes the results as
+// MessagePack with the same structure as JSON.
 func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request, u *User) {
 	q := r.URL.Query()
 	p := influxql.NewParser(strings.NewReader(q.Get("q")))
 	db := q.Get("db")
+	timeColumn := q.Get("timeColumn")
+
+	// Validate the response format.
+	var flat, asCSV, asMsgpack bool
+	switch format := q.Get("format"); format {
+	case "", "json":
+	case "flat":
+		flat = true
+	case "csv":
+		asCSV = true
+	case "msgpack":
+		asMsgpack = true
+	default:
+		h.error(w, fmt.Sprintf("unknown format: %q", format), http.StatusBadRequest)
+		return
+	}
 
 	// Parse query from query string.
 	query, err := p.ParseQuery()
@@ -145,43 +255,354 @@ func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request, u *User) {
 		return
 	}
 
-	// Execute query. One result will return for each statement.
-	results := h.server.ExecuteQuery(query, db, u)
+	// Stream rows as JSON Lines or CSV if the client accepts them.
+	if strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		h.serveQueryLines(w, r, query, db, u, timeColumn)
+		return
+	} else if asCSV || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		h.serveQueryCSV(w, r, query, db, u, timeColumn)
+		return
+	}
+
+	// Execute query. One result will return for each statement. Metadata
+	// statements can be applied together as a single atomic batch.
+	var results Results
+	if q.Get("atomic") == "true" {
+		results = h.server.ExecuteBatch(query, u)
+	} else {
+		results = h.server.ExecuteQuery(query, db, u)
+	}
+
+	// Rename the time column of every row.
+	for _, result := range results {
+		for _, row := range result.Rows {
+			renameTimeColumn(row, timeColumn)
+		}
+	}
+
+	// Encode MessagePack before the status is sent so encoding errors can
+	// still be reported.
+	var body []byte
+	if asMsgpack || strings.Contains(r.Header.Get("Accept"), "application/x-msgpack") {
+		if body, err = encodeMsgpack(results); err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("content-type", "application/x-msgpack")
+	}
 
-	// If any statement errored then set the response status code.
-	if results.Error() != nil {
+	// If any statement errored then set the response status code. Standby
+	// nodes report that they're unavailable so clients try another node.
+	if err := results.Error(); err == ErrDataNodeStandby {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else if err != nil {
+		h.logRequestError(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 
 	// Write resultset.
+	if body != nil {
+		_, _ = w.Write(body)
+		return
+	} else if flat {
+		_ = json.NewEncoder(w).Encode(flattenResults(results))
+		return
+	}
 	_ = json.NewEncoder(w).Encode(results)
 }
 
+// renameTimeColumn renames the time column of a row, if it has one.
+// The columns are copied as they may be shared with other rows.
+func renameTimeColumn(row *influxql.Row, name string) {
+	if row == nil || name == "" || name == "time" {
+		return
+	}
+	for i, col := range row.Columns {
+		if col == "time" {
+			columns := make([]string, len(row.Columns))
+			copy(columns, row.Columns)
+			columns[i] = name
+			row.Columns = columns
+			return
+		}
+	}
+}
+
+// flatResult represents a result with each value of its rows flattened into
+// a single object keyed by column name. The row's tags and name are inlined
+// unless a column has the same key.
+type flatResult struct {
+	Rows []map[string]interface{} `json:"rows,omitempty"`
+	Err  string                   `json:"error,omitempty"`
+}
+
+// flattenResults returns the flattened form of a set of results.
+func flattenResults(results Results) []*flatResult {
+	a := make([]*flatResult, len(results))
+	for i, result := range results {
+		fr := &flatResult{}
+		if result.Err != nil {
+			fr.Err = result.Err.Error()
+		}
+		for _, row := range result.Rows {
+			for _, values := range row.Values {
+				m := make(map[string]interface{}, len(row.Columns)+len(row.Tags)+1)
+				if row.Name != "" {
+					m["name"] = row.Name
+				}
+				for k, v := range row.Tags {
+					m[k] = v
+				}
+				for j, col := range row.Columns {
+					if j < len(values) {
+						m[col] = values[j]
+					}
+				}
+				fr.Rows = append(fr.Rows, m)
+			}
+		}
+		a[i] = fr
+	}
+	return a
+}
+
+// serveQueryLines streams each row of a query as a separate JSON object on its
+// own line, flushing as rows are produced. The status is sent before the query
+// executes so errors are reported as rows with an "error" field. The query
+// stops once the client goes away.
+func (h *Handler) serveQueryLines(w http.ResponseWriter, r *http.Request, query *influxql.Query, db string, u *User, timeColumn string) {
+	w.Header().Add("content-type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	f, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for row := range h.server.ExecuteQueryStream(ctx, query, db, u) {
+		renameTimeColumn(row.Row, timeColumn)
+		if err := enc.Encode(row); err != nil {
+			return
+		} else if f != nil {
+			f.Flush()
+		}
+	}
+}
+
+// serveQueryCSV streams the rows of a query as CSV, flushing as rows are
+// produced. Each record holds the row's name, its tag values and one value
+// from each column. A header naming the fields is written before the first
+// record and again whenever the fields change. Errors are written as a record
+// under an "error" header. The query stops once the client goes away.
+func (h *Handler) serveQueryCSV(w http.ResponseWriter, r *http.Request, query *influxql.Query, db string, u *User, timeColumn string) {
+	w.Header().Add("content-type", "text/csv")
+	w.WriteHeader(http.StatusOK)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	f, _ := w.(http.Flusher)
+	cw := csv.NewWriter(w)
+	var header []string
+	for row := range h.server.ExecuteQueryStream(ctx, query, db, u) {
+		var records [][]string
+		if row.Err != nil {
+			records = [][]string{{row.Err.Error()}}
+			if !stringSlicesEqual(header, []string{"error"}) {
+				header = []string{"error"}
+				records = append([][]string{header}, records...)
+			}
+		} else if row.Row != nil {
+			renameTimeColumn(row.Row, timeColumn)
+			keys := make([]string, 0, len(row.Row.Tags))
+			for k := range row.Row.Tags {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			if hdr := append(append([]string{"name"}, keys...), row.Row.Columns...); !stringSlicesEqual(header, hdr) {
+				header = hdr
+				records = append(records, header)
+			}
+			for _, values := range row.Row.Values {
+				record := []string{row.Row.Name}
+				for _, k := range keys {
+					record = append(record, row.Row.Tags[k])
+				}
+				for _, v := range values {
+					record = append(record, csvValue(v))
+				}
+				records = append(records, record)
+			}
+		}
+
+		if err := cw.WriteAll(records); err != nil {
+			return
+		} else if f != nil {
+			f.Flush()
+		}
+	}
+}
+
+// csvValue returns the CSV encoding of a row value. Nil values are blank.
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// stringSlicesEqual returns true if a and b have the same elements in order.
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 type batchWrite struct {
-	Points          []Point           `json:"points"`
+	Points          []batchPoint      `json:"points"`
 	Database        string            `json:"database"`
 	RetentionPolicy string            `json:"retentionPolicy"`
 	Tags            map[string]string `json:"tags"`
-	Timestamp       time.Time         `json:"timestamp"`
+	Timestamp       batchTimestamp    `json:"timestamp"`
+}
+
+// batchPoint represents a point in a batch write. A point may set its own
+// retention policy to override the batch's and a TTL, such as "1h", after
+// which the point expires.
+type batchPoint struct {
+	Point
+	Timestamp       batchTimestamp `json:"timestamp"`
+	RetentionPolicy string         `json:"retentionPolicy"`
+	TTL             string         `json:"ttl"`
+}
+
+// writeErrorResponse is the body returned when points in a batch write or
+// lines in a line protocol write are rejected. The remaining points are still
+// written.
+type writeErrorResponse struct {
+	Err      string      `json:"error"`
+	Rejected interface{} `json:"rejected"` // PointErrors or LineErrors
+}
+
+// batchTimestamp is a timestamp in a batch write. It is either an RFC3339
+// string or an integer epoch in the precision of the request.
+type batchTimestamp struct {
+	t     time.Time
+	epoch *int64
+}
+
+// UnmarshalJSON decodes a timestamp string or epoch.
+func (ts *batchTimestamp) UnmarshalJSON(b []byte) error {
+	*ts = batchTimestamp{}
+	if string(b) == "null" {
+		return nil
+	} else if len(b) > 0 && b[0] == '"' {
+		return ts.t.UnmarshalJSON(b)
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %s", b)
+	}
+	ts.epoch = &n
+	return nil
+}
+
+// time returns the timestamp with an epoch counted in units.
+func (ts batchTimestamp) time(unit time.Duration) (time.Time, error) {
+	if ts.epoch == nil {
+		return ts.t, nil
+	}
+	t, err := epochTime(*ts.epoch, unit)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("timestamp out of range: %d", *ts.epoch)
+	}
+	return t, nil
 }
 
 // serveWrite receives incoming series data and writes it to the database.
+// Bodies sent as text/plain are parsed as the line protocol.
+//
+// Points are written to the retention policy set on the point, then the
+// batch, then the "rp" parameter, or the database's default policy if none
+// are set.
+//
+// Points that can't be written don't stop the rest of the batch. They are
+// listed with their position in the request and the reason they were
+// rejected in a 400 response once the remaining points are written. Only
+// invalid points are rejected this way; if the server fails to write a point,
+// such as when the broker is unavailable, the request returns 500.
+//
+// Timestamps are RFC3339 strings or integer epochs in the unit set by the
+// "precision" parameter: "ns" (the default), "u", "ms" or "s".
+//
+// The "consistency" parameter sets how many replicas must apply each point
+// before the request returns: "any" (the default), "one", "quorum" or "all".
+// If "async" is true then the points are added to the server's durable write
+// queue and the request returns 202 before they are published.
 func (h *Handler) serveWrite(w http.ResponseWriter, r *http.Request, u *User) {
-	var br batchWrite
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/plain") {
+		h.serveWriteLines(w, r, u)
+		return
+	}
 
 	dec := json.NewDecoder(r.Body)
 	dec.UseNumber()
 
 	var writeError = func(result Result, statusCode int) {
+		h.logRequestError(r, result.Err)
 		w.WriteHeader(statusCode)
 		w.Header().Add("content-type", "application/json")
 		_ = json.NewEncoder(w).Encode(&result)
 		return
 	}
 
+	level, async, err := parseWriteMode(r.URL.Query())
+	if err != nil {
+		writeError(Result{Err: err}, http.StatusBadRequest)
+		return
+	}
+	unit, err := precisionUnit(r.URL.Query().Get("precision"))
+	if err != nil {
+		writeError(Result{Err: err}, http.StatusBadRequest)
+		return
+	}
+
+	// Points that can't be written are collected with their position in the
+	// request while the remaining points are written.
+	var errs PointErrors
+	var index int
+
 	for {
+		// Decode each batch into a new value so that fields omitted from a
+		// point aren't carried over from the previous batch.
+		var br batchWrite
 		if err := dec.Decode(&br); err != nil {
 			if err.Error() == "EOF" {
+				if len(errs) > 0 {
+					h.logRequestError(r, errs)
+					w.Header().Add("content-type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					_ = json.NewEncoder(w).Encode(&writeErrorResponse{Err: errs.Error(), Rejected: errs})
+					return
+				} else if async {
+					w.WriteHeader(http.StatusAccepted)
+					return
+				}
 				w.WriteHeader(http.StatusOK)
 				return
 			}
@@ -199,15 +620,43 @@ func (h *Handler) serveWrite(w http.ResponseWriter, r *http.Request, u *User) {
 			return
 		}
 
-		// TODO corylanou: Check if user can write to specified database
-		//if !user_can_write(br.Database) {
-		//writeError(&Result{Err: fmt.Errorf("%q user is not authorized to write to database %q", u.Name)}, http.StatusUnauthorized)
-		//return
-		//}
+		// Check if the user can write to the specified database.
+		if u != nil && !u.Authorize(influxql.WritePrivilege, br.Database) {
+			writeError(Result{Err: fmt.Errorf("%q user is not authorized to write to database %q", u.Name, br.Database)}, http.StatusUnauthorized)
+			return
+		}
+
+		// Queued points are grouped by retention policy so that each run of
+		// points is logged at once.
+		var queued []Point
+		var queuedRP string
+		var enqueue = func() error {
+			if len(queued) == 0 {
+				return nil
+			}
+			err := h.server.EnqueueSeries(br.Database, queuedRP, queued)
+			queued = nil
+			return err
+		}
+
+		batchTime, err := br.Timestamp.time(unit)
+		if err != nil {
+			writeError(Result{Err: err}, http.StatusBadRequest)
+			return
+		}
+
+		for _, bp := range br.Points {
+			p, i := bp.Point, index
+			index++
+			var reject = func(err error) {
+				errs = append(errs, &PointError{Index: i, Name: p.Name, Err: err})
+			}
 
-		for _, p := range br.Points {
-			if p.Timestamp.IsZero() {
-				p.Timestamp = br.Timestamp
+			if p.Timestamp, err = bp.Timestamp.time(unit); err != nil {
+				reject(err)
+				continue
+			} else if p.Timestamp.IsZero() {
+				p.Timestamp = batchTime
 			}
 			if len(br.Tags) > 0 {
 				for k, _ := range br.Tags {
@@ -216,107 +665,963 @@ func (h *Handler) serveWrite(w http.ResponseWriter, r *http.Request, u *User) {
 					}
 				}
 			}
-			if _, err := h.server.WriteSeries(br.Database, br.RetentionPolicy, []Point{p}); err != nil {
+
+			// Parse the point's expiry.
+			if bp.TTL != "" {
+				ttl, err := influxql.ParseDuration(bp.TTL)
+				if err != nil || ttl <= 0 {
+					reject(fmt.Errorf("invalid ttl: %q", bp.TTL))
+					continue
+				}
+				p.TTL = ttl
+			}
+
+			// Determine the retention policy for the point.
+			retentionPolicy := bp.RetentionPolicy
+			if retentionPolicy == "" {
+				retentionPolicy = br.RetentionPolicy
+			}
+			if retentionPolicy == "" {
+				retentionPolicy = r.URL.Query().Get("rp")
+			}
+			if err := h.checkRetentionPolicy(br.Database, retentionPolicy); err != nil {
+				reject(err)
+				continue
+			}
+
+			if async {
+				if retentionPolicy != queuedRP {
+					if err := enqueue(); err != nil {
+						writeError(Result{Err: err}, writeQueueErrorStatus(err))
+						return
+					}
+				}
+				queued, queuedRP = append(queued, p), retentionPolicy
+				continue
+			}
+
+			if _, err := h.server.writeSeries(r.Header.Get(RequestIDHeader), br.Database, retentionPolicy, level, []Point{p}); err != nil && pointRejected(err) {
+				reject(err)
+			} else if err != nil {
 				writeError(Result{Err: err}, http.StatusInternalServerError)
 				return
 			}
 		}
+		if err := enqueue(); err != nil {
+			writeError(Result{Err: err}, writeQueueErrorStatus(err))
+			return
+		}
 	}
 }
 
-// serveMetastore returns a copy of the metastore.
-func (h *Handler) serveMetastore(w http.ResponseWriter, r *http.Request, u *User) {
-	// Set headers.
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", `attachment; filename="meta"`)
+// parseWriteMode returns the consistency level and whether the write is
+// queued from the "consistency" and "async" parameters. Queued writes return
+// before they are published so they can't wait for replicas.
+func parseWriteMode(q url.Values) (ConsistencyLevel, bool, error) {
+	level, err := ParseConsistencyLevel(q.Get("consistency"))
+	if err != nil {
+		return 0, false, err
+	}
 
-	if err := h.server.CopyMetastore(w); err != nil {
-		h.error(w, err.Error(), http.StatusInternalServerError)
+	var async bool
+	if s := q.Get("async"); s != "" {
+		if async, err = strconv.ParseBool(s); err != nil {
+			return 0, false, fmt.Errorf("invalid async: %q", s)
+		}
+	}
+	if async && level != ConsistencyLevelAny {
+		return 0, false, fmt.Errorf("async writes cannot use consistency level: %s", level)
 	}
+	return level, async, nil
 }
 
-// servePing returns a simple response to let the client know the server is running.
-func (h *Handler) servePing(w http.ResponseWriter, r *http.Request, u *User) {}
-
-// serveDataNodes returns a list of all data nodes in the cluster.
-func (h *Handler) serveDataNodes(w http.ResponseWriter, r *http.Request, u *User) {
-	// Generate a list of objects for encoding to the API.
-	a := make([]*dataNodeJSON, 0)
-	for _, n := range h.server.DataNodes() {
-		a = append(a, &dataNodeJSON{
-			ID:  n.ID,
-			URL: n.URL.String(),
-		})
+// pointRejected returns true if a write failed because of the point itself,
+// such as a field type conflict or an invalid ttl. Those points are rejected
+// while the rest of the request is written. Other errors, such as failing to
+// publish to the broker, fail the request with a server error.
+func pointRejected(err error) bool {
+	switch err.(type) {
+	case *FieldTypeConflictError, *MaxValuesPerTagError:
+		return true
 	}
+	switch err {
+	case ErrMeasurementNameRequired, ErrInvalidPointTTL, ErrFieldOverflow, ErrWriteBlocked,
+		ErrRetentionPolicyNotFound, ErrDefaultRetentionPolicyNotFound:
+		return true
+	}
+	return false
+}
 
-	w.Header().Add("content-type", "application/json")
-	_ = json.NewEncoder(w).Encode(a)
+// writeQueueErrorStatus returns the HTTP status for an error queuing a write.
+func writeQueueErrorStatus(err error) int {
+	switch err {
+	case ErrWriteQueueDisabled:
+		return http.StatusBadRequest
+	case ErrWriteQueueFull:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
 }
 
-// serveCreateDataNode creates a new data node in the cluster.
-func (h *Handler) serveCreateDataNode(w http.ResponseWriter, r *http.Request, _ *User) {
-	// Read in data node from request body.
-	var n dataNodeJSON
-	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
-		h.error(w, err.Error(), http.StatusBadRequest)
-		return
+// serveWriteLines writes line protocol points to the database given by the
+// "db" parameter. The "rp", "precision", "consistency" and "async" parameters
+// optionally set the retention policy, the timestamp precision, the write
+// consistency level and whether the points are queued. Each line is written
+// independently so a bad line does not prevent the others from being written.
+func (h *Handler) serveWriteLines(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+	database, retentionPolicy, precision := q.Get("db"), q.Get("rp"), q.Get("precision")
+
+	var writeError = func(result Result, statusCode int) {
+		h.logRequestError(r, result.Err)
+		w.Header().Add("content-type", "application/json")
+		w.WriteHeader(statusCode)
+		_ = json.NewEncoder(w).Encode(&result)
 	}
 
-	// Parse the URL.
-	u, err := url.Parse(n.URL)
+	if database == "" {
+		writeError(Result{Err: fmt.Errorf("database is required")}, http.StatusBadRequest)
+		return
+	} else if !h.server.DatabaseExists(database) {
+		writeError(Result{Err: fmt.Errorf("database not found: %q", database)}, http.StatusNotFound)
+		return
+	} else if u != nil && !u.Authorize(influxql.WritePrivilege, database) {
+		writeError(Result{Err: fmt.Errorf("%q user is not authorized to write to database %q", u.Name, database)}, http.StatusUnauthorized)
+		return
+	} else if err := h.checkRetentionPolicy(database, retentionPolicy); err != nil {
+		writeError(Result{Err: err}, http.StatusNotFound)
+		return
+	} else if _, err := precisionUnit(precision); err != nil {
+		writeError(Result{Err: err}, http.StatusBadRequest)
+		return
+	}
+	level, async, err := parseWriteMode(q)
 	if err != nil {
-		h.error(w, "invalid data node url", http.StatusBadRequest)
+		writeError(Result{Err: err}, http.StatusBadRequest)
 		return
 	}
 
-	// Create the data node.
-	if err := h.server.CreateDataNode(u); err == ErrDataNodeExists {
-		h.error(w, err.Error(), http.StatusConflict)
+	// Parse and write each line, collecting the errors for failed lines.
+	// Queued points are logged together once every line is parsed. Lines
+	// after a server error are not written.
+	var errs LineErrors
+	var queued []Point
+	var serverErr error
+	if err := scanPoints(r.Body, precision, h.server.clock.Now(), func(n int, p Point, err error) {
+		if serverErr != nil {
+			return
+		} else if err != nil {
+			h.server.counters.addDropped(database, dropParseError, 1)
+		} else if async {
+			queued = append(queued, p)
+		} else if _, err = h.server.writeSeries(r.Header.Get(RequestIDHeader), database, retentionPolicy, level, []Point{p}); err != nil && !pointRejected(err) {
+			serverErr = err
+			return
+		}
+		if err != nil {
+			errs = append(errs, &LineError{Line: n, Err: err})
+		}
+	}); err != nil {
+		writeError(Result{Err: err}, http.StatusBadRequest)
 		return
-	} else if err != nil {
-		h.error(w, err.Error(), http.StatusInternalServerError)
+	} else if serverErr != nil {
+		writeError(Result{Err: serverErr}, http.StatusInternalServerError)
 		return
 	}
 
-	// Retrieve data node reference.
-	node := h.server.DataNodeByURL(u)
+	if len(queued) > 0 {
+		if err := h.server.EnqueueSeries(database, retentionPolicy, queued); err != nil {
+			writeError(Result{Err: err}, writeQueueErrorStatus(err))
+			return
+		}
+	}
 
-	// Create a new replica on the broker.
-	if err := h.server.client.CreateReplica(node.ID); err != nil {
-		h.error(w, err.Error(), http.StatusBadGateway)
+	if len(errs) > 0 {
+		h.logRequestError(r, errs)
+		w.Header().Add("content-type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&writeErrorResponse{Err: errs.Error(), Rejected: errs})
 		return
+	} else if async {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// checkRetentionPolicy returns an error if a retention policy is named for a
+// write but does not exist. An empty name writes to the default policy.
+func (h *Handler) checkRetentionPolicy(database, name string) error {
+	if name == "" {
+		return nil
+	} else if rp, err := h.server.RetentionPolicy(database, name); err != nil {
+		return err
+	} else if rp == nil {
+		return fmt.Errorf("retention policy not found: %q", name)
+	}
+	return nil
+}
+
+// serveEvents returns the events in a database that overlap an optional
+// time range given by the "start" and "end" RFC3339 parameters.
+func (h *Handler) serveEvents(w http.ResponseWriter, r *http.Request, u *User) {
+	q := r.URL.Query()
+
+	// Check if the user can read from the database.
+	if u != nil && !u.Authorize(influxql.ReadPrivilege, q.Get("db")) {
+		h.error(w, fmt.Sprintf("%q user is not authorized to read from database %q", u.Name, q.Get("db")), http.StatusUnauthorized)
+		return
+	}
+
+	// Parse the time range.
+	var min, max time.Time
+	if s := q.Get("start"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			h.error(w, "invalid start time", http.StatusBadRequest)
+			return
+		}
+		min = t
+	}
+	if s := q.Get("end"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			h.error(w, "invalid end time", http.StatusBadRequest)
+			return
+		}
+		max = t
+	}
+
+	events, err := h.server.Events(q.Get("db"), q.Get("name"), min, max)
+	if err == ErrDatabaseNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if events == nil {
+		events = make([]*Event, 0)
 	}
 
-	// Write new node back to client.
-	w.WriteHeader(http.StatusCreated)
 	w.Header().Add("content-type", "application/json")
-	_ = json.NewEncoder(w).Encode(&dataNodeJSON{ID: node.ID, URL: node.URL.String()})
+	_ = json.NewEncoder(w).Encode(events)
 }
 
-// serveDeleteDataNode removes an existing node.
-func (h *Handler) serveDeleteDataNode(w http.ResponseWriter, r *http.Request, u *User) {
-	// Parse node id.
-	nodeID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
-	if err != nil {
-		h.error(w, "invalid node id", http.StatusBadRequest)
+// serveCreateEvent records a new event in a database.
+func (h *Handler) serveCreateEvent(w http.ResponseWriter, r *http.Request, u *User) {
+	// Check if the user can write to the database.
+	database := r.URL.Query().Get("db")
+	if u != nil && !u.Authorize(influxql.WritePrivilege, database) {
+		h.error(w, fmt.Sprintf("%q user is not authorized to write to database %q", u.Name, database), http.StatusUnauthorized)
 		return
 	}
 
-	// Delete the node.
-	if err := h.server.DeleteDataNode(nodeID); err == ErrDataNodeNotFound {
+	// Read in event from request body.
+	var e Event
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Create the event.
+	id, err := h.server.CreateEvent(database, &e)
+	if err == ErrDatabaseNotFound {
 		h.error(w, err.Error(), http.StatusNotFound)
 		return
+	} else if err == ErrEventNameRequired || err == ErrEventTimeRequired || err == ErrInvalidEventTimeRange {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
 	} else if err != nil {
 		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	// Write the event id back to the client.
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]uint64{"id": id})
 }
 
-type dataNodeJSON struct {
-	ID  uint64 `json:"id"`
-	URL string `json:"url"`
+// privilegeNames maps the privilege names accepted by the privileges endpoint.
+var privilegeNames = map[string]influxql.Privilege{
+	"read":  influxql.ReadPrivilege,
+	"write": influxql.WritePrivilege,
+	"all":   influxql.AllPrivileges,
+	"none":  influxql.NoPrivileges,
+}
+
+// serveSetPrivilege sets the privilege of a user on a database. The request
+// body is a JSON object with a database and a privilege of "read", "write",
+// "all" or "none". Without a database, "all" and "none" set admin status.
+func (h *Handler) serveSetPrivilege(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	// Read the privilege from the request body.
+	var body struct {
+		Database  string `json:"database"`
+		Privilege string `json:"privilege"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, ok := privilegeNames[strings.ToLower(body.Privilege)]
+	if !ok {
+		h.error(w, ErrInvalidPrivilege.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Set the privilege.
+	if err := h.server.SetPrivilege(r.URL.Query().Get(":user"), body.Database, p); err == ErrUserNotFound || err == ErrDatabaseNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err == ErrDatabaseRequired || err == ErrInvalidPrivilege {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveMetastore returns a copy of the metastore.
+func (h *Handler) serveMetastore(w http.ResponseWriter, r *http.Request, u *User) {
+	// Set headers.
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="meta"`)
+
+	if err := h.server.CopyMetastore(w); err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// serveMetastoreIndex returns the index of the last broadcast message applied
+// to the metastore.
+func (h *Handler) serveMetastoreIndex(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(&indexJSON{Index: h.server.MetastoreIndex()})
+}
+
+// serveCompactBroadcast removes the broadcast messages applied by every data
+// node from the broker and returns the index of the last one removed.
+func (h *Handler) serveCompactBroadcast(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	index, err := h.server.CompactBroadcastTopic()
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(&indexJSON{Index: index})
+}
+
+// serveCompactShard compacts a shard stored on this server immediately,
+// whether or not it is idle.
+func (h *Handler) serveCompactShard(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	// Parse shard id.
+	shardID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid shard id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.server.CompactShard(shardID); err == ErrShardNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// indexJSON is the JSON representation of a broker index.
+type indexJSON struct {
+	Index uint64 `json:"index"`
+}
+
+// serveBackup streams a snapshot of the metastore and local shards as a tar archive.
+func (h *Handler) serveBackup(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", `attachment; filename="backup.tar"`)
+
+	// Headers are already sent once the archive starts streaming so errors
+	// can only be logged.
+	if err := h.server.Backup(w); err != nil {
+		h.server.Logger.Errorf("backup: %s", err)
+	}
+}
+
+// serveMetrics returns the server's counters, shard gauges and latency histograms
+// in the Prometheus text format.
+func (h *Handler) serveMetrics(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Add("content-type", "text/plain; version=0.0.4")
+	_ = h.server.WriteMetrics(w)
+}
+
+// serveDebugVars returns the published expvar variables, such as the Go
+// runtime's memory statistics, and the server's statistics under "influxdb".
+func (h *Handler) serveDebugVars(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+
+	b, err := json.Marshal(h.server.Stats())
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(w, "{\n")
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "%q: %s,\n", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "%q: %s\n}\n", "influxdb", b)
+}
+
+// serveShardStats returns the write counters for the shards on this server.
+func (h *Handler) serveShardStats(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.server.ShardStats())
+}
+
+// serveShardDigest returns the series digests of a shard stored on this server.
+func (h *Handler) serveShardDigest(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	// Parse shard id.
+	shardID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid shard id", http.StatusBadRequest)
+		return
+	}
+
+	a, err := h.server.ShardDigest(shardID)
+	if err == ErrShardNotFound || err == ErrShardNotLocal {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+}
+
+// serveShardSeries streams the values of a comma-separated list of series
+// ids in a shard stored on this server. The optional "min" and "max"
+// parameters limit the values to a range of nanosecond timestamps. This is
+// read by replicas repairing their copy of the shard and by data nodes
+// querying shards they don't store.
+func (h *Handler) serveShardSeries(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	// Parse shard id and series ids.
+	shardID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid shard id", http.StatusBadRequest)
+		return
+	}
+	var seriesIDs []uint32
+	if s := r.URL.Query().Get("ids"); s != "" {
+		for _, v := range strings.Split(s, ",") {
+			id, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				h.error(w, "invalid series id", http.StatusBadRequest)
+				return
+			}
+			seriesIDs = append(seriesIDs, uint32(id))
+		}
+	}
+	var min, max int64
+	if s := r.URL.Query().Get("min"); s != "" {
+		if min, err = strconv.ParseInt(s, 10, 64); err != nil {
+			h.error(w, "invalid min", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := r.URL.Query().Get("max"); s != "" {
+		if max, err = strconv.ParseInt(s, 10, 64); err != nil {
+			h.error(w, "invalid max", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Nothing is written before the shard is found so those errors can
+	// still be returned. Other errors can only be logged.
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := h.server.CopyShardSeries(w, shardID, seriesIDs, min, max); err == ErrShardNotFound || err == ErrShardNotLocal {
+		h.error(w, err.Error(), http.StatusNotFound)
+	} else if err != nil {
+		h.server.Logger.Errorf("shard series: %s", err)
+	}
+}
+
+// serveShardSync waits until the server has applied the broker message given
+// by the "index" parameter to a shard stored on this server. The optional
+// "timeout" parameter limits the wait. Peers call this to confirm a write was
+// applied when a write consistency level is requested.
+func (h *Handler) serveShardSync(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	// Parse shard id, index and timeout.
+	q := r.URL.Query()
+	shardID, err := strconv.ParseUint(q.Get(":id"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid shard id", http.StatusBadRequest)
+		return
+	}
+	index, err := strconv.ParseUint(q.Get("index"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid index", http.StatusBadRequest)
+		return
+	}
+	timeout := DefaultSyncTimeout
+	if s := q.Get("timeout"); s != "" {
+		if timeout, err = time.ParseDuration(s); err != nil {
+			h.error(w, "invalid timeout", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.server.SyncShard(shardID, index, timeout); err == ErrShardNotFound || err == ErrShardNotLocal {
+		h.error(w, err.Error(), http.StatusNotFound)
+	} else if err == ErrSyncTimeout {
+		h.error(w, err.Error(), http.StatusServiceUnavailable)
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// serveRepair copies missing data to the local shards from their other
+// replicas. The "shard" parameter limits the repair to a single shard. The
+// "index" parameter waits for the server to apply a broadcast message first.
+func (h *Handler) serveRepair(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	if s := r.URL.Query().Get("index"); s != "" {
+		index, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			h.error(w, "invalid index", http.StatusBadRequest)
+			return
+		}
+		if err := h.server.SyncTimeout(index, DefaultSyncTimeout); err == ErrSyncTimeout {
+			h.error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	var a []*ShardRepair
+	if s := r.URL.Query().Get("shard"); s != "" {
+		shardID, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			h.error(w, "invalid shard id", http.StatusBadRequest)
+			return
+		}
+
+		rep, err := h.server.RepairShard(shardID)
+		if err == ErrShardNotFound || err == ErrShardNotLocal {
+			h.error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a = []*ShardRepair{rep}
+	} else {
+		var err error
+		if a, err = h.server.RepairShards(); err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+}
+
+// serveTail streams a sample of the writes applied to this server as one JSON
+// object per line. The "sample" parameter sends every nth write and the
+// "limit" parameter ends the stream after that many writes. Otherwise the
+// stream runs until the client disconnects.
+func (h *Handler) serveTail(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	// Parse the sample rate and limit.
+	q := r.URL.Query()
+	sample, limit := 1, 0
+	if s := q.Get("sample"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			h.error(w, "invalid sample", http.StatusBadRequest)
+			return
+		}
+		sample = n
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			h.error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	// Stop when the client goes away.
+	var closing <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closing = cn.CloseNotify()
+	}
+
+	c, cancel := h.server.TailWrites(sample)
+	defer cancel()
+
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	enc := json.NewEncoder(w)
+	for n := 0; limit == 0 || n < limit; n++ {
+		select {
+		case <-closing:
+			return
+		case aw := <-c:
+			if err := enc.Encode(aw); err != nil {
+				return
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+	}
+}
+
+// serveApplyErrors returns the recent errors from applying broker messages.
+func (h *Handler) serveApplyErrors(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.server.ApplyErrors())
+}
+
+// serveClearApplyErrors removes all retained errors from applying broker messages.
+func (h *Handler) serveClearApplyErrors(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	h.server.ClearApplyErrors()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// servePing returns a simple response to let the client know the server is running.
+func (h *Handler) servePing(w http.ResponseWriter, r *http.Request, u *User) {}
+
+// serveHealth responds with 200 if the server is open, even if it isn't ready.
+func (h *Handler) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if err := h.server.Live(); err != nil {
+		h.error(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
+// serveReady responds with 200 if the server can serve writes and queries.
+// Otherwise it responds with 503 and the reason it isn't ready.
+func (h *Handler) serveReady(w http.ResponseWriter, r *http.Request) {
+	if err := h.server.Ready(); err != nil {
+		h.error(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
+// serveDataNodes returns a list of all data nodes in the cluster.
+func (h *Handler) serveDataNodes(w http.ResponseWriter, r *http.Request, u *User) {
+	// Generate a list of objects for encoding to the API.
+	a := make([]*dataNodeJSON, 0)
+	for _, n := range h.server.DataNodes() {
+		a = append(a, &dataNodeJSON{
+			ID:            n.ID,
+			URL:           n.URL.String(),
+			Standby:       n.Standby,
+			Version:       n.Version,
+			MessageFormat: n.MessageFormat,
+		})
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+}
+
+// serveCreateDataNode creates a new data node in the cluster. A data node that
+// joins again with the same URL gets its existing ID back so that a data node
+// which failed partway through joining can retry.
+func (h *Handler) serveCreateDataNode(w http.ResponseWriter, r *http.Request, _ *User) {
+	// Read in data node from request body.
+	var n dataNodeJSON
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse the URL.
+	u, err := url.Parse(n.URL)
+	if err != nil {
+		h.error(w, "invalid data node url", http.StatusBadRequest)
+		return
+	}
+
+	// Create the data node with the version and message format it reported.
+	// A data node rejoining in the same role reuses its existing ID.
+	status := http.StatusCreated
+	c := &createDataNodeCommand{URL: u.String(), Standby: n.Standby, Version: n.Version, MessageFormat: n.MessageFormat}
+	if err := h.server.joinDataNode(c); err == ErrDataNodeExists {
+		if node := h.server.DataNodeByURL(u); node == nil || node.Standby != n.Standby {
+			h.error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		status = http.StatusOK
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Retrieve data node reference.
+	node := h.server.DataNodeByURL(u)
+
+	// Create a new replica on the broker, unless an earlier join created it.
+	err = h.server.client.CreateReplica(node.ID)
+	if err == messaging.ErrReplicaExists && status == http.StatusOK {
+		err = nil
+	}
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	// Write node back to client.
+	w.WriteHeader(status)
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(&dataNodeJSON{ID: node.ID, URL: node.URL.String(), Standby: node.Standby})
+}
+
+// serveDeleteDataNode removes an existing node.
+func (h *Handler) serveDeleteDataNode(w http.ResponseWriter, r *http.Request, u *User) {
+	// Parse node id.
+	nodeID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid node id", http.StatusBadRequest)
+		return
+	}
+
+	// Delete the node.
+	if err := h.server.DeleteDataNode(nodeID); err == ErrDataNodeNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveDecommissionDataNode moves a data node's shards to the other data nodes
+// and then removes the data node from the cluster.
+func (h *Handler) serveDecommissionDataNode(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	// Parse node id.
+	nodeID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid node id", http.StatusBadRequest)
+		return
+	}
+
+	// Move the node's shards and delete the node.
+	a, err := h.server.DecommissionDataNode(nodeID)
+	if err == ErrDataNodeNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err == ErrNoReplacementDataNode {
+		h.error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+}
+
+// servePromoteDataNode promotes a standby data node to take over the shards
+// of the data node given by the "replace" parameter.
+func (h *Handler) servePromoteDataNode(w http.ResponseWriter, r *http.Request, u *User) {
+	if u != nil && !u.Admin {
+		h.error(w, "admin privileges required", http.StatusForbidden)
+		return
+	}
+
+	// Parse node ids.
+	q := r.URL.Query()
+	nodeID, err := strconv.ParseUint(q.Get(":id"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid node id", http.StatusBadRequest)
+		return
+	}
+	replaceID, err := strconv.ParseUint(q.Get("replace"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid replace node id", http.StatusBadRequest)
+		return
+	}
+
+	// Take over the replaced node's shards.
+	if _, err := h.server.PromoteDataNode(nodeID, replaceID); err == ErrDataNodeNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err == ErrDataNodeNotStandby || err == ErrDataNodeStandby {
+		h.error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type dataNodeJSON struct {
+	ID            uint64 `json:"id"`
+	URL           string `json:"url"`
+	Standby       bool   `json:"standby,omitempty"`
+	Version       string `json:"version,omitempty"`
+	MessageFormat int    `json:"messageFormat,omitempty"`
+}
+
+// gzipFilter wraps a handler to decompress request bodies sent with
+// "Content-Encoding: gzip" and to compress responses for clients that send
+// "Accept-Encoding: gzip".
+func gzipFilter(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "invalid gzip body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer gr.Close()
+			r.Body = gr
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			fn(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		defer gw.Close()
+		fn(&gzipResponseWriter{ResponseWriter: w, gw: gw}, r)
+	}
+}
+
+// acceptsGzip returns true if the request's Accept-Encoding header allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if enc = strings.TrimSpace(enc); enc == "gzip" || strings.HasPrefix(enc, "gzip;") {
+			return !strings.HasSuffix(strings.Replace(enc, " ", "", -1), ";q=0")
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the response body written by a handler.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gw *gzip.Writer
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	// Content-Length is for the uncompressed body so don't send it.
+	w.Header().Del("Content-Length")
+	return w.gw.Write(b)
+}
+
+// Flush sends the data compressed so far to the client so that streamed
+// responses aren't held in the compressor.
+func (w *gzipResponseWriter) Flush() {
+	_ = w.gw.Flush()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// logRequestError logs an error for a request that supplied a request id.
+func (h *Handler) logRequestError(r *http.Request, err error) {
+	if id := r.Header.Get(RequestIDHeader); id != "" {
+		h.server.Logger.Errorf("request error: request=%s, method=%s, path=%s, err=%s", id, r.Method, r.URL.Path, err)
+	}
 }
 
 // error returns an error to the client in a standard format.
@@ -1,16 +1,24 @@
 package influxdb_test
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/messaging"
 )
 
 func init() {
@@ -70,6 +78,22 @@ func TestHandler_CreateDatabase_Conflict(t *testing.T) {
 	}
 }
 
+func TestHandler_CreateDatabase_Atomic(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "CREATE DATABASE bar; CREATE DATABASE foo", "atomic": "true"}, nil, "")
+	if status != http.StatusInternalServerError {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"error":"not executed"},{"error":"database exists"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	} else if srvr.DatabaseExists("bar") {
+		t.Fatal("unexpected database: bar")
+	}
+}
+
 func TestHandler_DeleteDatabase(t *testing.T) {
 	srvr := OpenServer(NewMessagingClient())
 	srvr.CreateDatabase("foo")
@@ -187,7 +211,6 @@ func TestHandler_CreateRetentionPolicy_BadRequest(t *testing.T) {
 }
 
 func TestHandler_UpdateRetentionPolicy(t *testing.T) {
-	t.Skip()
 	srvr := OpenServer(NewMessagingClient())
 	srvr.CreateDatabase("foo")
 	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
@@ -201,10 +224,12 @@ func TestHandler_UpdateRetentionPolicy(t *testing.T) {
 	p, _ := srvr.RetentionPolicy("foo", "bar")
 	if status != http.StatusOK {
 		t.Fatalf("unexpected status: %d", status)
-	} else if body != "" {
+	} else if body != `[{}]` {
 		t.Fatalf("unexpected body: %s", body)
 	} else if p.ReplicaN != 42 {
 		t.Fatalf("unexpected replication factor: %d", p.ReplicaN)
+	} else if p.Duration != time.Minute {
+		t.Fatalf("unexpected duration: %s", p.Duration)
 	}
 }
 
@@ -314,6 +339,114 @@ func TestHandler_Ping(t *testing.T) {
 	}
 }
 
+func TestHandler_Ready(t *testing.T) {
+	srvr := OpenUninitializedServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// An uninitialized server is live but not ready.
+	if status, _ := MustHTTP("GET", s.URL+`/health`, nil, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected health status: %d", status)
+	} else if status, body := MustHTTP("GET", s.URL+`/ready`, nil, nil, ""); status != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected ready status: %d", status)
+	} else if body != `data node not found` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// The server is ready once it has joined a cluster.
+	if err := srvr.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	} else if status, body := MustHTTP("GET", s.URL+`/ready`, nil, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected ready status: %d: %s", status, body)
+	}
+}
+
+func TestHandler_ApplyErrors(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/errors`, nil, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, _ = MustHTTP("DELETE", s.URL+`/errors`, nil, nil, "")
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_Events(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/events`, map[string]string{"db": "foo"}, nil, `{"name":"deploys","startTime":"2000-01-01T00:00:00Z","text":"v1.0"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"id":3}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/events`, map[string]string{"db": "foo", "start": "2000-01-01T00:00:00Z"}, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"id":3,"name":"deploys","startTime":"2000-01-01T00:00:00Z","endTime":"2000-01-01T00:00:00Z","text":"v1.0"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_CreateEvent_BadRequest(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/events`, map[string]string{"db": "foo"}, nil, `{"startTime":"2000-01-01T00:00:00Z"}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `event name required` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Events_DatabaseNotFound(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, _ := MustHTTP("GET", s.URL+`/events`, map[string]string{"db": "foo"}, nil, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_Events_Unauthorized(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateUser("lisa", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	params := map[string]string{"db": "foo", "u": "lisa", "p": "password"}
+	if status, _ := MustHTTP("GET", s.URL+`/events`, params, nil, ""); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// Reading events doesn't allow creating them.
+	srvr.SetPrivilege("lisa", "foo", influxql.ReadPrivilege)
+	if status, _ := MustHTTP("GET", s.URL+`/events`, params, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	if status, _ := MustHTTP("POST", s.URL+`/events`, params, nil, `{"name":"deploys","startTime":"2000-01-01T00:00:00Z"}`); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
 func TestHandler_Users_NoUsers(t *testing.T) {
 	t.Skip()
 	srvr := OpenServer(NewMessagingClient())
@@ -514,6 +647,47 @@ func TestHandler_CreateDataNode(t *testing.T) {
 	}
 }
 
+// Ensure a data node can retry joining and get its existing ID back.
+func TestHandler_CreateDataNode_Rejoin(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Track the replicas created on the broker.
+	replicas := make(map[uint64]bool)
+	c.CreateReplicaFunc = func(replicaID uint64) error {
+		if replicas[replicaID] {
+			return messaging.ErrReplicaExists
+		}
+		replicas[replicaID] = true
+		return nil
+	}
+
+	status, body := MustHTTP("POST", s.URL+`/data_nodes`, nil, nil, `{"url":"http://localhost:1000"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("unexpected status: %d, %s", status, body)
+	} else if body != `{"id":2,"url":"http://localhost:1000"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Joining again returns the same data node.
+	status, body = MustHTTP("POST", s.URL+`/data_nodes`, nil, nil, `{"url":"http://localhost:1000"}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d, %s", status, body)
+	} else if body != `{"id":2,"url":"http://localhost:1000"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Joining in a different role is a conflict.
+	status, body = MustHTTP("POST", s.URL+`/data_nodes`, nil, nil, `{"url":"http://localhost:1000","standby":true}`)
+	if status != http.StatusConflict {
+		t.Fatalf("unexpected status: %d, %s", status, body)
+	} else if body != `data node exists` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
 func TestHandler_CreateDataNode_BadRequest(t *testing.T) {
 	t.Skip()
 	srvr := OpenServer(NewMessagingClient())
@@ -571,6 +745,94 @@ func TestHandler_DeleteUser_DataNodeNotFound(t *testing.T) {
 	}
 }
 
+func TestHandler_DecommissionDataNode_NoReplacementDataNode(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("raw"))
+	srvr.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T00:00:00Z"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/data_nodes/1/decommission`, nil, nil, "")
+	if status != http.StatusConflict {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `no data node available to take over shard` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_PromoteDataNode_NotStandby(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDataNode(&url.URL{Host: "127.0.0.1:8087"})
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/data_nodes/2/promote`, map[string]string{"replace": "1"}, nil, "")
+	if status != http.StatusConflict {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `data node is not a standby` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_SetPrivilege(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateUser("susy", "pass", false)
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("PUT", s.URL+`/users/susy/privileges`, nil, nil, `{"database":"foo","privilege":"write"}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if p := srvr.User("susy").Privileges["foo"]; p != influxql.WritePrivilege {
+		t.Fatalf("unexpected privilege: %s", p)
+	}
+
+	status, body = MustHTTP("PUT", s.URL+`/users/susy/privileges`, nil, nil, `{"database":"foo","privilege":"owner"}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `invalid privilege` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, _ = MustHTTP("PUT", s.URL+`/users/bob/privileges`, nil, nil, `{"database":"foo","privilege":"read"}`)
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_MetastoreIndex(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/metastore/index`, nil, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != fmt.Sprintf(`{"index":%d}`, srvr.MetastoreIndex()) {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_CompactShard(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	srvr.CreateShardGroupIfNotExists("foo", "bar", time.Now())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	a, _ := srvr.ShardGroups("foo")
+	if status, body := MustHTTP("POST", s.URL+fmt.Sprintf(`/shards/%d/compact`, a[0].Shards[0].ID), nil, nil, ""); status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	if status, _ := MustHTTP("POST", s.URL+`/shards/1000/compact`, nil, nil, ""); status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
 // Perform a subset of endpoint testing, with authentication enabled.
 
 func TestHandler_AuthenticatedCreateAdminUser(t *testing.T) {
@@ -672,6 +934,66 @@ func TestHandler_AuthenticatedDatabases_UnauthorizedBasicAuth(t *testing.T) {
 	}
 }
 
+func TestHandler_AuthenticatedDatabases_Token(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", true)
+	_, secret, err := srvr.CreateToken("lisa", influxql.ReadPrivilege, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	// A read token can list databases but can't create them.
+	auth := map[string]string{"Authorization": "Token " + secret}
+	status, _ := MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "LIST DATABASES"}, auth, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	status, body := MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "CREATE DATABASE foo"}, auth, "")
+	if status != http.StatusInternalServerError || !strings.Contains(body, "admin privileges required") {
+		t.Fatalf("unexpected status: %d, %s", status, body)
+	}
+
+	// Unknown tokens are rejected.
+	status, body = MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "LIST DATABASES"}, map[string]string{"Authorization": "Token bad"}, "")
+	if status != http.StatusUnauthorized || !strings.Contains(body, "invalid token") {
+		t.Fatalf("unexpected status: %d, %s", status, body)
+	}
+}
+
+// Ensure authenticated requests are rejected over plain HTTP when HTTPS is required.
+func TestHandler_HTTPSRequired(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", true)
+	h := influxdb.NewHandler(srvr.Server)
+	h.AuthenticationEnabled = true
+	h.HTTPSRequired = true
+
+	// Plain HTTP requests are rejected before credentials are checked.
+	s := httptest.NewServer(h)
+	defer s.Close()
+	status, body := MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "LIST DATABASES", "u": "lisa", "p": "password"}, nil, "")
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `https required` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// HTTPS requests are authenticated as usual.
+	tlsServer := httptest.NewTLSServer(h)
+	defer tlsServer.Close()
+	req, _ := http.NewRequest("GET", tlsServer.URL+`/query?q=LIST+DATABASES&u=lisa&p=password`, nil)
+	resp, err := tlsServer.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+}
+
 func TestHandler_serveWriteSeries(t *testing.T) {
 	srvr := OpenServer(NewMessagingClient())
 	srvr.CreateDatabase("foo")
@@ -686,64 +1008,739 @@ func TestHandler_serveWriteSeries(t *testing.T) {
 	}
 }
 
-func TestHandler_serveWriteSeries_noDatabaseExists(t *testing.T) {
+func TestHandler_serveWriteSeries_retentionPolicy(t *testing.T) {
 	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("baz"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
 	s := NewHTTPServer(srvr)
 	defer s.Close()
 
-	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}]}`)
+	// The "rp" parameter applies unless the point sets its own policy.
+	status, body := MustHTTP("POST", s.URL+`/write`, map[string]string{"rp": "baz"}, nil, `{"database" : "foo", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}, {"name": "cpu", "retentionPolicy": "bar", "timestamp": "2009-11-10T23:00:00Z","values": {"value": 20}}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
 
-	expectedStatus := http.StatusNotFound
-	if status != expectedStatus {
-		t.Fatalf("unexpected status: expected: %d, actual: %d", expectedStatus, status)
+	// Wait for the writes to be applied.
+	if index, err := srvr.WriteSeries("foo", "bar", []influxdb.Point{{Name: "mem", Timestamp: mustParseTime("2009-11-10T23:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}}); err != nil {
+		t.Fatal(err)
+	} else if err := srvr.Sync(index); err != nil {
+		t.Fatal(err)
 	}
 
-	response := `{"error":"database not found: \"foo\""}`
-	if body != response {
-		t.Fatalf("unexpected body: expected %s, actual %s", response, body)
+	for _, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SELECT sum(value) FROM "foo"."baz".cpu`, exp: `[{"rows":[{"name":"\"foo\".\"baz\".cpu","columns":["time","sum"],"values":[[0,100]]}]}]`},
+		{q: `SELECT sum(value) FROM "foo"."bar".cpu`, exp: `[{"rows":[{"name":"\"foo\".\"bar\".cpu","columns":["time","sum"],"values":[[0,20]]}]}]`},
+	} {
+		results := srvr.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if err := results.Error(); err != nil {
+			t.Fatal(err)
+		} else if s := mustMarshalJSON(results); s != tt.exp {
+			t.Fatalf("unexpected results: %s: %s", tt.q, s)
+		}
 	}
 }
 
-func TestHandler_serveWriteSeries_invalidJSON(t *testing.T) {
+func TestHandler_serveQuery_CSV(t *testing.T) {
 	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "raw")
+	for i, host := range []string{"a", "b"} {
+		srvr.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": host, "region": "us, east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(i) + 0.5}}})
+	}
 	s := NewHTTPServer(srvr)
 	defer s.Close()
 
-	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}]}`)
-
-	if status != http.StatusInternalServerError {
-		t.Fatalf("unexpected status: expected: %d, actual: %d", http.StatusInternalServerError, status)
+	// A header is written for each set of fields and errors are written as records.
+	query := map[string]string{"db": "foo", "format": "csv", "q": "SELECT sum(value) FROM cpu GROUP BY host, region; SELECT sum(value) FROM cpu; SELECT sum(value) FROM bar"}
+	status, body := MustHTTP("GET", s.URL+`/query`, query, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != "name,host,region,time,sum\n"+
+		"cpu,a,\"us, east\",0,0.5\n"+
+		"cpu,b,\"us, east\",0,1.5\n"+
+		"name,time,sum\n"+
+		"cpu,0,2\n"+
+		"error\n"+
+		"field not found: bar.value" {
+		t.Fatalf("unexpected body: %s", body)
 	}
 
-	response := `{"error":"invalid character 'o' in literal false (expecting 'a')"}`
-	if body != response {
-		t.Fatalf("unexpected body: expected %s, actual %s", response, body)
+	// CSV is also returned when it is accepted.
+	query = map[string]string{"db": "foo", "q": "SELECT sum(value) FROM cpu", "timeColumn": "ts"}
+	status, body = MustHTTP("GET", s.URL+`/query`, query, map[string]string{"Accept": "text/csv"}, "")
+	if status != http.StatusOK || body != "name,ts,sum\ncpu,0,2" {
+		t.Fatalf("unexpected response: %d: %s", status, body)
 	}
 }
 
-func TestHandler_serveWriteSeries_noDatabaseSpecified(t *testing.T) {
+func TestHandler_serveQuery_Msgpack(t *testing.T) {
 	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "raw")
+	srvr.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(2)}}})
 	s := NewHTTPServer(srvr)
 	defer s.Close()
 
-	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{}`)
-
-	if status != http.StatusInternalServerError {
-		t.Fatalf("unexpected status: expected: %d, actual: %d", http.StatusInternalServerError, status)
+	// [{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,2.0]]}]},{"error":"field not found: bar.value"}]
+	exp := "92" +
+		"81" + "a4" + hex.EncodeToString([]byte("rows")) + "91" +
+		"83" + "a4" + hex.EncodeToString([]byte("name")) + "a3" + hex.EncodeToString([]byte("cpu")) +
+		"a7" + hex.EncodeToString([]byte("columns")) + "92" + "a4" + hex.EncodeToString([]byte("time")) + "a3" + hex.EncodeToString([]byte("sum")) +
+		"a6" + hex.EncodeToString([]byte("values")) + "91" + "92" + "00" + "cb4000000000000000" +
+		"81" + "a5" + hex.EncodeToString([]byte("error")) + "ba" + hex.EncodeToString([]byte("field not found: bar.value"))
+
+	for _, tt := range []struct {
+		query   map[string]string
+		headers map[string]string
+	}{
+		{query: map[string]string{"db": "foo", "q": "SELECT sum(value) FROM cpu; SELECT sum(value) FROM bar", "format": "msgpack"}},
+		{query: map[string]string{"db": "foo", "q": "SELECT sum(value) FROM cpu; SELECT sum(value) FROM bar"}, headers: map[string]string{"Accept": "application/x-msgpack"}},
+	} {
+		status, body := MustHTTP("GET", s.URL+`/query`, tt.query, tt.headers, "")
+		if status != http.StatusInternalServerError {
+			t.Fatalf("unexpected status: %d", status)
+		} else if s := hex.EncodeToString([]byte(body)); s != exp {
+			t.Fatalf("unexpected body:\n\nexp=%s\n\ngot=%s\n\n", exp, s)
+		}
 	}
+}
 
-	response := `{"error":"database is required"}`
-	if body != response {
-		t.Fatalf("unexpected body: expected %s, actual %s", response, body)
+func TestHandler_serveWriteSeries_retentionPolicyNotFound(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/write`, map[string]string{"rp": "qux"}, nil, `{"database" : "foo", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}]}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"error":"point 0: retention policy not found: \"qux\"","rejected":[{"index":0,"name":"cpu","error":"retention policy not found: \"qux\""}]}` {
+		t.Fatalf("unexpected body: %s", body)
 	}
 }
 
-// Utility functions for this test suite.
+func TestHandler_serveQuery_JSONLines(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "raw")
+	for _, host := range []string{"a", "b"} {
+		srvr.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": host}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+	}
+	s := NewHTTPServer(srvr)
+	defer s.Close()
 
-func MustHTTP(verb, path string, params, headers map[string]string, body string) (int, string) {
-	req, err := http.NewRequest(verb, path, bytes.NewBuffer([]byte(body)))
-	if err != nil {
-		panic(err)
+	query := map[string]string{"db": "foo", "q": "SELECT sum(value) FROM cpu; SELECT sum(value) FROM cpu WHERE host = 'a'; SELECT sum(value) FROM bar; SELECT sum(value) FROM cpu"}
+	status, body := MustHTTP("GET", s.URL+`/query`, query, map[string]string{"Accept": "application/x-ndjson"}, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"statementId":0,"name":"cpu","columns":["time","sum"],"values":[[0,2]]}`+"\n"+
+		`{"statementId":1,"name":"cpu","columns":["time","sum"],"values":[[0,1]]}`+"\n"+
+		`{"statementId":2,"error":"field not found: bar.value"}`+"\n"+
+		`{"statementId":3,"error":"not executed"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure streamed rows are flushed through a compressed response.
+func TestHandler_serveQuery_JSONLines_Gzip(t *testing.T) {
+	storage := NewMemoryStorage()
+	srvr := NewServer()
+	if err := srvr.SetStorageEngine(func(path string) (influxdb.StorageEngine, error) { return storage, nil }); err != nil {
+		t.Fatal(err)
+	} else if err := srvr.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := srvr.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := srvr.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	defer srvr.Close()
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "raw")
+	srvr.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Block the select until the first row has been read.
+	release := make(chan struct{})
+	storage.SeekFunc = func() { <-release }
+	defer close(release)
+
+	req, _ := http.NewRequest("GET", s.URL+`/query?db=foo&q=LIST+DATABASES%3B+SELECT+sum(value)+FROM+cpu`, nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	gr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line, err := bufio.NewReader(gr).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	} else if line != `{"statementId":0,"columns":["Name"],"values":[["foo"]]}`+"\n" {
+		t.Fatalf("unexpected line: %s", line)
+	}
+}
+
+func TestHandler_serveQuery_Flat(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	srvr.SetDefaultRetentionPolicy("foo", "raw")
+	srvr.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	query := map[string]string{"db": "foo", "q": "SELECT sum(value) FROM cpu; SELECT sum(value) FROM bar", "format": "flat", "timeColumn": "ts"}
+	status, body := MustHTTP("GET", s.URL+`/query`, query, nil, "")
+	if status != http.StatusInternalServerError {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"rows":[{"name":"cpu","sum":1,"ts":0}]},{"error":"field not found: bar.value"}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// The time column can be renamed without flattening the rows.
+	query = map[string]string{"db": "foo", "q": "SELECT sum(value) FROM cpu", "timeColumn": "ts"}
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"rows":[{"name":"cpu","columns":["ts","sum"],"values":[[0,1]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Unknown formats are rejected.
+	query = map[string]string{"db": "foo", "q": "SELECT sum(value) FROM cpu", "format": "xml"}
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `unknown format: "xml"` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_serveMetrics(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/metrics`, nil, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if !strings.Contains(body, "# TYPE influxdb_query_duration_seconds histogram") {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure server statistics are only served to admin users.
+func TestHandler_serveStats_nonAdminUser(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	for _, path := range []string{"/metrics", "/debug/vars", "/shard_stats", "/metastore/index"} {
+		if status, _ := MustHTTP("GET", s.URL+path, map[string]string{"u": "lisa", "p": "password"}, nil, ""); status != http.StatusForbidden {
+			t.Errorf("%s: unexpected status: %d", path, status)
+		}
+	}
+}
+
+func TestHandler_serveDebugVars(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/debug/vars`, nil, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	var vars struct {
+		MemStats *struct{ HeapAlloc uint64 } `json:"memstats"`
+		InfluxDB *influxdb.ServerStats       `json:"influxdb"`
+	}
+	if err := json.Unmarshal([]byte(body), &vars); err != nil {
+		t.Fatalf("unmarshal: %s: %s", err, body)
+	} else if vars.MemStats == nil || vars.MemStats.HeapAlloc == 0 {
+		t.Fatalf("unexpected memstats: %s", body)
+	} else if vars.InfluxDB == nil || vars.InfluxDB.Index != srvr.Stats().Index {
+		t.Fatalf("unexpected stats: %s", body)
+	}
+}
+
+func TestHandler_serveWriteSeries_invalidTTL(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z", "ttl": "soon", "values": {"value": 100}}]}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"error":"point 0: invalid ttl: \"soon\"","rejected":[{"index":0,"name":"cpu","error":"invalid ttl: \"soon\""}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_serveWriteSeries_rejectedPoints(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	srvr.MustWriteSeries("foo", "bar", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2009-11-10T22:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Rejected points are listed by position and the others are written.
+	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "timestamp": "2009-11-10T23:00:00Z", "points": [{"name": "cpu", "values": {"value": 10}}, {"name": "cpu", "ttl": "soon", "values": {"value": 5}}, {"values": {"value": 1}}]}
+{"database" : "foo", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:01Z", "values": {"value": 20}}, {"name": "cpu", "retentionPolicy": "qux", "values": {"value": 1}}]}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"error":"point 1: invalid ttl: \"soon\"; point 2: measurement name required; point 4: retention policy not found: \"qux\"","rejected":[{"index":1,"name":"cpu","error":"invalid ttl: \"soon\""},{"index":2,"error":"measurement name required"},{"index":4,"name":"cpu","error":"retention policy not found: \"qux\""}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	if index, err := srvr.WriteSeries("foo", "bar", []influxdb.Point{{Name: "mem", Timestamp: mustParseTime("2009-11-10T23:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}}); err != nil {
+		t.Fatal(err)
+	} else if err := srvr.Sync(index); err != nil {
+		t.Fatal(err)
+	}
+	results := srvr.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,31]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
+
+func TestHandler_serveWriteSeries_publishError(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenServer(c)
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Publish failures are server errors, not rejected points.
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) { return 0, fmt.Errorf("broker unavailable") }
+	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z", "values": {"value": 100}}]}`)
+	if status != http.StatusInternalServerError {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"error":"broker unavailable"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	query := map[string]string{"db": "foo", "precision": "s"}
+	headers := map[string]string{"Content-Type": "text/plain"}
+	status, body = MustHTTP("POST", s.URL+`/write`, query, headers, "cpu value=x 1257894000\ncpu value=100 1257894001\n")
+	if status != http.StatusInternalServerError {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"error":"broker unavailable"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_serveWriteSeries_consistency(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// The write is applied before the request returns.
+	status, body := MustHTTP("POST", s.URL+`/write`, map[string]string{"consistency": "all"}, nil, `{"database" : "foo", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	results := srvr.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,100]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+
+	// Unknown levels are rejected for both formats.
+	status, body = MustHTTP("POST", s.URL+`/write`, map[string]string{"consistency": "most"}, nil, `{"database" : "foo", "points": []}`)
+	if status != http.StatusBadRequest || body != `{"error":"invalid consistency level"}` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, body = MustHTTP("POST", s.URL+`/write`, map[string]string{"db": "foo", "consistency": "most"}, map[string]string{"Content-Type": "text/plain"}, "cpu value=1\n")
+	if status != http.StatusBadRequest || body != `{"error":"invalid consistency level"}` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+}
+
+func TestHandler_serveWriteSeries_precision(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Epoch timestamps on the batch and its points are read in seconds.
+	status, body := MustHTTP("POST", s.URL+`/write`, map[string]string{"precision": "s"}, nil, `{"database" : "foo", "timestamp": 1257894001, "points": [{"name": "cpu", "timestamp": 1257894000, "values": {"value": 1}}, {"name": "cpu", "values": {"value": 2}}, {"name": "cpu", "timestamp": "2009-11-10T23:00:02Z", "values": {"value": 4}}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	if index, err := srvr.WriteSeries("foo", "bar", []influxdb.Point{{Name: "mem", Timestamp: mustParseTime("2009-11-10T23:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}}); err != nil {
+		t.Fatal(err)
+	} else if err := srvr.Sync(index); err != nil {
+		t.Fatal(err)
+	}
+	results := srvr.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2009-11-10 23:00:00' AND time < '2009-11-10 23:00:03' GROUP BY time(1s)`), "foo", nil)
+	if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[1257894000000000,1],[1257894001000000,2],[1257894002000000,4]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+
+	// Unknown precisions are rejected.
+	status, body = MustHTTP("POST", s.URL+`/write`, map[string]string{"precision": "d"}, nil, `{"database" : "foo", "points": []}`)
+	if status != http.StatusBadRequest || body != `{"error":"invalid precision"}` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+}
+
+func TestHandler_serveWriteSeries_async(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Async writes require the write queue.
+	status, body := MustHTTP("POST", s.URL+`/write`, map[string]string{"async": "true"}, nil, `{"database" : "foo", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}]}`)
+	if status != http.StatusBadRequest || body != `{"error":"write queue not enabled"}` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+	status, body = MustHTTP("POST", s.URL+`/write`, map[string]string{"async": "true", "consistency": "all"}, nil, `{"database" : "foo", "points": []}`)
+	if status != http.StatusBadRequest || body != `{"error":"async writes cannot use consistency level: all"}` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+
+	// Both formats are accepted once the queue is started.
+	if err := srvr.StartWriteQueue(0, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	status, body = MustHTTP("POST", s.URL+`/write`, map[string]string{"async": "true"}, nil, `{"database" : "foo", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}]}`)
+	if status != http.StatusAccepted {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	status, body = MustHTTP("POST", s.URL+`/write`, map[string]string{"db": "foo", "async": "true"}, map[string]string{"Content-Type": "text/plain"}, "cpu value=1 1257894001000000000\n")
+	if status != http.StatusAccepted {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	for i := 0; srvr.WriteQueueLen() > 0; i++ {
+		if i == 100 {
+			t.Fatal("write queue not drained")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if index, err := srvr.WriteSeries("foo", "bar", []influxdb.Point{{Name: "mem", Timestamp: mustParseTime("2009-11-10T23:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}}); err != nil {
+		t.Fatal(err)
+	} else if err := srvr.Sync(index); err != nil {
+		t.Fatal(err)
+	}
+	results := srvr.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,101]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
+
+func TestHandler_serveWriteSeries_lineProtocol(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	query := map[string]string{"db": "foo", "rp": "bar", "precision": "s"}
+	headers := map[string]string{"Content-Type": "text/plain"}
+	status, body := MustHTTP("POST", s.URL+`/write`, query, headers, "cpu,host=server01 value=100 1257894000\ncpu,host=server01 value=x 1257894001\n")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"error":"line 2: invalid field value: x","rejected":[{"line":2,"error":"invalid field value: x"}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// The valid line is still written.
+	if index, err := srvr.WriteSeries("foo", "bar", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "server02"}, Timestamp: mustParseTime("2009-11-10T23:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}}); err != nil {
+		t.Fatal(err)
+	} else if err := srvr.Sync(index); err != nil {
+		t.Fatal(err)
+	}
+	results := srvr.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,101]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
+
+// Ensure each rejected line is reported with its line number and reason.
+func TestHandler_serveWriteSeries_lineProtocol_rejectedLines(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	query := map[string]string{"db": "foo", "precision": "s"}
+	headers := map[string]string{"Content-Type": "text/plain"}
+	status, body := MustHTTP("POST", s.URL+`/write`, query, headers, "cpu value=x 1257894000\ncpu value=1 1257894001\n\ncpu value=y 1257894002\n")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"error":"line 1: invalid field value: x; line 4: invalid field value: y","rejected":[{"line":1,"error":"invalid field value: x"},{"line":4,"error":"invalid field value: y"}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_serveWriteSeries_unauthorizedUser(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.CreateUser("lisa", "password", false)
+	srvr.GrantPrivilege("lisa", "foo", influxql.ReadPrivilege)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	query := map[string]string{"u": "lisa", "p": "password"}
+	body := `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}]}`
+	if status, _ := MustHTTP("POST", s.URL+`/write`, query, nil, body); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// Granting write access allows the write.
+	srvr.GrantPrivilege("lisa", "foo", influxql.WritePrivilege)
+	if status, _ := MustHTTP("POST", s.URL+`/write`, query, nil, body); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_serveBackup_nonAdminUser(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", false)
+	srvr.CreateUser("susy", "password", true)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	if status, _ := MustHTTP("GET", s.URL+`/backup`, map[string]string{"u": "lisa", "p": "password"}, nil, ""); status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	if status, body := MustHTTP("GET", s.URL+`/backup`, map[string]string{"u": "susy", "p": "password"}, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body == "" {
+		t.Fatal("expected backup archive")
+	}
+}
+
+func TestHandler_serveWriteSeries_noDatabaseExists(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}]}`)
+
+	expectedStatus := http.StatusNotFound
+	if status != expectedStatus {
+		t.Fatalf("unexpected status: expected: %d, actual: %d", expectedStatus, status)
+	}
+
+	response := `{"error":"database not found: \"foo\""}`
+	if body != response {
+		t.Fatalf("unexpected body: expected %s, actual %s", response, body)
+	}
+}
+
+func TestHandler_serveWriteSeries_invalidJSON(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}]}`)
+
+	if status != http.StatusInternalServerError {
+		t.Fatalf("unexpected status: expected: %d, actual: %d", http.StatusInternalServerError, status)
+	}
+
+	response := `{"error":"invalid character 'o' in literal false (expecting 'a')"}`
+	if body != response {
+		t.Fatalf("unexpected body: expected %s, actual %s", response, body)
+	}
+}
+
+func TestHandler_serveWriteSeries_noDatabaseSpecified(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{}`)
+
+	if status != http.StatusInternalServerError {
+		t.Fatalf("unexpected status: expected: %d, actual: %d", http.StatusInternalServerError, status)
+	}
+
+	response := `{"error":"database is required"}`
+	if body != response {
+		t.Fatalf("unexpected body: expected %s, actual %s", response, body)
+	}
+}
+
+func TestHandler_serveWriteSeries_requestID(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	for i, tt := range []struct {
+		body   string
+		status int
+	}{
+		{body: `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}]}`, status: http.StatusOK},
+		{body: `{"database" : "baz"}`, status: http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest("POST", s.URL+`/write`, strings.NewReader(tt.body))
+		req.Header.Set(influxdb.RequestIDHeader, "req-1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%d. unexpected status: %d", i, resp.StatusCode)
+		} else if id := resp.Header.Get(influxdb.RequestIDHeader); id != "req-1" {
+			t.Errorf("%d. unexpected request id: %q", i, id)
+		}
+	}
+}
+
+// Ensure the handler accepts gzip-encoded writes and compresses query results.
+func TestHandler_Gzip(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Write a compressed body.
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte(`{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}]}`))
+	gw.Close()
+	status, _ := MustHTTP("POST", s.URL+`/write`, nil, map[string]string{"Content-Encoding": "gzip"}, buf.String())
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// A body that isn't gzip is rejected.
+	status, _ = MustHTTP("POST", s.URL+`/write`, nil, map[string]string{"Content-Encoding": "gzip"}, `{"database" : "foo"}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// Wait for the write to be applied.
+	if index, err := srvr.WriteSeries("foo", "bar", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "server02"}, Timestamp: mustParseTime("2009-11-10T23:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}}); err != nil {
+		t.Fatal(err)
+	} else if err := srvr.Sync(index); err != nil {
+		t.Fatal(err)
+	}
+
+	// Query with a compressed response.
+	req, _ := http.NewRequest("GET", s.URL+`/query?db=foo&q=SELECT+sum(value)+FROM+cpu`, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if enc := resp.Header.Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("unexpected content encoding: %q", enc)
+	}
+	gr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(gr); strings.TrimRight(string(b), "\n") != `[{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,101]]}]}]` {
+		t.Fatalf("unexpected body: %s", b)
+	}
+
+	// Responses are uncompressed unless requested.
+	status, body := MustHTTP("GET", s.URL+`/query`, map[string]string{"db": "foo", "q": "SELECT sum(value) FROM cpu"}, map[string]string{"Accept-Encoding": "identity"}, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `[{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,101]]}]}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure the handler streams applied writes.
+func TestHandler_Tail(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// The tail is attached once the response headers are received.
+	resp, err := http.Get(s.URL + `/tail?limit=1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+
+	srvr.MustWriteSeries("foo", "bar", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "server01"}, Timestamp: mustParseTime("2009-11-10T23:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	if b, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	} else if string(b) != `{"database":"foo","retentionPolicy":"bar","measurement":"cpu","tags":{"host":"server01"},"timestamp":"2009-11-10T23:00:00Z","values":{"value":100}}`+"\n" {
+		t.Fatalf("unexpected body: %s", b)
+	}
+}
+
+// Ensure the handler rejects an invalid tail sample rate.
+func TestHandler_Tail_BadRequest(t *testing.T) {
+	srvr := OpenServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/tail`, map[string]string{"sample": "0"}, nil, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `invalid sample` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Utility functions for this test suite.
+
+func MustHTTP(verb, path string, params, headers map[string]string, body string) (int, string) {
+	req, err := http.NewRequest(verb, path, bytes.NewBuffer([]byte(body)))
+	if err != nil {
+		panic(err)
 	}
 
 	if params != nil {
@@ -754,10 +1751,10 @@ func MustHTTP(verb, path string, params, headers map[string]string, body string)
 		req.URL.RawQuery = q.Encode()
 	}
 
+	req.Header.Set("Content-Type", "application/json")
 	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
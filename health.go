@@ -0,0 +1,56 @@
+package influxdb
+
+// connectedClient is implemented by messaging clients that can report whether
+// they're currently streaming from a broker.
+type connectedClient interface {
+	Connected() bool
+}
+
+// Live returns nil if the server is open. A live server may still be loading
+// or catching up and shouldn't receive traffic until it is ready.
+func (s *Server) Live() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.opened() {
+		return ErrServerClosed
+	}
+	return nil
+}
+
+// Ready returns nil if the server can serve writes and queries. The index must
+// be loaded and joined to a cluster, the broker stream must be connected,
+// every shard stored on the data node must be open and the data node can't
+// be a standby or halted for an upgrade.
+func (s *Server) Ready() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Check the index is loaded and the data node is part of a cluster.
+	if !s.opened() {
+		return ErrServerClosed
+	} else if s.id == 0 || s.dataNodes[s.id] == nil {
+		return ErrDataNodeNotFound
+	}
+
+	// Check the data node is receiving messages from the broker.
+	if s.client == nil {
+		return ErrBrokerDisconnected
+	} else if c, ok := s.client.(connectedClient); ok && !c.Connected() {
+		return ErrBrokerDisconnected
+	}
+
+	// Check the data node is applying messages and serving queries.
+	if s.upgradeIndex != 0 {
+		return ErrUpgradeRequired
+	} else if s.standby() {
+		return ErrDataNodeStandby
+	}
+
+	// Check every local shard could be opened.
+	for _, sh := range s.shards {
+		if s.storesShard(sh) && !sh.opened() {
+			return ErrShardNotOpen
+		}
+	}
+	return nil
+}
@@ -4,7 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
 )
 
 var (
@@ -14,9 +19,37 @@ var (
 	// ErrServerClosed is returned when closing an already closed server.
 	ErrServerClosed = errors.New("server already closed")
 
+	// ErrSyncTimeout is returned when an index is not applied within the
+	// timeout given to SyncTimeout.
+	ErrSyncTimeout = errors.New("sync timeout")
+
 	// ErrPathRequired is returned when opening a server without a path.
 	ErrPathRequired = errors.New("path required")
 
+	// ErrInvalidMetastoreKey is returned when a metastore encryption key is not
+	// a valid AES-128, AES-192 or AES-256 key.
+	ErrInvalidMetastoreKey = errors.New("invalid metastore key")
+
+	// ErrMetastoreDecrypt is returned when a metastore value cannot be decrypted
+	// with the current key or any of the previous keys.
+	ErrMetastoreDecrypt = errors.New("unable to decrypt metastore")
+
+	// ErrInvalidShardKey is returned when a shard encryption key is not a
+	// valid AES-128, AES-192 or AES-256 key.
+	ErrInvalidShardKey = errors.New("invalid shard key")
+
+	// ErrShardKeyRequired is returned when opening an encrypted shard without
+	// a key for its database.
+	ErrShardKeyRequired = errors.New("shard key required")
+
+	// ErrShardDecrypt is returned when an encrypted shard cannot be decrypted
+	// with its database's key.
+	ErrShardDecrypt = errors.New("unable to decrypt shard")
+
+	// ErrPeerCertificateNotPinned is returned when joining a peer whose
+	// certificate chain does not contain a pinned public key.
+	ErrPeerCertificateNotPinned = errors.New("peer certificate not pinned")
+
 	// ErrUnableToJoin is returned when a server cannot join a cluster.
 	ErrUnableToJoin = errors.New("unable to join")
 
@@ -26,12 +59,40 @@ var (
 	// ErrDataNodeExists is returned when creating a duplicate data node.
 	ErrDataNodeExists = errors.New("data node exists")
 
+	// ErrMessageFormatUnsupported is returned when broadcasting a message that
+	// some data nodes in the cluster are too old to apply.
+	ErrMessageFormatUnsupported = errors.New("message format unsupported by cluster")
+
+	// ErrUpgradeRequired is returned when waiting for a message that this data
+	// node can't apply until it is upgraded.
+	ErrUpgradeRequired = errors.New("data node upgrade required")
+
+	// ErrBrokerDisconnected is returned by readiness checks when the data node
+	// isn't connected to a broker.
+	ErrBrokerDisconnected = errors.New("broker disconnected")
+
+	// ErrShardNotOpen is returned by readiness checks when a shard stored on
+	// the data node couldn't be opened.
+	ErrShardNotOpen = errors.New("shard not open")
+
 	// ErrDataNodeNotFound is returned when dropping a non-existent data node.
 	ErrDataNodeNotFound = errors.New("data node not found")
 
 	// ErrDataNodeRequired is returned when using a blank data node id.
 	ErrDataNodeRequired = errors.New("data node required")
 
+	// ErrNoReplacementDataNode is returned when decommissioning the only data
+	// node that can store a shard.
+	ErrNoReplacementDataNode = errors.New("no data node available to take over shard")
+
+	// ErrDataNodeStandby is returned when querying a standby data node or
+	// when a standby data node is used in place of an owner.
+	ErrDataNodeStandby = errors.New("data node is a standby")
+
+	// ErrDataNodeNotStandby is returned when promoting a data node that is
+	// not a standby.
+	ErrDataNodeNotStandby = errors.New("data node is not a standby")
+
 	// ErrDatabaseNameRequired is returned when creating a database without a name.
 	ErrDatabaseNameRequired = errors.New("database name required")
 
@@ -62,6 +123,37 @@ var (
 	// ErrInvalidUsername is returned when using a username with invalid characters.
 	ErrInvalidUsername = errors.New("invalid username")
 
+	// ErrPasswordRequired is returned when using a blank password.
+	ErrPasswordRequired = errors.New("password required")
+
+	// ErrPasswordTooShort is returned when using a password shorter than the
+	// password policy's minimum length.
+	ErrPasswordTooShort = errors.New("password too short")
+
+	// ErrPasswordMatchesUsername is returned when using the username as the
+	// password and the password policy rejects it.
+	ErrPasswordMatchesUsername = errors.New("password matches username")
+
+	// ErrInvalidBcryptCost is returned when setting a bcrypt cost outside of
+	// the range allowed by bcrypt.
+	ErrInvalidBcryptCost = errors.New("invalid bcrypt cost")
+
+	// ErrTokenNotFound is returned when revoking a non-existent API token.
+	ErrTokenNotFound = errors.New("token not found")
+
+	// ErrInvalidToken is returned when authenticating with an unknown API token.
+	ErrInvalidToken = errors.New("invalid token")
+
+	// ErrTokenExpired is returned when authenticating with an expired API token.
+	ErrTokenExpired = errors.New("token expired")
+
+	// ErrInvalidTokenScope is returned when creating an API token with a scope
+	// other than read, write or all privileges.
+	ErrInvalidTokenScope = errors.New("invalid token scope")
+
+	// ErrInvalidTokenTTL is returned when creating an API token with a negative TTL.
+	ErrInvalidTokenTTL = errors.New("invalid token ttl")
+
 	// ErrRetentionPolicyExists is returned when creating a duplicate shard space.
 	ErrRetentionPolicyExists = errors.New("retention policy exists")
 
@@ -71,17 +163,139 @@ var (
 	// ErrRetentionPolicyNameRequired is returned using a blank shard space name.
 	ErrRetentionPolicyNameRequired = errors.New("retention policy name required")
 
+	// ErrInvalidShardHash is returned using an unknown shard hashing strategy.
+	ErrInvalidShardHash = errors.New("invalid shard hash")
+
 	// ErrDefaultRetentionPolicyNotFound is returned when using the default
 	// policy on a database but the default has not been set.
 	ErrDefaultRetentionPolicyNotFound = errors.New("default retention policy not found")
 
+	// ErrContinuousQueryNameRequired is returned when acquiring a lease without a query name.
+	ErrContinuousQueryNameRequired = errors.New("continuous query name required")
+
+	// ErrInvalidContinuousQueryLeaseDuration is returned when acquiring a lease with a non-positive duration.
+	ErrInvalidContinuousQueryLeaseDuration = errors.New("invalid continuous query lease duration")
+
+	// ErrContinuousQueryLeaseHeld is returned when another data node holds the lease for a continuous query.
+	ErrContinuousQueryLeaseHeld = errors.New("continuous query lease held by another data node")
+
+	// ErrContinuousQueryExists is returned when creating a duplicate continuous query.
+	ErrContinuousQueryExists = errors.New("continuous query already exists")
+
+	// ErrContinuousQueryNotFound is returned when dropping a non-existent continuous query.
+	ErrContinuousQueryNotFound = errors.New("continuous query not found")
+
+	// ErrContinuousQueryIntervalRequired is returned when creating a continuous
+	// query that is not grouped by a time interval.
+	ErrContinuousQueryIntervalRequired = errors.New("continuous query requires a GROUP BY time interval")
+
+	// ErrWriteBlockExists is returned when blocking a pattern that is already blocked.
+	ErrWriteBlockExists = errors.New("write block already exists")
+
+	// ErrWriteBlockNotFound is returned when unblocking a pattern that is not blocked.
+	ErrWriteBlockNotFound = errors.New("write block not found")
+
+	// ErrInvalidWriteBlock is returned when blocking an empty value or an
+	// invalid regular expression.
+	ErrInvalidWriteBlock = errors.New("invalid write block")
+
+	// ErrWriteBlocked is returned when writing a point that matches a write block.
+	ErrWriteBlocked = errors.New("write blocked")
+
+	// ErrEventNameRequired is returned when creating an event without a name.
+	ErrEventNameRequired = errors.New("event name required")
+
+	// ErrEventTimeRequired is returned when creating an event without a start time.
+	ErrEventTimeRequired = errors.New("event time required")
+
+	// ErrInvalidEventTimeRange is returned when an event ends before it starts.
+	ErrInvalidEventTimeRange = errors.New("invalid event time range")
+
+	// ErrDownsamplePolicyExists is returned when creating a duplicate downsample policy.
+	ErrDownsamplePolicyExists = errors.New("downsample policy exists")
+
+	// ErrDownsamplePolicyNotFound is returned when deleting a non-existent downsample policy.
+	ErrDownsamplePolicyNotFound = errors.New("downsample policy not found")
+
+	// ErrDownsamplePolicyNameRequired is returned using a blank downsample policy name.
+	ErrDownsamplePolicyNameRequired = errors.New("downsample policy name required")
+
+	// ErrInvalidDownsampleInterval is returned when a downsample policy has a non-positive interval.
+	ErrInvalidDownsampleInterval = errors.New("invalid downsample interval")
+
+	// ErrDownsampleAggregatesRequired is returned when a downsample policy has no aggregates.
+	ErrDownsampleAggregatesRequired = errors.New("downsample aggregates required")
+
 	// ErrShardNotFound is returned writing to a non-existent shard.
 	ErrShardNotFound = errors.New("shard not found")
 
+	// ErrShardNotLocal is returned when reading a shard not stored on this server.
+	ErrShardNotLocal = errors.New("shard not stored on this server")
+
+	// ErrShardGroupNotFound is returned when deleting a non-existent shard group.
+	ErrShardGroupNotFound = errors.New("shard group not found")
+
+	// ErrInvalidRetentionPolicyEnforcementInterval is returned when starting
+	// retention policy enforcement with a non-positive interval.
+	ErrInvalidRetentionPolicyEnforcementInterval = errors.New("invalid retention policy enforcement interval")
+
+	// ErrRestorePathExists is returned when restoring a backup into a
+	// directory that already contains a metastore.
+	ErrRestorePathExists = errors.New("restore path already contains a metastore")
+
+	// ErrInvalidShardCompactionInterval is returned when starting shard
+	// compaction with a non-positive interval.
+	ErrInvalidShardCompactionInterval = errors.New("invalid shard compaction interval")
+
+	// ErrInvalidMetastoreBackupInterval is returned when starting metastore
+	// backups with a non-positive interval.
+	ErrInvalidMetastoreBackupInterval = errors.New("invalid metastore backup interval")
+
+	// ErrInvalidBroadcastCompactionInterval is returned when starting
+	// broadcast topic compaction with a non-positive interval.
+	ErrInvalidBroadcastCompactionInterval = errors.New("invalid broadcast compaction interval")
+
+	// ErrInvalidWriteQueueInterval is returned when starting the write queue
+	// with a non-positive retry interval.
+	ErrInvalidWriteQueueInterval = errors.New("invalid write queue interval")
+
+	// ErrWriteQueueDisabled is returned when queuing a write before the write
+	// queue is started.
+	ErrWriteQueueDisabled = errors.New("write queue not enabled")
+
+	// ErrWriteQueueFull is returned when queuing a write would grow the write
+	// queue past its maximum size.
+	ErrWriteQueueFull = errors.New("write queue full")
+
+	// ErrInvalidCardinalityCheckInterval is returned when starting tag
+	// cardinality checks with a non-positive interval.
+	ErrInvalidCardinalityCheckInterval = errors.New("invalid cardinality check interval")
+
+	// ErrInvalidCardinalityThresholds is returned when starting tag cardinality
+	// checks without thresholds or with thresholds that are not positive and
+	// strictly ascending.
+	ErrInvalidCardinalityThresholds = errors.New("invalid cardinality thresholds")
+
+	// ErrInvalidContinuousQueryCheckInterval is returned when starting
+	// continuous query scheduling with a non-positive interval.
+	ErrInvalidContinuousQueryCheckInterval = errors.New("invalid continuous query check interval")
+
 	// ErrReadAccessDenied is returned when a user attempts to read
 	// data that he or she does not have permission to read.
 	ErrReadAccessDenied = errors.New("read access denied")
 
+	// ErrWriteAccessDenied is returned when a user attempts to write
+	// data that he or she does not have permission to write.
+	ErrWriteAccessDenied = errors.New("write access denied")
+
+	// ErrAdminRequired is returned when a non-admin user attempts to
+	// execute an administrative statement.
+	ErrAdminRequired = errors.New("admin privileges required")
+
+	// ErrInvalidPrivilege is returned when setting a privilege that is not
+	// read, write, all or no privileges.
+	ErrInvalidPrivilege = errors.New("invalid privilege")
+
 	// ErrReadWritePermissionsRequired is returned when required read/write permissions aren't provided.
 	ErrReadWritePermissionsRequired = errors.New("read/write permissions required")
 
@@ -91,20 +305,205 @@ var (
 	// ErrMeasurementNotFound is returned when a measurement does not exist.
 	ErrMeasurementNotFound = errors.New("measurement not found")
 
+	// ErrFieldNotFound is returned when a field does not exist on a measurement.
+	ErrFieldNotFound = errors.New("field not found")
+
 	// ErrFieldOverflow is returned when too many fields are created on a measurement.
 	ErrFieldOverflow = errors.New("field overflow")
 
+	// ErrMeasurementNameRequired is returned when writing a point without a measurement name.
+	ErrMeasurementNameRequired = errors.New("measurement name required")
+
+	// ErrInvalidPointTTL is returned when writing a point with a negative TTL.
+	ErrInvalidPointTTL = errors.New("invalid point ttl")
+
+	// ErrInvalidHistogramBuckets is returned when creating a histogram without
+	// buckets or with bucket bounds that are not strictly ascending.
+	ErrInvalidHistogramBuckets = errors.New("invalid histogram buckets")
+
 	// ErrSeriesNotFound is returned when looking up a non-existent series by database, name and tags
 	ErrSeriesNotFound = errors.New("series not found")
 
 	// ErrSeriesExists is returned when attempting to set the id of a series by database, name and tags that already exists
 	ErrSeriesExists = errors.New("series already exists")
 
+	// ErrInvalidPrecision is returned when writing points with an unknown timestamp precision.
+	ErrInvalidPrecision = errors.New("invalid precision")
+
 	// ErrNotExecuted is returned when a statement is not executed in a query.
 	// This can occur when a previous statement in the same query has errored.
 	ErrNotExecuted = errors.New("not executed")
+
+	// ErrInvalidBatchStatement is returned when batching a statement other than
+	// CREATE DATABASE, CREATE RETENTION POLICY, CREATE USER or GRANT.
+	ErrInvalidBatchStatement = errors.New("statement cannot be batched")
+
+	// ErrQueryNotFound is returned when killing a query that is not running.
+	ErrQueryNotFound = errors.New("query not found")
+
+	// ErrQueryKilled is returned by a query that was killed while running.
+	ErrQueryKilled = errors.New("query killed")
+
+	// ErrQueryTimeout is returned by a select statement that ran longer than
+	// the server's query timeout.
+	ErrQueryTimeout = errors.New("query timeout")
+
+	// ErrTooManyQueries is returned when a select statement is rejected because
+	// the concurrent query limit was reached and the query queue is full.
+	ErrTooManyQueries = errors.New("too many concurrent queries")
+
+	// ErrInvalidQueryLimit is returned when setting a negative concurrent query
+	// limit or queue size.
+	ErrInvalidQueryLimit = errors.New("invalid query limit")
+
+	// ErrInvalidLogLevel is returned when parsing an unknown log level name.
+	ErrInvalidLogLevel = errors.New("invalid log level")
+
+	// ErrInvalidConsistencyLevel is returned when parsing an unknown write
+	// consistency level.
+	ErrInvalidConsistencyLevel = errors.New("invalid consistency level")
+
+	// ErrInvalidValueEncoding is returned when stored or replicated point
+	// values cannot be decoded.
+	ErrInvalidValueEncoding = errors.New("invalid value encoding")
+
+	// ErrShardPseudoTagTimeRange is returned when a select statement groups or
+	// filters by the _shard or _dataNode pseudo tags over more than one shard
+	// group, since each group stores a series in a different shard.
+	ErrShardPseudoTagTimeRange = errors.New("_shard and _dataNode require a time range within one shard group")
 )
 
+// FieldTypeConflictError is returned when a value is written to an existing
+// field with a different type than the field was created with.
+type FieldTypeConflictError struct {
+	Measurement string
+	Field       *Field
+	Type        influxql.DataType
+}
+
+// Error returns a description of the conflicting types.
+func (e *FieldTypeConflictError) Error() string {
+	return fmt.Sprintf("field type conflict: %s.%s is %s (created %s), got %s",
+		e.Measurement, e.Field.Name, e.Field.Type, e.Field.CreatedAt.Format(time.RFC3339Nano), e.Type)
+}
+
+// LineError is returned when a line of a line protocol write cannot be
+// parsed or written.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+// Error returns the line number and the reason the line failed.
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// MarshalJSON encodes the line number and error.
+func (e *LineError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Line int    `json:"line"`
+		Err  string `json:"error"`
+	}{e.Line, e.Err.Error()})
+}
+
+// LineErrors is a list of errors from a single line protocol write.
+type LineErrors []*LineError
+
+// Error returns the errors for each failed line.
+func (a LineErrors) Error() string {
+	s := make([]string, len(a))
+	for i, e := range a {
+		s[i] = e.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// PointError is returned for a point that was rejected from a batch write.
+type PointError struct {
+	Index int    // position of the point in the request, from zero
+	Name  string // measurement name
+	Err   error
+}
+
+// Error returns the position of the point and the reason it was rejected.
+func (e *PointError) Error() string {
+	return fmt.Sprintf("point %d: %s", e.Index, e.Err)
+}
+
+// MarshalJSON encodes the point's position, measurement and error.
+func (e *PointError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Index int    `json:"index"`
+		Name  string `json:"name,omitempty"`
+		Err   string `json:"error"`
+	}{e.Index, e.Name, e.Err.Error()})
+}
+
+// PointErrors is a list of points rejected from a single batch write.
+type PointErrors []*PointError
+
+// Error returns the errors for each rejected point.
+func (a PointErrors) Error() string {
+	s := make([]string, len(a))
+	for i, e := range a {
+		s[i] = e.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// QueryCost represents the estimated cost of a query. The cost is the number
+// of shards read multiplied by the number of series and time buckets.
+type QueryCost struct {
+	Shards  int64
+	Series  int64
+	Buckets int64
+}
+
+// Total returns the product of the shard, series and bucket counts.
+// The product is clamped to math.MaxInt64 instead of overflowing.
+func (c *QueryCost) Total() int64 {
+	return saturatingMul(saturatingMul(c.Shards, c.Series), c.Buckets)
+}
+
+// saturatingMul returns the product of two non-negative numbers or
+// math.MaxInt64 if the product overflows.
+func saturatingMul(a, b int64) int64 {
+	if a != 0 && b > math.MaxInt64/a {
+		return math.MaxInt64
+	}
+	return a * b
+}
+
+// QueryCostError is returned when the estimated cost of a query exceeds the
+// query cost budget.
+type QueryCostError struct {
+	Cost   *QueryCost
+	Budget int64
+}
+
+// Error returns a description of the cost and how to reduce it.
+func (e *QueryCostError) Error() string {
+	return fmt.Sprintf("query cost %d (%d shards x %d series x %d time buckets) exceeds budget of %d: "+
+		"narrow the time range, filter by tags or use a larger GROUP BY time interval",
+		e.Cost.Total(), e.Cost.Shards, e.Cost.Series, e.Cost.Buckets, e.Budget)
+}
+
+// MaxValuesPerTagError is returned when a point would add a new value to a tag
+// key that already has the maximum number of distinct values.
+type MaxValuesPerTagError struct {
+	Measurement string
+	TagKey      string
+	Limit       int
+}
+
+// Error returns a description of the tag key over the limit.
+func (e *MaxValuesPerTagError) Error() string {
+	return fmt.Sprintf("max values per tag exceeded: %s.%s already has %d distinct values: "+
+		"tags should not contain unbounded values such as ids or timestamps",
+		e.Measurement, e.TagKey, e.Limit)
+}
+
 // mustMarshal encodes a value to JSON.
 // This will panic if an error occurs. This should only be used internally when
 // an invalid marshal will cause corruption and a panic is appropriate.
@@ -3,6 +3,7 @@ package influxql
 import (
 	"bytes"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -14,6 +15,7 @@ type DataType string
 const (
 	Unknown  = DataType("")
 	Number   = DataType("number")
+	Integer  = DataType("integer")
 	Boolean  = DataType("boolean")
 	String   = DataType("string")
 	Time     = DataType("time")
@@ -25,6 +27,8 @@ func InspectDataType(v interface{}) DataType {
 	switch v.(type) {
 	case float64:
 		return Number
+	case int64:
+		return Integer
 	case bool:
 		return Boolean
 	case string:
@@ -48,6 +52,7 @@ func (_ *Query) node()     {}
 func (_ Statements) node() {}
 
 func (_ *AlterRetentionPolicyStatement) node()  {}
+func (_ *BlockStatement) node()                 {}
 func (_ *CreateContinuousQueryStatement) node() {}
 func (_ *CreateDatabaseStatement) node()        {}
 func (_ *CreateRetentionPolicyStatement) node() {}
@@ -59,18 +64,30 @@ func (_ *DropRetentionPolicyStatement) node()   {}
 func (_ *DropSeriesStatement) node()            {}
 func (_ *DropUserStatement) node()              {}
 func (_ *GrantStatement) node()                 {}
+func (_ *KillQueryStatement) node()             {}
+func (_ *ListBlocksStatement) node()            {}
 func (_ *ListContinuousQueriesStatement) node() {}
 func (_ *ListDatabasesStatement) node()         {}
+func (_ *ListDiagnosticsStatement) node()       {}
+func (_ *ListEventsStatement) node()            {}
+func (_ *ListExpiredShardsStatement) node()     {}
 func (_ *ListFieldKeysStatement) node()         {}
 func (_ *ListFieldValuesStatement) node()       {}
+func (_ *ListGrantsStatement) node()            {}
 func (_ *ListRetentionPoliciesStatement) node() {}
 func (_ *ListMeasurementsStatement) node()      {}
+func (_ *ListQueriesStatement) node()           {}
 func (_ *ListSeriesStatement) node()            {}
+func (_ *ListSeriesTimesStatement) node()       {}
+func (_ *ListShardsStatement) node()            {}
+func (_ *ListStatsStatement) node()             {}
 func (_ *ListTagKeysStatement) node()           {}
 func (_ *ListTagValuesStatement) node()         {}
 func (_ *ListUsersStatement) node()             {}
+func (_ *ReindexDatabaseStatement) node()       {}
 func (_ *RevokeStatement) node()                {}
 func (_ *SelectStatement) node()                {}
+func (_ *UnblockStatement) node()               {}
 
 func (_ *BinaryExpr) node()      {}
 func (_ *BooleanLiteral) node()  {}
@@ -86,6 +103,7 @@ func (_ Measurements) node()     {}
 func (_ *Merge) node()           {}
 func (_ *NumberLiteral) node()   {}
 func (_ *ParenExpr) node()       {}
+func (_ *RegexLiteral) node()    {}
 func (_ *SortField) node()       {}
 func (_ SortFields) node()       {}
 func (_ *StringLiteral) node()   {}
@@ -121,6 +139,7 @@ type Statement interface {
 }
 
 func (_ *AlterRetentionPolicyStatement) stmt()  {}
+func (_ *BlockStatement) stmt()                 {}
 func (_ *CreateContinuousQueryStatement) stmt() {}
 func (_ *CreateDatabaseStatement) stmt()        {}
 func (_ *CreateRetentionPolicyStatement) stmt() {}
@@ -132,18 +151,30 @@ func (_ *DropRetentionPolicyStatement) stmt()   {}
 func (_ *DropSeriesStatement) stmt()            {}
 func (_ *DropUserStatement) stmt()              {}
 func (_ *GrantStatement) stmt()                 {}
+func (_ *KillQueryStatement) stmt()             {}
+func (_ *ListBlocksStatement) stmt()            {}
 func (_ *ListContinuousQueriesStatement) stmt() {}
 func (_ *ListDatabasesStatement) stmt()         {}
+func (_ *ListDiagnosticsStatement) stmt()       {}
+func (_ *ListEventsStatement) stmt()            {}
+func (_ *ListExpiredShardsStatement) stmt()     {}
 func (_ *ListFieldKeysStatement) stmt()         {}
 func (_ *ListFieldValuesStatement) stmt()       {}
+func (_ *ListGrantsStatement) stmt()            {}
 func (_ *ListMeasurementsStatement) stmt()      {}
+func (_ *ListQueriesStatement) stmt()           {}
 func (_ *ListRetentionPoliciesStatement) stmt() {}
 func (_ *ListSeriesStatement) stmt()            {}
+func (_ *ListSeriesTimesStatement) stmt()       {}
+func (_ *ListShardsStatement) stmt()            {}
+func (_ *ListStatsStatement) stmt()             {}
 func (_ *ListTagKeysStatement) stmt()           {}
 func (_ *ListTagValuesStatement) stmt()         {}
 func (_ *ListUsersStatement) stmt()             {}
+func (_ *ReindexDatabaseStatement) stmt()       {}
 func (_ *RevokeStatement) stmt()                {}
 func (_ *SelectStatement) stmt()                {}
+func (_ *UnblockStatement) stmt()               {}
 
 // Expr represents an expression that can be evaluated to a value.
 type Expr interface {
@@ -157,6 +188,7 @@ func (_ *Call) expr()            {}
 func (_ *DurationLiteral) expr() {}
 func (_ *NumberLiteral) expr()   {}
 func (_ *ParenExpr) expr()       {}
+func (_ *RegexLiteral) expr()    {}
 func (_ *StringLiteral) expr()   {}
 func (_ *TimeLiteral) expr()     {}
 func (_ *VarRef) expr()          {}
@@ -170,6 +202,7 @@ type Source interface {
 
 func (_ *Join) source()        {}
 func (_ *Measurement) source() {}
+func (_ Measurements) source() {}
 func (_ *Merge) source()       {}
 
 // SortField represens a field to sort results by.
@@ -206,6 +239,18 @@ func (a SortFields) String() string {
 type CreateDatabaseStatement struct {
 	// Name of the database to be created.
 	Name string
+
+	// Should a default retention policy be created with the database?
+	RetentionPolicyCreate bool
+
+	// Name of the default retention policy. Empty uses the server's default.
+	RetentionPolicyName string
+
+	// Duration data written to the default retention policy will be retained.
+	RetentionPolicyDuration *time.Duration
+
+	// Replication factor of the default retention policy.
+	RetentionPolicyReplication *int
 }
 
 // String returns a string representation of the create database statement.
@@ -213,6 +258,21 @@ func (s *CreateDatabaseStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("CREATE DATABASE ")
 	_, _ = buf.WriteString(s.Name)
+	if s.RetentionPolicyCreate {
+		_, _ = buf.WriteString(" WITH")
+		if s.RetentionPolicyName != "" {
+			_, _ = buf.WriteString(" RETENTION POLICY ")
+			_, _ = buf.WriteString(s.RetentionPolicyName)
+		}
+		if s.RetentionPolicyDuration != nil {
+			_, _ = buf.WriteString(" DURATION ")
+			_, _ = buf.WriteString(FormatDuration(*s.RetentionPolicyDuration))
+		}
+		if s.RetentionPolicyReplication != nil {
+			_, _ = buf.WriteString(" REPLICATION ")
+			_, _ = buf.WriteString(strconv.Itoa(*s.RetentionPolicyReplication))
+		}
+	}
 	return buf.String()
 }
 
@@ -230,6 +290,21 @@ func (s *DropDatabaseStatement) String() string {
 	return buf.String()
 }
 
+// ReindexDatabaseStatement represents a command to rebuild the in-memory
+// index of a database.
+type ReindexDatabaseStatement struct {
+	// Name of the database to reindex.
+	Name string
+}
+
+// String returns a string representation of the reindex database statement.
+func (s *ReindexDatabaseStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("REINDEX DATABASE ")
+	_, _ = buf.WriteString(s.Name)
+	return buf.String()
+}
+
 // DropRetentionPolicyStatement represents a command to drop a retention policy from a database.
 type DropRetentionPolicyStatement struct {
 	// Name of the policy to drop.
@@ -298,6 +373,7 @@ const (
 	ReadPrivilege Privilege = iota
 	WritePrivilege
 	AllPrivileges
+	NoPrivileges
 )
 
 // NewPrivilege returns an initialized *Privilege.
@@ -312,6 +388,8 @@ func (p Privilege) String() string {
 		return "WRITE"
 	case AllPrivileges:
 		return "ALL PRIVILEGES"
+	case NoPrivileges:
+		return "NO PRIVILEGES"
 	}
 	return ""
 }
@@ -382,6 +460,12 @@ type CreateRetentionPolicyStatement struct {
 	// Replication factor for data written to this policy.
 	Replication int
 
+	// Number of shards per replica set in each shard group. Zero uses the default.
+	Split int
+
+	// Strategy used to assign series to shards. Empty uses the default.
+	Hash string
+
 	// Should this policy be set as default for the database?
 	Default bool
 }
@@ -397,6 +481,14 @@ func (s *CreateRetentionPolicyStatement) String() string {
 	_, _ = buf.WriteString(FormatDuration(s.Duration))
 	_, _ = buf.WriteString(" REPLICATION ")
 	_, _ = buf.WriteString(strconv.Itoa(s.Replication))
+	if s.Split != 0 {
+		_, _ = buf.WriteString(" SPLIT ")
+		_, _ = buf.WriteString(strconv.Itoa(s.Split))
+	}
+	if s.Hash != "" {
+		_, _ = buf.WriteString(" HASH ")
+		_, _ = buf.WriteString(s.Hash)
+	}
 	if s.Default {
 		_, _ = buf.WriteString(" DEFAULT")
 	}
@@ -417,6 +509,12 @@ type AlterRetentionPolicyStatement struct {
 	// Replication factor for data written to this policy.
 	Replication *int
 
+	// Number of shards per replica set in new shard groups.
+	Split *int
+
+	// Strategy used to assign series to shards in new shard groups.
+	Hash *string
+
 	// Should this policy be set as defalut for the database?
 	Default bool
 }
@@ -439,6 +537,16 @@ func (s *AlterRetentionPolicyStatement) String() string {
 		_, _ = buf.WriteString(strconv.Itoa(*s.Replication))
 	}
 
+	if s.Split != nil {
+		_, _ = buf.WriteString(" SPLIT ")
+		_, _ = buf.WriteString(strconv.Itoa(*s.Split))
+	}
+
+	if s.Hash != nil {
+		_, _ = buf.WriteString(" HASH ")
+		_, _ = buf.WriteString(*s.Hash)
+	}
+
 	if s.Default {
 		_, _ = buf.WriteString(" DEFAULT")
 	}
@@ -457,6 +565,15 @@ type SelectStatement struct {
 	// Expressions used for grouping the selection.
 	Dimensions Dimensions
 
+	// Value reported for group by time intervals that contain no points.
+	// FillValue holds the number used by NumberFill.
+	Fill      FillOption
+	FillValue interface{}
+
+	// An expression evaluated on the aggregated values of each row.
+	// Rows are only returned if it evaluates to true.
+	Having Expr
+
 	// Data source that fields are extracted from.
 	Source Source
 
@@ -491,6 +608,20 @@ func (s *SelectStatement) String() string {
 		_, _ = buf.WriteString(" GROUP BY ")
 		_, _ = buf.WriteString(s.Dimensions.String())
 	}
+	switch s.Fill {
+	case NullFill:
+		_, _ = buf.WriteString(" fill(null)")
+	case NoFill:
+		_, _ = buf.WriteString(" fill(none)")
+	case PreviousFill:
+		_, _ = buf.WriteString(" fill(previous)")
+	case NumberFill:
+		_, _ = fmt.Fprintf(&buf, " fill(%v)", s.FillValue)
+	}
+	if s.Having != nil {
+		_, _ = buf.WriteString(" HAVING ")
+		_, _ = buf.WriteString(s.Having.String())
+	}
 	if len(s.SortFields) > 0 {
 		_, _ = buf.WriteString(" ORDER BY ")
 		_, _ = buf.WriteString(s.SortFields.String())
@@ -501,6 +632,26 @@ func (s *SelectStatement) String() string {
 	return buf.String()
 }
 
+// FillOption represents how empty group by time intervals are reported.
+type FillOption int
+
+const (
+	// DefaultFill reports empty intervals as zero.
+	DefaultFill FillOption = iota
+
+	// NullFill reports empty intervals with null values.
+	NullFill
+
+	// NoFill omits empty intervals from the results.
+	NoFill
+
+	// PreviousFill reports empty intervals with the previous interval's value.
+	PreviousFill
+
+	// NumberFill reports empty intervals with a fixed number.
+	NumberFill
+)
+
 // Aggregated returns true if the statement uses aggregate functions.
 func (s *SelectStatement) Aggregated() bool {
 	var v bool
@@ -512,6 +663,19 @@ func (s *SelectStatement) Aggregated() bool {
 	return v
 }
 
+// GroupByInterval returns the interval of the "time(duration)" dimension.
+// Returns zero if the statement is not grouped by time.
+func (s *SelectStatement) GroupByInterval() time.Duration {
+	for _, d := range s.Dimensions {
+		if call, ok := d.Expr.(*Call); ok && strings.ToLower(call.Name) == "time" && len(call.Args) == 1 {
+			if lit, ok := call.Args[0].(*DurationLiteral); ok {
+				return lit.Val
+			}
+		}
+	}
+	return 0
+}
+
 /*
 
 BinaryExpr
@@ -544,6 +708,23 @@ WHERE yyy.region == "uswest"
 
 */
 
+// Split returns a statement for each measurement when the source is a list of
+// measurements. Otherwise returns a list containing only the statement itself.
+func (s *SelectStatement) Split() []*SelectStatement {
+	a, ok := s.Source.(Measurements)
+	if !ok {
+		return []*SelectStatement{s}
+	}
+
+	other := make([]*SelectStatement, len(a))
+	for i, m := range a {
+		stmt := *s
+		stmt.Source = m
+		other[i] = &stmt
+	}
+	return other
+}
+
 // Substatement returns a single-series statement for a given variable reference.
 func (s *SelectStatement) Substatement(ref *VarRef) (*SelectStatement, error) {
 	// Copy dimensions and properties to new statement.
@@ -623,6 +804,12 @@ func MatchSource(src Source, name string) string {
 		if strings.HasPrefix(name, src.Name) {
 			return src.Name
 		}
+	case Measurements:
+		for _, m := range src {
+			if strings.HasPrefix(name, m.Name) {
+				return m.Name
+			}
+		}
 	case *Join:
 		for _, m := range src.Measurements {
 			if strings.HasPrefix(name, m.Name) {
@@ -674,24 +861,28 @@ type DeleteStatement struct {
 // String returns a string representation of the delete statement.
 func (s *DeleteStatement) String() string {
 	var buf bytes.Buffer
-	_, _ = buf.WriteString("DELETE ")
+	_, _ = buf.WriteString("DELETE FROM ")
 	_, _ = buf.WriteString(s.Source.String())
 	if s.Condition != nil {
 		_, _ = buf.WriteString(" WHERE ")
 		_, _ = buf.WriteString(s.Condition.String())
 	}
-	return s.String()
+	return buf.String()
 }
 
 // ListSeriesStatement represents a command for listing series in the database.
 type ListSeriesStatement struct {
+	// Measurements the series are listed from.
+	// All measurements are used if nil.
+	Source Source
+
 	// An expression evaluated on a series name or tag.
 	Condition Expr
 
 	// Fields to sort results by
 	SortFields SortFields
 
-	// Maximum number of rows to be returned.
+	// Maximum number of series to be returned across all measurements.
 	// Unlimited if zero.
 	Limit int
 }
@@ -701,6 +892,10 @@ func (s *ListSeriesStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("LIST SERIES")
 
+	if s.Source != nil {
+		_, _ = buf.WriteString(" FROM ")
+		_, _ = buf.WriteString(s.Source.String())
+	}
 	if s.Condition != nil {
 		_, _ = buf.WriteString(" WHERE ")
 		_, _ = buf.WriteString(s.Condition.String())
@@ -716,13 +911,72 @@ func (s *ListSeriesStatement) String() string {
 	return buf.String()
 }
 
-// DropSeriesStatement represents a command for removing a series from the database.
+// ListSeriesTimesStatement represents a command for listing the first and last
+// timestamps of each series within a time range.
+type ListSeriesTimesStatement struct {
+	// Measurements the series are listed from.
+	// All measurements are used if nil.
+	Source Source
+
+	// An expression evaluated on a series' tags and the time.
+	Condition Expr
+
+	// Maximum number of series to be returned across all measurements.
+	// Unlimited if zero.
+	Limit int
+}
+
+// String returns a string representation of the statement.
+func (s *ListSeriesTimesStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("LIST SERIES TIMES")
+
+	if s.Source != nil {
+		_, _ = buf.WriteString(" FROM ")
+		_, _ = buf.WriteString(s.Source.String())
+	}
+	if s.Condition != nil {
+		_, _ = buf.WriteString(" WHERE ")
+		_, _ = buf.WriteString(s.Condition.String())
+	}
+	if s.Limit > 0 {
+		_, _ = buf.WriteString(" LIMIT ")
+		_, _ = buf.WriteString(strconv.Itoa(s.Limit))
+	}
+	return buf.String()
+}
+
+// DropSeriesStatement represents a command for removing series from the database.
 type DropSeriesStatement struct {
+	// Name of the series to drop.
 	Name string
+
+	// Data source that series are removed from.
+	Source Source
+
+	// An expression evaluated on the tags of each series.
+	Condition Expr
 }
 
 // String returns a string representation of the drop series statement.
-func (s *DropSeriesStatement) String() string { return fmt.Sprintf("DROP SERIES %s", s.Name) }
+func (s *DropSeriesStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("DROP SERIES")
+
+	if s.Name != "" {
+		_, _ = buf.WriteString(" ")
+		_, _ = buf.WriteString(s.Name)
+	}
+	if s.Source != nil {
+		_, _ = buf.WriteString(" FROM ")
+		_, _ = buf.WriteString(s.Source.String())
+	}
+	if s.Condition != nil {
+		_, _ = buf.WriteString(" WHERE ")
+		_, _ = buf.WriteString(s.Condition.String())
+	}
+	return buf.String()
+}
 
 // ListContinuousQueriesStatement represents a command for listing continuous queries.
 type ListContinuousQueriesStatement struct{}
@@ -736,6 +990,145 @@ type ListDatabasesStatement struct{}
 // String returns a string representation of the list databases command.
 func (s *ListDatabasesStatement) String() string { return "LIST DATABASES" }
 
+// ListEventsStatement represents a command for listing annotation events.
+type ListEventsStatement struct {
+	// Name of the events to list. All events are listed if blank.
+	Name string
+
+	// An expression evaluated on the event time range.
+	Condition Expr
+
+	// Maximum number of events to be returned.
+	// Unlimited if zero.
+	Limit int
+}
+
+// String returns a string representation of the list events statement.
+func (s *ListEventsStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("LIST EVENTS")
+
+	if s.Name != "" {
+		_, _ = buf.WriteString(" FROM ")
+		_, _ = buf.WriteString(s.Name)
+	}
+	if s.Condition != nil {
+		_, _ = buf.WriteString(" WHERE ")
+		_, _ = buf.WriteString(s.Condition.String())
+	}
+	if s.Limit > 0 {
+		_, _ = buf.WriteString(" LIMIT ")
+		_, _ = buf.WriteString(strconv.Itoa(s.Limit))
+	}
+	return buf.String()
+}
+
+// ListExpiredShardsStatement represents a command for listing the shard groups
+// that have passed the duration of their retention policy.
+type ListExpiredShardsStatement struct{}
+
+// String returns a string representation of the list expired shards command.
+func (s *ListExpiredShardsStatement) String() string { return "LIST EXPIRED SHARDS" }
+
+// ListShardsStatement represents a command for listing the shards owned by each data node.
+type ListShardsStatement struct{}
+
+// String returns a string representation of the list shards command.
+func (s *ListShardsStatement) String() string { return "LIST SHARDS" }
+
+// BlockStatement represents a command for rejecting writes of points with a
+// matching measurement name or tag value.
+type BlockStatement struct {
+	// Key of the blocked tag. Empty when blocking measurements.
+	TagKey string
+
+	// Blocked measurement name (*VarRef), tag value (*StringLiteral) or
+	// pattern of either (*RegexLiteral).
+	Value Expr
+}
+
+// String returns a string representation of the block statement.
+func (s *BlockStatement) String() string { return "BLOCK " + blockPatternString(s.TagKey, s.Value) }
+
+// UnblockStatement represents a command for removing a block.
+type UnblockStatement struct {
+	// Key of the blocked tag. Empty when unblocking measurements.
+	TagKey string
+
+	// Value of the block being removed. Must match the block exactly.
+	Value Expr
+}
+
+// String returns a string representation of the unblock statement.
+func (s *UnblockStatement) String() string {
+	return "UNBLOCK " + blockPatternString(s.TagKey, s.Value)
+}
+
+// blockPatternString returns the string representation of a block's pattern.
+func blockPatternString(tagKey string, value Expr) string {
+	var buf bytes.Buffer
+	if tagKey == "" {
+		_, _ = buf.WriteString("MEASUREMENT")
+	} else {
+		_, _ = buf.WriteString("TAG ")
+		_, _ = buf.WriteString(tagKey)
+	}
+	if _, ok := value.(*RegexLiteral); ok {
+		_, _ = buf.WriteString(" =~ ")
+	} else {
+		_, _ = buf.WriteString(" = ")
+	}
+	if value != nil {
+		_, _ = buf.WriteString(value.String())
+	}
+	return buf.String()
+}
+
+// ListBlocksStatement represents a command for listing write blocks.
+type ListBlocksStatement struct{}
+
+// String returns a string representation of the list blocks command.
+func (s *ListBlocksStatement) String() string { return "LIST BLOCKS" }
+
+// ListQueriesStatement represents a command for listing the queries running on a data node.
+type ListQueriesStatement struct{}
+
+// String returns a string representation of the list queries command.
+func (s *ListQueriesStatement) String() string { return "LIST QUERIES" }
+
+// ListStatsStatement represents a command for listing the runtime statistics
+// of a data node.
+type ListStatsStatement struct {
+	// Database to limit the statistics to. Empty for every database.
+	Database string
+}
+
+// String returns a string representation of the list stats command.
+func (s *ListStatsStatement) String() string {
+	if s.Database != "" {
+		return "LIST STATS FOR " + QuoteString(s.Database)
+	}
+	return "LIST STATS"
+}
+
+// ListDiagnosticsStatement represents a command for listing the state of a
+// data node and its input services.
+type ListDiagnosticsStatement struct{}
+
+// String returns a string representation of the list diagnostics command.
+func (s *ListDiagnosticsStatement) String() string { return "LIST DIAGNOSTICS" }
+
+// KillQueryStatement represents a command for cancelling a running query.
+type KillQueryStatement struct {
+	// Identifier of the query, as reported by LIST QUERIES.
+	QueryID uint64
+}
+
+// String returns a string representation of the kill query command.
+func (s *KillQueryStatement) String() string {
+	return "KILL QUERY " + strconv.FormatUint(s.QueryID, 10)
+}
+
 // CreateContinuousQueriesStatement represents a command for creating a continuous query.
 type CreateContinuousQueryStatement struct {
 	// Name of the continuous query to be created.
@@ -765,6 +1158,10 @@ func (s *DropContinuousQueryStatement) String() string {
 
 // ListMeasurementsStatement represents a command for listing measurements.
 type ListMeasurementsStatement struct {
+	// Measurement name (*VarRef) or name pattern (*RegexLiteral).
+	// All measurements are listed if nil.
+	Name Expr
+
 	// An expression evaluated on data point.
 	Condition Expr
 
@@ -781,6 +1178,14 @@ func (s *ListMeasurementsStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("LIST MEASUREMENTS")
 
+	switch name := s.Name.(type) {
+	case *VarRef:
+		_, _ = buf.WriteString(" WITH MEASUREMENT = ")
+		_, _ = buf.WriteString(name.String())
+	case *RegexLiteral:
+		_, _ = buf.WriteString(" WITH MEASUREMENT =~ ")
+		_, _ = buf.WriteString(name.String())
+	}
 	if s.Condition != nil {
 		_, _ = buf.WriteString(" WHERE ")
 		_, _ = buf.WriteString(s.Condition.String())
@@ -821,7 +1226,7 @@ type ListTagKeysStatement struct {
 	// Fields to sort results by
 	SortFields SortFields
 
-	// Maximum number of rows to be returned.
+	// Maximum number of tag keys to be returned across all measurements.
 	// Unlimited if zero.
 	Limit int
 }
@@ -855,13 +1260,17 @@ type ListTagValuesStatement struct {
 	// Data source that fields are extracted from.
 	Source Source
 
+	// Tag key to list values for.
+	// Values for all keys are listed if empty.
+	TagKey string
+
 	// An expression evaluated on data point.
 	Condition Expr
 
 	// Fields to sort results by
 	SortFields SortFields
 
-	// Maximum number of rows to be returned.
+	// Maximum number of tag key/value pairs to be returned across all measurements.
 	// Unlimited if zero.
 	Limit int
 }
@@ -875,6 +1284,10 @@ func (s *ListTagValuesStatement) String() string {
 		_, _ = buf.WriteString(" FROM ")
 		_, _ = buf.WriteString(s.Source.String())
 	}
+	if s.TagKey != "" {
+		_, _ = buf.WriteString(" WITH KEY = ")
+		_, _ = buf.WriteString(QuoteIdent([]string{s.TagKey}))
+	}
 	if s.Condition != nil {
 		_, _ = buf.WriteString(" WHERE ")
 		_, _ = buf.WriteString(s.Condition.String())
@@ -898,6 +1311,18 @@ func (s *ListUsersStatement) String() string {
 	return "LIST USERS"
 }
 
+// ListGrantsStatement represents a command for listing the privileges
+// granted to a user on each database.
+type ListGrantsStatement struct {
+	// Name of the user.
+	Name string
+}
+
+// String returns a string representation of the list grants command.
+func (s *ListGrantsStatement) String() string {
+	return "LIST GRANTS FOR " + s.Name
+}
+
 // ListFieldKeyStatement represents a command for listing field keys.
 type ListFieldKeysStatement struct {
 	// Data source that fields are extracted from.
@@ -943,6 +1368,10 @@ type ListFieldValuesStatement struct {
 	// Data source that fields are extracted from.
 	Source Source
 
+	// Field key to list values for.
+	// Values for all fields are listed if empty.
+	FieldKey string
+
 	// An expression evaluated on data point.
 	Condition Expr
 
@@ -963,6 +1392,10 @@ func (s *ListFieldValuesStatement) String() string {
 		_, _ = buf.WriteString(" FROM ")
 		_, _ = buf.WriteString(s.Source.String())
 	}
+	if s.FieldKey != "" {
+		_, _ = buf.WriteString(" WITH KEY = ")
+		_, _ = buf.WriteString(QuoteIdent([]string{s.FieldKey}))
+	}
 	if s.Condition != nil {
 		_, _ = buf.WriteString(" WHERE ")
 		_, _ = buf.WriteString(s.Condition.String())
@@ -1059,10 +1492,18 @@ func (a Measurements) String() string {
 // Measurement represents a single measurement used as a datasource.
 type Measurement struct {
 	Name string
+
+	// Matches measurement names when set. The name is blank.
+	Regex *RegexLiteral
 }
 
 // String returns a string representation of the measurement.
-func (m *Measurement) String() string { return m.Name }
+func (m *Measurement) String() string {
+	if m.Regex != nil {
+		return m.Regex.String()
+	}
+	return m.Name
+}
 
 // Join represents two datasources joined together.
 type Join struct {
@@ -1139,6 +1580,16 @@ type StringLiteral struct {
 // String returns a string representation of the literal.
 func (l *StringLiteral) String() string { return QuoteString(l.Val) }
 
+// RegexLiteral represents a regular expression literal.
+type RegexLiteral struct {
+	Val *regexp.Regexp
+}
+
+// String returns a string representation of the literal.
+func (l *RegexLiteral) String() string {
+	return "/" + strings.Replace(l.Val.String(), "/", `\/`, -1) + "/"
+}
+
 // TimeLiteral represents a point-in-time literal.
 type TimeLiteral struct {
 	Val time.Time
@@ -1493,6 +1944,7 @@ func Walk(v Visitor, node Node) {
 		Walk(v, n.Dimensions)
 		Walk(v, n.Source)
 		Walk(v, n.Condition)
+		Walk(v, n.Having)
 
 	case Fields:
 		for _, c := range n {
@@ -1510,6 +1962,11 @@ func Walk(v Visitor, node Node) {
 	case *Dimension:
 		Walk(v, n.Expr)
 
+	case Measurements:
+		for _, m := range n {
+			Walk(v, m)
+		}
+
 	case *BinaryExpr:
 		Walk(v, n.LHS)
 		Walk(v, n.RHS)
@@ -1556,6 +2013,9 @@ func Rewrite(r Rewriter, node Node) Node {
 		n.Dimensions = Rewrite(r, n.Dimensions).(Dimensions)
 		n.Source = Rewrite(r, n.Source).(Source)
 		n.Condition = Rewrite(r, n.Condition).(Expr)
+		if n.Having != nil {
+			n.Having = Rewrite(r, n.Having).(Expr)
+		}
 
 	case Fields:
 		for i, f := range n {
@@ -3,6 +3,7 @@ package influxql_test
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/influxdb/influxdb/influxql"
 )
@@ -14,6 +15,9 @@ func TestInspectDataType(t *testing.T) {
 		typ influxql.DataType
 	}{
 		{float64(100), influxql.Number},
+		{int64(100), influxql.Integer},
+		{true, influxql.Boolean},
+		{"foo", influxql.String},
 	} {
 		if typ := influxql.InspectDataType(tt.v); tt.typ != typ {
 			t.Errorf("%d. %v (%s): unexpected type: %s", i, tt.v, tt.typ, typ)
@@ -93,6 +97,43 @@ func TestSelectStatement_Substatement(t *testing.T) {
 	}
 }
 
+// Ensure a statement with multiple measurements can be split into one statement per measurement.
+func TestSelectStatement_Split(t *testing.T) {
+	stmt := MustParseSelectStatement(`SELECT sum(value) FROM cpu, mem WHERE host = 'serverA' GROUP BY time(1m)`)
+
+	a := stmt.Split()
+	if len(a) != 2 {
+		t.Fatalf("unexpected statement count: %d", len(a))
+	} else if s := a[0].String(); s != `SELECT sum(value) FROM cpu WHERE host = 'serverA' GROUP BY time(1m)` {
+		t.Fatalf("unexpected statement(0): %s", s)
+	} else if s := a[1].String(); s != `SELECT sum(value) FROM mem WHERE host = 'serverA' GROUP BY time(1m)` {
+		t.Fatalf("unexpected statement(1): %s", s)
+	}
+
+	// Single measurement statements are returned as-is.
+	stmt = MustParseSelectStatement(`SELECT sum(value) FROM cpu`)
+	if a := stmt.Split(); len(a) != 1 || a[0] != stmt {
+		t.Fatalf("unexpected statements: %v", a)
+	}
+}
+
+// Ensure the GROUP BY time interval can be extracted from a statement.
+func TestSelectStatement_GroupByInterval(t *testing.T) {
+	for i, tt := range []struct {
+		s        string
+		interval time.Duration
+	}{
+		{s: `SELECT sum(value) FROM cpu`},
+		{s: `SELECT sum(value) FROM cpu GROUP BY host`},
+		{s: `SELECT sum(value) FROM cpu GROUP BY time(1m)`, interval: 1 * time.Minute},
+		{s: `SELECT sum(value) FROM cpu GROUP BY host, time(5s)`, interval: 5 * time.Second},
+	} {
+		if d := MustParseSelectStatement(tt.s).GroupByInterval(); d != tt.interval {
+			t.Errorf("%d. %s: unexpected interval: %s", i, tt.s, d)
+		}
+	}
+}
+
 // Ensure an expression can be folded.
 func TestFold(t *testing.T) {
 	for i, tt := range []struct {
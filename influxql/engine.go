@@ -1,16 +1,23 @@
 package influxql
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"io"
+	"math"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 // DB represents an interface to the underlying storage.
+// A DB may also implement io.Closer. It is closed once every iterator created
+// for a statement has finished so it can hold state, such as a read snapshot,
+// for the duration of the statement.
 type DB interface {
 	// Returns a list of series data ids matching a name and tags.
 	MatchSeries(name string, tags map[string]string) []uint32
@@ -18,6 +25,9 @@ type DB interface {
 	// Returns a slice of tag values for a series.
 	SeriesTagValues(seriesID uint32, keys []string) []string
 
+	// Returns the sorted tag keys of a measurement.
+	TagKeys(name string) []string
+
 	// Returns the id and data type for a series field.
 	// Returns id of zero if not a field.
 	Field(name, field string) (fieldID uint8, typ DataType)
@@ -49,6 +59,7 @@ func (p *Planner) Plan(stmt *SelectStatement) (*Executor, error) {
 		db:         p.DB,
 		stmt:       stmt,
 		processors: make([]processor, len(stmt.Fields)),
+		having:     make(map[Expr]int),
 	}
 
 	// Fold conditional.
@@ -65,8 +76,8 @@ func (p *Planner) Plan(stmt *SelectStatement) (*Executor, error) {
 	}
 	e.min, e.max = min, max
 
-	// Determine group by interval.
-	interval, tags, err := p.normalizeDimensions(stmt.Dimensions)
+	// Determine group by interval and tag keys.
+	interval, tags, err := p.normalizeDimensions(stmt)
 	if err != nil {
 		return nil, err
 	}
@@ -81,12 +92,21 @@ func (p *Planner) Plan(stmt *SelectStatement) (*Executor, error) {
 		e.processors[i] = p
 	}
 
+	// Resolve the fields referenced by the aggregate filter.
+	if stmt.Having != nil {
+		if err := p.planHaving(e, stmt.Having); err != nil {
+			return nil, err
+		}
+	}
+
 	return e, nil
 }
 
 // normalizeDimensions extacts the time interval, if specified.
-// Returns all remaining dimensions.
-func (p *Planner) normalizeDimensions(dimensions Dimensions) (time.Duration, []string, error) {
+// Returns the tag keys of all remaining dimensions.
+func (p *Planner) normalizeDimensions(stmt *SelectStatement) (time.Duration, []string, error) {
+	dimensions := stmt.Dimensions
+
 	// Ignore if there are no dimensions.
 	if len(dimensions) == 0 {
 		return 0, nil, nil
@@ -104,10 +124,33 @@ func (p *Planner) normalizeDimensions(dimensions Dimensions) (time.Duration, []s
 		if !ok {
 			return 0, nil, errors.New("time dimension must have one duration argument")
 		}
-		return lit.Val, dimensionKeys(dimensions[1:]), nil
+		tags, err := p.dimensionKeys(stmt, dimensions[1:])
+		return lit.Val, tags, err
 	}
 
-	return 0, dimensionKeys(dimensions), nil
+	tags, err := p.dimensionKeys(stmt, dimensions)
+	return 0, tags, err
+}
+
+// dimensionKeys returns the tag keys of a set of dimensions.
+// A wildcard is expanded to every tag key of the statement's measurement.
+func (p *Planner) dimensionKeys(stmt *SelectStatement, dimensions Dimensions) ([]string, error) {
+	var a []string
+	for _, d := range dimensions {
+		switch expr := d.Expr.(type) {
+		case *VarRef:
+			a = append(a, expr.Val)
+		case *Wildcard:
+			m, ok := stmt.Source.(*Measurement)
+			if !ok {
+				return nil, errors.New("group by wildcard requires a single measurement")
+			}
+			a = append(a, p.DB.TagKeys(m.Name)...)
+		default:
+			return nil, fmt.Errorf("invalid dimension: %s", d.Expr)
+		}
+	}
+	return a, nil
 }
 
 // planField returns a processor for field.
@@ -142,13 +185,33 @@ func (p *Planner) planExpr(e *Executor, expr Expr) (processor, error) {
 
 // planCall generates a processor for a function call.
 func (p *Planner) planCall(e *Executor, c *Call) (processor, error) {
-	// Ensure there is a single argument.
-	if len(c.Args) != 1 {
+	fn := strings.ToLower(c.Name)
+
+	// Unwrap count(distinct(field)) to count the distinct values of the field.
+	args, countDistinct := c.Args, false
+	if fn == "count" && len(args) == 1 {
+		if inner, ok := args[0].(*Call); ok && strings.EqualFold(inner.Name, "distinct") {
+			args, countDistinct = inner.Args, true
+		}
+	}
+
+	// Ensure there is a single argument. percentile() also requires a number.
+	var percentile float64
+	if fn == "percentile" {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expected two arguments for %s()", c.Name)
+		}
+		lit, ok := args[1].(*NumberLiteral)
+		if !ok || lit.Val < 0 || lit.Val > 100 {
+			return nil, fmt.Errorf("expected number between 0 and 100 in %s()", c.Name)
+		}
+		percentile = lit.Val
+	} else if len(args) != 1 {
 		return nil, fmt.Errorf("expected one argument for %s()", c.Name)
 	}
 
 	// Ensure the argument is a variable reference.
-	ref, ok := c.Args[0].(*VarRef)
+	ref, ok := args[0].(*VarRef)
 	if !ok {
 		return nil, fmt.Errorf("expected field argument in %s()", c.Name)
 	}
@@ -175,6 +238,12 @@ func (p *Planner) planCall(e *Executor, c *Call) (processor, error) {
 		return nil, fmt.Errorf("field not found: %s.%s", name, fname)
 	}
 
+	// Only numeric fields can be aggregated by functions other than count()
+	// and distinct().
+	if (typ == Boolean || typ == String) && fn != "count" && fn != "distinct" {
+		return nil, fmt.Errorf("%s() cannot be applied to %s field: %s.%s", strings.ToLower(c.Name), typ, name, fname)
+	}
+
 	// Generate a reducer for the given function.
 	r := newReducer(e)
 	r.stmt = sub
@@ -192,25 +261,79 @@ func (p *Planner) planCall(e *Executor, c *Call) (processor, error) {
 		r.mappers[i] = m
 	}
 
-	// Set the appropriate reducer function.
-	switch strings.ToLower(c.Name) {
+	// Set the appropriate reducer function. Mappers read a single series so
+	// functions that can't be combined from partial results map raw values.
+	var mfn mapFunc
+	switch fn {
 	case "count":
-		r.fn = reduceSum
-		for _, m := range r.mappers {
-			m.fn = mapCount
+		if countDistinct {
+			r.fn, mfn = reduceCountDistinct, mapDistinct
+		} else {
+			r.fn, mfn = reduceSum, mapCount
 		}
 	case "sum":
-		r.fn = reduceSum
-		for _, m := range r.mappers {
-			m.fn = mapSum
-		}
+		r.fn, mfn = reduceSum, mapSum
+	case "distinct":
+		r.fn, mfn = reduceDistinct, mapDistinct
+	case "median":
+		r.fn, mfn = reduceMedian, mapValues
+	case "percentile":
+		r.fn, mfn = newPercentileReduceFunc(percentile), mapValues
+	case "stddev":
+		r.fn, mfn = reduceStddev, mapValues
 	default:
 		return nil, fmt.Errorf("function not found: %q", c.Name)
 	}
+	for _, m := range r.mappers {
+		m.fn = mfn
+	}
 
 	return r, nil
 }
 
+// planHaving resolves each function call and name in a HAVING expression to
+// the index of the selected field that produces its value.
+func (p *Planner) planHaving(e *Executor, expr Expr) error {
+	switch expr := expr.(type) {
+	case *Call, *VarRef:
+		i := havingFieldIndex(e.stmt.Fields, expr)
+		if i == -1 {
+			return fmt.Errorf("having: %s must be selected", expr)
+		}
+		e.having[expr] = i
+	case *BinaryExpr:
+		if err := p.planHaving(e, expr.LHS); err != nil {
+			return err
+		}
+		return p.planHaving(e, expr.RHS)
+	case *ParenExpr:
+		return p.planHaving(e, expr.Expr)
+	case *NumberLiteral, *StringLiteral, *BooleanLiteral:
+	default:
+		return fmt.Errorf("having: unsupported expression: %s", expr)
+	}
+	return nil
+}
+
+// havingFieldIndex returns the index of the field matching a function call or
+// field name. Returns -1 if no field matches.
+func havingFieldIndex(fields Fields, expr Expr) int {
+	for i, f := range fields {
+		switch expr := expr.(type) {
+		case *Call:
+			if c, ok := f.Expr.(*Call); ok && strings.EqualFold(c.Name, expr.Name) &&
+				(&Call{Args: c.Args}).String() == (&Call{Args: expr.Args}).String() {
+				return i
+			}
+		case *VarRef:
+			if f.Name() == expr.Val {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
 // planBinaryExpr generates a processor for a binary expression.
 // A binary expression represents a join operator between two processors.
 func (p *Planner) planBinaryExpr(e *Executor, expr *BinaryExpr) (processor, error) {
@@ -307,10 +430,33 @@ type Executor struct {
 	min, max   time.Time        // time range
 	interval   time.Duration    // group by duration
 	tags       []string         // group by tag keys
+	having     map[Expr]int     // field index of each value in the HAVING clause
+	ctx        context.Context  // cancels reading of iterators
+
+	mu  sync.Mutex
+	err error // first error reported by an iterator
+}
+
+// setError records the first error that occurs while reading iterators.
+func (e *Executor) setError(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err == nil {
+		e.err = err
+	}
 }
 
 // Execute begins execution of the query and returns a channel to receive rows.
 func (e *Executor) Execute() (<-chan *Row, error) {
+	return e.ExecuteContext(context.Background())
+}
+
+// ExecuteContext begins execution of the query and returns a channel to
+// receive rows. If ctx is cancelled then the iterators stop reading at their
+// next interval and a single row is sent with the context's error.
+func (e *Executor) ExecuteContext(ctx context.Context) (<-chan *Row, error) {
+	e.ctx = ctx
+
 	// Initialize processors.
 	for _, p := range e.processors {
 		p.start()
@@ -355,10 +501,45 @@ loop:
 		}
 	}
 
+	// Wait for the remaining processors to finish and then release any
+	// resources held by the database for the statement, such as snapshots.
+	for _, p := range e.processors {
+		for range p.C() {
+		}
+	}
+	if c, ok := e.db.(io.Closer); ok {
+		_ = c.Close()
+	}
+
+	// Return only the error if any iterator failed since the rows are incomplete.
+	e.mu.Lock()
+	err := e.err
+	e.mu.Unlock()
+	if err != nil {
+		out <- &Row{Err: err}
+		close(out)
+		return
+	}
+
 	// Normalize rows and values.
 	// This converts the timestamps from nanoseconds to microseconds.
 	a := make(Rows, 0, len(rows))
 	for _, row := range rows {
+		// Report empty intervals using the statement's fill option.
+		row.Values = e.fill(row.Values)
+		if len(row.Values) == 0 {
+			continue
+		}
+
+		// Remove values that don't match the aggregate filter.
+		// Rows without any remaining values are not returned.
+		if e.stmt.Having != nil {
+			row.Values = e.filterHaving(row.Values)
+			if len(row.Values) == 0 {
+				continue
+			}
+		}
+
 		for _, values := range row.Values {
 			values[0] = values[0].(int64) / int64(time.Microsecond)
 		}
@@ -415,13 +596,133 @@ func (e *Executor) createRowValuesIfNotExists(rows map[string]*Row, name string,
 	return row.Values[len(row.Values)-1]
 }
 
-// dimensionKeys returns a list of tag key names for the dimensions.
-// Each dimension must be a VarRef.
-func dimensionKeys(dimensions Dimensions) (a []string) {
-	for _, d := range dimensions {
-		a = append(a, d.Expr.(*VarRef).Val)
+// fill replaces the nil values of empty intervals based on the fill option.
+// Intervals where every value is nil are removed when the option is NoFill.
+func (e *Executor) fill(a [][]interface{}) [][]interface{} {
+	other := a[:0]
+	var prev []interface{}
+	for _, values := range a {
+		empty := true
+		for i := 1; i < len(values); i++ {
+			if values[i] != nil {
+				empty = false
+				continue
+			}
+
+			switch e.stmt.Fill {
+			case DefaultFill:
+				values[i] = float64(0)
+			case PreviousFill:
+				if prev != nil {
+					values[i] = prev[i]
+				}
+			case NumberFill:
+				values[i] = e.stmt.FillValue
+			}
+		}
+
+		if empty && e.stmt.Fill == NoFill {
+			continue
+		}
+		other = append(other, values)
+		prev = values
 	}
-	return
+	return other
+}
+
+// filterHaving returns the set of values for which the HAVING clause is true.
+func (e *Executor) filterHaving(a [][]interface{}) [][]interface{} {
+	other := a[:0]
+	for _, values := range a {
+		if v, _ := e.evalHaving(e.stmt.Having, values).(bool); v {
+			other = append(other, values)
+		}
+	}
+	return other
+}
+
+// evalHaving evaluates a HAVING expression against a set of field values.
+// Returns nil if a referenced value is missing or the types don't match.
+func (e *Executor) evalHaving(expr Expr, values []interface{}) interface{} {
+	switch expr := expr.(type) {
+	case *Call, *VarRef:
+		return values[e.having[expr]+1]
+	case *BinaryExpr:
+		return evalHavingBinaryExpr(expr.Op, e.evalHaving(expr.LHS, values), e.evalHaving(expr.RHS, values))
+	case *ParenExpr:
+		return e.evalHaving(expr.Expr, values)
+	case *NumberLiteral:
+		return expr.Val
+	case *StringLiteral:
+		return expr.Val
+	case *BooleanLiteral:
+		return expr.Val
+	}
+	return nil
+}
+
+// evalHavingBinaryExpr applies an operator to two values of the same type.
+// Returns nil if the operator does not apply to the values.
+func evalHavingBinaryExpr(op Token, lhs, rhs interface{}) interface{} {
+	switch lhs := lhs.(type) {
+	case float64:
+		rhs, ok := rhs.(float64)
+		if !ok {
+			return nil
+		}
+		switch op {
+		case ADD:
+			return lhs + rhs
+		case SUB:
+			return lhs - rhs
+		case MUL:
+			return lhs * rhs
+		case DIV:
+			if rhs == 0 {
+				return float64(0)
+			}
+			return lhs / rhs
+		case EQ:
+			return lhs == rhs
+		case NEQ:
+			return lhs != rhs
+		case LT:
+			return lhs < rhs
+		case LTE:
+			return lhs <= rhs
+		case GT:
+			return lhs > rhs
+		case GTE:
+			return lhs >= rhs
+		}
+	case string:
+		rhs, ok := rhs.(string)
+		if !ok {
+			return nil
+		}
+		switch op {
+		case EQ:
+			return lhs == rhs
+		case NEQ:
+			return lhs != rhs
+		}
+	case bool:
+		rhs, ok := rhs.(bool)
+		if !ok {
+			return nil
+		}
+		switch op {
+		case AND:
+			return lhs && rhs
+		case OR:
+			return lhs || rhs
+		case EQ:
+			return lhs == rhs
+		case NEQ:
+			return lhs != rhs
+		}
+	}
+	return nil
 }
 
 // mapper represents an object for processing iterators.
@@ -452,13 +753,15 @@ func newMapper(e *Executor, seriesID uint32, fieldID uint8, typ DataType) *mappe
 	}
 }
 
-// start begins processing the iterator.
-func (m *mapper) start() {
+// open creates the mapper's iterator.
+func (m *mapper) open() {
 	m.itr = m.executor.db.CreateIterator(m.seriesID, m.fieldID, m.typ,
 		m.executor.min, m.executor.max, m.executor.interval)
-	go m.run()
 }
 
+// start begins processing the iterator.
+func (m *mapper) start() { go m.run() }
+
 // stop stops the mapper.
 func (m *mapper) stop() { syncClose(m.done) }
 
@@ -468,7 +771,28 @@ func (m *mapper) C() <-chan map[string]interface{} { return m.c }
 // run executes the map function against the iterator.
 func (m *mapper) run() {
 	for m.itr.NextIterval() {
+		// Stop reading if the query has been cancelled.
+		if err := m.executor.ctx.Err(); err != nil {
+			m.executor.setError(err)
+			break
+		}
+
 		m.fn(m.itr, m)
+
+		// Report iterators that failed to read their data. Values are still
+		// emitted so the other mappers are not blocked.
+		if itr, ok := m.itr.(interface {
+			Err() error
+		}); ok {
+			if err := itr.Err(); err != nil {
+				m.executor.setError(err)
+			}
+		}
+	}
+
+	// Release any resources held by the iterator, such as a read transaction.
+	if c, ok := m.itr.(io.Closer); ok {
+		_ = c.Close()
 	}
 	close(m.c)
 }
@@ -486,23 +810,72 @@ func (m *mapper) emit(key int64, value interface{}) {
 type mapFunc func(Iterator, *mapper)
 
 // mapCount computes the number of values in an iterator.
+// Emits nil if the interval is empty.
 func mapCount(itr Iterator, m *mapper) {
 	n := 0
 	for k, _ := itr.Next(); k != 0; k, _ = itr.Next() {
 		n++
 	}
+	if n == 0 {
+		m.emit(itr.Time(), nil)
+		return
+	}
 	m.emit(itr.Time(), float64(n))
 }
 
 // mapSum computes the summation of values in an iterator.
+// Emits nil if the interval is empty.
 func mapSum(itr Iterator, m *mapper) {
-	n := float64(0)
+	n, empty := float64(0), true
 	for k, v := itr.Next(); k != 0; k, v = itr.Next() {
-		n += v.(float64)
+		switch v := v.(type) {
+		case float64:
+			n += v
+		case int64:
+			n += float64(v)
+		}
+		empty = false
+	}
+	if empty {
+		m.emit(itr.Time(), nil)
+		return
 	}
 	m.emit(itr.Time(), n)
 }
 
+// mapValues collects the numeric values in an iterator.
+// Emits nil if the interval is empty.
+func mapValues(itr Iterator, m *mapper) {
+	var a []float64
+	for k, v := itr.Next(); k != 0; k, v = itr.Next() {
+		switch v := v.(type) {
+		case float64:
+			a = append(a, v)
+		case int64:
+			a = append(a, float64(v))
+		}
+	}
+	if len(a) == 0 {
+		m.emit(itr.Time(), nil)
+		return
+	}
+	m.emit(itr.Time(), a)
+}
+
+// mapDistinct collects the unique values in an iterator.
+// Emits nil if the interval is empty.
+func mapDistinct(itr Iterator, m *mapper) {
+	set := make(map[interface{}]struct{})
+	for k, v := itr.Next(); k != 0; k, v = itr.Next() {
+		set[v] = struct{}{}
+	}
+	if len(set) == 0 {
+		m.emit(itr.Time(), nil)
+		return
+	}
+	m.emit(itr.Time(), set)
+}
+
 // processor represents an object for joining reducer output.
 type processor interface {
 	start()
@@ -534,6 +907,11 @@ func newReducer(e *Executor) *reducer {
 
 // start begins streaming values from the mappers and reducing them.
 func (r *reducer) start() {
+	// Create every iterator before any are read so that the database can
+	// batch their reads.
+	for _, m := range r.mappers {
+		m.open()
+	}
 	for _, m := range r.mappers {
 		m.start()
 	}
@@ -556,8 +934,9 @@ func (r *reducer) name() string { return r.stmt.Source.(*Measurement).Name }
 
 // run runs the reducer loop to read mapper output and reduce it.
 func (r *reducer) run() {
+	// Stop immediately if no series matched.
 loop:
-	for {
+	for len(r.mappers) > 0 {
 		// Combine all data from the mappers.
 		data := make(map[string][]interface{})
 		for _, m := range r.mappers {
@@ -589,14 +968,154 @@ func (r *reducer) emit(key string, value interface{}) {
 type reduceFunc func(string, []interface{}, *reducer)
 
 // reduceSum computes the sum of values for each key.
+// Emits nil if every mapper's interval was empty.
 func reduceSum(key string, values []interface{}, r *reducer) {
 	var n float64
+	empty := true
 	for _, v := range values {
+		if v == nil {
+			continue
+		}
 		n += v.(float64)
+		empty = false
+	}
+	if empty {
+		r.emit(key, nil)
+		return
 	}
 	r.emit(key, n)
 }
 
+// reduceValues merges the values collected by mapValues into a sorted slice.
+func reduceValues(values []interface{}) []float64 {
+	var a []float64
+	for _, v := range values {
+		if v != nil {
+			a = append(a, v.([]float64)...)
+		}
+	}
+	sort.Float64s(a)
+	return a
+}
+
+// reduceMedian computes the median of the values for each key.
+// The two middle values are averaged for an even number of values.
+func reduceMedian(key string, values []interface{}, r *reducer) {
+	a := reduceValues(values)
+	if len(a) == 0 {
+		r.emit(key, nil)
+		return
+	}
+
+	if i := len(a) / 2; len(a)%2 == 1 {
+		r.emit(key, a[i])
+	} else {
+		r.emit(key, (a[i-1]+a[i])/2)
+	}
+}
+
+// newPercentileReduceFunc returns a reduce function that computes the nth
+// percentile of the values for each key using the nearest rank.
+func newPercentileReduceFunc(n float64) reduceFunc {
+	return func(key string, values []interface{}, r *reducer) {
+		a := reduceValues(values)
+		if len(a) == 0 {
+			r.emit(key, nil)
+			return
+		}
+
+		i := int(math.Floor(float64(len(a))*n/100+0.5)) - 1
+		if i < 0 {
+			i = 0
+		} else if i >= len(a) {
+			i = len(a) - 1
+		}
+		r.emit(key, a[i])
+	}
+}
+
+// reduceStddev computes the sample standard deviation of the values for each
+// key. Emits nil if there are fewer than two values.
+func reduceStddev(key string, values []interface{}, r *reducer) {
+	a := reduceValues(values)
+	if len(a) < 2 {
+		r.emit(key, nil)
+		return
+	}
+
+	var sum float64
+	for _, v := range a {
+		sum += v
+	}
+	mean := sum / float64(len(a))
+
+	var variance float64
+	for _, v := range a {
+		variance += (v - mean) * (v - mean)
+	}
+	r.emit(key, math.Sqrt(variance/float64(len(a)-1)))
+}
+
+// reduceDistinctSet merges the sets collected by mapDistinct.
+func reduceDistinctSet(values []interface{}) map[interface{}]struct{} {
+	set := make(map[interface{}]struct{})
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		for k := range v.(map[interface{}]struct{}) {
+			set[k] = struct{}{}
+		}
+	}
+	return set
+}
+
+// reduceDistinct computes the sorted unique values for each key.
+func reduceDistinct(key string, values []interface{}, r *reducer) {
+	set := reduceDistinctSet(values)
+	if len(set) == 0 {
+		r.emit(key, nil)
+		return
+	}
+
+	a := make(distinctValues, 0, len(set))
+	for v := range set {
+		a = append(a, v)
+	}
+	sort.Sort(a)
+	r.emit(key, []interface{}(a))
+}
+
+// reduceCountDistinct computes the number of unique values for each key.
+func reduceCountDistinct(key string, values []interface{}, r *reducer) {
+	set := reduceDistinctSet(values)
+	if len(set) == 0 {
+		r.emit(key, nil)
+		return
+	}
+	r.emit(key, float64(len(set)))
+}
+
+// distinctValues sorts the unique values of a field.
+// Values of a field all have the same type.
+type distinctValues []interface{}
+
+func (a distinctValues) Len() int      { return len(a) }
+func (a distinctValues) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a distinctValues) Less(i, j int) bool {
+	switch v := a[i].(type) {
+	case float64:
+		return v < a[j].(float64)
+	case int64:
+		return v < a[j].(int64)
+	case string:
+		return v < a[j].(string)
+	case bool:
+		return !v && a[j].(bool)
+	}
+	return false
+}
+
 // binaryExprEvaluator represents a processor for combining two processors.
 type binaryExprEvaluator struct {
 	executor *Executor // parent executor
@@ -676,7 +1195,17 @@ func (e *binaryExprEvaluator) run() {
 }
 
 // eval evaluates two values using the evaluator's operation.
+// Returns nil if both values are from empty intervals. Otherwise an empty
+// value is treated as zero.
 func (e *binaryExprEvaluator) eval(lhs, rhs interface{}) interface{} {
+	if lhs == nil && rhs == nil {
+		return nil
+	} else if lhs == nil {
+		lhs = float64(0)
+	} else if rhs == nil {
+		rhs = float64(0)
+	}
+
 	switch e.op {
 	case ADD:
 		return lhs.(float64) + rhs.(float64)
@@ -745,6 +1274,10 @@ func syncClose(done chan chan struct{}) {
 
 // Iterator represents a forward-only iterator over a set of points.
 // The iterator groups points together in interval sets.
+//
+// Iterators that can fail while reading, such as iterators over remote data,
+// may also implement "Err() error". The query returns the error instead of
+// incomplete results.
 type Iterator interface {
 	// Next returns the next value from the iterator.
 	Next() (key int64, value interface{})
@@ -2,6 +2,7 @@ package influxql_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -54,6 +55,41 @@ func TestPlanner_Plan_Count_Multiseries(t *testing.T) {
 	}
 }
 
+// Ensure the planner can combine the values of multiple series for aggregates
+// that can't be computed from each series separately.
+func TestPlanner_Plan_Aggregates(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(10)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(20)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T00:00:20Z", map[string]interface{}{"value": float64(20)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(50)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T00:00:10Z", map[string]interface{}{"value": float64(40)})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SELECT median(value) FROM cpu`, exp: `[{"name":"cpu","columns":["time","median"],"values":[[0,20]]}]`},
+		{q: `SELECT percentile(value, 90) FROM cpu`, exp: `[{"name":"cpu","columns":["time","percentile"],"values":[[0,50]]}]`},
+		{q: `SELECT percentile(value, 50) FROM cpu`, exp: `[{"name":"cpu","columns":["time","percentile"],"values":[[0,20]]}]`},
+		{q: `SELECT stddev(value) FROM cpu`, exp: `[{"name":"cpu","columns":["time","stddev"],"values":[[0,16.431676725154983]]}]`},
+		{q: `SELECT distinct(value) FROM cpu`, exp: `[{"name":"cpu","columns":["time","distinct"],"values":[[0,[10,20,40,50]]]}]`},
+		{q: `SELECT count(distinct(value)) FROM cpu`, exp: `[{"name":"cpu","columns":["time","count"],"values":[[0,4]]}]`},
+	} {
+		rs, err := db.PlanAndExecute(tt.q)
+		if err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, tt.q, err)
+		} else if act := minify(jsonify(rs)); tt.exp != act {
+			t.Errorf("%d. %s: unexpected resultset: %s", i, tt.q, act)
+		}
+	}
+
+	// Invalid percentiles are rejected.
+	if _, err := db.PlanAndExecute(`SELECT percentile(value, 101) FROM cpu`); err == nil || err.Error() != `expected number between 0 and 100 in percentile()` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 // Ensure the planner can plan and execute a count query grouped by hour.
 func TestPlanner_Plan_GroupByInterval(t *testing.T) {
 	db := NewDB("2000-01-01T12:00:00Z")
@@ -91,6 +127,31 @@ func TestPlanner_Plan_GroupByInterval(t *testing.T) {
 	}
 }
 
+// Ensure the planner reports empty intervals using the fill option.
+func TestPlanner_Plan_GroupByInterval_Fill(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T09:00:00Z", map[string]interface{}{"value": float64(100)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T10:30:00Z", map[string]interface{}{"value": float64(0)})
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T11:30:00Z", map[string]interface{}{"value": float64(50)})
+
+	for i, tt := range []struct {
+		fill string
+		exp  string
+	}{
+		{fill: ``, exp: `[[946717200000000,100],[946719000000000,0],[946720800000000,0],[946722600000000,0],[946724400000000,0],[946726200000000,50]]`},
+		{fill: `fill(null)`, exp: `[[946717200000000,100],[946719000000000,null],[946720800000000,null],[946722600000000,0],[946724400000000,null],[946726200000000,50]]`},
+		{fill: `fill(none)`, exp: `[[946717200000000,100],[946722600000000,0],[946726200000000,50]]`},
+		{fill: `fill(previous)`, exp: `[[946717200000000,100],[946719000000000,100],[946720800000000,100],[946722600000000,0],[946724400000000,0],[946726200000000,50]]`},
+		{fill: `fill(-1)`, exp: `[[946717200000000,100],[946719000000000,-1],[946720800000000,-1],[946722600000000,0],[946724400000000,-1],[946726200000000,50]]`},
+	} {
+		rs := db.MustPlanAndExecute(`SELECT sum(value) FROM cpu WHERE time >= now() - 3h GROUP BY time(30m) ` + tt.fill)
+		exp := `[{"name":"cpu","columns":["time","sum"],"values":` + tt.exp + `}]`
+		if act := minify(jsonify(rs)); exp != act {
+			t.Errorf("%d. %s: unexpected resultset: %s", i, tt.fill, act)
+		}
+	}
+}
+
 // Ensure the planner can plan and execute a query grouped by interval and tag.
 func TestPlanner_Plan_GroupByIntervalAndTag(t *testing.T) {
 	db := NewDB("2000-01-01T12:00:00Z")
@@ -136,6 +197,112 @@ func TestPlanner_Plan_GroupByIntervalAndTag(t *testing.T) {
 	}
 }
 
+// Ensure the planner can group by every tag key of a measurement.
+func TestPlanner_Plan_GroupByWildcard(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera", "region": "us-east"}, "2000-01-01T09:00:00Z", map[string]interface{}{"value": float64(10)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera", "region": "us-east"}, "2000-01-01T10:00:00Z", map[string]interface{}{"value": float64(20)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera", "region": "us-west"}, "2000-01-01T09:00:00Z", map[string]interface{}{"value": float64(30)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T09:00:00Z", map[string]interface{}{"value": float64(1)})
+
+	rs := db.MustPlanAndExecute(`SELECT sum(value) FROM cpu GROUP BY *`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"name":"cpu",
+		"tags":{"host":"serverb","region":""},
+		"columns":["time","sum"],
+		"values":[[0,1]]
+	},{
+		"name":"cpu",
+		"tags":{"host":"servera","region":"us-east"},
+		"columns":["time","sum"],
+		"values":[[0,30]]
+	},{
+		"name":"cpu",
+		"tags":{"host":"servera","region":"us-west"},
+		"columns":["time","sum"],
+		"values":[[0,30]]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: \n\n%s\n\n%s\n\n", exp, act)
+	}
+}
+
+// Ensure the planner only returns values matching the aggregate filter.
+func TestPlanner_Plan_Having(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T09:00:00Z", map[string]interface{}{"value": float64(10)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T09:30:00Z", map[string]interface{}{"value": float64(20)})
+	db.WriteSeries("cpu", map[string]string{"host": "servera"}, "2000-01-01T11:00:00Z", map[string]interface{}{"value": float64(30)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T09:00:00Z", map[string]interface{}{"value": float64(1)})
+	db.WriteSeries("cpu", map[string]string{"host": "serverb"}, "2000-01-01T11:00:00Z", map[string]interface{}{"value": float64(2)})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		// Filter by function call.
+		{
+			q:   `SELECT sum(value) FROM cpu WHERE time >= now() - 3h GROUP BY time(1h), host HAVING sum(value) > 20`,
+			exp: `[{"name":"cpu","tags":{"host":"servera"},"columns":["time","sum"],"values":[[946717200000000,30],[946724400000000,30]]}]`,
+		},
+
+		// Filter by alias and combine conditions.
+		{
+			q:   `SELECT sum(value) AS total, count(value) FROM cpu WHERE time >= now() - 3h GROUP BY time(1h), host HAVING total > 0 AND count(value) = 1`,
+			exp: `[{"name":"cpu","tags":{"host":"servera"},"columns":["time","total","count"],"values":[[946724400000000,30,1]]},{"name":"cpu","tags":{"host":"serverb"},"columns":["time","total","count"],"values":[[946717200000000,1,1],[946724400000000,2,1]]}]`,
+		},
+
+		// Rows without matching values are removed.
+		{
+			q:   `SELECT sum(value) FROM cpu WHERE time >= now() - 3h GROUP BY host HAVING sum > 1000`,
+			exp: `null`,
+		},
+	} {
+		rs := db.MustPlanAndExecute(tt.q)
+		if act := jsonify(rs); tt.exp != act {
+			t.Errorf("%d. %s: unexpected resultset: %s", i, tt.q, act)
+		}
+	}
+}
+
+// Ensure the planner returns an error if a HAVING value is not selected.
+func TestPlanner_Plan_Having_ErrFieldNotSelected(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T09:00:00Z", map[string]interface{}{"value": float64(10)})
+	if _, err := db.PlanAndExecute(`SELECT sum(value) FROM cpu HAVING count(value) > 1`); err == nil || err.Error() != `having: count(value) must be selected` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a cancelled execution stops reading and returns the context error.
+func TestExecutor_ExecuteContext_Cancel(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{}, "2000-01-01T00:00:00Z", map[string]interface{}{"value": float64(100)})
+
+	e, err := influxql.NewPlanner(db).Plan(MustParseSelectStatement(`SELECT sum(value) FROM cpu GROUP BY time(10s)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ch, err := e.ExecuteContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []*influxql.Row
+	for row := range ch {
+		rows = append(rows, row)
+	}
+	if len(rows) != 1 || rows[0].Err != context.Canceled {
+		t.Fatalf("unexpected rows: %s", jsonify(rows))
+	}
+}
+
 // Ensure the planner can plan and execute a query filtered by tag.
 func TestPlanner_Plan_FilterByTag(t *testing.T) {
 	db := NewDB("2000-01-01T12:00:00Z")
@@ -184,6 +351,22 @@ func TestPlanner_Plan_FilterByTag(t *testing.T) {
 	}
 }
 
+// Ensure the planner returns no rows when the filter matches no series.
+func TestPlanner_Plan_FilterByTag_NoSeries(t *testing.T) {
+	db := NewDB("2000-01-01T12:00:00Z")
+	db.WriteSeries("cpu", map[string]string{"host": "servera", "region": "us-west"}, "2000-01-01T09:00:00Z", map[string]interface{}{"value": float64(1)})
+
+	rs := db.MustPlanAndExecute(`
+		SELECT sum(value)
+		FROM cpu
+		WHERE time >= now() - 3h AND region = 'us-east'
+		GROUP BY time(1h), host`)
+
+	if act := jsonify(rs); act != "null" {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
 // Ensure the planner can plan and execute a joined query.
 func TestPlanner_Plan_Join(t *testing.T) {
 	db := NewDB("2000-01-01T12:00:00Z")
@@ -375,6 +558,27 @@ func (db *DB) SeriesTagValues(seriesID uint32, keys []string) (values []string)
 	return
 }
 
+// TagKeys returns the sorted tag keys of a measurement.
+func (db *DB) TagKeys(name string) []string {
+	m := db.measurements[name]
+	if m == nil {
+		return nil
+	}
+
+	set := make(map[string]struct{})
+	for _, s := range m.series {
+		for k := range s.tags {
+			set[k] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // FieldID returns the field identifier for a given measurement name and field name.
 func (db *DB) Field(name, field string) (fieldID uint8, typ influxql.DataType) {
 	// Find measurement.
@@ -69,8 +69,14 @@ func (p *Parser) ParseStatement() (Statement, error) {
 		return p.parseSelectStatement(targetNotRequired)
 	case DELETE:
 		return p.parseDeleteStatement()
-	case LIST:
+	case LIST, SHOW:
 		return p.parseListStatement()
+	case KILL:
+		return p.parseKillQueryStatement()
+	case BLOCK:
+		return p.parseBlockStatement()
+	case UNBLOCK:
+		return p.parseUnblockStatement()
 	case CREATE:
 		return p.parseCreateStatement()
 	case DROP:
@@ -81,20 +87,34 @@ func (p *Parser) ParseStatement() (Statement, error) {
 		return p.parseRevokeStatement()
 	case ALTER:
 		return p.parseAlterStatement()
+	case REINDEX:
+		return p.parseReindexDatabaseStatement()
 	default:
 		return nil, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
 	}
 }
 
 // parseListStatement parses a string and returns a list statement.
-// This function assumes the LIST token has already been consumed.
+// This function assumes the LIST or SHOW token has already been consumed.
 func (p *Parser) parseListStatement() (Statement, error) {
 	tok, pos, lit := p.scanIgnoreWhitespace()
 	switch tok {
+	case BLOCKS:
+		return p.parseListBlocksStatement()
 	case CONTINUOUS:
 		return p.parseListContinuousQueriesStatement()
 	case DATABASES:
 		return p.parseListDatabasesStatement()
+	case EVENTS:
+		return p.parseListEventsStatement()
+	case EXPIRED:
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		if tok == SHARDS {
+			return p.parseListExpiredShardsStatement()
+		}
+		return nil, newParseError(tokstr(tok, lit), []string{"SHARDS"}, pos)
+	case DIAGNOSTICS:
+		return p.parseListDiagnosticsStatement()
 	case FIELD:
 		tok, pos, lit := p.scanIgnoreWhitespace()
 		if tok == KEYS {
@@ -103,8 +123,12 @@ func (p *Parser) parseListStatement() (Statement, error) {
 			return p.parseListFieldValuesStatement()
 		}
 		return nil, newParseError(tokstr(tok, lit), []string{"KEYS", "VALUES"}, pos)
+	case GRANTS:
+		return p.parseListGrantsStatement()
 	case MEASUREMENTS:
 		return p.parseListMeasurementsStatement()
+	case QUERIES:
+		return p.parseListQueriesStatement()
 	case RETENTION:
 		tok, pos, lit := p.scanIgnoreWhitespace()
 		if tok == POLICIES {
@@ -112,7 +136,15 @@ func (p *Parser) parseListStatement() (Statement, error) {
 		}
 		return nil, newParseError(tokstr(tok, lit), []string{"POLICIES"}, pos)
 	case SERIES:
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok == TIMES {
+			return p.parseListSeriesTimesStatement()
+		}
+		p.unscan()
 		return p.parseListSeriesStatement()
+	case SHARDS:
+		return p.parseListShardsStatement()
+	case STATS:
+		return p.parseListStatsStatement()
 	case TAG:
 		tok, pos, lit := p.scanIgnoreWhitespace()
 		if tok == KEYS {
@@ -235,6 +267,28 @@ func (p *Parser) parseCreateRetentionPolicyStatement() (*CreateRetentionPolicySt
 	}
 	stmt.Replication = n
 
+	// Parse optional SPLIT token.
+	if tok, pos, lit = p.scanIgnoreWhitespace(); tok == SPLIT {
+		n, err := p.parseInt(1, math.MaxInt32)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Split = n
+	} else {
+		p.unscan()
+	}
+
+	// Parse optional HASH token.
+	if tok, pos, lit = p.scanIgnoreWhitespace(); tok == HASH {
+		ident, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Hash = ident
+	} else {
+		p.unscan()
+	}
+
 	// Parse optional DEFAULT token.
 	if tok, pos, lit = p.scanIgnoreWhitespace(); tok == DEFAULT {
 		stmt.Default = true
@@ -270,7 +324,7 @@ func (p *Parser) parseAlterRetentionPolicyStatement() (*AlterRetentionPolicyStat
 	stmt.Database = ident
 
 	// Loop through option tokens (DURATION, REPLICATION, DEFAULT, etc.).
-	maxNumOptions := 3
+	maxNumOptions := 5
 Loop:
 	for i := 0; i < maxNumOptions; i++ {
 		tok, pos, lit := p.scanIgnoreWhitespace()
@@ -287,11 +341,23 @@ Loop:
 				return nil, err
 			}
 			stmt.Replication = &n
+		case SPLIT:
+			n, err := p.parseInt(1, math.MaxInt32)
+			if err != nil {
+				return nil, err
+			}
+			stmt.Split = &n
+		case HASH:
+			ident, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Hash = &ident
 		case DEFAULT:
 			stmt.Default = true
 		default:
 			if i < 1 {
-				return nil, newParseError(tokstr(tok, lit), []string{"DURATION", "RETENTION", "DEFAULT"}, pos)
+				return nil, newParseError(tokstr(tok, lit), []string{"DURATION", "RETENTION", "SPLIT", "HASH", "DEFAULT"}, pos)
 			}
 			p.unscan()
 			break Loop
@@ -511,6 +577,18 @@ func (p *Parser) parseSelectStatement(tr targetRequirement) (*SelectStatement, e
 	}
 	stmt.Dimensions = dimensions
 
+	// Parse fill option: "fill(null|none|previous|NUMBER)".
+	if err := p.parseFill(stmt); err != nil {
+		return nil, err
+	}
+
+	// Parse aggregate filter: "HAVING EXPR".
+	having, err := p.parseHaving()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Having = having
+
 	// Parse sort: "ORDER BY FIELD+".
 	sortFields, err := p.parseOrderBy()
 	if err != nil {
@@ -600,6 +678,17 @@ func (p *Parser) parseDeleteStatement() (*DeleteStatement, error) {
 func (p *Parser) parseListSeriesStatement() (*ListSeriesStatement, error) {
 	stmt := &ListSeriesStatement{}
 
+	// Parse optional source: "FROM MEASUREMENTS".
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == FROM {
+		source, err := p.parseSource()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Source = source
+	} else {
+		p.unscan()
+	}
+
 	// Parse condition: "WHERE EXPR".
 	condition, err := p.parseCondition()
 	if err != nil {
@@ -629,6 +718,32 @@ func (p *Parser) parseListSeriesStatement() (*ListSeriesStatement, error) {
 func (p *Parser) parseListMeasurementsStatement() (*ListMeasurementsStatement, error) {
 	stmt := &ListMeasurementsStatement{}
 
+	// Parse optional name filter: "WITH MEASUREMENT = name" or "WITH MEASUREMENT =~ /regex/".
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == WITH {
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != MEASUREMENT {
+			return nil, newParseError(tokstr(tok, lit), []string{"MEASUREMENT"}, pos)
+		}
+
+		switch tok, pos, lit := p.scanIgnoreWhitespace(); tok {
+		case EQ:
+			ident, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Name = &VarRef{Val: ident}
+		case EQREGEX:
+			re, err := p.parseRegex()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Name = re
+		default:
+			return nil, newParseError(tokstr(tok, lit), []string{"=", "=~"}, pos)
+		}
+	} else {
+		p.unscan()
+	}
+
 	// Parse condition: "WHERE EXPR".
 	condition, err := p.parseCondition()
 	if err != nil {
@@ -672,15 +787,16 @@ func (p *Parser) parseListRetentionPoliciesStatement() (*ListRetentionPoliciesSt
 func (p *Parser) parseListTagKeysStatement() (*ListTagKeysStatement, error) {
 	stmt := &ListTagKeysStatement{}
 
-	// Parse source.
-	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
-		return nil, newParseError(tokstr(tok, lit), []string{"FROM"}, pos)
-	}
-	source, err := p.parseSource()
-	if err != nil {
-		return nil, err
+	// Parse optional source.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == FROM {
+		source, err := p.parseSource()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Source = source
+	} else {
+		p.unscan()
 	}
-	stmt.Source = source
 
 	// Parse condition: "WHERE EXPR".
 	condition, err := p.parseCondition()
@@ -711,15 +827,23 @@ func (p *Parser) parseListTagKeysStatement() (*ListTagKeysStatement, error) {
 func (p *Parser) parseListTagValuesStatement() (*ListTagValuesStatement, error) {
 	stmt := &ListTagValuesStatement{}
 
-	// Parse source.
-	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
-		return nil, newParseError(tokstr(tok, lit), []string{"FROM"}, pos)
+	// Parse optional source.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == FROM {
+		source, err := p.parseSource()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Source = source
+	} else {
+		p.unscan()
 	}
-	source, err := p.parseSource()
+
+	// Parse optional tag key: "WITH KEY = ident".
+	key, err := p.parseWithKey()
 	if err != nil {
 		return nil, err
 	}
-	stmt.Source = source
+	stmt.TagKey = key
 
 	// Parse condition: "WHERE EXPR".
 	condition, err := p.parseCondition()
@@ -745,26 +869,60 @@ func (p *Parser) parseListTagValuesStatement() (*ListTagValuesStatement, error)
 	return stmt, nil
 }
 
+// parseWithKey parses an optional key clause: "WITH KEY = ident".
+// KEY isn't a keyword so that "key" can still be used as an identifier.
+// Returns an empty string if there is no clause.
+func (p *Parser) parseWithKey() (string, error) {
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != WITH {
+		p.unscan()
+		return "", nil
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToUpper(lit) != "KEY" {
+		return "", newParseError(tokstr(tok, lit), []string{"KEY"}, pos)
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != EQ {
+		return "", newParseError(tokstr(tok, lit), []string{"="}, pos)
+	}
+	return p.parseIdent()
+}
+
 // parseListUsersStatement parses a string and returns a ListUsersStatement.
 // This function assumes the "LIST USERS" tokens have been consumed.
 func (p *Parser) parseListUsersStatement() (*ListUsersStatement, error) {
 	return &ListUsersStatement{}, nil
 }
 
+// parseListGrantsStatement parses a string and returns a ListGrantsStatement.
+// This function assumes the "LIST GRANTS" tokens have already been consumed.
+func (p *Parser) parseListGrantsStatement() (*ListGrantsStatement, error) {
+	// Parse required FOR token.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FOR {
+		return nil, newParseError(tokstr(tok, lit), []string{"FOR"}, pos)
+	}
+
+	// Parse the name of the user.
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	return &ListGrantsStatement{Name: name}, nil
+}
+
 // parseListFieldKeysStatement parses a string and returns a ListSeriesStatement.
 // This function assumes the "LIST FIELD KEYS" tokens have already been consumed.
 func (p *Parser) parseListFieldKeysStatement() (*ListFieldKeysStatement, error) {
 	stmt := &ListFieldKeysStatement{}
 
-	// Parse source.
-	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
-		return nil, newParseError(tokstr(tok, lit), []string{"FROM"}, pos)
-	}
-	source, err := p.parseSource()
-	if err != nil {
-		return nil, err
+	// Parse optional source.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == FROM {
+		source, err := p.parseSource()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Source = source
+	} else {
+		p.unscan()
 	}
-	stmt.Source = source
 
 	// Parse condition: "WHERE EXPR".
 	condition, err := p.parseCondition()
@@ -790,6 +948,39 @@ func (p *Parser) parseListFieldKeysStatement() (*ListFieldKeysStatement, error)
 	return stmt, nil
 }
 
+// parseListSeriesTimesStatement parses a string and returns a ListSeriesTimesStatement.
+// This function assumes the "LIST SERIES TIMES" tokens have already been consumed.
+func (p *Parser) parseListSeriesTimesStatement() (*ListSeriesTimesStatement, error) {
+	stmt := &ListSeriesTimesStatement{}
+
+	// Parse optional source: "FROM MEASUREMENTS".
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == FROM {
+		source, err := p.parseSource()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Source = source
+	} else {
+		p.unscan()
+	}
+
+	// Parse condition: "WHERE EXPR".
+	condition, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Condition = condition
+
+	// Parse limit: "LIMIT INT".
+	limit, err := p.parseLimit()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Limit = limit
+
+	return stmt, nil
+}
+
 // parseListFieldValuesStatement parses a string and returns a ListSeriesStatement.
 // This function assumes the "LIST FIELD VALUES" tokens have already been consumed.
 func (p *Parser) parseListFieldValuesStatement() (*ListFieldValuesStatement, error) {
@@ -805,6 +996,13 @@ func (p *Parser) parseListFieldValuesStatement() (*ListFieldValuesStatement, err
 	}
 	stmt.Source = source
 
+	// Parse optional field key: "WITH KEY = ident".
+	key, err := p.parseWithKey()
+	if err != nil {
+		return nil, err
+	}
+	stmt.FieldKey = key
+
 	// Parse condition: "WHERE EXPR".
 	condition, err := p.parseCondition()
 	if err != nil {
@@ -834,12 +1032,34 @@ func (p *Parser) parseListFieldValuesStatement() (*ListFieldValuesStatement, err
 func (p *Parser) parseDropSeriesStatement() (*DropSeriesStatement, error) {
 	stmt := &DropSeriesStatement{}
 
-	// Read the name of the series to drop.
-	lit, err := p.parseIdent()
+	// Read the name of the series to drop unless a source or condition follows.
+	tok, _, _ := p.scanIgnoreWhitespace()
+	p.unscan()
+	if tok != FROM && tok != WHERE {
+		lit, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Name = lit
+	}
+
+	// Parse optional source: "FROM SOURCE".
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == FROM {
+		source, err := p.parseSource()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Source = source
+	} else {
+		p.unscan()
+	}
+
+	// Parse condition: "WHERE EXPR".
+	condition, err := p.parseCondition()
 	if err != nil {
 		return nil, err
 	}
-	stmt.Name = lit
+	stmt.Condition = condition
 
 	return stmt, nil
 }
@@ -864,6 +1084,184 @@ func (p *Parser) parseListDatabasesStatement() (*ListDatabasesStatement, error)
 	return stmt, nil
 }
 
+// parseListEventsStatement parses a string and returns a ListEventsStatement.
+// This function assumes the "LIST EVENTS" tokens have already been consumed.
+func (p *Parser) parseListEventsStatement() (*ListEventsStatement, error) {
+	stmt := &ListEventsStatement{}
+
+	// Parse optional name: "FROM IDENT".
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == FROM {
+		ident, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Name = ident
+	} else {
+		p.unscan()
+	}
+
+	// Parse condition: "WHERE EXPR".
+	condition, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Condition = condition
+
+	// Parse limit: "LIMIT INT".
+	limit, err := p.parseLimit()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Limit = limit
+
+	return stmt, nil
+}
+
+// parseListExpiredShardsStatement parses a string and returns a ListExpiredShardsStatement.
+// This function assumes the "LIST EXPIRED SHARDS" tokens have already been consumed.
+func (p *Parser) parseListExpiredShardsStatement() (*ListExpiredShardsStatement, error) {
+	return &ListExpiredShardsStatement{}, nil
+}
+
+// parseListShardsStatement parses a string and returns a ListShardsStatement.
+// This function assumes the "LIST SHARDS" tokens have already been consumed.
+func (p *Parser) parseListShardsStatement() (*ListShardsStatement, error) {
+	return &ListShardsStatement{}, nil
+}
+
+// parseListQueriesStatement parses a string and returns a ListQueriesStatement.
+// This function assumes the "LIST QUERIES" tokens have already been consumed.
+func (p *Parser) parseListQueriesStatement() (*ListQueriesStatement, error) {
+	return &ListQueriesStatement{}, nil
+}
+
+// parseListDiagnosticsStatement parses a string and returns a ListDiagnosticsStatement.
+// This function assumes the "LIST DIAGNOSTICS" tokens have already been consumed.
+func (p *Parser) parseListDiagnosticsStatement() (*ListDiagnosticsStatement, error) {
+	return &ListDiagnosticsStatement{}, nil
+}
+
+// parseListStatsStatement parses a string and returns a ListStatsStatement.
+// This function assumes the "LIST STATS" tokens have already been consumed.
+func (p *Parser) parseListStatsStatement() (*ListStatsStatement, error) {
+	stmt := &ListStatsStatement{}
+
+	// Parse optional FOR clause.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != FOR {
+		p.unscan()
+		return stmt, nil
+	}
+
+	// Parse the database name.
+	name, err := p.parseString()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Database = name
+
+	return stmt, nil
+}
+
+// parseListBlocksStatement parses a string and returns a ListBlocksStatement.
+// This function assumes the "LIST BLOCKS" tokens have already been consumed.
+func (p *Parser) parseListBlocksStatement() (*ListBlocksStatement, error) {
+	return &ListBlocksStatement{}, nil
+}
+
+// parseBlockStatement parses a string and returns a BlockStatement.
+// This function assumes the BLOCK token has already been consumed.
+func (p *Parser) parseBlockStatement() (*BlockStatement, error) {
+	tagKey, value, err := p.parseBlockPattern()
+	if err != nil {
+		return nil, err
+	}
+	return &BlockStatement{TagKey: tagKey, Value: value}, nil
+}
+
+// parseUnblockStatement parses a string and returns an UnblockStatement.
+// This function assumes the UNBLOCK token has already been consumed.
+func (p *Parser) parseUnblockStatement() (*UnblockStatement, error) {
+	tagKey, value, err := p.parseBlockPattern()
+	if err != nil {
+		return nil, err
+	}
+	return &UnblockStatement{TagKey: tagKey, Value: value}, nil
+}
+
+// parseBlockPattern parses the points matched by a block:
+// "MEASUREMENT = name", "MEASUREMENT =~ /regex/", "TAG key = 'value'" or
+// "TAG key =~ /regex/". The tag key is empty for measurement patterns.
+func (p *Parser) parseBlockPattern() (tagKey string, value Expr, err error) {
+	switch tok, pos, lit := p.scanIgnoreWhitespace(); tok {
+	case MEASUREMENT:
+	case TAG:
+		if tagKey, err = p.parseIdent(); err != nil {
+			return "", nil, err
+		}
+	default:
+		return "", nil, newParseError(tokstr(tok, lit), []string{"MEASUREMENT", "TAG"}, pos)
+	}
+
+	switch tok, pos, lit := p.scanIgnoreWhitespace(); tok {
+	case EQ:
+		// Measurements are matched by name and tags by string value.
+		if tagKey == "" {
+			ident, err := p.parseIdent()
+			if err != nil {
+				return "", nil, err
+			}
+			return "", &VarRef{Val: ident}, nil
+		}
+		s, err := p.parseString()
+		if err != nil {
+			return "", nil, err
+		}
+		return tagKey, &StringLiteral{Val: s}, nil
+	case EQREGEX:
+		re, err := p.parseRegex()
+		if err != nil {
+			return "", nil, err
+		}
+		return tagKey, re, nil
+	default:
+		return "", nil, newParseError(tokstr(tok, lit), []string{"=", "=~"}, pos)
+	}
+}
+
+// parseReindexDatabaseStatement parses a string and returns a ReindexDatabaseStatement.
+// This function assumes the REINDEX token has already been consumed.
+func (p *Parser) parseReindexDatabaseStatement() (*ReindexDatabaseStatement, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != DATABASE {
+		return nil, newParseError(tokstr(tok, lit), []string{"DATABASE"}, pos)
+	}
+
+	// Parse the database name.
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	return &ReindexDatabaseStatement{Name: name}, nil
+}
+
+// parseKillQueryStatement parses a string and returns a KillQueryStatement.
+// This function assumes the KILL token has already been consumed.
+func (p *Parser) parseKillQueryStatement() (*KillQueryStatement, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != QUERY {
+		return nil, newParseError(tokstr(tok, lit), []string{"QUERY"}, pos)
+	}
+
+	// Parse the query identifier.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != NUMBER {
+		return nil, newParseError(tokstr(tok, lit), []string{"number"}, pos)
+	}
+	id, err := strconv.ParseUint(lit, 10, 64)
+	if err != nil {
+		return nil, &ParseError{Message: "invalid query id: " + lit, Pos: pos}
+	}
+	return &KillQueryStatement{QueryID: id}, nil
+}
+
 // parseCreateContinuousQueriesStatement parses a string and returns a CreateContinuousQueryStatement.
 // This function assumes the "CREATE CONTINUOUS" tokens have already been consumed.
 func (p *Parser) parseCreateContinuousQueryStatement() (*CreateContinuousQueryStatement, error) {
@@ -924,6 +1322,49 @@ func (p *Parser) parseCreateDatabaseStatement() (*CreateDatabaseStatement, error
 	}
 	stmt.Name = lit
 
+	// Parse the optional WITH clause for the default retention policy.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != WITH {
+		p.unscan()
+		return stmt, nil
+	}
+	stmt.RetentionPolicyCreate = true
+
+	// Loop through option tokens (RETENTION POLICY, DURATION, REPLICATION).
+	maxNumOptions := 3
+Loop:
+	for i := 0; i < maxNumOptions; i++ {
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		switch tok {
+		case RETENTION:
+			if tok, pos, lit := p.scanIgnoreWhitespace(); tok != POLICY {
+				return nil, newParseError(tokstr(tok, lit), []string{"POLICY"}, pos)
+			}
+			ident, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			stmt.RetentionPolicyName = ident
+		case DURATION:
+			d, err := p.parseDuration()
+			if err != nil {
+				return nil, err
+			}
+			stmt.RetentionPolicyDuration = &d
+		case REPLICATION:
+			n, err := p.parseInt(1, math.MaxInt32)
+			if err != nil {
+				return nil, err
+			}
+			stmt.RetentionPolicyReplication = &n
+		default:
+			if i < 1 {
+				return nil, newParseError(tokstr(tok, lit), []string{"RETENTION", "DURATION", "REPLICATION"}, pos)
+			}
+			p.unscan()
+			break Loop
+		}
+	}
+
 	return stmt, nil
 }
 
@@ -1144,6 +1585,17 @@ func (p *Parser) parseAlias() (string, error) {
 
 // parseSource parses the "FROM" clause of the query.
 func (p *Parser) parseSource() (Source, error) {
+	// The source may be a regular expression matching measurement names.
+	if m, err := p.parseMeasurementRegex(); err != nil {
+		return nil, err
+	} else if m != nil {
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			return m, nil
+		}
+		return p.parseMeasurements(m)
+	}
+
 	// The first token can either be the series name or a join/merge call.
 	tok, pos, lit := p.scanIgnoreWhitespace()
 	if tok != IDENT {
@@ -1151,9 +1603,14 @@ func (p *Parser) parseSource() (Source, error) {
 	}
 
 	// If the token is a string or the next token is not an LPAREN then return a measurement.
+	// If the measurement is followed by a comma then return a list of measurements.
 	if next, _, _ := p.scan(); tok == STRING || (tok == IDENT && next != LPAREN) {
 		p.unscan()
-		return &Measurement{Name: lit}, nil
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			return &Measurement{Name: lit}, nil
+		}
+		return p.parseMeasurements(&Measurement{Name: lit})
 	}
 
 	// Verify the source type is join/merge.
@@ -1192,6 +1649,47 @@ func (p *Parser) parseSource() (Source, error) {
 	}
 }
 
+// parseMeasurements parses a comma-delimited list of measurement names.
+// This function assumes the first measurement and comma have already been consumed.
+func (p *Parser) parseMeasurements(first *Measurement) (Measurements, error) {
+	a := Measurements{first}
+	for {
+		// Parse a regular expression or scan the measurement name.
+		if m, err := p.parseMeasurementRegex(); err != nil {
+			return nil, err
+		} else if m != nil {
+			a = append(a, m)
+		} else if tok, pos, lit := p.scanIgnoreWhitespace(); tok != IDENT {
+			return nil, newParseError(tokstr(tok, lit), []string{"measurement name"}, pos)
+		} else {
+			a = append(a, &Measurement{Name: lit})
+		}
+
+		// If there's not a comma next then stop parsing measurements.
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			return a, nil
+		}
+	}
+}
+
+// parseMeasurementRegex parses a regular expression matching measurement names.
+// Returns nil if the next token does not start a regular expression.
+func (p *Parser) parseMeasurementRegex() (*Measurement, error) {
+	tok, pos, lit := p.s.ScanRegex()
+	if tok == BADREGEX && lit == "" {
+		return nil, nil
+	} else if tok != REGEX {
+		return nil, &ParseError{Message: "expected regular expression", Pos: pos}
+	}
+
+	re, err := regexp.Compile(lit)
+	if err != nil {
+		return nil, &ParseError{Message: err.Error(), Pos: pos}
+	}
+	return &Measurement{Regex: &RegexLiteral{Val: re}}, nil
+}
+
 // parseCondition parses the "WHERE" clause of the query, if it exists.
 func (p *Parser) parseCondition() (Expr, error) {
 	// Check if the WHERE token exists.
@@ -1209,6 +1707,54 @@ func (p *Parser) parseCondition() (Expr, error) {
 	return expr, nil
 }
 
+// parseHaving parses the "HAVING" clause of the query, if it exists.
+func (p *Parser) parseHaving() (Expr, error) {
+	// Check if the HAVING token exists.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != HAVING {
+		p.unscan()
+		return nil, nil
+	}
+
+	return p.ParseExpr()
+}
+
+// parseFill parses the "fill" clause of the query, if it exists.
+func (p *Parser) parseFill(stmt *SelectStatement) error {
+	// Check if the FILL token exists.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != FILL {
+		p.unscan()
+		return nil
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LPAREN {
+		return newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+
+	// Scan the fill option.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch {
+	case tok == NUMBER:
+		v, err := strconv.ParseFloat(lit, 64)
+		if err != nil {
+			return &ParseError{Message: "unable to parse number", Pos: pos}
+		}
+		stmt.Fill, stmt.FillValue = NumberFill, v
+	case tok == IDENT && strings.EqualFold(lit, "null"):
+		stmt.Fill = NullFill
+	case tok == IDENT && strings.EqualFold(lit, "none"):
+		stmt.Fill = NoFill
+	case tok == IDENT && strings.EqualFold(lit, "previous"):
+		stmt.Fill = PreviousFill
+	default:
+		return newParseError(tokstr(tok, lit), []string{"null", "none", "previous", "number"}, pos)
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return nil
+}
+
 // parseDimensions parses the "GROUP BY" clause of the query, if it exists.
 func (p *Parser) parseDimensions() (Dimensions, error) {
 	// If the next token is not GROUP then exit.
@@ -1244,6 +1790,13 @@ func (p *Parser) parseDimensions() (Dimensions, error) {
 
 // parseDimension parses a single dimension.
 func (p *Parser) parseDimension() (*Dimension, error) {
+	// A wildcard groups by every tag key.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == MUL {
+		p.consumeWhitespace()
+		return &Dimension{Expr: &Wildcard{}}, nil
+	}
+	p.unscan()
+
 	// Parse the expression first.
 	expr, err := p.ParseExpr()
 	if err != nil {
@@ -1379,9 +1932,13 @@ func (p *Parser) ParseExpr() (Expr, error) {
 			return expr, nil
 		}
 
-		// Otherwise parse the next unary expression.
-		rhs, err := p.parseUnaryExpr()
-		if err != nil {
+		// Otherwise parse the next expression. Regex operators require a regex on the RHS.
+		var rhs Expr
+		if op == EQREGEX || op == NEQREGEX {
+			if rhs, err = p.parseRegex(); err != nil {
+				return nil, err
+			}
+		} else if rhs, err = p.parseUnaryExpr(); err != nil {
 			return nil, err
 		}
 
@@ -1398,6 +1955,20 @@ func (p *Parser) ParseExpr() (Expr, error) {
 	}
 }
 
+// parseRegex parses a regular expression delimited by forward slashes.
+func (p *Parser) parseRegex() (*RegexLiteral, error) {
+	tok, pos, lit := p.s.ScanRegex()
+	if tok != REGEX {
+		return nil, &ParseError{Message: "expected regular expression", Pos: pos}
+	}
+
+	re, err := regexp.Compile(lit)
+	if err != nil {
+		return nil, &ParseError{Message: err.Error(), Pos: pos}
+	}
+	return &RegexLiteral{Val: re}, nil
+}
+
 // parseUnaryExpr parses an non-binary expression.
 func (p *Parser) parseUnaryExpr() (Expr, error) {
 	// If the first token is a LPAREN then parse it as its own grouped expression.
@@ -2,6 +2,7 @@ package influxql_test
 
 import (
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -81,6 +82,119 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SELECT statement with HAVING
+		{
+			s: `SELECT sum(value) FROM cpu GROUP BY host HAVING sum(value) > 100`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{
+					&influxql.Field{Expr: &influxql.Call{Name: "sum", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}},
+				},
+				Source: &influxql.Measurement{Name: "cpu"},
+				Dimensions: []*influxql.Dimension{
+					&influxql.Dimension{Expr: &influxql.VarRef{Val: "host"}},
+				},
+				Having: &influxql.BinaryExpr{
+					Op:  influxql.GT,
+					LHS: &influxql.Call{Name: "sum", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}},
+					RHS: &influxql.NumberLiteral{Val: 100},
+				},
+			},
+		},
+
+		// SELECT statement with "key" as a field and a tag
+		{
+			s: `SELECT key FROM cpu WHERE key = 'a' GROUP BY key`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{
+					&influxql.Field{Expr: &influxql.VarRef{Val: "key"}},
+				},
+				Source: &influxql.Measurement{Name: "cpu"},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "key"},
+					RHS: &influxql.StringLiteral{Val: "a"},
+				},
+				Dimensions: []*influxql.Dimension{
+					&influxql.Dimension{Expr: &influxql.VarRef{Val: "key"}},
+				},
+			},
+		},
+
+		// SELECT statement grouped by every tag
+		{
+			s: `SELECT sum(value) FROM cpu GROUP BY time(1h), *`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{
+					&influxql.Field{Expr: &influxql.Call{Name: "sum", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}},
+				},
+				Source: &influxql.Measurement{Name: "cpu"},
+				Dimensions: []*influxql.Dimension{
+					&influxql.Dimension{Expr: &influxql.Call{Name: "time", Args: []influxql.Expr{&influxql.DurationLiteral{Val: time.Hour}}}},
+					&influxql.Dimension{Expr: &influxql.Wildcard{}},
+				},
+			},
+		},
+
+		// SELECT statement with fill
+		{
+			s: `SELECT sum(value) FROM cpu GROUP BY time(1h) fill(-1.5)`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{
+					&influxql.Field{Expr: &influxql.Call{Name: "sum", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}},
+				},
+				Source: &influxql.Measurement{Name: "cpu"},
+				Dimensions: []*influxql.Dimension{
+					&influxql.Dimension{Expr: &influxql.Call{Name: "time", Args: []influxql.Expr{&influxql.DurationLiteral{Val: time.Hour}}}},
+				},
+				Fill:      influxql.NumberFill,
+				FillValue: float64(-1.5),
+			},
+		},
+		{
+			s: `SELECT sum(value) FROM cpu GROUP BY time(1h) fill(previous) HAVING sum(value) > 100`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{
+					&influxql.Field{Expr: &influxql.Call{Name: "sum", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}},
+				},
+				Source: &influxql.Measurement{Name: "cpu"},
+				Dimensions: []*influxql.Dimension{
+					&influxql.Dimension{Expr: &influxql.Call{Name: "time", Args: []influxql.Expr{&influxql.DurationLiteral{Val: time.Hour}}}},
+				},
+				Fill: influxql.PreviousFill,
+				Having: &influxql.BinaryExpr{
+					Op:  influxql.GT,
+					LHS: &influxql.Call{Name: "sum", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}},
+					RHS: &influxql.NumberLiteral{Val: 100},
+				},
+			},
+		},
+
+		// SELECT statement with a regex source
+		{
+			s: `SELECT sum(value) FROM /cpu.*/, mem`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{
+					&influxql.Field{Expr: &influxql.Call{Name: "sum", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}},
+				},
+				Source: influxql.Measurements{
+					&influxql.Measurement{Regex: &influxql.RegexLiteral{Val: regexp.MustCompile(`cpu.*`)}},
+					&influxql.Measurement{Name: "mem"},
+				},
+			},
+		},
+		{
+			s: `SELECT sum(value) FROM mem, /cpu.*/`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{
+					&influxql.Field{Expr: &influxql.Call{Name: "sum", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}},
+				},
+				Source: influxql.Measurements{
+					&influxql.Measurement{Name: "mem"},
+					&influxql.Measurement{Regex: &influxql.RegexLiteral{Val: regexp.MustCompile(`cpu.*`)}},
+				},
+			},
+		},
+
 		// SELECT statement with JOIN
 		{
 			s: `SELECT field1 FROM join(aa,"bb", cc) JOIN cc`,
@@ -110,6 +224,24 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SELECT statement with multiple measurements
+		{
+			s: `SELECT field1 FROM cpu, "mem" , disk WHERE host = 'serverA'`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{&influxql.Field{Expr: &influxql.VarRef{Val: "field1"}}},
+				Source: influxql.Measurements{
+					{Name: "cpu"},
+					{Name: `"mem"`},
+					{Name: "disk"},
+				},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "host"},
+					RHS: &influxql.StringLiteral{Val: "serverA"},
+				},
+			},
+		},
+
 		// SELECT statement (lowercase)
 		{
 			s: `select my_field from myseries`,
@@ -147,12 +279,131 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// DELETE statement with a time range
+		{
+			s: `DELETE FROM cpu WHERE time < '2014-01-01'`,
+			stmt: &influxql.DeleteStatement{
+				Source: &influxql.Measurement{Name: "cpu"},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.LT,
+					LHS: &influxql.VarRef{Val: "time"},
+					RHS: &influxql.TimeLiteral{Val: mustParseTime("2014-01-01T00:00:00Z")},
+				},
+			},
+		},
+
 		// LIST DATABASES
 		{
 			s:    `LIST DATABASES`,
 			stmt: &influxql.ListDatabasesStatement{},
 		},
 
+		// LIST EVENTS
+		{
+			s:    `LIST EVENTS`,
+			stmt: &influxql.ListEventsStatement{},
+		},
+
+		// LIST EVENTS with name, time range and limit
+		{
+			s: `LIST EVENTS FROM deploys WHERE time > '2000-01-01 00:00:00' LIMIT 10`,
+			stmt: &influxql.ListEventsStatement{
+				Name: "deploys",
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.GT,
+					LHS: &influxql.VarRef{Val: "time"},
+					RHS: &influxql.TimeLiteral{Val: mustParseTime("2000-01-01T00:00:00Z")},
+				},
+				Limit: 10,
+			},
+		},
+
+		// LIST EXPIRED SHARDS
+		{
+			s:    `LIST EXPIRED SHARDS`,
+			stmt: &influxql.ListExpiredShardsStatement{},
+		},
+
+		// LIST SHARDS
+		{
+			s:    `LIST SHARDS`,
+			stmt: &influxql.ListShardsStatement{},
+		},
+
+		// LIST QUERIES
+		{
+			s:    `LIST QUERIES`,
+			stmt: &influxql.ListQueriesStatement{},
+		},
+
+		// SHOW is accepted in place of LIST.
+		{
+			s:    `SHOW QUERIES`,
+			stmt: &influxql.ListQueriesStatement{},
+		},
+
+		// LIST STATS
+		{
+			s:    `LIST STATS`,
+			stmt: &influxql.ListStatsStatement{},
+		},
+
+		// SHOW STATS FOR 'database'
+		{
+			s:    `SHOW STATS FOR 'mydb'`,
+			stmt: &influxql.ListStatsStatement{Database: "mydb"},
+		},
+
+		// SHOW DIAGNOSTICS
+		{
+			s:    `SHOW DIAGNOSTICS`,
+			stmt: &influxql.ListDiagnosticsStatement{},
+		},
+
+		// SHOW GRANTS FOR user
+		{
+			s:    `SHOW GRANTS FOR jdoe`,
+			stmt: &influxql.ListGrantsStatement{Name: "jdoe"},
+		},
+
+		// REINDEX DATABASE
+		{
+			s:    `REINDEX DATABASE testdb`,
+			stmt: &influxql.ReindexDatabaseStatement{Name: "testdb"},
+		},
+
+		// KILL QUERY
+		{
+			s:    `KILL QUERY 12`,
+			stmt: &influxql.KillQueryStatement{QueryID: 12},
+		},
+
+		// BLOCK statements
+		{
+			s:    `BLOCK MEASUREMENT = cpu`,
+			stmt: &influxql.BlockStatement{Value: &influxql.VarRef{Val: "cpu"}},
+		},
+		{
+			s:    `BLOCK MEASUREMENT =~ /^debug_/`,
+			stmt: &influxql.BlockStatement{Value: &influxql.RegexLiteral{Val: regexp.MustCompile(`^debug_`)}},
+		},
+		{
+			s:    `BLOCK TAG host = 'test'`,
+			stmt: &influxql.BlockStatement{TagKey: "host", Value: &influxql.StringLiteral{Val: "test"}},
+		},
+
+		// UNBLOCK statement
+		{
+			s:    `UNBLOCK TAG host =~ /^test-/`,
+			stmt: &influxql.UnblockStatement{TagKey: "host", Value: &influxql.RegexLiteral{Val: regexp.MustCompile(`^test-`)}},
+		},
+
+		// LIST BLOCKS
+		{
+			s:    `LIST BLOCKS`,
+			stmt: &influxql.ListBlocksStatement{},
+		},
+
 		// LIST SERIES statement
 		{
 			s:    `LIST SERIES`,
@@ -177,6 +428,37 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// LIST SERIES FROM with WHERE
+		{
+			s: `LIST SERIES FROM cpu, mem WHERE region = 'uswest'`,
+			stmt: &influxql.ListSeriesStatement{
+				Source: influxql.Measurements{{Name: "cpu"}, {Name: "mem"}},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "region"},
+					RHS: &influxql.StringLiteral{Val: "uswest"},
+				},
+			},
+		},
+
+		// LIST SERIES TIMES statement
+		{
+			s: `LIST SERIES TIMES FROM cpu WHERE time > now() - 5m LIMIT 10`,
+			stmt: &influxql.ListSeriesTimesStatement{
+				Source: &influxql.Measurement{Name: "cpu"},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.GT,
+					LHS: &influxql.VarRef{Val: "time"},
+					RHS: &influxql.BinaryExpr{
+						Op:  influxql.SUB,
+						LHS: &influxql.Call{Name: "now"},
+						RHS: &influxql.DurationLiteral{Val: 5 * time.Minute},
+					},
+				},
+				Limit: 10,
+			},
+		},
+
 		// LIST MEASUREMENTS WHERE with ORDER BY and LIMIT
 		{
 			s: `LIST MEASUREMENTS WHERE region = 'uswest' ORDER BY ASC, field1, field2 DESC LIMIT 10`,
@@ -195,6 +477,25 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// LIST MEASUREMENTS WITH MEASUREMENT = name
+		{
+			s:    `LIST MEASUREMENTS WITH MEASUREMENT = cpu`,
+			stmt: &influxql.ListMeasurementsStatement{Name: &influxql.VarRef{Val: "cpu"}},
+		},
+
+		// LIST MEASUREMENTS WITH MEASUREMENT =~ regex
+		{
+			s: `LIST MEASUREMENTS WITH MEASUREMENT =~ /^cpu\/[0-9]+$/ WHERE host = 'serverA'`,
+			stmt: &influxql.ListMeasurementsStatement{
+				Name: &influxql.RegexLiteral{Val: regexp.MustCompile(`^cpu/[0-9]+$`)},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "host"},
+					RHS: &influxql.StringLiteral{Val: "serverA"},
+				},
+			},
+		},
+
 		// LIST RETENTION POLICIES
 		{
 			s: `LIST RETENTION POLICIES mydb`,
@@ -222,6 +523,12 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// LIST TAG KEYS without FROM
+		{
+			s:    `LIST TAG KEYS`,
+			stmt: &influxql.ListTagKeysStatement{},
+		},
+
 		// LIST TAG VALUES
 		{
 			s: `LIST TAG VALUES FROM src WHERE region = 'uswest' ORDER BY ASC, field1, field2 DESC LIMIT 10`,
@@ -241,12 +548,41 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// LIST TAG VALUES WITH KEY
+		{
+			s: `LIST TAG VALUES FROM cpu WITH KEY = host WHERE region = 'uswest'`,
+			stmt: &influxql.ListTagValuesStatement{
+				Source: &influxql.Measurement{Name: "cpu"},
+				TagKey: "host",
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "region"},
+					RHS: &influxql.StringLiteral{Val: "uswest"},
+				},
+			},
+		},
+
+		// LIST TAG VALUES WITH KEY for a tag named "key"
+		{
+			s: `LIST TAG VALUES FROM cpu with key = key`,
+			stmt: &influxql.ListTagValuesStatement{
+				Source: &influxql.Measurement{Name: "cpu"},
+				TagKey: "key",
+			},
+		},
+
 		// LIST USERS
 		{
 			s:    `LIST USERS`,
 			stmt: &influxql.ListUsersStatement{},
 		},
 
+		// LIST FIELD KEYS without FROM
+		{
+			s:    `LIST FIELD KEYS`,
+			stmt: &influxql.ListFieldKeysStatement{},
+		},
+
 		// LIST FIELD KEYS
 		{
 			s: `LIST FIELD KEYS FROM src WHERE region = 'uswest' ORDER BY ASC, field1, field2 DESC LIMIT 10`,
@@ -285,12 +621,56 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// LIST FIELD VALUES WITH KEY
+		{
+			s: `LIST FIELD VALUES FROM cpu WITH KEY = status WHERE time > now() - 1h LIMIT 5`,
+			stmt: &influxql.ListFieldValuesStatement{
+				Source:   &influxql.Measurement{Name: "cpu"},
+				FieldKey: "status",
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.GT,
+					LHS: &influxql.VarRef{Val: "time"},
+					RHS: &influxql.BinaryExpr{
+						Op:  influxql.SUB,
+						LHS: &influxql.Call{Name: "now"},
+						RHS: &influxql.DurationLiteral{Val: time.Hour},
+					},
+				},
+				Limit: 5,
+			},
+		},
+
 		// DROP SERIES statement
 		{
 			s:    `DROP SERIES myseries`,
 			stmt: &influxql.DropSeriesStatement{Name: "myseries"},
 		},
 
+		// DROP SERIES with source and condition
+		{
+			s: `DROP SERIES FROM cpu WHERE host = 'serverA'`,
+			stmt: &influxql.DropSeriesStatement{
+				Source: &influxql.Measurement{Name: "cpu"},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "host"},
+					RHS: &influxql.StringLiteral{Val: "serverA"},
+				},
+			},
+		},
+
+		// DROP SERIES with condition only
+		{
+			s: `DROP SERIES WHERE region = 'uswest'`,
+			stmt: &influxql.DropSeriesStatement{
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "region"},
+					RHS: &influxql.StringLiteral{Val: "uswest"},
+				},
+			},
+		},
+
 		// LIST CONTINUOUS QUERIES statement
 		{
 			s:    `LIST CONTINUOUS QUERIES`,
@@ -335,6 +715,16 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// CREATE DATABASE statement with a default retention policy
+		{
+			s:    `CREATE DATABASE testdb WITH RETENTION POLICY one_week DURATION 7d REPLICATION 2`,
+			stmt: newCreateDatabaseStatement("testdb", "one_week", 7*24*time.Hour, 2),
+		},
+		{
+			s:    `CREATE DATABASE testdb WITH DURATION 1h`,
+			stmt: newCreateDatabaseStatement("testdb", "", time.Hour, -1),
+		},
+
 		// CREATE USER statement
 		{
 			s: `CREATE USER testuser WITH PASSWORD 'pwd1337'`,
@@ -502,6 +892,20 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// CREATE RETENTION POLICY ... SPLIT ... HASH
+		{
+			s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 2 SPLIT 4 HASH fnv DEFAULT`,
+			stmt: &influxql.CreateRetentionPolicyStatement{
+				Name:        "policy1",
+				Database:    "testdb",
+				Duration:    time.Hour,
+				Replication: 2,
+				Split:       4,
+				Hash:        "fnv",
+				Default:     true,
+			},
+		},
+
 		// ALTER RETENTION POLICY
 		{
 			s:    `ALTER RETENTION POLICY policy1 ON testdb DURATION 1m REPLICATION 4 DEFAULT`,
@@ -532,6 +936,17 @@ func TestParser_ParseStatement(t *testing.T) {
 			stmt: newAlterRetentionPolicyStatement("policy1", "testdb", -1, 4, false),
 		},
 
+		// ALTER RETENTION POLICY with SPLIT and HASH
+		{
+			s: `ALTER RETENTION POLICY policy1 ON testdb SPLIT 4 HASH fnv`,
+			stmt: func() *influxql.AlterRetentionPolicyStatement {
+				stmt := newAlterRetentionPolicyStatement("policy1", "testdb", -1, -1, false)
+				split, hash := 4, "fnv"
+				stmt.Split, stmt.Hash = &split, &hash
+				return stmt
+			}(),
+		},
+
 		// Errors
 		{s: ``, err: `found EOF, expected SELECT at line 1, char 1`},
 		{s: `SELECT`, err: `found EOF, expected identifier, string, number, bool at line 1, char 8`},
@@ -547,17 +962,39 @@ func TestParser_ParseStatement(t *testing.T) {
 		{s: `SELECT field1 FROM myseries ORDER BY 1`, err: `found 1, expected identifier, ASC, or DESC at line 1, char 38`},
 		{s: `SELECT field1 AS`, err: `found EOF, expected identifier at line 1, char 18`},
 		{s: `SELECT field1 FROM 12`, err: `found 12, expected identifier at line 1, char 20`},
-		{s: `SELECT field1 FROM myseries GROUP BY *`, err: `found *, expected identifier, string, number, bool at line 1, char 38`},
+		{s: `SELECT field1 FROM cpu, 12`, err: `found 12, expected measurement name at line 1, char 25`},
+		{s: `SELECT field1 FROM /cpu`, err: `expected regular expression at line 1, char 20`},
+		{s: `SELECT field1 FROM /cpu(/`, err: `error parsing regexp: missing closing ): ` + "`cpu(`" + ` at line 1, char 20`},
+		{s: `SELECT field1 FROM myseries GROUP BY /`, err: `found /, expected identifier, string, number, bool at line 1, char 38`},
+		{s: `SELECT sum(value) FROM cpu GROUP BY time(1h) fill`, err: `found EOF, expected ( at line 1, char 51`},
+		{s: `SELECT sum(value) FROM cpu GROUP BY time(1h) fill(zero)`, err: `found zero, expected null, none, previous, number at line 1, char 51`},
+		{s: `SELECT sum(value) FROM cpu GROUP BY time(1h) fill(null`, err: `found EOF, expected ) at line 1, char 56`},
 		{s: `SELECT 1000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000 FROM myseries`, err: `unable to parse number at line 1, char 8`},
 		{s: `SELECT 10.5h FROM myseries`, err: `found h, expected FROM at line 1, char 12`},
 		{s: `DELETE`, err: `found EOF, expected FROM at line 1, char 8`},
 		{s: `DELETE FROM`, err: `found EOF, expected identifier at line 1, char 13`},
 		{s: `DELETE FROM myseries WHERE`, err: `found EOF, expected identifier, string, number, bool at line 1, char 28`},
 		{s: `DROP SERIES`, err: `found EOF, expected identifier at line 1, char 13`},
+		{s: `DROP SERIES FROM`, err: `found EOF, expected identifier at line 1, char 18`},
 		{s: `LIST CONTINUOUS`, err: `found EOF, expected QUERIES at line 1, char 17`},
 		{s: `LIST RETENTION`, err: `found EOF, expected POLICIES at line 1, char 16`},
 		{s: `LIST RETENTION POLICIES`, err: `found EOF, expected identifier at line 1, char 25`},
+		{s: `LIST EVENTS FROM`, err: `found EOF, expected identifier at line 1, char 18`},
+		{s: `LIST EXPIRED FOO`, err: `found FOO, expected SHARDS at line 1, char 14`},
 		{s: `LIST FOO`, err: `found FOO, expected SERIES, CONTINUOUS, MEASUREMENTS, TAG, FIELD, RETENTION at line 1, char 6`},
+		{s: `BLOCK`, err: `found EOF, expected MEASUREMENT, TAG at line 1, char 7`},
+		{s: `BLOCK MEASUREMENT`, err: `found EOF, expected =, =~ at line 1, char 19`},
+		{s: `BLOCK MEASUREMENT = 'cpu'`, err: `found cpu, expected identifier at line 1, char 20`},
+		{s: `BLOCK TAG host = test`, err: `found test, expected string at line 1, char 18`},
+		{s: `UNBLOCK TAG`, err: `found EOF, expected identifier at line 1, char 13`},
+		{s: `KILL 12`, err: `found 12, expected QUERY at line 1, char 6`},
+		{s: `REINDEX testdb`, err: `found testdb, expected DATABASE at line 1, char 9`},
+		{s: `REINDEX DATABASE`, err: `found EOF, expected identifier at line 1, char 18`},
+		{s: `LIST STATS FOR mydb`, err: `found mydb, expected string at line 1, char 16`},
+		{s: `LIST GRANTS jdoe`, err: `found jdoe, expected FOR at line 1, char 13`},
+		{s: `LIST GRANTS FOR`, err: `found EOF, expected identifier at line 1, char 17`},
+		{s: `KILL QUERY`, err: `found EOF, expected number at line 1, char 12`},
+		{s: `KILL QUERY 1.5`, err: `invalid query id: 1.5 at line 1, char 12`},
 		{s: `DROP CONTINUOUS`, err: `found EOF, expected QUERY at line 1, char 17`},
 		{s: `DROP CONTINUOUS QUERY`, err: `found EOF, expected identifier at line 1, char 23`},
 		{s: `DROP FOO`, err: `found FOO, expected SERIES, CONTINUOUS at line 1, char 6`},
@@ -597,12 +1034,22 @@ func TestParser_ParseStatement(t *testing.T) {
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 3.14`, err: `number must be an integer at line 1, char 67`},
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 0`, err: `invalid value 0: must be 1 <= n <= 2147483647 at line 1, char 67`},
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION bad`, err: `found bad, expected number at line 1, char 67`},
+		{s: `LIST MEASUREMENTS WITH`, err: `found EOF, expected MEASUREMENT at line 1, char 24`},
+		{s: `LIST MEASUREMENTS WITH MEASUREMENT > cpu`, err: `found >, expected =, =~ at line 1, char 36`},
+		{s: `LIST MEASUREMENTS WITH MEASUREMENT =~ cpu`, err: `expected regular expression at line 1, char 39`},
+		{s: `LIST MEASUREMENTS WITH MEASUREMENT =~ /cpu(/`, err: "error parsing regexp: missing closing ): `cpu(` at line 1, char 39"},
+		{s: `LIST TAG VALUES WITH`, err: `found EOF, expected KEY at line 1, char 22`},
+		{s: `LIST TAG VALUES WITH KEY host`, err: `found host, expected = at line 1, char 26`},
+		{s: `LIST TAG VALUES WITH host = cpu`, err: `found host, expected KEY at line 1, char 22`},
+		{s: `LIST FIELD VALUES FROM cpu WITH`, err: `found EOF, expected KEY at line 1, char 33`},
 		{s: `ALTER`, err: `found EOF, expected RETENTION at line 1, char 7`},
 		{s: `ALTER RETENTION`, err: `found EOF, expected POLICY at line 1, char 17`},
 		{s: `ALTER RETENTION POLICY`, err: `found EOF, expected identifier at line 1, char 24`},
 		{s: `ALTER RETENTION POLICY policy1`, err: `found EOF, expected ON at line 1, char 32`},
 		{s: `ALTER RETENTION POLICY policy1 ON`, err: `found EOF, expected identifier at line 1, char 35`},
-		{s: `ALTER RETENTION POLICY policy1 ON testdb`, err: `found EOF, expected DURATION, RETENTION, DEFAULT at line 1, char 42`},
+		{s: `CREATE DATABASE testdb WITH`, err: `found EOF, expected RETENTION, DURATION, REPLICATION at line 1, char 29`},
+		{s: `CREATE DATABASE testdb WITH RETENTION one_week`, err: `found one_week, expected POLICY at line 1, char 39`},
+		{s: `ALTER RETENTION POLICY policy1 ON testdb`, err: `found EOF, expected DURATION, RETENTION, SPLIT, HASH, DEFAULT at line 1, char 42`},
 	}
 
 	for i, tt := range tests {
@@ -727,6 +1174,24 @@ func TestParser_ParseExpr(t *testing.T) {
 			},
 		},
 
+		// Regex binary expressions
+		{
+			s: `host =~ /^server[AB]$/ AND region !~ /west/`,
+			expr: &influxql.BinaryExpr{
+				Op: influxql.AND,
+				LHS: &influxql.BinaryExpr{
+					Op:  influxql.EQREGEX,
+					LHS: &influxql.VarRef{Val: "host"},
+					RHS: &influxql.RegexLiteral{Val: regexp.MustCompile(`^server[AB]$`)},
+				},
+				RHS: &influxql.BinaryExpr{
+					Op:  influxql.NEQREGEX,
+					LHS: &influxql.VarRef{Val: "region"},
+					RHS: &influxql.RegexLiteral{Val: regexp.MustCompile(`west`)},
+				},
+			},
+		},
+
 		// Function call (empty)
 		{
 			s: `my_func()`,
@@ -912,3 +1377,23 @@ func newAlterRetentionPolicyStatement(name string, DB string, d time.Duration, r
 
 	return stmt
 }
+
+// newCreateDatabaseStatement creates an initialized CreateDatabaseStatement
+// with a default retention policy.
+func newCreateDatabaseStatement(name string, rp string, d time.Duration, replication int) *influxql.CreateDatabaseStatement {
+	stmt := &influxql.CreateDatabaseStatement{
+		Name:                  name,
+		RetentionPolicyCreate: true,
+		RetentionPolicyName:   rp,
+	}
+
+	if d > -1 {
+		stmt.RetentionPolicyDuration = &d
+	}
+
+	if replication > -1 {
+		stmt.RetentionPolicyReplication = &replication
+	}
+
+	return stmt
+}
@@ -28,10 +28,10 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 	ch0, pos := s.r.read()
 
 	// If we see whitespace then consume all contiguous whitespace.
-	// If we see a letter then consume as an ident or reserved word.
+	// If we see a letter or underscore then consume as an ident or reserved word.
 	if isWhitespace(ch0) {
 		return s.scanWhitespace()
-	} else if isLetter(ch0) {
+	} else if isLetter(ch0) || ch0 == '_' {
 		s.r.unread()
 		return s.scanIdent()
 	} else if isDigit(ch0) {
@@ -61,7 +61,18 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 	case '/':
 		return DIV, pos, ""
 	case '=':
+		if ch1, _ := s.r.read(); ch1 == '~' {
+			return EQREGEX, pos, ""
+		}
+		s.r.unread()
 		return EQ, pos, ""
+	case '!':
+		if ch1, _ := s.r.read(); ch1 == '=' {
+			return NEQ, pos, ""
+		} else if ch1 == '~' {
+			return NEQREGEX, pos, ""
+		}
+		s.r.unread()
 	case '>':
 		if ch1, _ := s.r.read(); ch1 == '=' {
 			return GTE, pos, ""
@@ -89,6 +100,38 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 	return ILLEGAL, pos, string(ch0)
 }
 
+// ScanRegex consumes a regular expression delimited by forward slashes.
+// Leading whitespace is skipped. Forward slashes can be consumed if they're
+// first escaped with a backslash. Other escapes are passed through as-is.
+func (s *Scanner) ScanRegex() (tok Token, pos Pos, lit string) {
+	// Skip whitespace and read the opening slash.
+	ch0, pos := s.r.read()
+	for isWhitespace(ch0) {
+		ch0, pos = s.r.read()
+	}
+	if ch0 != '/' {
+		s.r.unread()
+		return BADREGEX, pos, ""
+	}
+
+	var buf bytes.Buffer
+	for {
+		ch, _ := s.r.read()
+		if ch == '/' {
+			return REGEX, pos, buf.String()
+		} else if ch == eof || ch == '\n' {
+			return BADREGEX, pos, buf.String()
+		} else if ch == '\\' {
+			if ch1, _ := s.r.read(); ch1 == '/' {
+				_, _ = buf.WriteRune(ch1)
+				continue
+			}
+			s.r.unread()
+		}
+		_, _ = buf.WriteRune(ch)
+	}
+}
+
 // scanWhitespace consumes the current rune and all contiguous whitespace.
 func (s *Scanner) scanWhitespace() (tok Token, pos Pos, lit string) {
 	// Create a buffer and read the current character into it.
@@ -303,6 +346,21 @@ func (s *bufScanner) Scan() (tok Token, pos Pos, lit string) {
 	return s.curr()
 }
 
+// ScanRegex reads a regular expression from the underlying scanner.
+// Returns ILLEGAL if there are unread tokens on the buffer.
+func (s *bufScanner) ScanRegex() (tok Token, pos Pos, lit string) {
+	if s.n > 0 {
+		return ILLEGAL, pos, ""
+	}
+
+	// Move buffer position forward and save the token.
+	s.i = (s.i + 1) % len(s.buf)
+	buf := &s.buf[s.i]
+	buf.tok, buf.pos, buf.lit = s.s.ScanRegex()
+
+	return s.curr()
+}
+
 // Unscan pushes the previously token back onto the buffer.
 func (s *bufScanner) Unscan() { s.n++ }
 
@@ -43,6 +43,9 @@ func TestScanner_Scan(t *testing.T) {
 
 		{s: `=`, tok: influxql.EQ},
 		{s: `<>`, tok: influxql.NEQ},
+		{s: `!=`, tok: influxql.NEQ},
+		{s: `=~`, tok: influxql.EQREGEX},
+		{s: `!~`, tok: influxql.NEQREGEX},
 		{s: `! `, tok: influxql.ILLEGAL, lit: "!"},
 		{s: `<`, tok: influxql.LT},
 		{s: `<=`, tok: influxql.LTE},
@@ -59,6 +62,7 @@ func TestScanner_Scan(t *testing.T) {
 		// Identifiers
 		{s: `foo`, tok: influxql.IDENT, lit: `foo`},
 		{s: `Zx12_3U_-`, tok: influxql.IDENT, lit: `Zx12_3U_`},
+		{s: `_shard`, tok: influxql.IDENT, lit: `_shard`},
 		{s: `"foo".bar`, tok: influxql.IDENT, lit: `"foo".bar`},
 
 		{s: `true`, tok: influxql.TRUE},
@@ -103,6 +107,8 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `AS`, tok: influxql.AS},
 		{s: `ASC`, tok: influxql.ASC},
 		{s: `BEGIN`, tok: influxql.BEGIN},
+		{s: `BLOCK`, tok: influxql.BLOCK},
+		{s: `BLOCKS`, tok: influxql.BLOCKS},
 		{s: `BY`, tok: influxql.BY},
 		{s: `CREATE`, tok: influxql.CREATE},
 		{s: `CONTINUOUS`, tok: influxql.CONTINUOUS},
@@ -111,20 +117,27 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `DEFAULT`, tok: influxql.DEFAULT},
 		{s: `DELETE`, tok: influxql.DELETE},
 		{s: `DESC`, tok: influxql.DESC},
+		{s: `DIAGNOSTICS`, tok: influxql.DIAGNOSTICS},
 		{s: `DROP`, tok: influxql.DROP},
 		{s: `DURATION`, tok: influxql.DURATION},
 		{s: `END`, tok: influxql.END},
 		{s: `EXISTS`, tok: influxql.EXISTS},
 		{s: `EXPLAIN`, tok: influxql.EXPLAIN},
 		{s: `FIELD`, tok: influxql.FIELD},
+		{s: `FILL`, tok: influxql.FILL},
+		{s: `FOR`, tok: influxql.FOR},
 		{s: `FROM`, tok: influxql.FROM},
 		{s: `GRANT`, tok: influxql.GRANT},
+		{s: `GRANTS`, tok: influxql.GRANTS},
 		{s: `GROUP`, tok: influxql.GROUP},
+		{s: `HASH`, tok: influxql.HASH},
+		{s: `HAVING`, tok: influxql.HAVING},
 		{s: `IF`, tok: influxql.IF},
 		{s: `INNER`, tok: influxql.INNER},
 		{s: `INSERT`, tok: influxql.INSERT},
 		{s: `INTO`, tok: influxql.INTO},
 		{s: `KEYS`, tok: influxql.KEYS},
+		{s: `KILL`, tok: influxql.KILL},
 		{s: `LIMIT`, tok: influxql.LIMIT},
 		{s: `LIST`, tok: influxql.LIST},
 		{s: `MEASUREMENT`, tok: influxql.MEASUREMENT},
@@ -138,12 +151,21 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `QUERIES`, tok: influxql.QUERIES},
 		{s: `QUERY`, tok: influxql.QUERY},
 		{s: `READ`, tok: influxql.READ},
+		{s: `REINDEX`, tok: influxql.REINDEX},
 		{s: `RETENTION`, tok: influxql.RETENTION},
 		{s: `REVOKE`, tok: influxql.REVOKE},
 		{s: `SELECT`, tok: influxql.SELECT},
 		{s: `SERIES`, tok: influxql.SERIES},
+		{s: `SHARDS`, tok: influxql.SHARDS},
+		{s: `SHOW`, tok: influxql.SHOW},
+		{s: `SPLIT`, tok: influxql.SPLIT},
+		{s: `STATS`, tok: influxql.STATS},
+		{s: `EVENTS`, tok: influxql.EVENTS},
+		{s: `EXPIRED`, tok: influxql.EXPIRED},
 		{s: `TAG`, tok: influxql.TAG},
+		{s: `TIMES`, tok: influxql.TIMES},
 		{s: `TO`, tok: influxql.TO},
+		{s: `UNBLOCK`, tok: influxql.UNBLOCK},
 		{s: `USER`, tok: influxql.USER},
 		{s: `USERS`, tok: influxql.USERS},
 		{s: `VALUES`, tok: influxql.VALUES},
@@ -248,6 +270,32 @@ func TestScanString(t *testing.T) {
 	}
 }
 
+// Ensure the scanner can correctly scan regular expressions.
+func TestScanner_ScanRegex(t *testing.T) {
+	var tests = []struct {
+		in  string
+		tok influxql.Token
+		lit string
+	}{
+		{in: `/^cpu$/`, tok: influxql.REGEX, lit: `^cpu$`},
+		{in: `  /cpu.*/`, tok: influxql.REGEX, lit: `cpu.*`},
+		{in: `/cpu\/[0-9]+/`, tok: influxql.REGEX, lit: `cpu/[0-9]+`},
+		{in: `/cpu\d+/`, tok: influxql.REGEX, lit: `cpu\d+`},
+		{in: `/cpu`, tok: influxql.BADREGEX, lit: `cpu`},
+		{in: "/cpu\nmem/", tok: influxql.BADREGEX, lit: `cpu`},
+		{in: `cpu`, tok: influxql.BADREGEX, lit: ``},
+	}
+
+	for i, tt := range tests {
+		tok, _, lit := influxql.NewScanner(strings.NewReader(tt.in)).ScanRegex()
+		if tt.tok != tok {
+			t.Errorf("%d. %s: token: exp=%s, got=%s", i, tt.in, tt.tok, tok)
+		} else if tt.lit != lit {
+			t.Errorf("%d. %s: literal: exp=%s, got=%s", i, tt.in, tt.lit, lit)
+		}
+	}
+}
+
 // Ensure identifiers can be split into multiple quoted and unquoted parts.
 func TestSplitIdent(t *testing.T) {
 	var tests = []struct {
@@ -21,6 +21,8 @@ const (
 	STRING       // "abc"
 	BADSTRING    // "abc
 	BADESCAPE    // \q
+	REGEX        // /abc/
+	BADREGEX     // /abc
 	TRUE         // true
 	FALSE        // false
 	literal_end
@@ -35,12 +37,14 @@ const (
 	AND // AND
 	OR  // OR
 
-	EQ  // =
-	NEQ // !=
-	LT  // <
-	LTE // <=
-	GT  // >
-	GTE // >=
+	EQ       // =
+	NEQ      // !=
+	EQREGEX  // =~
+	NEQREGEX // !~
+	LT       // <
+	LTE      // <=
+	GT       // >
+	GTE      // >=
 	operator_end
 
 	LPAREN    // (
@@ -56,6 +60,8 @@ const (
 	AS
 	ASC
 	BEGIN
+	BLOCK
+	BLOCKS
 	BY
 	CREATE
 	CONTINUOUS
@@ -64,20 +70,29 @@ const (
 	DEFAULT
 	DELETE
 	DESC
+	DIAGNOSTICS
 	DROP
 	DURATION
 	END
+	EVENTS
 	EXISTS
+	EXPIRED
 	EXPLAIN
 	FIELD
+	FILL
+	FOR
 	FROM
 	GRANT
+	GRANTS
 	GROUP
+	HASH
+	HAVING
 	IF
 	INNER
 	INSERT
 	INTO
 	KEYS
+	KILL
 	LIMIT
 	LIST
 	MEASUREMENT
@@ -91,13 +106,20 @@ const (
 	QUERIES
 	QUERY
 	READ
+	REINDEX
 	REPLICATION
 	RETENTION
 	REVOKE
 	SELECT
 	SERIES
+	SHARDS
+	SHOW
+	SPLIT
+	STATS
 	TAG
+	TIMES
 	TO
+	UNBLOCK
 	USER
 	USERS
 	VALUES
@@ -116,6 +138,7 @@ var tokens = [...]string{
 	NUMBER:       "NUMBER",
 	DURATION_VAL: "DURATION_VAL",
 	STRING:       "STRING",
+	REGEX:        "REGEX",
 	TRUE:         "TRUE",
 	FALSE:        "FALSE",
 
@@ -127,12 +150,14 @@ var tokens = [...]string{
 	AND: "AND",
 	OR:  "OR",
 
-	EQ:  "=",
-	NEQ: "!=",
-	LT:  "<",
-	LTE: "<=",
-	GT:  ">",
-	GTE: ">=",
+	EQ:       "=",
+	NEQ:      "!=",
+	EQREGEX:  "=~",
+	NEQREGEX: "!~",
+	LT:       "<",
+	LTE:      "<=",
+	GT:       ">",
+	GTE:      ">=",
 
 	LPAREN:    "(",
 	RPAREN:    ")",
@@ -145,6 +170,8 @@ var tokens = [...]string{
 	AS:           "AS",
 	ASC:          "ASC",
 	BEGIN:        "BEGIN",
+	BLOCK:        "BLOCK",
+	BLOCKS:       "BLOCKS",
 	BY:           "BY",
 	CREATE:       "CREATE",
 	CONTINUOUS:   "CONTINUOUS",
@@ -153,20 +180,29 @@ var tokens = [...]string{
 	DEFAULT:      "DEFAULT",
 	DELETE:       "DELETE",
 	DESC:         "DESC",
+	DIAGNOSTICS:  "DIAGNOSTICS",
 	DROP:         "DROP",
 	DURATION:     "DURATION",
 	END:          "END",
+	EVENTS:       "EVENTS",
 	EXISTS:       "EXISTS",
+	EXPIRED:      "EXPIRED",
 	EXPLAIN:      "EXPLAIN",
 	FIELD:        "FIELD",
+	FILL:         "FILL",
+	FOR:          "FOR",
 	FROM:         "FROM",
 	GRANT:        "GRANT",
+	GRANTS:       "GRANTS",
 	GROUP:        "GROUP",
+	HASH:         "HASH",
+	HAVING:       "HAVING",
 	IF:           "IF",
 	INNER:        "INNER",
 	INSERT:       "INSERT",
 	INTO:         "INTO",
 	KEYS:         "KEYS",
+	KILL:         "KILL",
 	LIMIT:        "LIMIT",
 	LIST:         "LIST",
 	MEASUREMENT:  "MEASUREMENT",
@@ -180,13 +216,20 @@ var tokens = [...]string{
 	QUERIES:      "QUERIES",
 	QUERY:        "QUERY",
 	READ:         "READ",
+	REINDEX:      "REINDEX",
 	REPLICATION:  "REPLICATION",
 	RETENTION:    "RETENTION",
 	REVOKE:       "REVOKE",
 	SELECT:       "SELECT",
 	SERIES:       "SERIES",
+	SHARDS:       "SHARDS",
+	SHOW:         "SHOW",
+	SPLIT:        "SPLIT",
+	STATS:        "STATS",
 	TAG:          "TAG",
+	TIMES:        "TIMES",
 	TO:           "TO",
+	UNBLOCK:      "UNBLOCK",
 	USER:         "USER",
 	USERS:        "USERS",
 	VALUES:       "VALUES",
@@ -226,7 +269,7 @@ func (tok Token) Precedence() int {
 		return 1
 	case AND:
 		return 2
-	case EQ, NEQ, LT, LTE, GT, GTE:
+	case EQ, NEQ, EQREGEX, NEQREGEX, LT, LTE, GT, GTE:
 		return 3
 	case ADD, SUB:
 		return 4
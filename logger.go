@@ -0,0 +1,280 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel represents the severity of a log message.
+type LogLevel int
+
+const (
+	// DebugLevel is used for verbose, per-request diagnostics.
+	DebugLevel LogLevel = iota
+
+	// InfoLevel is used for normal operational messages.
+	InfoLevel
+
+	// WarnLevel is used for recoverable problems that may need attention.
+	WarnLevel
+
+	// ErrorLevel is used for failures of background tasks and requests.
+	ErrorLevel
+)
+
+// String returns the lowercase name of the level.
+func (l LogLevel) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// ParseLogLevel returns the level for a name. "fine" is accepted as an alias
+// for debug and "warning" as an alias for warn.
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "fine", "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+	return 0, ErrInvalidLogLevel
+}
+
+// Logger writes leveled messages to an output. Messages below the logger's
+// level are discarded. Loggers derived with New() share the same output.
+type Logger struct {
+	out    *logOutput
+	prefix string
+	level  LogLevel
+	json   bool
+}
+
+// logOutput serializes writes from all loggers sharing a writer.
+type logOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewLogger returns a new logger writing to out. The prefix identifies the
+// subsystem and may be blank. If json is true then each message is written
+// as a single JSON object per line.
+func NewLogger(out io.Writer, prefix string, level LogLevel, json bool) *Logger {
+	return &Logger{
+		out:    &logOutput{w: out},
+		prefix: prefix,
+		level:  level,
+		json:   json,
+	}
+}
+
+// New returns a logger with a different prefix that shares the receiver's
+// output, level and format.
+func (l *Logger) New(prefix string) *Logger {
+	other := *l
+	other.prefix = prefix
+	return &other
+}
+
+// Level returns the minimum level written by the logger.
+func (l *Logger) Level() LogLevel { return l.level }
+
+// Debugf logs a message at the debug level.
+func (l *Logger) Debugf(format string, v ...interface{}) { l.logf(DebugLevel, format, v...) }
+
+// Infof logs a message at the info level.
+func (l *Logger) Infof(format string, v ...interface{}) { l.logf(InfoLevel, format, v...) }
+
+// Warnf logs a message at the warn level.
+func (l *Logger) Warnf(format string, v ...interface{}) { l.logf(WarnLevel, format, v...) }
+
+// Errorf logs a message at the error level.
+func (l *Logger) Errorf(format string, v ...interface{}) { l.logf(ErrorLevel, format, v...) }
+
+// Printf logs a message at the info level.
+func (l *Logger) Printf(format string, v ...interface{}) { l.logf(InfoLevel, format, v...) }
+
+// Writer returns a writer that logs each write through l at level.
+func (l *Logger) Writer(level LogLevel) io.Writer {
+	return &levelWriter{l: l, level: level}
+}
+
+// StdLogger returns a standard library logger that writes each message
+// through l at level. It is used for packages that accept a *log.Logger.
+func (l *Logger) StdLogger(level LogLevel) *log.Logger {
+	return log.New(l.Writer(level), "", 0)
+}
+
+func (l *Logger) logf(level LogLevel, format string, v ...interface{}) {
+	if level < l.level {
+		return
+	}
+	l.write(level, fmt.Sprintf(format, v...))
+}
+
+// write formats a single message and writes it to the output.
+func (l *Logger) write(level LogLevel, msg string) {
+	now := time.Now().UTC()
+	msg = strings.TrimRight(msg, "\n")
+
+	var buf []byte
+	if l.json {
+		buf, _ = json.Marshal(&logEntry{
+			Time:   now.Format(time.RFC3339Nano),
+			Level:  level.String(),
+			Prefix: l.prefix,
+			Msg:    msg,
+		})
+	} else {
+		line := now.Format("2006/01/02 15:04:05") + " " + strings.ToUpper(level.String())
+		if l.prefix != "" {
+			line += " [" + l.prefix + "]"
+		}
+		buf = []byte(line + " " + msg)
+	}
+	buf = append(buf, '\n')
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	_, _ = l.out.w.Write(buf)
+}
+
+// logEntry is the JSON encoding of a single message.
+type logEntry struct {
+	Time   string `json:"time"`
+	Level  string `json:"level"`
+	Prefix string `json:"prefix,omitempty"`
+	Msg    string `json:"msg"`
+}
+
+// levelWriter adapts a Logger to an io.Writer at a fixed level.
+type levelWriter struct {
+	l     *Logger
+	level LogLevel
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	if w.level >= w.l.level {
+		w.l.write(w.level, string(p))
+	}
+	return len(p), nil
+}
+
+// RotatingFile is a log file that is moved aside and reopened once it grows
+// past MaxSize bytes or has been open longer than MaxAge. Rotated files are
+// renamed with a timestamp suffix. A zero limit disables that rotation.
+type RotatingFile struct {
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+
+	Path    string
+	MaxSize int64
+	MaxAge  time.Duration
+
+	// Source of time for age based rotation and rotated file names.
+	Clock Clock
+}
+
+// NewRotatingFile returns a new instance of RotatingFile. It must be opened
+// before it is written to.
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration) *RotatingFile {
+	return &RotatingFile{
+		Path:    path,
+		MaxSize: maxSize,
+		MaxAge:  maxAge,
+		Clock:   systemClock{},
+	}
+}
+
+// Open opens the file for appending.
+func (f *RotatingFile) Open() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.open()
+}
+
+// open opens the file at the path and records its current size.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.f, f.size, f.opened = file, fi.Size(), f.Clock.Now()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if it is full or too old.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.f == nil {
+		return 0, os.ErrInvalid
+	}
+
+	now := f.Clock.Now()
+	full := f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize
+	old := f.MaxAge > 0 && now.Sub(f.opened) >= f.MaxAge
+	if full || old {
+		if err := f.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate closes the current file, renames it and opens a new file.
+func (f *RotatingFile) rotate(now time.Time) error {
+	if err := f.f.Close(); err != nil {
+		return err
+	}
+	f.f = nil
+
+	// Reopen the file even if it could not be moved aside so that logging
+	// can continue.
+	err := os.Rename(f.Path, f.Path+"."+now.UTC().Format("20060102T150405.000000000"))
+	if oerr := f.open(); oerr != nil {
+		return oerr
+	}
+	return err
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
@@ -0,0 +1,126 @@
+package influxdb_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure log level names can be parsed.
+func TestParseLogLevel(t *testing.T) {
+	for i, tt := range []struct {
+		s     string
+		level influxdb.LogLevel
+		err   error
+	}{
+		{s: "fine", level: influxdb.DebugLevel},
+		{s: "debug", level: influxdb.DebugLevel},
+		{s: "INFO", level: influxdb.InfoLevel},
+		{s: "warn", level: influxdb.WarnLevel},
+		{s: "warning", level: influxdb.WarnLevel},
+		{s: "error", level: influxdb.ErrorLevel},
+		{s: "trace", err: influxdb.ErrInvalidLogLevel},
+	} {
+		level, err := influxdb.ParseLogLevel(tt.s)
+		if err != tt.err {
+			t.Errorf("%d. %s: error: exp=%v, got=%v", i, tt.s, tt.err, err)
+		} else if level != tt.level {
+			t.Errorf("%d. %s: level: exp=%s, got=%s", i, tt.s, tt.level, level)
+		}
+	}
+}
+
+// Ensure the logger writes leveled text messages and discards lower levels.
+func TestLogger_Text(t *testing.T) {
+	var buf bytes.Buffer
+	l := influxdb.NewLogger(&buf, "server", influxdb.InfoLevel, false)
+	l.Debugf("hidden")
+	l.Infof("opened %d shards", 3)
+	l.New("graphite").Warnf("unable to parse data")
+	l.StdLogger(influxdb.ErrorLevel).Printf("apply: %s", "failed")
+
+	re := regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} INFO \[server\] opened 3 shards
+\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} WARN \[graphite\] unable to parse data
+\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} ERROR \[server\] apply: failed
+$`)
+	if !re.MatchString(buf.String()) {
+		t.Fatalf("unexpected output: %s", buf.String())
+	}
+}
+
+// Ensure the logger can write one JSON object per message.
+func TestLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	l := influxdb.NewLogger(&buf, "broker", influxdb.DebugLevel, true)
+	l.Debugf("hint: replica=%d", 2)
+
+	var m map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("unmarshal: %s: %s", err, buf.String())
+	} else if m["level"] != "debug" || m["prefix"] != "broker" || m["msg"] != "hint: replica=2" {
+		t.Fatalf("unexpected entry: %#v", m)
+	} else if _, err := time.Parse(time.RFC3339Nano, m["time"]); err != nil {
+		t.Fatalf("unexpected time: %s", m["time"])
+	}
+}
+
+// Ensure a log file is rotated once it grows past its maximum size.
+func TestRotatingFile_MaxSize(t *testing.T) {
+	path := filepath.Join(tempfile(), "influxdb.log")
+	os.MkdirAll(filepath.Dir(path), 0777)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	f := influxdb.NewRotatingFile(path, 12, 0)
+	if err := f.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, s := range []string{"aaaaaa\n", "bbb\n", "cccccc\n"} {
+		if _, err := f.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if b, _ := ioutil.ReadFile(path); string(b) != "cccccc\n" {
+		t.Fatalf("unexpected current file: %q", b)
+	} else if a, _ := filepath.Glob(path + ".*"); len(a) != 1 {
+		t.Fatalf("unexpected rotated files: %v", a)
+	} else if b, _ := ioutil.ReadFile(a[0]); string(b) != "aaaaaa\nbbb\n" {
+		t.Fatalf("unexpected rotated file: %q", b)
+	}
+}
+
+// Ensure a log file is rotated once it is older than its maximum age.
+func TestRotatingFile_MaxAge(t *testing.T) {
+	path := filepath.Join(tempfile(), "influxdb.log")
+	os.MkdirAll(filepath.Dir(path), 0777)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	clock := influxdb.NewMockClock(mustParseTime("2000-01-01T00:00:00Z"))
+	f := influxdb.NewRotatingFile(path, 0, time.Hour)
+	f.Clock = clock
+	if err := f.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Write([]byte("first\n"))
+	clock.Add(59 * time.Minute)
+	f.Write([]byte("second\n"))
+	clock.Add(time.Minute)
+	f.Write([]byte("third\n"))
+
+	if b, _ := ioutil.ReadFile(path); string(b) != "third\n" {
+		t.Fatalf("unexpected current file: %q", b)
+	} else if b, _ := ioutil.ReadFile(path + ".20000101T010000.000000000"); string(b) != "first\nsecond\n" {
+		t.Fatalf("unexpected rotated file: %q", b)
+	}
+}
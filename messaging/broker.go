@@ -13,6 +13,7 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/influxdb/influxdb/raft"
 )
@@ -31,6 +32,12 @@ type Broker struct {
 	replicas map[uint64]*Replica // replica by id
 	topics   map[uint64]*topic   // topics by id
 
+	// The maximum number of bytes and the maximum age of the messages held
+	// for a disconnected replica. Beyond either limit the held messages are
+	// discarded and the replica is caught up from the topic logs instead.
+	MaxHintSize int64
+	MaxHintAge  time.Duration
+
 	Logger *log.Logger
 }
 
@@ -40,12 +47,19 @@ func NewBroker() *Broker {
 		log:      raft.NewLog(),
 		replicas: make(map[uint64]*Replica),
 		topics:   make(map[uint64]*topic),
-		Logger:   log.New(os.Stderr, "[broker] ", log.LstdFlags),
+
+		MaxHintSize: DefaultMaxHintSize,
+		MaxHintAge:  DefaultMaxHintAge,
+
+		Logger: log.New(os.Stderr, "[broker] ", log.LstdFlags),
 	}
 	b.log.FSM = (*brokerFSM)(b)
 	return b
 }
 
+// SetRaftLogger sets the logger used by the broker's internal raft log.
+func (b *Broker) SetRaftLogger(l *log.Logger) { b.log.Logger = l }
+
 // Path returns the path used when opening the broker.
 // Returns empty string if the broker is not open.
 func (b *Broker) Path() string { return b.path }
@@ -69,6 +83,12 @@ func (b *Broker) Open(path string, u *url.URL) error {
 		return ErrConnectionAddressRequired
 	}
 
+	// Remove hints left by a previous process. Replica stream positions are
+	// not persisted so they cannot be handed off after a restart.
+	if err := os.RemoveAll(filepath.Join(path, "hints")); err != nil {
+		return fmt.Errorf("remove hints: %s", err)
+	}
+
 	// Open underlying raft log.
 	if err := b.log.Open(filepath.Join(path, "raft")); err != nil {
 		return fmt.Errorf("raft: %s", err)
@@ -113,11 +133,31 @@ func (b *Broker) closeTopics() {
 // closeReplicas closes all replica writers and clears the replica map.
 func (b *Broker) closeReplicas() {
 	for _, r := range b.replicas {
+		r.mu.Lock()
 		r.closeWriter()
+		r.closeHints()
+		r.mu.Unlock()
 	}
 	b.replicas = make(map[uint64]*Replica)
 }
 
+// Ready returns nil if the broker is open and its raft log is the leader or
+// knows of a leader that messages can be published through.
+func (b *Broker) Ready() error {
+	b.mu.RLock()
+	opened := b.opened()
+	b.mu.RUnlock()
+
+	if !opened {
+		return ErrClosed
+	} else if b.log.State() == raft.Leader {
+		return nil
+	} else if id, _ := b.log.Leader(); id == 0 {
+		return ErrNoLeader
+	}
+	return nil
+}
+
 // URL returns the connection url for the broker.
 func (b *Broker) URL() *url.URL {
 	return b.log.URL
@@ -260,8 +300,11 @@ func (b *Broker) mustApplyDeleteReplica(m *Message) {
 	}
 	r.topics = make(map[uint64]uint64)
 
-	// Close replica's writer.
+	// Close replica's writer and discard any undelivered messages.
+	r.mu.Lock()
 	r.closeWriter()
+	r.closeHints()
+	r.mu.Unlock()
 
 	// Remove replica from broker.
 	delete(b.replicas, c.ID)
@@ -344,6 +387,48 @@ func (b *Broker) mustApplyUnsubscribe(m *Message) {
 	}
 }
 
+// TruncateTopic removes the messages at or before index from a topic. Replicas
+// that later catch up on the topic start after index, so the state built from
+// the removed messages must be restored from elsewhere, such as a snapshot of
+// a data node's metastore.
+func (b *Broker) TruncateTopic(topicID, index uint64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Ensure the topic exists.
+	if b.topics[topicID] == nil {
+		return ErrTopicNotFound
+	}
+
+	// Issue command to truncate the topic.
+	return b.PublishSync(&Message{
+		Type: TruncateTopicMessageType,
+		Data: mustMarshalJSON(&TruncateTopicCommand{TopicID: topicID, Index: index}),
+	})
+}
+
+func (b *Broker) mustApplyTruncateTopic(m *Message) {
+	var c TruncateTopicCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	t := b.topics[c.TopicID]
+	if t == nil {
+		return
+	}
+
+	// Rewrite the topic without the truncated messages.
+	if err := t.truncate(c.Index); err != nil {
+		panic("truncate: " + err.Error())
+	}
+
+	// Move replicas past the truncated messages.
+	for _, r := range b.replicas {
+		if index, ok := r.topics[c.TopicID]; ok && index < c.Index {
+			r.topics[c.TopicID] = c.Index
+		}
+	}
+}
+
 // brokerFSM implements the raft.FSM interface for the broker.
 // This is implemented as a separate type because it is not meant to be exported.
 type brokerFSM Broker
@@ -373,6 +458,8 @@ func (fsm *brokerFSM) MustApply(e *raft.LogEntry) {
 			b.mustApplySubscribe(m)
 		case UnsubscribeMessageType:
 			b.mustApplyUnsubscribe(m)
+		case TruncateTopicMessageType:
+			b.mustApplyTruncateTopic(m)
 		}
 	} else {
 		// Internal raft commands should be broadcast out as no-ops.
@@ -675,6 +762,56 @@ func (t *topic) writeTo(r *Replica, index uint64) (int64, error) {
 	return total, nil
 }
 
+// truncate rewrites the topic file without the messages at or before index.
+func (t *topic) truncate(index uint64) error {
+	// Close the writer until the file is replaced.
+	_ = t.Close()
+
+	// Open the current file. A topic without a file has nothing to remove.
+	f, err := os.Open(t.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	// Copy the remaining messages to a new file.
+	tmp, err := os.OpenFile(t.path+".truncate", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tmp.Close() }()
+	w := bufio.NewWriter(tmp)
+	dec := NewMessageDecoder(bufio.NewReader(f))
+	for {
+		var m Message
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("decode: %s", err)
+		}
+		if m.Index <= index {
+			continue
+		}
+		if _, err := m.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+
+	// Replace the topic file and reopen it for writing.
+	if err := os.Rename(tmp.Name(), t.path); err != nil {
+		return err
+	}
+	return t.open()
+}
+
 // encode writes a message to the end of the topic.
 func (t *topic) encode(m *Message) error {
 	// Ensure the topic is open and ready for writing.
@@ -702,7 +839,7 @@ func (t *topic) encode(m *Message) error {
 
 	// Write message out to all replicas.
 	for _, r := range t.replicas {
-		_, _ = r.Write(b)
+		r.writeMessage(m.Index, b)
 	}
 
 	return nil
@@ -712,6 +849,7 @@ func (t *topic) encode(m *Message) error {
 // The replica maintains the highest index read for each topic so that the
 // broker can use this high water mark for trimming the topic logs.
 type Replica struct {
+	mu     sync.Mutex
 	id     uint64
 	url    *url.URL // TODO
 	broker *Broker
@@ -719,6 +857,10 @@ type Replica struct {
 	writer io.Writer     // currently attached writer
 	done   chan struct{} // notify when current writer is removed
 
+	index uint64     // last index written to the attached writer
+	live  bool       // true once the writer has caught up
+	hints *hintQueue // messages held while disconnected
+
 	topics map[uint64]uint64 // current index for each subscribed topic
 }
 
@@ -738,6 +880,46 @@ func (r *Replica) closeWriter() {
 		close(r.done)
 		r.done = nil
 	}
+	r.live = false
+}
+
+// closeHints discards any messages held for the replica.
+func (r *Replica) closeHints() {
+	if r.hints != nil {
+		r.hints.close()
+		r.hints = nil
+	}
+}
+
+// writeMessage writes an encoded message to the replica as it is published.
+// If the replica's stream has disconnected then the message is held so it
+// can be handed off when the replica reconnects.
+func (r *Replica) writeMessage(index uint64, b []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Write the message unless the replica is already disconnected. If a
+	// caught up stream fails then hold messages from the last index written.
+	if r.hints == nil {
+		live := r.live
+		if _, err := r.Write(b); err == nil {
+			r.index = index
+			return
+		} else if !live {
+			return
+		}
+		r.hints = newHintQueue(r.hintPath())
+	}
+
+	// Hold the message until the replica reconnects.
+	if err := r.hints.append(b, r.broker.MaxHintSize, r.broker.MaxHintAge); err != nil {
+		r.broker.Logger.Printf("hint: replica=%d, index=%d: %s", r.id, index, err)
+	}
+}
+
+// hintPath returns the path of the replica's hint queue.
+func (r *Replica) hintPath() string {
+	return filepath.Join(r.broker.path, "hints", strconv.FormatUint(r.id, 10))
 }
 
 // Topics returns a list of topic names that the replica is subscribed to.
@@ -775,8 +957,17 @@ func (r *Replica) Write(p []byte) (int, error) {
 
 // WriteTo begins writing messages to a named stream.
 // Only one writer is allowed on a stream at a time.
-func (r *Replica) WriteTo(w io.Writer) (int64, error) {
+func (r *Replica) WriteTo(w io.Writer) (int64, error) { return r.Resume(w, 0) }
+
+// Resume begins writing messages to a stream that has already received
+// messages up to index. If nothing was lost when the previous stream
+// disconnected then only the messages held since are written. Otherwise
+// all subscribed topics are replayed.
+func (r *Replica) Resume(w io.Writer, index uint64) (int64, error) {
+	r.mu.Lock()
+
 	// Close previous writer, if set.
+	live := r.live
 	r.closeWriter()
 
 	// Set a new writer on the replica.
@@ -784,6 +975,29 @@ func (r *Replica) WriteTo(w io.Writer) (int64, error) {
 	done := make(chan struct{})
 	r.done = done
 
+	// Hand off held messages if the stream continues where the previous
+	// stream left off. A previous stream that was never detached has
+	// nothing held.
+	if index > 0 && index == r.index && (live || (r.hints != nil && r.hints.valid(r.broker.MaxHintAge))) {
+		if r.hints != nil {
+			_, err := r.hints.writeTo(r)
+			r.closeHints()
+			if err != nil {
+				r.closeWriter()
+				r.mu.Unlock()
+				return 0, fmt.Errorf("hand off: %s", err)
+			}
+		}
+		r.live = true
+		r.mu.Unlock()
+
+		<-done
+		return 0, nil
+	}
+	r.closeHints()
+	r.index = 0
+	r.mu.Unlock()
+
 	// Create a topic list with the "config" topic first.
 	// Configuration changes need to be propagated to make sure topics exist.
 	ids := make([]uint64, 0, len(r.topics))
@@ -802,7 +1016,9 @@ func (r *Replica) WriteTo(w io.Writer) (int64, error) {
 		// Replica machine can ignore messages it already seen.
 		index := r.topics[topicID]
 		if _, err := t.writeTo(r, index); err != nil {
+			r.mu.Lock()
 			r.closeWriter()
+			r.mu.Unlock()
 			return 0, fmt.Errorf("add stream writer: %s", err)
 		}
 
@@ -810,6 +1026,13 @@ func (r *Replica) WriteTo(w io.Writer) (int64, error) {
 		t.replicas[r.id] = r
 	}
 
+	// Hold messages for the replica if the stream disconnects from here on.
+	r.mu.Lock()
+	if r.done == done {
+		r.live = true
+	}
+	r.mu.Unlock()
+
 	// Wait for writer to close and then return.
 	<-done
 	return 0, nil
@@ -837,6 +1060,12 @@ type UnsubscribeCommand struct {
 	TopicID   uint64 `json:"topicID"`   // topic id
 }
 
+// TruncateTopicCommand removes the messages at or before an index from a topic.
+type TruncateTopicCommand struct {
+	TopicID uint64 `json:"topicID"` // topic id
+	Index   uint64 `json:"index"`   // last index removed
+}
+
 // MessageType represents the type of message.
 type MessageType uint16
 
@@ -852,6 +1081,8 @@ const (
 
 	SubscribeMessageType   = BrokerMessageType | MessageType(0x20)
 	UnsubscribeMessageType = BrokerMessageType | MessageType(0x21)
+
+	TruncateTopicMessageType = BrokerMessageType | MessageType(0x30)
 )
 
 // The size of the encoded message header, in bytes.
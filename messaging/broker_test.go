@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -194,6 +195,108 @@ func TestBroker_Unsubscribe_ErrReplicaNotFound(t *testing.T) {
 	}
 }
 
+// Ensure the broker hands off messages held while a replica was disconnected.
+func TestBroker_Resume_HintedHandoff(t *testing.T) {
+	b := NewBroker(nil)
+	defer b.Close()
+	b.CreateReplica(2000)
+	b.Subscribe(2000, 20)
+
+	// Attach a stream and publish a message to it.
+	w0 := &StreamWriter{}
+	go func() { b.Replica(2000).WriteTo(w0) }()
+	time.Sleep(10 * time.Millisecond)
+	index := b.MustPublishSync(&messaging.Message{Type: 100, TopicID: 20, Data: []byte("0000")})
+
+	// Disconnect the stream and publish while the replica is unavailable.
+	w0.Close()
+	b.MustPublishSync(&messaging.Message{Type: 101, TopicID: 20, Data: []byte("1111")})
+	b.MustPublishSync(&messaging.Message{Type: 102, TopicID: 20, Data: []byte("2222")})
+
+	// Resume from the last received index and only the held messages are sent.
+	w1 := &StreamWriter{}
+	go func() { b.Replica(2000).Resume(w1, index) }()
+	time.Sleep(10 * time.Millisecond)
+	if a := w1.Messages(); len(a) != 2 {
+		t.Fatalf("unexpected message count: %d", len(a))
+	} else if a[0].Type != 101 || !bytes.Equal(a[0].Data, []byte("1111")) {
+		t.Fatalf("unexpected message(0): %#v", a[0])
+	} else if a[1].Type != 102 || !bytes.Equal(a[1].Data, []byte("2222")) {
+		t.Fatalf("unexpected message(1): %#v", a[1])
+	}
+
+	// Messages published after the hand off are streamed.
+	b.MustPublishSync(&messaging.Message{Type: 103, TopicID: 20})
+	if a := w1.Messages(); len(a) != 3 || a[2].Type != 103 {
+		t.Fatalf("unexpected messages: %#v", a)
+	}
+}
+
+// Ensure the broker replays all topics when held messages cannot be handed off.
+func TestBroker_Resume_Replay(t *testing.T) {
+	for i, tt := range []struct {
+		maxSize int64
+		maxAge  time.Duration
+		offset  uint64 // subtracted from the resumed index
+	}{
+		{maxSize: messaging.DefaultMaxHintSize, maxAge: messaging.DefaultMaxHintAge, offset: 1}, // missed messages
+		{maxSize: 10, maxAge: messaging.DefaultMaxHintAge},                                      // too large
+		{maxSize: messaging.DefaultMaxHintSize, maxAge: time.Nanosecond},                        // too old
+	} {
+		b := NewBroker(nil)
+		b.MaxHintSize, b.MaxHintAge = tt.maxSize, tt.maxAge
+		b.CreateReplica(2000)
+		b.Subscribe(2000, 20)
+
+		w0 := &StreamWriter{}
+		go func() { b.Replica(2000).WriteTo(w0) }()
+		time.Sleep(10 * time.Millisecond)
+		index := b.MustPublishSync(&messaging.Message{Type: 100, TopicID: 20, Data: []byte("0000")})
+		w0.Close()
+		b.MustPublishSync(&messaging.Message{Type: 101, TopicID: 20, Data: []byte("1111")})
+
+		// Every message since the subscription is resent.
+		w1 := &StreamWriter{}
+		go func() { b.Replica(2000).Resume(w1, index-tt.offset) }()
+		time.Sleep(10 * time.Millisecond)
+		if a := Messages(w1.Messages()).Unicasted(); len(a) != 2 || a[0].Type != 100 || a[1].Type != 101 {
+			t.Errorf("%d. unexpected messages: %#v", i, a)
+		}
+		b.Close()
+	}
+}
+
+// Ensure the broker removes truncated messages from a topic.
+func TestBroker_TruncateTopic(t *testing.T) {
+	b := NewBroker(nil)
+	defer b.Close()
+	b.CreateReplica(2000)
+	b.Subscribe(2000, 20)
+
+	index := b.MustPublishSync(&messaging.Message{Type: 100, TopicID: 20, Data: []byte("0000")})
+	b.MustPublishSync(&messaging.Message{Type: 101, TopicID: 20, Data: []byte("1111")})
+	if err := b.TruncateTopic(20, index); err != nil {
+		t.Fatal(err)
+	} else if err := b.TruncateTopic(30, index); err != messaging.ErrTopicNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Only the message after the truncation index is replayed.
+	w := &StreamWriter{}
+	go func() { b.Replica(2000).WriteTo(w) }()
+	time.Sleep(10 * time.Millisecond)
+	if a := Messages(w.Messages()).Unicasted(); len(a) != 1 || a[0].Type != 101 {
+		t.Fatalf("unexpected messages: %#v", a)
+	}
+
+	// New messages are still written to the topic.
+	b.MustPublishSync(&messaging.Message{Type: 102, TopicID: 20})
+	time.Sleep(10 * time.Millisecond)
+	if a := Messages(w.Messages()).Unicasted(); len(a) != 2 || a[1].Type != 102 {
+		t.Fatalf("unexpected messages: %#v", a)
+	}
+}
+
 // Broker is a wrapper for broker.Broker that creates the broker in a temporary location.
 type Broker struct {
 	*messaging.Broker
@@ -229,6 +332,18 @@ func (b *Broker) Close() {
 	b.Broker.Close()
 }
 
+// MustPublishSync publishes a message and waits for it to be applied.
+// Returns the message index. Panic on error.
+func (b *Broker) MustPublishSync(m *messaging.Message) uint64 {
+	index, err := b.Publish(m)
+	if err != nil {
+		panic("publish: " + err.Error())
+	} else if err := b.Sync(index); err != nil {
+		panic("sync: " + err.Error())
+	}
+	return index
+}
+
 // MustReadAll reads all available messages for a replica. Panic on error.
 func (b *Broker) MustReadAll(replicaID uint64) (a []*messaging.Message) {
 	// Read message from the replica.
@@ -294,6 +409,47 @@ func (a Messages) Unicasted() (other Messages) {
 	return
 }
 
+// StreamWriter represents a replica stream that can be disconnected.
+type StreamWriter struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+// Write appends to the stream. Returns an error if the stream is closed.
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return w.buf.Write(p)
+}
+
+// Close disconnects the stream.
+func (w *StreamWriter) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+}
+
+// Messages decodes the messages written to the stream. Panic on error.
+func (w *StreamWriter) Messages() (a []*messaging.Message) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dec := messaging.NewMessageDecoder(bytes.NewReader(w.buf.Bytes()))
+	for {
+		m := &messaging.Message{}
+		if err := dec.Decode(m); err == io.EOF {
+			break
+		} else if err != nil {
+			panic("decode: " + err.Error())
+		}
+		a = append(a, m)
+	}
+	return
+}
+
 // tempfile returns a temporary path.
 func tempfile() string {
 	f, _ := ioutil.TempFile("", "influxdb-messaging-")
@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -40,15 +41,33 @@ type Client struct {
 	replicaID uint64       // the replica that the client is connecting as.
 	config    ClientConfig // The Client state that must be persisted to disk.
 
-	opened bool
-	done   chan chan struct{} // disconnection notification
+	opened    bool
+	connected bool               // streaming from a broker
+	done      chan chan struct{} // disconnection notification
+
+	// Publish requests waiting to be batched, if batching is enabled.
+	batch     chan *publishRequest
+	batchDone chan chan struct{}
+	closing   chan struct{}
 
 	// Channel streams messages from the broker.
 	c chan *Message
 
+	// The index of the last message received from the broker stream.
+	// Sent on reconnect so the broker only resends what was missed.
+	index uint64
+
 	// The amount of time to wait before reconnecting to a broker stream.
 	ReconnectTimeout time.Duration
 
+	// The maximum amount of time a published message waits to be sent to
+	// the broker along with other messages. Batching is disabled if zero.
+	BatchFlushInterval time.Duration
+
+	// The number of bytes of message data that causes a batch to be sent
+	// immediately, before the flush interval has elapsed. Unlimited if zero.
+	MaxBatchSize int
+
 	// The logging interface used by the client for out-of-band errors.
 	Logger *log.Logger
 }
@@ -137,6 +156,14 @@ func (c *Client) Open(path string, urls []*url.URL) error {
 		go c.streamer(c.done)
 	}
 
+	// Start batching published messages if a flush interval is set.
+	c.closing = make(chan struct{})
+	if c.BatchFlushInterval > 0 {
+		c.batch = make(chan *publishRequest, 0)
+		c.batchDone = make(chan chan struct{})
+		go c.batcher(c.batch, c.batchDone)
+	}
+
 	// Set open flag.
 	c.opened = true
 
@@ -145,13 +172,27 @@ func (c *Client) Open(path string, urls []*url.URL) error {
 
 // Close disconnects the client from the broker cluster.
 func (c *Client) Close() error {
+	// Return error if the client is already closed or closing.
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	// Return error if the client is already closed.
-	if !c.opened {
+	if !c.opened || c.closing == nil {
+		c.mu.Unlock()
 		return ErrClientClosed
 	}
+	closing, batchDone := c.closing, c.batchDone
+	c.closing, c.batch, c.batchDone = nil, nil, nil
+	c.mu.Unlock()
+
+	// Flush any pending batch and shutdown the batcher. This is done without
+	// the lock since the final flush looks up the leader URL, which takes it.
+	close(closing)
+	if batchDone != nil {
+		ch := make(chan struct{})
+		batchDone <- ch
+		<-ch
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	// Shutdown streamer.
 	if c.done != nil {
@@ -172,7 +213,143 @@ func (c *Client) Close() error {
 }
 
 // Publish sends a message to the broker and returns an index or error.
+// If batching is enabled then the message is sent along with other messages
+// published within the flush interval.
 func (c *Client) Publish(m *Message) (uint64, error) {
+	c.mu.Lock()
+	batch, closing := c.batch, c.closing
+	c.mu.Unlock()
+
+	// Send the message directly if batching is disabled.
+	if batch == nil {
+		return c.publish(m)
+	}
+
+	// Otherwise hand the message to the batcher and wait for the result.
+	req := &publishRequest{m: m, ch: make(chan publishResponse, 1)}
+	select {
+	case batch <- req:
+	case <-closing:
+		return 0, ErrClientClosed
+	}
+	resp := <-req.ch
+	return resp.index, resp.err
+}
+
+// publishRequest represents a message waiting to be sent in a batch.
+type publishRequest struct {
+	m  *Message
+	ch chan publishResponse
+}
+
+// publishResponse represents the result of publishing a batched message.
+type publishResponse struct {
+	index uint64
+	err   error
+}
+
+// batcher collects published messages and sends them to the broker once the
+// flush interval has elapsed or the batch has reached its maximum size.
+func (c *Client) batcher(batch chan *publishRequest, done chan chan struct{}) {
+	var reqs []*publishRequest
+	var size int
+	var timeout <-chan time.Time
+
+	for {
+		select {
+		case ch := <-done:
+			c.flush(reqs)
+			close(ch)
+			return
+
+		case req := <-batch:
+			// Start the flush timer on the first message in the batch.
+			if len(reqs) == 0 {
+				timeout = time.After(c.BatchFlushInterval)
+			}
+			reqs = append(reqs, req)
+			size += len(req.m.Data)
+
+			// Send immediately if the batch is too large.
+			if c.MaxBatchSize > 0 && size >= c.MaxBatchSize {
+				c.flush(reqs)
+				reqs, size, timeout = nil, 0, nil
+			}
+
+		case <-timeout:
+			c.flush(reqs)
+			reqs, size, timeout = nil, 0, nil
+		}
+	}
+}
+
+// flush sends a batch of messages to the broker and notifies each publisher.
+func (c *Client) flush(reqs []*publishRequest) {
+	if len(reqs) == 0 {
+		return
+	}
+
+	// Send all messages in a single request.
+	a := make([]*Message, len(reqs))
+	for i, req := range reqs {
+		a[i] = req.m
+	}
+	indices, err := c.publishBatch(a)
+
+	// Messages that were published receive their index.
+	// All remaining messages receive the error.
+	for i, req := range reqs {
+		if i < len(indices) {
+			req.ch <- publishResponse{index: indices[i]}
+		} else {
+			req.ch <- publishResponse{err: err}
+		}
+	}
+}
+
+// publishBatch sends multiple messages to the broker in a single request.
+// Returns the index of each message published before an error occurred.
+func (c *Client) publishBatch(a []*Message) ([]uint64, error) {
+	// Encode all messages into the request body.
+	var buf bytes.Buffer
+	for _, m := range a {
+		_, _ = m.WriteTo(&buf)
+	}
+
+	// Send the messages to the messages endpoint.
+	u := *c.LeaderURL()
+	u.Path = "/messaging/messages"
+	u.RawQuery = url.Values{"batch": {"true"}}.Encode()
+	resp, err := http.Post(u.String(), "application/octet-stream", &buf)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// Parse the indices of the messages that were published.
+	var indices []uint64
+	if s := resp.Header.Get("X-Broker-Indices"); s != "" {
+		for _, s := range strings.Split(s, ",") {
+			index, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return indices, fmt.Errorf("invalid index: %s", err)
+			}
+			indices = append(indices, index)
+		}
+	}
+
+	// If a non-200 status is returned then an error occurred.
+	if resp.StatusCode != http.StatusOK {
+		return indices, errors.New(resp.Header.Get("X-Broker-Error"))
+	} else if len(indices) != len(a) {
+		return indices, fmt.Errorf("index count mismatch: %d != %d", len(indices), len(a))
+	}
+
+	return indices, nil
+}
+
+// publish sends a single message to the broker and returns an index or error.
+func (c *Client) publish(m *Message) (uint64, error) {
 	// Send the message to the messages endpoint.
 	u := *c.LeaderURL()
 	u.Path = "/messaging/messages"
@@ -213,7 +390,9 @@ func (c *Client) CreateReplica(id uint64) error {
 	defer func() { _ = resp.Body.Close() }()
 
 	// If a non-201 status is returned then an error occurred.
-	if resp.StatusCode != http.StatusCreated {
+	if resp.StatusCode == http.StatusConflict {
+		return ErrReplicaExists
+	} else if resp.StatusCode != http.StatusCreated {
 		return errors.New(resp.Header.Get("X-Broker-Error"))
 	}
 
@@ -288,6 +467,29 @@ func (c *Client) Unsubscribe(replicaID, topicID uint64) error {
 	return nil
 }
 
+// TruncateTopic removes the messages at or before index from a topic on the broker.
+func (c *Client) TruncateTopic(topicID, index uint64) error {
+	// Send request to the last known leader.
+	u := *c.LeaderURL()
+	u.Path = "/messaging/truncations"
+	u.RawQuery = url.Values{
+		"topicID": {strconv.FormatUint(topicID, 10)},
+		"index":   {strconv.FormatUint(index, 10)},
+	}.Encode()
+	resp, err := http.Post(u.String(), "application/octet-stream", nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// If a non-204 status is returned then an error occurred.
+	if resp.StatusCode != http.StatusNoContent {
+		return errors.New(resp.Header.Get("X-Broker-Error"))
+	}
+
+	return nil
+}
+
 // streamer connects to a broker server and streams the replica's messages.
 func (c *Client) streamer(done chan chan struct{}) {
 	for {
@@ -315,10 +517,29 @@ func (c *Client) streamer(done chan chan struct{}) {
 	}
 }
 
+// Connected returns true if the client is streaming messages from a broker.
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+func (c *Client) setConnected(v bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = v
+}
+
 // streamFromURL connects to a broker server and streams the replica's messages.
 func (c *Client) streamFromURL(u *url.URL, done chan chan struct{}) error {
-	// Set the replica id on the URL and open the stream.
-	u.RawQuery = url.Values{"replicaID": {strconv.FormatUint(c.replicaID, 10)}}.Encode()
+	// Set the replica id and last received index on the URL and open the stream.
+	c.mu.Lock()
+	index := c.index
+	c.mu.Unlock()
+	u.RawQuery = url.Values{
+		"replicaID": {strconv.FormatUint(c.replicaID, 10)},
+		"index":     {strconv.FormatUint(index, 10)},
+	}.Encode()
 	resp, err := http.Get(u.String())
 	if err != nil {
 		time.Sleep(c.ReconnectTimeout)
@@ -332,6 +553,10 @@ func (c *Client) streamFromURL(u *url.URL, done chan chan struct{}) error {
 		return nil
 	}
 
+	// Mark the client as connected until the stream ends.
+	c.setConnected(true)
+	defer c.setConnected(false)
+
 	// Continuously decode messages from request body in a separate goroutine.
 	errNotify := make(chan error, 0)
 	go func() {
@@ -348,6 +573,10 @@ func (c *Client) streamFromURL(u *url.URL, done chan chan struct{}) error {
 
 			// Write message to streaming channel.
 			c.c <- m
+
+			c.mu.Lock()
+			c.index = m.Index
+			c.mu.Unlock()
 		}
 	}()
 
@@ -111,6 +111,92 @@ func TestClient_Publish(t *testing.T) {
 	}
 }
 
+// Ensure that a client can batch published messages to the broker.
+func TestClient_Publish_Batch(t *testing.T) {
+	c := NewClient(1000)
+	c.BatchFlushInterval = 10 * time.Millisecond
+	c.Server.Handler.Broker().CreateReplica(1000)
+	defer c.Close()
+
+	// Open client to broker.
+	c.clientConfig = NewTempFile()
+	u, _ := url.Parse(c.Server.URL)
+	if err := c.Open(c.clientConfig, []*url.URL{u}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Publish messages concurrently so they are sent in a single batch.
+	ch := make(chan uint64, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			index, err := c.Publish(&messaging.Message{Type: 100, TopicID: messaging.BroadcastTopicID, Data: []byte{0}})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			ch <- index
+		}()
+	}
+
+	// Verify each message received its own index.
+	a, b := <-ch, <-ch
+	if a == 0 || b == 0 || a == b {
+		t.Fatalf("unexpected indices: %d, %d", a, b)
+	}
+
+	// Verify both messages were published at the returned indices.
+	indices := make(map[uint64]bool)
+	for len(indices) < 2 {
+		select {
+		case m := <-c.C():
+			if m.Type == 100 {
+				indices[m.Index] = true
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatal("timed out waiting for published messages")
+		}
+	}
+	if !indices[a] || !indices[b] {
+		t.Fatalf("unexpected published indices: %v", indices)
+	}
+}
+
+// Ensure that closing a client sends a pending batch instead of blocking.
+func TestClient_Close_PendingBatch(t *testing.T) {
+	c := NewClient(1000)
+	c.BatchFlushInterval = 1 * time.Hour
+	c.Server.Handler.Broker().CreateReplica(1000)
+	defer c.Server.Close()
+
+	c.clientConfig = NewTempFile()
+	defer os.Remove(c.clientConfig)
+	u, _ := url.Parse(c.Server.URL)
+	if err := c.Open(c.clientConfig, []*url.URL{u}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Publish a message that waits in the batch until the client closes.
+	ch := make(chan error, 1)
+	go func() {
+		_, err := c.Publish(&messaging.Message{Type: 100, TopicID: messaging.BroadcastTopicID, Data: []byte{0}})
+		ch <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- c.Client.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("unexpected close error: %s", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("close blocked on pending batch")
+	}
+	if err := <-ch; err != nil {
+		t.Fatalf("unexpected publish error: %s", err)
+	}
+}
+
 // Ensure that a client receives an error when publishing to a stopped server.
 func TestClient_Publish_ErrConnectionRefused(t *testing.T) {
 	c := OpenClient(1000)
@@ -156,7 +242,7 @@ func TestClient_CreateReplica_Err(t *testing.T) {
 	c := OpenClient(0)
 	defer c.Close()
 	c.Server.Handler.Broker().CreateReplica(123)
-	if err := c.CreateReplica(123); err == nil || err.Error() != `replica already exists` {
+	if err := c.CreateReplica(123); err != messaging.ErrReplicaExists {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
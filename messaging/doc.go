@@ -10,5 +10,9 @@ placed in a single "config" topic that is replicated to all data nodes. Each
 shard's data is placed in its own topic so that it can be parallized across the
 cluster.
 
+Single-node deployments that don't need replication can use a LocalClient in
+place of a broker and Client. It delivers published messages straight back to
+the data node in order.
+
 */
 package messaging
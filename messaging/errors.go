@@ -12,12 +12,19 @@ var (
 	// ErrClosed is returned when closing a broker that's already closed.
 	ErrClosed = errors.New("broker already closed")
 
+	// ErrNoLeader is returned by readiness checks when the broker's raft log
+	// has no leader.
+	ErrNoLeader = errors.New("no leader")
+
 	// ErrSubscribed is returned when a stream is already subscribed to a topic.
 	ErrSubscribed = errors.New("already subscribed")
 
 	// ErrTopicExists is returned when creating a duplicate topic.
 	ErrTopicExists = errors.New("topic already exists")
 
+	// ErrTopicNotFound is returned when truncating a topic that doesn't exist.
+	ErrTopicNotFound = errors.New("topic not found")
+
 	// ErrReplicaExists is returned when creating a duplicate replica.
 	ErrReplicaExists = errors.New("replica already exists")
 
@@ -27,6 +34,9 @@ var (
 	// ErrReplicaIDRequired is returned when creating a replica without an id.
 	ErrReplicaIDRequired = errors.New("replica id required")
 
+	// ErrInvalidIndex is returned when streaming with a malformed index.
+	ErrInvalidIndex = errors.New("invalid index")
+
 	// errReplicaUnavailable is returned when writing bytes to a replica when
 	// there is no writer attached to the replica.
 	errReplicaUnavailable = errors.New("replica unavailable")
@@ -1,6 +1,7 @@
 package messaging
 
 import (
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -70,6 +71,18 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		} else {
 			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		}
+	case "/messaging/health":
+		// The broker is live as long as it can serve requests.
+	case "/messaging/ready":
+		if err := h.broker.Ready(); err != nil {
+			h.error(w, err, http.StatusServiceUnavailable)
+		}
+	case "/messaging/truncations":
+		if r.Method == "POST" {
+			h.truncateTopic(w, r)
+		} else {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
 	default:
 		http.NotFound(w, r)
 	}
@@ -93,13 +106,30 @@ func (h *Handler) stream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Read the index of the last message the replica received, if any.
+	var index uint64
+	if s := r.URL.Query().Get("index"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			h.error(w, ErrInvalidIndex, http.StatusBadRequest)
+			return
+		}
+		index = n
+	}
+
 	// Connect the response writer to the replica.
 	// This will block until the replica is closed or a new writer connects.
-	_, _ = replica.WriteTo(w)
+	_, _ = replica.Resume(w, index)
 }
 
 // publishes a message to the broker.
 func (h *Handler) publish(w http.ResponseWriter, r *http.Request) {
+	// Publish multiple encoded messages, if requested.
+	if r.URL.Query().Get("batch") == "true" {
+		h.publishBatch(w, r)
+		return
+	}
+
 	m := &Message{}
 
 	// Read the message type.
@@ -137,6 +167,36 @@ func (h *Handler) publish(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("X-Broker-Index", strconv.FormatUint(index, 10))
 }
 
+// publishes a batch of encoded messages to the broker.
+// The indices of all published messages are returned, even if an error occurs.
+func (h *Handler) publishBatch(w http.ResponseWriter, r *http.Request) {
+	var indices []string
+	dec := NewMessageDecoder(r.Body)
+	for {
+		// Decode the next message from the request body.
+		m := &Message{}
+		if err := dec.Decode(m); err == io.EOF {
+			break
+		} else if err != nil {
+			w.Header().Set("X-Broker-Indices", strings.Join(indices, ","))
+			h.error(w, err, http.StatusBadRequest)
+			return
+		}
+
+		// Publish message to the broker.
+		index, err := h.broker.Publish(m)
+		if err != nil {
+			w.Header().Set("X-Broker-Indices", strings.Join(indices, ","))
+			h.error(w, err, http.StatusInternalServerError)
+			return
+		}
+		indices = append(indices, strconv.FormatUint(index, 10))
+	}
+
+	// Return indices.
+	w.Header().Set("X-Broker-Indices", strings.Join(indices, ","))
+}
+
 // createReplica creates a new replica with a given ID.
 func (h *Handler) createReplica(w http.ResponseWriter, r *http.Request) {
 	// Read the replica ID.
@@ -240,6 +300,33 @@ func (h *Handler) unsubscribe(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// truncateTopic removes the messages at or before an index from a topic.
+func (h *Handler) truncateTopic(w http.ResponseWriter, r *http.Request) {
+	// Read the topic ID.
+	topicID, err := strconv.ParseUint(r.URL.Query().Get("topicID"), 10, 64)
+	if err != nil {
+		h.error(w, ErrTopicRequired, http.StatusBadRequest)
+		return
+	}
+
+	// Read the index of the last message to remove.
+	index, err := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	if err != nil {
+		h.error(w, ErrInvalidIndex, http.StatusBadRequest)
+		return
+	}
+
+	// Truncate the topic.
+	if err := h.broker.TruncateTopic(topicID, index); err == ErrTopicNotFound {
+		h.error(w, err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.error(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // error writes an error to the client and sets the status code.
 func (h *Handler) error(w http.ResponseWriter, err error, code int) {
 	s := err.Error()
@@ -1,6 +1,7 @@
 package messaging_test
 
 import (
+	"bytes"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -64,6 +65,22 @@ func TestHandler_stream_ErrReplicaNotFound(t *testing.T) {
 	}
 }
 
+// Ensure an error is returned when requesting a stream with a malformed index.
+func TestHandler_stream_ErrInvalidIndex(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Handler.Broker().CreateReplica(2000)
+
+	resp, err := http.Get(s.URL + `/messaging/messages?replicaID=2000&index=foo`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if msg := resp.Header.Get("X-Broker-Error"); resp.StatusCode != http.StatusBadRequest || msg != "invalid index" {
+		t.Fatalf("unexpected status/error: %d/%s", resp.StatusCode, msg)
+	}
+}
+
 // Ensure an error is returned when requesting a stream with the wrong HTTP method.
 func TestHandler_stream_ErrMethodNotAllowed(t *testing.T) {
 	s := NewServer()
@@ -115,6 +132,29 @@ func TestHandler_publish(t *testing.T) {
 	}
 }
 
+// Ensure a handler can publish a batch of messages and return their indices.
+func TestHandler_publish_Batch(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	// Encode two messages into the request body.
+	var buf bytes.Buffer
+	(&messaging.Message{Type: 100, TopicID: 200, Data: []byte("abc")}).WriteTo(&buf)
+	(&messaging.Message{Type: 100, TopicID: 200, Data: []byte("def")}).WriteTo(&buf)
+
+	// Send request to the broker.
+	resp, err := http.Post(s.URL+`/messaging/messages?batch=true`, "application/octet-stream", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", resp.StatusCode, resp.Header.Get("X-Broker-Error"))
+	} else if s := resp.Header.Get("X-Broker-Indices"); s != "2,3" {
+		t.Fatalf("unexpected indices: %s", s)
+	}
+}
+
 // Ensure a handler returns an error when publishing a message without a type.
 func TestHandler_publish_ErrMessageTypeRequired(t *testing.T) {
 	s := NewServer()
@@ -161,6 +201,36 @@ func TestHandler_publish_ErrClosed(t *testing.T) {
 	}
 }
 
+// Ensure the handler reports liveness and readiness separately.
+func TestHandler_ready(t *testing.T) {
+	s := NewUninitializedServer()
+	defer s.Close()
+
+	// An uninitialized broker is live but has no leader.
+	resp, _ := http.Get(s.URL + `/messaging/health`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected health status: %d", resp.StatusCode)
+	}
+	resp, _ = http.Get(s.URL + `/messaging/ready`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected ready status: %d", resp.StatusCode)
+	} else if resp.Header.Get("X-Broker-Error") != "no leader" {
+		t.Fatalf("unexpected error: %s", resp.Header.Get("X-Broker-Error"))
+	}
+
+	// The broker is ready once it has been initialized.
+	if err := s.Handler.Broker().Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ = http.Get(s.URL + `/messaging/ready`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected ready status: %d: %s", resp.StatusCode, resp.Header.Get("X-Broker-Error"))
+	}
+}
+
 // Ensure the handler routes raft requests to the raft handler.
 func TestHandler_raft(t *testing.T) {
 	s := NewServer()
@@ -0,0 +1,124 @@
+package messaging
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultMaxHintSize is the default number of bytes of messages held for
+	// a disconnected replica.
+	DefaultMaxHintSize = 1 << 30 // 1GB
+
+	// DefaultMaxHintAge is the default amount of time messages are held for
+	// a disconnected replica.
+	DefaultMaxHintAge = 7 * 24 * time.Hour
+)
+
+// hintQueue persists the messages that could not be delivered to a replica
+// after its stream disconnected. When the replica reconnects the queue is
+// handed off instead of replaying every subscribed topic from the start.
+//
+// Once the queue exceeds its size or age limit the hints are discarded and
+// the replica is caught up from the topic logs instead.
+type hintQueue struct {
+	path    string
+	file    *os.File
+	size    int64     // bytes queued
+	created time.Time // time the replica disconnected
+	dropped bool      // true if the queue exceeded its limits
+}
+
+// newHintQueue returns a hint queue stored at path.
+func newHintQueue(path string) *hintQueue {
+	return &hintQueue{path: path, created: time.Now()}
+}
+
+// append adds an encoded message to the end of the queue. The queue is
+// dropped if the message would exceed maxSize or the queue is older than maxAge.
+func (q *hintQueue) append(b []byte, maxSize int64, maxAge time.Duration) error {
+	if q.dropped {
+		return nil
+	} else if q.size+int64(len(b)) > maxSize || time.Since(q.created) > maxAge {
+		q.drop()
+		return nil
+	}
+
+	// Open the queue file on the first hint.
+	if q.file == nil {
+		if err := os.MkdirAll(filepath.Dir(q.path), 0700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(q.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		q.file = f
+	}
+
+	if _, err := q.file.Write(b); err != nil {
+		q.drop()
+		return err
+	}
+	q.size += int64(len(b))
+	return nil
+}
+
+// valid returns true if the queue holds every message since the replica
+// disconnected and has not exceeded maxAge.
+func (q *hintQueue) valid(maxAge time.Duration) bool {
+	return !q.dropped && time.Since(q.created) <= maxAge
+}
+
+// writeTo writes the queued messages for the replica's current topics.
+func (q *hintQueue) writeTo(r *Replica) (int64, error) {
+	if q.file == nil {
+		return 0, nil
+	} else if _, err := q.file.Seek(0, os.SEEK_SET); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	dec := NewMessageDecoder(bufio.NewReader(q.file))
+	for {
+		var m Message
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return total, fmt.Errorf("decode: %s", err)
+		}
+
+		// Skip topics the replica unsubscribed from while disconnected.
+		if _, ok := r.topics[m.TopicID]; !ok {
+			continue
+		}
+
+		n, err := m.WriteTo(r)
+		if err != nil {
+			return total, fmt.Errorf("write to: %s", err)
+		}
+		total += n
+		r.index = m.Index
+	}
+	return total, nil
+}
+
+// drop discards the queued messages and stops queueing new ones.
+func (q *hintQueue) drop() {
+	q.close()
+	q.dropped = true
+}
+
+// close closes and removes the queue file.
+func (q *hintQueue) close() {
+	if q.file != nil {
+		_ = q.file.Close()
+		_ = os.Remove(q.path)
+		q.file = nil
+	}
+	q.size = 0
+}
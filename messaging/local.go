@@ -0,0 +1,101 @@
+package messaging
+
+import "sync"
+
+// DefaultLocalClientBufferSize is the number of published messages a
+// LocalClient holds before Publish blocks on the reader.
+const DefaultLocalClientBufferSize = 1000
+
+// LocalClient is an embedded, single-node replacement for a broker and Client.
+// Published messages are assigned an autoincrementing index and streamed back
+// in order without going through raft or HTTP.
+//
+// Every topic is delivered to the client so replica and subscription
+// management is a no-op. Messages live in memory until they are read so a
+// LocalClient is only suitable when its reader applies messages durably.
+type LocalClient struct {
+	mu    sync.Mutex
+	index uint64
+	c     chan *Message
+
+	closeMu sync.Mutex
+	closing chan struct{}
+}
+
+// NewLocalClient returns a new instance of LocalClient.
+func NewLocalClient() *LocalClient {
+	return &LocalClient{
+		c:       make(chan *Message, DefaultLocalClientBufferSize),
+		closing: make(chan struct{}),
+	}
+}
+
+// Close stops the client and closes its message stream.
+func (c *LocalClient) Close() error {
+	c.closeMu.Lock()
+	select {
+	case <-c.closing:
+		c.closeMu.Unlock()
+		return ErrClientClosed
+	default:
+		close(c.closing)
+	}
+	c.closeMu.Unlock()
+
+	// Wait for in-flight publishes to return before closing the stream.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	close(c.c)
+	return nil
+}
+
+// SetIndex sets the index of the last published message. When a server is
+// restarted this must be at least the index it has already applied so that
+// new messages are not mistaken for ones being replayed.
+func (c *LocalClient) SetIndex(index uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index = index
+}
+
+// Publish assigns the next index to a message and queues it on the stream.
+// Blocks while the stream is full.
+func (c *LocalClient) Publish(m *Message) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Don't assign an index once the client starts closing.
+	select {
+	case <-c.closing:
+		return 0, ErrClientClosed
+	default:
+	}
+
+	c.index++
+	m.Index = c.index
+
+	select {
+	case c.c <- m:
+		return m.Index, nil
+	case <-c.closing:
+		return 0, ErrClientClosed
+	}
+}
+
+// CreateReplica is a no-op. The client is the only replica.
+func (c *LocalClient) CreateReplica(replicaID uint64) error { return nil }
+
+// DeleteReplica is a no-op. The client is the only replica.
+func (c *LocalClient) DeleteReplica(replicaID uint64) error { return nil }
+
+// Subscribe is a no-op. All topics are delivered to the client.
+func (c *LocalClient) Subscribe(replicaID, topicID uint64) error { return nil }
+
+// Unsubscribe is a no-op. All topics are delivered to the client.
+func (c *LocalClient) Unsubscribe(replicaID, topicID uint64) error { return nil }
+
+// TruncateTopic is a no-op. Messages are not retained after delivery.
+func (c *LocalClient) TruncateTopic(topicID, index uint64) error { return nil }
+
+// C returns the stream of published messages.
+func (c *LocalClient) C() <-chan *Message { return c.c }
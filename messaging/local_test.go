@@ -0,0 +1,66 @@
+package messaging_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// Ensure a local client streams published messages back in order.
+func TestLocalClient_Publish(t *testing.T) {
+	c := messaging.NewLocalClient()
+	defer c.Close()
+
+	for i, topicID := range []uint64{messaging.BroadcastTopicID, 2, 3} {
+		if index, err := c.Publish(&messaging.Message{Type: 1, TopicID: topicID, Data: []byte{byte(i)}}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		} else if index != uint64(i+1) {
+			t.Fatalf("unexpected index: %d", index)
+		}
+	}
+
+	// All topics are delivered, regardless of subscriptions.
+	for i := 0; i < 3; i++ {
+		if m := <-c.C(); m.Index != uint64(i+1) || !reflect.DeepEqual(m.Data, []byte{byte(i)}) {
+			t.Fatalf("unexpected message(%d): %#v", i, m)
+		}
+	}
+}
+
+// Ensure a closed local client rejects publishes and closes its stream.
+func TestLocalClient_Close(t *testing.T) {
+	c := messaging.NewLocalClient()
+	if err := c.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := c.Close(); err != messaging.ErrClientClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := c.Publish(&messaging.Message{Type: 1}); err != messaging.ErrClientClosed {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, ok := <-c.C(); ok {
+		t.Fatal("expected closed stream")
+	}
+}
+
+// Ensure closing a local client unblocks a publish waiting on a full stream.
+func TestLocalClient_Close_BlockedPublish(t *testing.T) {
+	c := messaging.NewLocalClient()
+	for i := 0; i < messaging.DefaultLocalClientBufferSize; i++ {
+		if _, err := c.Publish(&messaging.Message{Type: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errs := make(chan error)
+	go func() {
+		_, err := c.Publish(&messaging.Message{Type: 1})
+		errs <- err
+	}()
+
+	c.Close()
+	if err := <-errs; err != messaging.ErrClientClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
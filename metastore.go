@@ -1,7 +1,12 @@
 package influxdb
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"encoding/binary"
+	"io"
 	"time"
 	"unsafe"
 
@@ -11,6 +16,47 @@ import (
 // metastore represents the low-level data store for metadata.
 type metastore struct {
 	db *bolt.DB
+
+	// Optional encryption of values at rest. New values are sealed with key.
+	// Previous keys are only used to decrypt values during key rotation.
+	key          cipher.AEAD
+	previousKeys []cipher.AEAD
+}
+
+// encryptedValuePrefix marks a value as sealed with AES-GCM. Plaintext values
+// are always JSON so they can never begin with this byte.
+const encryptedValuePrefix = 0xFF
+
+// setKeys sets the encryption key and any previous keys for the metastore.
+// A nil key disables encryption of new values. Must be called before open.
+func (m *metastore) setKeys(key []byte, previousKeys [][]byte) error {
+	m.key, m.previousKeys = nil, nil
+
+	if key != nil {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return err
+		}
+		m.key = aead
+	}
+
+	for _, k := range previousKeys {
+		aead, err := newAEAD(k)
+		if err != nil {
+			return err
+		}
+		m.previousKeys = append(m.previousKeys, aead)
+	}
+	return nil
+}
+
+// newAEAD returns an AES-GCM cipher for a 16, 24 or 32 byte key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrInvalidMetastoreKey
+	}
+	return cipher.NewGCM(block)
 }
 
 // open initializes the metastore.
@@ -27,6 +73,12 @@ func (m *metastore) open(path string) error {
 		return err
 	}
 
+	// Ensure all values can be read and are sealed with the current key.
+	if err := m.rekey(); err != nil {
+		_ = m.db.Close()
+		return err
+	}
+
 	return nil
 }
 
@@ -45,18 +97,123 @@ func (m *metastore) init() error {
 		_, _ = tx.CreateBucketIfNotExists([]byte("DataNodes"))
 		_, _ = tx.CreateBucketIfNotExists([]byte("Databases"))
 		_, _ = tx.CreateBucketIfNotExists([]byte("Users"))
+		_, _ = tx.CreateBucketIfNotExists([]byte("ContinuousQueries"))
+		_, _ = tx.CreateBucketIfNotExists([]byte("ContinuousQueryLeases"))
+		_, _ = tx.CreateBucketIfNotExists([]byte("SystemLeases"))
+		_, _ = tx.CreateBucketIfNotExists([]byte("WriteBlocks"))
+		_, _ = tx.CreateBucketIfNotExists([]byte("Tokens"))
 		return nil
 	})
 }
 
+// rekey rewrites every value that is not sealed with the current key.
+// This encrypts plaintext values when a key is first configured, re-encrypts
+// values sealed with a previous key and decrypts values if the key is removed.
+// Returns ErrMetastoreDecrypt if a value cannot be opened with any known key.
+func (m *metastore) rekey() error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			// Server id and sequences are not sensitive.
+			if string(name) == "Meta" {
+				return nil
+			}
+			return m.rekeyBucket(b)
+		})
+	})
+}
+
+// rekeyBucket rewrites the values in a bucket and all nested buckets.
+func (m *metastore) rekeyBucket(b *bolt.Bucket) error {
+	var keys, values [][]byte
+	if err := b.ForEach(func(k, v []byte) error {
+		// Recurse into nested buckets.
+		if v == nil {
+			return m.rekeyBucket(b.Bucket(k))
+		}
+
+		// Skip values that are already sealed with the current key.
+		if m.key != nil && len(v) > 0 && v[0] == encryptedValuePrefix {
+			if _, err := m.unseal(m.key, v); err == nil {
+				return nil
+			}
+		} else if m.key == nil && (len(v) == 0 || v[0] != encryptedValuePrefix) {
+			return nil
+		}
+
+		plaintext, err := m.decrypt(v)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, append([]byte{}, k...))
+		values = append(values, m.encrypt(plaintext))
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Write values back once iteration is complete.
+	for i := range keys {
+		if err := b.Put(keys[i], values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encrypt seals a value with the current key.
+// The value is returned unchanged if encryption is disabled.
+func (m *metastore) encrypt(v []byte) []byte {
+	if m.key == nil {
+		return v
+	}
+
+	nonce := make([]byte, m.key.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic("metastore nonce: " + err.Error())
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(encryptedValuePrefix)
+	buf.Write(nonce)
+	buf.Write(m.key.Seal(nil, nonce, v, nil))
+	return buf.Bytes()
+}
+
+// decrypt opens a sealed value with the current key or any previous key.
+// Plaintext values are returned unchanged.
+func (m *metastore) decrypt(v []byte) ([]byte, error) {
+	if len(v) == 0 || v[0] != encryptedValuePrefix {
+		return v, nil
+	}
+
+	for _, aead := range append([]cipher.AEAD{m.key}, m.previousKeys...) {
+		if aead == nil {
+			continue
+		}
+		if plaintext, err := m.unseal(aead, v); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, ErrMetastoreDecrypt
+}
+
+// unseal opens a sealed value with a single key.
+func (m *metastore) unseal(aead cipher.AEAD, v []byte) ([]byte, error) {
+	v = v[1:]
+	if len(v) < aead.NonceSize() {
+		return nil, ErrMetastoreDecrypt
+	}
+	return aead.Open(nil, v[:aead.NonceSize()], v[aead.NonceSize():], nil)
+}
+
 // view executes a function in the context of a read-only transaction.
 func (m *metastore) view(fn func(*metatx) error) error {
-	return m.db.View(func(tx *bolt.Tx) error { return fn(&metatx{tx}) })
+	return m.db.View(func(tx *bolt.Tx) error { return fn(&metatx{tx, m}) })
 }
 
 // update executes a function in the context of a read-write transaction.
 func (m *metastore) update(fn func(*metatx) error) error {
-	return m.db.Update(func(tx *bolt.Tx) error { return fn(&metatx{tx}) })
+	return m.db.Update(func(tx *bolt.Tx) error { return fn(&metatx{tx, m}) })
 }
 
 // mustView executes a function in the context of a read-only transaction.
@@ -86,6 +243,22 @@ func (m *metastore) mustUpdate(fn func(*metatx) error) (err error) {
 // metatx represents a metastore transaction.
 type metatx struct {
 	*bolt.Tx
+	meta *metastore
+}
+
+// marshal encodes a value as JSON and encrypts it with the metastore key.
+func (tx *metatx) marshal(v interface{}) []byte {
+	return tx.meta.encrypt(mustMarshalJSON(v))
+}
+
+// unmarshal decrypts a value and decodes it from JSON.
+// Panics if the value cannot be decrypted since all values are verified on open.
+func (tx *metatx) unmarshal(b []byte, v interface{}) {
+	b, err := tx.meta.decrypt(b)
+	if err != nil {
+		panic("metastore decrypt: " + err.Error())
+	}
+	mustUnmarshalJSON(b, v)
 }
 
 // id returns the server id.
@@ -101,6 +274,19 @@ func (tx *metatx) setID(v uint64) error {
 	return tx.Bucket([]byte("Meta")).Put([]byte("id"), u64tob(v))
 }
 
+// index returns the index of the last broadcast message applied.
+func (tx *metatx) index() (index uint64) {
+	if v := tx.Bucket([]byte("Meta")).Get([]byte("index")); v != nil {
+		index = btou64(v)
+	}
+	return
+}
+
+// setIndex sets the index of the last broadcast message applied.
+func (tx *metatx) setIndex(v uint64) error {
+	return tx.Bucket([]byte("Meta")).Put([]byte("index"), u64tob(v))
+}
+
 // mustNextSequence generates a new sequence for a key in the meta bucket.
 func (tx *metatx) mustNextSequence(key []byte) (id uint64) {
 	// Retrieve the previous value, if it exists.
@@ -132,7 +318,7 @@ func (tx *metatx) dataNodes() (a []*DataNode) {
 	c := tx.Bucket([]byte("DataNodes")).Cursor()
 	for k, v := c.First(); k != nil; k, v = c.Next() {
 		n := newDataNode()
-		mustUnmarshalJSON(v, &n)
+		tx.unmarshal(v, &n)
 		a = append(a, n)
 	}
 	return
@@ -146,7 +332,7 @@ func (tx *metatx) nextDataNodeID() uint64 {
 
 // saveDataNode persists a data node to the metastore.
 func (tx *metatx) saveDataNode(n *DataNode) error {
-	return tx.Bucket([]byte("DataNodes")).Put(u64tob(n.ID), mustMarshalJSON(n))
+	return tx.Bucket([]byte("DataNodes")).Put(u64tob(n.ID), tx.marshal(n))
 }
 
 // deleteDataNode removes data node from the metastore.
@@ -161,7 +347,7 @@ func (tx *metatx) databases() (a []*database) {
 		b := c.Bucket().Bucket(k)
 		v := b.Get([]byte("meta"))
 		db := newDatabase()
-		mustUnmarshalJSON(v, &db)
+		tx.unmarshal(v, &db)
 		a = append(a, db)
 	}
 	return
@@ -181,7 +367,7 @@ func (tx *metatx) saveDatabase(db *database) error {
 	if err != nil {
 		return err
 	}
-	return b.Put([]byte("meta"), mustMarshalJSON(db))
+	return b.Put([]byte("meta"), tx.marshal(db))
 }
 
 // deleteDatabase removes database from the metastore.
@@ -209,15 +395,72 @@ func (tx *metatx) createSeries(database, name string, tags map[string]string) (*
 	}
 
 	s := &Series{ID: uint32(id), Tags: tags}
-	idBytes := make([]byte, 4)
-	*(*uint32)(unsafe.Pointer(&idBytes[0])) = uint32(id)
-
-	if err := b.Put(idBytes, mustMarshalJSON(s)); err != nil {
+	if err := b.Put(seriesKey(s.ID), tx.marshal(s)); err != nil {
 		return nil, err
 	}
 	return s, nil
 }
 
+// deleteSeries removes a series from the metastore.
+func (tx *metatx) deleteSeries(database, name string, id uint32) error {
+	b := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Series")).Bucket([]byte(name))
+	if b == nil {
+		return nil
+	}
+	return b.Delete(seriesKey(id))
+}
+
+// seriesKey returns the metastore key for a series id.
+func seriesKey(id uint32) []byte {
+	b := make([]byte, 4)
+	*(*uint32)(unsafe.Pointer(&b[0])) = id
+	return b
+}
+
+// saveEvent persists an event to a database in the metastore.
+// Events are keyed by start time so that time ranges can be scanned in order.
+func (tx *metatx) saveEvent(database string, e *Event) error {
+	b, err := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).CreateBucketIfNotExists([]byte("Events"))
+	if err != nil {
+		return err
+	}
+	key := append(eventTimeKey(e.StartTime), u64tob(e.ID)...)
+	return b.Put(key, tx.marshal(e))
+}
+
+// eventTimeKey returns the key prefix for an event's start time. The sign bit
+// is flipped so that times before 1970 sort before later times.
+func eventTimeKey(t time.Time) []byte {
+	return u64tob(uint64(t.UnixNano()) ^ (1 << 63))
+}
+
+// events returns the events in a database that overlap a time range.
+// A zero min or max leaves that side of the range unbounded.
+func (tx *metatx) events(database string, min, max time.Time) (a []*Event) {
+	b := tx.Bucket([]byte("Databases")).Bucket([]byte(database))
+	if b == nil {
+		return nil
+	} else if b = b.Bucket([]byte("Events")); b == nil {
+		return nil
+	}
+
+	// Iterate until events start after the end of the range.
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if !max.IsZero() && bytes.Compare(k[0:8], eventTimeKey(max)) > 0 {
+			break
+		}
+
+		e := &Event{}
+		tx.unmarshal(v, &e)
+		if !min.IsZero() && e.EndTime.Before(min) {
+			continue
+		}
+		a = append(a, e)
+	}
+	return
+}
+
 // loops through all the measurements and series in a database
 func (tx *metatx) indexDatabase(db *database) {
 	// get the bucket that holds series data for the database
@@ -229,7 +472,7 @@ func (tx *metatx) indexDatabase(db *database) {
 		name := string(k)
 		for id, v := mc.First(); id != nil; id, v = mc.Next() {
 			var s *Series
-			mustUnmarshalJSON(v, &s)
+			tx.unmarshal(v, &s)
 			db.addSeriesToIndex(name, s)
 		}
 	}
@@ -238,7 +481,7 @@ func (tx *metatx) indexDatabase(db *database) {
 // user returns a user from the metastore by name.
 func (tx *metatx) user(name string) (u *User) {
 	if v := tx.Bucket([]byte("Users")).Get([]byte(name)); v != nil {
-		mustUnmarshalJSON(v, &u)
+		tx.unmarshal(v, &u)
 	}
 	return
 }
@@ -248,7 +491,7 @@ func (tx *metatx) users() (a []*User) {
 	c := tx.Bucket([]byte("Users")).Cursor()
 	for k, v := c.First(); k != nil; k, v = c.Next() {
 		u := &User{}
-		mustUnmarshalJSON(v, &u)
+		tx.unmarshal(v, &u)
 		a = append(a, u)
 	}
 	return
@@ -256,7 +499,7 @@ func (tx *metatx) users() (a []*User) {
 
 // saveUser persists a user to the metastore.
 func (tx *metatx) saveUser(u *User) error {
-	return tx.Bucket([]byte("Users")).Put([]byte(u.Name), mustMarshalJSON(u))
+	return tx.Bucket([]byte("Users")).Put([]byte(u.Name), tx.marshal(u))
 }
 
 // deleteUser removes the user from the metastore.
@@ -264,6 +507,104 @@ func (tx *metatx) deleteUser(name string) error {
 	return tx.Bucket([]byte("Users")).Delete([]byte(name))
 }
 
+// continuousQueries returns a list of all continuous queries from the metastore.
+func (tx *metatx) continuousQueries() (a []*ContinuousQuery) {
+	c := tx.Bucket([]byte("ContinuousQueries")).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		cq := &ContinuousQuery{}
+		tx.unmarshal(v, &cq)
+		a = append(a, cq)
+	}
+	return
+}
+
+// saveContinuousQuery persists a continuous query to the metastore.
+func (tx *metatx) saveContinuousQuery(cq *ContinuousQuery) error {
+	return tx.Bucket([]byte("ContinuousQueries")).Put([]byte(cq.Name), tx.marshal(cq))
+}
+
+// deleteContinuousQuery removes a continuous query from the metastore.
+func (tx *metatx) deleteContinuousQuery(name string) error {
+	return tx.Bucket([]byte("ContinuousQueries")).Delete([]byte(name))
+}
+
+// continuousQueryLeases returns a list of all continuous query leases or
+// system leases from the metastore.
+func (tx *metatx) continuousQueryLeases(system bool) (a []*ContinuousQueryLease) {
+	c := tx.leaseBucket(system).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		l := &ContinuousQueryLease{}
+		tx.unmarshal(v, &l)
+		a = append(a, l)
+	}
+	return
+}
+
+// saveContinuousQueryLease persists a continuous query lease to the metastore.
+func (tx *metatx) saveContinuousQueryLease(l *ContinuousQueryLease) error {
+	return tx.leaseBucket(l.System).Put([]byte(l.Name), tx.marshal(l))
+}
+
+// deleteContinuousQueryLease removes a continuous query lease from the metastore.
+func (tx *metatx) deleteContinuousQueryLease(name string, system bool) error {
+	return tx.leaseBucket(system).Delete([]byte(name))
+}
+
+// leaseBucket returns the bucket holding continuous query leases or system leases.
+func (tx *metatx) leaseBucket(system bool) *bolt.Bucket {
+	if system {
+		return tx.Bucket([]byte("SystemLeases"))
+	}
+	return tx.Bucket([]byte("ContinuousQueryLeases"))
+}
+
+// writeBlocks returns a list of all write blocks from the metastore.
+func (tx *metatx) writeBlocks() (a []*WriteBlock) {
+	c := tx.Bucket([]byte("WriteBlocks")).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		b := &WriteBlock{}
+		tx.unmarshal(v, &b)
+		a = append(a, b)
+	}
+	return
+}
+
+// saveWriteBlock persists a write block to the metastore by its pattern.
+func (tx *metatx) saveWriteBlock(key string, b *WriteBlock) error {
+	return tx.Bucket([]byte("WriteBlocks")).Put([]byte(key), tx.marshal(b))
+}
+
+// deleteWriteBlock removes a write block from the metastore by its pattern.
+func (tx *metatx) deleteWriteBlock(key string) error {
+	return tx.Bucket([]byte("WriteBlocks")).Delete([]byte(key))
+}
+
+// tokens returns a list of all API tokens from the metastore.
+func (tx *metatx) tokens() (a []*Token) {
+	c := tx.Bucket([]byte("Tokens")).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		t := &Token{}
+		tx.unmarshal(v, &t)
+		a = append(a, t)
+	}
+	return
+}
+
+// nextTokenID generates a new sequence id for an API token.
+func (tx *metatx) nextTokenID() uint64 {
+	return tx.mustNextSequence([]byte("tokenID"))
+}
+
+// saveToken persists an API token to the metastore by id.
+func (tx *metatx) saveToken(t *Token) error {
+	return tx.Bucket([]byte("Tokens")).Put(u64tob(t.ID), tx.marshal(t))
+}
+
+// deleteToken removes an API token from the metastore by id.
+func (tx *metatx) deleteToken(id uint64) error {
+	return tx.Bucket([]byte("Tokens")).Delete(u64tob(id))
+}
+
 // u64tob converts a uint64 into an 8-byte slice.
 func u64tob(v uint64) []byte {
 	b := make([]byte, 8)
@@ -0,0 +1,525 @@
+package influxdb
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the buckets used
+// by the server's latency histograms.
+var DefaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// Histogram counts observations into buckets by their upper bound. Buckets are
+// reported cumulatively, the same way as Prometheus histograms.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // upper bounds, ascending
+	counts  []uint64  // observations in each bucket, plus +Inf
+	sum     float64
+}
+
+// NewHistogram returns a new histogram with a set of bucket upper bounds.
+// Returns ErrInvalidHistogramBuckets if the bounds are empty or not ascending.
+func NewHistogram(buckets []float64) (*Histogram, error) {
+	if len(buckets) == 0 || !sort.Float64sAreSorted(buckets) {
+		return nil, ErrInvalidHistogramBuckets
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] == buckets[i-1] {
+			return nil, ErrInvalidHistogramBuckets
+		}
+	}
+
+	h := &Histogram{buckets: make([]float64, len(buckets))}
+	copy(h.buckets, buckets)
+	h.counts = make([]uint64, len(buckets)+1)
+	return h, nil
+}
+
+// mustNewHistogram returns a new histogram. Panics on invalid buckets.
+func mustNewHistogram(buckets []float64) *Histogram {
+	h, err := NewHistogram(buckets)
+	if err != nil {
+		panic(err.Error())
+	}
+	return h
+}
+
+// Observe adds a value to the histogram.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[sort.SearchFloat64s(h.buckets, v)]++
+	h.sum += v
+}
+
+// observeSince adds the seconds elapsed since t to the histogram.
+func (h *Histogram) observeSince(t time.Time) {
+	h.Observe(time.Since(t).Seconds())
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var n uint64
+	for _, c := range h.counts {
+		n += c
+	}
+	return n
+}
+
+// Sum returns the total of all observations.
+func (h *Histogram) Sum() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sum
+}
+
+// writePrometheus writes the histogram in the Prometheus text format.
+func (h *Histogram) writePrometheus(w io.Writer, name, help string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name); err != nil {
+		return err
+	}
+
+	var n uint64
+	for i, c := range h.counts {
+		n += c
+		le := math.Inf(1)
+		if i < len(h.buckets) {
+			le = h.buckets[i]
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatBucket(le), n); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64), name, n)
+	return err
+}
+
+// formatBucket formats a bucket upper bound as a Prometheus "le" label.
+func formatBucket(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Causes of dropped points, as reported in the dropped point counters.
+const (
+	dropParseError       = "parse_error"       // point could not be parsed
+	dropWriteBlocked     = "write_blocked"     // measurement or series is blocked
+	dropCardinalityLimit = "cardinality_limit" // tag key has too many values
+	dropTypeConflict     = "type_conflict"     // field has another type
+	dropRetentionWindow  = "retention_window"  // point is older than its retention policy keeps
+)
+
+// dropCause returns the cause of a point dropped because of a write error.
+// Returns an empty string if the error isn't a drop cause.
+func dropCause(err error) string {
+	switch err.(type) {
+	case *MaxValuesPerTagError:
+		return dropCardinalityLimit
+	case *FieldTypeConflictError:
+		return dropTypeConflict
+	}
+	if err == ErrWriteBlocked {
+		return dropWriteBlocked
+	}
+	return ""
+}
+
+// serverCounters counts server activity since the server started. Fields are
+// updated atomically except for the database counters, which are guarded by mu.
+type serverCounters struct {
+	pointsWritten   int64  // points accepted by WriteSeries
+	pointsDropped   int64  // points dropped for one of the drop causes
+	writeErrors     int64  // WriteSeries calls that failed
+	queriesExecuted int64  // queries passed to ExecuteQuery and ExecuteQueryStream
+	queryErrors     int64  // queries with a failed statement
+	applyErrors     int64  // broker messages that failed to apply
+	publishedIndex  uint64 // highest index returned by the broker
+
+	mu        sync.Mutex
+	databases map[string]*DatabaseStats // write and query counters by database
+}
+
+// database returns the counters for a database, creating them if needed.
+// Must be called under c.mu.
+func (c *serverCounters) database(name string) *DatabaseStats {
+	if c.databases == nil {
+		c.databases = make(map[string]*DatabaseStats)
+	}
+	db := c.databases[name]
+	if db == nil {
+		db = &DatabaseStats{}
+		c.databases[name] = db
+	}
+	return db
+}
+
+// addWrite counts the points of a write to a database and whether it failed.
+// Points rejected for a drop cause are also counted as dropped.
+func (c *serverCounters) addWrite(database string, n int, err error) {
+	c.mu.Lock()
+	db := c.database(database)
+	if err != nil {
+		db.WriteErrors++
+	} else {
+		db.PointsWritten += int64(n)
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		atomic.AddInt64(&c.writeErrors, 1)
+		if cause := dropCause(err); cause != "" {
+			c.addDropped(database, cause, n)
+		}
+		return
+	}
+	atomic.AddInt64(&c.pointsWritten, int64(n))
+}
+
+// addDropped counts n points dropped from a database for a cause.
+func (c *serverCounters) addDropped(database, cause string, n int) {
+	c.mu.Lock()
+	db := c.database(database)
+	if db.PointsDropped == nil {
+		db.PointsDropped = make(map[string]int64)
+	}
+	db.PointsDropped[cause] += int64(n)
+	c.mu.Unlock()
+
+	atomic.AddInt64(&c.pointsDropped, int64(n))
+}
+
+// addQuery counts a query executed against a database. Queries without a
+// database are only counted in the totals.
+func (c *serverCounters) addQuery(database string) {
+	if database != "" {
+		c.mu.Lock()
+		c.database(database).QueriesExecuted++
+		c.mu.Unlock()
+	}
+	atomic.AddInt64(&c.queriesExecuted, 1)
+}
+
+// addQueryError counts a query against a database that had a failed statement.
+func (c *serverCounters) addQueryError(database string) {
+	if database != "" {
+		c.mu.Lock()
+		c.database(database).QueryErrors++
+		c.mu.Unlock()
+	}
+	atomic.AddInt64(&c.queryErrors, 1)
+}
+
+// addPublish raises the highest published index to index.
+func (c *serverCounters) addPublish(index uint64) {
+	for {
+		prev := atomic.LoadUint64(&c.publishedIndex)
+		if index <= prev || atomic.CompareAndSwapUint64(&c.publishedIndex, prev, index) {
+			return
+		}
+	}
+}
+
+// databaseStats returns a copy of the write counters by database.
+func (c *serverCounters) databaseStats() map[string]*DatabaseStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]*DatabaseStats, len(c.databases))
+	for name, db := range c.databases {
+		other := *db
+		if db.PointsDropped != nil {
+			other.PointsDropped = make(map[string]int64, len(db.PointsDropped))
+			for cause, n := range db.PointsDropped {
+				other.PointsDropped[cause] = n
+			}
+		}
+		m[name] = &other
+	}
+	return m
+}
+
+// writePrometheusDropped writes the dropped point counters by database and
+// cause in the Prometheus text format.
+func (c *serverCounters) writePrometheusDropped(w io.Writer) error {
+	const name = "influxdb_points_dropped_total"
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, "Points dropped by database and cause.", name); err != nil {
+		return err
+	}
+
+	// Write counters in database and cause order.
+	stats := c.databaseStats()
+	databases := make([]string, 0, len(stats))
+	for database := range stats {
+		databases = append(databases, database)
+	}
+	sort.Strings(databases)
+	for _, database := range databases {
+		dropped := stats[database].PointsDropped
+		causes := make([]string, 0, len(dropped))
+		for cause := range dropped {
+			causes = append(causes, cause)
+		}
+		sort.Strings(causes)
+		for _, cause := range causes {
+			if _, err := fmt.Fprintf(w, "%s{database=%q,cause=%q} %d\n", name, database, cause, dropped[cause]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writePrometheusValue writes a single counter or gauge in the Prometheus
+// text format.
+func writePrometheusValue(w io.Writer, name, typ, help string, v int64) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, typ, name, v)
+	return err
+}
+
+// SetLatencyBuckets sets the bucket upper bounds, in seconds, used by the
+// write, query and publish latency histograms. Defaults to
+// DefaultLatencyBuckets. Must be called before Open.
+func (s *Server) SetLatencyBuckets(buckets []float64) error {
+	writeLatency, err := NewHistogram(buckets)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opened() {
+		return ErrServerOpen
+	}
+	s.writeLatency = writeLatency
+	s.queryLatency = mustNewHistogram(buckets)
+	s.publishLatency = mustNewHistogram(buckets)
+	s.syncLatency = mustNewHistogram(buckets)
+	return nil
+}
+
+// WriteMetrics writes the server's counters, shard gauges and latency
+// histograms to w in the Prometheus text format.
+func (s *Server) WriteMetrics(w io.Writer) error {
+	// Read the gauges under lock.
+	s.mu.RLock()
+	index, shardN := s.index, len(s.shards)
+	var openShardN int
+	for _, sh := range s.shards {
+		if sh.opened() {
+			openShardN++
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, m := range []struct {
+		name, typ, help string
+		value           int64
+	}{
+		{"influxdb_points_written_total", "counter", "Points accepted for writing by this data node.", atomic.LoadInt64(&s.counters.pointsWritten)},
+		{"influxdb_write_errors_total", "counter", "Writes rejected by this data node.", atomic.LoadInt64(&s.counters.writeErrors)},
+		{"influxdb_queries_executed_total", "counter", "Queries executed by this data node.", atomic.LoadInt64(&s.counters.queriesExecuted)},
+		{"influxdb_query_errors_total", "counter", "Queries with a statement that failed.", atomic.LoadInt64(&s.counters.queryErrors)},
+		{"influxdb_apply_errors_total", "counter", "Broker messages that failed to apply.", atomic.LoadInt64(&s.counters.applyErrors)},
+		{"influxdb_broker_index", "gauge", "Index of the last broker message applied.", int64(index)},
+		{"influxdb_shards", "gauge", "Shards in the cluster.", int64(shardN)},
+		{"influxdb_open_shards", "gauge", "Shards stored and open on this data node.", int64(openShardN)},
+	} {
+		if err := writePrometheusValue(w, m.name, m.typ, m.help, m.value); err != nil {
+			return err
+		}
+	}
+	if err := s.counters.writePrometheusDropped(w); err != nil {
+		return err
+	}
+
+	if err := s.writeLatency.writePrometheus(w, "influxdb_write_duration_seconds", "Time taken to write a point to the broker."); err != nil {
+		return err
+	}
+	if err := s.queryLatency.writePrometheus(w, "influxdb_query_duration_seconds", "Time taken to execute a query."); err != nil {
+		return err
+	}
+	if err := s.publishLatency.writePrometheus(w, "influxdb_publish_duration_seconds", "Time taken to publish a message to the broker."); err != nil {
+		return err
+	}
+	return s.syncLatency.writePrometheus(w, "influxdb_sync_duration_seconds", "Time spent waiting for a broker message to be applied.")
+}
+
+// ServerStats represents runtime statistics for a data node since it started.
+type ServerStats struct {
+	Index          uint64 `json:"index"`          // last broker message applied
+	PublishedIndex uint64 `json:"publishedIndex"` // highest index published by this node
+	Lag            uint64 `json:"lag"`            // published messages not yet applied
+
+	PointsWritten   int64 `json:"pointsWritten"`
+	PointsDropped   int64 `json:"pointsDropped"`
+	WriteErrors     int64 `json:"writeErrors"`
+	QueriesExecuted int64 `json:"queriesExecuted"`
+	QueryErrors     int64 `json:"queryErrors"`
+	ApplyErrors     int64 `json:"applyErrors"`
+
+	SyncN       uint64  `json:"syncN"`       // calls to Sync
+	SyncSeconds float64 `json:"syncSeconds"` // total time spent waiting in Sync
+
+	Databases map[string]*DatabaseStats `json:"databases"`
+	Shards    []ShardStats              `json:"shards"`
+}
+
+// DatabaseStats represents the writes and queries to a database through a
+// data node. The series count and size are only set by Server.DatabaseStats.
+//
+// Dropped points are counted by cause: "parse_error", "write_blocked",
+// "cardinality_limit", "type_conflict" or "retention_window". Points older
+// than their retention policy keeps are written, and counted as dropped, since
+// they are deleted by the next retention policy enforcement.
+type DatabaseStats struct {
+	PointsWritten   int64            `json:"pointsWritten"`
+	PointsDropped   map[string]int64 `json:"pointsDropped,omitempty"`
+	WriteErrors     int64            `json:"writeErrors"`
+	QueriesExecuted int64            `json:"queriesExecuted"`
+	QueryErrors     int64            `json:"queryErrors"`
+
+	SeriesN int   `json:"seriesN,omitempty"` // series in the database
+	Size    int64 `json:"size,omitempty"`    // bytes in the shards stored on this data node
+}
+
+// Stats returns the server's runtime statistics.
+func (s *Server) Stats() *ServerStats {
+	s.mu.RLock()
+	index := s.index
+	s.mu.RUnlock()
+
+	st := &ServerStats{
+		Index:           index,
+		PublishedIndex:  atomic.LoadUint64(&s.counters.publishedIndex),
+		PointsWritten:   atomic.LoadInt64(&s.counters.pointsWritten),
+		PointsDropped:   atomic.LoadInt64(&s.counters.pointsDropped),
+		WriteErrors:     atomic.LoadInt64(&s.counters.writeErrors),
+		QueriesExecuted: atomic.LoadInt64(&s.counters.queriesExecuted),
+		QueryErrors:     atomic.LoadInt64(&s.counters.queryErrors),
+		ApplyErrors:     atomic.LoadInt64(&s.counters.applyErrors),
+		SyncN:           s.syncLatency.Count(),
+		SyncSeconds:     s.syncLatency.Sum(),
+		Databases:       s.counters.databaseStats(),
+		Shards:          s.ShardStats(),
+	}
+	if st.PublishedIndex > st.Index {
+		st.Lag = st.PublishedIndex - st.Index
+	}
+	return st
+}
+
+// DatabaseStats returns the write and query counters of a database along with
+// its series count and the size of its shards stored on this data node.
+// Returns ErrDatabaseNotFound if the database doesn't exist.
+func (s *Server) DatabaseStats(name string) (*DatabaseStats, error) {
+	s.mu.RLock()
+	db := s.databases[name]
+	if db == nil {
+		s.mu.RUnlock()
+		return nil, ErrDatabaseNotFound
+	}
+	seriesN := len(db.series)
+	var shards []*Shard
+	for _, rp := range db.policies {
+		for _, g := range rp.shardGroups {
+			for _, sh := range g.Shards {
+				if sh.HasDataNodeID(s.id) {
+					shards = append(shards, sh)
+				}
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	st := &DatabaseStats{}
+	if c := s.counters.databaseStats()[name]; c != nil {
+		st = c
+	}
+	st.SeriesN = seriesN
+	for _, sh := range shards {
+		st.Size += sh.size()
+	}
+	return st, nil
+}
+
+func (s *Server) executeListStatsStatement(q *influxql.ListStatsStatement, user *User) *Result {
+	// Read the statistics of one database or of every database.
+	names := []string{q.Database}
+	if q.Database == "" {
+		names = s.Databases()
+	}
+
+	row := &influxql.Row{
+		Name:    "database",
+		Columns: []string{"database", "pointsWritten", "pointsDropped", "writeErrors", "queriesExecuted", "queryErrors", "series", "size"},
+	}
+	for _, name := range names {
+		st, err := s.DatabaseStats(name)
+		if err == ErrDatabaseNotFound && q.Database == "" {
+			continue // dropped since listing
+		} else if err != nil {
+			return &Result{Err: err}
+		}
+		var dropped int64
+		for _, n := range st.PointsDropped {
+			dropped += n
+		}
+		row.Values = append(row.Values, []interface{}{name, st.PointsWritten, dropped, st.WriteErrors, st.QueriesExecuted, st.QueryErrors, st.SeriesN, st.Size})
+	}
+	rows := []*influxql.Row{row}
+
+	// Statistics of the data node's subsystems are only listed with every database.
+	if q.Database == "" {
+		rows = append(rows, s.Stats().rows()...)
+	}
+	return &Result{Rows: rows}
+}
+
+// rows returns a row for each subsystem's statistics.
+func (st *ServerStats) rows() []*influxql.Row {
+	var shardsN, shardSize, outOfOrder int64
+	for _, sh := range st.Shards {
+		shardsN++
+		shardSize += sh.Size
+		outOfOrder += sh.PointsOutOfOrder
+	}
+
+	return []*influxql.Row{
+		{
+			Name:    "broker",
+			Columns: []string{"index", "publishedIndex", "lag", "applyErrors"},
+			Values:  [][]interface{}{{st.Index, st.PublishedIndex, st.Lag, st.ApplyErrors}},
+		},
+		{
+			Name:    "write",
+			Columns: []string{"pointsWritten", "pointsDropped", "writeErrors", "syncN", "syncSeconds"},
+			Values:  [][]interface{}{{st.PointsWritten, st.PointsDropped, st.WriteErrors, st.SyncN, st.SyncSeconds}},
+		},
+		{
+			Name:    "query",
+			Columns: []string{"queriesExecuted", "queryErrors"},
+			Values:  [][]interface{}{{st.QueriesExecuted, st.QueryErrors}},
+		},
+		{
+			Name:    "shard",
+			Columns: []string{"shards", "size", "pointsOutOfOrder"},
+			Values:  [][]interface{}{{shardsN, shardSize, outOfOrder}},
+		},
+	}
+}
@@ -0,0 +1,241 @@
+package influxdb_test
+
+import (
+	"bytes"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// Ensure a histogram counts every observation.
+func TestHistogram_Observe(t *testing.T) {
+	h, err := influxdb.NewHistogram([]float64{1, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []float64{0.5, 1, 5, 100} {
+		h.Observe(v)
+	}
+	if n := h.Count(); n != 4 {
+		t.Fatalf("unexpected count: %d", n)
+	}
+}
+
+// Ensure histogram buckets must be ascending.
+func TestNewHistogram_ErrInvalidHistogramBuckets(t *testing.T) {
+	for _, buckets := range [][]float64{nil, {10, 1}, {1, 1}} {
+		if _, err := influxdb.NewHistogram(buckets); err != influxdb.ErrInvalidHistogramBuckets {
+			t.Fatalf("unexpected error: %v: %v", buckets, err)
+		}
+	}
+}
+
+// Ensure the server records write, query and publish latencies and counters.
+func TestServer_WriteMetrics(t *testing.T) {
+	s := NewServer()
+	if err := s.SetLatencyBuckets([]float64{60}); err != nil {
+		t.Fatal(err)
+	} else if err := s.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Buckets can't be changed once the server is open.
+	if err := s.SetLatencyBuckets([]float64{1}); err != influxdb.ErrServerOpen {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+	if res := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil); res.Error() != nil {
+		t.Fatal(res.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE influxdb_write_duration_seconds histogram\n",
+		"influxdb_write_duration_seconds_bucket{le=\"60\"} 1\n",
+		"influxdb_write_duration_seconds_bucket{le=\"+Inf\"} 1\n",
+		"influxdb_write_duration_seconds_count 1\n",
+		"influxdb_query_duration_seconds_count 1\n",
+		"# TYPE influxdb_publish_duration_seconds histogram\n",
+		"# TYPE influxdb_points_written_total counter\ninfluxdb_points_written_total 1\n",
+		"influxdb_write_errors_total 0\n",
+		"influxdb_queries_executed_total 1\n",
+		"influxdb_query_errors_total 0\n",
+		"# TYPE influxdb_shards gauge\ninfluxdb_shards 1\n",
+		"influxdb_open_shards 1\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Fatalf("missing %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+// Ensure the server reports per-database writes, broker lag and sync waits.
+func TestServer_Stats(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+	s.WriteSeries("bar", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+
+	st := s.Stats()
+	if st.Index == 0 || st.PublishedIndex != st.Index || st.Lag != 0 {
+		t.Fatalf("unexpected indexes: %d, %d, %d", st.Index, st.PublishedIndex, st.Lag)
+	} else if st.SyncN == 0 {
+		t.Fatal("expected sync waits")
+	} else if !reflect.DeepEqual(st.Databases, map[string]*influxdb.DatabaseStats{"foo": {PointsWritten: 1, PointsDropped: map[string]int64{"retention_window": 1}}, "bar": {WriteErrors: 1}}) {
+		t.Fatalf("unexpected databases: %s", mustMarshalJSON(st.Databases))
+	} else if len(st.Shards) != 1 || st.Shards[0].Size == 0 {
+		t.Fatalf("unexpected shards: %s", mustMarshalJSON(st.Shards))
+	}
+
+	// Messages published but not yet applied are reported as lag.
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) { return m.Index, nil }
+	s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(1)}}})
+	if st := s.Stats(); st.Lag != 1 {
+		t.Fatalf("unexpected lag: %d", st.Lag)
+	}
+}
+
+// Ensure the server counts dropped points by database and cause.
+func TestServer_PointsDropped(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.SetMaxValuesPerTag(1, false)
+	now := time.Now().UTC()
+
+	// Drop points for each cause.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: now, Values: map[string]interface{}{"value": float64(1)}}})
+	if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: now, Values: map[string]interface{}{"value": "high"}}}); err == nil {
+		t.Fatal("expected type conflict")
+	}
+	if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "b"}, Timestamp: now, Values: map[string]interface{}{"value": float64(1)}}}); err == nil {
+		t.Fatal("expected cardinality limit")
+	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+	st := influxdb.NewInputStats("graphite", "foo")
+	s.RegisterInput(st)
+	st.ParseError()
+
+	if st, err := s.DatabaseStats("foo"); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(st.PointsDropped, map[string]int64{"type_conflict": 1, "cardinality_limit": 1, "retention_window": 1, "parse_error": 1}) {
+		t.Fatalf("unexpected dropped points: %v", st.PointsDropped)
+	} else if n := s.Stats().PointsDropped; n != 4 {
+		t.Fatalf("unexpected dropped point total: %d", n)
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(buf.String(), "# TYPE influxdb_points_dropped_total counter\n"+
+		"influxdb_points_dropped_total{database=\"foo\",cause=\"cardinality_limit\"} 1\n"+
+		"influxdb_points_dropped_total{database=\"foo\",cause=\"parse_error\"} 1\n") {
+		t.Fatalf("missing dropped points:\n%s", buf.String())
+	}
+}
+
+// Ensure the server reports the writes, queries, series and size of a database.
+func TestServer_DatabaseStats(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateDatabase("bar")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "b"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+	s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM mem`), "foo", nil)
+
+	if st, err := s.DatabaseStats("foo"); err != nil {
+		t.Fatal(err)
+	} else if st.PointsWritten != 2 || st.QueriesExecuted != 2 || st.QueryErrors != 1 || st.SeriesN != 2 || st.Size == 0 {
+		t.Fatalf("unexpected stats: %s", mustMarshalJSON(st))
+	} else if _, err := s.DatabaseStats("baz"); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Statistics can be limited to a single database.
+	results := s.ExecuteQuery(MustParseQuery(`SHOW STATS FOR 'bar'`), "", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatal(res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"database","columns":["database","pointsWritten","pointsDropped","writeErrors","queriesExecuted","queryErrors","series","size"],"values":[["bar",0,0,0,0,0,0,0]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Statistics are listed for every database.
+	results = s.ExecuteQuery(MustParseQuery(`SHOW STATS`), "", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatal(res.Err)
+	} else if values := res.Rows[0].Values; len(values) != 2 || values[0][0] != "bar" || values[1][0] != "foo" {
+		t.Fatalf("unexpected values: %s", mustMarshalJSON(values))
+	}
+
+	// Subsystem statistics follow the database statistics.
+	if rows := results[0].Rows; len(rows) != 5 {
+		t.Fatalf("unexpected row count: %d", len(rows))
+	} else if rows[1].Name != "broker" || rows[2].Name != "write" || rows[3].Name != "query" || rows[4].Name != "shard" {
+		t.Fatalf("unexpected rows: %s", mustMarshalJSON(rows))
+	} else if v := rows[2].Values[0]; v[0] != int64(2) {
+		t.Fatalf("unexpected write stats: %v", v)
+	} else if v := rows[3].Values[0]; v[0] != int64(4) || v[1] != int64(1) { // includes the SHOW STATS statements
+		t.Fatalf("unexpected query stats: %v", v)
+	} else if v := rows[4].Values[0]; v[0] != int64(1) || v[1].(int64) == 0 {
+		t.Fatalf("unexpected shard stats: %v", v)
+	}
+}
+
+// Ensure the server reports the state of its input services.
+func TestServer_Diagnostics(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	st := influxdb.NewInputStats("graphite", "foo")
+	st.Listening(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2003})
+	st.Received(3)
+	st.ParseError()
+	st.WriteError()
+	s.RegisterInput(st)
+	s.RegisterInput(influxdb.NewInputStats("statsd", "bar"))
+
+	results := s.ExecuteQuery(MustParseQuery(`SHOW DIAGNOSTICS`), "", nil)
+	res := results[0]
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	} else if len(res.Rows) != 2 || res.Rows[0].Name != "server" || res.Rows[1].Name != "inputs" {
+		t.Fatalf("unexpected rows: %s", mustMarshalJSON(res))
+	}
+
+	values := res.Rows[1].Values
+	if len(values) != 2 {
+		t.Fatalf("unexpected values: %s", mustMarshalJSON(values))
+	} else if v := values[0]; v[0] != "graphite" || v[1] != "foo" || v[2] != "127.0.0.1:2003" || v[3] == nil || v[4] != int64(3) || v[5] != int64(1) || v[6] != 0.25 || v[7] != int64(1) {
+		t.Fatalf("unexpected graphite values: %s", mustMarshalJSON(v))
+	} else if v := values[1]; v[0] != "statsd" || v[2] != "" || v[3] != nil || v[6] != float64(0) {
+		t.Fatalf("unexpected statsd values: %s", mustMarshalJSON(v))
+	}
+}
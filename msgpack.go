@@ -0,0 +1,299 @@
+package influxdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// encodeMsgpack returns the MessagePack encoding of v. Results are encoded
+// with the same keys as their JSON encoding. Only the types found in query
+// results are supported.
+func encodeMsgpack(v interface{}) ([]byte, error) {
+	var e msgpackEncoder
+	if err := e.encode(v); err != nil {
+		return nil, err
+	}
+	return e.b, nil
+}
+
+// msgpackEncoder appends MessagePack encoded values to a buffer.
+type msgpackEncoder struct {
+	b []byte
+}
+
+func (e *msgpackEncoder) encode(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		e.b = append(e.b, 0xc0)
+	case bool:
+		if v {
+			e.b = append(e.b, 0xc3)
+		} else {
+			e.b = append(e.b, 0xc2)
+		}
+	case int:
+		e.encodeInt(int64(v))
+	case int32:
+		e.encodeInt(int64(v))
+	case int64:
+		e.encodeInt(v)
+	case uint32:
+		e.encodeUint(uint64(v))
+	case uint64:
+		e.encodeUint(v)
+	case float32:
+		e.encodeFloat(float64(v))
+	case float64:
+		e.encodeFloat(v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			e.encodeInt(n)
+		} else if f, err := v.Float64(); err == nil {
+			e.encodeFloat(f)
+		} else {
+			e.encodeString(string(v))
+		}
+	case string:
+		e.encodeString(v)
+	case []byte:
+		e.encodeBytes(v)
+	case time.Time:
+		e.encodeString(v.UTC().Format(time.RFC3339Nano))
+	case []string:
+		e.encodeArrayLen(len(v))
+		for _, s := range v {
+			e.encodeString(s)
+		}
+	case []interface{}:
+		e.encodeArrayLen(len(v))
+		for _, elem := range v {
+			if err := e.encode(elem); err != nil {
+				return err
+			}
+		}
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.encodeMapLen(len(v))
+		for _, k := range keys {
+			e.encodeString(k)
+			e.encodeString(v[k])
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.encodeMapLen(len(v))
+		for _, k := range keys {
+			e.encodeString(k)
+			if err := e.encode(v[k]); err != nil {
+				return err
+			}
+		}
+	case Results:
+		e.encodeArrayLen(len(v))
+		for _, r := range v {
+			if err := e.encode(r); err != nil {
+				return err
+			}
+		}
+	case *Result:
+		return e.encodeResult(v)
+	case *influxql.Row:
+		return e.encodeRow(v)
+	default:
+		return fmt.Errorf("msgpack: unsupported type: %T", v)
+	}
+	return nil
+}
+
+// encodeResult encodes a result as a map of its rows and error.
+func (e *msgpackEncoder) encodeResult(r *Result) error {
+	n := 0
+	if len(r.Rows) > 0 {
+		n++
+	}
+	if r.Err != nil {
+		n++
+	}
+	e.encodeMapLen(n)
+
+	if len(r.Rows) > 0 {
+		e.encodeString("rows")
+		e.encodeArrayLen(len(r.Rows))
+		for _, row := range r.Rows {
+			if err := e.encodeRow(row); err != nil {
+				return err
+			}
+		}
+	}
+	if r.Err != nil {
+		e.encodeString("error")
+		e.encodeString(r.Err.Error())
+	}
+	return nil
+}
+
+// encodeRow encodes a row as a map of its name, tags, columns and values.
+func (e *msgpackEncoder) encodeRow(row *influxql.Row) error {
+	n := 1
+	if row.Name != "" {
+		n++
+	}
+	if len(row.Tags) > 0 {
+		n++
+	}
+	if len(row.Values) > 0 {
+		n++
+	}
+	e.encodeMapLen(n)
+
+	if row.Name != "" {
+		e.encodeString("name")
+		e.encodeString(row.Name)
+	}
+	if len(row.Tags) > 0 {
+		e.encodeString("tags")
+		_ = e.encode(row.Tags)
+	}
+	e.encodeString("columns")
+	_ = e.encode(row.Columns)
+	if len(row.Values) > 0 {
+		e.encodeString("values")
+		e.encodeArrayLen(len(row.Values))
+		for _, values := range row.Values {
+			if err := e.encode(values); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeInt(v int64) {
+	switch {
+	case v >= 0:
+		e.encodeUint(uint64(v))
+	case v >= -32:
+		e.b = append(e.b, byte(v))
+	case v >= math.MinInt8:
+		e.b = append(e.b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		e.b = append(e.b, 0xd1)
+		e.b = appendUint16(e.b, uint16(v))
+	case v >= math.MinInt32:
+		e.b = append(e.b, 0xd2)
+		e.b = appendUint32(e.b, uint32(v))
+	default:
+		e.b = append(e.b, 0xd3)
+		e.b = appendUint64(e.b, uint64(v))
+	}
+}
+
+func (e *msgpackEncoder) encodeUint(v uint64) {
+	switch {
+	case v <= 0x7f:
+		e.b = append(e.b, byte(v))
+	case v <= math.MaxUint8:
+		e.b = append(e.b, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		e.b = append(e.b, 0xcd)
+		e.b = appendUint16(e.b, uint16(v))
+	case v <= math.MaxUint32:
+		e.b = append(e.b, 0xce)
+		e.b = appendUint32(e.b, uint32(v))
+	default:
+		e.b = append(e.b, 0xcf)
+		e.b = appendUint64(e.b, v)
+	}
+}
+
+func (e *msgpackEncoder) encodeFloat(v float64) {
+	e.b = append(e.b, 0xcb)
+	e.b = appendUint64(e.b, math.Float64bits(v))
+}
+
+func (e *msgpackEncoder) encodeString(s string) {
+	switch n := len(s); {
+	case n < 32:
+		e.b = append(e.b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.b = append(e.b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		e.b = append(e.b, 0xda)
+		e.b = appendUint16(e.b, uint16(n))
+	default:
+		e.b = append(e.b, 0xdb)
+		e.b = appendUint32(e.b, uint32(n))
+	}
+	e.b = append(e.b, s...)
+}
+
+func (e *msgpackEncoder) encodeBytes(b []byte) {
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		e.b = append(e.b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		e.b = append(e.b, 0xc5)
+		e.b = appendUint16(e.b, uint16(n))
+	default:
+		e.b = append(e.b, 0xc6)
+		e.b = appendUint32(e.b, uint32(n))
+	}
+	e.b = append(e.b, b...)
+}
+
+func (e *msgpackEncoder) encodeArrayLen(n int) {
+	switch {
+	case n < 16:
+		e.b = append(e.b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		e.b = append(e.b, 0xdc)
+		e.b = appendUint16(e.b, uint16(n))
+	default:
+		e.b = append(e.b, 0xdd)
+		e.b = appendUint32(e.b, uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeMapLen(n int) {
+	switch {
+	case n < 16:
+		e.b = append(e.b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		e.b = append(e.b, 0xde)
+		e.b = appendUint16(e.b, uint16(n))
+	default:
+		e.b = append(e.b, 0xdf)
+		e.b = appendUint32(e.b, uint32(n))
+	}
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
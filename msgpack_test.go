@@ -0,0 +1,45 @@
+package influxdb
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Ensure values are encoded with the smallest MessagePack representation.
+func TestEncodeMsgpack(t *testing.T) {
+	for i, tt := range []struct {
+		v   interface{}
+		exp string
+	}{
+		{v: nil, exp: "c0"},
+		{v: true, exp: "c3"},
+		{v: false, exp: "c2"},
+		{v: int64(127), exp: "7f"},
+		{v: int64(128), exp: "cc80"},
+		{v: int64(65536), exp: "ce00010000"},
+		{v: int64(-32), exp: "e0"},
+		{v: int64(-33), exp: "d0df"},
+		{v: int64(-129), exp: "d1ff7f"},
+		{v: int64(-1 << 40), exp: "d3ffffff0000000000"},
+		{v: json.Number("300"), exp: "cd012c"},
+		{v: json.Number("1.5"), exp: "cb3ff8000000000000"},
+		{v: "", exp: "a0"},
+		{v: strings.Repeat("a", 32), exp: "d920" + strings.Repeat("61", 32)},
+		{v: []interface{}{"a", int64(1)}, exp: "92a16101"},
+		{v: map[string]string{"b": "2", "a": "1"}, exp: "82a161a131a162a132"},
+		{v: Results{{}}, exp: "9180"},
+	} {
+		b, err := encodeMsgpack(tt.v)
+		if err != nil {
+			t.Errorf("%d. %#v: unexpected error: %s", i, tt.v, err)
+		} else if s := hex.EncodeToString(b); s != tt.exp {
+			t.Errorf("%d. %#v: exp=%s, got=%s", i, tt.v, tt.exp, s)
+		}
+	}
+
+	if _, err := encodeMsgpack(struct{}{}); err == nil || err.Error() != "msgpack: unsupported type: struct {}" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package opentsdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+// DefaultPort represents the default OpenTSDB telnet port.
+const DefaultPort = 4242
+
+var (
+	// ErrBindAddressRequired is returned when starting the Server
+	// without a TCP listening address.
+	ErrBindAddressRequired = errors.New("bind address required")
+
+	// ErrDatabaseNotSpecified retuned when no database was specified in the config file
+	ErrDatabaseNotSpecified = errors.New("database was not specified in config")
+
+	// ErrServerClosed return when closing an already closed OpenTSDB server.
+	ErrServerClosed = errors.New("server already closed")
+)
+
+// SeriesWriter defines the interface for the destination of the data.
+type SeriesWriter interface {
+	WriteSeries(database, retentionPolicy string, points []influxdb.Point) (uint64, error)
+}
+
+// Server processes OpenTSDB telnet protocol data received over TCP connections.
+type Server struct {
+	mu sync.Mutex
+	wg sync.WaitGroup
+
+	writer   SeriesWriter
+	listener net.Listener
+	conns    map[net.Conn]struct{} // open connections
+
+	Database        string
+	RetentionPolicy string
+
+	// Stats records the state of the server for diagnostics. Optional.
+	Stats *influxdb.InputStats
+
+	Logger *influxdb.Logger
+}
+
+// NewServer returns a new instance of a Server.
+func NewServer(w SeriesWriter) *Server {
+	return &Server{
+		writer: w,
+		conns:  make(map[net.Conn]struct{}),
+		Logger: influxdb.NewLogger(os.Stderr, "opentsdb", influxdb.InfoLevel, false),
+	}
+}
+
+// ListenAndServe instructs the Server to start processing OpenTSDB data
+// on the given interface. iface must be in the form host:port
+func (s *Server) ListenAndServe(iface string) error {
+	if iface == "" { // Make sure we have an address
+		return ErrBindAddressRequired
+	} else if s.Database == "" { // Make sure they have a database
+		return ErrDatabaseNotSpecified
+	}
+
+	ln, err := net.Listen("tcp", iface)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+	s.Stats.Listening(ln.Addr())
+
+	s.wg.Add(1)
+	go s.serve(ln)
+
+	return nil
+}
+
+// Addr returns the address the server is listening on.
+// Returns nil if the server is not listening.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops listening for new connections, closes the open connections and
+// waits for their handlers to return.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.listener == nil {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	err := s.listener.Close()
+	s.listener = nil
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// serve accepts connections until the listener is closed.
+func (s *Server) serve(ln net.Listener) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.Addr() == nil {
+				return
+			}
+			s.Logger.Errorf("error accepting OpenTSDB connection: %s", err)
+			continue
+		}
+
+		// Track the connection so it can be closed with the server.
+		s.mu.Lock()
+		if s.listener == nil {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.handleConnection(conn)
+	}
+}
+
+// handleConnection services an individual TCP connection.
+func (s *Server) handleConnection(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		// Read up to the next newline.
+		buf, err := reader.ReadBytes('\n')
+		if err != nil {
+			if err != io.EOF && s.Addr() != nil {
+				s.Logger.Errorf("error reading OpenTSDB data: %s", err)
+			}
+			return
+		}
+
+		line := strings.TrimSpace(string(buf))
+		if line == "" {
+			continue
+		}
+
+		// Handle the command.
+		switch cmd := strings.Fields(line)[0]; cmd {
+		case "put":
+			point, err := Parse(line)
+			if err != nil {
+				s.Logger.Warnf("unable to parse OpenTSDB data: %s", err)
+				s.Stats.ParseError()
+				continue
+			}
+			s.Stats.Received(1)
+			if _, err := s.writer.WriteSeries(s.Database, s.RetentionPolicy, []influxdb.Point{point}); err != nil {
+				s.Logger.Errorf("unable to write OpenTSDB data: %s", err)
+				s.Stats.WriteError()
+			}
+		case "version":
+			fmt.Fprintf(conn, "InfluxDB OpenTSDB input\n")
+		case "exit":
+			return
+		default:
+			s.Logger.Warnf("unknown OpenTSDB command: %s", cmd)
+		}
+	}
+}
+
+// Parse parses a single OpenTSDB "put" line in the form:
+//
+//	put <metric> <timestamp> <value> <tagk1=tagv1 ... tagkN=tagvN>
+//
+// The metric becomes the measurement name and the value is stored as a float
+// in the "value" field so that integer and decimal values can be written to
+// the same field. Timestamps are in seconds, or milliseconds if the timestamp
+// has more than ten digits.
+func Parse(line string) (influxdb.Point, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[0] != "put" {
+		return influxdb.Point{}, fmt.Errorf("received %q which is not in the form: put metric timestamp value tag=value", line)
+	}
+
+	// Parse the timestamp.
+	ts, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return influxdb.Point{}, fmt.Errorf("invalid timestamp %q", fields[2])
+	}
+	var timestamp time.Time
+	if len(fields[2]) > 10 {
+		timestamp = time.Unix(0, ts*int64(time.Millisecond)).UTC()
+	} else {
+		timestamp = time.Unix(ts, 0).UTC()
+	}
+
+	// Parse the value.
+	value, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil {
+		return influxdb.Point{}, fmt.Errorf("invalid value %q", fields[3])
+	}
+
+	// Parse the tags.
+	tags := make(map[string]string)
+	for _, s := range fields[4:] {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return influxdb.Point{}, fmt.Errorf("invalid tag %q", s)
+		}
+		tags[kv[0]] = kv[1]
+	}
+
+	return influxdb.Point{
+		Name:      fields[1],
+		Tags:      tags,
+		Timestamp: timestamp,
+		Values:    map[string]interface{}{"value": value},
+	}, nil
+}
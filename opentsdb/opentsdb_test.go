@@ -0,0 +1,144 @@
+package opentsdb_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/opentsdb"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		line  string
+		point influxdb.Point
+		err   string
+	}{
+		{
+			line: `put sys.cpu.user 1356998400 42 host=webserver01 cpu=0`,
+			point: influxdb.Point{
+				Name:      "sys.cpu.user",
+				Tags:      map[string]string{"host": "webserver01", "cpu": "0"},
+				Timestamp: time.Unix(1356998400, 0).UTC(),
+				Values:    map[string]interface{}{"value": float64(42)},
+			},
+		},
+		{
+			line: `put sys.cpu.user 1356998400500 42.5`,
+			point: influxdb.Point{
+				Name:      "sys.cpu.user",
+				Tags:      map[string]string{},
+				Timestamp: time.Unix(1356998400, 500*int64(time.Millisecond)).UTC(),
+				Values:    map[string]interface{}{"value": float64(42.5)},
+			},
+		},
+		{line: `put sys.cpu.user 1356998400`, err: `received "put sys.cpu.user 1356998400" which is not in the form: put metric timestamp value tag=value`},
+		{line: `get sys.cpu.user 1356998400 42`, err: `received "get sys.cpu.user 1356998400 42" which is not in the form: put metric timestamp value tag=value`},
+		{line: `put sys.cpu.user now 42`, err: `invalid timestamp "now"`},
+		{line: `put sys.cpu.user 1356998400 x`, err: `invalid value "x"`},
+		{line: `put sys.cpu.user 1356998400 42 host`, err: `invalid tag "host"`},
+	}
+
+	for i, tt := range tests {
+		p, err := opentsdb.Parse(tt.line)
+		if errstr(err) != tt.err {
+			t.Errorf("%d. error mismatch: expected %q, got %q", i, tt.err, errstr(err))
+		} else if tt.err == "" && !reflect.DeepEqual(p, tt.point) {
+			t.Errorf("%d. point mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.point, p)
+		}
+	}
+}
+
+func TestServer_ListenAndServe(t *testing.T) {
+	points := make(chan influxdb.Point, 1)
+	w := &testSeriesWriter{fn: func(database, retentionPolicy string, a []influxdb.Point) (uint64, error) {
+		if database != "tsdb" || retentionPolicy != "raw" {
+			t.Errorf("unexpected destination: %s.%s", database, retentionPolicy)
+		}
+		points <- a[0]
+		return 0, nil
+	}}
+
+	s := opentsdb.NewServer(w)
+	s.Database, s.RetentionPolicy = "tsdb", "raw"
+	if err := s.ListenAndServe("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("put cpu 1356998400 1 host=a\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case p := <-points:
+		if p.Name != "cpu" || p.Tags["host"] != "a" {
+			t.Fatalf("unexpected point: %#v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for point")
+	}
+}
+
+// Ensure that closing the server closes open connections.
+func TestServer_Close(t *testing.T) {
+	s := opentsdb.NewServer(&testSeriesWriter{})
+	s.Database = "tsdb"
+	if err := s.ListenAndServe("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Wait for the connection to be handled before closing.
+	if _, err := conn.Write([]byte("version\n")); err != nil {
+		t.Fatal(err)
+	} else if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection should be closed by the server.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestServer_ListenAndServe_DatabaseRequired(t *testing.T) {
+	s := opentsdb.NewServer(&testSeriesWriter{})
+	if err := s.ListenAndServe("127.0.0.1:0"); err != opentsdb.ErrDatabaseNotSpecified {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// testSeriesWriter is a SeriesWriter that calls a function on every write.
+type testSeriesWriter struct {
+	fn func(database, retentionPolicy string, points []influxdb.Point) (uint64, error)
+}
+
+func (w *testSeriesWriter) WriteSeries(database, retentionPolicy string, points []influxdb.Point) (uint64, error) {
+	return w.fn(database, retentionPolicy, points)
+}
+
+func errstr(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
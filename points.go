@@ -0,0 +1,230 @@
+package influxdb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxLineSize is the length, in bytes, of the longest line protocol line that
+// can be parsed.
+const MaxLineSize = 1 << 20
+
+// ParsePoints parses points written in the line protocol. Each line holds a
+// single point in the form:
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+//
+// Blank lines and lines beginning with '#' are ignored. Lines that fail to
+// parse do not stop the remaining lines from being parsed; they are returned
+// together as LineErrors.
+func ParsePoints(buf []byte, precision string, now time.Time) ([]Point, error) {
+	var points []Point
+	var errs LineErrors
+	if err := scanPoints(bytes.NewReader(buf), precision, now, func(n int, p Point, err error) {
+		if err != nil {
+			errs = append(errs, &LineError{Line: n, Err: err})
+			return
+		}
+		points = append(points, p)
+	}); err != nil {
+		return nil, err
+	}
+
+	if len(errs) > 0 {
+		return points, errs
+	}
+	return points, nil
+}
+
+// scanPoints parses each line protocol point read from r and calls fn with
+// the line number and either the point or the error from parsing it. A line
+// longer than MaxLineSize is reported to fn and ends the scan. Returns an
+// error if r cannot be read.
+func scanPoints(r io.Reader, precision string, now time.Time, fn func(n int, p Point, err error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineSize)
+
+	n := 1
+	for ; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p, err := ParsePoint(line, precision, now)
+		fn(n, p, err)
+	}
+	if err := scanner.Err(); err == bufio.ErrTooLong {
+		fn(n, Point{}, fmt.Errorf("line exceeds %d bytes", MaxLineSize))
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ParsePoint parses a single line protocol point.
+//
+// Measurement names, tag keys, tag values and field keys may contain spaces,
+// commas and equal signs if they are escaped with a backslash. Field values
+// are floats by default, integers when suffixed with "i", booleans (t, true,
+// f, false) or double-quoted strings. The timestamp is an integer in the
+// given precision ("n", "ns", "u", "ms", "s", "m" or "h"). Points without a
+// timestamp are written at now.
+func ParsePoint(line, precision string, now time.Time) (Point, error) {
+	unit, err := precisionUnit(precision)
+	if err != nil {
+		return Point{}, err
+	}
+
+	// Split the line into its key, fields and timestamp sections.
+	var sections []string
+	for _, s := range splitUnescaped(line, ' ', true) {
+		if s != "" {
+			sections = append(sections, s)
+		}
+	}
+	if len(sections) < 2 {
+		return Point{}, errors.New("missing fields")
+	} else if len(sections) > 3 {
+		return Point{}, fmt.Errorf("unexpected text after timestamp: %q", strings.Join(sections[3:], " "))
+	}
+
+	// Parse the measurement name and tags.
+	key := splitUnescaped(sections[0], ',', false)
+	p := Point{Name: unescapeKey(key[0]), Tags: make(map[string]string), Values: make(map[string]interface{})}
+	if p.Name == "" {
+		return Point{}, errors.New("missing measurement")
+	}
+	for _, s := range key[1:] {
+		kv := splitUnescaped(s, '=', false)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return Point{}, fmt.Errorf("invalid tag: %q", s)
+		}
+		p.Tags[unescapeKey(kv[0])] = unescapeKey(kv[1])
+	}
+
+	// Parse the fields.
+	for _, s := range splitUnescaped(sections[1], ',', true) {
+		kv := splitUnescaped(s, '=', true)
+		if len(kv) != 2 || kv[0] == "" {
+			return Point{}, fmt.Errorf("invalid field: %q", s)
+		}
+		v, err := parseFieldValue(kv[1])
+		if err != nil {
+			return Point{}, err
+		}
+		p.Values[unescapeKey(kv[0])] = v
+	}
+
+	// Parse the timestamp, if present.
+	if len(sections) < 3 {
+		p.Timestamp = now
+		return p, nil
+	}
+	ts, err := strconv.ParseInt(sections[2], 10, 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("invalid timestamp: %q", sections[2])
+	}
+	if p.Timestamp, err = epochTime(ts, unit); err != nil {
+		return Point{}, fmt.Errorf("timestamp out of range: %q", sections[2])
+	}
+
+	return p, nil
+}
+
+// epochTime returns the time of an epoch timestamp counted in units.
+// Returns an error if the time can't be represented in nanoseconds.
+func epochTime(ts int64, unit time.Duration) (time.Time, error) {
+	if ts > math.MaxInt64/int64(unit) || ts < math.MinInt64/int64(unit) {
+		return time.Time{}, errors.New("timestamp out of range")
+	}
+	return time.Unix(0, ts*int64(unit)).UTC(), nil
+}
+
+// precisionUnit returns the duration of one timestamp unit for a precision.
+// An empty precision is nanoseconds.
+func precisionUnit(precision string) (time.Duration, error) {
+	switch precision {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	default:
+		return 0, ErrInvalidPrecision
+	}
+}
+
+// parseFieldValue parses a line protocol field value.
+func parseFieldValue(s string) (interface{}, error) {
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		// The closing quote must not itself be escaped.
+		body := strings.TrimSuffix(s[1:], `"`)
+		if len(s) < 2 || !strings.HasSuffix(s, `"`) || (len(body)-len(strings.TrimRight(body, `\`)))%2 == 1 {
+			return nil, fmt.Errorf("unterminated string: %s", s)
+		}
+		return stringValueReplacer.Replace(body), nil
+	}
+
+	if strings.HasSuffix(s, "i") {
+		i, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer: %s", s)
+		}
+		return i, nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid field value: %s", s)
+	}
+	return f, nil
+}
+
+// splitUnescaped splits s around each sep that is not escaped by a backslash.
+// If quoted is true then separators inside double quotes are also ignored.
+func splitUnescaped(s string, sep byte, quoted bool) []string {
+	var a []string
+	var inQuote bool
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == sep && !inQuote:
+			a = append(a, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(a, s[start:])
+}
+
+var (
+	keyReplacer         = strings.NewReplacer(`\ `, " ", `\,`, ",", `\=`, "=", `\\`, `\`)
+	stringValueReplacer = strings.NewReplacer(`\"`, `"`, `\\`, `\`)
+)
+
+// unescapeKey removes backslash escapes from a measurement name, tag or field key.
+func unescapeKey(s string) string { return keyReplacer.Replace(s) }
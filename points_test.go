@@ -0,0 +1,128 @@
+package influxdb_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure the line protocol parser can parse points.
+func TestParsePoint(t *testing.T) {
+	now := mustParseTime("2000-01-01T00:00:00Z")
+
+	var tests = []struct {
+		line      string
+		precision string
+		p         influxdb.Point
+		err       string
+	}{
+		// Fields only, no timestamp.
+		{
+			line: `cpu value=1`,
+			p:    influxdb.Point{Name: "cpu", Tags: map[string]string{}, Timestamp: now, Values: map[string]interface{}{"value": float64(1)}},
+		},
+
+		// Tags, typed fields and a nanosecond timestamp.
+		{
+			line: `cpu,host=serverA,region=us-west value=1.5,count=10i,ok=t,msg="hello, \"world\"" 946684800000000000`,
+			p: influxdb.Point{
+				Name:      "cpu",
+				Tags:      map[string]string{"host": "serverA", "region": "us-west"},
+				Timestamp: mustParseTime("2000-01-01T00:00:00Z"),
+				Values:    map[string]interface{}{"value": float64(1.5), "count": int64(10), "ok": true, "msg": `hello, "world"`},
+			},
+		},
+
+		// Escaped measurement, tags and field keys.
+		{
+			line: `disk\ io,path=C:\\,dev\=x=a\,b bytes\ read=1 `,
+			p:    influxdb.Point{Name: "disk io", Tags: map[string]string{"path": `C:\`, "dev=x": "a,b"}, Timestamp: now, Values: map[string]interface{}{"bytes read": float64(1)}},
+		},
+
+		// Timestamp precision.
+		{
+			line:      `cpu value=F 946684810`,
+			precision: "s",
+			p:         influxdb.Point{Name: "cpu", Tags: map[string]string{}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": false}},
+		},
+		{
+			line:      `cpu value=F 946684800000000010`,
+			precision: "ns",
+			p:         influxdb.Point{Name: "cpu", Tags: map[string]string{}, Timestamp: mustParseTime("2000-01-01T00:00:00.00000001Z"), Values: map[string]interface{}{"value": false}},
+		},
+
+		// Errors
+		{line: `cpu`, err: `missing fields`},
+		{line: `,host=a value=1`, err: `missing measurement`},
+		{line: `cpu,host value=1`, err: `invalid tag: "host"`},
+		{line: `cpu value`, err: `invalid field: "value"`},
+		{line: `cpu value=x`, err: `invalid field value: x`},
+		{line: `cpu value=1.5i`, err: `invalid integer: 1.5i`},
+		{line: `cpu value="foo\"`, err: `unterminated string: "foo\"`},
+		{line: `cpu value=1 abc`, err: `invalid timestamp: "abc"`},
+		{line: `cpu value=1 100 200`, err: `unexpected text after timestamp: "200"`},
+		{line: `cpu value=1 9223372036854775807`, precision: "h", err: `timestamp out of range: "9223372036854775807"`},
+		{line: `cpu value=1`, precision: "d", err: `invalid precision`},
+	}
+
+	for i, tt := range tests {
+		p, err := influxdb.ParsePoint(tt.line, tt.precision, now)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.line, tt.err, err)
+		} else if tt.err == "" && !reflect.DeepEqual(tt.p, p) {
+			t.Errorf("%d. %s: point mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.line, tt.p, p)
+		}
+	}
+}
+
+// Ensure the line protocol parser reports errors for each failed line.
+func TestParsePoints_LineErrors(t *testing.T) {
+	buf := strings.Join([]string{
+		`# comment`,
+		`cpu value=1 1`,
+		`cpu value=x 2`,
+		``,
+		`cpu value=3 3`,
+		`cpu`,
+	}, "\n")
+
+	points, err := influxdb.ParsePoints([]byte(buf), "s", time.Now())
+	if len(points) != 2 {
+		t.Fatalf("unexpected point count: %d", len(points))
+	} else if !points[1].Timestamp.Equal(time.Unix(3, 0)) {
+		t.Fatalf("unexpected timestamp: %s", points[1].Timestamp)
+	}
+	if err == nil || err.Error() != `line 3: invalid field value: x; line 6: missing fields` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure lines longer than the default scanner buffer can be parsed and lines
+// over the maximum are reported as line errors.
+func TestParsePoints_LongLine(t *testing.T) {
+	long := `cpu value="` + strings.Repeat("x", 100*1024) + `" 1`
+	if points, err := influxdb.ParsePoints([]byte(`cpu value=1 1`+"\n"+long), "s", time.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if len(points) != 2 {
+		t.Fatalf("unexpected point count: %d", len(points))
+	}
+
+	tooLong := `cpu value="` + strings.Repeat("x", influxdb.MaxLineSize) + `" 1`
+	points, err := influxdb.ParsePoints([]byte(`cpu value=1 1`+"\n"+tooLong), "s", time.Now())
+	if len(points) != 1 {
+		t.Fatalf("unexpected point count: %d", len(points))
+	} else if err == nil || err.Error() != `line 2: line exceeds 1048576 bytes` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// errstring converts an error to its string representation.
+func errstring(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
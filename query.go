@@ -0,0 +1,216 @@
+package influxdb
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// QueryInfo represents a statement executing on a data node.
+type QueryInfo struct {
+	ID       uint64    `json:"id"`
+	Query    string    `json:"query"`
+	Database string    `json:"database"`
+	User     string    `json:"user,omitempty"` // blank when authentication is disabled
+	Start    time.Time `json:"start"`
+}
+
+// runningQuery represents a registered statement and the function that cancels it.
+type runningQuery struct {
+	info   QueryInfo
+	cancel context.CancelFunc
+}
+
+// runningQueries represents the set of statements executing on the server
+// and the limits on executing select statements.
+type runningQueries struct {
+	mu     sync.Mutex
+	nextID uint64
+	m      map[uint64]*runningQuery
+
+	timeout time.Duration // select time limit, zero is unlimited
+	slots   chan struct{} // executing selects, nil is unlimited
+	queueN  int           // selects allowed to wait for a slot
+	queued  int           // selects waiting for a slot
+}
+
+// SetQueryTimeout sets the time a select statement may run, including time
+// spent waiting for a slot, before it is cancelled with ErrQueryTimeout.
+// A timeout of zero disables the limit.
+func (s *Server) SetQueryTimeout(d time.Duration) {
+	s.queries.mu.Lock()
+	defer s.queries.mu.Unlock()
+	s.queries.timeout = d
+}
+
+// SetMaxConcurrentQueries limits the number of select statements executing at
+// once to n. Up to queueN more statements wait for a statement to finish and
+// any others are rejected with ErrTooManyQueries. A limit of zero disables
+// the limit. Must be called before Open.
+func (s *Server) SetMaxConcurrentQueries(n, queueN int) error {
+	if n < 0 || queueN < 0 {
+		return ErrInvalidQueryLimit
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.opened() {
+		return ErrServerOpen
+	}
+
+	s.queries.mu.Lock()
+	defer s.queries.mu.Unlock()
+	s.queries.slots, s.queries.queueN = nil, queueN
+	if n > 0 {
+		s.queries.slots = make(chan struct{}, n)
+	}
+	return nil
+}
+
+// Queries returns the statements executing on the server ordered by id.
+func (s *Server) Queries() []*QueryInfo {
+	s.queries.mu.Lock()
+	defer s.queries.mu.Unlock()
+
+	a := make([]*QueryInfo, 0, len(s.queries.m))
+	for _, q := range s.queries.m {
+		info := q.info
+		a = append(a, &info)
+	}
+	sort.Sort(queryInfos(a))
+	return a
+}
+
+// KillQuery cancels an executing statement. The statement stops reading data
+// and returns ErrQueryKilled. Returns ErrQueryNotFound if no statement is
+// executing with the id.
+func (s *Server) KillQuery(id uint64) error {
+	s.queries.mu.Lock()
+	defer s.queries.mu.Unlock()
+
+	q := s.queries.m[id]
+	if q == nil {
+		return ErrQueryNotFound
+	}
+	q.cancel()
+	return nil
+}
+
+// registerQuery registers a statement as executing and returns a context that
+// is cancelled with ctx, when the statement is killed or, for select
+// statements, when it times out. Select statements wait for a slot if the concurrent query limit has
+// been reached. The returned function must be called once the statement has
+// finished unless an error is returned.
+func (s *Server) registerQuery(ctx context.Context, stmt influxql.Statement, database string, user *User) (context.Context, func(), error) {
+	_, isSelect := stmt.(*influxql.SelectStatement)
+
+	s.queries.mu.Lock()
+	timeout, slots := s.queries.timeout, s.queries.slots
+	s.queries.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	if isSelect && timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	q := &runningQuery{
+		info:   QueryInfo{Query: stmt.String(), Database: database, Start: s.clock.Now()},
+		cancel: cancel,
+	}
+	if user != nil {
+		q.info.User = user.Name
+	}
+
+	s.queries.mu.Lock()
+	s.queries.nextID++
+	q.info.ID = s.queries.nextID
+	s.queries.m[q.info.ID] = q
+	s.queries.mu.Unlock()
+
+	unregister := func() {
+		s.queries.mu.Lock()
+		delete(s.queries.m, q.info.ID)
+		s.queries.mu.Unlock()
+		cancel()
+	}
+
+	// Only select statements are limited.
+	if !isSelect || slots == nil {
+		return ctx, unregister, nil
+	}
+	if err := s.acquireQuerySlot(ctx, slots); err != nil {
+		unregister()
+		return nil, nil, err
+	}
+	return ctx, func() {
+		<-slots
+		unregister()
+	}, nil
+}
+
+// acquireQuerySlot takes a slot to execute a select statement. If every slot
+// is taken then it waits for one if the queue has room or returns
+// ErrTooManyQueries if it doesn't. Returns the translated context error if
+// the statement is cancelled while waiting.
+func (s *Server) acquireQuerySlot(ctx context.Context, slots chan struct{}) error {
+	select {
+	case slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	s.queries.mu.Lock()
+	if s.queries.queued >= s.queries.queueN {
+		s.queries.mu.Unlock()
+		return ErrTooManyQueries
+	}
+	s.queries.queued++
+	s.queries.mu.Unlock()
+
+	defer func() {
+		s.queries.mu.Lock()
+		s.queries.queued--
+		s.queries.mu.Unlock()
+	}()
+
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return queryError(ctx.Err())
+	}
+}
+
+// queryError translates errors from a cancelled statement into server errors.
+func queryError(err error) error {
+	switch err {
+	case context.Canceled:
+		return ErrQueryKilled
+	case context.DeadlineExceeded:
+		return ErrQueryTimeout
+	}
+	return err
+}
+
+func (s *Server) executeListQueriesStatement(q *influxql.ListQueriesStatement, user *User) *Result {
+	row := &influxql.Row{Columns: []string{"id", "query", "database", "user", "duration"}}
+	now := s.clock.Now()
+	for _, info := range s.Queries() {
+		row.Values = append(row.Values, []interface{}{info.ID, info.Query, info.Database, info.User, now.Sub(info.Start).String()})
+	}
+	return &Result{Rows: []*influxql.Row{row}}
+}
+
+func (s *Server) executeKillQueryStatement(q *influxql.KillQueryStatement, user *User) *Result {
+	return &Result{Err: s.KillQuery(q.QueryID)}
+}
+
+// queryInfos represents a list of queries sortable by id.
+type queryInfos []*QueryInfo
+
+func (p queryInfos) Len() int           { return len(p) }
+func (p queryInfos) Less(i, j int) bool { return p[i].ID < p[j].ID }
+func (p queryInfos) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,142 @@
+package influxdb
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// remoteReadTimeout limits a request for series in a shard stored on another
+// data node, including reading the response.
+const remoteReadTimeout = 1 * time.Minute
+
+// remoteClient returns an HTTP client for reading shards from other data nodes.
+// This function must be called under a lock.
+func (s *Server) remoteClient() *http.Client {
+	c := s.peerClient()
+	c.Timeout = remoteReadTimeout
+	return c
+}
+
+// remoteShard reads the series of a statement from a shard that is stored on
+// other data nodes. Series are registered as their cursors are created and
+// are fetched together in one request when the first cursor is positioned.
+// Series registered after that are fetched together by the next cursor.
+type remoteShard struct {
+	client   *http.Client
+	urls     []*url.URL // owners of the shard
+	shardID  uint64
+	min, max int64 // time range
+
+	mu      sync.Mutex
+	pending []uint32                  // series not yet fetched
+	points  map[uint32][]StoragePoint // fetched values by series
+	err     error
+}
+
+// newRemoteShard returns a reader for the values between min and max in a
+// shard stored on other data nodes.
+// This function must be called under a read lock.
+func (s *Server) newRemoteShard(sh *Shard, min, max int64) *remoteShard {
+	return &remoteShard{
+		client:  s.remoteClient(),
+		urls:    s.shardURLs(sh),
+		shardID: sh.ID,
+		min:     min,
+		max:     max,
+		points:  make(map[uint32][]StoragePoint),
+	}
+}
+
+// cursor returns a cursor over a series in the shard.
+func (r *remoteShard) cursor(seriesID uint32) *remoteCursor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, seriesID)
+	return &remoteCursor{shard: r, seriesID: seriesID}
+}
+
+// series returns the values of a series, fetching every pending series first.
+func (r *remoteShard) series(seriesID uint32) ([]StoragePoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.pending) > 0 && r.err == nil {
+		ids := r.pending
+		r.pending = nil
+
+		points, err := fetchRemoteSeries(r.client, r.urls, r.shardID, ids, r.min, r.max)
+		if err != nil {
+			r.err = err
+			return nil, err
+		}
+		for _, p := range points {
+			r.points[p.SeriesID] = append(r.points[p.SeriesID], p)
+		}
+	}
+	return r.points[seriesID], r.err
+}
+
+// remoteCursor iterates over a series in a shard that is stored on other data
+// nodes. The series' values are fetched when the cursor is first positioned.
+type remoteCursor struct {
+	shard    *remoteShard
+	seriesID uint32
+
+	points  []StoragePoint
+	i       int
+	fetched bool
+	err     error
+}
+
+// SeekTo moves to the first value at or after timestamp.
+func (c *remoteCursor) SeekTo(timestamp int64) (int64, []byte) {
+	if !c.fetched {
+		c.fetched = true
+		c.points, c.err = c.shard.series(c.seriesID)
+	}
+	c.i = sort.Search(len(c.points), func(i int) bool { return c.points[i].Timestamp >= timestamp })
+	return c.value()
+}
+
+// Next moves to the next value.
+func (c *remoteCursor) Next() (int64, []byte) {
+	if c.i < len(c.points) {
+		c.i++
+	}
+	return c.value()
+}
+
+// Err returns the error from fetching the series, if any.
+func (c *remoteCursor) Err() error { return c.err }
+
+// value returns the current value. Returns nil values at the end.
+func (c *remoteCursor) value() (int64, []byte) {
+	if c.i >= len(c.points) {
+		return 0, nil
+	}
+	p := c.points[c.i]
+	return p.Timestamp, p.Values
+}
+
+// fetchRemoteSeries reads a set of series in a shard from its owners, in
+// order, until one succeeds.
+func fetchRemoteSeries(client *http.Client, urls []*url.URL, shardID uint64, seriesIDs []uint32, min, max int64) ([]StoragePoint, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("remote shard(%d): no data nodes", shardID)
+	}
+
+	var err error
+	for _, u := range urls {
+		a, e := fetchShardSeries(client, u, shardID, seriesIDs, min, max)
+		if e != nil {
+			err = fmt.Errorf("remote shard(%d): %s: %s", shardID, u, e)
+			continue
+		}
+		return a, nil
+	}
+	return nil, err
+}
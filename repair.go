@@ -0,0 +1,347 @@
+package influxdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SeriesDigest summarizes the values of a series stored in a shard so the
+// replicas of a shard can be compared without copying their data.
+type SeriesDigest struct {
+	SeriesID uint32 `json:"seriesID"`
+	Count    int    `json:"count"`
+	Checksum uint64 `json:"checksum"` // FNV-1a of every timestamp and value
+}
+
+// ShardRepair represents the data copied to a shard from its other replicas.
+type ShardRepair struct {
+	ShardID        uint64 `json:"shardID"`
+	SeriesRepaired int    `json:"seriesRepaired"`
+	PointsCopied   int    `json:"pointsCopied"`
+}
+
+// ShardDigest returns a digest of each series stored in a shard on this
+// server, ordered by series id. Series without data are not included.
+func (s *Server) ShardDigest(shardID uint64) ([]*SeriesDigest, error) {
+	sh, ids, err := s.localShardSeries(shardID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := sh.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	a := make([]*SeriesDigest, 0)
+	for _, id := range ids {
+		c := tx.Cursor(id)
+		if c == nil {
+			continue
+		}
+
+		// Hash every timestamp and value in order.
+		d := &SeriesDigest{SeriesID: id}
+		h := fnv.New64a()
+		buf := make([]byte, 8)
+		for k, v := c.SeekTo(0); v != nil; k, v = c.Next() {
+			binary.BigEndian.PutUint64(buf, uint64(k))
+			_, _ = h.Write(buf)
+			_, _ = h.Write(v)
+			d.Count++
+		}
+		if err := cursorErr(c); err != nil {
+			return nil, err
+		} else if d.Count == 0 {
+			continue
+		}
+		d.Checksum = h.Sum64()
+		a = append(a, d)
+	}
+	return a, nil
+}
+
+// CopyShardSeries writes the values of a set of series stored in a shard on
+// this server to w, limited to the timestamps between min and max inclusive.
+// A max of zero has no upper limit. The output is read by other data nodes to
+// repair their replicas and to query shards they don't store.
+func (s *Server) CopyShardSeries(w io.Writer, shardID uint64, seriesIDs []uint32, min, max int64) error {
+	sh, _, err := s.localShardSeries(shardID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := sh.begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	bw := bufio.NewWriter(w)
+	for _, id := range seriesIDs {
+		c := tx.Cursor(id)
+		if c == nil {
+			continue
+		}
+		for k, v := c.SeekTo(min); v != nil && (max == 0 || k <= max); k, v = c.Next() {
+			if err := writeStoragePoint(bw, StoragePoint{SeriesID: id, Timestamp: k, Values: v}); err != nil {
+				return err
+			}
+		}
+		if err := cursorErr(c); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// RepairShard compares a shard stored on this server with the other data
+// nodes that own it and copies any values that are missing locally. Values
+// that exist on both replicas with different contents are left as-is.
+func (s *Server) RepairShard(shardID uint64) (*ShardRepair, error) {
+	// Find the other owners of the shard.
+	s.mu.RLock()
+	sh := s.shards[shardID]
+	var peers []*url.URL
+	if sh != nil {
+		for _, id := range sh.DataNodeIDs {
+			if n := s.dataNodes[id]; n != nil && id != s.id {
+				peers = append(peers, n.URL)
+			}
+		}
+	}
+	client := s.peerClient()
+	s.mu.RUnlock()
+
+	// Digest the local copy of the shard.
+	local, err := s.ShardDigest(shardID)
+	if err != nil {
+		return nil, err
+	}
+	digests := make(map[uint32]*SeriesDigest, len(local))
+	for _, d := range local {
+		digests[d.SeriesID] = d
+	}
+
+	r := &ShardRepair{ShardID: shardID}
+	repaired := make(map[uint32]bool)
+	for _, u := range peers {
+		// Find the series that differ from the peer.
+		remote, err := fetchShardDigest(client, u, shardID)
+		if err != nil {
+			return r, fmt.Errorf("digest: %s: %s", u, err)
+		}
+		var ids []uint32
+		for _, d := range remote {
+			if l := digests[d.SeriesID]; l == nil || l.Count != d.Count || l.Checksum != d.Checksum {
+				ids = append(ids, d.SeriesID)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		// Copy the peer's values for those series that are missing locally.
+		n, err := s.copyMissingSeries(client, u, sh, ids)
+		if err != nil {
+			return r, fmt.Errorf("copy: %s: %s", u, err)
+		}
+		for id, n := range n {
+			repaired[id] = true
+			r.PointsCopied += n
+		}
+	}
+	r.SeriesRepaired = len(repaired)
+
+	return r, nil
+}
+
+// RepairShards repairs every shard stored on this server that is owned by
+// other data nodes, ordered by shard id. Returns the repairs made before the
+// first error.
+func (s *Server) RepairShards() ([]*ShardRepair, error) {
+	s.mu.RLock()
+	var ids []uint64
+	for id, sh := range s.shards {
+		if sh.HasDataNodeID(s.id) && len(sh.DataNodeIDs) > 1 {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Sort(uint64Slice(ids))
+
+	a := make([]*ShardRepair, 0, len(ids))
+	for _, id := range ids {
+		r, err := s.RepairShard(id)
+		if err != nil {
+			return a, fmt.Errorf("shard(%d): %s", id, err)
+		}
+		a = append(a, r)
+	}
+	return a, nil
+}
+
+// copyMissingSeries reads a peer's values for a set of series and writes the
+// values whose timestamps don't exist in the local shard. Returns the number
+// of values copied for each series.
+func (s *Server) copyMissingSeries(client *http.Client, u *url.URL, sh *Shard, ids []uint32) (map[uint32]int, error) {
+	a, err := fetchShardSeries(client, u, sh.ID, ids, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only write values that are missing locally.
+	var points []StoragePoint
+	for _, p := range a {
+		if v, err := sh.readSeries(p.SeriesID, p.Timestamp); err != nil {
+			return nil, err
+		} else if v == nil {
+			points = append(points, p)
+		}
+	}
+	if err := sh.copyPoints(points); err != nil {
+		return nil, err
+	}
+
+	n := make(map[uint32]int)
+	for _, p := range points {
+		n[p.SeriesID]++
+	}
+	return n, nil
+}
+
+// localShardSeries returns a shard stored on this server along with the ids of
+// every series in the shard's database, in order.
+func (s *Server) localShardSeries(shardID uint64) (*Shard, []uint32, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sh := s.shards[shardID]
+	if sh == nil {
+		return nil, nil, ErrShardNotFound
+	} else if !sh.HasDataNodeID(s.id) {
+		return nil, nil, ErrShardNotLocal
+	}
+
+	db, _ := s.shardPolicy(shardID)
+	if db == nil {
+		return nil, nil, ErrShardNotFound
+	}
+	ids := make(SeriesIDs, 0, len(db.series))
+	for id := range db.series {
+		ids = append(ids, id)
+	}
+	sort.Sort(ids)
+
+	return sh, ids, nil
+}
+
+// fetchShardDigest retrieves the series digests of a shard from a peer.
+func fetchShardDigest(client *http.Client, u *url.URL, shardID uint64) ([]*SeriesDigest, error) {
+	resp, err := client.Get(peerShardURL(u, shardID, "digest", nil))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var a []*SeriesDigest
+	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// fetchShardSeries retrieves the values of a set of series in a shard from a
+// peer, limited to the timestamps between min and max inclusive. A max of zero
+// has no upper limit.
+func fetchShardSeries(client *http.Client, u *url.URL, shardID uint64, seriesIDs []uint32, min, max int64) ([]StoragePoint, error) {
+	// Request the series from the peer.
+	a := make([]string, len(seriesIDs))
+	for i, id := range seriesIDs {
+		a[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	params := url.Values{"ids": {strings.Join(a, ",")}}
+	if min != 0 {
+		params.Set("min", strconv.FormatInt(min, 10))
+	}
+	if max != 0 {
+		params.Set("max", strconv.FormatInt(max, 10))
+	}
+	resp, err := client.Get(peerShardURL(u, shardID, "series", params))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	// Decode the points until the end of the stream.
+	var points []StoragePoint
+	br := bufio.NewReader(resp.Body)
+	for {
+		p, err := readStoragePoint(br)
+		if err == io.EOF {
+			return points, nil
+		} else if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+}
+
+// peerShardURL returns the URL of a shard endpoint on a peer data node.
+func peerShardURL(u *url.URL, shardID uint64, name string, params url.Values) string {
+	u = copyURL(u)
+	u.Path = fmt.Sprintf("/shards/%d/%s", shardID, name)
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+// writeStoragePoint encodes a point as its series id, timestamp, value length
+// and values.
+func writeStoragePoint(w io.Writer, p StoragePoint) error {
+	var hdr [16]byte
+	binary.BigEndian.PutUint32(hdr[0:4], p.SeriesID)
+	binary.BigEndian.PutUint64(hdr[4:12], uint64(p.Timestamp))
+	binary.BigEndian.PutUint32(hdr[12:16], uint32(len(p.Values)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(p.Values)
+	return err
+}
+
+// readStoragePoint decodes a point written by writeStoragePoint.
+// Returns io.EOF if there are no more points.
+func readStoragePoint(r io.Reader) (StoragePoint, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err == io.EOF {
+		return StoragePoint{}, io.EOF
+	} else if err != nil {
+		return StoragePoint{}, err
+	}
+
+	p := StoragePoint{
+		SeriesID:  binary.BigEndian.Uint32(hdr[0:4]),
+		Timestamp: int64(binary.BigEndian.Uint64(hdr[4:12])),
+		Values:    make([]byte, binary.BigEndian.Uint32(hdr[12:16])),
+	}
+	if _, err := io.ReadFull(r, p.Values); err != nil {
+		return StoragePoint{}, err
+	}
+	return p, nil
+}
@@ -1,22 +1,34 @@
 package influxdb
 
 import (
+	"archive/tar"
 	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"code.google.com/p/go.crypto/bcrypt"
 	"github.com/influxdb/influxdb/influxql"
@@ -42,12 +54,26 @@ const (
 
 	// DefaultShardRetention is the length of time before a shard is dropped.
 	DefaultShardRetention = time.Duration(0)
+
+	// DefaultContinuousQueryLeaseDuration is the length of time a data node
+	// holds the right to run a continuous query before it must renew it.
+	DefaultContinuousQueryLeaseDuration = 1 * time.Minute
+
+	// DefaultSyncTimeout is the time a data node waits to apply a broadcast
+	// message requested by another data node.
+	DefaultSyncTimeout = 30 * time.Second
+
+	// DefaultConsistencyTimeout is the time a write waits for its replicas to
+	// apply it before failing the requested consistency level.
+	DefaultConsistencyTimeout = 10 * time.Second
 )
 
 const (
 	// Data node messages
-	createDataNodeMessageType = messaging.MessageType(0x00)
-	deleteDataNodeMessageType = messaging.MessageType(0x01)
+	createDataNodeMessageType  = messaging.MessageType(0x00)
+	deleteDataNodeMessageType  = messaging.MessageType(0x01)
+	promoteDataNodeMessageType = messaging.MessageType(0x02)
+	updateDataNodeMessageType  = messaging.MessageType(0x03)
 
 	// Database messages
 	createDatabaseMessageType = messaging.MessageType(0x10)
@@ -64,11 +90,43 @@ const (
 	updateUserMessageType = messaging.MessageType(0x31)
 	deleteUserMessageType = messaging.MessageType(0x32)
 
+	// Privilege messages
+	grantPrivilegeMessageType  = messaging.MessageType(0x33)
+	revokePrivilegeMessageType = messaging.MessageType(0x34)
+	setPrivilegeMessageType    = messaging.MessageType(0x37)
+
+	// Token messages
+	createTokenMessageType = messaging.MessageType(0x35)
+	revokeTokenMessageType = messaging.MessageType(0x36)
+
+	// Downsample policy messages
+	createDownsamplePolicyMessageType = messaging.MessageType(0x24)
+	deleteDownsamplePolicyMessageType = messaging.MessageType(0x25)
+
+	// Continuous query messages
+	acquireContinuousQueryLeaseMessageType = messaging.MessageType(0x60)
+	createContinuousQueryMessageType       = messaging.MessageType(0x61)
+	deleteContinuousQueryMessageType       = messaging.MessageType(0x62)
+
+	// Event messages
+	createEventMessageType = messaging.MessageType(0x70)
+
+	// Metadata batch messages
+	metadataBatchMessageType = messaging.MessageType(0xA0)
+
+	// Write block messages
+	blockWritesMessageType   = messaging.MessageType(0x90)
+	unblockWritesMessageType = messaging.MessageType(0x91)
+
 	// Shard messages
 	createShardGroupIfNotExistsMessageType = messaging.MessageType(0x40)
+	deleteShardGroupMessageType            = messaging.MessageType(0x41)
+	setShardOwnersMessageType              = messaging.MessageType(0x42)
 
 	// Series messages
 	createSeriesIfNotExistsMessageType = messaging.MessageType(0x50)
+	dropSeriesMessageType              = messaging.MessageType(0x51)
+	deleteRangeMessageType             = messaging.MessageType(0x52)
 
 	// Write series data messages (per-topic)
 	writeRawSeriesMessageType = messaging.MessageType(0x80)
@@ -82,9 +140,25 @@ type Server struct {
 	path string
 	done chan struct{} // goroutine close notification
 
-	client MessagingClient  // broker client
-	index  uint64           // highest broadcast index seen
-	errors map[uint64]error // message errors
+	retentionDone       chan struct{} // retention enforcement close notification
+	compactionDone      chan struct{} // shard compaction close notification
+	continuousQueryDone chan struct{} // continuous query scheduling close notification
+	metaBackupDone      chan struct{} // metastore backup close notification
+	broadcastDone       chan struct{} // broadcast compaction close notification
+	cardinalityDone     chan struct{} // tag cardinality check close notification
+	writeQueueDone      chan struct{} // write queue drain close notification
+
+	writeQueue *writeQueue // asynchronous writes waiting to be published
+
+	client    MessagingClient        // broker client
+	index     uint64                 // highest broadcast index seen
+	metaIndex uint64                 // last broadcast message applied to the metastore
+	applied   chan struct{}          // closed when the index changes
+	errors    map[uint64]*ApplyError // message errors
+	tails     writeTails             // readers of applied writes
+
+	version      string // version reported to the cluster
+	upgradeIndex uint64 // first message this data node couldn't apply
 
 	meta *metastore // metadata store
 
@@ -92,17 +166,91 @@ type Server struct {
 	databases map[string]*database // databases by name
 	shards    map[uint64]*Shard    // shards by id
 	users     map[string]*User     // user by name
+
+	droppedShardIDs []uint64 // shards to unsubscribe from after apply
+
+	continuousQueries     map[string]*ContinuousQuery      // queries by name
+	continuousQueryLeases map[string]*ContinuousQueryLease // leases by query name
+	systemLeases          map[string]*ContinuousQueryLease // leases for tasks run by one data node
+	downsampleLastRun     map[string]time.Time             // last interval run by downsample query name
+
+	cardinality map[cardinalityKey]int // highest threshold reported by tag key
+
+	dropExpiredSeries  bool                            // drop series once retention removes their data
+	expiredSeriesGrace time.Duration                   // time a series must be empty before it's dropped
+	emptySeriesSince   map[databaseSeriesKey]time.Time // first check each series was found empty
+
+	downsampleQueries map[string]bool // databases whose queries can read downsampled data
+
+	maxValuesPerTag   int  // distinct values allowed per tag key, zero is unlimited
+	dropTagsOverLimit bool // drop tags over the limit instead of rejecting points
+
+	inputs []*InputStats // input services reported in diagnostics
+
+	writeBlocks map[string]*WriteBlock // blocked points by pattern
+	tokens      map[string]*Token      // API tokens by hash
+
+	queries runningQueries // statements being executed
+
+	peerTLS       *PeerTLSConfig // join verification
+	clusterSecret string         // authenticates requests between data nodes
+
+	clock     Clock                  // time source for scheduling & expiration
+	storage   StorageEngineOpener    // shard storage engine
+	shardKeys map[string]cipher.AEAD // shard encryption keys by database
+
+	tagNormalizer     *TagNormalizer // incoming tag rules
+	maxQueryCost      int64          // select cost budget, zero is unlimited
+	reorderBufferSize int            // writes buffered per shard, zero disables buffering
+	bcryptCost        int            // password hash cost, zero uses BcryptCost
+	passwordPolicy    PasswordPolicy // rules for new passwords
+
+	bootstrapAdmin *createUserCommand // admin created by Initialize
+
+	writeLatency   *Histogram // time to write a point
+	queryLatency   *Histogram // time to execute a query
+	publishLatency *Histogram // time to publish a message to the broker
+	syncLatency    *Histogram // time to wait for a message to be applied
+	counters       serverCounters
+
+	consistencyTimeout time.Duration // time a write waits for its replicas
+
+	Logger *Logger
 }
 
 // NewServer returns a new instance of Server.
 func NewServer() *Server {
 	return &Server{
 		meta:      &metastore{},
-		errors:    make(map[uint64]error),
+		clock:     systemClock{},
+		storage:   OpenBoltStorageEngine,
+		errors:    make(map[uint64]*ApplyError),
+		applied:   make(chan struct{}),
+		tails:     writeTails{m: make(map[*writeTail]struct{})},
 		dataNodes: make(map[uint64]*DataNode),
 		databases: make(map[string]*database),
 		shards:    make(map[uint64]*Shard),
 		users:     make(map[string]*User),
+
+		continuousQueries:     make(map[string]*ContinuousQuery),
+		continuousQueryLeases: make(map[string]*ContinuousQueryLease),
+		systemLeases:          make(map[string]*ContinuousQueryLease),
+		downsampleLastRun:     make(map[string]time.Time),
+
+		cardinality: make(map[cardinalityKey]int),
+		writeBlocks: make(map[string]*WriteBlock),
+		tokens:      make(map[string]*Token),
+
+		queries: runningQueries{m: make(map[uint64]*runningQuery)},
+
+		writeLatency:   mustNewHistogram(DefaultLatencyBuckets),
+		queryLatency:   mustNewHistogram(DefaultLatencyBuckets),
+		publishLatency: mustNewHistogram(DefaultLatencyBuckets),
+		syncLatency:    mustNewHistogram(DefaultLatencyBuckets),
+
+		consistencyTimeout: DefaultConsistencyTimeout,
+
+		Logger: NewLogger(os.Stderr, "server", InfoLevel, false),
 	}
 }
 
@@ -122,6 +270,179 @@ func (s *Server) Path() string {
 	return s.path
 }
 
+// SetMetastoreKeys sets the key used to encrypt metastore values at rest.
+// Previous keys are used to decrypt values written before a key rotation.
+// Values are re-encrypted with the current key when the server is opened.
+// Passing a nil key stores new values unencrypted. Must be called before Open.
+//
+// Note that plaintext written before a key was configured may remain in the
+// free pages of the metastore file until those pages are reused.
+func (s *Server) SetMetastoreKeys(key []byte, previousKeys ...[]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opened() {
+		return ErrServerOpen
+	}
+	return s.meta.setKeys(key, previousKeys)
+}
+
+// SetClock sets the clock used for shard group creation, retention
+// enforcement and continuous query scheduling. Defaults to the system clock.
+// Must be called before Open.
+func (s *Server) SetClock(c Clock) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opened() {
+		return ErrServerOpen
+	}
+	s.clock = c
+	return nil
+}
+
+// SetStorageEngine sets the storage engine used to open shards stored on this
+// server. Defaults to OpenBoltStorageEngine. Must be called before Open and
+// must not change once shards have been created.
+func (s *Server) SetStorageEngine(fn StorageEngineOpener) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opened() {
+		return ErrServerOpen
+	}
+	s.storage = fn
+	return nil
+}
+
+// SetShardKeys sets the keys used to encrypt shards at rest by database name.
+// Shards created while their database has a key are encrypted with it and
+// can only be opened with the same key. Shards created before a key was set
+// stay unencrypted. Must be called before Open.
+func (s *Server) SetShardKeys(keys map[string][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opened() {
+		return ErrServerOpen
+	}
+
+	m := make(map[string]cipher.AEAD, len(keys))
+	for name, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return ErrInvalidShardKey
+		}
+		m[name] = aead
+	}
+	s.shardKeys = m
+	return nil
+}
+
+// SetPeerTLSConfig sets how HTTPS peers are verified when joining a cluster.
+// Peers are verified against the host's root certificates when not set.
+func (s *Server) SetPeerTLSConfig(c *PeerTLSConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peerTLS = c
+}
+
+// SetClusterSecret sets the secret that data nodes send to each other so that
+// requests between them are accepted when authentication is enabled. Every
+// data node in the cluster must use the same secret.
+func (s *Server) SetClusterSecret(secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clusterSecret = secret
+}
+
+// authenticatePeer returns true if a request carries the cluster secret.
+func (s *Server) authenticatePeer(r *http.Request) bool {
+	s.mu.RLock()
+	secret := s.clusterSecret
+	s.mu.RUnlock()
+
+	v := r.Header.Get(ClusterSecretHeader)
+	return secret != "" && v != "" && subtle.ConstantTimeCompare([]byte(v), []byte(secret)) == 1
+}
+
+// SetTagNormalizer sets the rules used to normalize the tags of incoming
+// points before series are created. Tags are used as-is when not set.
+func (s *Server) SetTagNormalizer(n *TagNormalizer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tagNormalizer = n
+}
+
+// SetMaxQueryCost sets the budget for the estimated cost of select statements.
+// Statements estimated to read more than n shard, series and time bucket
+// combinations are rejected. A budget of zero disables the check.
+func (s *Server) SetMaxQueryCost(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxQueryCost = n
+}
+
+// SetBcryptCost sets the cost of the bcrypt hashes of user passwords. Hashes
+// are generated when users are applied so a higher cost slows down user
+// creation on every data node. Returns ErrInvalidBcryptCost if the cost is out
+// of the range allowed by bcrypt.
+func (s *Server) SetBcryptCost(cost int) error {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return ErrInvalidBcryptCost
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bcryptCost = cost
+	return nil
+}
+
+// SetPasswordPolicy sets the rules that passwords must follow when users are
+// created or their password is changed. The policy is enforced as users are
+// applied so it should be the same on every data node.
+func (s *Server) SetPasswordPolicy(p PasswordPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.passwordPolicy = p
+}
+
+// SetBootstrapAdmin sets an admin user that Initialize creates when the
+// cluster is first started so that it can run with authentication enabled
+// from the start. A blank username disables the bootstrap admin.
+func (s *Server) SetBootstrapAdmin(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bootstrapAdmin = nil
+	if username != "" {
+		s.bootstrapAdmin = &createUserCommand{Username: username, Password: password, Admin: true}
+	}
+}
+
+// hashPassword generates a hash of a password with the server's bcrypt cost.
+// This function must be called under a lock.
+func (s *Server) hashPassword(password string) ([]byte, error) {
+	cost := s.bcryptCost
+	if cost == 0 {
+		cost = BcryptCost
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), cost)
+}
+
+// SetShardReorderBufferSize sets the number of writes each local shard holds
+// before sorting them by series and timestamp and writing them together. This
+// improves locality for mostly-ordered streams. Buffered writes are flushed
+// whenever the shard is read so queries always see them. Each buffered write
+// is also appended to the shard's write-ahead log, which is replayed into the
+// store when the shard is next opened. A size of zero disables buffering.
+func (s *Server) SetShardReorderBufferSize(n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reorderBufferSize = n
+	for id, sh := range s.shards {
+		if err := sh.setReorderBufferSize(n); err != nil {
+			return fmt.Errorf("shard(%d): %s", id, err)
+		}
+	}
+	return nil
+}
+
 // shardPath returns the path for a shard.
 func (s *Server) shardPath(id uint64) string {
 	if s.path == "" {
@@ -160,9 +481,61 @@ func (s *Server) Open(path string) error {
 	// Set the server path.
 	s.path = path
 
+	// Open the shards stored on this server.
+	if err := s.openShards(); err != nil {
+		return fmt.Errorf("shards: %s", err)
+	}
+
+	return nil
+}
+
+// openShards opens the stores of the shards assigned to this server. Any
+// writes left in a shard's write-ahead log are written to its store.
+func (s *Server) openShards() error {
+	for id, sh := range s.shards {
+		if !s.storesShard(sh) {
+			continue
+		}
+		var database string
+		if db, _ := s.shardPolicy(id); db != nil {
+			database = db.name
+		}
+		if err := s.openShard(sh, database, false); err != nil {
+			return fmt.Errorf("shard(%d): %s", id, err)
+		}
+	}
+	return nil
+}
+
+// openShard opens the store of a shard in a database. New shards are
+// encrypted if the database has a shard key.
+// This function must be called under a lock.
+func (s *Server) openShard(sh *Shard, database string, created bool) error {
+	key := s.shardKeys[database]
+	if err := sh.open(s.shardPath(sh.ID), s.storage, key); err != nil {
+		return err
+	}
+	if created && key != nil {
+		if err := sh.encrypt(key); err != nil {
+			_ = sh.close()
+			return err
+		}
+	}
+	_ = sh.setReorderBufferSize(s.reorderBufferSize)
 	return nil
 }
 
+// removeShardFiles removes the store and write-ahead log of a shard.
+func (s *Server) removeShardFiles(id uint64) {
+	path := s.shardPath(id)
+	if err := os.RemoveAll(path); err != nil {
+		s.Logger.Warnf("unable to remove shard: id=%d, err=%s", id, err)
+	}
+	if err := os.RemoveAll(walPath(path)); err != nil {
+		s.Logger.Warnf("unable to remove shard wal: id=%d, err=%s", id, err)
+	}
+}
+
 // opened returns true when the server is open.
 func (s *Server) opened() bool { return s.path != "" }
 
@@ -175,12 +548,61 @@ func (s *Server) Close() error {
 		return ErrServerClosed
 	}
 
-	// Remove path.
+	// Remove path and wake any callers waiting in Sync.
 	s.path = ""
+	s.notifyApplied()
+
+	// Stop retention policy enforcement.
+	if s.retentionDone != nil {
+		close(s.retentionDone)
+		s.retentionDone = nil
+	}
+
+	// Stop shard compaction.
+	if s.compactionDone != nil {
+		close(s.compactionDone)
+		s.compactionDone = nil
+	}
+
+	// Stop continuous query scheduling.
+	if s.continuousQueryDone != nil {
+		close(s.continuousQueryDone)
+		s.continuousQueryDone = nil
+	}
+
+	// Stop metastore backups.
+	if s.metaBackupDone != nil {
+		close(s.metaBackupDone)
+		s.metaBackupDone = nil
+	}
+
+	// Stop broadcast compaction.
+	if s.broadcastDone != nil {
+		close(s.broadcastDone)
+		s.broadcastDone = nil
+	}
+
+	// Stop tag cardinality checks.
+	if s.cardinalityDone != nil {
+		close(s.cardinalityDone)
+		s.cardinalityDone = nil
+	}
+
+	// Stop publishing queued writes. They remain in the queue's log.
+	if s.writeQueueDone != nil {
+		close(s.writeQueueDone)
+		_ = s.writeQueue.close()
+		s.writeQueue, s.writeQueueDone = nil, nil
+	}
 
 	// Close message processing.
 	s.setClient(nil)
 
+	// Close shards so buffered writes are written to their stores.
+	for _, sh := range s.shards {
+		_ = sh.close()
+	}
+
 	// Close metastore.
 	_ = s.meta.close()
 
@@ -190,16 +612,31 @@ func (s *Server) Close() error {
 // load reads the state of the server from the metastore.
 func (s *Server) load() error {
 	return s.meta.view(func(tx *metatx) error {
-		// Read server id.
+		// Read server id and the last broadcast message applied.
 		s.id = tx.id()
+		s.metaIndex = tx.index()
+
+		// Load data nodes.
+		s.dataNodes = make(map[uint64]*DataNode)
+		for _, n := range tx.dataNodes() {
+			s.dataNodes[n.ID] = n
+		}
 
-		// Load databases.
+		// Load databases and their shards.
 		s.databases = make(map[string]*database)
+		s.shards = make(map[uint64]*Shard)
 		for _, db := range tx.databases() {
 			s.databases[db.name] = db
+			for _, rp := range db.policies {
+				for _, g := range rp.shardGroups {
+					for _, sh := range g.Shards {
+						s.shards[sh.ID] = sh
+					}
+				}
+			}
 
 			// load the index
-			log.Printf("Loading metadata index for %s\n", db.name)
+			s.Logger.Infof("loading metadata index for %s", db.name)
 			err := s.meta.view(func(tx *metatx) error {
 				tx.indexDatabase(db)
 				return nil
@@ -215,6 +652,39 @@ func (s *Server) load() error {
 			s.users[u.Name] = u
 		}
 
+		// Load continuous queries.
+		s.continuousQueries = make(map[string]*ContinuousQuery)
+		for _, cq := range tx.continuousQueries() {
+			s.continuousQueries[cq.Name] = cq
+		}
+
+		// Load continuous query leases.
+		// Leases are treated as just renewed since this node doesn't know
+		// when they were last applied.
+		s.continuousQueryLeases = make(map[string]*ContinuousQueryLease)
+		s.systemLeases = make(map[string]*ContinuousQueryLease)
+		for _, system := range []bool{false, true} {
+			for _, l := range tx.continuousQueryLeases(system) {
+				l.renewed = s.clock.Now()
+				s.leases(system)[l.Name] = l
+			}
+		}
+
+		// Load write blocks.
+		s.writeBlocks = make(map[string]*WriteBlock)
+		for _, b := range tx.writeBlocks() {
+			if err := b.compile(); err != nil {
+				return err
+			}
+			s.writeBlocks[b.String()] = b
+		}
+
+		// Load tokens.
+		s.tokens = make(map[string]*Token)
+		for _, t := range tx.tokens() {
+			s.tokens[t.Hash] = t
+		}
+
 		return nil
 	})
 }
@@ -262,6 +732,11 @@ func (s *Server) setClient(client MessagingClient) error {
 // This function waits until the message has been processed by the server.
 // Returns the broker log index of the message or an error.
 func (s *Server) broadcast(typ messaging.MessageType, c interface{}) (uint64, error) {
+	// Only send messages every data node can apply.
+	if err := s.checkMessageFormat(typ); err != nil {
+		return 0, err
+	}
+
 	// Encode the command.
 	data, err := json.Marshal(c)
 	if err != nil {
@@ -274,7 +749,7 @@ func (s *Server) broadcast(typ messaging.MessageType, c interface{}) (uint64, er
 		TopicID: messaging.BroadcastTopicID,
 		Data:    data,
 	}
-	index, err := s.client.Publish(m)
+	index, err := s.publish(m)
 	if err != nil {
 		return 0, err
 	}
@@ -285,28 +760,76 @@ func (s *Server) broadcast(typ messaging.MessageType, c interface{}) (uint64, er
 	return index, err
 }
 
+// publish sends a message to the broker and records how long it took.
+func (s *Server) publish(m *messaging.Message) (uint64, error) {
+	defer s.publishLatency.observeSince(time.Now())
+	index, err := s.client.Publish(m)
+	if err == nil {
+		s.counters.addPublish(index)
+	}
+	return index, err
+}
+
 // Sync blocks until a given index (or a higher index) has been applied.
 // Returns any error associated with the command.
 func (s *Server) Sync(index uint64) error {
+	return s.SyncTimeout(index, 0)
+}
+
+// SyncTimeout blocks until a given index (or a higher index) has been applied
+// or until the timeout elapses. A zero timeout waits indefinitely.
+// Returns any error associated with the command, ErrSyncTimeout if the index
+// was not applied in time, ErrUpgradeRequired if the data node can't apply the
+// messages up to the index or ErrServerClosed if the server closes first.
+func (s *Server) SyncTimeout(index uint64, timeout time.Duration) error {
+	defer s.syncLatency.observeSince(time.Now())
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+
 	for {
 		// Check if index has occurred. If so, retrieve the error and return.
-		s.mu.RLock()
+		s.mu.Lock()
 		if s.index >= index {
-			err, ok := s.errors[index]
-			if ok {
+			var err error
+			if e, ok := s.errors[index]; ok {
+				err = e.Err
 				delete(s.errors, index)
 			}
-			s.mu.RUnlock()
+			s.mu.Unlock()
 			return err
+		} else if !s.opened() {
+			s.mu.Unlock()
+			return ErrServerClosed
+		} else if s.upgradeIndex != 0 && index >= s.upgradeIndex {
+			s.mu.Unlock()
+			return ErrUpgradeRequired
 		}
-		s.mu.RUnlock()
+		applied := s.applied
+		s.mu.Unlock()
 
-		// Otherwise wait momentarily and check again.
-		time.Sleep(1 * time.Millisecond)
+		// Otherwise wait for the index to change and check again.
+		select {
+		case <-applied:
+		case <-expired:
+			return ErrSyncTimeout
+		}
 	}
 }
 
+// notifyApplied wakes any callers waiting in Sync.
+// This function must be called under a write lock.
+func (s *Server) notifyApplied() {
+	close(s.applied)
+	s.applied = make(chan struct{})
+}
+
 // Initialize creates a new data node and initializes the server's id to 1.
+// The bootstrap admin is created, if set, so the new cluster has an admin.
 func (s *Server) Initialize(u *url.URL) error {
 	// Create a new data node.
 	if err := s.CreateDataNode(u); err != nil {
@@ -329,32 +852,146 @@ func (s *Server) Initialize(u *url.URL) error {
 	// Set the ID on the server.
 	s.id = 1
 
+	// Create the bootstrap admin, if set.
+	s.mu.RLock()
+	admin := s.bootstrapAdmin
+	s.mu.RUnlock()
+	if admin != nil {
+		if err := s.CreateUser(admin.Username, admin.Password, true); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// PeerTLSConfig represents how a server verifies HTTPS peers.
+type PeerTLSConfig struct {
+	// Certificate authorities used to verify peers.
+	// The host's root certificates are used if nil.
+	RootCAs *x509.CertPool
+
+	// Base64 encoded SHA-256 hashes of peer public keys. When set, the peer's
+	// certificate chain must contain a key matching one of the pins.
+	Pins []string
+
+	// Skips certificate chain verification so self-signed peers can be
+	// trusted by pin alone. Ignored unless pins are set.
+	InsecureSkipVerify bool
+}
+
+// dialTLS connects to a peer and verifies its certificate chain and pins.
+func (c *PeerTLSConfig) dialTLS(network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the default verification if there is no config.
+	config := &tls.Config{ServerName: host}
+	if c != nil {
+		config.RootCAs = c.RootCAs
+		config.InsecureSkipVerify = c.InsecureSkipVerify && len(c.Pins) > 0
+	}
+
+	// Connect and verify the certificate chain.
+	conn, err := tls.Dial(network, addr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify the chain contains a pinned key.
+	if c != nil && len(c.Pins) > 0 && !c.pinned(conn.ConnectionState().PeerCertificates) {
+		_ = conn.Close()
+		return nil, ErrPeerCertificateNotPinned
+	}
+
+	return conn, nil
+}
+
+// pinned returns true if any certificate's public key matches a pin.
+func (c *PeerTLSConfig) pinned(certs []*x509.Certificate) bool {
+	for _, cert := range certs {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		pin := base64.StdEncoding.EncodeToString(sum[:])
+		for _, p := range c.Pins {
+			if p == pin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// peerClient returns an HTTP client for requests to other data nodes.
+// HTTPS peers are verified with the configured roots and pins and requests
+// carry the cluster secret, if set.
+// This function must be called under a lock.
+func (s *Server) peerClient() *http.Client {
+	return &http.Client{Transport: &peerTransport{
+		transport: &http.Transport{DialTLS: s.peerTLS.dialTLS},
+		secret:    s.clusterSecret,
+	}}
+}
+
+// peerTransport adds the cluster secret to requests to other data nodes.
+type peerTransport struct {
+	transport http.RoundTripper
+	secret    string
+}
+
+// RoundTrip sends a copy of the request with the cluster secret header set.
+func (t *peerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.secret == "" {
+		return t.transport.RoundTrip(r)
+	}
+
+	other := new(http.Request)
+	*other = *r
+	other.Header = make(http.Header, len(r.Header)+1)
+	for k, v := range r.Header {
+		other.Header[k] = v
+	}
+	other.Header.Set(ClusterSecretHeader, t.secret)
+	return t.transport.RoundTrip(other)
+}
+
 // Join creates a new data node in an existing cluster, copies the metastore,
 // and initializes the ID.
 func (s *Server) Join(u *url.URL, joinURL *url.URL) error {
+	return s.join(u, joinURL, false)
+}
+
+// JoinStandby joins an existing cluster as a standby data node.
+func (s *Server) JoinStandby(u *url.URL, joinURL *url.URL) error {
+	return s.join(u, joinURL, true)
+}
+
+func (s *Server) join(u *url.URL, joinURL *url.URL, standby bool) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// Verify HTTPS peers with the configured roots and pins.
+	client := s.peerClient()
+
 	// Encode data node request.
 	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(&dataNodeJSON{URL: u.String()}); err != nil {
+	if err := json.NewEncoder(&buf).Encode(&dataNodeJSON{URL: u.String(), Standby: standby, Version: s.version, MessageFormat: MessageFormat}); err != nil {
 		return err
 	}
 
 	// Send request.
 	joinURL = copyURL(joinURL)
 	joinURL.Path = "/data_nodes"
-	resp, err := http.Post(joinURL.String(), "application/octet-stream", &buf)
+	resp, err := client.Post(joinURL.String(), "application/octet-stream", &buf)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	// Check if created.
-	if resp.StatusCode != http.StatusCreated {
+	// Check if created. An OK status means the data node had already joined
+	// and its existing ID was returned.
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
 		return ErrUnableToJoin
 	}
 
@@ -365,13 +1002,20 @@ func (s *Server) Join(u *url.URL, joinURL *url.URL) error {
 	}
 	assert(n.ID > 0, "invalid join node id returned: %d", n.ID)
 
-	// Download the metastore from joining server.
+	// Download the metastore from joining server so the new node starts
+	// from a snapshot instead of replaying the broadcast topic.
 	joinURL.Path = "/metastore"
-	resp, err = http.Post(joinURL.String(), "application/octet-stream", &buf)
+	resp, err = client.Get(joinURL.String())
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ErrUnableToJoin
+	}
+	if err := s.installMetastore(resp.Body); err != nil {
+		return fmt.Errorf("install metastore: %s", err)
+	}
 
 	// Update the ID on the metastore.
 	if err := s.meta.mustUpdate(func(tx *metatx) error {
@@ -386,20 +1030,285 @@ func (s *Server) Join(u *url.URL, joinURL *url.URL) error {
 	return nil
 }
 
-// CopyMetastore writes the underlying metastore data file to a writer.
-func (s *Server) CopyMetastore(w io.Writer) error {
-	return s.meta.mustView(func(tx *metatx) error {
-		// Set content lengh if this is a HTTP connection.
-		if w, ok := w.(http.ResponseWriter); ok {
-			w.Header().Set("Content-Length", strconv.Itoa(int(tx.Size())))
-		}
+// installMetastore replaces the metastore with a copy written by
+// CopyMetastore and reloads the server's state from it.
+// This function must be called under a lock.
+func (s *Server) installMetastore(r io.Reader) error {
+	path := filepath.Join(s.path, "meta")
 
-		// Write entire database to the writer.
-		return tx.Copy(w)
-	})
-}
+	// Write the copy next to the current metastore.
+	f, err := os.OpenFile(path+".install", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
 
-// DataNode returns a data node by id.
+	// Swap the files and reload.
+	_ = s.meta.close()
+	if err := os.Rename(path+".install", path); err != nil {
+		return err
+	}
+	if err := s.meta.open(path); err != nil {
+		return err
+	}
+	if err := s.load(); err != nil {
+		return err
+	}
+	return s.openShards()
+}
+
+// MetastoreIndex returns the index of the last broadcast message applied to
+// the metastore.
+func (s *Server) MetastoreIndex() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.metaIndex
+}
+
+// CopyMetastore writes the underlying metastore data file to a writer. HTTP
+// responses also include the index of the last broadcast message applied to
+// the copy in the "X-InfluxDB-Index" header.
+func (s *Server) CopyMetastore(w io.Writer) error {
+	return s.meta.mustView(func(tx *metatx) error {
+		// Set content lengh if this is a HTTP connection.
+		if w, ok := w.(http.ResponseWriter); ok {
+			w.Header().Set("Content-Length", strconv.Itoa(int(tx.Size())))
+			w.Header().Set("X-InfluxDB-Index", strconv.FormatUint(tx.index(), 10))
+		}
+
+		// Write entire database to the writer.
+		return tx.Copy(w)
+	})
+}
+
+// Backup writes a snapshot of the metastore and every shard stored on this
+// server to w as a tar archive. Read transactions are started on all files
+// before any are copied so the snapshot is consistent across files. Writes
+// that grow a shard's file may block until the backup completes.
+func (s *Server) Backup(w io.Writer) error {
+	type file struct {
+		name string
+		tx   interface {
+			Size() int64
+			Copy(io.Writer) error
+			Rollback() error
+		}
+	}
+	var files []file
+
+	// Roll back all transactions once the archive is written.
+	defer func() {
+		for _, f := range files {
+			_ = f.tx.Rollback()
+		}
+	}()
+
+	// Start transactions under lock so no messages are applied in between.
+	if err := func() error {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		if !s.opened() {
+			return ErrServerClosed
+		}
+
+		tx, err := s.meta.db.Begin(false)
+		if err != nil {
+			return fmt.Errorf("meta: %s", err)
+		}
+		files = append(files, file{name: "meta", tx: tx})
+
+		ids := make([]uint64, 0, len(s.shards))
+		for id := range s.shards {
+			ids = append(ids, id)
+		}
+		sort.Sort(uint64Slice(ids))
+
+		for _, id := range ids {
+			tx, err := s.shards[id].begin()
+			if err != nil {
+				return fmt.Errorf("shard(%d): %s", id, err)
+			}
+			files = append(files, file{name: path.Join("shards", strconv.FormatUint(id, 10)), tx: tx})
+		}
+		return nil
+	}(); err != nil {
+		return err
+	}
+
+	// Write each file to the archive.
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0600, Size: f.tx.Size(), ModTime: now}); err != nil {
+			return err
+		} else if err := f.tx.Copy(tw); err != nil {
+			return fmt.Errorf("%s: %s", f.name, err)
+		}
+	}
+	return tw.Close()
+}
+
+// Restore rebuilds a data directory from an archive written by Backup.
+// The server must not be running against the directory. Returns
+// ErrRestorePathExists if the directory already contains a metastore.
+func Restore(r io.Reader, dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, "meta")); err == nil {
+		return ErrRestorePathExists
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "shards"), 0700); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// Only the metastore and numbered shard files are restored.
+		name := path.Clean(hdr.Name)
+		if name != "meta" {
+			if parent, base := path.Split(name); parent != "shards/" {
+				return fmt.Errorf("invalid backup file: %s", hdr.Name)
+			} else if _, err := strconv.ParseUint(base, 10, 64); err != nil {
+				return fmt.Errorf("invalid backup file: %s", hdr.Name)
+			}
+		}
+
+		if err := restoreFile(tr, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+}
+
+// restoreFile copies the contents of a reader to a new file.
+func restoreFile(r io.Reader, filename string) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// metastoreBackupPrefix is the file name prefix of metastore snapshots.
+const metastoreBackupPrefix = "meta-"
+
+// BackupMetastore writes a snapshot of the metastore to a new timestamped
+// file in dir and then removes the oldest snapshots in dir so that at most
+// n remain. If n is zero then no snapshots are removed. Returns the path of
+// the new snapshot.
+func (s *Server) BackupMetastore(dir string, n int) (string, error) {
+	if dir == "" {
+		return "", ErrPathRequired
+	} else if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	// Write the snapshot to a temporary file and move it into place once
+	// it is complete so a partial snapshot is never mistaken for a backup.
+	filename := filepath.Join(dir, metastoreBackupPrefix+time.Now().UTC().Format("20060102T150405.000000000Z"))
+	if err := func() error {
+		f, err := os.OpenFile(filename+".tmp", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if !s.opened() {
+			return ErrServerClosed
+		}
+
+		if err := s.meta.view(func(tx *metatx) error { return tx.Copy(f) }); err != nil {
+			return err
+		}
+		return f.Sync()
+	}(); err != nil {
+		_ = os.Remove(filename + ".tmp")
+		return "", err
+	}
+	if err := os.Rename(filename+".tmp", filename); err != nil {
+		return "", err
+	}
+
+	// Remove the oldest snapshots. Names sort in the order they were taken.
+	if n > 0 {
+		names, err := filepath.Glob(filepath.Join(dir, metastoreBackupPrefix+"*Z"))
+		if err != nil {
+			return "", err
+		}
+		sort.Strings(names)
+		for len(names) > n {
+			if err := os.Remove(names[0]); err != nil {
+				return "", err
+			}
+			names = names[1:]
+		}
+	}
+
+	return filename, nil
+}
+
+// StartMetastoreBackups starts a background loop that snapshots the metastore
+// to dir once every interval, keeping the n most recent snapshots. The loop
+// stops when the server is closed or when backups are started again.
+func (s *Server) StartMetastoreBackups(dir string, interval time.Duration, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.opened() {
+		return ErrServerClosed
+	} else if dir == "" {
+		return ErrPathRequired
+	} else if interval <= 0 {
+		return ErrInvalidMetastoreBackupInterval
+	}
+
+	// Stop previous loop, if running.
+	if s.metaBackupDone != nil {
+		close(s.metaBackupDone)
+	}
+
+	done := make(chan struct{}, 0)
+	s.metaBackupDone = done
+	go s.backupMetastore(dir, s.clock.NewTicker(interval), n, done)
+
+	return nil
+}
+
+// backupMetastore runs in a separate goroutine and snapshots the metastore
+// on every tick until done is closed.
+func (s *Server) backupMetastore(dir string, ticker Ticker, n int, done chan struct{}) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C():
+			if _, err := s.BackupMetastore(dir, n); err != nil {
+				s.Logger.Errorf("metastore backup: %s", err)
+			}
+		}
+	}
+}
+
+// DataNode returns a data node by id.
 func (s *Server) DataNode(id uint64) *DataNode {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -431,7 +1340,28 @@ func (s *Server) DataNodes() (a []*DataNode) {
 
 // CreateDataNode creates a new data node with a given URL.
 func (s *Server) CreateDataNode(u *url.URL) error {
-	c := &createDataNodeCommand{URL: u.String()}
+	return s.createDataNode(&createDataNodeCommand{URL: u.String()})
+}
+
+// CreateStandbyDataNode creates a new standby data node with a given URL.
+// A standby subscribes to every shard and applies its writes but is never
+// assigned shards and rejects queries. It can take over the shards of a
+// failed data node with PromoteDataNode without copying any data.
+func (s *Server) CreateStandbyDataNode(u *url.URL) error {
+	return s.createDataNode(&createDataNodeCommand{URL: u.String(), Standby: true})
+}
+
+// createDataNode creates a data node that runs the same version as this one.
+func (s *Server) createDataNode(c *createDataNodeCommand) error {
+	s.mu.RLock()
+	c.Version, c.MessageFormat = s.version, MessageFormat
+	s.mu.RUnlock()
+	return s.joinDataNode(c)
+}
+
+// joinDataNode creates a data node with the version and message format
+// reported by a joining data node.
+func (s *Server) joinDataNode(c *createDataNodeCommand) error {
 	_, err := s.broadcast(createDataNodeMessageType, c)
 	return err
 }
@@ -459,6 +1389,8 @@ func (s *Server) applyCreateDataNode(m *messaging.Message) (err error) {
 	// Create data node.
 	n := newDataNode()
 	n.URL = u
+	n.Standby = c.Standby
+	n.Version, n.MessageFormat = c.Version, c.MessageFormat
 
 	// Persist to metastore.
 	err = s.meta.mustUpdate(func(tx *metatx) error {
@@ -473,7 +1405,132 @@ func (s *Server) applyCreateDataNode(m *messaging.Message) (err error) {
 }
 
 type createDataNodeCommand struct {
-	URL string `json:"url"`
+	URL           string `json:"url"`
+	Standby       bool   `json:"standby,omitempty"`
+	Version       string `json:"version,omitempty"`
+	MessageFormat int    `json:"messageFormat,omitempty"`
+}
+
+// PromoteDataNode turns a standby data node into a regular data node that
+// owns every shard previously owned by another data node. The standby already
+// stores the shards so no data is copied. The replaced data node stops
+// receiving writes and removes its copy of the shards but is not deleted.
+// Returns the index of the broadcast message.
+func (s *Server) PromoteDataNode(id, replaceID uint64) (uint64, error) {
+	c := &promoteDataNodeCommand{ID: id, ReplaceID: replaceID}
+	return s.broadcast(promoteDataNodeMessageType, c)
+}
+
+func (s *Server) applyPromoteDataNode(m *messaging.Message) error {
+	var c promoteDataNodeCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate the standby and the node it replaces.
+	n := s.dataNodes[c.ID]
+	if n == nil {
+		return ErrDataNodeNotFound
+	} else if !n.Standby {
+		return ErrDataNodeNotStandby
+	}
+	if r := s.dataNodes[c.ReplaceID]; r == nil {
+		return ErrDataNodeNotFound
+	} else if r.Standby {
+		return ErrDataNodeStandby
+	}
+
+	// Note which shards are stored locally before ownership changes.
+	stored := make(map[uint64]bool, len(s.shards))
+	for id, sh := range s.shards {
+		stored[id] = s.storesShard(sh)
+	}
+
+	// Replace the old owner in every shard and persist the changed databases.
+	n.Standby = false
+	changed := make(map[*database]bool)
+	for id, sh := range s.shards {
+		for i, nodeID := range sh.DataNodeIDs {
+			if nodeID == c.ReplaceID {
+				sh.DataNodeIDs[i] = c.ID
+				if db, _ := s.shardPolicy(id); db != nil {
+					changed[db] = true
+				}
+			}
+		}
+	}
+	if err := s.meta.mustUpdate(func(tx *metatx) error {
+		for db := range changed {
+			if err := tx.saveDatabase(db); err != nil {
+				return err
+			}
+		}
+		return tx.saveDataNode(n)
+	}); err != nil {
+		return err
+	}
+
+	// Drop the shards this server no longer stores.
+	for id, sh := range s.shards {
+		s.updateLocalShard(sh, stored[id])
+	}
+
+	return nil
+}
+
+type promoteDataNodeCommand struct {
+	ID        uint64 `json:"id"`
+	ReplaceID uint64 `json:"replaceID"`
+}
+
+// standby returns true if this server is a standby data node.
+// This function must be called under a lock.
+func (s *Server) standby() bool {
+	n := s.dataNodes[s.id]
+	return n != nil && n.Standby
+}
+
+// Standby returns true if this server is a standby data node.
+func (s *Server) Standby() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.standby()
+}
+
+// storesShard returns true if this server keeps a copy of a shard, either as
+// an owner or as a standby.
+// This function must be called under a lock.
+func (s *Server) storesShard(sh *Shard) bool {
+	return sh.HasDataNodeID(s.id) || s.standby()
+}
+
+// updateLocalShard opens or removes the local copy of a shard after its owners
+// change. wasStored is whether the server stored the shard before the change.
+// This function must be called under a lock.
+func (s *Server) updateLocalShard(sh *Shard, wasStored bool) {
+	isStored := s.storesShard(sh)
+
+	if !wasStored && isStored {
+		// Open an empty store and start receiving writes.
+		var database string
+		if db, _ := s.shardPolicy(sh.ID); db != nil {
+			database = db.name
+		}
+		if err := s.openShard(sh, database, true); err != nil {
+			panic("unable to open shard: " + err.Error())
+		}
+		if err := s.client.Subscribe(s.id, sh.ID); err != nil {
+			s.Logger.Errorf("unable to subscribe: replica=%d, topic=%d, err=%s", s.id, sh.ID, err)
+		}
+	} else if wasStored && !isStored {
+		// Stop receiving writes and remove the shard's data.
+		if err := s.client.Unsubscribe(s.id, sh.ID); err != nil {
+			s.Logger.Errorf("unable to unsubscribe: replica=%d, topic=%d, err=%s", s.id, sh.ID, err)
+		}
+		_ = sh.close()
+		s.removeShardFiles(sh.ID)
+	}
 }
 
 // DeleteDataNode deletes an existing data node.
@@ -500,6 +1557,18 @@ func (s *Server) applyDeleteDataNode(m *messaging.Message) (err error) {
 	// Delete the node.
 	delete(s.dataNodes, n.ID)
 
+	// Release any continuous query leases held by the node so another node
+	// can take over without waiting for the leases to expire.
+	for _, system := range []bool{false, true} {
+		leases := s.leases(system)
+		for name, l := range leases {
+			if l.DataNodeID == n.ID {
+				s.meta.mustUpdate(func(tx *metatx) error { return tx.deleteContinuousQueryLease(name, system) })
+				delete(leases, name)
+			}
+		}
+	}
+
 	return
 }
 
@@ -538,6 +1607,12 @@ func (s *Server) applyCreateDatabase(m *messaging.Message) (err error) {
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.meta.mustUpdate(func(tx *metatx) error { return s.createDatabase(tx, &c) })
+}
+
+// createDatabase creates a database and persists it in tx.
+// This function must be called under a lock.
+func (s *Server) createDatabase(tx *metatx, c *createDatabaseCommand) error {
 	if s.databases[c.Name] != nil {
 		return ErrDatabaseExists
 	}
@@ -547,18 +1622,32 @@ func (s *Server) applyCreateDatabase(m *messaging.Message) (err error) {
 	db.name = c.Name
 
 	// Persist to metastore.
-	err = s.meta.mustUpdate(func(tx *metatx) error { return tx.saveDatabase(db) })
+	if err := tx.saveDatabase(db); err != nil {
+		return err
+	}
 
 	// Add to databases on server.
 	s.databases[c.Name] = db
-
-	return
+	return nil
 }
 
 type createDatabaseCommand struct {
 	Name string `json:"name"`
 }
 
+// CreateDatabaseWithRetentionPolicy creates a database along with a retention
+// policy that is set as its default, so the database can be written to as
+// soon as it exists. Either both are created or neither is.
+func (s *Server) CreateDatabaseWithRetentionPolicy(name string, rp *RetentionPolicy) error {
+	c := &metadataBatchCommand{}
+	c.addCreateDatabase(0, name, rp)
+	_, err := s.broadcast(metadataBatchMessageType, c)
+	if e, ok := err.(*batchError); ok {
+		return e.err
+	}
+	return err
+}
+
 // DeleteDatabase deletes an existing database.
 func (s *Server) DeleteDatabase(name string) error {
 	c := &deleteDatabaseCommand{Name: name}
@@ -576,8 +1665,27 @@ func (s *Server) applyDeleteDatabase(m *messaging.Message) (err error) {
 		return ErrDatabaseNotFound
 	}
 
-	// Remove from metastore.
-	err = s.meta.mustUpdate(func(tx *metatx) error { return tx.deleteDatabase(c.Name) })
+	// Remove from metastore along with any user privileges on the database.
+	// Continuous queries on the database are removed as well.
+	err = s.meta.mustUpdate(func(tx *metatx) error {
+		for _, u := range s.users {
+			if _, ok := u.Privileges[c.Name]; ok {
+				delete(u.Privileges, c.Name)
+				if err := tx.saveUser(u); err != nil {
+					return err
+				}
+			}
+		}
+		for name, cq := range s.continuousQueries {
+			if cq.Database == c.Name {
+				if err := tx.deleteContinuousQuery(name); err != nil {
+					return err
+				}
+				delete(s.continuousQueries, name)
+			}
+		}
+		return tx.deleteDatabase(c.Name)
+	})
 
 	// Delete the database entry.
 	delete(s.databases, c.Name)
@@ -588,6 +1696,33 @@ type deleteDatabaseCommand struct {
 	Name string `json:"name"`
 }
 
+// ReindexDatabase rebuilds the in-memory measurement and series index of a
+// database from the series stored in the metastore. Only the index on this
+// server is rebuilt.
+func (s *Server) ReindexDatabase(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	db := s.databases[name]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Build the new index separately and then swap it in.
+	other := newDatabase()
+	other.name = db.name
+	if err := s.meta.view(func(tx *metatx) error {
+		tx.indexDatabase(other)
+		return nil
+	}); err != nil {
+		return err
+	}
+	db.replaceIndex(other)
+
+	s.Logger.Infof("reindexed database %s: %d measurements, %d series", name, len(db.measurements), len(db.series))
+	return nil
+}
+
 // Shard returns a shard by ID.
 func (s *Server) Shard(id uint64) *Shard {
 	s.mu.RLock()
@@ -595,38 +1730,149 @@ func (s *Server) Shard(id uint64) *Shard {
 	return s.shards[id]
 }
 
-// shardGroupByTimestamp returns a group for a database, policy & timestamp.
-func (s *Server) shardGroupByTimestamp(database, policy string, timestamp time.Time) (*ShardGroup, error) {
-	db := s.databases[database]
-	if db == nil {
-		return nil, ErrDatabaseNotFound
+// ShardStats returns the write counters for each shard stored on this
+// server, ordered by shard id.
+func (s *Server) ShardStats() []ShardStats {
+	s.mu.RLock()
+	ids := make([]uint64, 0, len(s.shards))
+	for id, sh := range s.shards {
+		if sh.HasDataNodeID(s.id) {
+			ids = append(ids, id)
+		}
 	}
-	return db.shardGroupByTimestamp(policy, timestamp)
+	sort.Sort(uint64Slice(ids))
+
+	a := make([]ShardStats, len(ids))
+	for i, id := range ids {
+		a[i] = s.shards[id].Stats()
+	}
+	s.mu.RUnlock()
+	return a
 }
 
-// ShardGroups returns a list of all shard groups for a database.
-// Returns an error if the database doesn't exist.
-func (s *Server) ShardGroups(database string) ([]*ShardGroup, error) {
+// ShardOwnership returns the shards owned by each data node, ordered by data node id.
+func (s *Server) ShardOwnership() []*DataNodeShards {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	// Lookup database.
-	db := s.databases[database]
-	if db == nil {
-		return nil, ErrDatabaseNotFound
+	// Create an entry for every data node, even if it owns no shards.
+	a := make([]*DataNodeShards, 0, len(s.dataNodes))
+	m := make(map[uint64]*DataNodeShards, len(s.dataNodes))
+	for _, n := range s.dataNodes {
+		o := &DataNodeShards{DataNodeID: n.ID, URL: n.URL}
+		a = append(a, o)
+		m[n.ID] = o
 	}
+	sort.Sort(dataNodeShards(a))
 
-	// Retrieve groups from database.
-	var a []*ShardGroup
-	for _, rp := range db.policies {
-		for _, g := range rp.shardGroups {
-			a = append(a, g)
+	// Assign each shard to its owners.
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				for _, sh := range g.Shards {
+					info := &ShardInfo{
+						ID:              sh.ID,
+						Database:        db.name,
+						RetentionPolicy: rp.Name,
+						StartTime:       g.StartTime,
+						EndTime:         g.EndTime,
+					}
+					if sh.opened() {
+						n := sh.size()
+						info.Size = &n
+					}
+					for _, id := range sh.DataNodeIDs {
+						if o := m[id]; o != nil {
+							o.Shards = append(o.Shards, info)
+						}
+					}
+				}
+			}
 		}
 	}
-	return a, nil
-}
 
-// CreateShardGroupIfNotExist creates the shard group for a retention policy for the interval a timestamp falls into.
+	// Sort shards within each data node.
+	for _, o := range a {
+		sort.Sort(shardInfos(o.Shards))
+	}
+
+	return a
+}
+
+// ExpiredShardGroups returns the shard groups in every database that have passed
+// the duration of their retention policy as of now. These are the groups that
+// retention enforcement would drop. Sorted by database, policy & start time.
+func (s *Server) ExpiredShardGroups(now time.Time) []*ShardGroupInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var a []*ShardGroupInfo
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.expiredShardGroups(now) {
+				info := &ShardGroupInfo{
+					ID:              g.ID,
+					Database:        db.name,
+					RetentionPolicy: rp.Name,
+					StartTime:       g.StartTime,
+					EndTime:         g.EndTime,
+					ShardN:          len(g.Shards),
+				}
+				for _, sh := range g.Shards {
+					info.Size += sh.size()
+				}
+				a = append(a, info)
+			}
+		}
+	}
+	sort.Sort(shardGroupInfos(a))
+	return a
+}
+
+// shardGroupExpired returns true if a shard group has expired as of now and
+// will be deleted by the next retention policy enforcement.
+func (s *Server) shardGroupExpired(database, policy string, g *ShardGroup) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db := s.databases[database]
+	if db == nil || db.policies[policy] == nil {
+		return false
+	}
+	return db.policies[policy].expired(g, s.clock.Now())
+}
+
+// shardGroupByTimestamp returns a group for a database, policy & timestamp.
+func (s *Server) shardGroupByTimestamp(database, policy string, timestamp time.Time) (*ShardGroup, error) {
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+	return db.shardGroupByTimestamp(policy, timestamp)
+}
+
+// ShardGroups returns a list of all shard groups for a database.
+// Returns an error if the database doesn't exist.
+func (s *Server) ShardGroups(database string) ([]*ShardGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Lookup database.
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	// Retrieve groups from database.
+	var a []*ShardGroup
+	for _, rp := range db.policies {
+		for _, g := range rp.shardGroups {
+			a = append(a, g)
+		}
+	}
+	return a, nil
+}
+
+// CreateShardGroupIfNotExist creates the shard group for a retention policy for the interval a timestamp falls into.
 func (s *Server) CreateShardGroupIfNotExists(database, policy string, timestamp time.Time) error {
 	c := &createShardGroupIfNotExistsCommand{Database: database, Policy: policy, Timestamp: timestamp}
 	_, err := s.broadcast(createShardGroupIfNotExistsMessageType, c)
@@ -683,9 +1929,12 @@ func (s *Server) applyCreateShardGroupIfNotExists(m *messaging.Message) (err err
 	g.EndTime = g.StartTime.Add(rp.Duration).UTC()
 
 	// Sort nodes so they're consistently assigned to the shards.
+	// Standby nodes are never assigned shards.
 	nodes := make([]*DataNode, 0, len(s.dataNodes))
 	for _, n := range s.dataNodes {
-		nodes = append(nodes, n)
+		if !n.Standby {
+			nodes = append(nodes, n)
+		}
 	}
 	sort.Sort(dataNodes(nodes))
 
@@ -699,8 +1948,14 @@ func (s *Server) applyCreateShardGroupIfNotExists(m *messaging.Message) (err err
 
 	// Determine shard count by node count divided by replication factor.
 	// This will ensure nodes will get distributed across nodes evenly and
-	// replicated the correct number of times.
-	shardN := len(nodes) / replicaN
+	// replicated the correct number of times. The split factor multiplies
+	// the shards so writes to a group are spread over more stores.
+	splitN := int(rp.SplitN)
+	if splitN == 0 {
+		splitN = DefaultSplitN
+	}
+	shardN := (len(nodes) / replicaN) * splitN
+	g.Hash = rp.ShardHash
 
 	// Create a shard based on the node count and replication factor.
 	g.Shards = make([]*Shard, shardN)
@@ -738,12 +1993,12 @@ func (s *Server) applyCreateShardGroupIfNotExists(m *messaging.Message) (err err
 	// Open shards assigned to this server.
 	for _, sh := range g.Shards {
 		// Ignore if this server is not assigned.
-		if !sh.HasDataNodeID(s.id) {
+		if !s.storesShard(sh) {
 			continue
 		}
 
 		// Open shard store. Panic if an error occurs and we can retry.
-		if err := sh.open(s.shardPath(sh.ID)); err != nil {
+		if err := s.openShard(sh, c.Database, true); err != nil {
 			panic("unable to open shard: " + err.Error())
 		}
 	}
@@ -759,13 +2014,13 @@ func (s *Server) applyCreateShardGroupIfNotExists(m *messaging.Message) (err err
 	// TODO: Retry subscriptions on failure.
 	for _, sh := range g.Shards {
 		// Ignore if this server is not assigned.
-		if !sh.HasDataNodeID(s.id) {
+		if !s.storesShard(sh) {
 			continue
 		}
 
 		// Subscribe on the broker.
 		if err := s.client.Subscribe(s.id, sh.ID); err != nil {
-			log.Printf("unable to subscribe: replica=%d, topic=%d, err=%s", s.id, sh.ID, err)
+			s.Logger.Errorf("unable to subscribe: replica=%d, topic=%d, err=%s", s.id, sh.ID, err)
 		}
 	}
 
@@ -778,6 +2033,401 @@ type createShardGroupIfNotExistsCommand struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// DeleteShardGroup deletes a shard group from a retention policy. Shards in
+// the group are closed and their files are removed from every data node.
+func (s *Server) DeleteShardGroup(database, policy string, id uint64) error {
+	c := &deleteShardGroupCommand{Database: database, Policy: policy, ID: id}
+	_, err := s.broadcast(deleteShardGroupMessageType, c)
+	return err
+}
+
+func (s *Server) applyDeleteShardGroup(m *messaging.Message) error {
+	var c deleteShardGroupCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve database.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Retrieve retention policy.
+	rp := db.policies[c.Policy]
+	if rp == nil {
+		return ErrRetentionPolicyNotFound
+	}
+
+	// Remove the group from the policy and persist.
+	g := rp.removeShardGroupByID(c.ID)
+	if g == nil {
+		return ErrShardGroupNotFound
+	}
+	if err := s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	}); err != nil {
+		return err
+	}
+
+	// Close shards and remove the ones assigned to this server.
+	for _, sh := range g.Shards {
+		delete(s.shards, sh.ID)
+		_ = sh.close()
+
+		// Ignore if this server is not assigned.
+		if !s.storesShard(sh) {
+			continue
+		}
+
+		// Remove the shard's data. The processor stops receiving its writes
+		// once the message is applied.
+		s.removeShardFiles(sh.ID)
+		s.droppedShardIDs = append(s.droppedShardIDs, sh.ID)
+	}
+
+	return nil
+}
+
+type deleteShardGroupCommand struct {
+	Database string `json:"database"`
+	Policy   string `json:"policy"`
+	ID       uint64 `json:"id"`
+}
+
+// SetShardOwners replaces the data nodes that own a shard. Data nodes added to
+// the shard open an empty store and start receiving its writes. Data nodes
+// removed from the shard delete their copy of its data.
+func (s *Server) SetShardOwners(shardID uint64, dataNodeIDs []uint64) (uint64, error) {
+	c := &setShardOwnersCommand{ID: shardID, DataNodeIDs: dataNodeIDs}
+	return s.broadcast(setShardOwnersMessageType, c)
+}
+
+func (s *Server) applySetShardOwners(m *messaging.Message) error {
+	var c setShardOwnersCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve the shard and the database it's persisted with.
+	sh := s.shards[c.ID]
+	if sh == nil {
+		return ErrShardNotFound
+	}
+	db, _ := s.shardPolicy(c.ID)
+	if db == nil {
+		return ErrShardNotFound
+	}
+
+	// Validate the new owners.
+	if len(c.DataNodeIDs) == 0 {
+		return ErrDataNodeRequired
+	}
+	for _, id := range c.DataNodeIDs {
+		if n := s.dataNodes[id]; n == nil {
+			return ErrDataNodeNotFound
+		} else if n.Standby {
+			return ErrDataNodeStandby
+		}
+	}
+
+	// Update the owners and persist.
+	wasStored := s.storesShard(sh)
+	sh.DataNodeIDs = c.DataNodeIDs
+	if err := s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	}); err != nil {
+		return err
+	}
+	s.updateLocalShard(sh, wasStored)
+
+	return nil
+}
+
+type setShardOwnersCommand struct {
+	ID          uint64   `json:"id"`
+	DataNodeIDs []uint64 `json:"dataNodeIDs"`
+}
+
+// EnforceRetentionPolicies deletes every shard group that has expired as of now
+// and then drops series left without data, if enabled.
+// Deletion continues past failures and the first error is returned. Groups
+// that were deleted in the meantime, such as by dropping their policy, are
+// skipped.
+func (s *Server) EnforceRetentionPolicies(now time.Time) (err error) {
+	for _, g := range s.ExpiredShardGroups(now) {
+		if e := s.DeleteShardGroup(g.Database, g.RetentionPolicy, g.ID); e != nil && e != ErrShardGroupNotFound && err == nil {
+			err = fmt.Errorf("delete shard group(%s/%s/%d): %s", g.Database, g.RetentionPolicy, g.ID, e)
+		}
+	}
+
+	s.mu.RLock()
+	enabled := s.dropExpiredSeries
+	s.mu.RUnlock()
+	if enabled {
+		if e := s.DropExpiredSeries(now); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// SetDropExpiredSeries sets whether retention policy enforcement removes
+// series from the index and metastore once they have no data left in any
+// shard. Series are dropped after they have been found empty for the grace
+// period so that new series aren't dropped before their first write arrives.
+func (s *Server) SetDropExpiredSeries(enabled bool, grace time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropExpiredSeries = enabled
+	s.expiredSeriesGrace = grace
+}
+
+// databaseSeriesKey identifies a series within a database.
+type databaseSeriesKey struct {
+	database string
+	id       uint32
+}
+
+// DropExpiredSeries drops the series that have had no data in any shard for
+// the expired series grace period as of now. It is run by the data node that
+// holds the retention lease so a single data node checks the series. A series
+// is only checked if that data node stores its shard in every shard group,
+// since other data nodes may hold its data. Each data node checks its shards
+// again when the drop is applied and keeps series that have been written to.
+func (s *Server) DropExpiredSeries(now time.Time) (err error) {
+	if err := s.checkFormat(dropEmptySeriesMessageFormat); err != nil {
+		return err
+	}
+
+	empty, err := s.emptySeries()
+	if err != nil {
+		return err
+	}
+
+	// Track when each series was first found empty and forget those that
+	// have data again.
+	s.mu.Lock()
+	expired := make(map[string][]uint32)
+	since := make(map[databaseSeriesKey]time.Time)
+	for database, ids := range empty {
+		for _, id := range ids {
+			k := databaseSeriesKey{database, id}
+			t, ok := s.emptySeriesSince[k]
+			if !ok {
+				t = now
+			}
+			since[k] = t
+
+			if !now.Before(t.Add(s.expiredSeriesGrace)) {
+				expired[database] = append(expired[database], id)
+			}
+		}
+	}
+	s.emptySeriesSince = since
+	s.mu.Unlock()
+
+	for database, ids := range expired {
+		c := &dropSeriesCommand{Database: database, SeriesIDs: ids, IfEmpty: true}
+		if _, e := s.broadcast(dropSeriesMessageType, c); e != nil && err == nil {
+			err = fmt.Errorf("drop expired series(%s): %s", database, e)
+		}
+	}
+	return
+}
+
+// emptySeries returns the ids, by database, of the series without data in any
+// shard. Series with a shard that isn't open on this data node are skipped.
+func (s *Server) emptySeries() (map[string][]uint32, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a := make(map[string][]uint32)
+	for _, db := range s.databases {
+		candidates := make(map[uint32]struct{}, len(db.series))
+		for id := range db.series {
+			candidates[id] = struct{}{}
+		}
+
+		// Remove the series with data in a shard of any shard group.
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				shardIDs := make(map[*Shard][]uint32)
+				for id := range candidates {
+					sh := g.ShardBySeriesID(id)
+					if !s.storesShard(sh) || !sh.opened() {
+						delete(candidates, id)
+						continue
+					}
+					shardIDs[sh] = append(shardIDs[sh], id)
+				}
+
+				for sh, ids := range shardIDs {
+					found, err := sh.seriesWithData(ids)
+					if err != nil {
+						return nil, fmt.Errorf("shard(%d): %s", sh.ID, err)
+					}
+					for _, id := range found {
+						delete(candidates, id)
+					}
+				}
+			}
+		}
+
+		for id := range candidates {
+			a[db.name] = append(a[db.name], id)
+		}
+		sort.Sort(SeriesIDs(a[db.name]))
+	}
+	return a, nil
+}
+
+// StartRetentionPolicyEnforcement starts a background loop that deletes
+// expired shard groups once every interval. Only the data node holding the
+// retention lease enforces the policies so that data nodes don't race to
+// delete the same groups. The lease lasts two intervals and is renewed on
+// every tick. The loop stops when the server is closed or when enforcement
+// is started again.
+func (s *Server) StartRetentionPolicyEnforcement(interval time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.opened() {
+		return ErrServerClosed
+	} else if interval <= 0 {
+		return ErrInvalidRetentionPolicyEnforcementInterval
+	}
+
+	// Stop previous loop, if running.
+	if s.retentionDone != nil {
+		close(s.retentionDone)
+	}
+
+	done := make(chan struct{}, 0)
+	s.retentionDone = done
+	go s.enforceRetentionPolicies(s.clock.NewTicker(interval), 2*interval, done)
+
+	return nil
+}
+
+// enforceRetentionPolicies runs in a separate goroutine and deletes expired
+// shard groups on every tick, while this data node holds the retention lease,
+// until done is closed.
+func (s *Server) enforceRetentionPolicies(ticker Ticker, lease time.Duration, done chan struct{}) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C():
+			if _, err := s.acquireLease(retentionPolicyEnforcementLease, true, lease); err == ErrContinuousQueryLeaseHeld {
+				continue
+			} else if err != nil {
+				s.Logger.Errorf("retention policy enforcement: %s", err)
+				continue
+			}
+			if err := s.EnforceRetentionPolicies(s.clock.Now()); err != nil {
+				s.Logger.Errorf("retention policy enforcement: %s", err)
+			}
+		}
+	}
+}
+
+// CompactShard removes expired points and reclaims the space left behind by
+// deleted and overwritten data in a shard stored on this server. The default
+// storage engine copies the live data to a new file and swaps it in place of
+// the current file.
+func (s *Server) CompactShard(id uint64) error {
+	s.mu.RLock()
+	sh := s.shards[id]
+	s.mu.RUnlock()
+
+	if sh == nil {
+		return ErrShardNotFound
+	}
+
+	// Collect the series that may have expired values in the shard.
+	var ids []uint32
+	s.mu.RLock()
+	if db, _ := s.shardPolicy(id); db != nil {
+		for seriesID := range db.series {
+			ids = append(ids, seriesID)
+		}
+	}
+	now := s.clock.Now().UnixNano()
+	s.mu.RUnlock()
+
+	return sh.compact(ids, now)
+}
+
+// CompactIdleShards compacts every shard on this server that has been written
+// to or deleted from since it was last compacted but has not been written to
+// since the previous call. Compaction continues past failures and the first
+// error is returned.
+func (s *Server) CompactIdleShards() (err error) {
+	s.mu.RLock()
+	var ids []uint64
+	for id, sh := range s.shards {
+		if sh.idle() {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, id := range ids {
+		if e := s.CompactShard(id); e != nil && err == nil {
+			err = fmt.Errorf("compact shard(%d): %s", id, e)
+		}
+	}
+	return
+}
+
+// StartShardCompaction starts a background loop that compacts idle shards
+// once every interval. A shard is idle if it changed since its last
+// compaction and received no writes during the previous interval. The loop stops when the server is closed or when
+// compaction is started again.
+func (s *Server) StartShardCompaction(interval time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.opened() {
+		return ErrServerClosed
+	} else if interval <= 0 {
+		return ErrInvalidShardCompactionInterval
+	}
+
+	// Stop previous loop, if running.
+	if s.compactionDone != nil {
+		close(s.compactionDone)
+	}
+
+	done := make(chan struct{}, 0)
+	s.compactionDone = done
+	go s.compactShards(s.clock.NewTicker(interval), done)
+
+	return nil
+}
+
+// compactShards runs in a separate goroutine and compacts idle shards on
+// every tick until done is closed.
+func (s *Server) compactShards(ticker Ticker, done chan struct{}) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C():
+			if err := s.CompactIdleShards(); err != nil {
+				s.Logger.Errorf("shard compaction: %s", err)
+			}
+		}
+	}
+}
+
 // User returns a user by username
 // Returns nil if the user does not exist.
 func (s *Server) User(name string) *User {
@@ -830,22 +2480,29 @@ func (s *Server) CreateUser(username, password string, admin bool) error {
 	return err
 }
 
-func (s *Server) applyCreateUser(m *messaging.Message) (err error) {
+func (s *Server) applyCreateUser(m *messaging.Message) error {
 	var c createUserCommand
 	mustUnmarshalJSON(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.meta.mustUpdate(func(tx *metatx) error { return s.createUser(tx, &c) })
+}
 
+// createUser creates a user and persists it in tx.
+// This function must be called under a lock.
+func (s *Server) createUser(tx *metatx, c *createUserCommand) error {
 	// Validate user.
 	if c.Username == "" {
 		return ErrUsernameRequired
 	} else if s.users[c.Username] != nil {
 		return ErrUserExists
+	} else if err := s.passwordPolicy.Validate(c.Username, c.Password); err != nil {
+		return err
 	}
 
 	// Generate the hash of the password.
-	hash, err := HashPassword(c.Password)
+	hash, err := s.hashPassword(c.Password)
 	if err != nil {
 		return err
 	}
@@ -858,12 +2515,12 @@ func (s *Server) applyCreateUser(m *messaging.Message) (err error) {
 	}
 
 	// Persist to metastore.
-	err = s.meta.mustUpdate(func(tx *metatx) error {
-		return tx.saveUser(u)
-	})
+	if err := tx.saveUser(u); err != nil {
+		return err
+	}
 
 	s.users[u.Name] = u
-	return
+	return nil
 }
 
 type createUserCommand struct {
@@ -894,7 +2551,10 @@ func (s *Server) applyUpdateUser(m *messaging.Message) (err error) {
 
 	// Update the user's password, if set.
 	if c.Password != "" {
-		hash, err := HashPassword(c.Password)
+		if err := s.passwordPolicy.Validate(c.Username, c.Password); err != nil {
+			return err
+		}
+		hash, err := s.hashPassword(c.Password)
 		if err != nil {
 			return err
 		}
@@ -933,8 +2593,11 @@ func (s *Server) applyDeleteUser(m *messaging.Message) error {
 		return ErrUserNotFound
 	}
 
-	// Remove from metastore.
+	// Remove the user and its tokens from metastore.
 	s.meta.mustUpdate(func(tx *metatx) error {
+		if err := s.deleteUserTokens(tx, c.Username); err != nil {
+			return err
+		}
 		return tx.deleteUser(c.Username)
 	})
 
@@ -947,6 +2610,150 @@ type deleteUserCommand struct {
 	Username string `json:"username"`
 }
 
+// GrantPrivilege grants a privilege on a database to a user.
+// Granting all privileges without a database makes the user an admin.
+func (s *Server) GrantPrivilege(username, database string, p influxql.Privilege) error {
+	c := &privilegeCommand{Username: username, Database: database, Privilege: p}
+	_, err := s.broadcast(grantPrivilegeMessageType, c)
+	return err
+}
+
+func (s *Server) applyGrantPrivilege(m *messaging.Message) error {
+	var c privilegeCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.meta.mustUpdate(func(tx *metatx) error { return s.grantPrivilege(tx, &c) })
+}
+
+// grantPrivilege grants a privilege to a user and persists it in tx.
+// This function must be called under a lock.
+func (s *Server) grantPrivilege(tx *metatx, c *privilegeCommand) error {
+	// Validate command.
+	u := s.users[c.Username]
+	if u == nil {
+		return ErrUserNotFound
+	} else if c.Database == "" && c.Privilege != influxql.AllPrivileges {
+		return ErrDatabaseRequired
+	} else if c.Database != "" && s.databases[c.Database] == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Grant admin or merge the privilege with any existing database privilege.
+	if c.Database == "" {
+		u.Admin = true
+	} else {
+		if u.Privileges == nil {
+			u.Privileges = make(map[string]influxql.Privilege)
+		}
+		if p, ok := u.Privileges[c.Database]; ok && p != c.Privilege {
+			c.Privilege = influxql.AllPrivileges
+		}
+		u.Privileges[c.Database] = c.Privilege
+	}
+
+	// Persist to metastore.
+	return tx.saveUser(u)
+}
+
+// RevokePrivilege revokes a privilege on a database from a user.
+// Revoking all privileges without a database removes the user's admin status.
+func (s *Server) RevokePrivilege(username, database string, p influxql.Privilege) error {
+	c := &privilegeCommand{Username: username, Database: database, Privilege: p}
+	_, err := s.broadcast(revokePrivilegeMessageType, c)
+	return err
+}
+
+func (s *Server) applyRevokePrivilege(m *messaging.Message) error {
+	var c privilegeCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	u := s.users[c.Username]
+	if u == nil {
+		return ErrUserNotFound
+	} else if c.Database == "" && c.Privilege != influxql.AllPrivileges {
+		return ErrDatabaseRequired
+	}
+
+	// Revoke admin or remove the privilege from the database privilege.
+	if c.Database == "" {
+		u.Admin = false
+	} else if p, ok := u.Privileges[c.Database]; ok {
+		switch {
+		case c.Privilege == influxql.AllPrivileges || c.Privilege == p:
+			delete(u.Privileges, c.Database)
+		case p == influxql.AllPrivileges && c.Privilege == influxql.ReadPrivilege:
+			u.Privileges[c.Database] = influxql.WritePrivilege
+		case p == influxql.AllPrivileges && c.Privilege == influxql.WritePrivilege:
+			u.Privileges[c.Database] = influxql.ReadPrivilege
+		}
+	}
+
+	// Persist to metastore.
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveUser(u)
+	})
+}
+
+// SetPrivilege sets the privilege of a user on a database, replacing any
+// existing privilege. NoPrivileges removes the user's access to the database.
+// Without a database, all privileges makes the user an admin and no
+// privileges removes the user's admin status.
+func (s *Server) SetPrivilege(username, database string, p influxql.Privilege) error {
+	c := &privilegeCommand{Username: username, Database: database, Privilege: p}
+	_, err := s.broadcast(setPrivilegeMessageType, c)
+	return err
+}
+
+func (s *Server) applySetPrivilege(m *messaging.Message) error {
+	var c privilegeCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	u := s.users[c.Username]
+	if u == nil {
+		return ErrUserNotFound
+	} else if c.Privilege < influxql.ReadPrivilege || c.Privilege > influxql.NoPrivileges {
+		return ErrInvalidPrivilege
+	} else if c.Database == "" && c.Privilege != influxql.AllPrivileges && c.Privilege != influxql.NoPrivileges {
+		return ErrDatabaseRequired
+	} else if c.Database != "" && c.Privilege != influxql.NoPrivileges && s.databases[c.Database] == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Set admin or replace the database privilege.
+	switch {
+	case c.Database == "":
+		u.Admin = c.Privilege == influxql.AllPrivileges
+	case c.Privilege == influxql.NoPrivileges:
+		delete(u.Privileges, c.Database)
+	default:
+		if u.Privileges == nil {
+			u.Privileges = make(map[string]influxql.Privilege)
+		}
+		u.Privileges[c.Database] = c.Privilege
+	}
+
+	// Persist to metastore.
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveUser(u)
+	})
+}
+
+type privilegeCommand struct {
+	Username  string             `json:"username"`
+	Database  string             `json:"database,omitempty"`
+	Privilege influxql.Privilege `json:"privilege"`
+}
+
 // RetentionPolicy returns a retention policy by name.
 // Returns an error if the database doesn't exist.
 func (s *Server) RetentionPolicy(database, name string) (*RetentionPolicy, error) {
@@ -1000,10 +2807,17 @@ func (s *Server) RetentionPolicies(database string) ([]*RetentionPolicy, error)
 // CreateRetentionPolicy creates a retention policy for a database.
 func (s *Server) CreateRetentionPolicy(database string, rp *RetentionPolicy) error {
 	c := &createRetentionPolicyCommand{
-		Database: database,
-		Name:     rp.Name,
-		Duration: rp.Duration,
-		ReplicaN: rp.ReplicaN,
+		Database:  database,
+		Name:      rp.Name,
+		Duration:  rp.Duration,
+		ReplicaN:  rp.ReplicaN,
+		SplitN:    rp.SplitN,
+		ShardHash: rp.ShardHash,
+	}
+	if c.ShardHash != "" {
+		if err := s.checkFormat(shardHashMessageFormat); err != nil {
+			return err
+		}
 	}
 	_, err := s.broadcast(createRetentionPolicyMessageType, c)
 	return err
@@ -1015,7 +2829,13 @@ func (s *Server) applyCreateRetentionPolicy(m *messaging.Message) error {
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.meta.mustUpdate(func(tx *metatx) error { return s.createRetentionPolicy(tx, &c) })
+}
 
+// createRetentionPolicy adds a retention policy to a database and persists
+// the database in tx.
+// This function must be called under a lock.
+func (s *Server) createRetentionPolicy(tx *metatx, c *createRetentionPolicyCommand) error {
 	// Retrieve the database.
 	db := s.databases[c.Database]
 	if s.databases[c.Database] == nil {
@@ -1024,42 +2844,84 @@ func (s *Server) applyCreateRetentionPolicy(m *messaging.Message) error {
 		return ErrRetentionPolicyNameRequired
 	} else if db.policies[c.Name] != nil {
 		return ErrRetentionPolicyExists
+	} else if !validShardHash(c.ShardHash) {
+		return ErrInvalidShardHash
 	}
 
 	// Add policy to the database.
 	db.policies[c.Name] = &RetentionPolicy{
-		Name:     c.Name,
-		Duration: c.Duration,
-		ReplicaN: c.ReplicaN,
+		Name:      c.Name,
+		Duration:  c.Duration,
+		ReplicaN:  c.ReplicaN,
+		SplitN:    c.SplitN,
+		ShardHash: c.ShardHash,
 	}
 
 	// Persist to metastore.
-	s.meta.mustUpdate(func(tx *metatx) error {
-		return tx.saveDatabase(db)
-	})
-
-	return nil
+	return tx.saveDatabase(db)
 }
 
 type createRetentionPolicyCommand struct {
-	Database string        `json:"database"`
-	Name     string        `json:"name"`
-	Duration time.Duration `json:"duration"`
-	ReplicaN uint32        `json:"replicaN"`
-	SplitN   uint32        `json:"splitN"`
+	Database  string        `json:"database"`
+	Name      string        `json:"name"`
+	Duration  time.Duration `json:"duration"`
+	ReplicaN  uint32        `json:"replicaN"`
+	SplitN    uint32        `json:"splitN"`
+	ShardHash string        `json:"shardHash,omitempty"`
 }
 
-// UpdateRetentionPolicy updates an existing retention policy on a database.
+// UpdateRetentionPolicy renames an existing retention policy on a database or
+// changes its sharding. An empty name, a zero split factor or an empty shard
+// hash leaves the policy's value unchanged. The duration and replication
+// factor are ignored; use AlterRetentionPolicy to change them.
 func (s *Server) UpdateRetentionPolicy(database, name string, rp *RetentionPolicy) error {
-	c := &updateRetentionPolicyCommand{Database: database, Name: name, NewName: rp.Name}
+	u := &RetentionPolicyUpdate{}
+	if rp.Name != "" {
+		u.Name = &rp.Name
+	}
+	if rp.SplitN != 0 {
+		u.SplitN = &rp.SplitN
+	}
+	if rp.ShardHash != "" {
+		u.ShardHash = &rp.ShardHash
+	}
+	return s.AlterRetentionPolicy(database, name, u)
+}
+
+// AlterRetentionPolicy applies changes to an existing retention policy on a
+// database. Changes to replication and sharding only apply to new shard
+// groups. Shard groups that have expired under a new duration are deleted by
+// the next retention policy enforcement.
+func (s *Server) AlterRetentionPolicy(database, name string, u *RetentionPolicyUpdate) error {
+	c := &updateRetentionPolicyCommand{Database: database, Name: name, Duration: u.Duration, ReplicaN: u.ReplicaN}
+	if u.Name != nil {
+		c.NewName = *u.Name
+	}
+	if u.SplitN != nil {
+		c.SplitN = *u.SplitN
+	}
+	if u.ShardHash != nil {
+		c.ShardHash = *u.ShardHash
+	}
+
+	// Data nodes that predate the options only apply renames.
+	if c.Duration != nil || c.ReplicaN != nil || c.SplitN != 0 || c.ShardHash != "" {
+		if err := s.checkFormat(retentionPolicyOptionsMessageFormat); err != nil {
+			return err
+		}
+	}
 	_, err := s.broadcast(updateRetentionPolicyMessageType, c)
 	return err
 }
 
 type updateRetentionPolicyCommand struct {
-	Database string `json:"database"`
-	Name     string `json:"name"`
-	NewName  string `json:"newName"`
+	Database  string         `json:"database"`
+	Name      string         `json:"name"`
+	NewName   string         `json:"newName"`
+	Duration  *time.Duration `json:"duration,omitempty"`
+	ReplicaN  *uint32        `json:"replicaN,omitempty"`
+	SplitN    uint32         `json:"splitN,omitempty"`
+	ShardHash string         `json:"shardHash,omitempty"`
 }
 
 func (s *Server) applyUpdateRetentionPolicy(m *messaging.Message) (err error) {
@@ -1081,6 +2943,8 @@ func (s *Server) applyUpdateRetentionPolicy(m *messaging.Message) (err error) {
 	p := db.policies[c.Name]
 	if db.policies[c.Name] == nil {
 		return ErrRetentionPolicyNotFound
+	} else if !validShardHash(c.ShardHash) {
+		return ErrInvalidShardHash
 	}
 
 	// Update the policy name, if not blank.
@@ -1090,6 +2954,22 @@ func (s *Server) applyUpdateRetentionPolicy(m *messaging.Message) (err error) {
 		db.policies[p.Name] = p
 	}
 
+	// Update the duration and replication of new shard groups, if set.
+	if c.Duration != nil {
+		p.Duration = *c.Duration
+	}
+	if c.ReplicaN != nil {
+		p.ReplicaN = *c.ReplicaN
+	}
+
+	// Update the sharding of new shard groups, if set.
+	if c.SplitN != 0 {
+		p.SplitN = c.SplitN
+	}
+	if c.ShardHash != "" {
+		p.ShardHash = c.ShardHash
+	}
+
 	// Persist to metastore.
 	err = s.meta.mustUpdate(func(tx *metatx) error {
 		return tx.saveDatabase(db)
@@ -1145,13 +3025,19 @@ func (s *Server) SetDefaultRetentionPolicy(database, name string) error {
 	return err
 }
 
-func (s *Server) applySetDefaultRetentionPolicy(m *messaging.Message) (err error) {
+func (s *Server) applySetDefaultRetentionPolicy(m *messaging.Message) error {
 	var c setDefaultRetentionPolicyCommand
 	mustUnmarshalJSON(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.meta.mustUpdate(func(tx *metatx) error { return s.setDefaultRetentionPolicy(tx, &c) })
+}
 
+// setDefaultRetentionPolicy sets the default retention policy of a database
+// and persists the database in tx.
+// This function must be called under a lock.
+func (s *Server) setDefaultRetentionPolicy(tx *metatx, c *setDefaultRetentionPolicyCommand) error {
 	// Validate command.
 	db := s.databases[c.Database]
 	if s.databases[c.Database] == nil {
@@ -1164,11 +3050,7 @@ func (s *Server) applySetDefaultRetentionPolicy(m *messaging.Message) (err error
 	db.defaultRetentionPolicy = c.Name
 
 	// Persist to metastore.
-	err = s.meta.mustUpdate(func(tx *metatx) error {
-		return tx.saveDatabase(db)
-	})
-
-	return
+	return tx.saveDatabase(db)
 }
 
 type setDefaultRetentionPolicyCommand struct {
@@ -1176,8 +3058,43 @@ type setDefaultRetentionPolicyCommand struct {
 	Name     string `json:"name"`
 }
 
-func (s *Server) applyCreateSeriesIfNotExists(m *messaging.Message) error {
-	var c createSeriesIfNotExistsCommand
+// DownsamplePolicies returns a list of downsample policies for a database.
+// Returns an error if the database doesn't exist.
+func (s *Server) DownsamplePolicies(database string) ([]*DownsamplePolicy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Lookup database.
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	// Retrieve the policies.
+	a := make(downsamplePolicies, 0, len(db.downsamplePolicies))
+	for _, dp := range db.downsamplePolicies {
+		a = append(a, dp)
+	}
+	sort.Sort(a)
+	return a, nil
+}
+
+// CreateDownsamplePolicy creates a downsample policy for a database.
+func (s *Server) CreateDownsamplePolicy(database string, dp *DownsamplePolicy) error {
+	c := &createDownsamplePolicyCommand{
+		Database:   database,
+		Name:       dp.Name,
+		Source:     dp.Source,
+		Target:     dp.Target,
+		Interval:   dp.Interval,
+		Aggregates: dp.Aggregates,
+	}
+	_, err := s.broadcast(createDownsamplePolicyMessageType, c)
+	return err
+}
+
+func (s *Server) applyCreateDownsamplePolicy(m *messaging.Message) error {
+	var c createDownsamplePolicyCommand
 	mustUnmarshalJSON(m.Data, &c)
 
 	s.mu.Lock()
@@ -1187,492 +3104,2969 @@ func (s *Server) applyCreateSeriesIfNotExists(m *messaging.Message) error {
 	db := s.databases[c.Database]
 	if db == nil {
 		return ErrDatabaseNotFound
+	} else if c.Name == "" {
+		return ErrDownsamplePolicyNameRequired
+	} else if db.downsamplePolicies[c.Name] != nil {
+		return ErrDownsamplePolicyExists
+	} else if db.policies[c.Source] == nil || db.policies[c.Target] == nil {
+		return ErrRetentionPolicyNotFound
+	} else if c.Interval <= 0 {
+		return ErrInvalidDownsampleInterval
+	} else if len(c.Aggregates) == 0 {
+		return ErrDownsampleAggregatesRequired
 	}
 
-	if _, series := db.MeasurementAndSeries(c.Name, c.Tags); series != nil {
-		return nil
-	}
-
-	// save to the metastore and add it to the in memory index
-	var series *Series
-	if err := s.meta.mustUpdate(func(tx *metatx) error {
-		var err error
-		series, err = tx.createSeries(db.name, c.Name, c.Tags)
-		return err
-	}); err != nil {
-		return err
+	// Add policy to the database.
+	db.downsamplePolicies[c.Name] = &DownsamplePolicy{
+		Name:       c.Name,
+		Source:     c.Source,
+		Target:     c.Target,
+		Interval:   c.Interval,
+		Aggregates: c.Aggregates,
 	}
 
-	db.addSeriesToIndex(c.Name, series)
+	// Persist to metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
 
 	return nil
 }
 
-type createSeriesIfNotExistsCommand struct {
-	Database string            `json:"database"`
-	Name     string            `json:"name"`
-	Tags     map[string]string `json:"tags"`
+type createDownsamplePolicyCommand struct {
+	Database   string            `json:"database"`
+	Name       string            `json:"name"`
+	Source     string            `json:"source"`
+	Target     string            `json:"target"`
+	Interval   time.Duration     `json:"interval"`
+	Aggregates map[string]string `json:"aggregates"`
 }
 
-// Point defines the values that will be written to the database
-type Point struct {
-	Name      string
-	Tags      map[string]string
-	Timestamp time.Time
-	Values    map[string]interface{}
+// DeleteDownsamplePolicy removes a downsample policy from a database.
+func (s *Server) DeleteDownsamplePolicy(database, name string) error {
+	c := &deleteDownsamplePolicyCommand{Database: database, Name: name}
+	_, err := s.broadcast(deleteDownsamplePolicyMessageType, c)
+	return err
 }
 
-// WriteSeries writes series data to the database.
-// Returns the messaging index the data was written to.
-func (s *Server) WriteSeries(database, retentionPolicy string, points []Point) (uint64, error) {
-	// TODO corylanou: implement batch writing
-	if len(points) != 1 {
-		return 0, errors.New("batching WriteSeries has not been implemented yet")
-	}
-	name, tags, timestamp, values := points[0].Name, points[0].Tags, points[0].Timestamp, points[0].Values
+func (s *Server) applyDeleteDownsamplePolicy(m *messaging.Message) (err error) {
+	var c deleteDownsamplePolicyCommand
+	mustUnmarshalJSON(m.Data, &c)
 
-	// Find the id for the series and tagset
-	seriesID, err := s.createSeriesIfNotExists(database, name, tags)
-	if err != nil {
-		return 0, err
-	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	// If the retention policy is not set, use the default for this database.
-	if retentionPolicy == "" {
-		rp, err := s.DefaultRetentionPolicy(database)
-		if err != nil {
-			return 0, fmt.Errorf("failed to determine default retention policy: %s", err.Error())
-		} else if rp == nil {
-			return 0, ErrDefaultRetentionPolicyNotFound
-		}
-		retentionPolicy = rp.Name
+	// Retrieve the database.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	} else if c.Name == "" {
+		return ErrDownsamplePolicyNameRequired
+	} else if db.downsamplePolicies[c.Name] == nil {
+		return ErrDownsamplePolicyNotFound
 	}
 
-	// Retrieve measurement.
-	m, err := s.measurement(database, name)
-	if err != nil {
-		return 0, err
-	} else if m == nil {
-		return 0, ErrMeasurementNotFound
-	}
+	// Remove downsample policy.
+	delete(db.downsamplePolicies, c.Name)
 
-	// Retrieve shard group.
-	g, err := s.createShardGroupIfNotExists(database, retentionPolicy, timestamp)
-	if err != nil {
-		return 0, fmt.Errorf("create shard(%s/%s): %s", retentionPolicy, timestamp.Format(time.RFC3339Nano), err)
-	}
+	// Persist to metastore.
+	err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
 
-	// Find appropriate shard within the shard group.
-	sh := g.ShardBySeriesID(seriesID)
+	return
+}
 
-	// Ignore requests that have no values.
-	if len(values) == 0 {
-		return 0, nil
-	}
+type deleteDownsamplePolicyCommand struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+}
 
-	// Convert string-key/values to fieldID-key/values.
-	// If not all fields can be converted then send as a non-raw write series.
-	rawValues := m.mapValues(values)
-	if rawValues == nil {
-		// Encode the command.
-		data := mustMarshalJSON(&writeSeriesCommand{
-			Database:    database,
-			Measurement: name,
-			SeriesID:    seriesID,
-			Timestamp:   timestamp.UnixNano(),
-			Values:      values,
-		})
+// DownsampleQueries returns the continuous queries compiled from the
+// downsample policies of a database, one per policy and measurement.
+func (s *Server) DownsampleQueries(database string) ([]*influxql.CreateContinuousQueryStatement, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-		// Publish "write series" message on shard's topic to broker.
-		return s.client.Publish(&messaging.Message{
-			Type:    writeSeriesMessageType,
-			TopicID: sh.ID,
-			Data:    data,
-		})
+	// Lookup database.
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
 	}
 
-	// If we can successfully encode the string keys to raw field ids then
-	// we can send a raw write series message which is much smaller and faster.
+	return db.downsampleQueries(database), nil
+}
 
-	// Encode point header.
-	data := marshalPointHeader(seriesID, timestamp.UnixNano())
-	data = append(data, marshalValues(rawValues)...)
+// downsampleContinuousQueries returns the continuous queries compiled from the
+// downsample policies of every database. Queries are compiled on every call so
+// that measurements and fields created after a policy is declared are rolled
+// up too. Query names are qualified by database since policy names are not
+// unique across databases.
+func (s *Server) downsampleContinuousQueries() []*ContinuousQuery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	// Publish "raw write series" message on shard's topic to broker.
-	return s.client.Publish(&messaging.Message{
-		Type:    writeRawSeriesMessageType,
-		TopicID: sh.ID,
-		Data:    data,
-	})
+	names := make([]string, 0, len(s.databases))
+	for name := range s.databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var a []*ContinuousQuery
+	lastRun := make(map[string]time.Time)
+	for _, database := range names {
+		for _, stmt := range s.databases[database].downsampleQueries(database) {
+			cq := &ContinuousQuery{
+				Name:       database + "." + stmt.Name,
+				Database:   database,
+				Query:      stmt.String(),
+				downsample: true,
+				lastRun:    s.downsampleLastRun[database+"."+stmt.Name],
+			}
+			lastRun[cq.Name] = cq.lastRun
+			a = append(a, cq)
+		}
+	}
+
+	// Forget queries whose policy or database has been removed.
+	s.downsampleLastRun = lastRun
+
+	return a
 }
 
-type writeSeriesCommand struct {
-	Database    string                 `json:"database"`
-	Measurement string                 `json:"measurement"`
-	SeriesID    uint32                 `json:"seriesID"`
-	Timestamp   int64                  `json:"timestamp"`
-	Values      map[string]interface{} `json:"values"`
+// ContinuousQueries returns a list of all continuous queries, sorted by name.
+func (s *Server) ContinuousQueries() []*ContinuousQuery {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a := make(continuousQueries, 0, len(s.continuousQueries))
+	for _, cq := range s.continuousQueries {
+		other := *cq
+		a = append(a, &other)
+	}
+	sort.Sort(a)
+	return a
 }
 
-// applyWriteSeries writes "non-raw" series data to the database.
-// Non-raw data occurs when fields have not been created yet so the field
-// names cannot be converted to field ids.
-func (s *Server) applyWriteSeries(m *messaging.Message) error {
-	var c writeSeriesCommand
+// CreateContinuousQuery creates a continuous query on a database. The source
+// statement must be grouped by a time interval and is run once each interval
+// completes. Results are written to the statement's target measurement.
+func (s *Server) CreateContinuousQuery(q *influxql.CreateContinuousQueryStatement) error {
+	c := &createContinuousQueryCommand{Query: q.String()}
+	_, err := s.broadcast(createContinuousQueryMessageType, c)
+	return err
+}
+
+func (s *Server) applyCreateContinuousQuery(m *messaging.Message) error {
+	var c createContinuousQueryCommand
 	mustUnmarshalJSON(m.Data, &c)
 
+	// Parse the query outside of the lock.
+	cq := &ContinuousQuery{Query: c.Query}
+	stmt, err := cq.statement()
+	if err != nil {
+		return err
+	}
+	cq.Name, cq.Database = stmt.Name, stmt.Database
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Retrieve the shard.
-	sh := s.shards[m.TopicID]
-	if sh == nil {
-		return ErrShardNotFound
-	}
-
-	// Retrieve the database.
-	db := s.databases[c.Database]
-	if db == nil {
+	// Validate command.
+	if cq.Name == "" {
+		return ErrContinuousQueryNameRequired
+	} else if s.continuousQueries[cq.Name] != nil {
+		return ErrContinuousQueryExists
+	} else if s.databases[cq.Database] == nil {
 		return ErrDatabaseNotFound
+	} else if stmt.Source.GroupByInterval() <= 0 {
+		return ErrContinuousQueryIntervalRequired
 	}
 
-	// Retrieve the measurement.
-	mm := db.measurements[c.Measurement]
-	if mm == nil {
-		return ErrMeasurementNotFound
-	}
+	// Persist to metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveContinuousQuery(cq)
+	})
+	s.continuousQueries[cq.Name] = cq
 
-	// Encode value map and create fields as needed.
-	rawValues := make(map[uint8]interface{}, len(c.Values))
-	for k, v := range c.Values {
-		// TODO: Support non-float types.
+	return nil
+}
 
-		// Find or create fields.
-		// If too many fields are on the measurement then log the issue.
-		// If any other error occurs then exit.
-		f, err := mm.createFieldIfNotExists(k, influxql.Number)
-		if err == ErrFieldOverflow {
-			log.Printf("no more fields allowed: %s::%s", mm.Name, k)
-			continue
-		} else if err != nil {
+type createContinuousQueryCommand struct {
+	Query string `json:"query"`
+}
+
+// DeleteContinuousQuery removes a continuous query and its lease.
+func (s *Server) DeleteContinuousQuery(name string) error {
+	c := &deleteContinuousQueryCommand{Name: name}
+	_, err := s.broadcast(deleteContinuousQueryMessageType, c)
+	return err
+}
+
+func (s *Server) applyDeleteContinuousQuery(m *messaging.Message) error {
+	var c deleteContinuousQueryCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	if s.continuousQueries[c.Name] == nil {
+		return ErrContinuousQueryNotFound
+	}
+
+	// Remove from metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		if err := tx.deleteContinuousQueryLease(c.Name, false); err != nil {
 			return err
 		}
-		rawValues[f.ID] = v
+		return tx.deleteContinuousQuery(c.Name)
+	})
+	delete(s.continuousQueries, c.Name)
+	delete(s.continuousQueryLeases, c.Name)
+
+	return nil
+}
+
+type deleteContinuousQueryCommand struct {
+	Name string `json:"name"`
+}
+
+// RunContinuousQueries runs each continuous query whose most recent interval
+// completed after the query was last run by this server. Queries compiled from
+// downsample policies are run along with user created queries. A query only
+// runs on the data node holding its lease, so queries leased to other nodes are
+// skipped. All queries are attempted and the first error is returned.
+func (s *Server) RunContinuousQueries(now time.Time) (err error) {
+	for _, cq := range append(s.ContinuousQueries(), s.downsampleContinuousQueries()...) {
+		if e := s.runContinuousQuery(cq, now); e != nil && err == nil {
+			err = fmt.Errorf("continuous query %s: %s", cq.Name, e)
+		}
 	}
+	return
+}
 
-	// Update metastore.
-	if err := s.meta.mustUpdate(func(tx *metatx) error {
-		return tx.saveDatabase(db)
-	}); err != nil {
+// runContinuousQuery executes a continuous query over its last completed
+// interval and writes the resulting rows to the target measurement.
+func (s *Server) runContinuousQuery(cq *ContinuousQuery, now time.Time) error {
+	stmt, err := cq.statement()
+	if err != nil {
 		return err
 	}
 
-	// Encode the values into a binary format.
-	data := marshalValues(rawValues)
+	// Determine the last completed interval. Intervals are aligned to the
+	// epoch the same way the executor groups points.
+	interval := stmt.Source.GroupByInterval()
+	if interval <= 0 {
+		return ErrContinuousQueryIntervalRequired
+	}
+	end := time.Unix(0, now.UnixNano()-(now.UnixNano()%int64(interval))).UTC()
+	start := end.Add(-interval)
+	if !cq.lastRun.Before(end) {
+		return nil
+	}
 
-	// TODO: Enable some way to specify if the data should be overwritten
-	overwrite := true
+	// Only the lease holder runs the query.
+	if _, err := s.AcquireContinuousQueryLease(cq.Name, DefaultContinuousQueryLeaseDuration); err == ErrContinuousQueryLeaseHeld {
+		return nil
+	} else if err != nil {
+		return err
+	}
 
-	// Write to shard.
-	return sh.writeSeries(c.SeriesID, c.Timestamp, data, overwrite)
+	// Restrict the source to the interval.
+	cond := influxql.Expr(&influxql.BinaryExpr{
+		Op:  influxql.AND,
+		LHS: &influxql.BinaryExpr{Op: influxql.GTE, LHS: &influxql.VarRef{Val: "time"}, RHS: &influxql.TimeLiteral{Val: start}},
+		RHS: &influxql.BinaryExpr{Op: influxql.LT, LHS: &influxql.VarRef{Val: "time"}, RHS: &influxql.TimeLiteral{Val: end}},
+	})
+	if stmt.Source.Condition != nil {
+		cond = &influxql.BinaryExpr{Op: influxql.AND, LHS: &influxql.ParenExpr{Expr: stmt.Source.Condition}, RHS: cond}
+	}
+	stmt.Source.Condition = cond
+
+	// Execute the source statement and write the results to the target.
+	res := s.executeSelectStatement(context.Background(), stmt.Source, cq.Database, nil)
+	if res.Err != nil {
+		return res.Err
+	}
+	if err := s.writeContinuousQueryResults(stmt, res.Rows); err != nil {
+		return err
+	}
+
+	// Mark the interval as run.
+	s.mu.Lock()
+	if cq.downsample {
+		s.downsampleLastRun[cq.Name] = end
+	} else if other := s.continuousQueries[cq.Name]; other != nil {
+		other.lastRun = end
+	}
+	s.mu.Unlock()
+
+	return nil
 }
 
-// applyWriteRawSeries writes raw series data to the database.
-// Raw series data has already converted field names to ids so the
-// representation is fast and compact.
-func (s *Server) applyWriteRawSeries(m *messaging.Message) error {
-	// Retrieve the shard.
-	sh := s.Shard(m.TopicID)
-	if sh == nil {
-		return ErrShardNotFound
+// writeContinuousQueryResults writes the rows produced by a continuous query
+// to its target and waits for the writes to be applied. The target measurement
+// may be qualified by a retention policy. Otherwise the default retention
+// policy of the target database is used.
+func (s *Server) writeContinuousQueryResults(stmt *influxql.CreateContinuousQueryStatement, rows []*influxql.Row) error {
+	database := stmt.Source.Target.Database
+	if database == "" {
+		database = stmt.Database
 	}
 
-	// Extract the series id and timestamp from the header.
-	// Everything after the header is the marshalled value.
-	seriesID, timestamp := unmarshalPointHeader(m.Data[:pointHeaderSize])
-	data := m.Data[pointHeaderSize:]
+	// Split the target into retention policy and measurement.
+	db, rp, name, err := splitMeasurement(stmt.Source.Target.Measurement)
+	if err != nil {
+		return err
+	} else if db != "" {
+		database = db
+	}
 
-	// TODO: Enable some way to specify if the data should be overwritten
-	overwrite := true
+	// Write a point for each row value. Timestamps are returned by the
+	// executor in microseconds.
+	var index uint64
+	for _, row := range rows {
+		for _, values := range row.Values {
+			p := Point{
+				Name:      name,
+				Tags:      row.Tags,
+				Timestamp: time.Unix(0, values[0].(int64)*int64(time.Microsecond)).UTC(),
+				Values:    make(map[string]interface{}),
+			}
+			for i, v := range values[1:] {
+				if v != nil {
+					p.Values[row.Columns[i+1]] = v
+				}
+			}
+			if len(p.Values) == 0 {
+				continue
+			}
 
-	// Write to shard.
-	return sh.writeSeries(seriesID, timestamp, data, overwrite)
-}
+			if index, err = s.WriteSeries(database, rp, []Point{p}); err != nil {
+				return err
+			}
+		}
+	}
 
-func (s *Server) createSeriesIfNotExists(database, name string, tags map[string]string) (uint32, error) {
-	// Try to find series locally first.
-	s.mu.RLock()
-	idx := s.databases[database]
-	if idx == nil {
-		return 0, fmt.Errorf("database not found %q", database)
+	if index == 0 {
+		return nil
 	}
-	if _, series := idx.MeasurementAndSeries(name, tags); series != nil {
-		s.mu.RUnlock()
-		return series.ID, nil
+	return s.Sync(index)
+}
+
+// StartContinuousQueries starts a background loop that runs continuous
+// queries whose intervals have completed, checking once every interval.
+// The loop stops when the server is closed or when it is started again.
+func (s *Server) StartContinuousQueries(interval time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.opened() {
+		return ErrServerClosed
+	} else if interval <= 0 {
+		return ErrInvalidContinuousQueryCheckInterval
 	}
-	// release the read lock so the broadcast can actually go through and acquire the write lock
-	s.mu.RUnlock()
 
-	// If it doesn't exist then create a message and broadcast.
-	c := &createSeriesIfNotExistsCommand{Database: database, Name: name, Tags: tags}
-	_, err := s.broadcast(createSeriesIfNotExistsMessageType, c)
-	if err != nil {
-		return 0, err
+	// Stop previous loop, if running.
+	if s.continuousQueryDone != nil {
+		close(s.continuousQueryDone)
 	}
 
-	// Lookup series again.
-	_, series := idx.MeasurementAndSeries(name, tags)
-	if series == nil {
-		return 0, ErrSeriesNotFound
+	done := make(chan struct{}, 0)
+	s.continuousQueryDone = done
+	go s.runContinuousQueries(s.clock.NewTicker(interval), done)
+
+	return nil
+}
+
+// runContinuousQueries runs in a separate goroutine and runs continuous
+// queries on every tick until done is closed.
+func (s *Server) runContinuousQueries(ticker Ticker, done chan struct{}) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C():
+			if err := s.RunContinuousQueries(s.clock.Now()); err != nil {
+				s.Logger.Errorf("continuous queries: %s", err)
+			}
+		}
 	}
-	return series.ID, nil
 }
 
-// ReadSeries reads a single point from a series in the database.
-func (s *Server) ReadSeries(database, retentionPolicy, name string, tags map[string]string, timestamp time.Time) (map[string]interface{}, error) {
+// ContinuousQueryLeases returns a list of all continuous query leases, sorted by name.
+func (s *Server) ContinuousQueryLeases() []*ContinuousQueryLease {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	// Find database.
-	db := s.databases[database]
-	if db == nil {
-		return nil, ErrDatabaseNotFound
+	a := make(continuousQueryLeases, 0, len(s.continuousQueryLeases))
+	for _, l := range s.continuousQueryLeases {
+		other := *l
+		a = append(a, &other)
 	}
+	sort.Sort(a)
+	return a
+}
 
-	// Find series.
-	mm, series := db.MeasurementAndSeries(name, tags)
-	if mm == nil {
-		return nil, ErrMeasurementNotFound
-	} else if series == nil {
-		return nil, ErrSeriesNotFound
-	}
+// AcquireContinuousQueryLease requests the right for this data node to run a
+// continuous query for a given duration. Calling it again before the lease
+// expires renews the lease. Returns ErrContinuousQueryLeaseHeld if another
+// data node holds an unexpired lease. Leases held by a data node are released
+// when the node is deleted, which allows another node to take over immediately.
+//
+// A lease expires once its duration has passed on this node's clock since the
+// holder last renewed it. The request names the renewal it takes over from, so
+// it is refused if the holder renewed the lease in the meantime.
+func (s *Server) AcquireContinuousQueryLease(name string, d time.Duration) (*ContinuousQueryLease, error) {
+	return s.acquireLease(name, false, d)
+}
 
-	// If the retention policy is not specified, use the default for this database.
-	if retentionPolicy == "" {
-		retentionPolicy = db.defaultRetentionPolicy
+// acquireLease requests a continuous query lease or, if system is set, a lease
+// for a task such as retention policy enforcement. System leases are named
+// separately so they don't collide with continuous queries.
+func (s *Server) acquireLease(name string, system bool, d time.Duration) (*ContinuousQueryLease, error) {
+	c := &acquireContinuousQueryLeaseCommand{
+		Name:       name,
+		DataNodeID: s.ID(),
+		Timestamp:  s.clock.Now(),
+		Duration:   d,
 	}
+	if s.checkFormat(leaseRenewalMessageFormat) == nil {
+		// Data nodes that predate system leases store them with the
+		// continuous query leases.
+		c.System = system
 
-	// Retrieve retention policy.
-	rp := db.policies[retentionPolicy]
-	if rp == nil {
-		return nil, ErrRetentionPolicyNotFound
+		var renewal uint64
+		s.mu.RLock()
+		if l := s.leases(c.System)[name]; l != nil {
+			duration := l.Duration
+			if duration == 0 {
+				duration = d
+			}
+			if l.DataNodeID != c.DataNodeID && c.Timestamp.Sub(l.renewed) < duration {
+				s.mu.RUnlock()
+				return nil, ErrContinuousQueryLeaseHeld
+			}
+			renewal = l.Index
+		}
+		s.mu.RUnlock()
+		c.Renewal = &renewal
 	}
-
-	// Retrieve shard group.
-	g, err := s.shardGroupByTimestamp(database, retentionPolicy, timestamp)
-	if err != nil {
+	if _, err := s.broadcast(acquireContinuousQueryLeaseMessageType, c); err != nil {
 		return nil, err
-	} else if g == nil {
-		return nil, nil
 	}
 
-	// TODO: Verify that server owns shard.
-
-	// Find appropriate shard within the shard group.
-	sh := g.Shards[int(series.ID)%len(g.Shards)]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	other := *s.leases(c.System)[name]
+	return &other, nil
+}
 
-	// Read raw encoded series data.
-	data, err := sh.readSeries(series.ID, timestamp.UnixNano())
-	if err != nil {
-		return nil, err
+// leases returns the continuous query leases or the system leases.
+// This function must be called under a lock.
+func (s *Server) leases(system bool) map[string]*ContinuousQueryLease {
+	if system {
+		return s.systemLeases
 	}
+	return s.continuousQueryLeases
+}
 
-	// Decode into a raw value map.
-	rawValues := unmarshalValues(data)
-	if rawValues == nil {
-		return nil, nil
+func (s *Server) applyAcquireContinuousQueryLease(m *messaging.Message) error {
+	var c acquireContinuousQueryLeaseCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	if c.Name == "" {
+		return ErrContinuousQueryNameRequired
+	} else if c.Duration <= 0 {
+		return ErrInvalidContinuousQueryLeaseDuration
+	} else if c.DataNodeID == 0 {
+		return ErrDataNodeNotFound
 	}
 
-	// Decode into a string-key value map.
-	values := make(map[string]interface{}, len(rawValues))
-	for fieldID, value := range rawValues {
-		f := mm.Field(fieldID)
-		if f == nil {
-			continue
+	// A takeover is only granted if it names the holder's latest renewal so
+	// every server applies the command the same way. Requesters that predate
+	// renewals are compared against the holder's expiration instead.
+	leases := s.leases(c.System)
+	if l := leases[c.Name]; l != nil && l.DataNodeID != c.DataNodeID {
+		if c.Renewal != nil && *c.Renewal != l.Index {
+			return ErrContinuousQueryLeaseHeld
+		} else if c.Renewal == nil && l.Expiration.After(c.Timestamp) {
+			return ErrContinuousQueryLeaseHeld
 		}
-		values[f.Name] = value
+	}
+	l := &ContinuousQueryLease{
+		Name:       c.Name,
+		DataNodeID: c.DataNodeID,
+		Expiration: c.Timestamp.Add(c.Duration),
+		Duration:   c.Duration,
+		Index:      m.Index,
+		System:     c.System,
+		renewed:    s.clock.Now(),
 	}
 
-	return values, nil
+	// Persist to metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveContinuousQueryLease(l)
+	})
+	leases[l.Name] = l
+
+	return nil
 }
 
-// ExecuteQuery executes an InfluxQL query against the server.
-// Returns a resultset for each statement in the query.
-// Stops on first execution error that occurs.
-func (s *Server) ExecuteQuery(q *influxql.Query, database string, user *User) Results {
-	// Build empty resultsets.
-	results := make(Results, len(q.Statements))
+type acquireContinuousQueryLeaseCommand struct {
+	Name       string        `json:"name"`
+	DataNodeID uint64        `json:"dataNodeID"`
+	Timestamp  time.Time     `json:"timestamp"`
+	Duration   time.Duration `json:"duration"`
+	Renewal    *uint64       `json:"renewal,omitempty"`
+	System     bool          `json:"system,omitempty"`
+}
 
-	// Execute each statement.
-	for i, stmt := range q.Statements {
-		var res *Result
-		switch stmt := stmt.(type) {
-		case *influxql.SelectStatement:
-			res = s.executeSelectStatement(stmt, database, user)
-		case *influxql.CreateDatabaseStatement:
-			res = s.executeCreateDatabaseStatement(stmt, user)
-		case *influxql.DropDatabaseStatement:
-			res = s.executeDropDatabaseStatement(stmt, user)
-		case *influxql.ListDatabasesStatement:
-			res = s.executeListDatabasesStatement(stmt, user)
-		case *influxql.CreateUserStatement:
-			res = s.executeCreateUserStatement(stmt, user)
-		case *influxql.DropUserStatement:
-			res = s.executeDropUserStatement(stmt, user)
-		case *influxql.DropSeriesStatement:
-			continue
-		case *influxql.ListSeriesStatement:
-			continue
-		case *influxql.ListMeasurementsStatement:
+// retentionPolicyEnforcementLease is the name of the system lease held by the
+// data node that enforces retention policies.
+const retentionPolicyEnforcementLease = "retention policy enforcement"
+
+// ContinuousQueryLease represents the right of a data node to run a
+// continuous query. The expiration is the holder's estimate; other data nodes
+// time the lease from when they applied its latest renewal.
+type ContinuousQueryLease struct {
+	Name       string        `json:"name"`
+	DataNodeID uint64        `json:"dataNodeID"`
+	Expiration time.Time     `json:"expiration"`
+	Duration   time.Duration `json:"duration,omitempty"`
+	Index      uint64        `json:"index,omitempty"` // broker index of the latest renewal
+	System     bool          `json:"system,omitempty"`
+
+	renewed time.Time // local time the latest renewal was applied
+}
+
+type continuousQueryLeases []*ContinuousQueryLease
+
+func (p continuousQueryLeases) Len() int           { return len(p) }
+func (p continuousQueryLeases) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p continuousQueryLeases) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// CreateEvent records an event, such as a deploy or an incident, in a database.
+// An event without an end time is treated as a single point in time.
+// Returns the id of the new event.
+func (s *Server) CreateEvent(database string, e *Event) (uint64, error) {
+	c := &createEventCommand{
+		Database:  database,
+		Name:      e.Name,
+		StartTime: e.StartTime,
+		EndTime:   e.EndTime,
+		Text:      e.Text,
+		Tags:      e.Tags,
+	}
+	return s.broadcast(createEventMessageType, c)
+}
+
+func (s *Server) applyCreateEvent(m *messaging.Message) error {
+	var c createEventCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Default the end time to the start time for instantaneous events.
+	if c.EndTime.IsZero() {
+		c.EndTime = c.StartTime
+	}
+
+	// Validate command.
+	if s.databases[c.Database] == nil {
+		return ErrDatabaseNotFound
+	} else if c.Name == "" {
+		return ErrEventNameRequired
+	} else if c.StartTime.IsZero() {
+		return ErrEventTimeRequired
+	} else if c.EndTime.Before(c.StartTime) {
+		return ErrInvalidEventTimeRange
+	}
+
+	// The message index is used as the id since it is unique across the cluster.
+	e := &Event{
+		ID:        m.Index,
+		Name:      c.Name,
+		StartTime: c.StartTime.UTC(),
+		EndTime:   c.EndTime.UTC(),
+		Text:      c.Text,
+		Tags:      c.Tags,
+	}
+
+	// Persist to metastore.
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveEvent(c.Database, e)
+	})
+}
+
+type createEventCommand struct {
+	Database  string            `json:"database"`
+	Name      string            `json:"name"`
+	StartTime time.Time         `json:"startTime"`
+	EndTime   time.Time         `json:"endTime"`
+	Text      string            `json:"text,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// Events returns the events in a database that overlap a time range, sorted by
+// start time. A zero min or max leaves that side of the range unbounded and a
+// blank name returns events of every name.
+func (s *Server) Events(database, name string, min, max time.Time) ([]*Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.databases[database] == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	var a []*Event
+	s.meta.mustView(func(tx *metatx) error {
+		for _, e := range tx.events(database, min, max) {
+			if name == "" || e.Name == name {
+				a = append(a, e)
+			}
+		}
+		return nil
+	})
+	return a, nil
+}
+
+// Event represents an annotation over a time range, such as a deploy or an
+// incident, that can be overlaid on series data. Events are stored once in the
+// metastore keyed by start time instead of as series data so that their string
+// payloads do not create fields, series or tag index entries.
+type Event struct {
+	ID        uint64            `json:"id"`
+	Name      string            `json:"name"`
+	StartTime time.Time         `json:"startTime"`
+	EndTime   time.Time         `json:"endTime"`
+	Text      string            `json:"text,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+func (s *Server) applyCreateSeriesIfNotExists(m *messaging.Message) error {
+	var c createSeriesIfNotExistsCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	if _, series := db.MeasurementAndSeries(c.Name, c.Tags); series != nil {
+		return nil
+	}
+
+	// save to the metastore and add it to the in memory index
+	var series *Series
+	if err := s.meta.mustUpdate(func(tx *metatx) error {
+		var err error
+		series, err = tx.createSeries(db.name, c.Name, c.Tags)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	db.addSeriesToIndex(c.Name, series)
+
+	return nil
+}
+
+type createSeriesIfNotExistsCommand struct {
+	Database string            `json:"database"`
+	Name     string            `json:"name"`
+	Tags     map[string]string `json:"tags"`
+}
+
+// DropSeries removes series from the index, the metastore and all local shards.
+func (s *Server) DropSeries(database string, seriesIDs []uint32) error {
+	c := &dropSeriesCommand{Database: database, SeriesIDs: seriesIDs}
+	_, err := s.broadcast(dropSeriesMessageType, c)
+	return err
+}
+
+func (s *Server) applyDropSeries(m *messaging.Message) error {
+	var c dropSeriesCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Keep series that have been written to since they were found empty.
+	var written map[uint32]struct{}
+	if c.IfEmpty {
+		var err error
+		if written, err = s.seriesWithData(db, c.SeriesIDs); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range c.SeriesIDs {
+		series := db.series[id]
+		if series == nil {
+			continue
+		} else if _, ok := written[id]; ok {
+			continue
+		}
+
+		// Remove series data from every shard stored on this server.
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				for _, sh := range g.Shards {
+					if sh.store == nil {
+						continue
+					}
+					if err := sh.deleteSeries(id); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		// Remove from metastore.
+		s.meta.mustUpdate(func(tx *metatx) error {
+			return tx.deleteSeries(c.Database, series.measurement.Name, id)
+		})
+
+		// Remove from the index.
+		db.DropSeries(id)
+	}
+
+	return nil
+}
+
+type dropSeriesCommand struct {
+	Database  string   `json:"database"`
+	SeriesIDs []uint32 `json:"seriesIDs"`
+	IfEmpty   bool     `json:"ifEmpty,omitempty"`
+}
+
+// seriesWithData returns the set of series with data in any shard of a
+// database stored on this server.
+// This function must be called under a lock.
+func (s *Server) seriesWithData(db *database, seriesIDs []uint32) (map[uint32]struct{}, error) {
+	a := make(map[uint32]struct{})
+	for _, rp := range db.policies {
+		for _, g := range rp.shardGroups {
+			shardIDs := make(map[*Shard][]uint32)
+			for _, id := range seriesIDs {
+				if sh := g.ShardBySeriesID(id); sh.store != nil {
+					shardIDs[sh] = append(shardIDs[sh], id)
+				}
+			}
+
+			for sh, ids := range shardIDs {
+				found, err := sh.seriesWithData(ids)
+				if err != nil {
+					return nil, fmt.Errorf("shard(%d): %s", sh.ID, err)
+				}
+				for _, id := range found {
+					a[id] = struct{}{}
+				}
+			}
+		}
+	}
+	return a, nil
+}
+
+// DeleteRange removes the points of a set of series with timestamps between
+// min and max, inclusive, from every local shard. Zero times are unbounded.
+// The series remain in the index.
+func (s *Server) DeleteRange(database string, seriesIDs []uint32, min, max time.Time) error {
+	c := &deleteRangeCommand{Database: database, SeriesIDs: seriesIDs, Max: math.MaxInt64}
+	if !min.IsZero() {
+		c.Min = min.UnixNano()
+	}
+	if !max.IsZero() {
+		c.Max = max.UnixNano()
+	}
+	_, err := s.broadcast(deleteRangeMessageType, c)
+	return err
+}
+
+func (s *Server) applyDeleteRange(m *messaging.Message) error {
+	var c deleteRangeCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Remove the points from every shard overlapping the range.
+	min, max := time.Unix(0, c.Min).UTC(), time.Unix(0, c.Max).UTC()
+	for _, rp := range db.policies {
+		for _, g := range rp.shardGroups {
+			if g.EndTime.Before(min) || g.StartTime.After(max) {
+				continue
+			}
+			for _, sh := range g.Shards {
+				if !sh.opened() {
+					continue
+				}
+				if err := sh.deleteRange(c.SeriesIDs, c.Min, c.Max); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+type deleteRangeCommand struct {
+	Database  string   `json:"database"`
+	SeriesIDs []uint32 `json:"seriesIDs"`
+	Min       int64    `json:"min"`
+	Max       int64    `json:"max"`
+}
+
+// Point defines the values that will be written to the database.
+// A point with a TTL expires that long after its timestamp. Expired points
+// are not returned by queries and are removed when their shard is compacted,
+// even if their retention policy would keep them longer.
+type Point struct {
+	Name      string
+	Tags      map[string]string
+	Timestamp time.Time
+	Values    map[string]interface{}
+	TTL       time.Duration
+}
+
+// WriteSeries writes series data to the database.
+// Returns the messaging index the data was written to.
+func (s *Server) WriteSeries(database, retentionPolicy string, points []Point) (uint64, error) {
+	return s.writeSeries("", database, retentionPolicy, ConsistencyLevelAny, points)
+}
+
+// WriteSeriesWithRequestID writes series data to the database and carries a
+// client-supplied request id in the broker message so the write can be traced
+// to the shard that applies it. Traced writes are always sent in the non-raw
+// format since the raw format has no room for metadata.
+func (s *Server) WriteSeriesWithRequestID(requestID, database, retentionPolicy string, points []Point) (uint64, error) {
+	return s.writeSeries(requestID, database, retentionPolicy, ConsistencyLevelAny, points)
+}
+
+// WriteSeriesWithConsistency writes series data to the database and waits
+// until enough replicas of the point's shard have applied it to satisfy the
+// consistency level. Returns a *ConsistencyError if they don't in time.
+func (s *Server) WriteSeriesWithConsistency(database, retentionPolicy string, level ConsistencyLevel, points []Point) (uint64, error) {
+	return s.writeSeries("", database, retentionPolicy, level, points)
+}
+
+func (s *Server) writeSeries(requestID, database, retentionPolicy string, level ConsistencyLevel, points []Point) (index uint64, err error) {
+	defer s.writeLatency.observeSince(time.Now())
+
+	// Count the write and, if the point is older than its retention policy
+	// keeps, count it as dropped too.
+	var expired bool
+	defer func() {
+		s.counters.addWrite(database, len(points), err)
+		if err == nil && expired {
+			s.counters.addDropped(database, dropRetentionWindow, len(points))
+		}
+	}()
+
+	// TODO corylanou: implement batch writing
+	if len(points) != 1 {
+		return 0, errors.New("batching WriteSeries has not been implemented yet")
+	}
+	name, tags, timestamp, values := points[0].Name, points[0].Tags, points[0].Timestamp, points[0].Values
+	if name == "" {
+		return 0, ErrMeasurementNameRequired
+	}
+
+	// Points without a timestamp are written at the current time.
+	if timestamp.IsZero() {
+		timestamp = s.clock.Now()
+	}
+
+	// Determine when the point expires, if ever.
+	var expires int64
+	if ttl := points[0].TTL; ttl < 0 {
+		return 0, ErrInvalidPointTTL
+	} else if ttl > 0 {
+		expires = timestamp.Add(ttl).UnixNano()
+	}
+
+	// Normalize tags so equivalent tag sets map to the same series. Blocked
+	// points and points over the tag value limit are rejected before their
+	// series is created.
+	s.mu.RLock()
+	tags = s.tagNormalizer.Normalize(tags)
+	blocked := s.writeBlocked(name, tags)
+	tags, err = s.limitTagValues(database, name, tags)
+	s.mu.RUnlock()
+	if blocked {
+		return 0, ErrWriteBlocked
+	} else if err != nil {
+		return 0, err
+	}
+
+	// Find the id for the series and tagset
+	seriesID, err := s.createSeriesIfNotExists(database, name, tags)
+	if err != nil {
+		return 0, err
+	}
+
+	// If the retention policy is not set, use the default for this database.
+	if retentionPolicy == "" {
+		rp, err := s.DefaultRetentionPolicy(database)
+		if err != nil {
+			return 0, fmt.Errorf("failed to determine default retention policy: %s", err.Error())
+		} else if rp == nil {
+			return 0, ErrDefaultRetentionPolicyNotFound
+		}
+		retentionPolicy = rp.Name
+	}
+
+	// Retrieve measurement.
+	m, err := s.measurement(database, name)
+	if err != nil {
+		return 0, err
+	} else if m == nil {
+		return 0, ErrMeasurementNotFound
+	}
+
+	// Convert untyped numbers to the type of their field and reject values
+	// that do not match the type of an existing field.
+	values = m.normalizeValues(values)
+	if err := m.validateFieldTypes(values); err != nil {
+		return 0, err
+	}
+
+	// Retrieve shard group.
+	g, err := s.createShardGroupIfNotExists(database, retentionPolicy, timestamp)
+	if err != nil {
+		return 0, fmt.Errorf("create shard(%s/%s): %s", retentionPolicy, timestamp.Format(time.RFC3339Nano), err)
+	}
+
+	// Find appropriate shard within the shard group.
+	sh := g.ShardBySeriesID(seriesID)
+
+	// Ignore requests that have no values.
+	if len(values) == 0 {
+		return 0, nil
+	}
+	expired = s.shardGroupExpired(database, retentionPolicy, g)
+
+	// Data nodes that predate typed values decode every value as a float and
+	// ignore expiry so other writes wait until every data node is upgraded.
+	typed := s.checkFormat(typedValuesMessageFormat) == nil
+	if !typed && (expires != 0 || !floatValues(values)) {
+		return 0, ErrMessageFormatUnsupported
+	}
+
+	// Convert string-key/values to fieldID-key/values.
+	// If not all fields can be converted then send as a non-raw write series.
+	rawValues := m.mapValues(values)
+	if rawValues == nil || requestID != "" {
+		// Record the value types since JSON does not distinguish integers.
+		types := make(map[string]influxql.DataType, len(values))
+		for k, v := range values {
+			types[k] = influxql.InspectDataType(v)
+		}
+
+		// Encode the command.
+		data := mustMarshalJSON(&writeSeriesCommand{
+			Database:    database,
+			Measurement: name,
+			SeriesID:    seriesID,
+			Timestamp:   timestamp.UnixNano(),
+			Values:      values,
+			Types:       types,
+			Expires:     expires,
+			RequestID:   requestID,
+			CreatedAt:   s.clock.Now().UnixNano(),
+		})
+
+		// Publish "write series" message on shard's topic to broker.
+		index, err = s.publish(&messaging.Message{
+			Type:    writeSeriesMessageType,
+			TopicID: sh.ID,
+			Data:    data,
+		})
+		if err != nil {
+			return 0, err
+		}
+		return index, s.waitForReplicas(sh, index, level)
+	}
+
+	// If we can successfully encode the string keys to raw field ids then
+	// we can send a raw write series message which is much smaller and faster.
+
+	// Encode point header.
+	if expires != 0 {
+		rawValues[expiryFieldID] = expires
+	}
+	data := marshalPointHeader(seriesID, timestamp.UnixNano())
+	if typed {
+		data = append(data, marshalValues(rawValues)...)
+	} else {
+		data = append(data, marshalFloatValues(rawValues)...)
+	}
+
+	// Publish "raw write series" message on shard's topic to broker.
+	index, err = s.publish(&messaging.Message{
+		Type:    writeRawSeriesMessageType,
+		TopicID: sh.ID,
+		Data:    data,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return index, s.waitForReplicas(sh, index, level)
+}
+
+// floatValues returns true if every value is a number stored as a float.
+func floatValues(values map[string]interface{}) bool {
+	for _, v := range values {
+		switch v.(type) {
+		case float64, int:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type writeSeriesCommand struct {
+	Database    string                       `json:"database"`
+	Measurement string                       `json:"measurement"`
+	SeriesID    uint32                       `json:"seriesID"`
+	Timestamp   int64                        `json:"timestamp"`
+	Values      map[string]interface{}       `json:"values"`
+	Types       map[string]influxql.DataType `json:"types,omitempty"`
+	Expires     int64                        `json:"expires,omitempty"`
+	RequestID   string                       `json:"requestID,omitempty"`
+
+	// CreatedAt is the publisher's time, recorded on any fields the write creates.
+	CreatedAt int64 `json:"createdAt,omitempty"`
+}
+
+// applyWriteSeries writes "non-raw" series data to the database.
+// Non-raw data occurs when fields have not been created yet so the field
+// names cannot be converted to field ids.
+func (s *Server) applyWriteSeries(m *messaging.Message) error {
+	var c writeSeriesCommand
+	dec := json.NewDecoder(bytes.NewReader(m.Data))
+	dec.UseNumber()
+	if err := dec.Decode(&c); err != nil {
+		panic("unmarshal: " + err.Error())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve the shard.
+	sh := s.shards[m.TopicID]
+	if sh == nil {
+		return ErrShardNotFound
+	}
+
+	// Retrieve the database.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Retrieve the measurement.
+	mm := db.measurements[c.Measurement]
+	if mm == nil {
+		return ErrMeasurementNotFound
+	}
+
+	// Use the publisher's time for new fields. Commands from nodes that
+	// don't send it leave the creation time unset on every replica.
+	var createdAt time.Time
+	if c.CreatedAt != 0 {
+		createdAt = time.Unix(0, c.CreatedAt).UTC()
+	}
+
+	// Encode value map and create fields as needed.
+	rawValues := make(map[uint8]interface{}, len(c.Values))
+	for k, v := range c.Values {
+		// Restore numbers to the type they were written with.
+		if n, ok := v.(json.Number); ok {
+			if c.Types[k] == influxql.Integer {
+				v, _ = n.Int64()
+			} else {
+				v, _ = n.Float64()
+			}
+		}
+
+		// Find or create fields.
+		// If too many fields are on the measurement then log the issue.
+		// If the value conflicts with the existing field type then log and skip it.
+		// If any other error occurs then exit.
+		f, err := mm.createFieldIfNotExists(k, influxql.InspectDataType(v), createdAt)
+		if err == ErrFieldOverflow {
+			s.Logger.Warnf("no more fields allowed: %s::%s", mm.Name, k)
+			continue
+		} else if err, ok := err.(*FieldTypeConflictError); ok {
+			s.Logger.Warnf("%s", err)
+			continue
+		} else if err != nil {
+			return err
+		}
+		rawValues[f.ID] = v
+	}
+	if c.Expires != 0 {
+		rawValues[expiryFieldID] = c.Expires
+	}
+
+	// Update metastore.
+	if err := s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	}); err != nil {
+		return err
+	}
+
+	// Encode the values into a binary format.
+	data := marshalValues(rawValues)
+
+	// TODO: Enable some way to specify if the data should be overwritten
+	overwrite := true
+
+	// Write to shard.
+	if err := sh.writeSeries(c.SeriesID, c.Timestamp, data, overwrite); err != nil {
+		return err
+	}
+	s.tailWrite(func() *AppliedWrite { return s.appliedWrite(sh.ID, c.SeriesID, c.Timestamp, data) })
+
+	// Log traced writes so they can be followed to the shard.
+	if c.RequestID != "" {
+		s.Logger.Debugf("write series: request=%s, index=%d, shard=%d, series=%d", c.RequestID, m.Index, sh.ID, c.SeriesID)
+	}
+	return nil
+}
+
+// applyWriteRawSeries writes raw series data to the database.
+// Raw series data has already converted field names to ids so the
+// representation is fast and compact.
+func (s *Server) applyWriteRawSeries(m *messaging.Message) error {
+	// Retrieve the shard.
+	sh := s.Shard(m.TopicID)
+	if sh == nil {
+		return ErrShardNotFound
+	}
+
+	// Extract the series id and timestamp from the header.
+	// Everything after the header is the marshalled value.
+	seriesID, timestamp := unmarshalPointHeader(m.Data[:pointHeaderSize])
+	data := m.Data[pointHeaderSize:]
+
+	// TODO: Enable some way to specify if the data should be overwritten
+	overwrite := true
+
+	// Write to shard.
+	if err := sh.writeSeries(seriesID, timestamp, data, overwrite); err != nil {
+		return err
+	}
+
+	// Report the write to any tail readers.
+	if !s.tails.empty() {
+		s.mu.RLock()
+		s.tailWrite(func() *AppliedWrite { return s.appliedWrite(sh.ID, seriesID, timestamp, data) })
+		s.mu.RUnlock()
+	}
+	return nil
+}
+
+func (s *Server) createSeriesIfNotExists(database, name string, tags map[string]string) (uint32, error) {
+	// Try to find series locally first.
+	s.mu.RLock()
+	idx := s.databases[database]
+	if idx == nil {
+		s.mu.RUnlock()
+		return 0, fmt.Errorf("database not found %q", database)
+	}
+	if _, series := idx.MeasurementAndSeries(name, tags); series != nil {
+		s.mu.RUnlock()
+		return series.ID, nil
+	}
+	// release the read lock so the broadcast can actually go through and acquire the write lock
+	s.mu.RUnlock()
+
+	// If it doesn't exist then create a message and broadcast.
+	c := &createSeriesIfNotExistsCommand{Database: database, Name: name, Tags: tags}
+	_, err := s.broadcast(createSeriesIfNotExistsMessageType, c)
+	if err != nil {
+		return 0, err
+	}
+
+	// Lookup series again.
+	_, series := idx.MeasurementAndSeries(name, tags)
+	if series == nil {
+		return 0, ErrSeriesNotFound
+	}
+	return series.ID, nil
+}
+
+// ReadSeries reads a single point from a series in the database.
+func (s *Server) ReadSeries(database, retentionPolicy, name string, tags map[string]string, timestamp time.Time) (map[string]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Find database.
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	// Find series using the same tag rules as writes.
+	mm, series := db.MeasurementAndSeries(name, s.tagNormalizer.Normalize(tags))
+	if mm == nil {
+		return nil, ErrMeasurementNotFound
+	} else if series == nil {
+		return nil, ErrSeriesNotFound
+	}
+
+	// If the retention policy is not specified, use the default for this database.
+	if retentionPolicy == "" {
+		retentionPolicy = db.defaultRetentionPolicy
+	}
+
+	// Retrieve retention policy.
+	rp := db.policies[retentionPolicy]
+	if rp == nil {
+		return nil, ErrRetentionPolicyNotFound
+	}
+
+	// Retrieve shard group.
+	g, err := s.shardGroupByTimestamp(database, retentionPolicy, timestamp)
+	if err != nil {
+		return nil, err
+	} else if g == nil {
+		return nil, nil
+	}
+
+	// TODO: Verify that server owns shard.
+
+	// Find appropriate shard within the shard group.
+	sh := g.Shards[int(series.ID)%len(g.Shards)]
+
+	// Read raw encoded series data.
+	data, err := sh.readSeries(series.ID, timestamp.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+
+	// Ignore values that have expired.
+	if expires := unmarshalExpiry(data); expires != 0 && expires <= s.clock.Now().UnixNano() {
+		return nil, nil
+	}
+
+	// Decode into a raw value map.
+	rawValues, err := unmarshalValues(data)
+	if err != nil {
+		return nil, err
+	} else if rawValues == nil {
+		return nil, nil
+	}
+
+	// Decode into a string-key value map.
+	values := make(map[string]interface{}, len(rawValues))
+	for fieldID, value := range rawValues {
+		f := mm.Field(fieldID)
+		if f == nil {
+			continue
+		}
+		values[f.Name] = value
+	}
+
+	return values, nil
+}
+
+// ExecuteQuery executes an InfluxQL query against the server.
+// Returns a resultset for each statement in the query.
+// Stops on first execution error that occurs. Standby data nodes don't
+// execute queries and return ErrDataNodeStandby.
+func (s *Server) ExecuteQuery(q *influxql.Query, database string, user *User) Results {
+	defer s.queryLatency.observeSince(time.Now())
+	s.counters.addQuery(database)
+
+	// Build empty resultsets.
+	results := make(Results, len(q.Statements))
+
+	// Execute each statement.
+	for i, stmt := range q.Statements {
+		var res *Result
+		if s.Standby() {
+			res = &Result{Err: ErrDataNodeStandby}
+		} else if err := s.authorize(stmt, database, user); err != nil {
+			res = &Result{Err: err}
+		} else if ctx, done, err := s.registerQuery(context.Background(), stmt, database, user); err != nil {
+			res = &Result{Err: err}
+		} else {
+			res = s.executeStatement(ctx, stmt, database, user)
+			done()
+		}
+
+		// If an error occurs then stop processing remaining statements.
+		results[i] = res
+		if res.Err != nil {
+			break
+		}
+	}
+
+	// Fill any empty results after error.
+	for i, res := range results {
+		if res == nil {
+			results[i] = &Result{Err: ErrNotExecuted}
+		}
+	}
+
+	if results.Error() != nil {
+		s.counters.addQueryError(database)
+	}
+	return results
+}
+
+// ExecuteQueryStream executes an InfluxQL query against the server and streams
+// rows back as they are produced instead of materializing all results first.
+//
+// Rows from select statements are sent as soon as the executor emits them.
+// Errors are sent as a row with Err set and stop processing of the remaining
+// statements, which are reported as ErrNotExecuted. The channel is closed
+// after the last statement. Callers that stop reading early must cancel ctx,
+// which stops the query and closes the channel without sending more rows.
+func (s *Server) ExecuteQueryStream(ctx context.Context, q *influxql.Query, database string, user *User) <-chan *ResultRow {
+	ch := make(chan *ResultRow, 0)
+	go s.executeQueryStream(ctx, q, database, user, ch)
+	return ch
+}
+
+// executeQueryStream runs in a separate goroutine and streams statement output to ch.
+func (s *Server) executeQueryStream(ctx context.Context, q *influxql.Query, database string, user *User, ch chan *ResultRow) {
+	defer close(ch)
+	defer s.queryLatency.observeSince(time.Now())
+	s.counters.addQuery(database)
+
+	for i, stmt := range q.Statements {
+		// Standby nodes don't serve queries until they're promoted.
+		if s.Standby() {
+			s.abortQueryStream(ctx, q.Statements, i, database, ErrDataNodeStandby, ch)
+			return
+		}
+
+		// Check that the user can execute the statement.
+		if err := s.authorize(stmt, database, user); err != nil {
+			s.abortQueryStream(ctx, q.Statements, i, database, err, ch)
+			return
+		}
+
+		if !s.executeStatementStream(ctx, q.Statements, i, database, user, ch) {
+			return
+		}
+	}
+}
+
+// executeStatementStream executes statement i of a query and streams its rows
+// to ch. The statement is registered as running until its last row is sent.
+// Returns false if the statement failed and the query was aborted or if ctx
+// was cancelled.
+func (s *Server) executeStatementStream(ctx context.Context, stmts influxql.Statements, i int, database string, user *User, ch chan *ResultRow) bool {
+	stmtCtx, done, err := s.registerQuery(ctx, stmts[i], database, user)
+	if err != nil {
+		s.abortQueryStream(ctx, stmts, i, database, err, ch)
+		return false
+	}
+	defer done()
+
+	// Stream select statements directly from the executor.
+	if stmt, ok := stmts[i].(*influxql.SelectStatement); ok {
+		rows, err := s.executeSelectStatementStream(stmtCtx, stmt, database, user)
+		if err != nil {
+			s.abortQueryStream(ctx, stmts, i, database, err, ch)
+			return false
+		}
+		for row := range rows {
+			if row.Err != nil {
+				s.abortQueryStream(ctx, stmts, i, database, queryError(row.Err), ch)
+				return false
+			} else if !sendResultRow(ctx, ch, &ResultRow{StatementID: i, Row: row}) {
+				// The executor stops reading once cancelled but must still
+				// be drained so that it can exit.
+				for range rows {
+				}
+				return false
+			}
+		}
+		return true
+	}
+
+	// All other statements return a small, fully materialized result.
+	res := s.executeStatement(stmtCtx, stmts[i], database, user)
+	for _, row := range res.Rows {
+		if !sendResultRow(ctx, ch, &ResultRow{StatementID: i, Row: row}) {
+			return false
+		}
+	}
+	if res.Err != nil {
+		s.abortQueryStream(ctx, stmts, i, database, res.Err, ch)
+		return false
+	}
+	return true
+}
+
+// sendResultRow sends a row to ch unless ctx is cancelled first.
+// Returns false if the row wasn't sent.
+func sendResultRow(ctx context.Context, ch chan *ResultRow, row *ResultRow) bool {
+	select {
+	case ch <- row:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// authorize returns an error if a user cannot execute a statement. Reads and
+// writes require a privilege on the database and all other statements require
+// an admin. All statements are allowed for a nil user, which is passed when
+// authentication is disabled.
+func (s *Server) authorize(stmt influxql.Statement, database string, user *User) error {
+	if user == nil || user.Admin {
+		return nil
+	}
+
+	switch stmt := stmt.(type) {
+	case *influxql.ListDatabasesStatement:
+		return nil
+	case *influxql.SelectStatement:
+		// Every database read by the statement requires read access. Sources
+		// that cannot be resolved are reported when the statement executes.
+		sources, err := s.selectSources(stmt, database)
+		if err != nil || len(sources) == 0 {
+			sources = []*selectSource{{database: database}}
+		}
+		for _, src := range sources {
+			if !user.Authorize(influxql.ReadPrivilege, src.database) {
+				return ErrReadAccessDenied
+			}
+		}
+
+		// Writing results into another database requires write access to it.
+		if stmt.Target != nil {
+			target := stmt.Target.Database
+			if target == "" {
+				target = database
+			}
+			if !user.Authorize(influxql.WritePrivilege, target) {
+				return ErrWriteAccessDenied
+			}
+		}
+	case *influxql.ListRetentionPoliciesStatement:
+		if !user.Authorize(influxql.ReadPrivilege, stmt.Database) {
+			return ErrReadAccessDenied
+		}
+	case *influxql.ListSeriesStatement, *influxql.ListMeasurementsStatement,
+		*influxql.ListTagKeysStatement, *influxql.ListTagValuesStatement,
+		*influxql.ListFieldKeysStatement, *influxql.ListFieldValuesStatement,
+		*influxql.ListSeriesTimesStatement,
+		*influxql.ListContinuousQueriesStatement, *influxql.ListEventsStatement:
+		if !user.Authorize(influxql.ReadPrivilege, database) {
+			return ErrReadAccessDenied
+		}
+	case *influxql.DeleteStatement, *influxql.DropSeriesStatement:
+		if !user.Authorize(influxql.WritePrivilege, database) {
+			return ErrWriteAccessDenied
+		}
+	case *influxql.ListGrantsStatement:
+		// Users may list their own privileges.
+		if stmt.Name != user.Name {
+			return ErrAdminRequired
+		}
+	case *influxql.ListStatsStatement:
+		// Statistics for a single database only require read access to it.
+		if stmt.Database == "" {
+			return ErrAdminRequired
+		} else if !user.Authorize(influxql.ReadPrivilege, stmt.Database) {
+			return ErrReadAccessDenied
+		}
+	default:
+		return ErrAdminRequired
+	}
+	return nil
+}
+
+// abortQueryStream sends the error of statement i and marks the remaining
+// statements as not executed.
+func (s *Server) abortQueryStream(ctx context.Context, stmts influxql.Statements, i int, database string, err error, ch chan *ResultRow) {
+	s.counters.addQueryError(database)
+	if sendResultRow(ctx, ch, &ResultRow{StatementID: i, Err: err}) {
+		s.notExecuted(ctx, stmts[i+1:], i+1, ch)
+	}
+}
+
+// notExecuted sends an ErrNotExecuted row for each statement, starting at offset.
+func (s *Server) notExecuted(ctx context.Context, stmts influxql.Statements, offset int, ch chan *ResultRow) {
+	for i := range stmts {
+		if !sendResultRow(ctx, ch, &ResultRow{StatementID: offset + i, Err: ErrNotExecuted}) {
+			return
+		}
+	}
+}
+
+// executeStatement executes a single statement against the server.
+// Select statements stop reading data when ctx is cancelled.
+// ExecuteQuery and ExecuteQueryStream both report the returned result as-is,
+// so every supported statement must return a non-nil result.
+func (s *Server) executeStatement(ctx context.Context, stmt influxql.Statement, database string, user *User) *Result {
+	switch stmt := stmt.(type) {
+	case *influxql.SelectStatement:
+		return s.executeSelectStatement(ctx, stmt, database, user)
+	case *influxql.CreateDatabaseStatement:
+		return s.executeCreateDatabaseStatement(stmt, user)
+	case *influxql.DropDatabaseStatement:
+		return s.executeDropDatabaseStatement(stmt, user)
+	case *influxql.ListDatabasesStatement:
+		return s.executeListDatabasesStatement(stmt, user)
+	case *influxql.ReindexDatabaseStatement:
+		return s.executeReindexDatabaseStatement(stmt, user)
+	case *influxql.CreateUserStatement:
+		return s.executeCreateUserStatement(stmt, user)
+	case *influxql.DropUserStatement:
+		return s.executeDropUserStatement(stmt, user)
+	case *influxql.DropSeriesStatement:
+		return s.executeDropSeriesStatement(stmt, database, user)
+	case *influxql.DeleteStatement:
+		return s.executeDeleteStatement(stmt, database, user)
+	case *influxql.ListSeriesStatement:
+		return s.executeListSeriesStatement(stmt, database, user)
+	case *influxql.ListShardsStatement:
+		return s.executeListShardsStatement(stmt, user)
+	case *influxql.ListExpiredShardsStatement:
+		return s.executeListExpiredShardsStatement(stmt, user)
+	case *influxql.ListEventsStatement:
+		return s.executeListEventsStatement(stmt, database, user)
+	case *influxql.ListMeasurementsStatement:
+		return s.executeListMeasurementsStatement(stmt, database, user)
+	case *influxql.ListTagKeysStatement:
+		return s.executeListTagKeysStatement(stmt, database, user)
+	case *influxql.ListTagValuesStatement:
+		return s.executeListTagValuesStatement(stmt, database, user)
+	case *influxql.ListFieldKeysStatement:
+		return s.executeListFieldKeysStatement(stmt, database, user)
+	case *influxql.ListFieldValuesStatement:
+		return s.executeListFieldValuesStatement(stmt, database, user)
+	case *influxql.ListSeriesTimesStatement:
+		return s.executeListSeriesTimesStatement(stmt, database, user)
+	case *influxql.GrantStatement:
+		return s.executeGrantStatement(stmt, user)
+	case *influxql.RevokeStatement:
+		return s.executeRevokeStatement(stmt, user)
+	case *influxql.ListGrantsStatement:
+		return s.executeListGrantsStatement(stmt, user)
+	case *influxql.CreateRetentionPolicyStatement:
+		return s.executeCreateRetentionPolicyStatement(stmt, user)
+	case *influxql.AlterRetentionPolicyStatement:
+		return s.executeAlterRetentionPolicyStatement(stmt, user)
+	case *influxql.DropRetentionPolicyStatement:
+		return s.executeDropRetentionPolicyStatement(stmt, user)
+	case *influxql.ListRetentionPoliciesStatement:
+		return s.executeListRetentionPoliciesStatement(stmt, user)
+	case *influxql.CreateContinuousQueryStatement:
+		return s.executeCreateContinuousQueryStatement(stmt, user)
+	case *influxql.DropContinuousQueryStatement:
+		return s.executeDropContinuousQueryStatement(stmt, user)
+	case *influxql.ListContinuousQueriesStatement:
+		return s.executeListContinuousQueriesStatement(stmt, user)
+	case *influxql.ListQueriesStatement:
+		return s.executeListQueriesStatement(stmt, user)
+	case *influxql.KillQueryStatement:
+		return s.executeKillQueryStatement(stmt, user)
+	case *influxql.BlockStatement:
+		return s.executeBlockStatement(stmt, user)
+	case *influxql.UnblockStatement:
+		return s.executeUnblockStatement(stmt, user)
+	case *influxql.ListBlocksStatement:
+		return s.executeListBlocksStatement(stmt, user)
+	case *influxql.ListStatsStatement:
+		return s.executeListStatsStatement(stmt, user)
+	case *influxql.ListDiagnosticsStatement:
+		return s.executeListDiagnosticsStatement(stmt, user)
+	default:
+		panic(fmt.Sprintf("unsupported statement type: %T", stmt))
+	}
+}
+
+// executeSelectStatement plans and executes a select statement against a database.
+func (s *Server) executeSelectStatement(ctx context.Context, stmt *influxql.SelectStatement, database string, user *User) *Result {
+	// Plan and execute statement.
+	ch, err := s.executeSelectStatementStream(ctx, stmt, database, user)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	// Read all rows from channel.
+	res := &Result{Rows: make([]*influxql.Row, 0)}
+	for row := range ch {
+		if row.Err != nil {
+			return &Result{Err: queryError(row.Err)}
+		}
+		res.Rows = append(res.Rows, row)
+	}
+
+	return res
+}
+
+// executeSelectStatementStream plans and executes a select statement against a database.
+// Returns a channel that streams rows as they are produced by the executor.
+// Execution stops with the context's error if ctx is cancelled.
+func (s *Server) executeSelectStatementStream(ctx context.Context, stmt *influxql.SelectStatement, database string, user *User) (<-chan *influxql.Row, error) {
+	// Resolve the database of each measurement in the source.
+	sources, err := s.selectSources(stmt, database)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject the statement if it would exceed the query cost budget.
+	if err := s.checkQueryCost(sources); err != nil {
+		return nil, err
+	}
+
+	// Plan a statement for each measurement in the source.
+	executors := make([]*influxql.Executor, len(sources))
+	for i, src := range sources {
+		e, err := s.planSelectStatement(src)
+		if err != nil {
+			return nil, err
+		}
+		executors[i] = e
+	}
+
+	// Execute a single plan directly unless its rows need to be renamed.
+	if len(executors) == 1 && !sources[0].qualified() {
+		return executors[0].ExecuteContext(ctx)
+	}
+
+	// Otherwise execute each plan in order and stream all rows to one channel.
+	// Rows from qualified measurements are named as they were in the query so
+	// measurements with the same name in different databases can be told apart.
+	out := make(chan *influxql.Row, 0)
+	go func() {
+		defer close(out)
+		for i, e := range executors {
+			ch, err := e.ExecuteContext(ctx)
+			if err != nil {
+				out <- &influxql.Row{Err: err}
+				return
+			}
+			for row := range ch {
+				if row.Err != nil {
+					out <- row
+					return
+				}
+				if sources[i].qualified() {
+					row.Name = sources[i].name
+				}
+				out <- row
+			}
+		}
+	}()
+	return out, nil
+}
+
+// selectSource represents a select statement split by measurement along with
+// the database and retention policy the measurement belongs to.
+type selectSource struct {
+	stmt     *influxql.SelectStatement // statement with an unqualified source
+	name     string                    // measurement name as written in the query
+	database string
+	rp       string // empty for the default retention policy
+}
+
+// qualified returns true if the measurement was qualified in the query.
+func (src *selectSource) qualified() bool {
+	m, ok := src.stmt.Source.(*influxql.Measurement)
+	return ok && m.Name != src.name
+}
+
+// selectSources splits a select statement by measurement and resolves the
+// database each measurement belongs to. Measurements may be qualified by
+// quoted database and retention policy segments, such as "db"."rp"."cpu" or
+// "db".."cpu", to read from databases other than the default database.
+// Regular expressions are replaced by the matching measurements of the
+// default database.
+func (s *Server) selectSources(stmt *influxql.SelectStatement, defaultDatabase string) ([]*selectSource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sources []*selectSource
+	for _, stmt := range stmt.Split() {
+		// Regular expressions are expanded to every matching measurement name
+		// in the default database.
+		if m, ok := stmt.Source.(*influxql.Measurement); ok && m.Regex != nil {
+			db := s.databases[defaultDatabase]
+			if db == nil {
+				return nil, ErrDatabaseNotFound
+			}
+			for _, name := range db.measurementNamesByRegex(m.Regex.Val) {
+				other := *stmt
+				other.Source = &influxql.Measurement{Name: name}
+				sources = append(sources, &selectSource{stmt: &other, name: name, database: defaultDatabase})
+			}
+			continue
+		}
+
+		src := &selectSource{stmt: stmt, database: defaultDatabase}
+		sources = append(sources, src)
+
+		// Only single measurements can be qualified.
+		m, ok := stmt.Source.(*influxql.Measurement)
+		if !ok {
+			continue
+		}
+		src.name = m.Name
+
+		// Measurements existing in the default database are never split.
+		if db := s.databases[defaultDatabase]; db != nil && db.measurements[m.Name] != nil {
+			continue
+		}
+
+		database, rp, name, err := splitMeasurement(m.Name)
+		if err != nil {
+			return nil, err
+		} else if database != "" {
+			src.database = database
+		}
+		src.rp = rp
+
+		// Validate the database and retention policy.
+		db := s.databases[src.database]
+		if db == nil {
+			return nil, ErrDatabaseNotFound
+		} else if rp != "" && db.policies[rp] == nil {
+			return nil, ErrRetentionPolicyNotFound
+		}
+
+		// Copy the statement so the source can be replaced.
+		other := *stmt
+		other.Source = &influxql.Measurement{Name: name}
+		src.stmt = &other
+	}
+
+	// Read downsampled data in place of the default policy, where enabled.
+	for _, src := range sources {
+		s.downsampleSource(src)
+	}
+	return sources, nil
+}
+
+// SetDownsampleQueries sets whether queries on a database read from a
+// downsampled retention policy when their GROUP BY interval is coarser than
+// a downsample policy's interval. Only queries on the default retention
+// policy are rewritten and only if the rollup covers their time range.
+// Other queries read the raw data.
+func (s *Server) SetDownsampleQueries(database string, enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.downsampleQueries == nil {
+		s.downsampleQueries = make(map[string]bool)
+	}
+	s.downsampleQueries[database] = enabled
+}
+
+// downsampleSource sets the retention policy of a source that doesn't name one
+// to a downsampled policy of the default policy that can answer its statement.
+// This function must be called under a read lock.
+func (s *Server) downsampleSource(src *selectSource) {
+	if src.rp != "" || !s.downsampleQueries[src.database] {
+		return
+	} else if _, ok := src.stmt.Source.(*influxql.Measurement); !ok {
+		return
+	}
+
+	db := s.databases[src.database]
+	if db == nil || db.defaultRetentionPolicy == "" {
+		return
+	}
+
+	// Unbounded queries run up to the current time.
+	now := s.clock.Now()
+	src.stmt.Condition = influxql.Fold(src.stmt.Condition, &now)
+	min, max := influxql.TimeRange(src.stmt.Condition)
+	if max.IsZero() {
+		max = now
+	}
+
+	covers := func(dp *DownsamplePolicy) bool {
+		return s.downsampleCovers(db, dp, src.stmt.Source.(*influxql.Measurement).Name, min, max, now)
+	}
+	if rp := db.downsampleTarget(db.defaultRetentionPolicy, src.stmt, covers); rp != "" {
+		src.rp = rp
+	}
+}
+
+// downsampleCovers returns true if a downsample policy's rollup of a
+// measurement holds the data between min and max. The rollup must still
+// retain min and must have been written up to max: its last interval has to
+// end after max. Rollups stored on other data nodes aren't read so queries
+// on them read the raw data.
+// This function must be called under a read lock.
+func (s *Server) downsampleCovers(db *database, dp *DownsamplePolicy, name string, min, max, now time.Time) bool {
+	rp := db.policies[dp.Target]
+	if rp.Duration > 0 && min.Before(now.Add(-rp.Duration)) {
+		return false
+	}
+	m := db.measurements[name]
+	if m == nil {
+		return false
+	}
+
+	// Look for a rolled up interval that ends after max.
+	times, err := s.readSeriesTimes(rp, m.ids, max.Add(-dp.Interval+1), max)
+	if err != nil {
+		s.Logger.Errorf("downsample: %s: %s", dp.Name, err)
+		return false
+	}
+	return len(times) > 0
+}
+
+// splitMeasurement splits a measurement name into its database, retention
+// policy and measurement segments. Missing segments are returned as blank.
+func splitMeasurement(name string) (database, rp, measurement string, err error) {
+	segments, err := influxql.SplitIdent(name)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid measurement: %s", name)
+	}
+
+	switch len(segments) {
+	case 1:
+		return "", "", segments[0], nil
+	case 2:
+		return "", segments[0], segments[1], nil
+	case 3:
+		return segments[0], segments[1], segments[2], nil
+	default:
+		return "", "", "", fmt.Errorf("invalid measurement: %s", name)
+	}
+}
+
+// checkQueryCost returns a QueryCostError if the combined estimated cost of
+// select statements exceeds the query cost budget. The combined cost is
+// bounded by the largest shard and bucket counts of any statement. Conditions
+// are folded in place the same way the planner folds them.
+func (s *Server) checkQueryCost(sources []*selectSource) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.maxQueryCost <= 0 {
+		return nil
+	}
+
+	total := &QueryCost{}
+	now := s.clock.Now()
+	for _, src := range sources {
+		db := s.databases[src.database]
+		if db == nil {
+			return ErrDatabaseNotFound
+		}
+
+		src.stmt.Condition = influxql.Fold(src.stmt.Condition, &now)
+		cost, err := db.selectCost(src.stmt, src.rp, now)
+		if err != nil {
+			return err
+		}
+
+		if cost.Shards > total.Shards {
+			total.Shards = cost.Shards
+		}
+		if cost.Buckets > total.Buckets {
+			total.Buckets = cost.Buckets
+		}
+		total.Series += cost.Series
+	}
+
+	if total.Total() > s.maxQueryCost {
+		return &QueryCostError{Cost: total, Budget: s.maxQueryCost}
+	}
+	return nil
+}
+
+// plans a selection statement under lock.
+func (s *Server) planSelectStatement(src *selectSource) (*influxql.Executor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Find database.
+	db := s.databases[src.database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	// Shard pseudo tags are resolved in the one shard group the statement
+	// reads from. The time range is found the same way the planner finds it.
+	now := s.clock.Now()
+	d := &dbi{server: s, db: db, rp: src.rp}
+	if usesShardPseudoTags(src.stmt) {
+		src.stmt.Condition = influxql.Fold(src.stmt.Condition, &now)
+		min, max := influxql.TimeRange(src.stmt.Condition)
+		if max.IsZero() {
+			max = now
+		}
+		if err := d.setShardGroup(min, max); err != nil {
+			return nil, err
+		}
+	}
+
+	// Plan query.
+	p := influxql.NewPlanner(d)
+	p.Now = func() time.Time { return now }
+	return p.Plan(src.stmt)
+}
+
+func (s *Server) executeCreateDatabaseStatement(q *influxql.CreateDatabaseStatement, user *User) *Result {
+	if rp := createDatabaseRetentionPolicy(q); rp != nil {
+		return &Result{Err: s.CreateDatabaseWithRetentionPolicy(q.Name, rp)}
+	}
+	return &Result{Err: s.CreateDatabase(q.Name)}
+}
+
+// createDatabaseRetentionPolicy returns the default retention policy of a
+// CREATE DATABASE statement. Returns nil if the statement doesn't create one.
+func createDatabaseRetentionPolicy(q *influxql.CreateDatabaseStatement) *RetentionPolicy {
+	if !q.RetentionPolicyCreate {
+		return nil
+	}
+	rp := NewRetentionPolicy(DefaultRetentionPolicyName)
+	if q.RetentionPolicyName != "" {
+		rp.Name = q.RetentionPolicyName
+	}
+	if q.RetentionPolicyDuration != nil {
+		rp.Duration = *q.RetentionPolicyDuration
+	}
+	if q.RetentionPolicyReplication != nil {
+		rp.ReplicaN = uint32(*q.RetentionPolicyReplication)
+	}
+	return rp
+}
+
+func (s *Server) executeDropDatabaseStatement(q *influxql.DropDatabaseStatement, user *User) *Result {
+	return &Result{Err: s.DeleteDatabase(q.Name)}
+}
+
+func (s *Server) executeReindexDatabaseStatement(q *influxql.ReindexDatabaseStatement, user *User) *Result {
+	return &Result{Err: s.ReindexDatabase(q.Name)}
+}
+
+func (s *Server) executeListDatabasesStatement(q *influxql.ListDatabasesStatement, user *User) *Result {
+	row := &influxql.Row{Columns: []string{"Name"}}
+	for _, name := range s.Databases() {
+		row.Values = append(row.Values, []interface{}{name})
+	}
+	return &Result{Rows: []*influxql.Row{row}}
+}
+
+func (s *Server) executeListShardsStatement(q *influxql.ListShardsStatement, user *User) *Result {
+	res := &Result{Rows: make([]*influxql.Row, 0)}
+	for _, o := range s.ShardOwnership() {
+		row := &influxql.Row{
+			Name:    "shards",
+			Tags:    map[string]string{"dataNodeID": strconv.FormatUint(o.DataNodeID, 10), "url": o.URL.String()},
+			Columns: []string{"id", "database", "retentionPolicy", "startTime", "endTime", "size"},
+		}
+		for _, sh := range o.Shards {
+			// Shards stored on other data nodes have no size.
+			var size interface{}
+			if sh.Size != nil {
+				size = *sh.Size
+			}
+			row.Values = append(row.Values, []interface{}{sh.ID, sh.Database, sh.RetentionPolicy, sh.StartTime, sh.EndTime, size})
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	return res
+}
+
+func (s *Server) executeDropSeriesStatement(q *influxql.DropSeriesStatement, database string, user *User) *Result {
+	s.mu.RLock()
+
+	// Find the database.
+	db := s.databases[database]
+	if db == nil {
+		s.mu.RUnlock()
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	// Determine which measurements to drop series from.
+	var names []string
+	if q.Name != "" {
+		names = append(names, q.Name)
+	}
+	if q.Name == "" || q.Source != nil {
+		a, err := db.sourceNames(q.Source)
+		if err != nil {
+			s.mu.RUnlock()
+			return &Result{Err: err}
+		}
+		names = append(names, a...)
+	}
+
+	// Find the series that match the condition.
+	var ids []uint32
+	for _, name := range names {
+		m := db.measurements[name]
+		if m == nil {
+			continue
+		}
+		for _, id := range m.ids {
+			ok, err := m.seriesByID[id].matchExpr(q.Condition)
+			if err != nil {
+				s.mu.RUnlock()
+				return &Result{Err: err}
+			} else if ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	if len(ids) == 0 {
+		return &Result{}
+	}
+	return &Result{Err: s.DropSeries(database, ids)}
+}
+
+func (s *Server) executeDeleteStatement(q *influxql.DeleteStatement, database string, user *User) *Result {
+	s.mu.RLock()
+
+	// Find the database.
+	db := s.databases[database]
+	if db == nil {
+		s.mu.RUnlock()
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	names, err := db.sourceNames(q.Source)
+	if err != nil {
+		s.mu.RUnlock()
+		return &Result{Err: err}
+	}
+
+	// Split the condition into the time range and the tag condition.
+	// Time comparisons that can't be evaluated are rejected instead of
+	// being ignored, which would delete every point.
+	now := s.clock.Now()
+	folded := influxql.Fold(q.Condition, &now)
+	if err := validateTimeExpr(folded); err != nil {
+		s.mu.RUnlock()
+		return &Result{Err: err}
+	}
+	min, max := influxql.TimeRange(folded)
+	cond := tagExpr(q.Condition)
+
+	// Find the series that match the tag condition.
+	var ids []uint32
+	for _, name := range names {
+		m := db.measurements[name]
+		if m == nil {
+			continue
+		}
+		for _, id := range m.ids {
+			ok, err := m.seriesByID[id].matchExpr(cond)
+			if err != nil {
+				s.mu.RUnlock()
+				return &Result{Err: err}
+			} else if ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	if len(ids) == 0 {
+		return &Result{}
+	}
+	return &Result{Err: s.DeleteRange(database, ids, min, max)}
+}
+
+// validateTimeExpr returns an error if an expression compares time to anything
+// other than a time or duration literal.
+func validateTimeExpr(expr influxql.Expr) (err error) {
+	influxql.WalkFunc(expr, func(n influxql.Node) {
+		e, ok := n.(*influxql.BinaryExpr)
+		if !ok || err != nil || e.Op == influxql.AND || e.Op == influxql.OR {
+			return
+		}
+		for _, pair := range [][2]influxql.Expr{{e.LHS, e.RHS}, {e.RHS, e.LHS}} {
+			if ref, ok := pair[0].(*influxql.VarRef); !ok || strings.ToLower(ref.Val) != "time" {
+				continue
+			}
+			switch pair[1].(type) {
+			case *influxql.TimeLiteral, *influxql.DurationLiteral:
+			default:
+				err = fmt.Errorf("invalid time condition: %s", e)
+			}
+		}
+	})
+	return
+}
+
+func (s *Server) executeListSeriesStatement(q *influxql.ListSeriesStatement, database string, user *User) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	names, err := db.sourceNames(q.Source)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	// Return one row per measurement with the id & tag values of each series.
+	// The limit applies to the total number of series across measurements.
+	res := &Result{Rows: make([]*influxql.Row, 0)}
+	var n int
+	for _, name := range names {
+		m := db.measurements[name]
+		if m == nil {
 			continue
-		case *influxql.ListTagKeysStatement:
+		}
+
+		keys := db.TagKeys([]string{name})
+		row := &influxql.Row{Name: m.Name, Columns: append([]string{"id"}, keys...)}
+		for _, id := range m.ids {
+			if q.Limit > 0 && n >= q.Limit {
+				break
+			}
+
+			series := m.seriesByID[id]
+			if ok, err := series.matchExpr(q.Condition); err != nil {
+				return &Result{Err: err}
+			} else if !ok {
+				continue
+			}
+
+			values := make([]interface{}, 0, len(keys)+1)
+			values = append(values, id)
+			for _, k := range keys {
+				values = append(values, series.Tags[k])
+			}
+			row.Values = append(row.Values, values)
+			n++
+		}
+
+		if len(row.Values) > 0 {
+			res.Rows = append(res.Rows, row)
+		}
+	}
+	return res
+}
+
+func (s *Server) executeListSeriesTimesStatement(q *influxql.ListSeriesTimesStatement, database string, user *User) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+	rp := db.policies[db.defaultRetentionPolicy]
+	if rp == nil {
+		return &Result{Err: ErrDefaultRetentionPolicyNotFound}
+	}
+
+	names, err := db.sourceNames(q.Source)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	// Split the condition into the time range and the tag condition.
+	now := s.clock.Now()
+	min, max := influxql.TimeRange(influxql.Fold(q.Condition, &now))
+	if max.IsZero() {
+		max = time.Unix(0, math.MaxInt64).UTC()
+	}
+	cond := tagExpr(q.Condition)
+
+	// Return one row per measurement with the id, tag values and the first &
+	// last timestamps of each series. The limit applies to the total number
+	// of series across measurements.
+	res := &Result{Rows: make([]*influxql.Row, 0)}
+	var n int
+	for _, name := range names {
+		m := db.measurements[name]
+		if m == nil {
 			continue
-		case *influxql.ListTagValuesStatement:
+		}
+
+		// Find the matching series.
+		var ids []uint32
+		for _, id := range m.ids {
+			if q.Limit > 0 && n >= q.Limit {
+				break
+			}
+			if ok, err := m.seriesByID[id].matchExpr(cond); err != nil {
+				return &Result{Err: err}
+			} else if ok {
+				ids = append(ids, id)
+				n++
+			}
+		}
+		if len(ids) == 0 {
 			continue
-		case *influxql.ListFieldKeysStatement:
+		}
+
+		times, err := s.readSeriesTimes(rp, ids, min, max)
+		if err != nil {
+			return &Result{Err: err}
+		}
+
+		keys := db.TagKeys([]string{name})
+		row := &influxql.Row{Name: m.Name, Columns: append(append([]string{"id"}, keys...), "first", "last")}
+		for _, id := range ids {
+			values := make([]interface{}, 0, len(keys)+3)
+			values = append(values, id)
+			for _, k := range keys {
+				values = append(values, m.seriesByID[id].Tags[k])
+			}
+			if t, ok := times[id]; ok {
+				values = append(values, time.Unix(0, t[0]).UTC(), time.Unix(0, t[1]).UTC())
+			} else {
+				values = append(values, nil, nil)
+			}
+			row.Values = append(row.Values, values)
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	return res
+}
+
+// readSeriesTimes returns the first and last timestamps of each series stored
+// in local shards between min and max. Values are not decoded.
+// Series without data in the range are not included.
+func (s *Server) readSeriesTimes(rp *RetentionPolicy, ids []uint32, min, max time.Time) (map[uint32][2]int64, error) {
+	times := make(map[uint32][2]int64)
+	for _, g := range rp.shardGroups {
+		if g.EndTime.Before(min) || g.StartTime.After(max) {
 			continue
-		case *influxql.ListFieldValuesStatement:
+		}
+
+		// Group series by the shard they're stored in.
+		shardIDs := make(map[*Shard][]uint32)
+		for _, id := range ids {
+			if sh := g.ShardBySeriesID(id); sh.store != nil && sh.HasDataNodeID(s.id) {
+				shardIDs[sh] = append(shardIDs[sh], id)
+			}
+		}
+
+		for sh, ids := range shardIDs {
+			if err := readShardSeriesTimes(sh, ids, min, max, times); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return times, nil
+}
+
+// readShardSeriesTimes widens the first and last timestamps in times with
+// the timestamps of each series in a shard.
+func readShardSeriesTimes(sh *Shard, ids []uint32, min, max time.Time, times map[uint32][2]int64) error {
+	tx, err := sh.begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var tmin int64
+	if !min.IsZero() {
+		tmin = min.UnixNano()
+	}
+	tmax := max.UnixNano()
+	for _, id := range ids {
+		c := tx.Cursor(id)
+		if c == nil {
 			continue
-		case *influxql.GrantStatement:
+		}
+
+		first, v := c.SeekTo(tmin)
+		if err := cursorErr(c); err != nil {
+			return err
+		} else if v == nil || first > tmax {
 			continue
-		case *influxql.RevokeStatement:
+		}
+		last := first
+		for timestamp, v := c.Next(); v != nil && timestamp <= tmax; timestamp, v = c.Next() {
+			last = timestamp
+		}
+		if err := cursorErr(c); err != nil {
+			return err
+		}
+
+		t, ok := times[id]
+		if !ok || first < t[0] {
+			t[0] = first
+		}
+		if !ok || last > t[1] {
+			t[1] = last
+		}
+		times[id] = t
+	}
+	return nil
+}
+
+func (s *Server) executeListMeasurementsStatement(q *influxql.ListMeasurementsStatement, database string, user *User) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	// Restrict measurements by name or name pattern, if specified.
+	var matcher *Matcher
+	switch name := q.Name.(type) {
+	case *influxql.VarRef:
+		matcher = &Matcher{Name: name.Val}
+	case *influxql.RegexLiteral:
+		matcher = &Matcher{IsRegex: true, Name: name.Val.String(), Regex: name.Val}
+	}
+
+	row := &influxql.Row{Name: "measurements", Columns: []string{"name"}}
+	for _, name := range db.names {
+		if q.Limit > 0 && len(row.Values) >= q.Limit {
+			break
+		} else if matcher != nil && !matcher.Matches(name) {
 			continue
-		case *influxql.CreateRetentionPolicyStatement:
-			res = s.executeCreateRetentionPolicyStatement(stmt, user)
-		case *influxql.AlterRetentionPolicyStatement:
-			res = s.executeAlterRetentionPolicyStatement(stmt, user)
-		case *influxql.DropRetentionPolicyStatement:
-			res = s.executeDropRetentionPolicyStatement(stmt, user)
-		case *influxql.ListRetentionPoliciesStatement:
-			res = s.executeListRetentionPoliciesStatement(stmt, user)
-		case *influxql.CreateContinuousQueryStatement:
+		}
+
+		// Only include measurements with at least one series matching the condition.
+		m := db.measurements[name]
+		if m == nil {
 			continue
-		case *influxql.DropContinuousQueryStatement:
+		}
+		var matched bool
+		for _, id := range m.ids {
+			ok, err := m.seriesByID[id].matchExpr(q.Condition)
+			if err != nil {
+				return &Result{Err: err}
+			} else if ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
 			continue
-		case *influxql.ListContinuousQueriesStatement:
+		}
+
+		row.Values = append(row.Values, []interface{}{name})
+	}
+
+	res := &Result{Rows: make([]*influxql.Row, 0)}
+	if len(row.Values) > 0 {
+		res.Rows = append(res.Rows, row)
+	}
+	return res
+}
+
+func (s *Server) executeListTagKeysStatement(q *influxql.ListTagKeysStatement, database string, user *User) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	names, err := db.sourceNames(q.Source)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	// Return one row per measurement with the keys of the matching series.
+	// The limit applies to the total number of keys across measurements.
+	res := &Result{Rows: make([]*influxql.Row, 0)}
+	var n int
+	for _, name := range names {
+		if q.Limit > 0 && n >= q.Limit {
+			break
+		}
+		m := db.measurements[name]
+		if m == nil {
 			continue
-		default:
-			panic(fmt.Sprintf("unsupported statement type: %T", stmt))
 		}
 
-		// If an error occurs then stop processing remaining statements.
-		results[i] = res
-		if res.Err != nil {
+		set := make(map[string]struct{})
+		for _, id := range m.ids {
+			series := m.seriesByID[id]
+			if ok, err := series.matchExpr(q.Condition); err != nil {
+				return &Result{Err: err}
+			} else if !ok {
+				continue
+			}
+			for k := range series.Tags {
+				set[k] = struct{}{}
+			}
+		}
+
+		keys := make([]string, 0, len(set))
+		for k := range set {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if q.Limit > 0 && len(keys) > q.Limit-n {
+			keys = keys[:q.Limit-n]
+		}
+		n += len(keys)
+
+		if len(keys) > 0 {
+			row := &influxql.Row{Name: m.Name, Columns: []string{"tagKey"}}
+			for _, k := range keys {
+				row.Values = append(row.Values, []interface{}{k})
+			}
+			res.Rows = append(res.Rows, row)
+		}
+	}
+	return res
+}
+
+func (s *Server) executeListTagValuesStatement(q *influxql.ListTagValuesStatement, database string, user *User) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	names, err := db.sourceNames(q.Source)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	// Return one row per measurement with the distinct key/value pairs of
+	// the matching series, restricted to a single key if one is specified.
+	// The limit applies to the total number of pairs across measurements.
+	res := &Result{Rows: make([]*influxql.Row, 0)}
+	var n int
+	for _, name := range names {
+		if q.Limit > 0 && n >= q.Limit {
 			break
 		}
+		m := db.measurements[name]
+		if m == nil {
+			continue
+		}
+
+		set := make(map[[2]string]struct{})
+		for _, id := range m.ids {
+			series := m.seriesByID[id]
+			if ok, err := series.matchExpr(q.Condition); err != nil {
+				return &Result{Err: err}
+			} else if !ok {
+				continue
+			}
+			for k, v := range series.Tags {
+				if q.TagKey == "" || q.TagKey == k {
+					set[[2]string{k, v}] = struct{}{}
+				}
+			}
+		}
+
+		pairs := make(tagPairs, 0, len(set))
+		for p := range set {
+			pairs = append(pairs, p)
+		}
+		sort.Sort(pairs)
+		if q.Limit > 0 && len(pairs) > q.Limit-n {
+			pairs = pairs[:q.Limit-n]
+		}
+		n += len(pairs)
+
+		if len(pairs) > 0 {
+			row := &influxql.Row{Name: m.Name, Columns: []string{"tagKey", "tagValue"}}
+			for _, p := range pairs {
+				row.Values = append(row.Values, []interface{}{p[0], p[1]})
+			}
+			res.Rows = append(res.Rows, row)
+		}
+	}
+	return res
+}
+
+// tagPairs represents a sortable list of tag key/value pairs.
+type tagPairs [][2]string
+
+func (p tagPairs) Len() int { return len(p) }
+func (p tagPairs) Less(i, j int) bool {
+	if p[i][0] != p[j][0] {
+		return p[i][0] < p[j][0]
+	}
+	return p[i][1] < p[j][1]
+}
+func (p tagPairs) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+func (s *Server) executeListExpiredShardsStatement(q *influxql.ListExpiredShardsStatement, user *User) *Result {
+	res := &Result{Rows: make([]*influxql.Row, 0)}
+
+	// Group shard groups into one row per database & policy.
+	var row *influxql.Row
+	for _, g := range s.ExpiredShardGroups(s.clock.Now()) {
+		if row == nil || row.Tags["database"] != g.Database || row.Tags["retentionPolicy"] != g.RetentionPolicy {
+			row = &influxql.Row{
+				Name:    "shard_groups",
+				Tags:    map[string]string{"database": g.Database, "retentionPolicy": g.RetentionPolicy},
+				Columns: []string{"id", "startTime", "endTime", "shardN", "size"},
+			}
+			res.Rows = append(res.Rows, row)
+		}
+		row.Values = append(row.Values, []interface{}{g.ID, g.StartTime, g.EndTime, g.ShardN, g.Size})
+	}
+	return res
+}
+
+func (s *Server) executeListEventsStatement(q *influxql.ListEventsStatement, database string, user *User) *Result {
+	// Restrict events to the time range in the condition.
+	var min, max time.Time
+	if q.Condition != nil {
+		now := s.clock.Now()
+		min, max = influxql.TimeRange(influxql.Fold(q.Condition, &now))
+	}
+
+	events, err := s.Events(database, q.Name, min, max)
+	if err != nil {
+		return &Result{Err: err}
+	}
+	if q.Limit > 0 && len(events) > q.Limit {
+		events = events[:q.Limit]
+	}
+
+	// Group events into one row per name.
+	res := &Result{Rows: make([]*influxql.Row, 0)}
+	rows := make(map[string]*influxql.Row)
+	for _, e := range events {
+		row := rows[e.Name]
+		if row == nil {
+			row = &influxql.Row{Name: e.Name, Columns: []string{"time", "endTime", "text", "tags"}}
+			rows[e.Name] = row
+			res.Rows = append(res.Rows, row)
+		}
+		row.Values = append(row.Values, []interface{}{e.StartTime, e.EndTime, e.Text, e.Tags})
+	}
+	return res
+}
+
+func (s *Server) executeCreateUserStatement(q *influxql.CreateUserStatement, user *User) *Result {
+	isAdmin := false
+	if q.Privilege != nil {
+		isAdmin = *q.Privilege == influxql.AllPrivileges
+	}
+	return &Result{Err: s.CreateUser(q.Name, q.Password, isAdmin)}
+}
+
+func (s *Server) executeDropUserStatement(q *influxql.DropUserStatement, user *User) *Result {
+	return &Result{Err: s.DeleteUser(q.Name)}
+}
+
+func (s *Server) executeGrantStatement(q *influxql.GrantStatement, user *User) *Result {
+	return &Result{Err: s.GrantPrivilege(q.User, q.On, q.Privilege)}
+}
+
+func (s *Server) executeRevokeStatement(q *influxql.RevokeStatement, user *User) *Result {
+	return &Result{Err: s.RevokePrivilege(q.User, q.On, q.Privilege)}
+}
+
+func (s *Server) executeListGrantsStatement(q *influxql.ListGrantsStatement, user *User) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u := s.users[q.Name]
+	if u == nil {
+		return &Result{Err: ErrUserNotFound}
+	}
+
+	// List the privilege on each database, sorted by database name.
+	names := make([]string, 0, len(u.Privileges))
+	for name := range u.Privileges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	row := &influxql.Row{Columns: []string{"database", "privilege"}}
+	for _, name := range names {
+		row.Values = append(row.Values, []interface{}{name, u.Privileges[name].String()})
+	}
+	return &Result{Rows: []*influxql.Row{row}}
+}
+
+func (s *Server) executeCreateContinuousQueryStatement(q *influxql.CreateContinuousQueryStatement, user *User) *Result {
+	return &Result{Err: s.CreateContinuousQuery(q)}
+}
+
+func (s *Server) executeDropContinuousQueryStatement(q *influxql.DropContinuousQueryStatement, user *User) *Result {
+	return &Result{Err: s.DeleteContinuousQuery(q.Name)}
+}
+
+func (s *Server) executeListContinuousQueriesStatement(q *influxql.ListContinuousQueriesStatement, user *User) *Result {
+	// Group queries by database, skipping databases the user cannot read.
+	rows := make(map[string]*influxql.Row)
+	var names []string
+	for _, cq := range s.ContinuousQueries() {
+		if user != nil && !user.Authorize(influxql.ReadPrivilege, cq.Database) {
+			continue
+		}
+		row := rows[cq.Database]
+		if row == nil {
+			row = &influxql.Row{Name: cq.Database, Columns: []string{"name", "query"}}
+			rows[cq.Database] = row
+			names = append(names, cq.Database)
+		}
+		row.Values = append(row.Values, []interface{}{cq.Name, cq.Query})
+	}
+	sort.Strings(names)
+
+	res := &Result{Rows: make([]*influxql.Row, 0, len(names))}
+	for _, name := range names {
+		res.Rows = append(res.Rows, rows[name])
 	}
+	return res
+}
+
+func (s *Server) executeCreateRetentionPolicyStatement(q *influxql.CreateRetentionPolicyStatement, user *User) *Result {
+	rp := NewRetentionPolicy(q.Name)
+	rp.Duration = q.Duration
+	rp.ReplicaN = uint32(q.Replication)
+	rp.SplitN = uint32(q.Split)
+	rp.ShardHash = q.Hash
+	return &Result{Err: s.CreateRetentionPolicy(q.Database, rp)}
+}
 
-	// Fill any empty results after error.
-	for i, res := range results {
-		if res == nil {
-			results[i] = &Result{Err: ErrNotExecuted}
-		}
+func (s *Server) executeAlterRetentionPolicyStatement(q *influxql.AlterRetentionPolicyStatement, user *User) *Result {
+	u := &RetentionPolicyUpdate{Duration: q.Duration, ShardHash: q.Hash}
+	if q.Replication != nil {
+		replicaN := uint32(*q.Replication)
+		u.ReplicaN = &replicaN
 	}
+	if q.Split != nil {
+		splitN := uint32(*q.Split)
+		u.SplitN = &splitN
+	}
+	return &Result{Err: s.AlterRetentionPolicy(q.Database, q.Name, u)}
+}
 
-	return results
+func (s *Server) executeDropRetentionPolicyStatement(q *influxql.DropRetentionPolicyStatement, user *User) *Result {
+	return &Result{Err: s.DeleteRetentionPolicy(q.Database, q.Name)}
 }
 
-// executeSelectStatement plans and executes a select statement against a database.
-func (s *Server) executeSelectStatement(stmt *influxql.SelectStatement, database string, user *User) *Result {
-	// Plan statement execution.
-	e, err := s.planSelectStatement(stmt, database)
+func (s *Server) executeListRetentionPoliciesStatement(q *influxql.ListRetentionPoliciesStatement, user *User) *Result {
+	a, err := s.RetentionPolicies(q.Database)
 	if err != nil {
 		return &Result{Err: err}
 	}
 
-	// Execute plan.
-	ch, err := e.Execute()
+	row := &influxql.Row{Columns: []string{"Name"}}
+	for _, rp := range a {
+		row.Values = append(row.Values, []interface{}{rp.Name})
+	}
+	return &Result{Rows: []*influxql.Row{row}}
+}
+
+func (s *Server) executeListFieldKeysStatement(q *influxql.ListFieldKeysStatement, database string, user *User) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	// Determine which measurements to list fields for.
+	names, err := db.sourceNames(q.Source)
 	if err != nil {
 		return &Result{Err: err}
 	}
 
-	// Read all rows from channel.
-	res := &Result{Rows: make([]*influxql.Row, 0)}
-	for row := range ch {
+	// Return one row per measurement with the name, type & creation time of each field.
+	res := &Result{Rows: make([]*influxql.Row, 0, len(names))}
+	for _, name := range names {
+		m := db.measurements[name]
+		if m == nil {
+			return &Result{Err: ErrMeasurementNotFound}
+		}
+
+		row := &influxql.Row{Name: m.Name, Columns: []string{"name", "type", "createdAt"}}
+		for _, f := range m.Fields {
+			if q.Limit > 0 && len(row.Values) >= q.Limit {
+				break
+			}
+			row.Values = append(row.Values, []interface{}{f.Name, string(f.Type), f.CreatedAt})
+		}
 		res.Rows = append(res.Rows, row)
 	}
-
 	return res
 }
 
-// plans a selection statement under lock.
-func (s *Server) planSelectStatement(stmt *influxql.SelectStatement, database string) (*influxql.Executor, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *Server) executeListFieldValuesStatement(q *influxql.ListFieldValuesStatement, database string, user *User) *Result {
+	// Field values aren't indexed so the shards overlapping the time range
+	// are scanned. Tag conditions are applied against the series index.
+	now := s.clock.Now()
+	min, max := influxql.TimeRange(influxql.Fold(q.Condition, &now))
+	if max.IsZero() {
+		max = time.Unix(0, math.MaxInt64).UTC()
+	}
+	var tmin int64
+	if !min.IsZero() {
+		tmin = min.UnixNano()
+	}
+	tmax := max.UnixNano()
 
-	// Find database.
-	db := s.databases[database]
-	if db == nil {
-		return nil, ErrDatabaseNotFound
+	// Find the shards to scan under the lock and read them without it.
+	scans, client, err := s.planFieldValues(q, database, min, max)
+	if err != nil {
+		return &Result{Err: err}
 	}
 
-	// Plan query.
-	p := influxql.NewPlanner(&dbi{server: s, db: db})
-	return p.Plan(stmt)
+	// Return one row per measurement with the distinct field key/value pairs
+	// ordered by the time each value was most recently written.
+	res := &Result{Rows: make([]*influxql.Row, 0)}
+	for _, sc := range scans {
+		values, err := readFieldValues(client, sc.shards, sc.fields, tmin, tmax)
+		if err != nil {
+			return &Result{Err: err}
+		}
+		if q.Limit > 0 && len(values) > q.Limit {
+			values = values[:q.Limit]
+		}
+
+		if len(values) > 0 {
+			row := &influxql.Row{Name: sc.name, Columns: []string{"fieldKey", "fieldValue", "time"}}
+			for _, v := range values {
+				row.Values = append(row.Values, []interface{}{v.key, v.value, time.Unix(0, v.timestamp).UTC()})
+			}
+			res.Rows = append(res.Rows, row)
+		}
+	}
+	return res
 }
 
-func (s *Server) executeCreateDatabaseStatement(q *influxql.CreateDatabaseStatement, user *User) *Result {
-	return &Result{Err: s.CreateDatabase(q.Name)}
+// fieldValuesScan represents the fields of a measurement to read and the
+// matching series in each shard overlapping a time range.
+type fieldValuesScan struct {
+	name   string
+	fields Fields
+	shards []*shardSeries
 }
 
-func (s *Server) executeDropDatabaseStatement(q *influxql.DropDatabaseStatement, user *User) *Result {
-	return &Result{Err: s.DeleteDatabase(q.Name)}
+// shardSeries represents a set of series in a shard. Shards stored on other
+// data nodes list the URLs of their owners.
+type shardSeries struct {
+	shard *Shard
+	ids   []uint32
+	urls  []*url.URL // nil if stored locally
 }
 
-func (s *Server) executeListDatabasesStatement(q *influxql.ListDatabasesStatement, user *User) *Result {
-	row := &influxql.Row{Columns: []string{"Name"}}
-	for _, name := range s.Databases() {
-		row.Values = append(row.Values, []interface{}{name})
+// planFieldValues returns the fields and shards to scan for each measurement
+// in a LIST FIELD VALUES statement and the client used to read remote shards.
+func (s *Server) planFieldValues(q *influxql.ListFieldValuesStatement, database string, min, max time.Time) ([]*fieldValuesScan, *http.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return nil, nil, ErrDatabaseNotFound
+	}
+	rp := db.policies[db.defaultRetentionPolicy]
+	if rp == nil {
+		return nil, nil, ErrDefaultRetentionPolicyNotFound
 	}
-	return &Result{Rows: []*influxql.Row{row}}
-}
 
-func (s *Server) executeCreateUserStatement(q *influxql.CreateUserStatement, user *User) *Result {
-	isAdmin := false
-	if q.Privilege != nil {
-		isAdmin = *q.Privilege == influxql.AllPrivileges
+	names, err := db.sourceNames(q.Source)
+	if err != nil {
+		return nil, nil, err
 	}
-	return &Result{Err: s.CreateUser(q.Name, q.Password, isAdmin)}
-}
+	cond := tagExpr(q.Condition)
 
-func (s *Server) executeDropUserStatement(q *influxql.DropUserStatement, user *User) *Result {
-	return &Result{Err: s.DeleteUser(q.Name)}
+	var scans []*fieldValuesScan
+	for _, name := range names {
+		m := db.measurements[name]
+		if m == nil {
+			continue
+		}
+
+		// Determine which fields to read.
+		sc := &fieldValuesScan{name: m.Name, fields: m.Fields}
+		if q.FieldKey != "" {
+			f := m.FieldByName(q.FieldKey)
+			if f == nil {
+				return nil, nil, ErrFieldNotFound
+			}
+			sc.fields = Fields{f}
+		}
+
+		// Find the matching series.
+		var ids []uint32
+		for _, id := range m.ids {
+			if ok, err := m.seriesByID[id].matchExpr(cond); err != nil {
+				return nil, nil, err
+			} else if ok {
+				ids = append(ids, id)
+			}
+		}
+
+		// Group the series by the shard they're stored in. Shards stored on
+		// other data nodes are read from their owners, as they are by selects.
+		for _, g := range rp.shardGroups {
+			if g.EndTime.Before(min) || g.StartTime.After(max) {
+				continue
+			}
+
+			shards := make(map[*Shard]*shardSeries)
+			for _, id := range ids {
+				sh := g.ShardBySeriesID(id)
+				ss := shards[sh]
+				if ss == nil {
+					ss = &shardSeries{shard: sh}
+					if sh.store == nil {
+						ss.urls = s.shardURLs(sh)
+					}
+					shards[sh] = ss
+					sc.shards = append(sc.shards, ss)
+				}
+				ss.ids = append(ss.ids, id)
+			}
+		}
+		scans = append(scans, sc)
+	}
+	return scans, s.remoteClient(), nil
 }
 
-func (s *Server) executeCreateRetentionPolicyStatement(q *influxql.CreateRetentionPolicyStatement, user *User) *Result {
-	rp := NewRetentionPolicy(q.Name)
-	rp.Duration = q.Duration
-	rp.ReplicaN = uint32(q.Replication)
-	return &Result{Err: s.CreateRetentionPolicy(q.Database, rp)}
+// shardURLs returns the URLs of the data nodes that own a shard.
+// This function must be called under a read lock.
+func (s *Server) shardURLs(sh *Shard) (a []*url.URL) {
+	for _, id := range sh.DataNodeIDs {
+		if n := s.dataNodes[id]; n != nil {
+			a = append(a, n.URL)
+		}
+	}
+	return
 }
 
-func (s *Server) executeAlterRetentionPolicyStatement(q *influxql.AlterRetentionPolicyStatement, user *User) *Result {
-	rp := NewRetentionPolicy(q.Name)
-	if q.Duration != nil {
-		rp.Duration = *q.Duration
+// readFieldValues returns the distinct values of fields for sets of series
+// in shards between min and max, newest first.
+func readFieldValues(client *http.Client, shards []*shardSeries, fields Fields, min, max int64) (fieldValues, error) {
+	set := make(map[[2]interface{}]int64)
+	for _, ss := range shards {
+		if ss.urls == nil {
+			if err := readShardFieldValues(ss.shard, ss.ids, fields, min, max, set); err == ErrShardNotLocal {
+				continue // dropped since the scan was planned
+			} else if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		points, err := fetchRemoteSeries(client, ss.urls, ss.shard.ID, ss.ids, min, max)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range points {
+			if err := addFieldValues(p.Timestamp, p.Values, fields, set); err != nil {
+				return nil, err
+			}
+		}
 	}
-	if q.Replication != nil {
-		rp.ReplicaN = uint32(*q.Replication)
+
+	values := make(fieldValues, 0, len(set))
+	for k, timestamp := range set {
+		values = append(values, fieldValue{key: k[0].(string), value: k[1], timestamp: timestamp})
 	}
-	return &Result{Err: s.UpdateRetentionPolicy(q.Database, q.Name, rp)}
+	sort.Sort(values)
+	return values, nil
 }
 
-func (s *Server) executeDropRetentionPolicyStatement(q *influxql.DropRetentionPolicyStatement, user *User) *Result {
-	return &Result{Err: s.DeleteRetentionPolicy(q.Database, q.Name)}
+// readShardFieldValues adds the latest timestamp of each distinct field
+// key/value pair in a local shard to set.
+func readShardFieldValues(sh *Shard, ids []uint32, fields Fields, min, max int64, set map[[2]interface{}]int64) error {
+	tx, err := sh.begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, id := range ids {
+		c := tx.Cursor(id)
+		if c == nil {
+			continue
+		}
+
+		for timestamp, v := c.SeekTo(min); v != nil && timestamp <= max; timestamp, v = c.Next() {
+			if err := addFieldValues(timestamp, v, fields, set); err != nil {
+				return err
+			}
+		}
+		if err := cursorErr(c); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (s *Server) executeListRetentionPoliciesStatement(q *influxql.ListRetentionPoliciesStatement, user *User) *Result {
-	a, err := s.RetentionPolicies(q.Database)
+// addFieldValues records the timestamp of each field key/value pair in
+// encoded values in set, if it is the latest seen.
+func addFieldValues(timestamp int64, v []byte, fields Fields, set map[[2]interface{}]int64) error {
+	values, err := unmarshalValues(v)
 	if err != nil {
-		return &Result{Err: err}
+		return err
+	}
+	for _, f := range fields {
+		value, ok := values[f.ID]
+		if !ok {
+			continue
+		}
+		key := [2]interface{}{f.Name, value}
+		if latest, ok := set[key]; !ok || timestamp > latest {
+			set[key] = timestamp
+		}
 	}
+	return nil
+}
 
-	row := &influxql.Row{Columns: []string{"Name"}}
-	for _, rp := range a {
-		row.Values = append(row.Values, []interface{}{rp.Name})
+// fieldValue represents a field value and the last time it was written.
+type fieldValue struct {
+	key       string
+	value     interface{}
+	timestamp int64
+}
+
+// fieldValues represents a list of field values sorted newest first.
+type fieldValues []fieldValue
+
+func (p fieldValues) Len() int { return len(p) }
+func (p fieldValues) Less(i, j int) bool {
+	if p[i].timestamp != p[j].timestamp {
+		return p[i].timestamp > p[j].timestamp
 	}
-	return &Result{Rows: []*influxql.Row{row}}
+	return p[i].key < p[j].key
 }
+func (p fieldValues) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
 
 func (s *Server) MeasurementNames(database string) []string {
 	s.mu.RLock()
@@ -1733,6 +6127,10 @@ func (s *Server) normalizeStatement(stmt influxql.Statement, defaultDatabase str
 		}
 		switch n := n.(type) {
 		case *influxql.Measurement:
+			// Regular expressions are expanded when the statement is planned.
+			if n.Regex != nil {
+				return
+			}
 			name, e := s.normalizeMeasurement(n.Name, defaultDatabase)
 			if e != nil {
 				err = e
@@ -1835,51 +6233,203 @@ func (s *Server) processor(client MessagingClient, done chan struct{}) {
 			continue
 		}
 
+		// Stop applying messages at the first message in a format this data
+		// node doesn't understand. It and later messages wait on the broker
+		// until the data node is upgraded.
+		s.mu.Lock()
+		if s.upgradeIndex == 0 && !canApply(m.Type) {
+			s.haltForUpgrade(m)
+		}
+		halted := s.upgradeIndex != 0
+		s.mu.Unlock()
+		if halted {
+			continue
+		}
+
+		// Skip broadcast messages that are already in the metastore. These are
+		// replayed when the server restarts or starts from a metastore copy.
+		broadcast := m.TopicID == messaging.BroadcastTopicID
+		s.mu.RLock()
+		applied := broadcast && m.Index <= s.metaIndex
+		s.mu.RUnlock()
+
 		// Process message.
 		var err error
-		switch m.Type {
-		case writeSeriesMessageType:
-			err = s.applyWriteSeries(m)
-		case writeRawSeriesMessageType:
-			err = s.applyWriteRawSeries(m)
-		case createDataNodeMessageType:
-			err = s.applyCreateDataNode(m)
-		case deleteDataNodeMessageType:
-			err = s.applyDeleteDataNode(m)
-		case createDatabaseMessageType:
-			err = s.applyCreateDatabase(m)
-		case deleteDatabaseMessageType:
-			err = s.applyDeleteDatabase(m)
-		case createUserMessageType:
-			err = s.applyCreateUser(m)
-		case updateUserMessageType:
-			err = s.applyUpdateUser(m)
-		case deleteUserMessageType:
-			err = s.applyDeleteUser(m)
-		case createRetentionPolicyMessageType:
-			err = s.applyCreateRetentionPolicy(m)
-		case updateRetentionPolicyMessageType:
-			err = s.applyUpdateRetentionPolicy(m)
-		case deleteRetentionPolicyMessageType:
-			err = s.applyDeleteRetentionPolicy(m)
-		case createShardGroupIfNotExistsMessageType:
-			err = s.applyCreateShardGroupIfNotExists(m)
-		case setDefaultRetentionPolicyMessageType:
-			err = s.applySetDefaultRetentionPolicy(m)
-		case createSeriesIfNotExistsMessageType:
-			err = s.applyCreateSeriesIfNotExists(m)
+		if !applied {
+			err = s.apply(m)
 		}
+		s.unsubscribeDroppedShards(client)
 
 		// Sync high water mark and errors.
 		s.mu.Lock()
+		if broadcast && !applied && s.opened() {
+			s.metaIndex = m.Index
+			_ = s.meta.mustUpdate(func(tx *metatx) error { return tx.setIndex(m.Index) })
+		}
 		s.index = m.Index
 		if err != nil {
-			s.errors[m.Index] = err
+			s.addApplyError(&ApplyError{Index: m.Index, Type: m.Type, Err: err})
 		}
+		s.notifyApplied()
 		s.mu.Unlock()
 	}
 }
 
+// unsubscribeDroppedShards removes the subscriptions to the shards dropped by
+// the last applied message. The broker is called outside the lock so that
+// readers aren't blocked on it.
+func (s *Server) unsubscribeDroppedShards(client MessagingClient) {
+	s.mu.Lock()
+	id, topicIDs := s.id, s.droppedShardIDs
+	s.droppedShardIDs = nil
+	s.mu.Unlock()
+
+	for _, topicID := range topicIDs {
+		if err := client.Unsubscribe(id, topicID); err != nil {
+			s.Logger.Errorf("unable to unsubscribe: replica=%d, topic=%d, err=%s", id, topicID, err)
+		}
+	}
+}
+
+// apply applies a message from the broker.
+func (s *Server) apply(m *messaging.Message) (err error) {
+	switch m.Type {
+	case writeSeriesMessageType:
+		err = s.applyWriteSeries(m)
+	case writeRawSeriesMessageType:
+		err = s.applyWriteRawSeries(m)
+	case createDataNodeMessageType:
+		err = s.applyCreateDataNode(m)
+	case deleteDataNodeMessageType:
+		err = s.applyDeleteDataNode(m)
+	case promoteDataNodeMessageType:
+		err = s.applyPromoteDataNode(m)
+	case updateDataNodeMessageType:
+		err = s.applyUpdateDataNode(m)
+	case createDatabaseMessageType:
+		err = s.applyCreateDatabase(m)
+	case deleteDatabaseMessageType:
+		err = s.applyDeleteDatabase(m)
+	case createUserMessageType:
+		err = s.applyCreateUser(m)
+	case updateUserMessageType:
+		err = s.applyUpdateUser(m)
+	case deleteUserMessageType:
+		err = s.applyDeleteUser(m)
+	case grantPrivilegeMessageType:
+		err = s.applyGrantPrivilege(m)
+	case setPrivilegeMessageType:
+		err = s.applySetPrivilege(m)
+	case revokePrivilegeMessageType:
+		err = s.applyRevokePrivilege(m)
+	case createTokenMessageType:
+		err = s.applyCreateToken(m)
+	case revokeTokenMessageType:
+		err = s.applyRevokeToken(m)
+	case createRetentionPolicyMessageType:
+		err = s.applyCreateRetentionPolicy(m)
+	case updateRetentionPolicyMessageType:
+		err = s.applyUpdateRetentionPolicy(m)
+	case deleteRetentionPolicyMessageType:
+		err = s.applyDeleteRetentionPolicy(m)
+	case createShardGroupIfNotExistsMessageType:
+		err = s.applyCreateShardGroupIfNotExists(m)
+	case deleteShardGroupMessageType:
+		err = s.applyDeleteShardGroup(m)
+	case setShardOwnersMessageType:
+		err = s.applySetShardOwners(m)
+	case setDefaultRetentionPolicyMessageType:
+		err = s.applySetDefaultRetentionPolicy(m)
+	case createDownsamplePolicyMessageType:
+		err = s.applyCreateDownsamplePolicy(m)
+	case deleteDownsamplePolicyMessageType:
+		err = s.applyDeleteDownsamplePolicy(m)
+	case createSeriesIfNotExistsMessageType:
+		err = s.applyCreateSeriesIfNotExists(m)
+	case dropSeriesMessageType:
+		err = s.applyDropSeries(m)
+	case deleteRangeMessageType:
+		err = s.applyDeleteRange(m)
+	case acquireContinuousQueryLeaseMessageType:
+		err = s.applyAcquireContinuousQueryLease(m)
+	case createContinuousQueryMessageType:
+		err = s.applyCreateContinuousQuery(m)
+	case deleteContinuousQueryMessageType:
+		err = s.applyDeleteContinuousQuery(m)
+	case createEventMessageType:
+		err = s.applyCreateEvent(m)
+	case blockWritesMessageType:
+		err = s.applyBlockWrites(m)
+	case unblockWritesMessageType:
+		err = s.applyUnblockWrites(m)
+	case metadataBatchMessageType:
+		err = s.applyMetadataBatch(m)
+	}
+	return
+}
+
+// MaxApplyErrors is the number of message errors retained by the server.
+// Errors not consumed by Sync are evicted oldest first beyond this limit.
+var MaxApplyErrors = 1000
+
+// ApplyError represents an error that occurred while applying a broker message.
+type ApplyError struct {
+	Index uint64
+	Type  messaging.MessageType
+	Err   error
+}
+
+// MarshalJSON encodes the apply error into JSON.
+func (e *ApplyError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Index uint64 `json:"index"`
+		Type  uint16 `json:"type"`
+		Err   string `json:"error"`
+	}{e.Index, uint16(e.Type), e.Err.Error()})
+}
+
+// addApplyError records an error and evicts the oldest errors over the limit.
+// This function must be called under a write lock.
+func (s *Server) addApplyError(e *ApplyError) {
+	atomic.AddInt64(&s.counters.applyErrors, 1)
+	s.errors[e.Index] = e
+	for len(s.errors) > MaxApplyErrors {
+		var min uint64
+		for index := range s.errors {
+			if min == 0 || index < min {
+				min = index
+			}
+		}
+		delete(s.errors, min)
+	}
+}
+
+// ApplyErrors returns the retained message errors sorted by index.
+func (s *Server) ApplyErrors() []*ApplyError {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a := make(applyErrors, 0, len(s.errors))
+	for _, e := range s.errors {
+		a = append(a, e)
+	}
+	sort.Sort(a)
+	return a
+}
+
+// ClearApplyErrors removes all retained message errors.
+func (s *Server) ClearApplyErrors() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = make(map[uint64]*ApplyError)
+}
+
+type applyErrors []*ApplyError
+
+func (p applyErrors) Len() int           { return len(p) }
+func (p applyErrors) Less(i, j int) bool { return p[i].Index < p[j].Index }
+func (p applyErrors) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
 // Result represents a resultset returned from a single statement.
 type Result struct {
 	Rows []*influxql.Row
@@ -1903,6 +6453,38 @@ func (r *Result) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&o)
 }
 
+// ResultRow represents a single row streamed from the execution of a statement.
+// Either Row or Err is set.
+type ResultRow struct {
+	StatementID int
+	Row         *influxql.Row
+	Err         error
+}
+
+// MarshalJSON encodes the row into a single JSON object that carries its
+// statement id alongside the row's fields or the error.
+func (r *ResultRow) MarshalJSON() ([]byte, error) {
+	var o struct {
+		StatementID int               `json:"statementId"`
+		Name        string            `json:"name,omitempty"`
+		Tags        map[string]string `json:"tags,omitempty"`
+		Columns     []string          `json:"columns,omitempty"`
+		Values      [][]interface{}   `json:"values,omitempty"`
+		Err         string            `json:"error,omitempty"`
+	}
+
+	// Copy fields to output struct.
+	o.StatementID = r.StatementID
+	if r.Row != nil {
+		o.Name, o.Tags, o.Columns, o.Values = r.Row.Name, r.Row.Tags, r.Row.Columns, r.Row.Values
+	}
+	if r.Err != nil {
+		o.Err = r.Err.Error()
+	}
+
+	return json.Marshal(&o)
+}
+
 // Results represents a list of statement results.
 type Results []*Result
 
@@ -1917,7 +6499,11 @@ func (a Results) Error() error {
 	return nil
 }
 
-// MessagingClient represents the client used to receive messages from brokers.
+// MessagingClient represents the transport that orders and distributes
+// commands and data to the data nodes. It is satisfied by a broker client,
+// by messaging.LocalClient for single-node deployments and may be implemented
+// on top of other ordered logs. Every message published must be delivered
+// in index order to each data node subscribed to its topic.
 type MessagingClient interface {
 	// Publishes a message to the broker.
 	Publish(m *messaging.Message) (index uint64, err error)
@@ -1934,6 +6520,9 @@ type MessagingClient interface {
 	// Removes a subscription from the replica for a topic.
 	Unsubscribe(replicaID, topicID uint64) error
 
+	// Removes the messages at or before an index from a topic.
+	TruncateTopic(topicID, index uint64) error
+
 	// The streaming channel for all subscribed messages.
 	C() <-chan *messaging.Message
 }
@@ -1942,11 +6531,86 @@ type MessagingClient interface {
 type DataNode struct {
 	ID  uint64
 	URL *url.URL
+
+	// A standby stores and applies writes for every shard but owns none of
+	// them and serves no queries until it is promoted.
+	Standby bool
+
+	// Version of the data node and the highest message format it can apply.
+	// The format is zero for data nodes that joined before formats were
+	// negotiated.
+	Version       string `json:",omitempty"`
+	MessageFormat int    `json:",omitempty"`
 }
 
 // newDataNode returns an instance of DataNode.
 func newDataNode() *DataNode { return &DataNode{} }
 
+// messageFormat returns the highest message format the data node can apply.
+func (n *DataNode) messageFormat() int {
+	if n.MessageFormat == 0 {
+		return 1
+	}
+	return n.MessageFormat
+}
+
+// DataNodeShards represents the shards owned by a single data node.
+type DataNodeShards struct {
+	DataNodeID uint64
+	URL        *url.URL
+	Shards     []*ShardInfo
+}
+
+type dataNodeShards []*DataNodeShards
+
+func (p dataNodeShards) Len() int           { return len(p) }
+func (p dataNodeShards) Less(i, j int) bool { return p[i].DataNodeID < p[j].DataNodeID }
+func (p dataNodeShards) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// ShardInfo represents the location, time range and size of a shard.
+type ShardInfo struct {
+	ID              uint64
+	Database        string
+	RetentionPolicy string
+	StartTime       time.Time
+	EndTime         time.Time
+
+	// Size on disk in bytes, or nil if the shard isn't stored on this server.
+	Size *int64
+}
+
+type shardInfos []*ShardInfo
+
+func (p shardInfos) Len() int           { return len(p) }
+func (p shardInfos) Less(i, j int) bool { return p[i].ID < p[j].ID }
+func (p shardInfos) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// ShardGroupInfo represents the location, time range and size of a shard group.
+type ShardGroupInfo struct {
+	ID              uint64
+	Database        string
+	RetentionPolicy string
+	StartTime       time.Time
+	EndTime         time.Time
+	ShardN          int
+
+	// Size on disk in bytes of the group's shards stored on this server.
+	Size int64
+}
+
+type shardGroupInfos []*ShardGroupInfo
+
+func (p shardGroupInfos) Len() int { return len(p) }
+func (p shardGroupInfos) Less(i, j int) bool {
+	if p[i].Database != p[j].Database {
+		return p[i].Database < p[j].Database
+	} else if p[i].RetentionPolicy != p[j].RetentionPolicy {
+		return p[i].RetentionPolicy < p[j].RetentionPolicy
+	}
+	return p[i].StartTime.Before(p[j].StartTime)
+}
+func (p shardGroupInfos) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
 type dataNodes []*DataNode
 
 func (p dataNodes) Len() int           { return len(p) }
@@ -1960,9 +6624,20 @@ var BcryptCost = 10
 // User represents a user account on the system.
 // It can be given read/write permissions to individual databases.
 type User struct {
-	Name  string `json:"name"`
-	Hash  string `json:"hash"`
-	Admin bool   `json:"admin,omitempty"`
+	Name       string                        `json:"name"`
+	Hash       string                        `json:"hash"`
+	Admin      bool                          `json:"admin,omitempty"`
+	Privileges map[string]influxql.Privilege `json:"privileges,omitempty"` // by database
+}
+
+// Authorize returns true if the user has a privilege on a database.
+// Admins are authorized for all privileges on all databases.
+func (u *User) Authorize(p influxql.Privilege, database string) bool {
+	if u.Admin {
+		return true
+	}
+	up, ok := u.Privileges[database]
+	return ok && (up == influxql.AllPrivileges || up == p)
 }
 
 // Authenticate returns nil if the password matches the user's password.
@@ -1971,6 +6646,25 @@ func (u *User) Authenticate(password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(u.Hash), []byte(password))
 }
 
+// PasswordPolicy represents the rules for user passwords.
+// Blank passwords are always rejected.
+type PasswordPolicy struct {
+	MinLength      int  // minimum length in characters
+	RejectUsername bool // reject passwords that match the username
+}
+
+// Validate returns an error if a user's password doesn't follow the policy.
+func (p *PasswordPolicy) Validate(username, password string) error {
+	if password == "" {
+		return ErrPasswordRequired
+	} else if utf8.RuneCountInString(password) < p.MinLength {
+		return ErrPasswordTooShort
+	} else if p.RejectUsername && strings.EqualFold(password, username) {
+		return ErrPasswordMatchesUsername
+	}
+	return nil
+}
+
 // users represents a list of users, sortable by name.
 type users []*User
 
@@ -1981,10 +6675,13 @@ func (p users) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 type Matcher struct {
 	IsRegex bool
 	Name    string
+	Regex   *regexp.Regexp // compiled pattern, used instead of Name when set
 }
 
 func (m *Matcher) Matches(name string) bool {
-	if m.IsRegex {
+	if m.Regex != nil {
+		return m.Regex.MatchString(name)
+	} else if m.IsRegex {
 		matches, _ := regexp.MatchString(m.Name, name)
 		return matches
 	}
@@ -1999,14 +6696,37 @@ func HashPassword(password string) ([]byte, error) {
 	return bcrypt.GenerateFromPassword([]byte(password), BcryptCost)
 }
 
-// ContinuousQuery represents a query that exists on the server and processes
-// each incoming event.
+// ContinuousQuery represents a query that exists on the server and is run
+// each time one of its GROUP BY time intervals completes.
 type ContinuousQuery struct {
-	ID    uint32
-	Query string
-	// TODO: ParsedQuery *parser.SelectQuery
+	Name     string `json:"name"`
+	Database string `json:"database"`
+	Query    string `json:"query"`
+
+	downsample bool      // compiled from a downsample policy
+	lastRun    time.Time // end of the last interval run by this server
 }
 
+// statement parses the query into a new statement. A new statement is
+// returned on every call since planning modifies the statement.
+func (cq *ContinuousQuery) statement() (*influxql.CreateContinuousQueryStatement, error) {
+	stmt, err := influxql.NewParser(strings.NewReader(cq.Query)).ParseStatement()
+	if err != nil {
+		return nil, err
+	}
+	other, ok := stmt.(*influxql.CreateContinuousQueryStatement)
+	if !ok {
+		return nil, fmt.Errorf("not a continuous query: %s", cq.Query)
+	}
+	return other, nil
+}
+
+type continuousQueries []*ContinuousQuery
+
+func (p continuousQueries) Len() int           { return len(p) }
+func (p continuousQueries) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p continuousQueries) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
 // copyURL returns a copy of the the URL.
 func copyURL(u *url.URL) *url.URL {
 	other := &url.URL{}
@@ -1,13 +1,26 @@
 package influxdb_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -29,12 +42,250 @@ func TestServer_Open(t *testing.T) {
 	}
 }
 
+// Ensure a single-node server can run on the local messaging transport.
+func TestServer_LocalClient(t *testing.T) {
+	c := messaging.NewLocalClient()
+	s := OpenServer(c)
+	defer s.Close()
+	defer c.Close()
+
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "b"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,30]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+}
+
 // Ensure an error is returned when opening an already open server.
 func TestServer_Open_ErrServerOpen(t *testing.T) { t.Skip("pending") }
 
 // Ensure an error is returned when opening a server without a path.
 func TestServer_Open_ErrPathRequired(t *testing.T) { t.Skip("pending") }
 
+// Ensure the server encrypts the metastore at rest and supports key rotation.
+func TestServer_Open_EncryptedMetastore(t *testing.T) {
+	s := NewServer()
+	path, client := tempfile(), NewMessagingClient()
+	defer os.RemoveAll(path)
+
+	// Closes and reopens the server with a new set of keys.
+	reopen := func(key []byte, previousKeys ...[]byte) error {
+		if s.Path() != "" {
+			if err := s.Server.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.SetMetastoreKeys(key, previousKeys...); err != nil {
+			t.Fatal(err)
+		} else if err := s.Server.Open(path); err != nil {
+			return err
+		} else if err := s.SetClient(client); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}
+	if err := reopen([]byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	} else if err := s.CreateUser("susy", "pass", true); err != nil {
+		t.Fatal(err)
+	}
+	hash := s.User("susy").Hash
+
+	// Verify that the password hash is not stored in plaintext.
+	if err := s.Server.Close(); err != nil {
+		t.Fatal(err)
+	} else if b, err := ioutil.ReadFile(path + "/meta"); err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(string(b), hash) {
+		t.Fatal("password hash stored in plaintext")
+	}
+
+	// Reopen with the same key and ensure values are decrypted.
+	if err := reopen([]byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	} else if u := s.User("susy"); u == nil || u.Hash != hash {
+		t.Fatalf("unexpected user: %#v", u)
+	}
+
+	// Rotate the key and ensure values are still readable.
+	if err := reopen([]byte("fedcba9876543210"), []byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	} else if u := s.User("susy"); u == nil || u.Hash != hash {
+		t.Fatalf("unexpected user after rotation: %#v", u)
+	}
+
+	// Opening with only the retired key should fail.
+	if err := reopen([]byte("0123456789abcdef")); err == nil || !strings.Contains(err.Error(), influxdb.ErrMetastoreDecrypt.Error()) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Removing the key decrypts values using the previous key.
+	if err := reopen(nil, []byte("fedcba9876543210")); err != nil {
+		t.Fatal(err)
+	} else if err := reopen(nil); err != nil {
+		t.Fatal(err)
+	} else if u := s.User("susy"); u == nil || u.Hash != hash {
+		t.Fatalf("unexpected user after decryption: %#v", u)
+	}
+	s.Server.Close()
+}
+
+// Ensure an error is returned when setting an invalid metastore key.
+func TestServer_SetMetastoreKeys_ErrInvalidMetastoreKey(t *testing.T) {
+	s := NewServer()
+	if err := s.SetMetastoreKeys([]byte("short")); err != influxdb.ErrInvalidMetastoreKey {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server verifies HTTPS peers by certificate authority and pin when joining.
+func TestServer_Join_PeerTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/data_nodes" {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":2}`))
+		}
+	}))
+	defer ts.Close()
+	joinURL, _ := url.Parse(ts.URL)
+
+	// Determine the pin for the peer's certificate.
+	sum := sha256.Sum256(ts.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+
+	// Trust the peer's certificate authority.
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+
+	for i, tt := range []struct {
+		config *influxdb.PeerTLSConfig
+		err    string
+	}{
+		{config: nil, err: "certificate"},
+		{config: &influxdb.PeerTLSConfig{RootCAs: pool}},
+		{config: &influxdb.PeerTLSConfig{RootCAs: pool, Pins: []string{pin}}},
+		{config: &influxdb.PeerTLSConfig{InsecureSkipVerify: true, Pins: []string{pin}}},
+		{config: &influxdb.PeerTLSConfig{InsecureSkipVerify: true}, err: "certificate"},
+		{config: &influxdb.PeerTLSConfig{RootCAs: pool, Pins: []string{"UNhY4JhezH9gQYqvDMWrWH9CwlcKiECVqejMrND2VFw="}}, err: influxdb.ErrPeerCertificateNotPinned.Error()},
+	} {
+		s := OpenUninitializedServer(NewMessagingClient())
+		s.SetPeerTLSConfig(tt.config)
+		err := s.Join(&url.URL{Host: "127.0.0.1:8080"}, joinURL)
+		if tt.err == "" && err != nil {
+			t.Errorf("%d. unexpected error: %s", i, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%d. expected error %q, got: %v", i, tt.err, err)
+		} else if tt.err == "" && s.ID() != 2 {
+			t.Errorf("%d. unexpected id: %d", i, s.ID())
+		}
+		s.Close()
+	}
+}
+
+// Ensure a joining server starts from a copy of the joined server's metastore.
+func TestServer_Join_CopyMetastore(t *testing.T) {
+	s1 := OpenServer(NewMessagingClient())
+	defer s1.Close()
+	s1.CreateDatabase("foo")
+	hs := httptest.NewServer(influxdb.NewHandler(s1.Server))
+	defer hs.Close()
+	u1, _ := url.Parse(hs.URL)
+
+	s2 := OpenUninitializedServer(NewMessagingClient())
+	defer s2.Close()
+	if err := s2.Join(&url.URL{Host: "127.0.0.1:8087"}, u1); err != nil {
+		t.Fatal(err)
+	} else if s2.ID() != 2 {
+		t.Fatalf("unexpected id: %d", s2.ID())
+	} else if !s2.DatabaseExists("foo") {
+		t.Fatal("database not copied")
+	} else if n := len(s2.DataNodes()); n != 2 {
+		t.Fatalf("unexpected data node count: %d", n)
+	} else if s2.MetastoreIndex() != s1.MetastoreIndex() {
+		t.Fatalf("unexpected index: %d", s2.MetastoreIndex())
+	}
+}
+
+// Ensure a restarted server skips broadcast messages already in its metastore.
+func TestServer_Restart_SkipAppliedBroadcast(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+
+	// Record the message that creates the database and then drop it.
+	var m *messaging.Message
+	c.PublishFunc = func(msg *messaging.Message) (uint64, error) {
+		if m == nil {
+			m = msg
+		}
+		return c.send(msg)
+	}
+	s.CreateDatabase("foo")
+	s.DeleteDatabase("foo")
+
+	// Replaying the create after a restart doesn't recreate the database.
+	// New messages are still applied.
+	s.Restart()
+	if _, err := c.send(m); err != nil {
+		t.Fatal(err)
+	} else if err := s.CreateDatabase("bar"); err != nil {
+		t.Fatal(err)
+	} else if s.DatabaseExists("foo") {
+		t.Fatal("database recreated")
+	} else if !s.DatabaseExists("bar") {
+		t.Fatal("database not created")
+	}
+}
+
+// Ensure the server only compacts broadcast messages applied by every data node.
+func TestServer_CompactBroadcastTopic(t *testing.T) {
+	c1 := NewMessagingClient()
+	s1 := OpenServer(c1)
+	defer s1.Close()
+	hs1 := httptest.NewServer(influxdb.NewHandler(s1.Server))
+	defer hs1.Close()
+	u1, _ := url.Parse(hs1.URL)
+
+	s2 := OpenUninitializedServer(NewMessagingClient())
+	defer s2.Close()
+	hs2 := httptest.NewServer(influxdb.NewHandler(s2.Server))
+	defer hs2.Close()
+	u2, _ := url.Parse(hs2.URL)
+	if err := s2.Join(u2, u1); err != nil {
+		t.Fatal(err)
+	}
+	index := s2.MetastoreIndex()
+
+	// Apply a message on the first server only.
+	s1.CreateDatabase("foo")
+	if s1.MetastoreIndex() <= index {
+		t.Fatalf("unexpected index: %d", s1.MetastoreIndex())
+	}
+
+	var truncated bool
+	c1.TruncateTopicFunc = func(topicID, i uint64) error {
+		if topicID != messaging.BroadcastTopicID || i != index {
+			t.Fatalf("unexpected truncation: topic=%d, index=%d", topicID, i)
+		}
+		truncated = true
+		return nil
+	}
+	if i, err := s1.CompactBroadcastTopic(); err != nil {
+		t.Fatal(err)
+	} else if i != index || !truncated {
+		t.Fatalf("unexpected index: %d", i)
+	}
+}
+
 // Ensure the server can create a new data node.
 func TestServer_CreateDataNode(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -112,6 +363,33 @@ func TestServer_CreateDatabase(t *testing.T) {
 	}
 }
 
+// Ensure the server can create a database with a default retention policy.
+func TestServer_CreateDatabaseWithRetentionPolicy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create the database and its policy in a single statement.
+	results := s.ExecuteQuery(MustParseQuery(`CREATE DATABASE foo WITH RETENTION POLICY one_week DURATION 7d REPLICATION 1`), "", nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	// Verify the policy is the database's default.
+	if rp, err := s.DefaultRetentionPolicy("foo"); err != nil {
+		t.Fatal(err)
+	} else if rp == nil || rp.Name != "one_week" || rp.Duration != 7*24*time.Hour || rp.ReplicaN != 1 {
+		t.Fatalf("unexpected policy: %s", mustMarshalJSON(rp))
+	}
+
+	// Nothing is created if the database exists.
+	if err := s.CreateDatabaseWithRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar")); err != influxdb.ErrDatabaseExists {
+		t.Fatalf("unexpected error: %v", err)
+	} else if rp, _ := s.RetentionPolicy("foo", "bar"); rp != nil {
+		t.Fatal("unexpected policy created")
+	}
+}
+
 // Ensure the server returns an error when creating a duplicate database.
 func TestServer_CreateDatabase_ErrDatabaseExists(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -264,6 +542,69 @@ func TestServer_CreateUser_ErrUserExists(t *testing.T) {
 	}
 }
 
+// Ensure the server enforces the password policy on new and changed passwords.
+func TestServer_CreateUser_PasswordPolicy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.SetPasswordPolicy(influxdb.PasswordPolicy{MinLength: 6, RejectUsername: true})
+
+	for i, tt := range []struct {
+		username, password string
+		err                error
+	}{
+		{username: "susy", password: "", err: influxdb.ErrPasswordRequired},
+		{username: "susy", password: "pass", err: influxdb.ErrPasswordTooShort},
+		{username: "susan1", password: "Susan1", err: influxdb.ErrPasswordMatchesUsername},
+		{username: "susy", password: "password"},
+	} {
+		if err := s.CreateUser(tt.username, tt.password, false); err != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+
+	// Changed passwords follow the policy too.
+	if err := s.UpdateUser("susy", "short"); err != influxdb.ErrPasswordTooShort {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.UpdateUser("susy", "longer password"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server hashes passwords with its bcrypt cost.
+func TestServer_SetBcryptCost(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.SetBcryptCost(bcrypt.MaxCost + 1); err != influxdb.ErrInvalidBcryptCost {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.SetBcryptCost(5); err != nil {
+		t.Fatal(err)
+	} else if err := s.CreateUser("susy", "pass", false); err != nil {
+		t.Fatal(err)
+	} else if cost, err := bcrypt.Cost([]byte(s.User("susy").Hash)); err != nil || cost != 5 {
+		t.Fatalf("unexpected cost: %d, %v", cost, err)
+	}
+}
+
+// Ensure the server creates the bootstrap admin when the cluster is initialized.
+func TestServer_SetBootstrapAdmin(t *testing.T) {
+	s := OpenUninitializedServer(NewMessagingClient())
+	defer s.Close()
+	s.SetBootstrapAdmin("root", "pass")
+	if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	} else if u := s.User("root"); u == nil || !u.Admin {
+		t.Fatalf("unexpected admin: %#v", u)
+	} else if _, err := s.Authenticate("root", "pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The admin isn't created again after a restart.
+	s.Restart()
+	if n := len(s.Users()); n != 1 {
+		t.Fatalf("unexpected user count: %d", n)
+	}
+}
+
 // Ensure the server can delete an existing user.
 func TestServer_DeleteUser(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -289,6 +630,289 @@ func TestServer_DeleteUser(t *testing.T) {
 	}
 }
 
+// Ensure the server can create, authenticate, list and revoke API tokens.
+func TestServer_Tokens(t *testing.T) {
+	clock := influxdb.NewMockClock(mustParseTime("2000-01-01T00:00:00Z"))
+	s := NewServer()
+	if err := s.SetClock(clock); err != nil {
+		t.Fatal(err)
+	} else if err := s.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	s.CreateUser("susy", "pass", false)
+	s.GrantPrivilege("susy", "foo", influxql.AllPrivileges)
+	s.GrantPrivilege("susy", "bar", influxql.WritePrivilege)
+
+	// Tokens require a valid scope, ttl and user.
+	if _, _, err := s.CreateToken("susy", influxql.Privilege(10), 0); err != influxdb.ErrInvalidTokenScope {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, _, err := s.CreateToken("susy", influxql.ReadPrivilege, -1); err != influxdb.ErrInvalidTokenTTL {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, _, err := s.CreateToken("bob", influxql.ReadPrivilege, 0); err != influxdb.ErrUserNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A read token only grants read access to the user's databases.
+	tok, secret, err := s.CreateToken("susy", influxql.ReadPrivilege, 1*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	} else if tok.ID != 1 || tok.User != "susy" || !tok.Expires.Equal(mustParseTime("2000-01-01T01:00:00Z")) {
+		t.Fatalf("unexpected token: %#v", tok)
+	}
+	if u, err := s.AuthenticateToken(secret); err != nil {
+		t.Fatal(err)
+	} else if !u.Authorize(influxql.ReadPrivilege, "foo") || u.Authorize(influxql.WritePrivilege, "foo") || u.Authorize(influxql.ReadPrivilege, "bar") {
+		t.Fatalf("unexpected privileges: %#v", u.Privileges)
+	} else if _, err := s.AuthenticateToken("x" + secret); err != influxdb.ErrInvalidToken {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Tokens are listed per user and survive a restart but not their expiry.
+	other, _, err := s.CreateToken("susy", influxql.AllPrivileges, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+	if a := s.Tokens("susy"); len(a) != 2 || a[0].ID != tok.ID || a[1].ID != other.ID {
+		t.Fatalf("unexpected tokens: %s", mustMarshalJSON(a))
+	}
+	clock.Add(1 * time.Hour)
+	if _, err := s.AuthenticateToken(secret); err != influxdb.ErrTokenExpired {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Revoked tokens are removed.
+	if err := s.RevokeToken(tok.ID); err != nil {
+		t.Fatal(err)
+	} else if err := s.RevokeToken(tok.ID); err != influxdb.ErrTokenNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if a := s.Tokens("susy"); len(a) != 1 || a[0].ID != other.ID {
+		t.Fatalf("unexpected tokens: %s", mustMarshalJSON(a))
+	}
+
+	// Deleting a user removes its tokens.
+	if err := s.DeleteUser("susy"); err != nil {
+		t.Fatal(err)
+	} else if a := s.Tokens("susy"); len(a) != 0 {
+		t.Fatalf("unexpected tokens: %s", mustMarshalJSON(a))
+	}
+}
+
+// Ensure the server can grant and revoke database privileges.
+func TestServer_GrantRevokePrivileges(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateUser("susy", "pass", false)
+
+	// Grant read and then write, which should merge into all privileges.
+	if err := s.ExecuteQuery(MustParseQuery(`GRANT READ ON foo TO susy`), "foo", nil).Error(); err != nil {
+		t.Fatal(err)
+	} else if u := s.User("susy"); !u.Authorize(influxql.ReadPrivilege, "foo") || u.Authorize(influxql.WritePrivilege, "foo") {
+		t.Fatalf("unexpected privileges: %#v", u.Privileges)
+	}
+	if err := s.GrantPrivilege("susy", "foo", influxql.WritePrivilege); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+	if p := s.User("susy").Privileges["foo"]; p != influxql.AllPrivileges {
+		t.Fatalf("unexpected privilege after restart: %s", p)
+	}
+
+	// Revoke read and ensure only write remains.
+	if err := s.ExecuteQuery(MustParseQuery(`REVOKE READ ON foo FROM susy`), "foo", nil).Error(); err != nil {
+		t.Fatal(err)
+	} else if p := s.User("susy").Privileges["foo"]; p != influxql.WritePrivilege {
+		t.Fatalf("unexpected privilege: %s", p)
+	}
+
+	// Grant and revoke admin.
+	if err := s.GrantPrivilege("susy", "", influxql.AllPrivileges); err != nil {
+		t.Fatal(err)
+	} else if !s.User("susy").Admin {
+		t.Fatal("expected admin")
+	}
+	if err := s.RevokePrivilege("susy", "", influxql.AllPrivileges); err != nil {
+		t.Fatal(err)
+	} else if s.User("susy").Admin {
+		t.Fatal("expected non-admin")
+	}
+
+	// Ensure invalid grants are rejected.
+	if err := s.GrantPrivilege("no_such_user", "foo", influxql.ReadPrivilege); err != influxdb.ErrUserNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := s.GrantPrivilege("susy", "no_such_db", influxql.ReadPrivilege); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := s.GrantPrivilege("susy", "", influxql.ReadPrivilege); err != influxdb.ErrDatabaseRequired {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Dropping the database removes its privileges.
+	if err := s.DeleteDatabase("foo"); err != nil {
+		t.Fatal(err)
+	} else if _, ok := s.User("susy").Privileges["foo"]; ok {
+		t.Fatal("expected privilege to be removed")
+	}
+}
+
+// Ensure the server can set the privilege of a user and list the grants.
+func TestServer_SetPrivilege(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	s.CreateUser("susy", "pass", false)
+	s.CreateUser("bob", "pass", false)
+
+	// Setting a privilege replaces the existing privilege.
+	if err := s.SetPrivilege("susy", "foo", influxql.AllPrivileges); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetPrivilege("susy", "foo", influxql.ReadPrivilege); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetPrivilege("susy", "bar", influxql.WritePrivilege); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+	if u := s.User("susy"); !reflect.DeepEqual(u.Privileges, map[string]influxql.Privilege{"foo": influxql.ReadPrivilege, "bar": influxql.WritePrivilege}) {
+		t.Fatalf("unexpected privileges: %#v", u.Privileges)
+	}
+
+	// Grants are listed by database. Users can only list their own grants.
+	results := s.ExecuteQuery(MustParseQuery(`SHOW GRANTS FOR susy`), "", s.User("susy"))
+	if res := results[0]; res.Err != nil {
+		t.Fatal(res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"columns":["database","privilege"],"values":[["bar","WRITE"],["foo","READ"]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+	if res := s.ExecuteQuery(MustParseQuery(`SHOW GRANTS FOR susy`), "", s.User("bob"))[0]; res.Err != influxdb.ErrAdminRequired {
+		t.Fatalf("unexpected error: %v", res.Err)
+	} else if res := s.ExecuteQuery(MustParseQuery(`SHOW GRANTS FOR no_such_user`), "", nil)[0]; res.Err != influxdb.ErrUserNotFound {
+		t.Fatalf("unexpected error: %v", res.Err)
+	}
+
+	// No privileges removes access to the database.
+	if err := s.SetPrivilege("susy", "foo", influxql.NoPrivileges); err != nil {
+		t.Fatal(err)
+	} else if _, ok := s.User("susy").Privileges["foo"]; ok {
+		t.Fatal("expected privilege to be removed")
+	}
+
+	// Set and remove admin.
+	if err := s.SetPrivilege("susy", "", influxql.AllPrivileges); err != nil {
+		t.Fatal(err)
+	} else if !s.User("susy").Admin {
+		t.Fatal("expected admin")
+	} else if err := s.SetPrivilege("susy", "", influxql.NoPrivileges); err != nil {
+		t.Fatal(err)
+	} else if s.User("susy").Admin {
+		t.Fatal("expected non-admin")
+	}
+
+	// Ensure invalid privileges are rejected.
+	if err := s.SetPrivilege("no_such_user", "foo", influxql.ReadPrivilege); err != influxdb.ErrUserNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := s.SetPrivilege("susy", "no_such_db", influxql.ReadPrivilege); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := s.SetPrivilege("susy", "", influxql.ReadPrivilege); err != influxdb.ErrDatabaseRequired {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := s.SetPrivilege("susy", "foo", influxql.Privilege(10)); err != influxdb.ErrInvalidPrivilege {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server enforces user privileges when executing queries.
+func TestServer_ExecuteQuery_Authorize(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	s.CreateUser("susy", "pass", false)
+	s.GrantPrivilege("susy", "foo", influxql.ReadPrivilege)
+	u := s.User("susy")
+
+	for i, tt := range []struct {
+		q        string
+		database string
+		err      error
+	}{
+		{q: `LIST TAG KEYS`, database: "foo"},
+		{q: `LIST MEASUREMENTS`, database: "foo"},
+		{q: `LIST DATABASES`, database: "foo"},
+		{q: `SELECT sum(value) FROM cpu`, database: "bar", err: influxdb.ErrReadAccessDenied},
+		{q: `LIST RETENTION POLICIES bar`, database: "foo", err: influxdb.ErrReadAccessDenied},
+		{q: `DROP SERIES cpu`, database: "foo", err: influxdb.ErrWriteAccessDenied},
+		{q: `CREATE DATABASE baz`, database: "foo", err: influxdb.ErrAdminRequired},
+		{q: `GRANT ALL PRIVILEGES TO susy`, database: "foo", err: influxdb.ErrAdminRequired},
+		{q: `LIST QUERIES`, database: "foo", err: influxdb.ErrAdminRequired},
+		{q: `KILL QUERY 1`, database: "foo", err: influxdb.ErrAdminRequired},
+		{q: `REINDEX DATABASE foo`, database: "foo", err: influxdb.ErrAdminRequired},
+		{q: `BLOCK MEASUREMENT = cpu`, database: "foo", err: influxdb.ErrAdminRequired},
+		{q: `SHOW STATS FOR 'foo'`, database: "foo"},
+		{q: `SHOW STATS FOR 'bar'`, database: "foo", err: influxdb.ErrReadAccessDenied},
+		{q: `SHOW STATS`, database: "foo", err: influxdb.ErrAdminRequired},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), tt.database, u)
+		if err := results[0].Err; err != tt.err {
+			t.Errorf("%d. %s: unexpected error: %v", i, tt.q, err)
+		}
+	}
+}
+
+// Ensure a batch of metadata statements is applied together or not at all.
+func TestServer_ExecuteBatch(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	results := s.ExecuteBatch(MustParseQuery(`CREATE DATABASE foo; CREATE RETENTION POLICY raw ON foo DURATION 1h REPLICATION 1 DEFAULT; CREATE USER susy WITH PASSWORD 'pass'; GRANT READ ON foo TO susy`), nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	} else if rp, err := s.DefaultRetentionPolicy("foo"); err != nil || rp == nil || rp.Name != "raw" {
+		t.Fatalf("unexpected default policy: %v, %v", rp, err)
+	} else if u := s.User("susy"); u == nil || u.Privileges["foo"] != influxql.ReadPrivilege {
+		t.Fatalf("unexpected user: %#v", u)
+	}
+
+	// The batch is persisted with the message's index.
+	index := s.MetastoreIndex()
+	s.Restart()
+	if rp, err := s.DefaultRetentionPolicy("foo"); err != nil || rp == nil || rp.Name != "raw" {
+		t.Fatalf("unexpected default policy after restart: %v, %v", rp, err)
+	} else if u := s.User("susy"); u == nil || u.Privileges["foo"] != influxql.ReadPrivilege {
+		t.Fatalf("unexpected user after restart: %#v", u)
+	} else if other := s.MetastoreIndex(); other != index {
+		t.Fatalf("unexpected index after restart: %d, %d", index, other)
+	}
+
+	// A failing statement rejects the whole batch.
+	results = s.ExecuteBatch(MustParseQuery(`CREATE DATABASE bar; CREATE USER bob WITH PASSWORD 'pass'; CREATE DATABASE foo`), nil)
+	if results[0].Err != influxdb.ErrNotExecuted || results[1].Err != influxdb.ErrNotExecuted || results[2].Err != influxdb.ErrDatabaseExists {
+		t.Fatalf("unexpected errors: %v, %v, %v", results[0].Err, results[1].Err, results[2].Err)
+	} else if s.DatabaseExists("bar") || s.User("bob") != nil {
+		t.Fatal("unexpected partial batch")
+	}
+
+	// Statements are validated against earlier statements in the batch.
+	results = s.ExecuteBatch(MustParseQuery(`CREATE DATABASE bar; CREATE RETENTION POLICY raw ON bar DURATION 1h REPLICATION 1; CREATE RETENTION POLICY raw ON bar DURATION 2h REPLICATION 1`), nil)
+	if err := results[2].Err; err != influxdb.ErrRetentionPolicyExists {
+		t.Fatalf("unexpected error: %v", err)
+	} else if s.DatabaseExists("bar") {
+		t.Fatal("unexpected partial batch")
+	}
+
+	// Only metadata statements can be batched.
+	results = s.ExecuteBatch(MustParseQuery(`CREATE DATABASE bar; DROP DATABASE foo`), nil)
+	if err := results[1].Err; err != influxdb.ErrInvalidBatchStatement {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 // Ensure the server can return a list of all users.
 func TestServer_Users(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -342,258 +966,3146 @@ func TestServer_CreateRetentionPolicy(t *testing.T) {
 
 	// Create a retention policy on the database.
 	rp := &influxdb.RetentionPolicy{
-		Name:     "bar",
-		Duration: time.Hour,
-		ReplicaN: 2,
+		Name:      "bar",
+		Duration:  time.Hour,
+		ReplicaN:  2,
+		SplitN:    4,
+		ShardHash: influxdb.FNVShardHash,
 	}
 	if err := s.CreateRetentionPolicy("foo", rp); err != nil {
 		t.Fatal(err)
 	}
-	s.Restart()
+	s.Restart()
+
+	// Verify that the policy exists.
+	if o, err := s.RetentionPolicy("foo", "bar"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if o == nil {
+		t.Fatalf("retention policy not found")
+	} else if !reflect.DeepEqual(rp, o) {
+		t.Fatalf("retention policy mismatch: %#v", o)
+	}
+}
+
+// Ensure the server returns an error when creating a retention policy with an invalid db.
+func TestServer_CreateRetentionPolicy_ErrDatabaseNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != influxdb.ErrDatabaseNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server returns an error when creating a retention policy without a name.
+func TestServer_CreateRetentionPolicy_ErrRetentionPolicyNameRequired(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: ""}); err != influxdb.ErrRetentionPolicyNameRequired {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server returns an error when creating a duplicate retention policy.
+func TestServer_CreateRetentionPolicy_ErrRetentionPolicyExists(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"})
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != influxdb.ErrRetentionPolicyExists {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server returns an error when creating a retention policy with an unknown shard hash.
+func TestServer_CreateRetentionPolicy_ErrInvalidShardHash(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", ShardHash: "crc"}); err != influxdb.ErrInvalidShardHash {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server can alter the duration and replication of a retention policy.
+func TestServer_AlterRetentionPolicy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", ReplicaN: 1})
+	s.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T00:00:00Z"))
+
+	// Shortening the duration leaves the groups it has expired to the next
+	// retention policy enforcement.
+	results := s.ExecuteQuery(MustParseQuery(`ALTER RETENTION POLICY raw ON foo DURATION 1h REPLICATION 2`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	} else if a, err := s.ShardGroups("foo"); err != nil || len(a) != 1 {
+		t.Fatalf("unexpected groups: %v, %s", err, mustMarshalJSON(a))
+	} else if err := s.EnforceRetentionPolicies(mustParseTime("2000-01-02T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	} else if a, err := s.ShardGroups("foo"); err != nil || len(a) != 0 {
+		t.Fatalf("unexpected groups: %v, %s", err, mustMarshalJSON(a))
+	}
+
+	// Renaming the policy through the original API leaves its options.
+	if err := s.UpdateRetentionPolicy("foo", "raw", &influxdb.RetentionPolicy{Name: "raw2", Duration: 2 * time.Hour}); err != nil {
+		t.Fatal(err)
+	} else if rp, err := s.RetentionPolicy("foo", "raw2"); err != nil || rp == nil || rp.Duration != time.Hour {
+		t.Fatalf("unexpected policy: %v, %s", err, mustMarshalJSON(rp))
+	} else if err := s.UpdateRetentionPolicy("foo", "raw2", &influxdb.RetentionPolicy{Name: "raw"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Settings that aren't altered are left unchanged.
+	results = s.ExecuteQuery(MustParseQuery(`ALTER RETENTION POLICY raw ON foo REPLICATION 3`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+	if rp, err := s.RetentionPolicy("foo", "raw"); err != nil {
+		t.Fatal(err)
+	} else if rp.Duration != 1*time.Hour || rp.ReplicaN != 3 {
+		t.Fatalf("unexpected policy: %s", mustMarshalJSON(rp))
+	}
+}
+
+// Ensure the server can delete an existing retention policy.
+func TestServer_DeleteRetentionPolicy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create a database and retention policy.
+	s.CreateDatabase("foo")
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != nil {
+		t.Fatal(err)
+	} else if rp, _ := s.RetentionPolicy("foo", "bar"); rp == nil {
+		t.Fatal("retention policy not created")
+	}
+
+	// Remove retention policy from database.
+	if err := s.DeleteRetentionPolicy("foo", "bar"); err != nil {
+		t.Fatal(err)
+	} else if rp, _ := s.RetentionPolicy("foo", "bar"); rp != nil {
+		t.Fatal("retention policy not deleted")
+	}
+	s.Restart()
+
+	if rp, _ := s.RetentionPolicy("foo", "bar"); rp != nil {
+		t.Fatal("retention policy not deleted after restart")
+	}
+}
+
+// Ensure the server returns an error when deleting a retention policy on invalid db.
+func TestServer_DeleteRetentionPolicy_ErrDatabaseNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.DeleteRetentionPolicy("foo", "bar"); err != influxdb.ErrDatabaseNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server returns an error when deleting a retention policy without a name.
+func TestServer_DeleteRetentionPolicy_ErrRetentionPolicyNameRequired(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.DeleteRetentionPolicy("foo", ""); err != influxdb.ErrRetentionPolicyNameRequired {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server returns an error when deleting a non-existent retention policy.
+func TestServer_DeleteRetentionPolicy_ErrRetentionPolicyNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.DeleteRetentionPolicy("foo", "no_such_policy"); err != influxdb.ErrRetentionPolicyNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server can set the default retention policy
+func TestServer_SetDefaultRetentionPolicy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	rp := &influxdb.RetentionPolicy{Name: "bar"}
+	if err := s.CreateRetentionPolicy("foo", rp); err != nil {
+		t.Fatal(err)
+	} else if rp, _ := s.RetentionPolicy("foo", "bar"); rp == nil {
+		t.Fatal("retention policy not created")
+	}
+
+	// Set bar as default
+	if err := s.SetDefaultRetentionPolicy("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	if o, _ := s.DefaultRetentionPolicy("foo"); o == nil {
+		t.Fatal("default policy not set")
+	} else if !reflect.DeepEqual(rp, o) {
+		t.Fatalf("retention policy mismatch: %#v", o)
+	}
+
+	s.Restart()
+
+	if o, _ := s.DefaultRetentionPolicy("foo"); o == nil {
+		t.Fatal("default policy not kept after restart")
+	} else if !reflect.DeepEqual(rp, o) {
+		t.Fatalf("retention policy mismatch after restart: %#v", o)
+	}
+}
+
+// Ensure the server returns an error when setting the deafult retention policy to a non-existant one.
+func TestServer_SetDefaultRetentionPolicy_ErrRetentionPolicyNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.SetDefaultRetentionPolicy("foo", "no_such_policy"); err != influxdb.ErrRetentionPolicyNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server can create and persist a downsample policy.
+func TestServer_CreateDownsamplePolicy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "archive"})
+
+	// Create a downsample policy on the database.
+	dp := &influxdb.DownsamplePolicy{
+		Name:       "hourly",
+		Source:     "raw",
+		Target:     "archive",
+		Interval:   time.Hour,
+		Aggregates: map[string]string{"value": "mean", "*": "max"},
+	}
+	if err := s.CreateDownsamplePolicy("foo", dp); err != nil {
+		t.Fatal(err)
+	} else if err := s.CreateDownsamplePolicy("foo", dp); err != influxdb.ErrDownsamplePolicyExists {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s.Restart()
+
+	// Verify that the policy exists.
+	if a, err := s.DownsamplePolicies("foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if len(a) != 1 || !reflect.DeepEqual(dp, a[0]) {
+		t.Fatalf("downsample policy mismatch: %#v", a)
+	}
+}
+
+// Ensure the server returns an error when a downsample policy references a missing retention policy.
+func TestServer_CreateDownsamplePolicy_ErrRetentionPolicyNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw"})
+	dp := &influxdb.DownsamplePolicy{Name: "hourly", Source: "raw", Target: "no_rp", Interval: time.Hour, Aggregates: map[string]string{"*": "mean"}}
+	if err := s.CreateDownsamplePolicy("foo", dp); err != influxdb.ErrRetentionPolicyNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server can delete an existing downsample policy.
+func TestServer_DeleteDownsamplePolicy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw"})
+	s.CreateDownsamplePolicy("foo", &influxdb.DownsamplePolicy{Name: "hourly", Source: "raw", Target: "raw", Interval: time.Hour, Aggregates: map[string]string{"*": "mean"}})
+
+	if err := s.DeleteDownsamplePolicy("foo", "hourly"); err != nil {
+		t.Fatal(err)
+	} else if err := s.DeleteDownsamplePolicy("foo", "hourly"); err != influxdb.ErrDownsamplePolicyNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	} else if a, _ := s.DownsamplePolicies("foo"); len(a) != 0 {
+		t.Fatalf("unexpected policies: %#v", a)
+	}
+}
+
+// Ensure the server compiles downsample policies into continuous queries per measurement.
+func TestServer_DownsampleQueries(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "archive"})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateDownsamplePolicy("foo", &influxdb.DownsamplePolicy{Name: "hourly", Source: "raw", Target: "archive", Interval: time.Hour, Aggregates: map[string]string{"value": "mean", "*": "max"}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20), "peak": float64(30)}}})
+
+	a, err := s.DownsampleQueries("foo")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 {
+		t.Fatalf("unexpected query count: %d", len(a))
+	}
+
+	// Fields are aggregated in the order they were created.
+	stmt := a[0].Source
+	if a[0].Name != "hourly_cpu" || a[0].Database != "foo" {
+		t.Fatalf("unexpected query: %s", a[0])
+	} else if len(stmt.Fields) != 2 {
+		t.Fatalf("unexpected fields: %s", stmt.Fields)
+	} else if f := stmt.Fields.String(); f != "max(peak) AS peak, mean(value) AS value" && f != "mean(value) AS value, max(peak) AS peak" {
+		t.Fatalf("unexpected fields: %s", f)
+	} else if s := stmt.Source.String(); s != `"foo"."raw"."cpu"` {
+		t.Fatalf("unexpected source: %s", s)
+	} else if s := stmt.Target.String(); s != `INTO "archive"."cpu" ON foo` {
+		t.Fatalf("unexpected target: %s", s)
+	} else if s := stmt.Dimensions.String(); s != "time(1h)" {
+		t.Fatalf("unexpected dimensions: %s", s)
+	}
+}
+
+// Ensure the continuous query scheduler runs downsample policies, including
+// for measurements created after the policy.
+func TestServer_RunContinuousQueries_Downsample(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "archive"})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	if err := s.CreateDownsamplePolicy("foo", &influxdb.DownsamplePolicy{Name: "hourly", Source: "raw", Target: "archive", Interval: time.Hour, Aggregates: map[string]string{"value": "sum"}}); err != nil {
+		t.Fatal(err)
+	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:30:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+
+	// Run the scheduler once the first hour completes.
+	if err := s.RunContinuousQueries(mustParseTime("2000-01-01T01:10:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM "archive"."cpu"`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"\"archive\".\"cpu\"","columns":["time","sum"],"values":[[0,30]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+	if l := s.ContinuousQueryLeases(); len(l) != 1 || l[0].Name != "foo.hourly_cpu" {
+		t.Fatalf("unexpected leases: %s", mustMarshalJSON(l))
+	}
+}
+
+// Ensure the server reads downsampled data for coarse queries when enabled.
+func TestServer_SetDownsampleQueries(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "archive"})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateDownsamplePolicy("foo", &influxdb.DownsamplePolicy{Name: "hourly", Source: "raw", Target: "archive", Interval: time.Hour, Aggregates: map[string]string{"value": "sum"}})
+
+	// Write raw points and rolled up points that differ from them.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T00:30:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T01:30:00Z"), Values: map[string]interface{}{"value": float64(5)}}})
+	s.MustWriteSeries("foo", "archive", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+	s.MustWriteSeries("foo", "archive", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T01:00:00Z"), Values: map[string]interface{}{"value": float64(200)}}})
+	s.SetDownsampleQueries("foo", true)
+
+	sum := func(q string) interface{} {
+		results := s.ExecuteQuery(MustParseQuery(q), "foo", nil)
+		if err := results.Error(); err != nil {
+			t.Fatalf("%s: %s", q, err)
+		} else if len(results[0].Rows) != 1 || len(results[0].Rows[0].Values) == 0 {
+			t.Fatalf("%s: unexpected results: %s", q, mustMarshalJSON(results))
+		}
+		return results[0].Rows[0].Values[0][1]
+	}
+	for i, tt := range []struct {
+		q   string
+		sum float64
+	}{
+		// Coarser intervals read the rollup.
+		{q: `SELECT sum(value) FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-01 02:00:00' GROUP BY time(2h)`, sum: 300},
+		{q: `SELECT sum(value) FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-01 01:00:00' GROUP BY time(1h)`, sum: 100},
+
+		// Time ranges past the last rolled up interval read the raw data.
+		{q: `SELECT sum(value) FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-01 03:00:00' GROUP BY time(1h)`, sum: 30},
+		{q: `SELECT sum(value) FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-01 04:00:00' GROUP BY time(2h)`, sum: 35},
+
+		// Finer intervals and tags read the raw data.
+		{q: `SELECT sum(value) FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-01 01:00:00' GROUP BY time(90m)`, sum: 30},
+		{q: `SELECT sum(value) FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-01 01:00:00' AND host = 'a' GROUP BY time(1h)`, sum: 30},
+	} {
+		if v := sum(tt.q); v != tt.sum {
+			t.Errorf("%d. %s: unexpected sum: %v", i, tt.q, v)
+		}
+	}
+
+	// Queries read the raw data once disabled.
+	s.SetDownsampleQueries("foo", false)
+	if v := sum(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-01 02:00:00' GROUP BY time(2h)`); v != float64(35) {
+		t.Fatalf("unexpected sum: %v", v)
+	}
+}
+
+// Ensure the database can write data to the database.
+func TestServer_WriteSeries(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "mypolicy", Duration: 1 * time.Hour})
+	s.CreateUser("susy", "pass", false)
+
+	// Check if a topic is being subscribed to.
+	var subscribed bool
+	c.SubscribeFunc = func(replicaID, topicID uint64) error {
+		subscribed = true
+		return nil
+	}
+
+	// Write series with one point to the database.
+	tags := map[string]string{"host": "servera.influx.com", "region": "uswest"}
+	index, err := s.WriteSeries("foo", "mypolicy", []influxdb.Point{influxdb.Point{Name: "cpu_load", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(23.2)}}})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = s.Sync(index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Write another point 10 seconds later so it goes through "raw series".
+	index, err = s.WriteSeries("foo", "mypolicy", []influxdb.Point{influxdb.Point{Name: "cpu_load", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(100)}}})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = s.Sync(index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Verify a subscription was made.
+	if !subscribed {
+		t.Fatal("expected subscription")
+	}
+
+	// Retrieve first series data point.
+	if v, err := s.ReadSeries("foo", "mypolicy", "cpu_load", tags, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"value": float64(23.2)}) {
+		t.Fatalf("values mismatch: %#v", v)
+	}
+
+	// Retrieve second series data point.
+	if v, err := s.ReadSeries("foo", "mypolicy", "cpu_load", tags, mustParseTime("2000-01-01T00:00:10Z")); err != nil {
+		t.Fatal(err)
+	} else if mustMarshalJSON(v) != mustMarshalJSON(map[string]interface{}{"value": float64(100)}) {
+		t.Fatalf("values mismatch: %#v", v)
+	}
+
+	// Retrieve non-existent series data point.
+	if v, err := s.ReadSeries("foo", "mypolicy", "cpu_load", tags, mustParseTime("2000-01-01T00:01:00Z")); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("expected nil values: %#v", v)
+	}
+}
+
+// Ensure the server can execute a query and return the data correctly.
+func TestServer_ExecuteQuery(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", false)
+
+	// Write series with one point to the database.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	// Select data from the server.
+	results := s.ExecuteQuery(MustParseQuery("SELECT sum(value) FROM cpu"), "foo", nil)
+	if len(results) != 1 {
+		t.Fatalf("unexpected result count: %d", len(results))
+	}
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Rows) != 1 {
+		t.Fatalf("unexpected row count: %s", len(res.Rows))
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,150]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+}
+
+// Ensure the server tracks out-of-order writes per shard.
+func TestServer_ShardStats(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	for _, ts := range []string{"2000-01-01T00:00:10Z", "2000-01-01T00:00:20Z", "2000-01-01T00:00:05Z", "2000-01-01T00:00:15Z"} {
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime(ts), Values: map[string]interface{}{"value": float64(1)}}})
+	}
+
+	a := s.ShardStats()
+	if len(a) != 1 {
+		t.Fatalf("unexpected shard count: %d", len(a))
+	} else if a[0].PointsWritten != 4 || a[0].PointsOutOfOrder != 2 || a[0].MaxOutOfOrder != 15*time.Second || a[0].Size == 0 {
+		t.Fatalf("unexpected stats: %#v", a[0])
+	}
+}
+
+// Ensure a sample of applied writes can be tailed.
+func TestServer_TailWrites(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	all, cancelAll := s.TailWrites(1)
+	defer cancelAll()
+	sampled, cancelSampled := s.TailWrites(2)
+
+	// The first write creates the field so both encodings are tailed.
+	for i, ts := range []string{"2000-01-01T00:00:00Z", "2000-01-01T00:00:10Z", "2000-01-01T00:00:20Z"} {
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime(ts), Values: map[string]interface{}{"value": float64(i)}}})
+	}
+
+	for i := 0; i < 3; i++ {
+		w := <-all
+		if w.Database != "foo" || w.RetentionPolicy != "raw" || w.Measurement != "cpu" || !reflect.DeepEqual(w.Tags, map[string]string{"host": "a"}) {
+			t.Fatalf("unexpected write(%d): %#v", i, w)
+		} else if !w.Timestamp.Equal(mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(i) * 10 * time.Second)) {
+			t.Fatalf("unexpected timestamp(%d): %s", i, w.Timestamp)
+		} else if !reflect.DeepEqual(w.Values, map[string]interface{}{"value": float64(i)}) {
+			t.Fatalf("unexpected values(%d): %#v", i, w.Values)
+		}
+	}
+
+	// Only every other write is sampled.
+	if w := <-sampled; w.Values["value"] != float64(0) {
+		t.Fatalf("unexpected sampled write: %#v", w)
+	} else if w := <-sampled; w.Values["value"] != float64(2) {
+		t.Fatalf("unexpected sampled write: %#v", w)
+	}
+
+	// Canceling the tail closes the channel.
+	cancelSampled()
+	if _, ok := <-sampled; ok {
+		t.Fatal("expected closed channel")
+	}
+}
+
+// Ensure buffered writes are applied in order and are visible to queries.
+func TestServer_ShardReorderBuffer(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.SetShardReorderBufferSize(3); err != nil {
+		t.Fatal(err)
+	}
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Write more points than the buffer holds so one batch is written
+	// and one point remains buffered.
+	for i, ts := range []string{"2000-01-01T00:00:30Z", "2000-01-01T00:00:10Z", "2000-01-01T00:00:20Z", "2000-01-01T00:00:00Z"} {
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime(ts), Values: map[string]interface{}{"value": float64(i + 1)}}})
+	}
+
+	// Ensure the buffered point is flushed before the query reads the shard.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	} else if s := mustMarshalJSON(results[0]); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,10]]}]}` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
+
+// Ensure buffered writes are replayed from the write-ahead log when a shard
+// is opened after its store missed them.
+func TestServer_ShardReorderBuffer_ReplayWAL(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.SetShardReorderBufferSize(10); err != nil {
+		t.Fatal(err)
+	}
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	for i, ts := range []string{"2000-01-01T00:00:10Z", "2000-01-01T00:00:00Z"} {
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime(ts), Values: map[string]interface{}{"value": float64(i + 1)}}})
+	}
+
+	// Save the log while both points are still buffered.
+	paths, err := filepath.Glob(filepath.Join(s.Path(), "shards", "*.wal"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(paths) != 1 {
+		t.Fatalf("unexpected wal files: %v", paths)
+	}
+	wal, err := ioutil.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	} else if len(wal) == 0 {
+		t.Fatal("expected buffered points in wal")
+	}
+
+	// Simulate a crash by replacing the store with an empty one and restoring
+	// the log, plus a partial point that was never acknowledged.
+	path, client := s.Path(), s.Client()
+	if err := s.Server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(strings.TrimSuffix(paths[0], ".wal")); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(paths[0], append(wal, 0, 0, 0), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Server.Open(path); err != nil {
+		t.Fatal(err)
+	} else if err := s.Server.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure the logged points were written to the new store.
+	for _, ts := range []string{"2000-01-01T00:00:10Z", "2000-01-01T00:00:00Z"} {
+		if v, err := s.ReadSeries("foo", "raw", "cpu", nil, mustParseTime(ts)); err != nil {
+			t.Fatal(err)
+		} else if v == nil {
+			t.Fatalf("point not replayed: %s", ts)
+		}
+	}
+}
+
+// Ensure the server can select from measurements in multiple databases.
+func TestServer_ExecuteQuery_CrossDatabase(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	for _, name := range []string{"foo", "bar"} {
+		s.CreateDatabase(name)
+		s.CreateRetentionPolicy(name, &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+		s.SetDefaultRetentionPolicy(name, "raw")
+	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("bar", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	// Select from both databases in a single statement.
+	q := MustParseQuery(`SELECT sum(value) FROM cpu, "bar"."raw"."cpu"`)
+	results := s.ExecuteQuery(q, "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,20]]},{"name":"\"bar\".\"raw\".\"cpu\"","columns":["time","sum"],"values":[[0,100]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// The default retention policy is used when omitted.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM "bar".."cpu"`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"\"bar\"..\"cpu\"","columns":["time","sum"],"values":[[0,100]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Unknown databases are rejected.
+	if err := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM "baz"."raw"."cpu"`), "foo", nil).Error(); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Users must be able to read every database in the statement.
+	s.CreateUser("susy", "pass", false)
+	s.GrantPrivilege("susy", "foo", influxql.ReadPrivilege)
+	if err := s.ExecuteQuery(q, "foo", s.User("susy")).Error(); err != influxdb.ErrReadAccessDenied {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.GrantPrivilege("susy", "bar", influxql.ReadPrivilege)
+	if err := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu, "bar"."raw"."cpu"`), "foo", s.User("susy")).Error(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the query cost total is clamped instead of overflowing.
+func TestQueryCost_Total(t *testing.T) {
+	if n := (&influxdb.QueryCost{Shards: 2, Series: 3, Buckets: 4}).Total(); n != 24 {
+		t.Fatalf("unexpected total: %d", n)
+	} else if n := (&influxdb.QueryCost{Shards: 1 << 20, Series: 1 << 30, Buckets: 1 << 20}).Total(); n != math.MaxInt64 {
+		t.Fatalf("unexpected total: %d", n)
+	} else if n := (&influxdb.QueryCost{Shards: 0, Series: 1 << 62, Buckets: 1 << 62}).Total(); n != 0 {
+		t.Fatalf("unexpected total: %d", n)
+	}
+}
+
+// Ensure the server rejects select statements that exceed the query cost budget.
+func TestServer_ExecuteQuery_MaxQueryCost(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+	s.SetMaxQueryCost(2)
+
+	for i, tt := range []struct {
+		q    string
+		cost int64
+	}{
+		{q: `SELECT sum(value) FROM cpu WHERE time >= '2000-01-01 00:00:00' AND time < '2000-01-01 00:01:00'`},
+		{q: `SELECT sum(value) FROM cpu WHERE region = 'us-east' AND time >= '2000-01-01 00:00:00' AND time < '2000-01-01 00:01:00' GROUP BY time(30s)`},
+		{q: `SELECT sum(value) FROM cpu WHERE time >= '2000-01-01 00:00:00' AND time < '2000-01-01 00:01:00' GROUP BY time(10s)`, cost: 12},
+	} {
+		err := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil).Error()
+		if tt.cost == 0 && err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, tt.q, err)
+		} else if e, ok := err.(*influxdb.QueryCostError); tt.cost != 0 && (!ok || e.Cost.Total() != tt.cost) {
+			t.Errorf("%d. %s: unexpected error: %v", i, tt.q, err)
+		}
+	}
+}
+
+// Ensure a running query is listed and stops with an error when killed.
+func TestServer_KillQuery(t *testing.T) {
+	storage := NewMemoryStorage()
+	s := NewServer()
+	if err := s.SetStorageEngine(func(path string) (influxdb.StorageEngine, error) { return storage, nil }); err != nil {
+		t.Fatal(err)
+	} else if err := s.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+
+	// Block the query's first read until the test releases it.
+	started, release := make(chan struct{}), make(chan struct{})
+	storage.SeekFunc = func() { close(started); <-release }
+
+	var results influxdb.Results
+	done := make(chan struct{})
+	go func() {
+		results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01 00:00:00' AND time < '2000-01-01 00:01:00' GROUP BY time(10s)`), "foo", nil)
+		close(done)
+	}()
+	<-started
+
+	// The running query is listed along with the listing statement itself.
+	a := s.Queries()
+	if len(a) != 1 || a[0].Database != "foo" || !strings.HasPrefix(a[0].Query, "SELECT sum(value) FROM cpu") {
+		t.Fatalf("unexpected queries: %s", mustMarshalJSON(a))
+	}
+	if res := s.ExecuteQuery(MustParseQuery(`SHOW QUERIES`), "foo", nil)[0]; res.Err != nil {
+		t.Fatal(res.Err)
+	} else if values := res.Rows[0].Values; len(values) != 2 || values[0][0] != a[0].ID || values[1][1] != "LIST QUERIES" {
+		t.Fatalf("unexpected rows: %s", mustMarshalJSON(res.Rows))
+	}
+
+	// Kill the query and let it read.
+	if err := s.ExecuteQuery(MustParseQuery(fmt.Sprintf(`KILL QUERY %d`, a[0].ID)), "foo", nil).Error(); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	<-done
+
+	if err := results.Error(); err != influxdb.ErrQueryKilled {
+		t.Fatalf("unexpected error: %v", err)
+	} else if other := s.Queries(); len(other) != 0 {
+		t.Fatalf("unexpected queries: %s", mustMarshalJSON(other))
+	} else if err := s.KillQuery(a[0].ID); err != influxdb.ErrQueryNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server queues, rejects and times out select statements.
+func TestServer_QueryLimits(t *testing.T) {
+	storage := NewMemoryStorage()
+	s := NewServer()
+	if err := s.SetMaxConcurrentQueries(-1, 0); err != influxdb.ErrInvalidQueryLimit {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.SetMaxConcurrentQueries(1, 1); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetStorageEngine(func(path string) (influxdb.StorageEngine, error) { return storage, nil }); err != nil {
+		t.Fatal(err)
+	} else if err := s.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+
+	// Limits can't be changed once the server is open.
+	if err := s.SetMaxConcurrentQueries(2, 0); err != influxdb.ErrServerOpen {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Block the first query's read until the test releases it.
+	var once sync.Once
+	started, release := make(chan struct{}), make(chan struct{})
+	storage.SeekFunc = func() { once.Do(func() { close(started); <-release }) }
+
+	q := MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01 00:00:00' AND time < '2000-01-01 00:00:10'`)
+	results := make([]influxdb.Results, 2)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { defer wg.Done(); results[0] = s.ExecuteQuery(q, "foo", nil) }()
+	<-started
+
+	// The second query waits for a slot and is listed while it waits.
+	wg.Add(1)
+	go func() { defer wg.Done(); results[1] = s.ExecuteQuery(q, "foo", nil) }()
+	var a []*influxdb.QueryInfo
+	for a = s.Queries(); len(a) != 2; a = s.Queries() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full so the third query is rejected.
+	if err := s.ExecuteQuery(q, "foo", nil).Error(); err != influxdb.ErrTooManyQueries {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Kill the queued query and let the first query finish.
+	if err := s.KillQuery(a[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	wg.Wait()
+	if err := results[0].Error(); err != nil {
+		t.Fatal(err)
+	} else if err := results[1].Error(); err != influxdb.ErrQueryKilled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Slow queries are cancelled once they exceed the timeout.
+	s.SetQueryTimeout(10 * time.Millisecond)
+	storage.SeekFunc = func() { time.Sleep(50 * time.Millisecond) }
+	if err := s.ExecuteQuery(q, "foo", nil).Error(); err != influxdb.ErrQueryTimeout {
+		t.Fatalf("unexpected error: %v", err)
+	} else if a := s.Queries(); len(a) != 0 {
+		t.Fatalf("unexpected queries: %s", mustMarshalJSON(a))
+	}
+}
+
+// Ensure the server can group and filter by pseudo tags.
+func TestServer_ExecuteQuery_PseudoTags(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	// Group by the pseudo tags.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY _retentionPolicy, _shard, _dataNode`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","tags":{"_dataNode":"1","_retentionPolicy":"raw","_shard":"1"},"columns":["time","sum"],"values":[[0,120]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Filter by a pseudo tag.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE _retentionPolicy = 'raw'`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,120]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE _retentionPolicy = 'other'`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Rows) != 0 {
+		t.Fatalf("unexpected rows: %s", mustMarshalJSON(res))
+	}
+
+	// Write to a later shard group. Its shard is reported for series read from it.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T02:00:00Z"), Values: map[string]interface{}{"value": float64(5)}}})
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01 02:00:00' AND time < '2000-01-01 03:00:00' GROUP BY time(1h), _shard`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","tags":{"_shard":"2"},"columns":["time","sum"],"values":[[946692000000000,5]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Statements reading more than one shard group can't use the shard pseudo tags.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time < '2000-01-01 03:00:00' GROUP BY _shard`), "foo", nil)
+	if res := results[0]; res.Err != influxdb.ErrShardPseudoTagTimeRange {
+		t.Fatalf("unexpected error: %v", res.Err)
+	}
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time < '2000-01-01 03:00:00' AND _dataNode = '1'`), "foo", nil)
+	if res := results[0]; res.Err != influxdb.ErrShardPseudoTagTimeRange {
+		t.Fatalf("unexpected error: %v", res.Err)
+	}
+}
+
+// Ensure the server returns a row set for each measurement listed in FROM.
+func TestServer_ExecuteQuery_MultipleMeasurements(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "mem", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu, mem`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,20]]},{"name":"mem","columns":["time","sum"],"values":[[0,100]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+}
+
+// Ensure the server combines raw values from every shard for aggregates such
+// as median() and distinct().
+func TestServer_ExecuteQuery_MultipleShards_Aggregates(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-02T00:00:00Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-02T00:00:10Z"), Values: map[string]interface{}{"value": float64(40)}}})
+	if a, _ := s.ShardGroups("foo"); len(a) != 2 {
+		t.Fatalf("unexpected shard group count: %d", len(a))
+	}
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SELECT median(value) FROM cpu`, exp: `{"rows":[{"name":"cpu","columns":["time","median"],"values":[[0,30]]}]}`},
+		{q: `SELECT distinct(value) FROM cpu`, exp: `{"rows":[{"name":"cpu","columns":["time","distinct"],"values":[[0,[10,30,40]]]}]}`},
+		{q: `SELECT count(distinct(value)) FROM cpu`, exp: `{"rows":[{"name":"cpu","columns":["time","count"],"values":[[0,3]]}]}`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results[0]; res.Err != nil {
+			t.Errorf("%d. unexpected error: %s", i, res.Err)
+		} else if s := mustMarshalJSON(res); s != tt.exp {
+			t.Errorf("%d. unexpected result: %s", i, s)
+		}
+	}
+}
+
+// Ensure the server can group a select statement by every tag key.
+func TestServer_ExecuteQuery_GroupByWildcard(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera", "region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverb", "region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+
+	res := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY *`), "foo", nil)[0]
+	if res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Rows) != 2 {
+		t.Fatalf("unexpected row count: %s", mustMarshalJSON(res))
+	}
+	for _, row := range res.Rows {
+		if row.Tags["region"] != "us-east" || (row.Tags["host"] != "servera" && row.Tags["host"] != "serverb") {
+			t.Fatalf("unexpected tags: %v", row.Tags)
+		}
+	}
+}
+
+// Ensure the server can select from every measurement matching a regex.
+func TestServer_ExecuteQuery_RegexMeasurement(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu_user", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu_system", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "mem", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SELECT sum(value) FROM /^cpu/`, exp: `{"rows":[{"name":"cpu_system","columns":["time","sum"],"values":[[0,10]]},{"name":"cpu_user","columns":["time","sum"],"values":[[0,20]]}]}`},
+		{q: `SELECT sum(value) FROM mem, /_user$/`, exp: `{"rows":[{"name":"mem","columns":["time","sum"],"values":[[0,100]]},{"name":"cpu_user","columns":["time","sum"],"values":[[0,20]]}]}`},
+		{q: `SELECT sum(value) FROM /^disk/`, exp: `{}`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results[0]; res.Err != nil {
+			t.Errorf("%d. unexpected error: %s", i, res.Err)
+		} else if s := mustMarshalJSON(res); s != tt.exp {
+			t.Errorf("%d. unexpected result: %s", i, s)
+		}
+	}
+}
+
+// Ensure the server can drop all series matching a tag condition.
+func TestServer_DropSeries(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	// Drop the series in one region.
+	results := s.ExecuteQuery(MustParseQuery(`DROP SERIES FROM cpu WHERE region = 'us-east'`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if _, err := s.ReadSeries("foo", "raw", "cpu", map[string]string{"region": "us-east"}, mustParseTime("2000-01-01T00:00:00Z")); err != influxdb.ErrSeriesNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Verify only the remaining series is queried.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,100]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Verify the series is removed from the metastore.
+	s.Restart()
+	if ids := s.MeasurementSeriesIDs("foo", "cpu"); len(ids) != 1 || ids[0] != 2 {
+		t.Fatalf("unexpected series ids: %v", ids)
+	}
+
+	// Verify conditions on fields are rejected.
+	results = s.ExecuteQuery(MustParseQuery(`DROP SERIES WHERE value > 10`), "foo", nil)
+	if err := results.Error(); err == nil || err.Error() != "invalid series condition: value > 10.000" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Drop the remaining series from a list of measurements.
+	results = s.ExecuteQuery(MustParseQuery(`DROP SERIES FROM cpu, mem`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if ids := s.MeasurementSeriesIDs("foo", "cpu"); len(ids) != 0 {
+		t.Fatalf("unexpected series ids: %v", ids)
+	}
+}
+
+// Ensure the server can delete the points of series within a time range.
+func TestServer_DeleteRange(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	for _, p := range []struct {
+		region, timestamp string
+	}{{"us-east", "2000-01-01T00:00:00Z"}, {"us-east", "2000-01-01T00:30:00Z"}, {"us-west", "2000-01-01T00:00:00Z"}} {
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": p.region}, Timestamp: mustParseTime(p.timestamp), Values: map[string]interface{}{"value": float64(1)}}})
+	}
+	exists := func(region, timestamp string) bool {
+		v, err := s.ReadSeries("foo", "raw", "cpu", map[string]string{"region": region}, mustParseTime(timestamp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v != nil
+	}
+
+	// Delete the earlier point in one region.
+	results := s.ExecuteQuery(MustParseQuery(`DELETE FROM cpu WHERE time < '2000-01-01 00:10:00' AND region = 'us-east'`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	} else if exists("us-east", "2000-01-01T00:00:00Z") {
+		t.Fatal("expected point to be deleted")
+	} else if !exists("us-east", "2000-01-01T00:30:00Z") || !exists("us-west", "2000-01-01T00:00:00Z") {
+		t.Fatal("expected points to remain")
+	}
+
+	// Delete every point after a time.
+	results = s.ExecuteQuery(MustParseQuery(`DELETE FROM cpu WHERE time >= '2000-01-01'`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	} else if exists("us-east", "2000-01-01T00:30:00Z") || exists("us-west", "2000-01-01T00:00:00Z") {
+		t.Fatal("expected points to be deleted")
+	}
+
+	// Series remain in the index.
+	if ids := s.MeasurementSeriesIDs("foo", "cpu"); len(ids) != 2 {
+		t.Fatalf("unexpected series ids: %v", ids)
+	}
+
+	// Conditions on fields and invalid times are rejected.
+	results = s.ExecuteQuery(MustParseQuery(`DELETE FROM cpu WHERE value > 10`), "foo", nil)
+	if err := results.Error(); err == nil || err.Error() != "invalid series condition: value > 10.000" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results = s.ExecuteQuery(MustParseQuery(`DELETE FROM cpu WHERE time < 'yesterday'`), "foo", nil)
+	if err := results.Error(); err == nil || err.Error() != "invalid time condition: time < 'yesterday'" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server can list series by measurement with tag filters and a limit.
+func TestServer_ListSeries(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera", "region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverb", "region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "mem", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	for i, tt := range []struct {
+		q    string
+		rows string
+	}{
+		{q: `LIST SERIES`, rows: `[{"name":"cpu","columns":["id","host","region"],"values":[[1,"servera","us-east"],[2,"serverb","us-west"]]},{"name":"mem","columns":["id","region"],"values":[[3,"us-west"]]}]`},
+		{q: `LIST SERIES FROM cpu WHERE region = 'us-west'`, rows: `[{"name":"cpu","columns":["id","host","region"],"values":[[2,"serverb","us-west"]]}]`},
+		{q: `LIST SERIES WHERE region = 'us-west' LIMIT 1`, rows: `[{"name":"cpu","columns":["id","host","region"],"values":[[2,"serverb","us-west"]]}]`},
+		{q: `LIST SERIES FROM disk`, rows: `[]`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results[0]; res.Err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, tt.q, res.Err)
+		} else if rows := mustMarshalJSON(res.Rows); rows != tt.rows {
+			t.Errorf("%d. %s: unexpected rows: %s", i, tt.q, rows)
+		}
+	}
+}
+
+// Ensure a traced write carries its request id to the shard in every message.
+func TestServer_WriteSeriesWithRequestID(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Record the request ids of published messages.
+	var requestIDs []string
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		var v struct {
+			RequestID string `json:"requestID"`
+		}
+		if err := json.Unmarshal(m.Data, &v); err == nil && v.RequestID != "" {
+			requestIDs = append(requestIDs, v.RequestID)
+		}
+		c.c <- m
+		return m.Index, nil
+	}
+
+	// Write twice so the second write would normally use the raw format.
+	for _, ts := range []string{"2000-01-01T00:00:00Z", "2000-01-01T00:00:10Z"} {
+		index, err := s.WriteSeriesWithRequestID("req-1", "foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime(ts), Values: map[string]interface{}{"value": float64(10)}}})
+		if err != nil {
+			t.Fatal(err)
+		} else if err = s.Sync(index); err != nil {
+			t.Fatalf("sync error: %s", err)
+		}
+	}
+	if !reflect.DeepEqual(requestIDs, []string{"req-1", "req-1"}) {
+		t.Fatalf("unexpected request ids: %v", requestIDs)
+	}
+
+	// Verify both points were written.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res.Rows); s != `[{"name":"cpu","columns":["time","sum"],"values":[[0,20]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+}
+
+// Ensure the server retains a bounded number of unconsumed apply errors.
+func TestServer_ApplyErrors(t *testing.T) {
+	defer func(n int) { influxdb.MaxApplyErrors = n }(influxdb.MaxApplyErrors)
+	influxdb.MaxApplyErrors = 2
+
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	// Publish duplicate "create database" messages without syncing on them.
+	var index uint64
+	for i := 0; i < 3; i++ {
+		index, _ = c.Publish(&messaging.Message{Type: messaging.MessageType(0x10), Data: []byte(`{"name":"foo"}`)})
+	}
+	s.CreateDatabase("bar")
+
+	// Consume the second error with Sync. The first error has been evicted.
+	if err := s.Sync(index - 1); err != influxdb.ErrDatabaseExists {
+		t.Fatalf("unexpected sync error: %s", err)
+	}
+
+	// Only the most recent unconsumed error should be listed.
+	if a := s.ApplyErrors(); len(a) != 1 || a[0].Index != index {
+		t.Fatalf("unexpected errors: %s", mustMarshalJSON(a))
+	} else if v := mustMarshalJSON(a[0]); v != `{"index":`+strconv.FormatUint(index, 10)+`,"type":16,"error":"database exists"}` {
+		t.Fatalf("unexpected json: %s", v)
+	}
+
+	// Clear the errors.
+	s.ClearApplyErrors()
+	if a := s.ApplyErrors(); len(a) != 0 {
+		t.Fatalf("unexpected errors after clear: %d", len(a))
+	}
+}
+
+// Ensure Sync waits for the index to be applied and gives up after a timeout.
+func TestServer_SyncTimeout(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+
+	// Hold back published messages until they are released.
+	var held []*messaging.Message
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		held = append(held, m)
+		return m.Index, nil
+	}
+	index, _ := c.Publish(&messaging.Message{Type: messaging.MessageType(0x10), Data: []byte(`{"name":"foo"}`)})
+
+	if err := s.SyncTimeout(index, 10*time.Millisecond); err != influxdb.ErrSyncTimeout {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Release the message while a caller is waiting.
+	errs := make(chan error)
+	go func() { errs <- s.SyncTimeout(index, 5*time.Second) }()
+	c.send(held[0])
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !s.DatabaseExists("foo") {
+		t.Fatal("expected database")
+	}
+
+	// Closing the server releases waiting callers.
+	go func() { errs <- s.Sync(index + 1) }()
+	time.Sleep(10 * time.Millisecond)
+	s.Server.Close()
+	if err := <-errs; err != influxdb.ErrServerClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server normalizes tags so equivalent tag sets share a series.
+func TestServer_WriteSeries_TagNormalization(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.SetTagNormalizer(&influxdb.TagNormalizer{Lowercase: true, TrimSpace: true})
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"Host ": " ServerA"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(30)}}})
+
+	// Both points should belong to a single series.
+	results := s.ExecuteQuery(MustParseQuery(`LIST SERIES`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res.Rows); s != `[{"name":"cpu","columns":["id","host"],"values":[[1,"servera"]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+
+	// Reads should normalize tags the same way.
+	if v, err := s.ReadSeries("foo", "raw", "cpu", map[string]string{"HOST": "SERVERA"}, mustParseTime("2000-01-01T00:00:10Z")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"value": float64(30)}) {
+		t.Fatalf("unexpected values: %#v", v)
+	}
+}
+
+// Ensure writes matching a block are rejected and blocks survive a restart.
+func TestServer_BlockWrites(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	for _, q := range []string{`BLOCK MEASUREMENT = cpu`, `BLOCK MEASUREMENT =~ /^debug_/`, `BLOCK TAG host = 'rogue'`} {
+		if err := s.ExecuteQuery(MustParseQuery(q), "foo", nil).Error(); err != nil {
+			t.Fatalf("%s: %s", q, err)
+		}
+	}
+	if err := s.BlockWrites(&influxdb.WriteBlock{Value: "cpu"}); err != influxdb.ErrWriteBlockExists {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.BlockWrites(&influxdb.WriteBlock{Value: "(", Regex: true}); err != influxdb.ErrInvalidWriteBlock {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.Restart()
+
+	for i, tt := range []struct {
+		name string
+		tags map[string]string
+		err  error
+	}{
+		{name: "cpu", err: influxdb.ErrWriteBlocked},
+		{name: "debug_trace", err: influxdb.ErrWriteBlocked},
+		{name: "mem", tags: map[string]string{"host": "rogue"}, err: influxdb.ErrWriteBlocked},
+		{name: "mem", tags: map[string]string{"host": "servera"}},
+		{name: "cpu_idle"},
+	} {
+		if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: tt.name, Tags: tt.tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}}); err != tt.err {
+			t.Errorf("%d. %s: unexpected error: %v", i, tt.name, err)
+		}
+	}
+
+	// Blocked points don't create series.
+	if a := s.MeasurementNames("foo"); !reflect.DeepEqual(a, []string{"cpu_idle", "mem"}) {
+		t.Fatalf("unexpected measurements: %v", a)
+	}
+
+	// Remove a block and list the remaining ones.
+	if err := s.ExecuteQuery(MustParseQuery(`UNBLOCK MEASUREMENT = cpu`), "foo", nil).Error(); err != nil {
+		t.Fatal(err)
+	} else if err := s.UnblockWrites(&influxdb.WriteBlock{Value: "cpu"}); err != influxdb.ErrWriteBlockNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+
+	results := s.ExecuteQuery(MustParseQuery(`LIST BLOCKS`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatal(res.Err)
+	} else if s := mustMarshalJSON(res.Rows); s != `[{"columns":["pattern"],"values":[["MEASUREMENT =~ /^debug_/"],["TAG host = 'rogue'"]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+}
+
+// Ensure the server can list measurements filtered by name and tag condition.
+func TestServer_ListMeasurements(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera", "region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu_idle", Tags: map[string]string{"host": "serverb", "region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "mem", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	for i, tt := range []struct {
+		q    string
+		rows string
+	}{
+		{q: `LIST MEASUREMENTS`, rows: `[{"name":"measurements","columns":["name"],"values":[["cpu"],["cpu_idle"],["mem"]]}]`},
+		{q: `LIST MEASUREMENTS LIMIT 2`, rows: `[{"name":"measurements","columns":["name"],"values":[["cpu"],["cpu_idle"]]}]`},
+		{q: `LIST MEASUREMENTS WITH MEASUREMENT = cpu`, rows: `[{"name":"measurements","columns":["name"],"values":[["cpu"]]}]`},
+		{q: `LIST MEASUREMENTS WITH MEASUREMENT =~ /^cpu/`, rows: `[{"name":"measurements","columns":["name"],"values":[["cpu"],["cpu_idle"]]}]`},
+		{q: `LIST MEASUREMENTS WHERE region = 'us-west'`, rows: `[{"name":"measurements","columns":["name"],"values":[["cpu_idle"],["mem"]]}]`},
+		{q: `LIST MEASUREMENTS WHERE host =~ /^server/ AND region != 'us-east'`, rows: `[{"name":"measurements","columns":["name"],"values":[["cpu_idle"]]}]`},
+		{q: `LIST MEASUREMENTS WITH MEASUREMENT =~ /^cpu/ WHERE region = 'us-central'`, rows: `[]`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results[0]; res.Err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, tt.q, res.Err)
+		} else if rows := mustMarshalJSON(res.Rows); rows != tt.rows {
+			t.Errorf("%d. %s: unexpected rows: %s", i, tt.q, rows)
+		}
+	}
+}
+
+// Ensure the server can list tag keys and values scoped by measurement and condition.
+func TestServer_ListTagKeysAndValues(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera", "region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverb", "region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "mem", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	for i, tt := range []struct {
+		q    string
+		rows string
+	}{
+		{q: `LIST TAG KEYS`, rows: `[{"name":"cpu","columns":["tagKey"],"values":[["host"],["region"]]},{"name":"mem","columns":["tagKey"],"values":[["region"]]}]`},
+		{q: `LIST TAG KEYS FROM cpu LIMIT 1`, rows: `[{"name":"cpu","columns":["tagKey"],"values":[["host"]]}]`},
+		{q: `LIST TAG KEYS LIMIT 2`, rows: `[{"name":"cpu","columns":["tagKey"],"values":[["host"],["region"]]}]`},
+		{q: `LIST TAG KEYS WHERE host = 'servera'`, rows: `[{"name":"cpu","columns":["tagKey"],"values":[["host"],["region"]]}]`},
+		{q: `LIST TAG VALUES FROM cpu`, rows: `[{"name":"cpu","columns":["tagKey","tagValue"],"values":[["host","servera"],["host","serverb"],["region","us-east"],["region","us-west"]]}]`},
+		{q: `LIST TAG VALUES WITH KEY = region WHERE region != 'us-east'`, rows: `[{"name":"cpu","columns":["tagKey","tagValue"],"values":[["region","us-west"]]},{"name":"mem","columns":["tagKey","tagValue"],"values":[["region","us-west"]]}]`},
+		{q: `LIST TAG VALUES FROM cpu WITH KEY = host WHERE region = 'us-west'`, rows: `[{"name":"cpu","columns":["tagKey","tagValue"],"values":[["host","serverb"]]}]`},
+		{q: `LIST TAG VALUES WITH KEY = region LIMIT 2`, rows: `[{"name":"cpu","columns":["tagKey","tagValue"],"values":[["region","us-east"],["region","us-west"]]}]`},
+		{q: `LIST TAG VALUES FROM disk`, rows: `[]`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results[0]; res.Err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, tt.q, res.Err)
+		} else if rows := mustMarshalJSON(res.Rows); rows != tt.rows {
+			t.Errorf("%d. %s: unexpected rows: %s", i, tt.q, rows)
+		}
+	}
+}
+
+// Ensure the server can list field keys and types scoped by measurement.
+func TestServer_ListFieldKeys(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"status": "ok"}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "mem", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"used": int64(100), "swapped": true}}})
+
+	for i, tt := range []struct {
+		q     string
+		names map[string][]string
+	}{
+		{q: `LIST FIELD KEYS`, names: map[string][]string{"cpu": {"value:number", "status:string"}, "mem": {"swapped:boolean", "used:integer"}}},
+		{q: `LIST FIELD KEYS FROM cpu LIMIT 1`, names: map[string][]string{"cpu": {"value:number"}}},
+		{q: `LIST FIELD KEYS FROM mem, cpu`, names: map[string][]string{"cpu": {"value:number", "status:string"}, "mem": {"swapped:boolean", "used:integer"}}},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results[0]; res.Err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, tt.q, res.Err)
+		} else {
+			names := make(map[string][]string)
+			for _, row := range res.Rows {
+				for _, v := range row.Values {
+					names[row.Name] = append(names[row.Name], v[0].(string)+":"+v[1].(string))
+				}
+				sort.Strings(names[row.Name])
+			}
+			for _, a := range tt.names {
+				sort.Strings(a)
+			}
+			if !reflect.DeepEqual(tt.names, names) {
+				t.Errorf("%d. %s: unexpected fields: %v", i, tt.q, names)
+			}
+		}
+	}
+}
+
+// Ensure the server can list the distinct values of a field.
+func TestServer_ListFieldValues(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"status": "ok", "value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"status": "error"}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "b"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Values: map[string]interface{}{"status": "ok"}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "mem", Timestamp: time.Unix(0, 0).UTC(), Values: map[string]interface{}{"status": "ok"}}})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+		err string
+	}{
+		{q: `LIST FIELD VALUES FROM mem`, exp: `[{"name":"mem","columns":["fieldKey","fieldValue","time"],"values":[["status","ok","1970-01-01T00:00:00Z"]]}]`},
+		{q: `LIST FIELD VALUES FROM cpu WITH KEY = status`, exp: `[{"name":"cpu","columns":["fieldKey","fieldValue","time"],"values":[["status","ok","2000-01-01T00:00:20Z"],["status","error","2000-01-01T00:00:10Z"]]}]`},
+		{q: `LIST FIELD VALUES FROM cpu WHERE host = 'a'`, exp: `[{"name":"cpu","columns":["fieldKey","fieldValue","time"],"values":[["status","error","2000-01-01T00:00:10Z"],["status","ok","2000-01-01T00:00:00Z"],["value",10,"2000-01-01T00:00:00Z"]]}]`},
+		{q: `LIST FIELD VALUES FROM cpu WITH KEY = status WHERE time < '2000-01-01 00:00:15' LIMIT 1`, exp: `[{"name":"cpu","columns":["fieldKey","fieldValue","time"],"values":[["status","error","2000-01-01T00:00:10Z"]]}]`},
+		{q: `LIST FIELD VALUES FROM cpu WITH KEY = no_such_field`, err: `field not found`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results[0]; tt.err != "" {
+			if res.Err == nil || res.Err.Error() != tt.err {
+				t.Errorf("%d. %s: unexpected error: %v", i, tt.q, res.Err)
+			}
+		} else if res.Err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, tt.q, res.Err)
+		} else if s := mustMarshalJSON(res.Rows); s != tt.exp {
+			t.Errorf("%d. %s: unexpected rows: %s", i, tt.q, s)
+		}
+	}
+}
+
+// Ensure the server can list the first and last timestamps of each series.
+func TestServer_ListSeriesTimes(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "b"}, Timestamp: mustParseTime("2000-01-01T00:00:05Z"), Values: map[string]interface{}{"value": float64(40)}}})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `LIST SERIES TIMES`, exp: `[{"name":"cpu","columns":["id","host","first","last"],"values":[[1,"a","2000-01-01T00:00:00Z","2000-01-01T00:00:20Z"],[2,"b","2000-01-01T00:00:05Z","2000-01-01T00:00:05Z"]]}]`},
+		{q: `LIST SERIES TIMES FROM cpu WHERE host = 'a' AND time > '2000-01-01 00:00:05' AND time < '2000-01-01 00:00:15'`, exp: `[{"name":"cpu","columns":["id","host","first","last"],"values":[[1,"a","2000-01-01T00:00:10Z","2000-01-01T00:00:10Z"]]}]`},
+		{q: `LIST SERIES TIMES WHERE time > '2000-01-01 00:00:15'`, exp: `[{"name":"cpu","columns":["id","host","first","last"],"values":[[1,"a","2000-01-01T00:00:20Z","2000-01-01T00:00:20Z"],[2,"b",null,null]]}]`},
+		{q: `LIST SERIES TIMES LIMIT 1`, exp: `[{"name":"cpu","columns":["id","host","first","last"],"values":[[1,"a","2000-01-01T00:00:00Z","2000-01-01T00:00:20Z"]]}]`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results[0]; res.Err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, tt.q, res.Err)
+		} else if s := mustMarshalJSON(res.Rows); s != tt.exp {
+			t.Errorf("%d. %s: unexpected rows: %s", i, tt.q, s)
+		}
+	}
+}
+
+// Ensure a select statement reads shards stored on other data nodes.
+func TestServer_SelectStatement_RemoteShards(t *testing.T) {
+	// Open two servers that both consider themselves data node 1. The second
+	// server publishes an extra message first so its shard group assigns each
+	// shard to the other data node.
+	s1, s2 := OpenServer(NewMessagingClient()), OpenServer(NewMessagingClient())
+	defer s1.Close()
+	defer s2.Close()
+	s2.CreateDatabase("bar")
+	hs := httptest.NewServer(influxdb.NewHandler(s2.Server))
+	defer hs.Close()
+	u, _ := url.Parse(hs.URL)
+	for _, s := range []*Server{s1, s2} {
+		s.CreateDataNode(u)
+		s.CreateDatabase("foo")
+		s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 1})
+		s.SetDefaultRetentionPolicy("foo", "raw")
+
+		// The test broker sends every write to both servers so the writes to
+		// shards stored on the other server are rejected.
+		for _, p := range []influxdb.Point{
+			{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+			{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}},
+		} {
+			if index, err := s.WriteSeries("foo", "raw", []influxdb.Point{p}); err != nil {
+				t.Fatal(err)
+			} else if err := s.Sync(index); err != nil && err != influxdb.ErrShardNotLocal {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Each server should only store one of the series.
+	if a := s1.ShardStats(); len(a) != 1 || a[0].PointsWritten != 1 {
+		t.Fatalf("unexpected shard stats: %#v", a)
+	}
+
+	// The first server should read the other series from the second server.
+	results := s1.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY host`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu","tags":{"host":"serverA"},"columns":["time","sum"],"values":[[0,10]]},{"name":"cpu","tags":{"host":"serverB"},"columns":["time","sum"],"values":[[0,20]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+
+	// Field values are read from the other server too.
+	results = s1.ExecuteQuery(MustParseQuery(`LIST FIELD VALUES FROM cpu`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	} else if s := mustMarshalJSON(results[0].Rows); s != `[{"name":"cpu","columns":["fieldKey","fieldValue","time"],"values":[["value",20,"2000-01-01T00:00:10Z"],["value",10,"2000-01-01T00:00:00Z"]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+
+	// Queries fail rather than returning partial results if a data node is unreachable.
+	hs.Close()
+	results = s1.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if err := results.Error(); err == nil || !strings.Contains(err.Error(), "remote shard") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a select statement reads the series in a remote shard in one request.
+func TestServer_SelectStatement_RemoteShards_Batch(t *testing.T) {
+	s1, s2 := OpenServer(NewMessagingClient()), OpenServer(NewMessagingClient())
+	defer s1.Close()
+	defer s2.Close()
+	s2.CreateDatabase("bar")
+
+	// Count the requests for series made to the second server.
+	var n int32
+	h := influxdb.NewHandler(s2.Server)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/series") {
+			atomic.AddInt32(&n, 1)
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer hs.Close()
+	u, _ := url.Parse(hs.URL)
+
+	// Series with even ids are stored in the shard on the second server.
+	for _, s := range []*Server{s1, s2} {
+		s.CreateDataNode(u)
+		s.CreateDatabase("foo")
+		s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 1})
+		s.SetDefaultRetentionPolicy("foo", "raw")
+		for i, host := range []string{"a", "b", "c", "d"} {
+			p := influxdb.Point{Name: "cpu", Tags: map[string]string{"host": host}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(i + 1)}}
+			if index, err := s.WriteSeries("foo", "raw", []influxdb.Point{p}); err != nil {
+				t.Fatal(err)
+			} else if err := s.Sync(index); err != nil && err != influxdb.ErrShardNotLocal {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	results := s1.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,10]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	} else if n := atomic.LoadInt32(&n); n != 1 {
+		t.Fatalf("unexpected series request count: %d", n)
+	}
+}
+
+// Ensure data nodes read remote shards with the cluster secret when
+// authentication is enabled.
+func TestServer_SelectStatement_RemoteShards_ClusterSecret(t *testing.T) {
+	s1, s2 := OpenServer(NewMessagingClient()), OpenServer(NewMessagingClient())
+	defer s1.Close()
+	defer s2.Close()
+	s2.CreateDatabase("bar")
+	hs := NewAuthenticatedHTTPServer(s2)
+	defer hs.Close()
+	u, _ := url.Parse(hs.URL)
+	for _, s := range []*Server{s1, s2} {
+		s.CreateDataNode(u)
+		s.CreateUser("admin", "admin", true)
+		s.CreateDatabase("foo")
+		s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 1})
+		s.SetDefaultRetentionPolicy("foo", "raw")
+		if index, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}}}); err != nil {
+			t.Fatal(err)
+		} else if err := s.Sync(index); err != nil && err != influxdb.ErrShardNotLocal {
+			t.Fatal(err)
+		}
+	}
+
+	// Requests without the secret are rejected.
+	q := MustParseQuery(`SELECT sum(value) FROM cpu`)
+	if err := s1.ExecuteQuery(q, "foo", nil).Error(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Requests with a different secret are rejected.
+	s1.SetClusterSecret("foo")
+	s2.SetClusterSecret("bar")
+	if err := s1.ExecuteQuery(q, "foo", nil).Error(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Requests with the secret are accepted.
+	s2.SetClusterSecret("foo")
+	results := s1.ExecuteQuery(q, "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,20]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
+
+// Ensure the server can stream query results row by row.
+func TestServer_ExecuteQueryStream(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Write series with one point to the database.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	// Stream results from a select, a failing statement, and a trailing statement.
+	var a []*influxdb.ResultRow
+	for r := range s.ExecuteQueryStream(context.Background(), MustParseQuery(`SELECT sum(value) FROM cpu; DROP DATABASE no_db; LIST DATABASES`), "foo", nil) {
+		a = append(a, r)
+	}
+
+	if len(a) != 3 {
+		t.Fatalf("unexpected row count: %d", len(a))
+	} else if a[0].StatementID != 0 || a[0].Err != nil || mustMarshalJSON(a[0].Row) != `{"name":"cpu","columns":["time","sum"],"values":[[0,120]]}` {
+		t.Fatalf("unexpected row(0): %#v", a[0])
+	} else if a[1].StatementID != 1 || a[1].Err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected row(1): %#v", a[1])
+	} else if a[2].StatementID != 2 || a[2].Err != influxdb.ErrNotExecuted {
+		t.Fatalf("unexpected row(2): %#v", a[2])
+	}
+}
+
+// Ensure a streamed query stops when its context is cancelled.
+func TestServer_ExecuteQueryStream_Cancel(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	// Read one row and stop.
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := s.ExecuteQueryStream(ctx, MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY region; LIST DATABASES`), "foo", nil)
+	if r := <-ch; r == nil || r.Err != nil {
+		t.Fatalf("unexpected row: %#v", r)
+	}
+	cancel()
+
+	// The query is unregistered and the channel is closed without reading
+	// the remaining rows.
+	for i := 0; len(s.Queries()) != 0; i++ {
+		if i == 500 {
+			t.Fatalf("unexpected running queries: %s", mustMarshalJSON(s.Queries()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case r, ok := <-ch:
+		if ok {
+			t.Fatalf("unexpected row: %#v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not closed")
+	}
+}
+
+// Ensure streamed and materialized queries return the same results.
+func TestServer_ExecuteQueryStream_MatchesExecuteQuery(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+
+	q := MustParseQuery(`SELECT sum(value) FROM cpu; LIST MEASUREMENTS; LIST TAG KEYS FROM cpu; LIST DATABASES`)
+
+	// Group streamed rows back into one result per statement.
+	streamed := make(influxdb.Results, len(q.Statements))
+	for i := range streamed {
+		streamed[i] = &influxdb.Result{}
+	}
+	for r := range s.ExecuteQueryStream(context.Background(), q, "foo", nil) {
+		if r.Err != nil {
+			streamed[r.StatementID].Err = r.Err
+		} else {
+			streamed[r.StatementID].Rows = append(streamed[r.StatementID].Rows, r.Row)
+		}
+	}
+
+	results := s.ExecuteQuery(q, "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if exp, act := mustMarshalJSON(results), mustMarshalJSON(streamed); exp != act {
+		t.Fatalf("unexpected streamed results:\n\nexp=%s\n\ngot=%s", exp, act)
+	}
+}
+
+// Ensure the server rejects writes that conflict with a field's type and lists field types.
+func TestServer_FieldTypeConflict(t *testing.T) {
+	clock := influxdb.NewMockClock(mustParseTime("2000-01-01T00:00:00Z"))
+	s := NewServer()
+	if err := s.SetClock(clock); err != nil {
+		t.Fatal(err)
+	} else if err := s.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Create the field as a number.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+
+	// Writing a string to the same field should return the conflicting types.
+	_, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": "high"}}})
+	if err, ok := err.(*influxdb.FieldTypeConflictError); !ok {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err.Measurement != "cpu" || err.Field.Name != "value" || err.Field.Type != influxql.Number || err.Type != influxql.String {
+		t.Fatalf("unexpected conflict: %#v", err)
+	}
+
+	// List the field keys along with their types.
+	results := s.ExecuteQuery(MustParseQuery(`LIST FIELD KEYS FROM cpu`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Rows) != 1 || len(res.Rows[0].Values) != 1 {
+		t.Fatalf("unexpected rows: %s", mustMarshalJSON(res.Rows))
+	} else if v := res.Rows[0].Values[0]; v[0] != "value" || v[1] != "number" || !v[2].(time.Time).Equal(clock.Now()) {
+		t.Fatalf("unexpected field: %#v", v)
+	}
+}
+
+// Ensure the server can write and read back integer, boolean, and string fields.
+func TestServer_WriteSeries_FieldTypes(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Write a point that creates each field type, then one through "raw series".
+	values := map[string]interface{}{"count": int64(40), "bytes": int64(1 << 60), "up": true, "status": "ok", "load": float64(0.5)}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "host", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: values}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "host", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"count": 2, "up": false, "status": ""}}})
+
+	// Verify the values are read back with their original types.
+	if v, err := s.ReadSeries("foo", "raw", "host", nil, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, values) {
+		t.Fatalf("values mismatch: %#v", v)
+	}
+	if v, err := s.ReadSeries("foo", "raw", "host", nil, mustParseTime("2000-01-01T00:00:10Z")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"count": int64(2), "up": false, "status": ""}) {
+		t.Fatalf("values mismatch: %#v", v)
+	}
+
+	// Writing a float to an integer field should conflict.
+	_, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "host", Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Values: map[string]interface{}{"count": float64(1.5)}}})
+	if err, ok := err.(*influxdb.FieldTypeConflictError); !ok {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err.Field.Type != influxql.Integer || err.Type != influxql.Number {
+		t.Fatalf("unexpected conflict: %#v", err)
+	}
+
+	// Integer fields can be summed but string fields can only be counted.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(count) FROM host`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"host","columns":["time","sum"],"values":[[0,42]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(status) FROM host`), "foo", nil)
+	if res := results[0]; res.Err == nil || res.Err.Error() != "sum() cannot be applied to string field: host.status" {
+		t.Fatalf("unexpected error: %v", res.Err)
+	}
+}
+
+func TestServer_CreateShardGroupIfNotExist(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.CreateShardGroupIfNotExists("foo", "bar", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if a, err := s.ShardGroups("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 {
+		t.Fatalf("expected 1 shard group but found %d", len(a))
+	}
+}
+
+// Ensure shard groups are split and hashed by their retention policy.
+func TestServer_CreateShardGroupIfNotExist_SplitN(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDataNode(&url.URL{Host: "myserver:8086"})
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 2, SplitN: 3, ShardHash: influxdb.FNVShardHash})
+	s.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T00:00:00Z"))
+
+	// Each of the split shards should be replicated to both data nodes.
+	a, err := s.ShardGroups("foo")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 || len(a[0].Shards) != 3 || a[0].Hash != influxdb.FNVShardHash {
+		t.Fatalf("unexpected shard groups: %#v", a)
+	}
+	for i, sh := range a[0].Shards {
+		if len(sh.DataNodeIDs) != 2 {
+			t.Fatalf("unexpected data nodes(%d): %v", i, sh.DataNodeIDs)
+		}
+	}
+
+	// Series should be spread over every shard.
+	counts := make(map[uint64]int)
+	for id := uint32(1); id <= 300; id++ {
+		counts[a[0].ShardBySeriesID(id).ID]++
+	}
+	if len(counts) != 3 {
+		t.Fatalf("unexpected series distribution: %v", counts)
+	}
+
+	// Changing the split factor only applies to new shard groups.
+	if err := s.UpdateRetentionPolicy("foo", "raw", &influxdb.RetentionPolicy{SplitN: 1}); err != nil {
+		t.Fatal(err)
+	}
+	s.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T02:00:00Z"))
+	if a, err := s.ShardGroups("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 2 || len(a[0].Shards) != 3 || len(a[1].Shards) != 1 {
+		t.Fatalf("unexpected shard groups: %#v", a)
+	}
+}
+
+// Ensure the server can report the shards owned by each data node.
+func TestServer_ShardOwnership(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDataNode(&url.URL{Host: "myserver:8086"})
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 1})
+	s.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T00:00:00Z"))
+
+	// Each data node should own one of the group's two shards.
+	a := s.ShardOwnership()
+	if len(a) != 2 {
+		t.Fatalf("unexpected data node count: %d", len(a))
+	}
+	for i, o := range a {
+		if o.DataNodeID != uint64(i+1) || len(o.Shards) != 1 {
+			t.Fatalf("unexpected data node(%d): %#v", i, o)
+		} else if sh := o.Shards[0]; sh.Database != "foo" || sh.RetentionPolicy != "raw" || !sh.StartTime.Equal(mustParseTime("2000-01-01T00:00:00Z")) || !sh.EndTime.Equal(mustParseTime("2000-01-01T01:00:00Z")) {
+			t.Fatalf("unexpected shard(%d): %#v", i, sh)
+		}
+	}
+
+	// Only the shard stored on this server has a known size.
+	if size := a[0].Shards[0].Size; size == nil || *size == 0 {
+		t.Fatal("expected local shard size")
+	} else if size := a[1].Shards[0].Size; size != nil {
+		t.Fatalf("unexpected remote shard size: %d", *size)
+	}
+
+	// Verify the shards can be listed through a query.
+	results := s.ExecuteQuery(MustParseQuery(`LIST SHARDS`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Rows) != 2 || res.Rows[1].Tags["url"] != "//myserver:8086" || len(res.Rows[1].Values) != 1 {
+		t.Fatalf("unexpected rows: %s", mustMarshalJSON(res.Rows))
+	} else if res.Rows[0].Values[0][5] == nil || res.Rows[1].Values[0][5] != nil {
+		t.Fatalf("unexpected sizes: %s", mustMarshalJSON(res.Rows))
+	}
+}
+
+// Ensure each continuous query can only be leased by one data node at a time.
+func TestServer_AcquireContinuousQueryLease(t *testing.T) {
+	clock := influxdb.NewMockClock(mustParseTime("2000-01-01T00:00:00Z"))
+	c := NewMessagingClient()
+	s := NewServer()
+	if err := s.SetClock(clock); err != nil {
+		t.Fatal(err)
+	} else if err := s.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(c); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.CreateDataNode(&url.URL{Host: "myserver:8086"})
+
+	// Simulates a lease request from another data node whose clock is far
+	// ahead. The request names the renewal it expects to take over from.
+	acquireAs := func(id uint64, name string, d time.Duration, renewal uint64) error {
+		return s.Sync(mustPublish(c, 0x60, fmt.Sprintf(`{"name":%q,"dataNodeID":%d,"timestamp":"2100-01-01T00:00:00Z","duration":%d,"renewal":%d}`, name, id, d, renewal)))
+	}
+	lease := func(name string) *influxdb.ContinuousQueryLease {
+		for _, l := range s.ContinuousQueryLeases() {
+			if l.Name == name {
+				return l
+			}
+		}
+		return nil
+	}
+
+	// The first node to acquire the lease holds it until it expires.
+	if l, err := s.AcquireContinuousQueryLease("cq0", time.Hour); err != nil {
+		t.Fatal(err)
+	} else if l.Name != "cq0" || l.DataNodeID != 1 || l.Index == 0 {
+		t.Fatalf("unexpected lease: %#v", l)
+	}
+	index := lease("cq0").Index
+
+	// The holder can renew its lease, which refuses takeovers of the
+	// previous renewal regardless of the requester's clock.
+	if _, err := s.AcquireContinuousQueryLease("cq0", time.Hour); err != nil {
+		t.Fatal(err)
+	} else if err := acquireAs(2, "cq0", time.Hour, index); err != influxdb.ErrContinuousQueryLeaseHeld {
+		t.Fatalf("unexpected error: %v", err)
+	} else if l := lease("cq0"); l.DataNodeID != 1 || l.Index == index {
+		t.Fatalf("unexpected lease: %#v", l)
+	}
+
+	// Another node can take over the latest renewal once it has expired.
+	if err := acquireAs(2, "cq0", time.Hour, lease("cq0").Index); err != nil {
+		t.Fatal(err)
+	} else if l := lease("cq0"); l.DataNodeID != 2 {
+		t.Fatalf("unexpected lease: %#v", l)
+	}
+
+	// The lease expires once its duration passes on this node's clock.
+	clock.Add(59 * time.Minute)
+	if _, err := s.AcquireContinuousQueryLease("cq0", time.Hour); err != influxdb.ErrContinuousQueryLeaseHeld {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clock.Add(time.Minute)
+	if _, err := s.AcquireContinuousQueryLease("cq0", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting a data node releases its leases.
+	if err := acquireAs(2, "cq1", time.Hour, 0); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.AcquireContinuousQueryLease("cq1", time.Hour); err != influxdb.ErrContinuousQueryLeaseHeld {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.DeleteDataNode(2); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.AcquireContinuousQueryLease("cq1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	// Verify leases are persisted.
+	if a := s.ContinuousQueryLeases(); len(a) != 2 {
+		t.Fatalf("unexpected lease count: %d", len(a))
+	} else if a[0].Name != "cq0" || a[1].Name != "cq1" || a[0].DataNodeID != 1 || a[1].DataNodeID != 1 || a[0].Duration != time.Hour {
+		t.Fatalf("unexpected leases: %s", mustMarshalJSON(a))
+	}
+}
+
+// Ensure the server can create, run, list and drop continuous queries.
+func TestServer_ContinuousQueries(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:01:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	// Create a continuous query.
+	q := `CREATE CONTINUOUS QUERY cq0 ON foo BEGIN SELECT sum(value) INTO cpu_sum FROM cpu GROUP BY time(1m) END`
+	if err := s.ExecuteQuery(MustParseQuery(q), "foo", nil).Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Duplicate queries and queries without an interval are rejected.
+	if err := s.ExecuteQuery(MustParseQuery(q), "foo", nil).Error(); err != influxdb.ErrContinuousQueryExists {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.ExecuteQuery(MustParseQuery(`CREATE CONTINUOUS QUERY cq1 ON foo BEGIN SELECT sum(value) INTO cpu_sum FROM cpu END`), "foo", nil).Error(); err != influxdb.ErrContinuousQueryIntervalRequired {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Run the query after the first interval completes.
+	// Only points within the completed interval are aggregated.
+	if err := s.RunContinuousQueries(mustParseTime("2000-01-01T00:01:30Z")); err != nil {
+		t.Fatal(err)
+	}
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(sum) FROM cpu_sum`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu_sum","columns":["time","sum"],"values":[[0,50]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+	if l := s.ContinuousQueryLeases(); len(l) != 1 || l[0].Name != "cq0" {
+		t.Fatalf("unexpected leases: %#v", l)
+	}
+
+	s.Restart()
+
+	// List the query after restart.
+	results = s.ExecuteQuery(MustParseQuery(`LIST CONTINUOUS QUERIES`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"foo","columns":["name","query"],"values":[["cq0","CREATE CONTINUOUS QUERY cq0 ON foo BEGIN SELECT sum(value) INTO cpu_sum FROM cpu GROUP BY time(1m) END"]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Drop the query.
+	if err := s.ExecuteQuery(MustParseQuery(`DROP CONTINUOUS QUERY cq0`), "foo", nil).Error(); err != nil {
+		t.Fatal(err)
+	} else if a := s.ContinuousQueries(); len(a) != 0 {
+		t.Fatalf("unexpected queries: %#v", a)
+	} else if err := s.DeleteContinuousQuery("cq0"); err != influxdb.ErrContinuousQueryNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server can back up its metastore and shards and restore them to a new data directory.
+func TestServer_BackupRestore(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", true)
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+
+	// Write a snapshot of the server.
+	var buf bytes.Buffer
+	if err := s.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restore the snapshot to a new directory.
+	path := tempfile()
+	defer os.RemoveAll(path)
+	if err := influxdb.Restore(bytes.NewReader(buf.Bytes()), path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure the shard file was restored.
+	if a, err := ioutil.ReadDir(filepath.Join(path, "shards")); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 {
+		t.Fatalf("unexpected shard count: %d", len(a))
+	}
+
+	// Open a new server on the restored directory and verify the metadata.
+	other := NewServer()
+	if err := other.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if a := other.Databases(); !reflect.DeepEqual(a, []string{"foo"}) {
+		t.Fatalf("unexpected databases: %v", a)
+	} else if u := other.User("susy"); u == nil || !u.Admin {
+		t.Fatalf("unexpected user: %#v", u)
+	} else if a := other.MeasurementNames("foo"); !reflect.DeepEqual(a, []string{"cpu"}) {
+		t.Fatalf("unexpected measurements: %v", a)
+	}
+
+	// Ensure a second restore to the same directory is rejected.
+	if err := influxdb.Restore(bytes.NewReader(buf.Bytes()), path); err != influxdb.ErrRestorePathExists {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a shard can be repaired with the points missing from another replica.
+func TestServer_RepairShard(t *testing.T) {
+	// Open two servers with the same metadata and a shard owned by both.
+	s1, s2 := OpenServer(NewMessagingClient()), OpenServer(NewMessagingClient())
+	defer s1.Close()
+	defer s2.Close()
+	hs := httptest.NewServer(influxdb.NewHandler(s2.Server))
+	defer hs.Close()
+	u, _ := url.Parse(hs.URL)
+	for _, s := range []*Server{s1, s2} {
+		s.CreateDataNode(u)
+		s.CreateDatabase("foo")
+		s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 2})
+		s.SetDefaultRetentionPolicy("foo", "raw")
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	}
+
+	// Write a point that only the second replica receives.
+	s2.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}}})
+
+	shardID := s1.ShardStats()[0].ShardID
+	if a, _ := s1.ShardDigest(shardID); len(a) != 1 || a[0].Count != 1 {
+		t.Fatalf("unexpected digest: %#v", a)
+	}
+
+	// Repair the first replica.
+	r, err := s1.RepairShard(shardID)
+	if err != nil {
+		t.Fatal(err)
+	} else if *r != (influxdb.ShardRepair{ShardID: shardID, SeriesRepaired: 1, PointsCopied: 1}) {
+		t.Fatalf("unexpected repair: %#v", r)
+	}
+
+	// Both replicas should now have the same data.
+	a1, _ := s1.ShardDigest(shardID)
+	a2, _ := s2.ShardDigest(shardID)
+	if !reflect.DeepEqual(a1, a2) {
+		t.Fatalf("digest mismatch: %#v != %#v", a1, a2)
+	}
+
+	// A second repair should find nothing to copy.
+	if r, err := s1.RepairShard(shardID); err != nil {
+		t.Fatal(err)
+	} else if r.PointsCopied != 0 {
+		t.Fatalf("unexpected repair: %#v", r)
+	}
+}
+
+// Ensure a write waits for the replicas required by its consistency level.
+func TestServer_WriteSeriesWithConsistency(t *testing.T) {
+	// Open two servers with the same metadata and a shard owned by both.
+	s1, s2 := OpenServer(NewMessagingClient()), OpenServer(NewMessagingClient())
+	defer s1.Close()
+	defer s2.Close()
+	hs := httptest.NewServer(influxdb.NewHandler(s2.Server))
+	defer hs.Close()
+	u, _ := url.Parse(hs.URL)
+	for _, s := range []*Server{s1, s2} {
+		s.CreateDataNode(u)
+		s.CreateDatabase("foo")
+		s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 2})
+		s.SetDefaultRetentionPolicy("foo", "raw")
+	}
+
+	// The test brokers aren't shared so the second server writes the same
+	// point itself before the first server waits for it to be applied.
+	p := influxdb.Point{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}
+	s2.MustWriteSeries("foo", "raw", []influxdb.Point{p})
+	if _, err := s1.WriteSeriesWithConsistency("foo", "raw", influxdb.ConsistencyLevelAll, []influxdb.Point{p}); err != nil {
+		t.Fatal(err)
+	} else if a := s1.ShardStats(); len(a) != 1 || a[0].PointsWritten != 1 {
+		t.Fatalf("unexpected shard stats: %#v", a)
+	}
+
+	// Levels requiring the unreachable replica fail once it is down.
+	hs.Close()
+	for _, tt := range []struct {
+		level influxdb.ConsistencyLevel
+		acks  int
+		req   int
+	}{
+		{level: influxdb.ConsistencyLevelAny},
+		{level: influxdb.ConsistencyLevelOne},
+		{level: influxdb.ConsistencyLevelQuorum, acks: 1, req: 2},
+		{level: influxdb.ConsistencyLevelAll, acks: 1, req: 2},
+	} {
+		_, err := s1.WriteSeriesWithConsistency("foo", "raw", tt.level, []influxdb.Point{p})
+		if tt.req == 0 {
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", tt.level, err)
+			}
+			continue
+		}
+		if e, ok := err.(*influxdb.ConsistencyError); !ok || e.Acks != tt.acks || e.Required != tt.req || e.Err == nil {
+			t.Fatalf("%s: unexpected error: %#v", tt.level, err)
+		}
+	}
+}
+
+// Ensure queued writes are kept while the broker is unavailable and are
+// written once the queue is started again.
+func TestServer_EnqueueSeries(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(&DisconnectedMessagingClient{c})
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	points := []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": int64(10)}}}
+	if err := s.EnqueueSeries("foo", "raw", points); err != influxdb.ErrWriteQueueDisabled {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.StartWriteQueue(0, 0); err != influxdb.ErrInvalidWriteQueueInterval {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Queue a write that can't be published.
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) { return 0, fmt.Errorf("broker unavailable") }
+	if err := s.StartWriteQueue(0, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	} else if err := s.EnqueueSeries("foo", "raw", points); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := s.WriteQueueLen(); n != 1 {
+		t.Fatalf("unexpected queue length: %d", n)
+	}
+
+	// Restart the server and queue with the broker available again.
+	s.Restart()
+	c.PublishFunc = c.send
+	if err := s.StartWriteQueue(0, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; s.WriteQueueLen() > 0; i++ {
+		if i == 100 {
+			t.Fatal("write queue not drained")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "mem", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,10]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+}
+
+// Ensure a shard that doesn't exist can't be repaired.
+func TestServer_RepairShard_ErrShardNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if _, err := s.RepairShard(100); err != influxdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure a data node's shards are moved to the remaining data nodes when it is decommissioned.
+func TestServer_DecommissionDataNode(t *testing.T) {
+	// Open two servers that each store one shard of the group, as in the
+	// remote shard test. The first server sees the second as data node 2.
+	s1, s2 := OpenServer(NewMessagingClient()), OpenServer(NewMessagingClient())
+	defer s1.Close()
+	defer s2.Close()
+	s2.CreateDatabase("bar")
+	hs := httptest.NewServer(influxdb.NewHandler(s2.Server))
+	defer hs.Close()
+	u, _ := url.Parse(hs.URL)
+	for _, s := range []*Server{s1, s2} {
+		s.CreateDataNode(u)
+		s.CreateDatabase("foo")
+		s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 1})
+		s.SetDefaultRetentionPolicy("foo", "raw")
+		for _, p := range []influxdb.Point{
+			{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+			{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}},
+		} {
+			if index, err := s.WriteSeries("foo", "raw", []influxdb.Point{p}); err != nil {
+				t.Fatal(err)
+			} else if err := s.Sync(index); err != nil && err != influxdb.ErrShardNotLocal {
+				t.Fatal(err)
+			}
+		}
+	}
+	if a := s1.ShardStats(); len(a) != 1 {
+		t.Fatalf("unexpected shard stats: %#v", a)
+	}
+
+	// Decommission the second data node from the first server.
+	a, err := s1.DecommissionDataNode(2)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 || a[0].From != 2 || a[0].To != 1 {
+		t.Fatalf("unexpected moves: %#v", a)
+	} else if s1.DataNode(2) != nil {
+		t.Fatal("expected data node to be deleted")
+	} else if sh := s1.Shard(a[0].ShardID); !reflect.DeepEqual(sh.DataNodeIDs, []uint64{1}) {
+		t.Fatalf("unexpected owners: %v", sh.DataNodeIDs)
+	}
+
+	// The first server should answer the query without the second server.
+	hs.Close()
+	results := s1.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY host`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	} else if s := mustMarshalJSON(results); s != `[{"rows":[{"name":"cpu","tags":{"host":"serverA"},"columns":["time","sum"],"values":[[0,10]]},{"name":"cpu","tags":{"host":"serverB"},"columns":["time","sum"],"values":[[0,20]]}]}]` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+}
+
+// Ensure the last data node storing a shard can't be decommissioned.
+func TestServer_DecommissionDataNode_ErrNoReplacementDataNode(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T00:00:00Z"))
+	if _, err := s.DecommissionDataNode(1); err != influxdb.ErrNoReplacementDataNode {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s.DataNode(1) == nil {
+		t.Fatal("expected data node to exist")
+	}
+}
+
+// Ensure newer messages aren't broadcast until every data node can apply them.
+func TestServer_MessageFormat(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	if f := s.ClusterMessageFormat(); f != influxdb.MessageFormat {
+		t.Fatalf("unexpected format: %d", f)
+	} else if n := s.DataNode(1); n.MessageFormat != influxdb.MessageFormat {
+		t.Fatalf("unexpected data node format: %d", n.MessageFormat)
+	}
+
+	// A data node that joined without reporting a format only applies format 1.
+	if err := s.Sync(mustPublish(c, 0x00, `{"url":"http://localhost:1000"}`)); err != nil {
+		t.Fatal(err)
+	} else if f := s.ClusterMessageFormat(); f != 1 {
+		t.Fatalf("unexpected format: %d", f)
+	} else if err := s.CreateUser("susy", "pass", false); err != nil {
+		t.Fatal(err)
+	} else if err := s.GrantPrivilege("susy", "", influxql.AllPrivileges); err != influxdb.ErrMessageFormatUnsupported {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Fields added to existing messages are only used once supported.
+	duration := 2 * time.Hour
+	s.CreateDatabase("foo")
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", ShardHash: influxdb.FNVShardHash}); err != influxdb.ErrMessageFormatUnsupported {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", ReplicaN: 2}); err != nil {
+		t.Fatal(err)
+	}
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	if err := s.AlterRetentionPolicy("foo", "raw", &influxdb.RetentionPolicyUpdate{Duration: &duration}); err != influxdb.ErrMessageFormatUnsupported {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": "a"}}}); err != influxdb.ErrMessageFormatUnsupported {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}, TTL: time.Hour}}); err != influxdb.ErrMessageFormatUnsupported {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,30]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Newer messages are used once the data node announces its upgrade.
+	if err := s.Sync(mustPublish(c, 0x03, `{"id":2,"version":"0.9","messageFormat":2}`)); err != nil {
+		t.Fatal(err)
+	} else if err := s.GrantPrivilege("susy", "", influxql.AllPrivileges); err != nil {
+		t.Fatal(err)
+	} else if err := s.AlterRetentionPolicy("foo", "raw", &influxdb.RetentionPolicyUpdate{Duration: &duration}); err != influxdb.ErrMessageFormatUnsupported {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.Sync(mustPublish(c, 0x03, `{"id":2,"version":"1.0","messageFormat":3}`)); err != nil {
+		t.Fatal(err)
+	} else if err := s.AlterRetentionPolicy("foo", "raw", &influxdb.RetentionPolicyUpdate{Duration: &duration}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Data nodes announce a new version.
+	s.SetVersion("1.0")
+	if err := s.AnnounceDataNode(); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+	if n := s.DataNode(1); n.Version != "1.0" || n.MessageFormat != influxdb.MessageFormat {
+		t.Fatalf("unexpected data node: %#v", n)
+	}
+}
+
+// Ensure a data node stops applying messages at a message it can't apply.
+func TestServer_UpgradeRequired(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+
+	// Waiting for the unknown message reports that an upgrade is required.
+	if err := s.Sync(mustPublish(c, 0x7F, `{}`)); err != influxdb.ErrUpgradeRequired {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !s.UpgradeRequired() {
+		t.Fatal("expected upgrade required")
+	}
+
+	// Later messages wait for the upgrade too.
+	if err := s.CreateDatabase("foo"); err != influxdb.ErrUpgradeRequired {
+		t.Fatalf("unexpected error: %v", err)
+	} else if s.DatabaseExists("foo") {
+		t.Fatal("unexpected database")
+	}
+}
+
+// mustPublish publishes a broadcast message with a raw type and data.
+func mustPublish(c *MessagingClient, typ messaging.MessageType, data string) uint64 {
+	index, err := c.Publish(&messaging.Message{Type: typ, TopicID: messaging.BroadcastTopicID, Data: []byte(data)})
+	if err != nil {
+		panic(err.Error())
+	}
+	return index
+}
+
+// Ensure a standby stores every shard without owning any and can take over
+// the shards of another data node.
+func TestServer_PromoteDataNode(t *testing.T) {
+	// Join the second server to the first as a standby.
+	s1 := OpenServer(NewMessagingClient())
+	defer s1.Close()
+	hs := httptest.NewServer(influxdb.NewHandler(s1.Server))
+	defer hs.Close()
+	u1, _ := url.Parse(hs.URL)
+	u2, _ := url.Parse("http://127.0.0.1:8087")
+	c2 := NewMessagingClient()
+	s2 := OpenUninitializedServer(c2)
+	defer s2.Close()
+	if err := s2.JoinStandby(u2, u1); err != nil {
+		t.Fatal(err)
+	} else if s2.ID() != 2 {
+		t.Fatalf("unexpected id: %d", s2.ID())
+	}
+	c2.index = s2.MetastoreIndex()
+
+	for _, s := range []*Server{s1, s2} {
+		s.CreateDatabase("foo")
+		s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 2})
+		s.SetDefaultRetentionPolicy("foo", "raw")
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	}
+
+	// The standby isn't assigned the shard and doesn't serve queries.
+	g, err := s2.ShardGroups("foo")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(g) != 1 || !reflect.DeepEqual(g[0].Shards[0].DataNodeIDs, []uint64{1}) {
+		t.Fatalf("unexpected shard groups: %#v", g)
+	} else if !s2.Standby() || s1.Standby() {
+		t.Fatal("unexpected standby state")
+	}
+	if err := s2.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil).Error(); err != influxdb.ErrDataNodeStandby {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Standbys can't be made owners without promotion.
+	if _, err := s2.SetShardOwners(g[0].Shards[0].ID, []uint64{2}); err != influxdb.ErrDataNodeStandby {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Promote the standby in place of the first data node.
+	for _, s := range []*Server{s1, s2} {
+		if index, err := s.PromoteDataNode(2, 1); err != nil {
+			t.Fatal(err)
+		} else if err := s.Sync(index); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s2.PromoteDataNode(2, 1); err != influxdb.ErrDataNodeNotStandby {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The promoted node serves the data it already applied.
+	results := s2.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	} else if s := mustMarshalJSON(results[0]); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,10]]}]}` {
+		t.Fatalf("unexpected results: %s", s)
+	} else if sh := s2.Shard(g[0].Shards[0].ID); !reflect.DeepEqual(sh.DataNodeIDs, []uint64{2}) {
+		t.Fatalf("unexpected owners: %v", sh.DataNodeIDs)
+	}
+
+	// The replaced node no longer stores the shard.
+	if a := s1.ShardStats(); len(a) != 0 {
+		t.Fatalf("unexpected shard stats: %#v", a)
+	}
+}
+
+// Ensure the server can snapshot the metastore and rotate old snapshots.
+func TestServer_BackupMetastore(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	dir := tempfile()
+	defer os.RemoveAll(dir)
+
+	// Take more snapshots than are kept.
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := s.BackupMetastore(dir, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	// Ensure only the most recent snapshots remain.
+	if a, err := filepath.Glob(filepath.Join(dir, "*")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(a, paths[1:]) {
+		t.Fatalf("unexpected snapshots: %v", a)
+	}
+
+	// Ensure a snapshot can be used as the metastore of a new server.
+	path := tempfile()
+	defer os.RemoveAll(path)
+	if b, err := ioutil.ReadFile(paths[2]); err != nil {
+		t.Fatal(err)
+	} else if err := os.MkdirAll(path, 0700); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(filepath.Join(path, "meta"), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	other := NewServer()
+	if err := other.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if a := other.Databases(); !reflect.DeepEqual(a, []string{"foo"}) {
+		t.Fatalf("unexpected databases: %v", a)
+	}
+}
+
+// Ensure the server can report which shard groups have passed their retention duration.
+func TestServer_ExpiredShardGroups(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 1})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "forever", ReplicaN: 1})
+	s.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T00:00:00Z"))
+	s.CreateShardGroupIfNotExists("foo", "raw", time.Now().UTC())
+	s.CreateShardGroupIfNotExists("foo", "forever", mustParseTime("2000-01-01T00:00:00Z"))
+
+	// Only the old group in the policy with a duration should be expired.
+	a := s.ExpiredShardGroups(time.Now().UTC())
+	if len(a) != 1 {
+		t.Fatalf("unexpected group count: %d", len(a))
+	} else if g := a[0]; g.Database != "foo" || g.RetentionPolicy != "raw" || !g.StartTime.Equal(mustParseTime("2000-01-01T00:00:00Z")) || g.ShardN != 1 || g.Size == 0 {
+		t.Fatalf("unexpected group: %#v", g)
+	}
+
+	// Verify the groups can be listed through a query.
+	results := s.ExecuteQuery(MustParseQuery(`LIST EXPIRED SHARDS`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Rows) != 1 || res.Rows[0].Tags["retentionPolicy"] != "raw" || len(res.Rows[0].Values) != 1 {
+		t.Fatalf("unexpected rows: %s", mustMarshalJSON(res.Rows))
+	}
+}
+
+// Ensure retention policies are enforced on the ticks of the server's clock.
+func TestServer_StartRetentionPolicyEnforcement_Clock(t *testing.T) {
+	clock := influxdb.NewMockClock(mustParseTime("2000-01-01T00:00:00Z"))
+	s := NewServer()
+	if err := s.SetClock(clock); err != nil {
+		t.Fatal(err)
+	} else if err := s.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 1})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Write a point without a timestamp so it's written at the clock's time.
+	s.MustWriteSeries("foo", "", []influxdb.Point{{Name: "cpu", Values: map[string]interface{}{"value": float64(1)}}})
+	if a, _ := s.ShardGroups("foo"); len(a) != 1 || !a[0].StartTime.Equal(mustParseTime("2000-01-01T00:00:00Z")) {
+		t.Fatalf("unexpected groups: %s", mustMarshalJSON(a))
+	}
+
+	if err := s.StartRetentionPolicyEnforcement(10 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// The group is retained while it is within the policy's duration. A tick
+	// is only received once the previous tick has been processed so the extra
+	// tick ensures enforcement has run.
+	clock.Add(1 * time.Hour)
+	clock.Add(10 * time.Minute)
+	if a, _ := s.ShardGroups("foo"); len(a) != 1 {
+		t.Fatalf("unexpected groups: %s", mustMarshalJSON(a))
+	}
+
+	// The group is removed once the clock passes its expiration.
+	clock.Add(2 * time.Hour)
+	clock.Add(10 * time.Minute)
+	if a, _ := s.ShardGroups("foo"); len(a) != 0 {
+		t.Fatalf("unexpected groups: %s", mustMarshalJSON(a))
+	}
+}
+
+// Ensure retention policies are only enforced by the data node holding the lease.
+func TestServer_StartRetentionPolicyEnforcement_LeaseHeld(t *testing.T) {
+	clock := influxdb.NewMockClock(mustParseTime("2000-01-01T00:00:00Z"))
+	c := NewMessagingClient()
+	s := NewServer()
+	if err := s.SetClock(clock); err != nil {
+		t.Fatal(err)
+	} else if err := s.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(c); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.CreateDataNode(&url.URL{Host: "myserver:8086"})
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 1})
+	s.CreateShardGroupIfNotExists("foo", "raw", clock.Now())
+
+	// Acquire the lease as another data node.
+	if err := s.Sync(mustPublish(c, 0x60, `{"name":"retention policy enforcement","system":true,"dataNodeID":2,"timestamp":"2000-01-01T00:00:00Z","duration":86400000000000,"renewal":0}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A continuous query with the same name has its own lease.
+	if err := s.ExecuteQuery(MustParseQuery(`CREATE CONTINUOUS QUERY "retention policy enforcement" ON foo BEGIN SELECT count(value) INTO cpu_count FROM cpu GROUP BY time(1m) END`), "foo", nil).Error(); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.AcquireContinuousQueryLease("retention policy enforcement", time.Hour); err != nil {
+		t.Fatal(err)
+	} else if l := s.ContinuousQueryLeases(); len(l) != 1 || l[0].DataNodeID != 1 {
+		t.Fatalf("unexpected leases: %s", mustMarshalJSON(l))
+	}
+
+	if err := s.StartRetentionPolicyEnforcement(10 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
 
-	// Verify that the policy exists.
-	if o, err := s.RetentionPolicy("foo", "bar"); err != nil {
-		t.Fatalf("unexpected error: %s", err)
-	} else if o == nil {
-		t.Fatalf("retention policy not found")
-	} else if !reflect.DeepEqual(rp, o) {
-		t.Fatalf("retention policy mismatch: %#v", o)
+	// The expired group is left for the lease holder to remove.
+	clock.Add(3 * time.Hour)
+	clock.Add(10 * time.Minute)
+	if a, _ := s.ShardGroups("foo"); len(a) != 1 {
+		t.Fatalf("unexpected groups: %s", mustMarshalJSON(a))
 	}
 }
 
-// Ensure the server returns an error when creating a retention policy with an invalid db.
-func TestServer_CreateRetentionPolicy_ErrDatabaseNotFound(t *testing.T) {
+// Ensure the server reports each tag cardinality threshold once per tag key.
+func TestServer_CheckTagCardinality(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
-	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != influxdb.ErrDatabaseNotFound {
-		t.Fatal(err)
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	writeHosts := func(n int) {
+		for i := 0; i < n; i++ {
+			s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": strconv.Itoa(i), "region": "us"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+		}
 	}
-}
 
-// Ensure the server returns an error when creating a retention policy without a name.
-func TestServer_CreateRetentionPolicy_ErrRetentionPolicyNameRequired(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
-	defer s.Close()
-	s.CreateDatabase("foo")
-	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: ""}); err != influxdb.ErrRetentionPolicyNameRequired {
-		t.Fatal(err)
+	writeHosts(3)
+	if a := s.CheckTagCardinality([]int{2, 5}); len(a) != 1 || !reflect.DeepEqual(a[0], &influxdb.CardinalityAlert{Database: "foo", Measurement: "cpu", TagKey: "host", N: 3, Threshold: 2}) {
+		t.Fatalf("unexpected alerts: %s", mustMarshalJSON(a))
+	}
+
+	// The same threshold isn't reported again.
+	if a := s.CheckTagCardinality([]int{2, 5}); len(a) != 0 {
+		t.Fatalf("unexpected alerts: %s", mustMarshalJSON(a))
+	}
+
+	// Passing the next threshold is reported.
+	writeHosts(6)
+	if a := s.CheckTagCardinality([]int{2, 5}); len(a) != 1 || a[0].N != 6 || a[0].Threshold != 5 {
+		t.Fatalf("unexpected alerts: %s", mustMarshalJSON(a))
 	}
 }
 
-// Ensure the server returns an error when creating a duplicate retention policy.
-func TestServer_CreateRetentionPolicy_ErrRetentionPolicyExists(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
+// Ensure the server isn't ready while disconnected from the broker.
+func TestServer_Ready_ErrBrokerDisconnected(t *testing.T) {
+	c := &DisconnectedMessagingClient{NewMessagingClient()}
+	s := OpenServer(c)
 	defer s.Close()
-	s.CreateDatabase("foo")
-	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"})
-	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != influxdb.ErrRetentionPolicyExists {
-		t.Fatal(err)
+
+	if err := s.Ready(); err != influxdb.ErrBrokerDisconnected {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.Live(); err != nil {
+		t.Fatalf("unexpected live error: %v", err)
 	}
 }
 
-// Ensure the server can delete an existing retention policy.
-func TestServer_DeleteRetentionPolicy(t *testing.T) {
+// DisconnectedMessagingClient is a messaging client that reports it isn't
+// connected to a broker.
+type DisconnectedMessagingClient struct {
+	*MessagingClient
+}
+
+// Connected always returns false.
+func (c *DisconnectedMessagingClient) Connected() bool { return false }
+
+// Ensure the server rejects or drops tags with too many distinct values.
+func TestServer_SetMaxValuesPerTag(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
-
-	// Create a database and retention policy.
 	s.CreateDatabase("foo")
-	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != nil {
-		t.Fatal(err)
-	} else if rp, _ := s.RetentionPolicy("foo", "bar"); rp == nil {
-		t.Fatal("retention policy not created")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.SetMaxValuesPerTag(2, false)
+	write := func(host string) error {
+		_, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": host, "region": "us"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+		return err
 	}
 
-	// Remove retention policy from database.
-	if err := s.DeleteRetentionPolicy("foo", "bar"); err != nil {
-		t.Fatal(err)
-	} else if rp, _ := s.RetentionPolicy("foo", "bar"); rp != nil {
-		t.Fatal("retention policy not deleted")
+	// Values up to the limit and existing series can be written.
+	for _, host := range []string{"a", "b", "a"} {
+		if err := write(host); err != nil {
+			t.Fatal(err)
+		}
 	}
-	s.Restart()
 
-	if rp, _ := s.RetentionPolicy("foo", "bar"); rp != nil {
-		t.Fatal("retention policy not deleted after restart")
+	// A new value over the limit is rejected.
+	if err := write("c"); !reflect.DeepEqual(err, &influxdb.MaxValuesPerTagError{Measurement: "cpu", TagKey: "host", Limit: 2}) {
+		t.Fatalf("unexpected error: %v", err)
 	}
-}
 
-// Ensure the server returns an error when deleting a retention policy on invalid db.
-func TestServer_DeleteRetentionPolicy_ErrDatabaseNotFound(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
-	defer s.Close()
-	if err := s.DeleteRetentionPolicy("foo", "bar"); err != influxdb.ErrDatabaseNotFound {
+	// Tags over the limit can be dropped instead.
+	s.SetMaxValuesPerTag(2, true)
+	if err := write("d"); err != nil {
 		t.Fatal(err)
 	}
+	if a := s.CheckTagCardinality([]int{1}); len(a) != 2 {
+		t.Fatalf("unexpected alerts: %s", mustMarshalJSON(a))
+	} else {
+		for _, alert := range a {
+			if (alert.TagKey == "host" && alert.N != 2) || (alert.TagKey == "region" && alert.N != 1) {
+				t.Fatalf("unexpected alert: %s", mustMarshalJSON(alert))
+			}
+		}
+	}
 }
 
-// Ensure the server returns an error when deleting a retention policy without a name.
-func TestServer_DeleteRetentionPolicy_ErrRetentionPolicyNameRequired(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
+// Ensure the server writes tag cardinality alerts to a database on every tick.
+func TestServer_StartCardinalityChecks(t *testing.T) {
+	clock := influxdb.NewMockClock(mustParseTime("2000-01-01T00:00:00Z"))
+	s := NewServer()
+	if err := s.SetClock(clock); err != nil {
+		t.Fatal(err)
+	} else if err := s.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
 	defer s.Close()
 	s.CreateDatabase("foo")
-	if err := s.DeleteRetentionPolicy("foo", ""); err != influxdb.ErrRetentionPolicyNameRequired {
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	for _, host := range []string{"a", "b", "c"} {
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": host}, Values: map[string]interface{}{"value": float64(1)}}})
+	}
+
+	if err := s.StartCardinalityChecks(10*time.Minute, []int{5, 2}, "foo"); err != influxdb.ErrInvalidCardinalityThresholds {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.StartCardinalityChecks(0, []int{2}, "foo"); err != influxdb.ErrInvalidCardinalityCheckInterval {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.StartCardinalityChecks(10*time.Minute, []int{2}, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The extra tick ensures the first check has published its alerts and
+	// the next write waits for them to be applied.
+	clock.Add(10 * time.Minute)
+	clock.Add(10 * time.Minute)
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Values: map[string]interface{}{"value": float64(1)}}})
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(n) FROM tag_cardinality WHERE tag_key = 'host'`), "foo", nil)
+	if err := results.Error(); err != nil {
 		t.Fatal(err)
+	} else if s := mustMarshalJSON(results[0]); s != `{"rows":[{"name":"tag_cardinality","columns":["time","sum"],"values":[[0,3]]}]}` {
+		t.Fatalf("unexpected results: %s", s)
 	}
 }
 
-// Ensure the server returns an error when deleting a non-existent retention policy.
-func TestServer_DeleteRetentionPolicy_ErrRetentionPolicyNotFound(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
+// Ensure the server can delete expired shard groups and their shard files.
+func TestServer_EnforceRetentionPolicies(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
 	defer s.Close()
 	s.CreateDatabase("foo")
-	if err := s.DeleteRetentionPolicy("foo", "no_such_policy"); err != influxdb.ErrRetentionPolicyNotFound {
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 1})
+	s.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T00:00:00Z"))
+	s.CreateShardGroupIfNotExists("foo", "raw", time.Now().UTC())
+
+	// Track unsubscriptions from the expired shard.
+	a, _ := s.ShardGroups("foo")
+	sh := a[0].Shards[0]
+	var unsubscribed bool
+	c.UnsubscribeFunc = func(replicaID, topicID uint64) error {
+		unsubscribed = (topicID == sh.ID)
+		return nil
+	}
+
+	// Enforce the retention policies.
+	if err := s.EnforceRetentionPolicies(time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	// Verify the expired group and its shard file are gone.
+	if a, err := s.ShardGroups("foo"); err != nil {
 		t.Fatal(err)
+	} else if len(a) != 1 || a[0].StartTime.Equal(mustParseTime("2000-01-01T00:00:00Z")) {
+		t.Fatalf("unexpected groups: %s", mustMarshalJSON(a))
+	}
+	if _, err := os.Stat(filepath.Join(s.Path(), "shards", strconv.FormatUint(sh.ID, 10))); !os.IsNotExist(err) {
+		t.Fatalf("expected shard file to be removed: %v", err)
+	}
+	if !unsubscribed {
+		t.Fatal("expected unsubscription")
+	}
+
+	// Deleting the group again should return an error.
+	if err := s.DeleteShardGroup("foo", "raw", a[0].ID); err != influxdb.ErrShardGroupNotFound {
+		t.Fatalf("unexpected error: %s", err)
 	}
 }
 
-// Ensure the server can set the default retention policy
-func TestServer_SetDefaultRetentionPolicy(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
+// Ensure the server drops series once retention has removed all their data.
+func TestServer_DropExpiredSeries(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
 	defer s.Close()
 	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 1})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.SetDropExpiredSeries(true, 1*time.Hour)
 
-	rp := &influxdb.RetentionPolicy{Name: "bar"}
-	if err := s.CreateRetentionPolicy("foo", rp); err != nil {
+	// Write an expired series and a current series.
+	now := time.Now().UTC()
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"pod": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"pod": "b"}, Timestamp: now, Values: map[string]interface{}{"value": float64(1)}}})
+
+	// Series are kept during the grace period.
+	if err := s.EnforceRetentionPolicies(now); err != nil {
 		t.Fatal(err)
-	} else if rp, _ := s.RetentionPolicy("foo", "bar"); rp == nil {
-		t.Fatal("retention policy not created")
+	} else if ids := s.MeasurementSeriesIDs("foo", "cpu"); len(ids) != 2 {
+		t.Fatalf("unexpected series ids: %v", ids)
 	}
 
-	// Set bar as default
-	if err := s.SetDefaultRetentionPolicy("foo", "bar"); err != nil {
+	// The empty series is dropped after the grace period.
+	if err := s.DropExpiredSeries(now.Add(1 * time.Hour)); err != nil {
 		t.Fatal(err)
 	}
 
-	if o, _ := s.DefaultRetentionPolicy("foo"); o == nil {
-		t.Fatal("default policy not set")
-	} else if !reflect.DeepEqual(rp, o) {
-		t.Fatalf("retention policy mismatch: %#v", o)
+	// A series written to before the drop is applied is kept.
+	ids := s.MeasurementSeriesIDs("foo", "cpu")
+	if len(ids) != 1 {
+		t.Fatalf("unexpected series ids: %v", ids)
+	} else if err := s.Sync(mustPublish(c, 0x51, fmt.Sprintf(`{"database":"foo","seriesIDs":[%d],"ifEmpty":true}`, ids[0]))); err != nil {
+		t.Fatal(err)
 	}
-
 	s.Restart()
-
-	if o, _ := s.DefaultRetentionPolicy("foo"); o == nil {
-		t.Fatal("default policy not kept after restart")
-	} else if !reflect.DeepEqual(rp, o) {
-		t.Fatalf("retention policy mismatch after restart: %#v", o)
+	if ids := s.MeasurementSeriesIDs("foo", "cpu"); len(ids) != 1 {
+		t.Fatalf("unexpected series ids: %v", ids)
+	} else if v, err := s.ReadSeries("foo", "raw", "cpu", map[string]string{"pod": "b"}, now); err != nil || v == nil {
+		t.Fatalf("expected current series: %v, %v", v, err)
 	}
 }
 
-// Ensure the server returns an error when setting the deafult retention policy to a non-existant one.
-func TestServer_SetDefaultRetentionPolicy_ErrRetentionPolicyNotFound(t *testing.T) {
+// Ensure the server can rebuild a database's index from the metastore.
+func TestServer_ReindexDatabase(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
-	if err := s.SetDefaultRetentionPolicy("foo", "no_such_policy"); err != influxdb.ErrRetentionPolicyNotFound {
-		t.Fatal(err)
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverb"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "mem", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(30)}}})
+
+	if res := s.ExecuteQuery(MustParseQuery(`REINDEX DATABASE foo`), "foo", nil); res.Error() != nil {
+		t.Fatal(res.Error())
+	}
+
+	// The measurements, series and fields are still available.
+	if a := s.MeasurementNames("foo"); !reflect.DeepEqual(a, []string{"cpu", "mem"}) {
+		t.Fatalf("unexpected measurements: %v", a)
+	}
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,30]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	if err := s.ReindexDatabase("no_such_db"); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
-// Ensure the database can write data to the database.
-func TestServer_WriteSeries(t *testing.T) {
-	c := NewMessagingClient()
-	s := OpenServer(c)
+// Ensure the server can compact idle shards to reclaim space from deleted data.
+func TestServer_CompactIdleShards(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
-	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "mypolicy", Duration: 1 * time.Hour})
-	s.CreateUser("susy", "pass", false)
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
 
-	// Check if a topic is being subscribed to.
-	var subscribed bool
-	c.SubscribeFunc = func(replicaID, topicID uint64) error {
-		subscribed = true
-		return nil
+	// Write a large series and a small one, then drop the large series.
+	text := strings.Repeat("x", 1000)
+	for i := 0; i < 200; i++ {
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(i) * time.Second), Values: map[string]interface{}{"text": text}}})
+	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverb"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"text": "ok"}}})
+	if res := s.ExecuteQuery(MustParseQuery(`DROP SERIES FROM cpu WHERE host = 'servera'`), "foo", nil); res.Error() != nil {
+		t.Fatal(res.Error())
 	}
 
-	// Write series with one point to the database.
-	tags := map[string]string{"host": "servera.influx.com", "region": "uswest"}
-	index, err := s.WriteSeries("foo", "mypolicy", []influxdb.Point{influxdb.Point{Name: "cpu_load", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(23.2)}}})
-	if err != nil {
+	a, _ := s.ShardGroups("foo")
+	path := filepath.Join(s.Path(), "shards", strconv.FormatUint(a[0].Shards[0].ID, 10))
+	size := func() int64 {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	before := size()
+
+	// The shard was just written to so the first pass should skip it.
+	if err := s.CompactIdleShards(); err != nil {
 		t.Fatal(err)
-	} else if err = s.Sync(index); err != nil {
-		t.Fatalf("sync error: %s", err)
+	} else if n := size(); n != before {
+		t.Fatalf("unexpected compaction: %d -> %d", before, n)
 	}
 
-	// Write another point 10 seconds later so it goes through "raw series".
-	index, err = s.WriteSeries("foo", "mypolicy", []influxdb.Point{influxdb.Point{Name: "cpu_load", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(100)}}})
+	// The shard is idle on the second pass and should shrink.
+	if err := s.CompactIdleShards(); err != nil {
+		t.Fatal(err)
+	} else if n := size(); n >= before {
+		t.Fatalf("expected shard to shrink: %d -> %d", before, n)
+	}
+
+	// The shard hasn't changed since it was compacted so it is skipped.
+	fi, err := os.Stat(path)
 	if err != nil {
 		t.Fatal(err)
-	} else if err = s.Sync(index); err != nil {
-		t.Fatalf("sync error: %s", err)
+	}
+	if err := s.CompactIdleShards(); err != nil {
+		t.Fatal(err)
+	} else if other, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if !os.SameFile(fi, other) {
+		t.Fatal("unexpected compaction of unchanged shard")
 	}
 
-	// Verify a subscription was made.
-	if !subscribed {
-		t.Fatal("expected subscription")
+	// Remaining data should still be readable and writable.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverb"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"text": "ok"}}})
+	results := s.ExecuteQuery(MustParseQuery(`SELECT count(text) FROM cpu`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res.Rows); s != `[{"name":"cpu","columns":["time","count"],"values":[[0,2]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
 	}
 
-	// Retrieve first series data point.
-	if v, err := s.ReadSeries("foo", "mypolicy", "cpu_load", tags, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
-		t.Fatal(err)
-	} else if !reflect.DeepEqual(v, map[string]interface{}{"value": float64(23.2)}) {
-		t.Fatalf("values mismatch: %#v", v)
+	// Compacting an unknown shard should return an error.
+	if err := s.CompactShard(1000); err != influxdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %s", err)
 	}
+}
 
-	// Retrieve second series data point.
-	if v, err := s.ReadSeries("foo", "mypolicy", "cpu_load", tags, mustParseTime("2000-01-01T00:00:10Z")); err != nil {
+// Ensure points with a TTL are hidden from queries once they expire and are
+// removed when their shard is compacted.
+func TestServer_WriteSeries_TTL(t *testing.T) {
+	clock := influxdb.NewMockClock(mustParseTime("2000-01-01T00:00:00Z"))
+	s := NewServer()
+	if err := s.SetClock(clock); err != nil {
 		t.Fatal(err)
-	} else if mustMarshalJSON(v) != mustMarshalJSON(map[string]interface{}{"value": float64(100)}) {
-		t.Fatalf("values mismatch: %#v", v)
+	} else if err := s.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Write points that expire after 10 minutes, an hour and never.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}, TTL: 10 * time.Minute}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(2)}, TTL: 1 * time.Hour}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Values: map[string]interface{}{"value": float64(4)}}})
+
+	sum := func() string {
+		results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+		if err := results.Error(); err != nil {
+			t.Fatal(err)
+		}
+		return mustMarshalJSON(results[0].Rows[0].Values)
+	}
+	if v := sum(); v != `[[0,7]]` {
+		t.Fatalf("unexpected sum: %s", v)
 	}
 
-	// Retrieve non-existent series data point.
-	if v, err := s.ReadSeries("foo", "mypolicy", "cpu_load", tags, mustParseTime("2000-01-01T00:01:00Z")); err != nil {
+	// Expired points are skipped by queries.
+	clock.Add(30 * time.Minute)
+	if v := sum(); v != `[[0,6]]` {
+		t.Fatalf("unexpected sum: %s", v)
+	} else if v, _ := s.ReadSeries("foo", "raw", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z")); v != nil {
+		t.Fatalf("unexpected values: %#v", v)
+	}
+
+	// Compaction removes the expired points from the shard.
+	clock.Add(1 * time.Hour)
+	shardID := s.ShardStats()[0].ShardID
+	if err := s.CompactShard(shardID); err != nil {
 		t.Fatal(err)
-	} else if v != nil {
-		t.Fatalf("expected nil values: %#v", v)
+	} else if a, _ := s.ShardDigest(shardID); len(a) != 1 || a[0].Count != 1 {
+		t.Fatalf("unexpected digest: %s", mustMarshalJSON(a))
+	} else if v := sum(); v != `[[0,4]]` {
+		t.Fatalf("unexpected sum: %s", v)
 	}
 }
 
-// Ensure the server can execute a query and return the data correctly.
-func TestServer_ExecuteQuery(t *testing.T) {
+// Ensure a point can't be written with a negative TTL.
+func TestServer_WriteSeries_ErrInvalidPointTTL(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
 	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
-	s.SetDefaultRetentionPolicy("foo", "raw")
-	s.CreateUser("susy", "pass", false)
+	if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}, TTL: -1}}); err != influxdb.ErrInvalidPointTTL {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
 
-	// Write series with one point to the database.
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(30)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+// Ensure the server can record events and list them by time range.
+func TestServer_Events(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
 
-	// Select data from the server.
-	results := s.ExecuteQuery(MustParseQuery("SELECT sum(value) FROM cpu"), "foo", nil)
-	if len(results) != 1 {
-		t.Fatalf("unexpected result count: %d", len(results))
+	// Record a deploy and an incident that spans an hour.
+	if _, err := s.CreateEvent("foo", &influxdb.Event{Name: "deploys", StartTime: mustParseTime("2000-01-01T00:00:00Z"), Text: "v1.0", Tags: map[string]string{"host": "servera"}}); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.CreateEvent("foo", &influxdb.Event{Name: "incidents", StartTime: mustParseTime("2000-01-01T01:00:00Z"), EndTime: mustParseTime("2000-01-01T02:00:00Z"), Text: "outage"}); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.CreateEvent("foo", &influxdb.Event{Name: "deploys", StartTime: mustParseTime("2000-01-01T03:00:00Z"), Text: "v1.1"}); err != nil {
+		t.Fatal(err)
 	}
+	s.Restart()
+
+	// Verify events overlapping a range are returned, including ones that started earlier.
+	if a, err := s.Events("foo", "", mustParseTime("2000-01-01T01:30:00Z"), mustParseTime("2000-01-01T05:00:00Z")); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 2 || a[0].Text != "outage" || a[1].Text != "v1.1" {
+		t.Fatalf("unexpected events: %s", mustMarshalJSON(a))
+	}
+
+	// Verify events can be listed through a query.
+	results := s.ExecuteQuery(MustParseQuery(`LIST EVENTS FROM deploys WHERE time < '2000-01-01 02:00:00'`), "foo", nil)
 	if res := results[0]; res.Err != nil {
 		t.Fatalf("unexpected error: %s", res.Err)
-	} else if len(res.Rows) != 1 {
-		t.Fatalf("unexpected row count: %s", len(res.Rows))
-	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,150]]}]}` {
-		t.Fatalf("unexpected row(0): %s", s)
+	} else if s := mustMarshalJSON(res.Rows); s != `[{"name":"deploys","columns":["time","endTime","text","tags"],"values":[["2000-01-01T00:00:00Z","2000-01-01T00:00:00Z","v1.0",{"host":"servera"}]]}]` {
+		t.Fatalf("unexpected rows: %s", s)
 	}
 }
 
-func TestServer_CreateShardGroupIfNotExist(t *testing.T) {
+// Ensure events before 1970 are ordered before later events.
+func TestServer_Events_BeforeEpoch(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
+	for _, tt := range []string{"1970-01-01T00:00:01Z", "1969-12-31T00:00:00Z", "1960-01-01T00:00:00Z"} {
+		if _, err := s.CreateEvent("foo", &influxdb.Event{Name: "deploys", StartTime: mustParseTime(tt), Text: tt}); err != nil {
+			t.Fatal(err)
+		}
+	}
 
-	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != nil {
+	if a, err := s.Events("foo", "", time.Time{}, time.Time{}); err != nil {
 		t.Fatal(err)
+	} else if len(a) != 3 || a[0].Text != "1960-01-01T00:00:00Z" || a[1].Text != "1969-12-31T00:00:00Z" || a[2].Text != "1970-01-01T00:00:01Z" {
+		t.Fatalf("unexpected events: %s", mustMarshalJSON(a))
 	}
 
-	if err := s.CreateShardGroupIfNotExists("foo", "bar", time.Time{}); err != nil {
+	// Verify a range that ends before 1970 excludes later events.
+	if a, err := s.Events("foo", "", mustParseTime("1965-01-01T00:00:00Z"), mustParseTime("1969-12-31T12:00:00Z")); err != nil {
 		t.Fatal(err)
+	} else if len(a) != 1 || a[0].Text != "1969-12-31T00:00:00Z" {
+		t.Fatalf("unexpected events: %s", mustMarshalJSON(a))
 	}
+}
 
-	if a, err := s.ShardGroups("foo"); err != nil {
-		t.Fatal(err)
-	} else if len(a) != 1 {
-		t.Fatalf("expected 1 shard group but found %d", len(a))
+// Ensure the server returns an error when creating an event with an invalid time range.
+func TestServer_CreateEvent_ErrInvalidEventTimeRange(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if _, err := s.CreateEvent("foo", &influxdb.Event{Name: "deploys", StartTime: mustParseTime("2000-01-01T01:00:00Z"), EndTime: mustParseTime("2000-01-01T00:00:00Z")}); err != influxdb.ErrInvalidEventTimeRange {
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
@@ -830,6 +4342,7 @@ type MessagingClient struct {
 	DeleteReplicaFunc func(replicaID uint64) error
 	SubscribeFunc     func(replicaID, topicID uint64) error
 	UnsubscribeFunc   func(replicaID, topicID uint64) error
+	TruncateTopicFunc func(topicID, index uint64) error
 }
 
 // NewMessagingClient returns a new instance of MessagingClient.
@@ -840,6 +4353,7 @@ func NewMessagingClient() *MessagingClient {
 	c.DeleteReplicaFunc = func(replicaID uint64) error { return nil }
 	c.SubscribeFunc = func(replicaID, topicID uint64) error { return nil }
 	c.UnsubscribeFunc = func(replicaID, topicID uint64) error { return nil }
+	c.TruncateTopicFunc = func(topicID, index uint64) error { return nil }
 	return c
 }
 
@@ -878,6 +4392,11 @@ func (c *MessagingClient) Unsubscribe(replicaID, topicID uint64) error {
 	return c.UnsubscribeFunc(replicaID, topicID)
 }
 
+// TruncateTopic removes the messages at or before an index from a topic on the broker.
+func (c *MessagingClient) TruncateTopic(topicID, index uint64) error {
+	return c.TruncateTopicFunc(topicID, index)
+}
+
 // C returns a channel for streaming message.
 func (c *MessagingClient) C() <-chan *messaging.Message { return c.c }
 
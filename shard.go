@@ -1,24 +1,52 @@
 package influxdb
 
 import (
+	"bufio"
+	"crypto/cipher"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"math"
+	"os"
 	"sort"
+	"sync"
 	"time"
+)
+
+const (
+	// ModuloShardHash assigns a series to a shard by its id modulo the
+	// number of shards in the group. This is the default.
+	ModuloShardHash = "modulo"
 
-	"github.com/boltdb/bolt"
+	// FNVShardHash assigns a series to a shard by an FNV-1a hash of its id.
+	// This spreads series evenly even when ids are created in regular patterns.
+	FNVShardHash = "fnv"
 )
 
+// validShardHash returns true if name is a known shard hashing strategy.
+// An empty name uses the default strategy.
+func validShardHash(name string) bool {
+	return name == "" || name == ModuloShardHash || name == FNVShardHash
+}
+
 // ShardGroup represents a group of shards created for a single time range.
 type ShardGroup struct {
 	ID        uint64    `json:"id,omitempty"`
 	StartTime time.Time `json:"startTime,omitempty"`
 	EndTime   time.Time `json:"endTime,omitempty"`
 	Shards    []*Shard  `json:"shards,omitempty"`
+	Hash      string    `json:"hash,omitempty"` // series to shard strategy
 }
 
+// shardGroups represents a list of shard groups sortable by start time.
+type shardGroups []*ShardGroup
+
+func (a shardGroups) Len() int           { return len(a) }
+func (a shardGroups) Less(i, j int) bool { return a[i].StartTime.Before(a[j].StartTime) }
+func (a shardGroups) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
 // close closes all shards.
 func (g *ShardGroup) close() {
 	for _, sh := range g.Shards {
@@ -28,7 +56,14 @@ func (g *ShardGroup) close() {
 
 // ShardBySeriesID returns the shard that a series is assigned to in the group.
 func (g *ShardGroup) ShardBySeriesID(seriesID uint32) *Shard {
-	return g.Shards[int(seriesID)%len(g.Shards)]
+	switch g.Hash {
+	case FNVShardHash:
+		h := fnv.New32a()
+		_, _ = h.Write(u32tob(seriesID))
+		return g.Shards[int(h.Sum32()%uint32(len(g.Shards)))]
+	default:
+		return g.Shards[int(seriesID)%len(g.Shards)]
+	}
 }
 
 // Shard represents the logical storage for a given time range.
@@ -38,7 +73,44 @@ type Shard struct {
 	ID          uint64   `json:"id,omitempty"`
 	DataNodeIDs []uint64 `json:"nodeIDs,omitempty"` // owners
 
-	store *bolt.DB
+	mu    sync.RWMutex // guards store swaps during compaction
+	store StorageEngine
+
+	cmu     sync.Mutex // guards the compaction state below
+	written bool       // true if written to since the last compaction check
+	dirty   bool       // true if written or deleted since the last compaction
+
+	wmu      sync.Mutex       // serializes writes and guards the fields below
+	pending  []StoragePoint   // writes buffered for reordering
+	pendingN int              // max buffered writes, zero disables buffering
+	wal      *os.File         // log of the buffered writes, nil if not on disk
+	key      cipher.AEAD      // seals logged writes, nil if not encrypted
+	latest   map[uint32]int64 // newest timestamp written per series
+	stats    ShardStats
+}
+
+// ShardStats represents counters for the points written to a shard since
+// the server started. A point is out of order when it is older than a point
+// previously written to the same series.
+type ShardStats struct {
+	ShardID          uint64        `json:"shardID"`
+	PointsWritten    int64         `json:"pointsWritten"`
+	PointsOutOfOrder int64         `json:"pointsOutOfOrder"`
+	MaxOutOfOrder    time.Duration `json:"maxOutOfOrder"` // furthest a point was behind its series
+	Size             int64         `json:"size"`          // bytes in the store
+	Encrypted        bool          `json:"encrypted,omitempty"`
+}
+
+// storagePoints sorts points by series and then by timestamp.
+type storagePoints []StoragePoint
+
+func (p storagePoints) Len() int      { return len(p) }
+func (p storagePoints) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p storagePoints) Less(i, j int) bool {
+	if p[i].SeriesID != p[j].SeriesID {
+		return p[i].SeriesID < p[j].SeriesID
+	}
+	return p[i].Timestamp < p[j].Timestamp
 }
 
 // newShardGroup returns a new initialized ShardGroup instance.
@@ -50,38 +122,157 @@ func (g *ShardGroup) Duration() time.Duration { return g.EndTime.Sub(g.StartTime
 // newShard returns a new initialized Shard instance.
 func newShard() *Shard { return &Shard{} }
 
-// open initializes and opens the shard's store.
-func (s *Shard) open(path string) error {
+// open initializes and opens the shard's store using a storage engine.
+// Stores that were encrypted when they were created are opened with key.
+func (s *Shard) open(path string, fn StorageEngineOpener, key cipher.AEAD) error {
 	// Return an error if the shard is already open.
 	if s.store != nil {
 		return errors.New("shard already open")
 	}
 
 	// Open store on shard.
-	store, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	store, err := fn(path)
+	if err != nil {
+		return err
+	}
+	s.store, err = openEncryptedStorage(store, key)
 	if err != nil {
+		_ = store.Close()
 		return err
 	}
-	s.store = store
+	if _, ok := s.store.(*encryptedStorage); ok {
+		s.key = key
+	}
 
-	// Initialize store.
-	if err := s.store.Update(func(tx *bolt.Tx) error {
-		_, _ = tx.CreateBucketIfNotExists([]byte("values"))
-		return nil
-	}); err != nil {
-		_ = s.close()
-		return fmt.Errorf("init: %s", err)
+	// Write any buffered points that were logged before the last shutdown
+	// and keep the log open for new buffered writes.
+	if path != "" {
+		if err := s.openWAL(walPath(path)); err != nil {
+			_ = store.Close()
+			s.store, s.key = nil, nil
+			return fmt.Errorf("wal: %s", err)
+		}
 	}
 
 	return nil
 }
 
-// close shuts down the shard's store.
+// walPath returns the path of the write-ahead log for a shard's store.
+func walPath(path string) string { return path + ".wal" }
+
+// openWAL replays the write-ahead log at path into the store and then opens
+// the log for appending.
+func (s *Shard) openWAL(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	// Read logged points until the end of the file. A partial point at the
+	// end was never acknowledged so it is discarded.
+	var points []StoragePoint
+	r := bufio.NewReader(f)
+	for {
+		p, err := readStoragePoint(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			_ = f.Close()
+			return err
+		}
+		if s.key != nil {
+			if p.Values, err = openValues(s.key, p.Values); err != nil {
+				_ = f.Close()
+				return err
+			}
+		}
+		points = append(points, p)
+	}
+
+	// Write the points in order and clear the log.
+	if len(points) > 0 {
+		sort.Stable(storagePoints(points))
+		if err := s.store.WritePoints(points); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := f.Truncate(0); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	s.wal = f
+	return nil
+}
+
+// encrypt marks the shard's new store as encrypted so that every value
+// written to it, and to its write-ahead log, is sealed with key.
+// Must be called before the shard is written to.
+func (s *Shard) encrypt(key cipher.AEAD) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	store, err := encryptStorage(s.store, key)
+	if err != nil {
+		return err
+	}
+	s.store, s.key = store, key
+	return nil
+}
+
+// encrypted returns true if the shard's store is encrypted on this server.
+func (s *Shard) encrypted() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.store.(*encryptedStorage)
+	return ok
+}
+
+// close shuts down the shard's store. Buffered points that can't be written
+// are left in the write-ahead log and replayed when the shard is reopened.
 func (s *Shard) close() error {
+	ferr := s.flush()
+
+	s.wmu.Lock()
+	if s.wal != nil {
+		_ = s.wal.Close()
+		s.wal = nil
+	}
+	s.pending, s.key = nil, nil
+	s.wmu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.store == nil {
-		return nil
+		return ferr
+	}
+	err := s.store.Close()
+	s.store = nil
+	if ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+// opened returns true if the shard's store is open on this server.
+func (s *Shard) opened() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.store != nil
+}
+
+// size returns the size of the shard's store in bytes.
+// Returns zero if the shard is not stored on this server.
+func (s *Shard) size() (n int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.store == nil {
+		return 0
 	}
-	return s.store.Close()
+	return s.store.Size()
 }
 
 // HasDataNodeID return true if the data node owns the shard.
@@ -94,42 +285,329 @@ func (s *Shard) HasDataNodeID(id uint64) bool {
 	return false
 }
 
+// begin starts a read-only transaction on the shard's store.
+func (s *Shard) begin() (StorageTx, error) {
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.store == nil {
+		return nil, ErrShardNotLocal
+	}
+	return s.store.Begin()
+}
+
 // readSeries reads encoded series data from a shard.
 func (s *Shard) readSeries(seriesID uint32, timestamp int64) (values []byte, err error) {
-	err = s.store.View(func(tx *bolt.Tx) error {
-		// Find series bucket.
-		b := tx.Bucket(u32tob(seriesID))
-		if b == nil {
-			return nil
+	if err = s.flush(); err != nil {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.store.ReadSeries(seriesID, timestamp)
+}
+
+// writeSeries writes series data to a shard. If a reorder buffer is set then
+// the write is appended to the shard's write-ahead log and held until the
+// buffer is full or the shard is read.
+func (s *Shard) writeSeries(seriesID uint32, timestamp int64, values []byte, overwrite bool) error {
+	// Reject writes to shards that are stored on other data nodes.
+	if s.store == nil {
+		return ErrShardNotLocal
+	}
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	// Track points that are older than the newest point in their series.
+	s.stats.PointsWritten++
+	if s.latest == nil {
+		s.latest = make(map[uint32]int64)
+	}
+	if latest, ok := s.latest[seriesID]; ok && timestamp < latest {
+		s.stats.PointsOutOfOrder++
+		if d := time.Duration(latest - timestamp); d > s.stats.MaxOutOfOrder {
+			s.stats.MaxOutOfOrder = d
 		}
+	} else {
+		s.latest[seriesID] = timestamp
+	}
+
+	p := StoragePoint{SeriesID: seriesID, Timestamp: timestamp, Values: values}
+	if s.pendingN == 0 {
+		return s.writePoints([]StoragePoint{p})
+	}
+
+	// Log the point and sync the log so that the point survives a crash of
+	// the process or the host before the buffer is written.
+	if s.wal != nil {
+		logged := p
+		if s.key != nil {
+			logged.Values = sealValues(s.key, p.Values)
+		}
+		if err := writeStoragePoint(s.wal, logged); err != nil {
+			return fmt.Errorf("wal: %s", err)
+		} else if err := s.wal.Sync(); err != nil {
+			return fmt.Errorf("wal: %s", err)
+		}
+	}
 
-		// Retrieve encoded series data.
-		values = b.Get(u64tob(uint64(timestamp)))
+	// Buffer the point and write the buffer in order once it is full.
+	s.pending = append(s.pending, p)
+	if len(s.pending) < s.pendingN {
 		return nil
-	})
-	return
+	}
+	return s.writePending()
 }
 
-// writeSeries writes series data to a shard.
-func (s *Shard) writeSeries(seriesID uint32, timestamp int64, values []byte, overwrite bool) error {
-	return s.store.Update(func(tx *bolt.Tx) error {
-		// Create a bucket for the series.
-		b, err := tx.CreateBucketIfNotExists(u32tob(seriesID))
-		if err != nil {
+// flush writes any points held in the reorder buffer.
+func (s *Shard) flush() error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return s.writePending()
+}
+
+// writePending writes the reorder buffer sorted by series and timestamp.
+// Points with the same series and timestamp keep their write order so the
+// last write wins. If the write fails the points stay buffered, and logged,
+// so the next flush retries them. Must be called with wmu held.
+func (s *Shard) writePending() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	sort.Stable(storagePoints(s.pending))
+	if err := s.writePoints(s.pending); err != nil {
+		return err
+	}
+	s.pending = nil
+
+	// Clear the log once its points are in the store.
+	if s.wal != nil {
+		if err := s.wal.Truncate(0); err != nil {
+			return fmt.Errorf("wal: %s", err)
+		}
+	}
+	return nil
+}
+
+// writePoints writes points to the store. Must be called with wmu held.
+func (s *Shard) writePoints(points []StoragePoint) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.changed()
+	return s.store.WritePoints(points)
+}
+
+// setReorderBufferSize sets the number of writes buffered before they are
+// sorted and written. Buffered writes are flushed if buffering is reduced.
+func (s *Shard) setReorderBufferSize(n int) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.pendingN = n
+	if len(s.pending) >= n {
+		return s.writePending()
+	}
+	return nil
+}
+
+// Stats returns the write counters and store size for the shard.
+func (s *Shard) Stats() ShardStats {
+	s.wmu.Lock()
+	stats := s.stats
+	s.wmu.Unlock()
+	stats.ShardID = s.ID
+	stats.Size = s.size()
+	stats.Encrypted = s.encrypted()
+	return stats
+}
+
+// deleteSeries removes all data for a series from a shard.
+func (s *Shard) deleteSeries(seriesID uint32) error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.changed()
+	return s.store.DeleteSeries(seriesID)
+}
+
+// deleteRange removes the values of a set of series with timestamps between
+// min and max, inclusive.
+func (s *Shard) deleteRange(seriesIDs []uint32, min, max int64) error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed()
+
+	// Find the values in the range in a single transaction.
+	tx, err := s.store.Begin()
+	if err != nil {
+		return err
+	}
+	deleted := make(map[uint32][]int64)
+	for _, id := range seriesIDs {
+		c := tx.Cursor(id)
+		if c == nil {
+			continue
+		}
+		for k, v := c.SeekTo(min); v != nil && k <= max; k, v = c.Next() {
+			deleted[id] = append(deleted[id], k)
+		}
+		if err := cursorErr(c); err != nil {
+			_ = tx.Rollback()
 			return err
 		}
+	}
+	if err := tx.Rollback(); err != nil {
+		return err
+	}
 
-		// Insert the values by timestamp.
-		if err := b.Put(u64tob(uint64(timestamp)), values); err != nil {
+	// Remove them once the transaction is closed.
+	for id, timestamps := range deleted {
+		if err := s.store.DeletePoints(id, timestamps); err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+// seriesWithData returns the ids of the series that have values in the shard.
+func (s *Shard) seriesWithData(seriesIDs []uint32) ([]uint32, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var a []uint32
+	for _, id := range seriesIDs {
+		c := tx.Cursor(id)
+		if c == nil {
+			continue
+		}
+		if _, v := c.SeekTo(0); v != nil {
+			a = append(a, id)
+		} else if err := cursorErr(c); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
 
+// copyPoints writes points copied from another replica of the shard.
+// Copied points are not counted in the shard's write stats.
+func (s *Shard) copyPoints(points []StoragePoint) error {
+	if len(points) == 0 {
 		return nil
-	})
+	}
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if err := s.writePending(); err != nil {
+		return err
+	}
+	return s.writePoints(points)
+}
+
+// changed marks the shard as written to since the last compaction check.
+func (s *Shard) changed() {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	s.written, s.dirty = true, true
 }
 
-func (s *Shard) deleteSeries(name string) error {
-	panic("not yet implemented") // TODO
+// idle returns true if the shard has changed since it was last compacted but
+// has not been written to since the last call.
+func (s *Shard) idle() bool {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	idle := s.dirty && !s.written
+	s.written = false
+	return idle
+}
+
+// compact removes the values of a set of series that expired before now and
+// then reclaims the space left by deleted and overwritten data in the shard's
+// store. Writes to the shard are blocked while compaction is running. The
+// shard stays dirty if compaction fails or values are left to expire.
+func (s *Shard) compact(seriesIDs []uint32, now int64) error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Ignore shards that are not stored on this server.
+	if s.store == nil {
+		return nil
+	}
+
+	dirty := true
+	defer func() {
+		s.cmu.Lock()
+		s.dirty = dirty
+		s.cmu.Unlock()
+	}()
+
+	pending, err := s.deleteExpired(seriesIDs, now)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Compact(); err != nil {
+		return err
+	}
+	dirty = pending
+	return nil
+}
+
+// deleteExpired removes the values of a set of series that have an expiry
+// at or before now. Returns true if values with a later expiry remain.
+// Must be called while holding the store lock.
+func (s *Shard) deleteExpired(seriesIDs []uint32, now int64) (pending bool, err error) {
+	// Find the expired values in a single transaction.
+	tx, err := s.store.Begin()
+	if err != nil {
+		return false, err
+	}
+	expired := make(map[uint32][]int64)
+	for _, id := range seriesIDs {
+		c := tx.Cursor(id)
+		if c == nil {
+			continue
+		}
+		for k, v := c.SeekTo(0); v != nil; k, v = c.Next() {
+			if expires := unmarshalExpiry(v); expires != 0 && expires <= now {
+				expired[id] = append(expired[id], k)
+			} else if expires != 0 {
+				pending = true
+			}
+		}
+		if err := cursorErr(c); err != nil {
+			_ = tx.Rollback()
+			return false, err
+		}
+	}
+	if err := tx.Rollback(); err != nil {
+		return false, err
+	}
+
+	// Remove them once the transaction is closed.
+	for id, timestamps := range expired {
+		if err := s.store.DeletePoints(id, timestamps); err != nil {
+			return false, err
+		}
+	}
+	return pending, nil
 }
 
 // Shards represents a list of shards.
@@ -153,7 +631,30 @@ func unmarshalPointHeader(b []byte) (seriesID uint32, timestamp int64) {
 	return
 }
 
+// expiryFieldID is the field id that holds a point's expiry, in nanoseconds
+// since the epoch. Measurement fields are numbered from 1 so the expiry never
+// collides with a field and is not returned as a value.
+const expiryFieldID = uint8(0)
+
+// Field value type codes used by the value encoding.
+const (
+	fieldValueNumber  = byte(1)
+	fieldValueInteger = byte(2)
+	fieldValueBoolean = byte(3)
+	fieldValueString  = byte(4)
+)
+
+// typedValuesMarker is the first byte of values encoded with type codes.
+// Values written before field types were supported start with the field
+// count instead, which never exceeds 254, and hold only float64 values.
+// Both layouts are decoded since shards and the broker log keep old values.
+const typedValuesMarker = byte(0xFF)
+
 // marshalValues encodes a set of field ids and values to a byte slice.
+// The encoding starts with typedValuesMarker and the field count. Each value
+// is then written as its field id, a type code, and a type-specific payload:
+// 8 bytes for numbers and integers, 1 byte for booleans, and a uvarint length
+// followed by the bytes for strings.
 func marshalValues(values map[uint8]interface{}) []byte {
 	// Sort fields for consistency.
 	fieldIDs := make([]uint8, 0, len(values))
@@ -162,74 +663,181 @@ func marshalValues(values map[uint8]interface{}) []byte {
 	}
 	sort.Sort(uint8Slice(fieldIDs))
 
-	// Allocate byte slice and write field count.
-	b := make([]byte, 1, 10)
-	b[0] = byte(len(values))
+	// Allocate byte slice and write the marker and field count.
+	b := make([]byte, 2, 12)
+	b[0], b[1] = typedValuesMarker, byte(len(values))
 
 	// Write out each field.
 	for _, fieldID := range fieldIDs {
-		// Create a temporary buffer for this field.
-		buf := make([]byte, 9)
-		buf[0] = fieldID
-
 		// Convert integers to floats.
 		v := values[fieldID]
 		if intval, ok := v.(int); ok {
 			v = float64(intval)
 		}
 
-		// Encode value after field id.
-		// TODO: Support non-float types.
+		// Encode value after field id and type code.
 		switch v := v.(type) {
 		case float64:
-			binary.BigEndian.PutUint64(buf[1:9], math.Float64bits(v))
+			buf := make([]byte, 10)
+			buf[0], buf[1] = fieldID, fieldValueNumber
+			binary.BigEndian.PutUint64(buf[2:10], math.Float64bits(v))
+			b = append(b, buf...)
+		case int64:
+			buf := make([]byte, 10)
+			buf[0], buf[1] = fieldID, fieldValueInteger
+			binary.BigEndian.PutUint64(buf[2:10], uint64(v))
+			b = append(b, buf...)
+		case bool:
+			buf := []byte{fieldID, fieldValueBoolean, 0}
+			if v {
+				buf[2] = 1
+			}
+			b = append(b, buf...)
+		case string:
+			buf := make([]byte, 2+binary.MaxVarintLen64)
+			buf[0], buf[1] = fieldID, fieldValueString
+			n := binary.PutUvarint(buf[2:], uint64(len(v)))
+			b = append(b, buf[:2+n]...)
+			b = append(b, v...)
 		default:
 			panic(fmt.Sprintf("unsupported value type: %T", v))
 		}
+	}
 
-		// Append temp buffer to the end.
-		b = append(b, buf...)
+	return b
+}
+
+// marshalFloatValues encodes float values in the layout used before type
+// codes were added: the field count followed by each field id and value.
+// It is used while data nodes that only decode that layout are in the cluster.
+func marshalFloatValues(values map[uint8]interface{}) []byte {
+	// Sort fields for consistency.
+	fieldIDs := make([]uint8, 0, len(values))
+	for fieldID := range values {
+		fieldIDs = append(fieldIDs, fieldID)
 	}
+	sort.Sort(uint8Slice(fieldIDs))
+
+	b := make([]byte, 1, 1+len(values)*9)
+	b[0] = byte(len(values))
+	for _, fieldID := range fieldIDs {
+		v := values[fieldID]
+		if intval, ok := v.(int); ok {
+			v = float64(intval)
+		}
 
+		buf := make([]byte, 9)
+		buf[0] = fieldID
+		binary.BigEndian.PutUint64(buf[1:9], math.Float64bits(v.(float64)))
+		b = append(b, buf...)
+	}
 	return b
 }
 
 // unmarshalValues decodes a byte slice into a set of field ids and values.
-func unmarshalValues(b []byte) map[uint8]interface{} {
+// Returns ErrInvalidValueEncoding if the byte slice is truncated or holds an
+// unknown type code.
+func unmarshalValues(b []byte) (map[uint8]interface{}, error) {
 	if len(b) == 0 {
-		return nil
+		return nil, nil
+	} else if b[0] != typedValuesMarker {
+		return unmarshalFloatValues(b)
+	} else if len(b) < 2 {
+		return nil, ErrInvalidValueEncoding
 	}
 
-	// Read the field count from the field byte.
-	n := int(b[0])
+	// Read the field count after the marker.
+	n := int(b[1])
 
 	// Create a map to hold the decoded data.
 	values := make(map[uint8]interface{}, n)
 
-	// Start from the second byte and iterate over until we're done decoding.
-	b = b[1:]
+	// Start after the count and iterate over until we're done decoding.
+	b = b[2:]
 	for i := 0; i < n; i++ {
-		// First byte is the field identifier.
-		fieldID := b[0]
+		// First byte is the field identifier and second is the type code.
+		if len(b) < 2 {
+			return nil, ErrInvalidValueEncoding
+		}
+		fieldID, typ := b[0], b[1]
+		b = b[2:]
+
+		// Decode value and move bytes forward.
+		var v interface{}
+		switch typ {
+		case fieldValueNumber:
+			if len(b) < 8 {
+				return nil, ErrInvalidValueEncoding
+			}
+			v = math.Float64frombits(binary.BigEndian.Uint64(b[0:8]))
+			b = b[8:]
+		case fieldValueInteger:
+			if len(b) < 8 {
+				return nil, ErrInvalidValueEncoding
+			}
+			v = int64(binary.BigEndian.Uint64(b[0:8]))
+			b = b[8:]
+		case fieldValueBoolean:
+			if len(b) < 1 {
+				return nil, ErrInvalidValueEncoding
+			}
+			v = b[0] != 0
+			b = b[1:]
+		case fieldValueString:
+			sz, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < sz {
+				return nil, ErrInvalidValueEncoding
+			}
+			v = string(b[n : n+int(sz)])
+			b = b[n+int(sz):]
+		default:
+			return nil, ErrInvalidValueEncoding
+		}
 
-		// Decode value.
-		// TODO: Support non-float types.
-		value := math.Float64frombits(binary.BigEndian.Uint64(b[1:9]))
+		// The expiry is not a field value.
+		if fieldID != expiryFieldID {
+			values[fieldID] = v
+		}
+	}
 
-		values[fieldID] = value
+	return values, nil
+}
 
-		// Move bytes forward.
+// unmarshalFloatValues decodes values written before type codes were added:
+// the field count followed by a field id and 8 byte float64 for each value.
+func unmarshalFloatValues(b []byte) (map[uint8]interface{}, error) {
+	n := int(b[0])
+	b = b[1:]
+	if len(b) < n*9 {
+		return nil, ErrInvalidValueEncoding
+	}
+
+	values := make(map[uint8]interface{}, n)
+	for i := 0; i < n; i++ {
+		values[b[0]] = math.Float64frombits(binary.BigEndian.Uint64(b[1:9]))
 		b = b[9:]
 	}
+	return values, nil
+}
 
-	return values
+// unmarshalExpiry returns the expiry of an encoded byte slice or zero if the
+// values do not expire. Fields are sorted by id so the expiry is always first.
+// Values written before type codes were added never expire.
+func unmarshalExpiry(b []byte) int64 {
+	if len(b) < 12 || b[0] != typedValuesMarker || b[1] == 0 || b[2] != expiryFieldID || b[3] != fieldValueInteger {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b[4:12]))
 }
 
 // unmarshalValue extracts a single value by field id from an encoded byte slice.
-func unmarshalValue(b []byte, fieldID uint8) interface{} {
+func unmarshalValue(b []byte, fieldID uint8) (interface{}, error) {
 	// OPTIMIZE: Don't materialize entire map. Just search for value.
-	values := unmarshalValues(b)
-	return values[fieldID]
+	values, err := unmarshalValues(b)
+	if err != nil {
+		return nil, err
+	}
+	return values[fieldID], nil
 }
 
 type uint8Slice []uint8
@@ -237,3 +845,9 @@ type uint8Slice []uint8
 func (p uint8Slice) Len() int           { return len(p) }
 func (p uint8Slice) Less(i, j int) bool { return p[i] < p[j] }
 func (p uint8Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type uint64Slice []uint64
+
+func (p uint64Slice) Len() int           { return len(p) }
+func (p uint64Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p uint64Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
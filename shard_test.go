@@ -0,0 +1,163 @@
+package influxdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Ensure that typed values can be encoded and decoded.
+func TestMarshalValues(t *testing.T) {
+	values := map[uint8]interface{}{
+		1: float64(100),
+		2: int64(-20),
+		3: true,
+		4: "foo",
+	}
+	if v, err := unmarshalValues(marshalValues(values)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !reflect.DeepEqual(v, values) {
+		t.Fatalf("unexpected values: %#v", v)
+	}
+}
+
+// Ensure that values written before type codes were added are decoded as floats.
+func TestUnmarshalValues_Legacy(t *testing.T) {
+	b := []byte{2, 1, 0, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(b[2:10], math.Float64bits(10))
+	binary.BigEndian.PutUint64(b[11:19], math.Float64bits(-2.5))
+
+	if v, err := unmarshalValues(b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !reflect.DeepEqual(v, map[uint8]interface{}{1: float64(10), 3: float64(-2.5)}) {
+		t.Fatalf("unexpected values: %#v", v)
+	}
+	if expires := unmarshalExpiry(b); expires != 0 {
+		t.Fatalf("unexpected expiry: %d", expires)
+	}
+}
+
+// Ensure that float values can be encoded for data nodes that predate type codes.
+func TestMarshalFloatValues(t *testing.T) {
+	b := marshalFloatValues(map[uint8]interface{}{3: float64(-2.5), 1: 10})
+	if b[0] == typedValuesMarker {
+		t.Fatal("unexpected marker")
+	} else if v, err := unmarshalValues(b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !reflect.DeepEqual(v, map[uint8]interface{}{1: float64(10), 3: float64(-2.5)}) {
+		t.Fatalf("unexpected values: %#v", v)
+	}
+}
+
+// Ensure that decoding an unknown type code or truncated values returns an error.
+func TestUnmarshalValues_ErrInvalidValueEncoding(t *testing.T) {
+	for i, b := range [][]byte{
+		{typedValuesMarker, 1, 1, 9, 0},
+		{typedValuesMarker, 1, 1, fieldValueNumber, 0, 0},
+		{typedValuesMarker, 1, 1, fieldValueString, 5, 'a'},
+		{2, 1, 0, 0, 0, 0, 0, 0, 0, 0},
+	} {
+		if _, err := unmarshalValues(b); err != ErrInvalidValueEncoding {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+}
+
+// Ensure that buffered points are kept when writing the reorder buffer fails.
+func TestShard_writePending_Err(t *testing.T) {
+	store := &shardTestStorage{err: errors.New("marker")}
+	sh := &Shard{store: store, pendingN: 2}
+
+	if err := sh.writeSeries(1, 20, []byte{1}, false); err != nil {
+		t.Fatal(err)
+	} else if err := sh.writeSeries(1, 10, []byte{2}, false); err == nil || err.Error() != "marker" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(sh.pending) != 2 {
+		t.Fatalf("unexpected pending points: %d", len(sh.pending))
+	}
+
+	// Ensure the points are written in order once the store recovers.
+	store.err = nil
+	if err := sh.flush(); err != nil {
+		t.Fatal(err)
+	} else if len(sh.pending) != 0 {
+		t.Fatalf("unexpected pending points: %d", len(sh.pending))
+	} else if !reflect.DeepEqual(store.points, []StoragePoint{
+		{SeriesID: 1, Timestamp: 10, Values: []byte{2}},
+		{SeriesID: 1, Timestamp: 20, Values: []byte{1}},
+	}) {
+		t.Fatalf("unexpected points: %#v", store.points)
+	}
+}
+
+// Ensure a statement's snapshot of a shard can still be read after the shard
+// is compacted and then closed and deleted.
+func TestShard_begin_CompactClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-shard-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "1")
+	sh := newShard()
+	if err := sh.open(path, OpenBoltStorageEngine, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, timestamp := range []int64{10, 20, 30} {
+		if err := sh.writeSeries(1, timestamp, marshalValues(map[uint8]interface{}{1: float64(timestamp)}), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Start reading the series as a statement would.
+	tx, err := sh.begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := tx.Cursor(1)
+	if timestamp, v := c.SeekTo(0); timestamp != 10 || v == nil {
+		t.Fatalf("unexpected value: %d, %v", timestamp, v)
+	}
+
+	// Compact and delete the shard while the statement is iterating.
+	if err := sh.compact([]uint32{1}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if timestamp, v := c.Next(); timestamp != 20 || v == nil {
+		t.Fatalf("unexpected value: %d, %v", timestamp, v)
+	}
+	if err := sh.close(); err != nil {
+		t.Fatal(err)
+	} else if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if timestamp, v := c.Next(); timestamp != 30 || v == nil {
+		t.Fatalf("unexpected value: %d, %v", timestamp, v)
+	} else if _, v := c.Next(); v != nil {
+		t.Fatalf("unexpected value: %v", v)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// shardTestStorage is a storage engine that records written points.
+type shardTestStorage struct {
+	StorageEngine
+	points []StoragePoint
+	err    error // returned by WritePoints if set
+}
+
+func (s *shardTestStorage) WritePoints(points []StoragePoint) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.points = append(s.points, points...)
+	return nil
+}
@@ -0,0 +1,398 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+const (
+	// DefaultPort represents the default StatsD UDP port.
+	DefaultPort = 8125
+
+	// DefaultFlushInterval represents the default time between writes of
+	// aggregated metrics.
+	DefaultFlushInterval = 10 * time.Second
+)
+
+var (
+	// ErrBindAddressRequired is returned when starting the Server
+	// without a UDP listening address.
+	ErrBindAddressRequired = errors.New("bind address required")
+
+	// ErrDatabaseNotSpecified retuned when no database was specified in the config file
+	ErrDatabaseNotSpecified = errors.New("database was not specified in config")
+
+	// ErrInvalidFlushInterval is returned when starting the Server with a
+	// non-positive flush interval.
+	ErrInvalidFlushInterval = errors.New("invalid flush interval")
+
+	// ErrServerClosed return when closing an already closed StatsD server.
+	ErrServerClosed = errors.New("server already closed")
+)
+
+// SeriesWriter defines the interface for the destination of the data.
+type SeriesWriter interface {
+	WriteSeries(database, retentionPolicy string, points []influxdb.Point) (uint64, error)
+}
+
+// Metric types.
+const (
+	Counter = "c"
+	Gauge   = "g"
+	Timer   = "ms"
+	Set     = "s"
+)
+
+// Metric represents a single parsed StatsD sample.
+type Metric struct {
+	Name       string
+	Tags       map[string]string
+	Type       string
+	Value      float64
+	SetValue   string  // member for sets
+	Relative   bool    // gauge value is a delta
+	SampleRate float64 // fraction of samples sent, counters are scaled by its inverse
+}
+
+// Parse parses a single StatsD line in the form:
+//
+//	<name>:<value>|<type>[|@<sample rate>][|#<tag>:<value>,...]
+//
+// Types are "c" (counter), "g" (gauge), "ms" or "h" (timer) and "s" (set).
+// Gauge values prefixed with "+" or "-" adjust the previous value.
+func Parse(line string) (*Metric, error) {
+	// Split the name from the value and its options.
+	i := strings.LastIndex(line, ":")
+	if i <= 0 {
+		return nil, fmt.Errorf("received %q which is not in the form: name:value|type", line)
+	}
+	// Tags may contain colons so find the name separator before them.
+	if j := strings.Index(line, "|#"); j != -1 {
+		i = strings.LastIndex(line[:j], ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("received %q which is not in the form: name:value|type", line)
+		}
+	}
+
+	m := &Metric{Name: line[:i], Tags: make(map[string]string), SampleRate: 1}
+	parts := strings.Split(line[i+1:], "|")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("received %q which is not in the form: name:value|type", line)
+	}
+
+	// Parse the type.
+	switch parts[1] {
+	case Counter, Gauge, Timer, Set:
+		m.Type = parts[1]
+	case "h":
+		m.Type = Timer
+	default:
+		return nil, fmt.Errorf("invalid metric type %q", parts[1])
+	}
+
+	// Parse the value.
+	if m.Type == Set {
+		m.SetValue = parts[0]
+	} else {
+		if m.Type == Gauge && (strings.HasPrefix(parts[0], "+") || strings.HasPrefix(parts[0], "-")) {
+			m.Relative = true
+		}
+		v, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q", parts[0])
+		}
+		m.Value = v
+	}
+
+	// Parse the sample rate and tags.
+	for _, s := range parts[2:] {
+		switch {
+		case strings.HasPrefix(s, "@"):
+			rate, err := strconv.ParseFloat(s[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid sample rate %q", s[1:])
+			}
+			m.SampleRate = rate
+		case strings.HasPrefix(s, "#"):
+			for _, tag := range strings.Split(s[1:], ",") {
+				kv := strings.SplitN(tag, ":", 2)
+				if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+					return nil, fmt.Errorf("invalid tag %q", tag)
+				}
+				m.Tags[kv[0]] = kv[1]
+			}
+		default:
+			return nil, fmt.Errorf("invalid option %q", s)
+		}
+	}
+
+	return m, nil
+}
+
+// key returns a string that uniquely identifies the metric's series.
+func (m *Metric) key() string {
+	keys := make([]string, 0, len(m.Tags))
+	for k := range m.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := m.Name
+	for _, k := range keys {
+		s += "," + k + "=" + m.Tags[k]
+	}
+	return s
+}
+
+// aggregate represents the values received for one series during a flush
+// interval.
+type aggregate struct {
+	name    string
+	tags    map[string]string
+	typ     string
+	value   float64             // counter total or gauge value
+	timings []float64           // timer samples
+	members map[string]struct{} // set members
+	updated bool                // true if received during the interval
+}
+
+// Server receives StatsD metrics over UDP, aggregates them and writes the
+// aggregates on every flush interval.
+type Server struct {
+	mu   sync.Mutex
+	wg   sync.WaitGroup
+	done chan struct{}
+
+	conn       *net.UDPConn
+	writer     SeriesWriter
+	aggregates map[string]*aggregate
+
+	Database        string
+	RetentionPolicy string
+	FlushInterval   time.Duration
+
+	// Stats records the state of the server for diagnostics. Optional.
+	Stats *influxdb.InputStats
+
+	Logger *influxdb.Logger
+}
+
+// NewServer returns a new instance of Server.
+func NewServer(w SeriesWriter) *Server {
+	return &Server{
+		writer:        w,
+		aggregates:    make(map[string]*aggregate),
+		FlushInterval: DefaultFlushInterval,
+		Logger:        influxdb.NewLogger(os.Stderr, "statsd", influxdb.InfoLevel, false),
+	}
+}
+
+// ListenAndServe instructs the Server to start processing StatsD data on the
+// given interface. iface must be in the form host:port
+func (s *Server) ListenAndServe(iface string) error {
+	if iface == "" { // Make sure we have an address
+		return ErrBindAddressRequired
+	} else if s.Database == "" { // Make sure they have a database
+		return ErrDatabaseNotSpecified
+	} else if s.FlushInterval <= 0 {
+		return ErrInvalidFlushInterval
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", iface)
+	if err != nil {
+		return fmt.Errorf("unable to resolve UDP address: %v", err)
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on UDP: %v", err)
+	}
+
+	s.mu.Lock()
+	s.conn = conn
+	s.done = make(chan struct{})
+	s.mu.Unlock()
+	s.Stats.Listening(conn.LocalAddr())
+
+	s.wg.Add(2)
+	go s.serve(conn)
+	go s.flusher(s.done)
+
+	return nil
+}
+
+// Addr returns the address the server is listening on.
+// Returns nil if the server is not listening.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+// Close stops the listener and writes any remaining aggregates.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.conn == nil {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	close(s.done)
+	s.mu.Unlock()
+
+	// Wait for all goroutines to shutdown.
+	s.wg.Wait()
+
+	s.Flush(time.Now())
+	return err
+}
+
+// serve reads packets until the connection is closed.
+func (s *Server) serve(conn *net.UDPConn) {
+	defer s.wg.Done()
+
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if s.Addr() == nil {
+				return
+			}
+			s.Logger.Errorf("StatsD ReadFromUDP error: %s", err)
+			continue
+		}
+
+		// Packets may contain multiple newline separated metrics.
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+
+			m, err := Parse(line)
+			if err != nil {
+				s.Logger.Warnf("unable to parse StatsD data: %s", err)
+				s.Stats.ParseError()
+				continue
+			}
+			s.Stats.Received(1)
+			s.Add(m)
+		}
+	}
+}
+
+// flusher writes the aggregates on every flush interval until done is closed.
+func (s *Server) flusher(done chan struct{}) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			s.Flush(now)
+		}
+	}
+}
+
+// Add adds a metric to the current interval's aggregates.
+func (s *Server) Add(m *Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := m.key()
+	a := s.aggregates[key]
+	if a == nil || a.typ != m.Type {
+		a = &aggregate{name: m.Name, tags: m.Tags, typ: m.Type}
+		s.aggregates[key] = a
+	}
+	a.updated = true
+
+	switch m.Type {
+	case Counter:
+		a.value += m.Value / m.SampleRate
+	case Gauge:
+		if m.Relative {
+			a.value += m.Value
+		} else {
+			a.value = m.Value
+		}
+	case Timer:
+		a.timings = append(a.timings, m.Value)
+	case Set:
+		if a.members == nil {
+			a.members = make(map[string]struct{})
+		}
+		a.members[m.SetValue] = struct{}{}
+	}
+}
+
+// Flush writes a point for every series updated since the last flush and
+// resets the aggregates. Gauges keep their value so that later relative
+// updates apply to it.
+func (s *Server) Flush(now time.Time) {
+	s.mu.Lock()
+	var points []influxdb.Point
+	for key, a := range s.aggregates {
+		if !a.updated {
+			continue
+		}
+		points = append(points, influxdb.Point{Name: a.name, Tags: a.tags, Timestamp: now, Values: a.values()})
+
+		// Reset everything but gauges.
+		if a.typ == Gauge {
+			a.updated = false
+		} else {
+			delete(s.aggregates, key)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, p := range points {
+		if _, err := s.writer.WriteSeries(s.Database, s.RetentionPolicy, []influxdb.Point{p}); err != nil {
+			s.Logger.Errorf("unable to write StatsD data: %s", err)
+			s.Stats.WriteError()
+		}
+	}
+}
+
+// values returns the field values written for the aggregate.
+func (a *aggregate) values() map[string]interface{} {
+	switch a.typ {
+	case Timer:
+		sort.Float64s(a.timings)
+		var sum float64
+		for _, v := range a.timings {
+			sum += v
+		}
+		n := len(a.timings)
+		return map[string]interface{}{
+			"count":    float64(n),
+			"mean":     sum / float64(n),
+			"min":      a.timings[0],
+			"max":      a.timings[n-1],
+			"upper_90": a.timings[int(math.Ceil(0.9*float64(n)))-1],
+		}
+	case Set:
+		return map[string]interface{}{"value": float64(len(a.members))}
+	default:
+		return map[string]interface{}{"value": a.value}
+	}
+}
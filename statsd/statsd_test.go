@@ -0,0 +1,148 @@
+package statsd_test
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/statsd"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		line   string
+		metric *statsd.Metric
+		err    string
+	}{
+		{line: `hits:1|c`, metric: &statsd.Metric{Name: "hits", Tags: map[string]string{}, Type: statsd.Counter, Value: 1, SampleRate: 1}},
+		{line: `hits:2|c|@0.5`, metric: &statsd.Metric{Name: "hits", Tags: map[string]string{}, Type: statsd.Counter, Value: 2, SampleRate: 0.5}},
+		{line: `temp:-3.5|g`, metric: &statsd.Metric{Name: "temp", Tags: map[string]string{}, Type: statsd.Gauge, Value: -3.5, Relative: true, SampleRate: 1}},
+		{line: `latency:320|ms|#host:a,region:us-west`, metric: &statsd.Metric{Name: "latency", Tags: map[string]string{"host": "a", "region": "us-west"}, Type: statsd.Timer, Value: 320, SampleRate: 1}},
+		{line: `latency:10|h`, metric: &statsd.Metric{Name: "latency", Tags: map[string]string{}, Type: statsd.Timer, Value: 10, SampleRate: 1}},
+		{line: `users:bob|s`, metric: &statsd.Metric{Name: "users", Tags: map[string]string{}, Type: statsd.Set, SetValue: "bob", SampleRate: 1}},
+		{line: `hits`, err: `received "hits" which is not in the form: name:value|type`},
+		{line: `hits:1`, err: `received "hits:1" which is not in the form: name:value|type`},
+		{line: `hits:1|x`, err: `invalid metric type "x"`},
+		{line: `hits:x|c`, err: `invalid value "x"`},
+		{line: `hits:1|c|@2`, err: `invalid sample rate "2"`},
+		{line: `hits:1|c|#host`, err: `invalid tag "host"`},
+		{line: `hits:1|c|foo`, err: `invalid option "foo"`},
+	}
+
+	for i, tt := range tests {
+		m, err := statsd.Parse(tt.line)
+		if errstr(err) != tt.err {
+			t.Errorf("%d. error mismatch: expected %q, got %q", i, tt.err, errstr(err))
+		} else if tt.err == "" && !reflect.DeepEqual(m, tt.metric) {
+			t.Errorf("%d. metric mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.metric, m)
+		}
+	}
+}
+
+// Ensure metrics are aggregated by type and written on flush.
+func TestServer_Flush(t *testing.T) {
+	var points []influxdb.Point
+	s := statsd.NewServer(&testSeriesWriter{fn: func(database, retentionPolicy string, a []influxdb.Point) (uint64, error) {
+		points = append(points, a...)
+		return 0, nil
+	}})
+
+	for _, line := range []string{
+		`hits:1|c`, `hits:2|c|@0.5`,
+		`temp:10|g`, `temp:+5|g`,
+		`latency:1|ms`, `latency:2|ms`, `latency:3|ms`, `latency:4|ms`,
+		`users:bob|s`, `users:sue|s`, `users:bob|s`,
+	} {
+		s.Add(mustParse(line))
+	}
+
+	now := time.Unix(0, 0).UTC()
+	s.Flush(now)
+	sort.Sort(pointsByName(points))
+	exp := []influxdb.Point{
+		{Name: "hits", Tags: map[string]string{}, Timestamp: now, Values: map[string]interface{}{"value": float64(5)}},
+		{Name: "latency", Tags: map[string]string{}, Timestamp: now, Values: map[string]interface{}{"count": float64(4), "mean": float64(2.5), "min": float64(1), "max": float64(4), "upper_90": float64(4)}},
+		{Name: "temp", Tags: map[string]string{}, Timestamp: now, Values: map[string]interface{}{"value": float64(15)}},
+		{Name: "users", Tags: map[string]string{}, Timestamp: now, Values: map[string]interface{}{"value": float64(2)}},
+	}
+	if !reflect.DeepEqual(points, exp) {
+		t.Fatalf("unexpected points:\n\nexp=%#v\n\ngot=%#v\n\n", exp, points)
+	}
+
+	// Ensure only updated series are written and gauges keep their value.
+	points = nil
+	s.Add(mustParse(`temp:-1|g`))
+	s.Flush(now)
+	if len(points) != 1 || points[0].Values["value"] != float64(14) {
+		t.Fatalf("unexpected points: %#v", points)
+	}
+}
+
+// Ensure the server receives metrics over UDP and writes them on close.
+func TestServer_ListenAndServe(t *testing.T) {
+	points := make(chan influxdb.Point, 1)
+	s := statsd.NewServer(&testSeriesWriter{fn: func(database, retentionPolicy string, a []influxdb.Point) (uint64, error) {
+		if database != "stats" {
+			t.Errorf("unexpected database: %s", database)
+		}
+		points <- a[0]
+		return 0, nil
+	}})
+	s.Database = "stats"
+	s.FlushInterval = 10 * time.Millisecond
+	if err := s.ListenAndServe("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("udp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hits:3|c\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case p := <-points:
+		if p.Name != "hits" || p.Values["value"] != float64(3) {
+			t.Fatalf("unexpected point: %#v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for point")
+	}
+}
+
+// testSeriesWriter is a SeriesWriter that calls a function on every write.
+type testSeriesWriter struct {
+	fn func(database, retentionPolicy string, points []influxdb.Point) (uint64, error)
+}
+
+func (w *testSeriesWriter) WriteSeries(database, retentionPolicy string, points []influxdb.Point) (uint64, error) {
+	return w.fn(database, retentionPolicy, points)
+}
+
+type pointsByName []influxdb.Point
+
+func (p pointsByName) Len() int           { return len(p) }
+func (p pointsByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p pointsByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func mustParse(line string) *statsd.Metric {
+	m, err := statsd.Parse(line)
+	if err != nil {
+		panic(err.Error())
+	}
+	return m
+}
+
+func errstr(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
@@ -0,0 +1,528 @@
+package influxdb
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// StorageEngine represents the store for the series data of a single shard.
+// Values are opaque encoded bytes keyed by series id and timestamp.
+type StorageEngine interface {
+	// Writes points. Points are sorted by series and timestamp and a point
+	// replaces any existing values with the same series and timestamp.
+	WritePoints(points []StoragePoint) error
+
+	// Returns the values for a series at a timestamp or nil if not found.
+	ReadSeries(seriesID uint32, timestamp int64) ([]byte, error)
+
+	// Removes all values for a series.
+	DeleteSeries(seriesID uint32) error
+
+	// Removes the values for a series at a set of timestamps.
+	DeletePoints(seriesID uint32, timestamps []int64) error
+
+	// Starts a read-only transaction for iterating over a consistent view.
+	Begin() (StorageTx, error)
+
+	// Returns the size of the store in bytes.
+	Size() int64
+
+	// Reclaims space left behind by deleted and overwritten values.
+	// Transactions started before compacting still read the old values.
+	Compact() error
+
+	// Closes the store. Transactions that are still open can be read until
+	// they are rolled back.
+	Close() error
+}
+
+// StorageTx represents a read-only transaction on a storage engine.
+// A query shares one transaction across every series it reads from a shard.
+// Cursor is never called concurrently but the cursors it returns are used
+// from different goroutines so each cursor must hold its own position.
+type StorageTx interface {
+	// Returns a cursor over a series' values ordered by timestamp.
+	// Returns nil if the series has no values.
+	Cursor(seriesID uint32) StorageCursor
+
+	// Returns the size of the snapshot written by Copy, in bytes.
+	Size() int64
+
+	// Writes a snapshot of the store that can be opened by the same engine.
+	Copy(w io.Writer) error
+
+	// Closes the transaction.
+	Rollback() error
+}
+
+// StorageCursor iterates over the values of a single series.
+//
+// Cursors that can fail while reading, such as cursors over encrypted or
+// remote data, may also implement "Err() error". They stop returning values
+// at the failure and callers check the error once iteration ends.
+type StorageCursor interface {
+	// Moves to the first value at or after timestamp.
+	// Returns nil values if there are no more values.
+	SeekTo(timestamp int64) (int64, []byte)
+
+	// Moves to the next value. Returns nil values if there are no more values.
+	Next() (int64, []byte)
+}
+
+// cursorErr returns the read error of a cursor, if it reports one.
+func cursorErr(c StorageCursor) error {
+	if c, ok := c.(interface {
+		Err() error
+	}); ok {
+		return c.Err()
+	}
+	return nil
+}
+
+// StoragePoint represents the encoded values of a series at a timestamp.
+type StoragePoint struct {
+	SeriesID  uint32
+	Timestamp int64
+	Values    []byte
+}
+
+// StorageEngineOpener opens a storage engine at path, creating it if it does
+// not exist. Backups of the engine are restored to path.
+type StorageEngineOpener func(path string) (StorageEngine, error)
+
+// OpenBoltStorageEngine opens a storage engine backed by a Bolt file with one
+// bucket per series. This is the default storage engine.
+func OpenBoltStorageEngine(path string) (StorageEngine, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize store.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, _ = tx.CreateBucketIfNotExists([]byte("values"))
+		return nil
+	}); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("init: %s", err)
+	}
+
+	return &boltStorage{path: path, db: db, txs: make(map[*bolt.DB]int)}, nil
+}
+
+// boltStorage is a storage engine backed by a Bolt file.
+//
+// Bolt unmaps a file when it is closed even if read transactions are still
+// open, so files replaced by compaction or closed with the store are only
+// closed once the last transaction reading them is rolled back.
+type boltStorage struct {
+	path string
+	db   *bolt.DB
+
+	mu     sync.Mutex       // guards the fields below and swaps of db
+	txs    map[*bolt.DB]int // open read transactions on each file
+	closed bool
+}
+
+func (s *boltStorage) WritePoints(points []StoragePoint) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, p := range points {
+			// Create a bucket for the series.
+			b, err := tx.CreateBucketIfNotExists(u32tob(p.SeriesID))
+			if err != nil {
+				return err
+			}
+
+			// Insert the values by timestamp.
+			if err := b.Put(u64tob(uint64(p.Timestamp)), p.Values); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStorage) ReadSeries(seriesID uint32, timestamp int64) (values []byte, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		// Find series bucket.
+		b := tx.Bucket(u32tob(seriesID))
+		if b == nil {
+			return nil
+		}
+
+		// Retrieve encoded series data. Copy since it's only valid in the tx.
+		if v := b.Get(u64tob(uint64(timestamp))); v != nil {
+			values = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return
+}
+
+func (s *boltStorage) DeleteSeries(seriesID uint32) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(u32tob(seriesID)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *boltStorage) DeletePoints(seriesID uint32, timestamps []int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(u32tob(seriesID))
+		if b == nil {
+			return nil
+		}
+		for _, timestamp := range timestamps {
+			if err := b.Delete(u64tob(uint64(timestamp))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStorage) Begin() (StorageTx, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, bolt.ErrDatabaseNotOpen
+	}
+
+	tx, err := s.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	s.txs[s.db]++
+	return &boltStorageTx{tx: tx, store: s, db: s.db}, nil
+}
+
+// release ends a read transaction on a file and closes the file if it has
+// been replaced or the store closed and no other transactions are reading it.
+func (s *boltStorage) release(db *bolt.DB) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.txs[db]--; s.txs[db] > 0 {
+		return nil
+	}
+	delete(s.txs, db)
+	if db != s.db || s.closed {
+		return db.Close()
+	}
+	return nil
+}
+
+// closeDB closes a file that is no longer used for new transactions.
+// If transactions are still reading the file then the last one closes it.
+// Must be called while holding the lock.
+func (s *boltStorage) closeDB(db *bolt.DB) error {
+	if s.txs[db] > 0 {
+		return nil
+	}
+	return db.Close()
+}
+
+func (s *boltStorage) Size() (n int64) {
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Size()
+		return nil
+	})
+	return
+}
+
+// Compact copies the live data to a new file and then swaps the new file in
+// place of the old one. Bolt never shrinks its files so this reclaims the
+// space left by deleted and overwritten data. The compacted file is opened
+// before it replaces the current file so the current handle is kept if any
+// step fails.
+func (s *boltStorage) Compact() error {
+	// Copy every bucket into a new file next to the current one.
+	tmppath := s.path + ".compact"
+	if err := copyBolt(s.db, tmppath); err != nil {
+		_ = os.Remove(tmppath)
+		return fmt.Errorf("copy: %s", err)
+	}
+
+	// Open the compacted file and move it over the current file.
+	db, err := bolt.Open(tmppath, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		_ = os.Remove(tmppath)
+		return fmt.Errorf("open: %s", err)
+	}
+	if err := os.Rename(tmppath, s.path); err != nil {
+		_ = db.Close()
+		_ = os.Remove(tmppath)
+		return fmt.Errorf("rename: %s", err)
+	}
+
+	// The current file has been replaced so its handle is closed once the
+	// transactions reading it end.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.db
+	s.db = db
+	if err := s.closeDB(prev); err != nil {
+		return fmt.Errorf("close: %s", err)
+	}
+	return nil
+}
+
+func (s *boltStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.closeDB(s.db)
+}
+
+// copyBolt writes all buckets in src to a new file at path.
+func copyBolt(src *bolt.DB, path string) error {
+	dst, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	return src.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			// Copy each bucket in its own transaction to limit memory usage.
+			return dst.Update(func(dtx *bolt.Tx) error {
+				db, err := dtx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return b.ForEach(func(k, v []byte) error { return db.Put(k, v) })
+			})
+		})
+	})
+}
+
+// boltStorageTx wraps a read-only Bolt transaction.
+type boltStorageTx struct {
+	tx    *bolt.Tx
+	store *boltStorage
+	db    *bolt.DB // file read by the transaction, nil once rolled back
+}
+
+func (tx *boltStorageTx) Cursor(seriesID uint32) StorageCursor {
+	b := tx.tx.Bucket(u32tob(seriesID))
+	if b == nil {
+		return nil
+	}
+	return &boltStorageCursor{b.Cursor()}
+}
+
+func (tx *boltStorageTx) Size() int64            { return tx.tx.Size() }
+func (tx *boltStorageTx) Copy(w io.Writer) error { return tx.tx.Copy(w) }
+
+func (tx *boltStorageTx) Rollback() error {
+	if tx.db == nil {
+		return bolt.ErrTxClosed
+	}
+	err := tx.tx.Rollback()
+	if e := tx.store.release(tx.db); e != nil && err == nil {
+		err = e
+	}
+	tx.db = nil
+	return err
+}
+
+// boltStorageCursor wraps a cursor on a series bucket.
+type boltStorageCursor struct {
+	cur *bolt.Cursor
+}
+
+func (c *boltStorageCursor) SeekTo(timestamp int64) (int64, []byte) {
+	return boltStorageValue(c.cur.Seek(u64tob(uint64(timestamp))))
+}
+
+func (c *boltStorageCursor) Next() (int64, []byte) { return boltStorageValue(c.cur.Next()) }
+
+// boltStorageValue converts a raw key/value pair to a timestamp and values.
+func boltStorageValue(k, v []byte) (int64, []byte) {
+	if k == nil {
+		return 0, nil
+	} else if v == nil {
+		v = []byte{}
+	}
+	return int64(btou64(k)), v
+}
+
+// chainCursor iterates over a list of cursors in order as a single cursor.
+// Every value in a cursor must be before the values in the cursors after it.
+type chainCursor struct {
+	cursors []StorageCursor
+	i       int
+}
+
+// SeekTo moves to the first value at or after timestamp in any cursor.
+func (c *chainCursor) SeekTo(timestamp int64) (int64, []byte) {
+	for c.i = 0; c.i < len(c.cursors); c.i++ {
+		if k, v := c.cursors[c.i].SeekTo(timestamp); v != nil {
+			return k, v
+		}
+	}
+	return 0, nil
+}
+
+// Next moves to the next value, moving to the next cursor at the end of each.
+func (c *chainCursor) Next() (int64, []byte) {
+	if c.i >= len(c.cursors) {
+		return 0, nil
+	}
+	if k, v := c.cursors[c.i].Next(); v != nil {
+		return k, v
+	}
+	for c.i++; c.i < len(c.cursors); c.i++ {
+		if k, v := c.cursors[c.i].SeekTo(0); v != nil {
+			return k, v
+		}
+	}
+	return 0, nil
+}
+
+// encryptionMarker is the plaintext sealed in series 0 of an encrypted store.
+// Series ids start at 1 so the marker never collides with series data.
+var encryptionMarker = []byte("influxdb shard")
+
+// encryptedStorage is a storage engine that seals values with AES-GCM before
+// passing them to another engine. Series ids and timestamps aren't encrypted.
+type encryptedStorage struct {
+	StorageEngine
+	key cipher.AEAD
+}
+
+// openEncryptedStorage wraps a store if it was encrypted when it was created.
+// Unencrypted stores are returned unchanged. Returns ErrShardKeyRequired if
+// the store is encrypted and no key is set and ErrShardDecrypt if the key is
+// not the one the store was encrypted with.
+func openEncryptedStorage(store StorageEngine, key cipher.AEAD) (StorageEngine, error) {
+	v, err := store.ReadSeries(0, 0)
+	if err != nil {
+		return nil, err
+	} else if v == nil {
+		return store, nil
+	} else if key == nil {
+		return nil, ErrShardKeyRequired
+	}
+
+	if b, err := openValues(key, v); err != nil || !bytes.Equal(b, encryptionMarker) {
+		return nil, ErrShardDecrypt
+	}
+	return &encryptedStorage{StorageEngine: store, key: key}, nil
+}
+
+// encryptStorage marks a new store as encrypted and wraps it so that every
+// value written to it is sealed with key.
+func encryptStorage(store StorageEngine, key cipher.AEAD) (StorageEngine, error) {
+	if err := store.WritePoints([]StoragePoint{{Values: sealValues(key, encryptionMarker)}}); err != nil {
+		return nil, err
+	}
+	return &encryptedStorage{StorageEngine: store, key: key}, nil
+}
+
+func (s *encryptedStorage) WritePoints(points []StoragePoint) error {
+	sealed := make([]StoragePoint, len(points))
+	for i, p := range points {
+		sealed[i] = StoragePoint{SeriesID: p.SeriesID, Timestamp: p.Timestamp, Values: sealValues(s.key, p.Values)}
+	}
+	return s.StorageEngine.WritePoints(sealed)
+}
+
+func (s *encryptedStorage) ReadSeries(seriesID uint32, timestamp int64) ([]byte, error) {
+	v, err := s.StorageEngine.ReadSeries(seriesID, timestamp)
+	if err != nil || v == nil {
+		return v, err
+	}
+	return openValues(s.key, v)
+}
+
+func (s *encryptedStorage) Begin() (StorageTx, error) {
+	tx, err := s.StorageEngine.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedStorageTx{StorageTx: tx, key: s.key}, nil
+}
+
+// encryptedStorageTx wraps a transaction on an encrypted store. Snapshots
+// are copied as-is so backups of the store stay encrypted.
+type encryptedStorageTx struct {
+	StorageTx
+	key cipher.AEAD
+}
+
+func (tx *encryptedStorageTx) Cursor(seriesID uint32) StorageCursor {
+	c := tx.StorageTx.Cursor(seriesID)
+	if c == nil {
+		return nil
+	}
+	return &encryptedStorageCursor{cur: c, key: tx.key}
+}
+
+// encryptedStorageCursor opens the values of a cursor on an encrypted store.
+type encryptedStorageCursor struct {
+	cur StorageCursor
+	key cipher.AEAD
+	err error
+}
+
+func (c *encryptedStorageCursor) SeekTo(timestamp int64) (int64, []byte) {
+	return c.open(c.cur.SeekTo(timestamp))
+}
+
+func (c *encryptedStorageCursor) Next() (int64, []byte) { return c.open(c.cur.Next()) }
+
+// Err returns the error from decrypting a value, if any.
+func (c *encryptedStorageCursor) Err() error { return c.err }
+
+// open decrypts a value read from the underlying cursor. The key was verified
+// when the store was opened so a value that can't be opened has been
+// corrupted or tampered with. The cursor stops at the value rather than
+// silently skipping it and reports the error from Err.
+func (c *encryptedStorageCursor) open(k int64, v []byte) (int64, []byte) {
+	if v == nil || c.err != nil {
+		return k, nil
+	}
+	plaintext, err := openValues(c.key, v)
+	if err != nil {
+		c.err = fmt.Errorf("shard decrypt: timestamp=%d: %s", k, err)
+		return k, nil
+	}
+	return k, plaintext
+}
+
+// sealValues encrypts encoded values with a random nonce prepended.
+// AES-GCM's 96-bit random nonces are only safe for about 2^32 seals with the
+// same key. Every shard of a database shares its key and each point written
+// counts as a seal, so a database key must not be used for more writes.
+func sealValues(key cipher.AEAD, v []byte) []byte {
+	nonce := make([]byte, key.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic("shard nonce: " + err.Error())
+	}
+	return key.Seal(nonce, nonce, v, nil)
+}
+
+// openValues decrypts values sealed by sealValues.
+func openValues(key cipher.AEAD, v []byte) ([]byte, error) {
+	if len(v) < key.NonceSize() {
+		return nil, ErrShardDecrypt
+	}
+	plaintext, err := key.Open(nil, v[:key.NonceSize()], v[key.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrShardDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,315 @@
+package influxdb_test
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+// Ensure the server reads & writes series data through its storage engine.
+func TestServer_SetStorageEngine(t *testing.T) {
+	engines := make(map[string]*MemoryStorage)
+	s := NewServer()
+	if err := s.SetStorageEngine(func(path string) (influxdb.StorageEngine, error) {
+		engines[path] = NewMemoryStorage()
+		return engines[path], nil
+	}); err != nil {
+		t.Fatal(err)
+	} else if err := s.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// The engine can't be changed once the server is open.
+	if err := s.SetStorageEngine(influxdb.OpenBoltStorageEngine); err != influxdb.ErrServerOpen {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "b"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(30)}}})
+
+	if len(engines) != 1 {
+		t.Fatalf("unexpected engine count: %d", len(engines))
+	}
+
+	// Verify the data can be queried from the engine.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if res := results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,60]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Verify a single point can be read.
+	if v, err := s.ReadSeries("foo", "raw", "cpu", map[string]string{"host": "a"}, mustParseTime("2000-01-01T00:00:10Z")); err != nil {
+		t.Fatal(err)
+	} else if v["value"] != float64(20) {
+		t.Fatalf("unexpected values: %#v", v)
+	}
+
+	// A query reads every series in a shard from a single snapshot and
+	// releases it once the query finishes.
+	for _, e := range engines {
+		beginN := e.beginN
+		results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value), count(value) FROM cpu GROUP BY host`), "foo", nil)
+		if err := results.Error(); err != nil {
+			t.Fatal(err)
+		} else if n := e.beginN - beginN; n != 1 {
+			t.Fatalf("unexpected transaction count: %d", n)
+		} else if n := e.OpenN(); n != 0 {
+			t.Fatalf("unexpected open transaction count: %d", n)
+		}
+	}
+
+	// Compaction is delegated to the engine.
+	for _, e := range engines {
+		if err := s.CompactShard(1); err != nil {
+			t.Fatal(err)
+		} else if e.compactN != 1 {
+			t.Fatalf("unexpected compaction count: %d", e.compactN)
+		}
+	}
+}
+
+// Ensure the server encrypts the shards of databases with shard keys.
+func TestServer_SetShardKeys(t *testing.T) {
+	engines := make(map[string]*MemoryStorage)
+	s := NewServer()
+	if err := s.SetStorageEngine(func(path string) (influxdb.StorageEngine, error) {
+		if engines[path] == nil {
+			engines[path] = NewMemoryStorage()
+		}
+		return engines[path], nil
+	}); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetShardKeys(map[string][]byte{"foo": []byte("short")}); err != influxdb.ErrInvalidShardKey {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.SetShardKeys(map[string][]byte{"foo": []byte("0123456789abcdef")}); err != nil {
+		t.Fatal(err)
+	} else if err := s.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, name := range []string{"foo", "bar"} {
+		s.CreateDatabase(name)
+		s.CreateRetentionPolicy(name, &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+		s.SetDefaultRetentionPolicy(name, "raw")
+		s.MustWriteSeries(name, "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	}
+
+	// Values are decrypted when they're read.
+	for _, name := range []string{"foo", "bar"} {
+		if v, err := s.ReadSeries(name, "raw", "cpu", nil, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		} else if v["value"] != float64(10) {
+			t.Fatalf("unexpected values: %s: %#v", name, v)
+		}
+	}
+
+	// Only the shard of the database with a key is encrypted.
+	if a := s.ShardStats(); len(a) != 2 || !a[0].Encrypted || a[1].Encrypted {
+		t.Fatalf("unexpected shard stats: %s", mustMarshalJSON(a))
+	}
+
+	// Queries return an error for values that have been tampered with.
+	for _, store := range engines {
+		if store.series[0] == nil {
+			continue
+		}
+		for id, values := range store.series {
+			for k, v := range values {
+				if id != 0 {
+					values[k] = append(v[:len(v)-1:len(v)-1], v[len(v)-1]^0xFF)
+				}
+			}
+		}
+	}
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if err := results.Error(); err == nil || !strings.Contains(err.Error(), influxdb.ErrShardDecrypt.Error()) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// An encrypted shard can't be opened without its key.
+	path, client := s.Path(), s.Client()
+	for i, tt := range []struct {
+		keys map[string][]byte
+		err  error
+	}{
+		{keys: map[string][]byte{"foo": []byte("fedcba9876543210")}, err: influxdb.ErrShardDecrypt},
+		{keys: nil, err: influxdb.ErrShardKeyRequired},
+	} {
+		s.Server.Close()
+		if err := s.SetShardKeys(tt.keys); err != nil {
+			t.Fatal(err)
+		} else if err := s.Server.Open(path); err == nil || !strings.HasSuffix(err.Error(), tt.err.Error()) {
+			t.Fatalf("%d. unexpected error: %v", i, err)
+		}
+	}
+
+	// Reopen with the key.
+	s.Server.Close()
+	if err := s.SetShardKeys(map[string][]byte{"foo": []byte("0123456789abcdef")}); err != nil {
+		t.Fatal(err)
+	} else if err := s.Server.Open(path); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(client); err != nil {
+		t.Fatal(err)
+	} else if a := s.ShardStats(); len(a) != 2 || !a[0].Encrypted || a[1].Encrypted {
+		t.Fatalf("unexpected shard stats: %s", mustMarshalJSON(a))
+	}
+}
+
+// MemoryStorage is a storage engine that keeps all values in memory.
+type MemoryStorage struct {
+	mu       sync.RWMutex
+	series   map[uint32]map[int64][]byte
+	compactN int
+	beginN   int // transactions started
+	openN    int // transactions not yet rolled back
+
+	SeekFunc func() // called before a cursor seeks, if set
+}
+
+// NewMemoryStorage returns a new instance of MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{series: make(map[uint32]map[int64][]byte)}
+}
+
+func (s *MemoryStorage) WritePoints(points []influxdb.StoragePoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range points {
+		if s.series[p.SeriesID] == nil {
+			s.series[p.SeriesID] = make(map[int64][]byte)
+		}
+		s.series[p.SeriesID][p.Timestamp] = p.Values
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ReadSeries(seriesID uint32, timestamp int64) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.series[seriesID][timestamp], nil
+}
+
+func (s *MemoryStorage) DeleteSeries(seriesID uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.series, seriesID)
+	return nil
+}
+
+func (s *MemoryStorage) DeletePoints(seriesID uint32, timestamps []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, timestamp := range timestamps {
+		delete(s.series[seriesID], timestamp)
+	}
+	return nil
+}
+
+// Begin copies the values of every series into the transaction.
+func (s *MemoryStorage) Begin() (influxdb.StorageTx, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.beginN++
+	s.openN++
+	tx := &memoryStorageTx{storage: s, series: make(map[uint32]*memoryStorageCursor)}
+	for id, m := range s.series {
+		c := &memoryStorageCursor{values: make(map[int64][]byte, len(m)), seek: s.SeekFunc}
+		for timestamp, v := range m {
+			c.keys = append(c.keys, timestamp)
+			c.values[timestamp] = v
+		}
+		sort.Sort(int64Slice(c.keys))
+		tx.series[id] = c
+	}
+	return tx, nil
+}
+
+// OpenN returns the number of transactions that have not been rolled back.
+func (s *MemoryStorage) OpenN() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.openN
+}
+
+func (s *MemoryStorage) Size() int64    { return 0 }
+func (s *MemoryStorage) Compact() error { s.compactN++; return nil }
+func (s *MemoryStorage) Close() error   { return nil }
+
+type memoryStorageTx struct {
+	storage *MemoryStorage
+	series  map[uint32]*memoryStorageCursor
+}
+
+func (tx *memoryStorageTx) Cursor(seriesID uint32) influxdb.StorageCursor {
+	if c := tx.series[seriesID]; c != nil {
+		other := *c
+		return &other
+	}
+	return nil
+}
+
+func (tx *memoryStorageTx) Size() int64            { return 0 }
+func (tx *memoryStorageTx) Copy(w io.Writer) error { return errors.New("not supported") }
+func (tx *memoryStorageTx) Rollback() error {
+	tx.storage.mu.Lock()
+	defer tx.storage.mu.Unlock()
+	tx.storage.openN--
+	return nil
+}
+
+type memoryStorageCursor struct {
+	keys   []int64
+	values map[int64][]byte
+	i      int
+	seek   func()
+}
+
+func (c *memoryStorageCursor) SeekTo(timestamp int64) (int64, []byte) {
+	if c.seek != nil {
+		c.seek()
+	}
+	c.i = sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= timestamp })
+	return c.value()
+}
+
+func (c *memoryStorageCursor) Next() (int64, []byte) {
+	c.i++
+	return c.value()
+}
+
+func (c *memoryStorageCursor) value() (int64, []byte) {
+	if c.i >= len(c.keys) {
+		return 0, nil
+	}
+	return c.keys[c.i], c.values[c.keys[c.i]]
+}
+
+type int64Slice []int64
+
+func (p int64Slice) Len() int           { return len(p) }
+func (p int64Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p int64Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,147 @@
+package influxdb
+
+import (
+	"sync"
+	"time"
+)
+
+// WriteTailBufferSize is the number of writes held for a slow tail reader.
+// Writes sampled while the buffer is full are dropped for that reader.
+var WriteTailBufferSize = 100
+
+// AppliedWrite represents a point that was applied to a local shard.
+type AppliedWrite struct {
+	Database        string                 `json:"database"`
+	RetentionPolicy string                 `json:"retentionPolicy"`
+	Measurement     string                 `json:"measurement"`
+	Tags            map[string]string      `json:"tags,omitempty"`
+	Timestamp       time.Time              `json:"timestamp"`
+	Values          map[string]interface{} `json:"values"`
+}
+
+// writeTail represents a single reader of applied writes.
+type writeTail struct {
+	c      chan *AppliedWrite
+	sample int // every nth write is sent
+	n      int // writes seen
+}
+
+// writeTails represents the set of readers tailing applied writes.
+type writeTails struct {
+	mu sync.Mutex
+	m  map[*writeTail]struct{}
+}
+
+// empty returns true if there are no readers.
+func (a *writeTails) empty() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.m) == 0
+}
+
+// TailWrites returns a channel that receives every nth write applied to the
+// server's local shards, where n is the sample rate. Writes are dropped
+// rather than slowing down the server when the reader falls behind.
+// The returned function stops the tail and closes the channel.
+func (s *Server) TailWrites(sample int) (<-chan *AppliedWrite, func()) {
+	if sample < 1 {
+		sample = 1
+	}
+	t := &writeTail{c: make(chan *AppliedWrite, WriteTailBufferSize), sample: sample}
+
+	s.tails.mu.Lock()
+	s.tails.m[t] = struct{}{}
+	s.tails.mu.Unlock()
+
+	return t.c, func() {
+		s.tails.mu.Lock()
+		defer s.tails.mu.Unlock()
+		if _, ok := s.tails.m[t]; ok {
+			delete(s.tails.m, t)
+			close(t.c)
+		}
+	}
+}
+
+// tailWrite sends an applied write to each reader whose sample includes it.
+// The write is only built by fn if at least one reader wants it.
+// This function must be called under a read lock.
+func (s *Server) tailWrite(fn func() *AppliedWrite) {
+	s.tails.mu.Lock()
+	defer s.tails.mu.Unlock()
+
+	// Find the readers that sample this write.
+	var a []*writeTail
+	for t := range s.tails.m {
+		if t.n%t.sample == 0 {
+			a = append(a, t)
+		}
+		t.n++
+	}
+	if len(a) == 0 {
+		return
+	}
+
+	w := fn()
+	if w == nil {
+		return
+	}
+	for _, t := range a {
+		select {
+		case t.c <- w:
+		default:
+		}
+	}
+}
+
+// appliedWrite builds an applied write for encoded values written to a shard.
+// Returns nil if the shard or series cannot be found or the values cannot be
+// decoded. This function must be called under a read lock.
+func (s *Server) appliedWrite(shardID uint64, seriesID uint32, timestamp int64, data []byte) *AppliedWrite {
+	db, rp := s.shardPolicy(shardID)
+	if db == nil {
+		return nil
+	}
+	series := db.series[seriesID]
+	if series == nil || series.measurement == nil {
+		return nil
+	}
+
+	// Decode the values using the measurement's field names.
+	rawValues, err := unmarshalValues(data)
+	if err != nil {
+		return nil
+	}
+	values := make(map[string]interface{})
+	for id, v := range rawValues {
+		if f := series.measurement.Field(id); f != nil {
+			values[f.Name] = v
+		}
+	}
+
+	return &AppliedWrite{
+		Database:        db.name,
+		RetentionPolicy: rp.Name,
+		Measurement:     series.measurement.Name,
+		Tags:            series.Tags,
+		Timestamp:       time.Unix(0, timestamp).UTC(),
+		Values:          values,
+	}
+}
+
+// shardPolicy returns the database and retention policy that own a shard.
+// This function must be called under a read lock.
+func (s *Server) shardPolicy(shardID uint64) (*database, *RetentionPolicy) {
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				for _, sh := range g.Shards {
+					if sh.ID == shardID {
+						return db, rp
+					}
+				}
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,79 @@
+package testutil
+
+import (
+	"sync"
+
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// MessagingClient represents a fake broker client. Published messages are
+// assigned an autoincrementing index and sent straight back to the server.
+type MessagingClient struct {
+	mu    sync.Mutex
+	index uint64
+	c     chan *messaging.Message
+
+	PublishFunc       func(*messaging.Message) (uint64, error)
+	CreateReplicaFunc func(replicaID uint64) error
+	DeleteReplicaFunc func(replicaID uint64) error
+	SubscribeFunc     func(replicaID, topicID uint64) error
+	UnsubscribeFunc   func(replicaID, topicID uint64) error
+	TruncateTopicFunc func(topicID, index uint64) error
+}
+
+// NewMessagingClient returns a new instance of MessagingClient.
+func NewMessagingClient() *MessagingClient {
+	c := &MessagingClient{c: make(chan *messaging.Message, 1)}
+	c.PublishFunc = c.send
+	c.CreateReplicaFunc = func(replicaID uint64) error { return nil }
+	c.DeleteReplicaFunc = func(replicaID uint64) error { return nil }
+	c.SubscribeFunc = func(replicaID, topicID uint64) error { return nil }
+	c.UnsubscribeFunc = func(replicaID, topicID uint64) error { return nil }
+	c.TruncateTopicFunc = func(topicID, index uint64) error { return nil }
+	return c
+}
+
+// Publish attaches an autoincrementing index to the message.
+// This function also executes the client's PublishFunc mock function.
+func (c *MessagingClient) Publish(m *messaging.Message) (uint64, error) {
+	c.mu.Lock()
+	c.index++
+	m.Index = c.index
+	c.mu.Unlock()
+	return c.PublishFunc(m)
+}
+
+// send sends the message through to the channel.
+// This is the default value of PublishFunc.
+func (c *MessagingClient) send(m *messaging.Message) (uint64, error) {
+	c.c <- m
+	return m.Index, nil
+}
+
+// CreateReplica creates a new replica with a given ID on the broker.
+func (c *MessagingClient) CreateReplica(replicaID uint64) error {
+	return c.CreateReplicaFunc(replicaID)
+}
+
+// DeleteReplica deletes an existing replica with a given ID from the broker.
+func (c *MessagingClient) DeleteReplica(replicaID uint64) error {
+	return c.DeleteReplicaFunc(replicaID)
+}
+
+// Subscribe adds a subscription to a replica for a topic on the broker.
+func (c *MessagingClient) Subscribe(replicaID, topicID uint64) error {
+	return c.SubscribeFunc(replicaID, topicID)
+}
+
+// Unsubscribe removes a subscrition from a replica for a topic on the broker.
+func (c *MessagingClient) Unsubscribe(replicaID, topicID uint64) error {
+	return c.UnsubscribeFunc(replicaID, topicID)
+}
+
+// TruncateTopic removes the messages at or before an index from a topic on the broker.
+func (c *MessagingClient) TruncateTopic(topicID, index uint64) error {
+	return c.TruncateTopicFunc(topicID, index)
+}
+
+// C returns a channel for streaming message.
+func (c *MessagingClient) C() <-chan *messaging.Message { return c.c }
@@ -0,0 +1,208 @@
+// Package testutil provides an in-process broker and data node for writing
+// integration tests against the influxdb package.
+//
+// A Server runs a real broker and data node over HTTP using temporary
+// directories that are removed when the server is closed:
+//
+//	s := testutil.OpenServer()
+//	defer s.Close()
+//
+//	s.MustCreateDatabase("db", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+//	s.MustWriteSeries("db", "raw", []influxdb.Point{...})
+//	s.AssertQuery(t, `SELECT count(value) FROM cpu`, "db", `[{"rows":[...]}]`)
+//
+// Tests that don't need a broker can use OpenLocalServer with a
+// MessagingClient, which applies published messages immediately.
+package testutil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// Server represents an in-process data node and, unless opened with
+// OpenLocalServer, a broker. Both are served from a single HTTP server.
+type Server struct {
+	*influxdb.Server
+
+	Broker     *messaging.Broker // nil for local servers
+	Client     *messaging.Client // nil for local servers
+	Handler    *influxdb.Handler // data node HTTP handler
+	HTTPServer *httptest.Server
+
+	brokerHandler *messaging.Handler
+	path          string // temporary directory for all data
+}
+
+// OpenServer returns an initialized broker and data node. Panic on error.
+func OpenServer() *Server {
+	s := newServer()
+
+	// Open the broker and attach it to the HTTP server.
+	u := mustParseURL(s.HTTPServer.URL)
+	s.Broker = messaging.NewBroker()
+	if err := s.Broker.Open(filepath.Join(s.path, "broker"), u); err != nil {
+		panic("open broker: " + err.Error())
+	} else if err := s.Broker.Initialize(); err != nil {
+		panic("initialize broker: " + err.Error())
+	}
+	s.brokerHandler.SetBroker(s.Broker)
+
+	// Open the data node and connect it to the broker.
+	s.openServer()
+	if err := s.Broker.CreateReplica(1); err != nil {
+		panic("create replica: " + err.Error())
+	}
+	s.Client = messaging.NewClient(1)
+	if err := s.Client.Open(filepath.Join(s.path, "messaging"), []*url.URL{u}); err != nil {
+		panic("open client: " + err.Error())
+	}
+	if err := s.Server.SetClient(s.Client); err != nil {
+		panic("set client: " + err.Error())
+	}
+	if err := s.Server.Initialize(u); err != nil {
+		panic("initialize: " + err.Error())
+	}
+
+	return s
+}
+
+// OpenLocalServer returns an initialized data node attached to client
+// instead of a broker. Panic on error.
+func OpenLocalServer(client influxdb.MessagingClient) *Server {
+	s := newServer()
+	s.openServer()
+	if err := s.Server.SetClient(client); err != nil {
+		panic("set client: " + err.Error())
+	}
+	if err := s.Server.Initialize(mustParseURL(s.HTTPServer.URL)); err != nil {
+		panic("initialize: " + err.Error())
+	}
+	return s
+}
+
+// newServer returns a server with a temporary directory and HTTP server.
+func newServer() *Server {
+	path, err := ioutil.TempDir("", "influxdb-testutil-")
+	if err != nil {
+		panic("temp dir: " + err.Error())
+	}
+
+	s := &Server{Server: influxdb.NewServer(), path: path}
+	s.Handler = influxdb.NewHandler(s.Server)
+	s.brokerHandler = messaging.NewHandler(nil)
+	s.HTTPServer = httptest.NewServer(&handler{broker: s.brokerHandler, server: s.Handler})
+	return s
+}
+
+// openServer opens the data node in the temporary directory.
+func (s *Server) openServer() {
+	if err := s.Server.Open(filepath.Join(s.path, "data")); err != nil {
+		panic("open server: " + err.Error())
+	}
+}
+
+// URL returns the URL of the HTTP server.
+func (s *Server) URL() *url.URL { return mustParseURL(s.HTTPServer.URL) }
+
+// Close shuts down the data node, broker & HTTP server and removes all data.
+func (s *Server) Close() {
+	defer os.RemoveAll(s.path)
+	_ = s.Server.Close()
+	if s.Client != nil {
+		_ = s.Client.Close()
+	}
+	if s.Broker != nil {
+		_ = s.Broker.Close()
+	}
+	s.HTTPServer.Close()
+}
+
+// MustCreateDatabase creates a database with a retention policy and sets the
+// policy as the database's default. Panic on error.
+func (s *Server) MustCreateDatabase(name string, rp *influxdb.RetentionPolicy) {
+	if err := s.CreateDatabase(name); err != nil {
+		panic("create database: " + err.Error())
+	} else if err := s.CreateRetentionPolicy(name, rp); err != nil {
+		panic("create retention policy: " + err.Error())
+	} else if err := s.SetDefaultRetentionPolicy(name, rp.Name); err != nil {
+		panic("set default retention policy: " + err.Error())
+	}
+}
+
+// MustWriteSeries writes series data and waits for the data to be applied.
+// Returns the messaging index for the write. Panic on error.
+func (s *Server) MustWriteSeries(database, retentionPolicy string, points []influxdb.Point) uint64 {
+	index, err := s.WriteSeries(database, retentionPolicy, points)
+	if err != nil {
+		panic("write series: " + err.Error())
+	} else if err = s.Sync(index); err != nil {
+		panic("sync: " + err.Error())
+	}
+	return index
+}
+
+// MustQuery parses and executes a query as an administrator.
+// Panic if the query cannot be parsed.
+func (s *Server) MustQuery(q, database string) influxdb.Results {
+	query, err := influxql.NewParser(strings.NewReader(q)).ParseQuery()
+	if err != nil {
+		panic("parse query: " + err.Error())
+	}
+	return s.ExecuteQuery(query, database, nil)
+}
+
+// T represents the subset of testing.TB used to report failed assertions.
+type T interface {
+	Errorf(format string, args ...interface{})
+}
+
+// AssertQuery executes a query and reports an error if the JSON encoding of
+// the results does not match exp.
+func (s *Server) AssertQuery(t T, q, database, exp string) {
+	if act := MustMarshalJSON(s.MustQuery(q, database)); act != exp {
+		t.Errorf("unexpected results: %s\n\nexp: %s\n\ngot: %s", q, exp, act)
+	}
+}
+
+// MustMarshalJSON encodes a value to a JSON string. Panic on error.
+func MustMarshalJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic("marshal: " + err.Error())
+	}
+	return string(b)
+}
+
+// handler routes broker requests to the broker and all others to the data node.
+type handler struct {
+	broker *messaging.Handler
+	server *influxdb.Handler
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/raft") || strings.HasPrefix(r.URL.Path, "/messaging") {
+		h.broker.ServeHTTP(w, r)
+		return
+	}
+	h.server.ServeHTTP(w, r)
+}
+
+// mustParseURL parses a string into a URL. Panic on error.
+func mustParseURL(s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err.Error())
+	}
+	return u
+}
@@ -0,0 +1,32 @@
+package testutil_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/testutil"
+)
+
+// Ensure points written through a broker can be queried.
+func TestServer(t *testing.T) {
+	s := testutil.OpenServer()
+	defer s.Close()
+
+	s.MustCreateDatabase("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "b"}, Timestamp: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), Values: map[string]interface{}{"value": float64(20)}}})
+
+	s.AssertQuery(t, `SELECT sum(value) FROM cpu`, "foo", `[{"rows":[{"name":"cpu","columns":["time","sum"],"values":[[0,30]]}]}]`)
+}
+
+// Ensure points written through a fake messaging client can be queried.
+func TestOpenLocalServer(t *testing.T) {
+	s := testutil.OpenLocalServer(testutil.NewMessagingClient())
+	defer s.Close()
+
+	s.MustCreateDatabase("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), Values: map[string]interface{}{"value": float64(10)}}})
+
+	s.AssertQuery(t, `SELECT count(value) FROM cpu`, "foo", `[{"rows":[{"name":"cpu","columns":["time","count"],"values":[[0,1]]}]}]`)
+}
@@ -0,0 +1,224 @@
+package influxdb
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// Token represents an API token that authenticates requests as a user.
+// Only a hash of the token's secret is stored by the cluster.
+type Token struct {
+	ID      uint64             `json:"id"`
+	User    string             `json:"user"`
+	Hash    string             `json:"hash"`              // hex encoded SHA-256 of the secret
+	Scope   influxql.Privilege `json:"scope"`             // highest privilege granted by the token
+	Expires time.Time          `json:"expires,omitempty"` // zero if the token never expires
+}
+
+// expired returns true if the token has expired by time t.
+func (t *Token) expired(now time.Time) bool {
+	return !t.Expires.IsZero() && !now.Before(t.Expires)
+}
+
+// scopedUser returns the user authenticated by the token. Tokens without all
+// privileges act as a non-admin copy of the user with only the privilege of
+// the token on the databases the user could already access.
+func (t *Token) scopedUser(u *User, databases []string) *User {
+	if t.Scope == influxql.AllPrivileges {
+		return u
+	}
+
+	other := &User{Name: u.Name, Privileges: make(map[string]influxql.Privilege)}
+	if u.Admin {
+		for _, name := range databases {
+			other.Privileges[name] = t.Scope
+		}
+		return other
+	}
+	for name, p := range u.Privileges {
+		if p == influxql.AllPrivileges || p == t.Scope {
+			other.Privileges[name] = t.Scope
+		}
+	}
+	return other
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a token's secret.
+func hashToken(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// CreateToken creates an API token for a user with a scope of read, write or
+// all privileges. The token expires after ttl, or never if ttl is zero.
+// Returns the token and its secret. The secret is not stored by the cluster
+// and can't be retrieved again.
+func (s *Server) CreateToken(username string, scope influxql.Privilege, ttl time.Duration) (*Token, string, error) {
+	if scope != influxql.ReadPrivilege && scope != influxql.WritePrivilege && scope != influxql.AllPrivileges {
+		return nil, "", ErrInvalidTokenScope
+	} else if ttl < 0 {
+		return nil, "", ErrInvalidTokenTTL
+	}
+
+	// Generate the secret before broadcasting so that only its hash is sent.
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	secret := hex.EncodeToString(b)
+
+	c := &createTokenCommand{Username: username, Hash: hashToken(secret), Scope: scope}
+	if ttl > 0 {
+		c.Expires = s.clock.Now().Add(ttl).UTC()
+	}
+	if _, err := s.broadcast(createTokenMessageType, c); err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t := s.tokens[c.Hash]
+	if t == nil {
+		return nil, "", ErrTokenNotFound
+	}
+	other := *t
+	return &other, secret, nil
+}
+
+func (s *Server) applyCreateToken(m *messaging.Message) error {
+	var c createTokenCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	if s.users[c.Username] == nil {
+		return ErrUserNotFound
+	} else if c.Hash == "" || s.tokens[c.Hash] != nil {
+		return ErrInvalidToken
+	}
+
+	// Persist to metastore.
+	t := &Token{User: c.Username, Hash: c.Hash, Scope: c.Scope, Expires: c.Expires}
+	s.meta.mustUpdate(func(tx *metatx) error {
+		t.ID = tx.nextTokenID()
+		return tx.saveToken(t)
+	})
+	s.tokens[t.Hash] = t
+
+	return nil
+}
+
+type createTokenCommand struct {
+	Username string             `json:"username"`
+	Hash     string             `json:"hash"`
+	Scope    influxql.Privilege `json:"scope"`
+	Expires  time.Time          `json:"expires,omitempty"`
+}
+
+// RevokeToken removes an API token by id.
+// Returns ErrTokenNotFound if the token doesn't exist.
+func (s *Server) RevokeToken(id uint64) error {
+	c := &revokeTokenCommand{ID: id}
+	_, err := s.broadcast(revokeTokenMessageType, c)
+	return err
+}
+
+func (s *Server) applyRevokeToken(m *messaging.Message) error {
+	var c revokeTokenCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, t := range s.tokens {
+		if t.ID != c.ID {
+			continue
+		}
+
+		// Remove from metastore.
+		s.meta.mustUpdate(func(tx *metatx) error {
+			return tx.deleteToken(t.ID)
+		})
+		delete(s.tokens, hash)
+		return nil
+	}
+	return ErrTokenNotFound
+}
+
+type revokeTokenCommand struct {
+	ID uint64 `json:"id"`
+}
+
+// deleteUserTokens removes the tokens of a user.
+// This function must be called under a write lock.
+func (s *Server) deleteUserTokens(tx *metatx, username string) error {
+	for hash, t := range s.tokens {
+		if t.User != username {
+			continue
+		}
+		if err := tx.deleteToken(t.ID); err != nil {
+			return err
+		}
+		delete(s.tokens, hash)
+	}
+	return nil
+}
+
+// Tokens returns the API tokens of a user sorted by id.
+func (s *Server) Tokens(username string) []*Token {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var a tokens
+	for _, t := range s.tokens {
+		if t.User == username {
+			other := *t
+			a = append(a, &other)
+		}
+	}
+	sort.Sort(a)
+	return a
+}
+
+// AuthenticateToken returns the user authenticated by a token's secret.
+// Returns ErrInvalidToken if the token doesn't exist or its user was removed
+// and ErrTokenExpired if the token has expired.
+func (s *Server) AuthenticateToken(secret string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t := s.tokens[hashToken(secret)]
+	if t == nil {
+		return nil, ErrInvalidToken
+	} else if t.expired(s.clock.Now()) {
+		return nil, ErrTokenExpired
+	}
+
+	u := s.users[t.User]
+	if u == nil {
+		return nil, ErrInvalidToken
+	}
+
+	var databases []string
+	if u.Admin && t.Scope != influxql.AllPrivileges {
+		for name := range s.databases {
+			databases = append(databases, name)
+		}
+	}
+	return t.scopedUser(u, databases), nil
+}
+
+// tokens represents a list of tokens, sortable by id.
+type tokens []*Token
+
+func (p tokens) Len() int           { return len(p) }
+func (p tokens) Less(i, j int) bool { return p[i].ID < p[j].ID }
+func (p tokens) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
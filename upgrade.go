@@ -0,0 +1,195 @@
+package influxdb
+
+import (
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// MessageFormat is the highest broker message format this data node can
+// apply. Format 1 is the original set of messages; each later format adds
+// message types that data nodes built before it would skip without applying,
+// or fields that they would ignore.
+const MessageFormat = 4
+
+// Formats that added fields to existing message types. Messages that use the
+// fields are only published once every data node can apply them.
+const (
+	// shardHashMessageFormat added the shard hash to created retention policies.
+	shardHashMessageFormat = 2
+
+	// typedValuesMessageFormat added typed values and expiry to writes.
+	typedValuesMessageFormat = 2
+
+	// retentionPolicyOptionsMessageFormat added the duration, replication
+	// and sharding options to retention policy updates.
+	retentionPolicyOptionsMessageFormat = 3
+
+	// dropEmptySeriesMessageFormat added dropping series only if they are
+	// still empty when the drop is applied.
+	dropEmptySeriesMessageFormat = 3
+
+	// leaseRenewalMessageFormat added the renewal index to lease requests
+	// so that lease takeovers don't depend on the requester's clock.
+	leaseRenewalMessageFormat = 4
+)
+
+// messageFormats is the format that introduced each server message type.
+var messageFormats = map[messaging.MessageType]int{
+	createDataNodeMessageType:              1,
+	deleteDataNodeMessageType:              1,
+	updateDataNodeMessageType:              1, // safe to skip, only used for negotiation
+	createDatabaseMessageType:              1,
+	deleteDatabaseMessageType:              1,
+	createRetentionPolicyMessageType:       1,
+	updateRetentionPolicyMessageType:       1,
+	deleteRetentionPolicyMessageType:       1,
+	setDefaultRetentionPolicyMessageType:   1,
+	createUserMessageType:                  1,
+	updateUserMessageType:                  1,
+	deleteUserMessageType:                  1,
+	createShardGroupIfNotExistsMessageType: 1,
+	createSeriesIfNotExistsMessageType:     1,
+	writeRawSeriesMessageType:              1,
+	writeSeriesMessageType:                 1,
+
+	promoteDataNodeMessageType:             2,
+	grantPrivilegeMessageType:              2,
+	revokePrivilegeMessageType:             2,
+	setPrivilegeMessageType:                2,
+	createTokenMessageType:                 2,
+	revokeTokenMessageType:                 2,
+	createDownsamplePolicyMessageType:      2,
+	deleteDownsamplePolicyMessageType:      2,
+	acquireContinuousQueryLeaseMessageType: 2,
+	createContinuousQueryMessageType:       2,
+	deleteContinuousQueryMessageType:       2,
+	createEventMessageType:                 2,
+	metadataBatchMessageType:               2,
+	blockWritesMessageType:                 2,
+	unblockWritesMessageType:               2,
+	deleteShardGroupMessageType:            2,
+	setShardOwnersMessageType:              2,
+	dropSeriesMessageType:                  2,
+	deleteRangeMessageType:                 2,
+}
+
+// canApply returns true if this data node understands a message type.
+// Broker messages are passed through to data nodes and are always ignored.
+func canApply(typ messaging.MessageType) bool {
+	if typ&messaging.BrokerMessageType != 0 {
+		return true
+	}
+	f, ok := messageFormats[typ]
+	return ok && f <= MessageFormat
+}
+
+// SetVersion sets the version this data node reports to the cluster.
+func (s *Server) SetVersion(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = version
+}
+
+// ClusterMessageFormat returns the highest message format every data node in
+// the cluster can apply. Data nodes that joined before formats were negotiated
+// are assumed to only apply format 1.
+func (s *Server) ClusterMessageFormat() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.clusterMessageFormat()
+}
+
+// clusterMessageFormat returns the lowest message format of the data nodes.
+// This function must be called under a read lock.
+func (s *Server) clusterMessageFormat() int {
+	format := MessageFormat
+	for _, n := range s.dataNodes {
+		if f := n.messageFormat(); f < format {
+			format = f
+		}
+	}
+	return format
+}
+
+// checkMessageFormat returns ErrMessageFormatUnsupported if a message type
+// can't be applied by every data node in the cluster.
+func (s *Server) checkMessageFormat(typ messaging.MessageType) error {
+	return s.checkFormat(messageFormats[typ])
+}
+
+// checkFormat returns ErrMessageFormatUnsupported if a message format can't
+// be applied by every data node in the cluster.
+func (s *Server) checkFormat(format int) error {
+	if format > s.ClusterMessageFormat() {
+		return ErrMessageFormatUnsupported
+	}
+	return nil
+}
+
+// AnnounceDataNode broadcasts this data node's version and message format
+// to the cluster if they differ from the ones recorded for it. Data nodes
+// announce themselves after an upgrade so that newer messages can be used
+// once every data node supports them.
+func (s *Server) AnnounceDataNode() error {
+	s.mu.RLock()
+	n := s.dataNodes[s.id]
+	if n == nil {
+		s.mu.RUnlock()
+		return ErrDataNodeNotFound
+	}
+	c := &updateDataNodeCommand{ID: n.ID, Version: s.version, MessageFormat: MessageFormat}
+	announced := n.Version == c.Version && n.MessageFormat == c.MessageFormat
+	s.mu.RUnlock()
+
+	if announced {
+		return nil
+	}
+	_, err := s.broadcast(updateDataNodeMessageType, c)
+	return err
+}
+
+func (s *Server) applyUpdateDataNode(m *messaging.Message) error {
+	var c updateDataNodeCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate data node.
+	n := s.dataNodes[c.ID]
+	if n == nil {
+		return ErrDataNodeNotFound
+	}
+
+	// Persist to metastore.
+	n.Version, n.MessageFormat = c.Version, c.MessageFormat
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDataNode(n)
+	})
+}
+
+type updateDataNodeCommand struct {
+	ID            uint64 `json:"id"`
+	Version       string `json:"version,omitempty"`
+	MessageFormat int    `json:"messageFormat"`
+}
+
+// UpgradeRequired returns true if the data node has stopped applying messages
+// because it received a message in a format it doesn't understand.
+func (s *Server) UpgradeRequired() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.upgradeIndex != 0
+}
+
+// haltForUpgrade stops the data node from applying messages at the index of a
+// message it can't apply. The message and every message after it stay queued
+// on the broker and are applied once the data node is upgraded and restarted.
+// This function must be called under a write lock.
+func (s *Server) haltForUpgrade(m *messaging.Message) {
+	if s.upgradeIndex != 0 {
+		return
+	}
+	s.Logger.Warnf("message format: data node must be upgraded to apply message type %#x at index %d", m.Type, m.Index)
+	s.upgradeIndex = m.Index
+	s.notifyApplied()
+}
@@ -0,0 +1,450 @@
+package influxdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// writeQueue holds asynchronous writes until they are published to the
+// broker. Queued writes are logged so they survive a restart. The log is a
+// segment file named by the log position of its first byte and a separate
+// file records the position of the first write that hasn't been published.
+// A new segment holding only the unpublished writes is started once most of
+// the current segment has been published.
+type writeQueue struct {
+	mu      sync.Mutex
+	dir     string
+	f       *os.File // current segment
+	offsetf *os.File // position of the first unpublished write
+	base    int64    // log position of the segment's first byte
+	offset  int64    // log position of the first unpublished write
+	writes  []*queuedWrite
+	size    int64 // bytes of unpublished writes in the log
+	maxSize int64 // zero is unlimited
+
+	notify chan struct{} // signaled when a write is queued
+}
+
+// queuedWrite is a batch of points queued for a database.
+type queuedWrite struct {
+	Database        string         `json:"database"`
+	RetentionPolicy string         `json:"retentionPolicy,omitempty"`
+	Points          []*queuedPoint `json:"points"`
+
+	size int64 // bytes in the log
+}
+
+// queuedPoint is the logged form of a Point. The types of typed numbers are
+// recorded since JSON does not distinguish integers.
+type queuedPoint struct {
+	Name      string                       `json:"name"`
+	Tags      map[string]string            `json:"tags,omitempty"`
+	Timestamp time.Time                    `json:"timestamp"`
+	Values    map[string]interface{}       `json:"values"`
+	Types     map[string]influxql.DataType `json:"types,omitempty"`
+	TTL       time.Duration                `json:"ttl,omitempty"`
+}
+
+// newQueuedPoint returns the logged form of a point.
+func newQueuedPoint(p Point) *queuedPoint {
+	qp := &queuedPoint{Name: p.Name, Tags: p.Tags, Timestamp: p.Timestamp, Values: p.Values, TTL: p.TTL}
+	for k, v := range p.Values {
+		switch v.(type) {
+		case int, int32, int64, float32, float64:
+			if qp.Types == nil {
+				qp.Types = make(map[string]influxql.DataType)
+			}
+			qp.Types[k] = influxql.InspectDataType(v)
+		}
+	}
+	return qp
+}
+
+// point returns the point with typed numbers restored. Untyped numbers are
+// left for the measurement to convert when the point is written.
+func (qp *queuedPoint) point() Point {
+	values := make(map[string]interface{}, len(qp.Values))
+	for k, v := range qp.Values {
+		if n, ok := v.(json.Number); ok {
+			switch qp.Types[k] {
+			case influxql.Integer:
+				v, _ = n.Int64()
+			case influxql.Number:
+				v, _ = n.Float64()
+			}
+		}
+		values[k] = v
+	}
+	return Point{Name: qp.Name, Tags: qp.Tags, Timestamp: qp.Timestamp, Values: values, TTL: qp.TTL}
+}
+
+// openWriteQueue opens the log in dir and reads any unpublished writes queued
+// by a previous process. A partial write at the end of the log was never
+// acknowledged so it is discarded.
+func openWriteQueue(dir string, maxSize int64) (*writeQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	q := &writeQueue{dir: dir, maxSize: maxSize, notify: make(chan struct{}, 1)}
+	if err := q.open(); err != nil {
+		_ = q.close()
+		return nil, err
+	}
+	if len(q.writes) > 0 {
+		q.notify <- struct{}{}
+	}
+	return q, nil
+}
+
+// open reads the published position and the newest segment of the log.
+func (q *writeQueue) open() error {
+	// Read the position of the first unpublished write, if it has been saved.
+	f, err := os.OpenFile(filepath.Join(q.dir, "offset"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	q.offsetf = f
+	var buf [8]byte
+	if _, err := io.ReadFull(f, buf[:]); err == nil {
+		q.offset = int64(binary.BigEndian.Uint64(buf[:]))
+	} else if err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	// Use the newest segment. Older segments and partial new segments are
+	// left behind if the process stops while a new segment is started.
+	q.base = q.offset
+	paths, err := filepath.Glob(filepath.Join(q.dir, "writes.*"))
+	if err != nil {
+		return err
+	}
+	var segment string
+	for _, path := range paths {
+		base, err := strconv.ParseInt(strings.TrimPrefix(filepath.Base(path), "writes."), 16, 64)
+		if err != nil {
+			_ = os.Remove(path)
+			continue
+		} else if segment != "" && base < q.base {
+			_ = os.Remove(path)
+			continue
+		} else if segment != "" {
+			_ = os.Remove(segment)
+		}
+		segment, q.base = path, base
+	}
+	if q.offset < q.base {
+		q.offset = q.base
+	}
+
+	if q.f, err = os.OpenFile(q.segmentPath(q.base), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600); err != nil {
+		return err
+	}
+
+	// Read the writes after the published position.
+	pos := q.base
+	r := bufio.NewReader(q.f)
+	for {
+		w, err := readQueuedWrite(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if pos >= q.offset {
+			q.writes = append(q.writes, w)
+			q.size += w.size
+		}
+		pos += w.size
+	}
+	if q.offset > pos {
+		q.offset = pos
+	}
+
+	// Drop the partial write, if any, so new writes follow the last whole one.
+	return q.f.Truncate(pos - q.base)
+}
+
+// segmentPath returns the path of the segment starting at a log position.
+func (q *writeQueue) segmentPath(base int64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("writes.%016x", base))
+}
+
+// readQueuedWrite decodes a length-prefixed write from r.
+func readQueuedWrite(r io.Reader) (*queuedWrite, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	w := &queuedWrite{size: int64(len(hdr) + len(buf))}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err := dec.Decode(w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// append logs a write and adds it to the end of the queue.
+// The write is synced to disk before it returns.
+func (q *writeQueue) append(w *queuedWrite) error {
+	b, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4, 4+len(b))
+	binary.BigEndian.PutUint32(buf, uint32(len(b)))
+	buf = append(buf, b...)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.f == nil {
+		return ErrWriteQueueDisabled
+	} else if q.maxSize > 0 && q.size+int64(len(buf)) > q.maxSize {
+		return ErrWriteQueueFull
+	}
+	if _, err := q.f.Write(buf); err != nil {
+		return err
+	} else if err := q.f.Sync(); err != nil {
+		return err
+	}
+	w.size = int64(len(buf))
+	q.size += w.size
+	q.writes = append(q.writes, w)
+
+	// Wake the drainer without blocking if it is already signaled.
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// front returns the oldest queued write or nil if the queue is empty.
+func (q *writeQueue) front() *queuedWrite {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.writes) == 0 {
+		return nil
+	}
+	return q.writes[0]
+}
+
+// pop removes the oldest queued write and saves the position of the next
+// one so the write isn't published again after a restart. A new segment is
+// started once more of the log has been published than remains queued.
+func (q *writeQueue) pop() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.writes) == 0 {
+		return nil
+	}
+	w := q.writes[0]
+	q.writes[0] = nil
+	q.writes = q.writes[1:]
+	q.offset += w.size
+	q.size -= w.size
+	if q.f == nil {
+		return nil
+	}
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(q.offset))
+	if _, err := q.offsetf.WriteAt(buf[:], 0); err != nil {
+		return err
+	} else if err := q.offsetf.Sync(); err != nil {
+		return err
+	}
+
+	if q.offset-q.base > q.size {
+		return q.roll()
+	}
+	return nil
+}
+
+// roll copies the unpublished writes to a new segment that starts at the
+// published position and removes the current segment. The new segment is
+// written to a temporary file first so a partial copy is never read.
+// This function must be called under a lock.
+func (q *writeQueue) roll() error {
+	path := q.segmentPath(q.offset)
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, io.NewSectionReader(q.f, q.offset-q.base, q.size)); err != nil {
+		_ = f.Close()
+		return err
+	} else if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	} else if err := os.Rename(path+".tmp", path); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	prev := q.f.Name()
+	_ = q.f.Close()
+	q.f, q.base = f, q.offset
+	return os.Remove(prev)
+}
+
+// len returns the number of queued writes.
+func (q *writeQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.writes)
+}
+
+// close closes the log. Queued writes remain in the log.
+func (q *writeQueue) close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var err error
+	if q.f != nil {
+		err = q.f.Close()
+		q.f = nil
+	}
+	if q.offsetf != nil {
+		if e := q.offsetf.Close(); e != nil && err == nil {
+			err = e
+		}
+		q.offsetf = nil
+	}
+	return err
+}
+
+// writeQueueDir returns the directory of the write queue's log.
+func (s *Server) writeQueueDir() string {
+	return filepath.Join(s.path, "queue")
+}
+
+// StartWriteQueue opens the durable queue used by EnqueueSeries and starts
+// publishing queued writes in the background. Writes are retried every
+// interval while the server can't accept them. Writes queued before a restart
+// are published once the queue is started again. maxSize limits the bytes
+// held by the queue; zero is unlimited. Any previous queue is stopped.
+func (s *Server) StartWriteQueue(maxSize int64, interval time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.opened() {
+		return ErrServerClosed
+	} else if interval <= 0 {
+		return ErrInvalidWriteQueueInterval
+	}
+
+	// Stop previous queue, if running.
+	if s.writeQueueDone != nil {
+		close(s.writeQueueDone)
+		_ = s.writeQueue.close()
+		s.writeQueue, s.writeQueueDone = nil, nil
+	}
+
+	q, err := openWriteQueue(s.writeQueueDir(), maxSize)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{}, 0)
+	s.writeQueue, s.writeQueueDone = q, done
+	go s.drainWriteQueue(q, s.clock.NewTicker(interval), done)
+
+	return nil
+}
+
+// EnqueueSeries durably queues points to be written to the database in the
+// background and returns without waiting for the broker. Points without a
+// timestamp are written at the time they are queued. Errors writing queued
+// points are logged. Returns ErrWriteQueueDisabled if the queue isn't started
+// or ErrWriteQueueFull if it has reached its maximum size.
+func (s *Server) EnqueueSeries(database, retentionPolicy string, points []Point) error {
+	s.mu.RLock()
+	q, now := s.writeQueue, s.clock.Now()
+	s.mu.RUnlock()
+	if q == nil {
+		return ErrWriteQueueDisabled
+	}
+
+	w := &queuedWrite{Database: database, RetentionPolicy: retentionPolicy}
+	for _, p := range points {
+		if p.Timestamp.IsZero() {
+			p.Timestamp = now
+		}
+		w.Points = append(w.Points, newQueuedPoint(p))
+	}
+	return q.append(w)
+}
+
+// WriteQueueLen returns the number of batches waiting in the write queue.
+func (s *Server) WriteQueueLen() int {
+	s.mu.RLock()
+	q := s.writeQueue
+	s.mu.RUnlock()
+	if q == nil {
+		return 0
+	}
+	return q.len()
+}
+
+// drainWriteQueue runs in a separate goroutine and publishes queued writes
+// whenever a write is queued or the ticker fires until done is closed.
+func (s *Server) drainWriteQueue(q *writeQueue, ticker Ticker, done chan struct{}) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-q.notify:
+		case <-ticker.C():
+		}
+
+		for s.writeQueued(q) {
+		}
+	}
+}
+
+// writeQueued publishes the oldest queued write and removes it from the
+// queue. Points that can't be written are logged and dropped unless the
+// server can't accept writes, in which case the write stays queued.
+// Returns true if a write was removed.
+func (s *Server) writeQueued(q *writeQueue) bool {
+	w := q.front()
+	if w == nil {
+		return false
+	}
+
+	for len(w.Points) > 0 {
+		p := w.Points[0].point()
+		if _, err := s.WriteSeries(w.Database, w.RetentionPolicy, []Point{p}); err != nil {
+			if s.Ready() != nil {
+				return false
+			}
+			s.Logger.Errorf("write queue: db=%s, measurement=%s: %s", w.Database, p.Name, err)
+		}
+		w.Points = w.Points[1:]
+	}
+
+	if err := q.pop(); err != nil {
+		s.Logger.Errorf("write queue: %s", err)
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package influxdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Ensure published writes aren't read again when the queue is reopened.
+func TestWriteQueue_pop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-writequeue-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := openWriteQueue(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"cpu", "mem", "disk"} {
+		if err := q.append(&queuedWrite{Database: "foo", Points: []*queuedPoint{{Name: name}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size := q.size
+	if err := q.pop(); err != nil {
+		t.Fatal(err)
+	} else if q.size >= size {
+		t.Fatalf("unexpected size: %d", q.size)
+	}
+	size = q.size
+
+	// The remaining writes are read after a restart.
+	if err := q.close(); err != nil {
+		t.Fatal(err)
+	} else if q, err = openWriteQueue(dir, 0); err != nil {
+		t.Fatal(err)
+	} else if q.len() != 2 || q.front().Points[0].Name != "mem" || q.size != size {
+		t.Fatalf("unexpected queue: len=%d, size=%d", q.len(), q.size)
+	}
+
+	// A new segment is started once most of the log has been published.
+	if err := q.pop(); err != nil {
+		t.Fatal(err)
+	} else if paths, _ := filepath.Glob(filepath.Join(dir, "writes.*")); len(paths) != 1 || q.base != q.offset {
+		t.Fatalf("unexpected segments: %v", paths)
+	}
+	if err := q.close(); err != nil {
+		t.Fatal(err)
+	} else if q, err = openWriteQueue(dir, 0); err != nil {
+		t.Fatal(err)
+	} else if q.len() != 1 || q.front().Points[0].Name != "disk" {
+		t.Fatalf("unexpected queue: len=%d", q.len())
+	}
+
+	// The queue is empty once every write has been published.
+	if err := q.pop(); err != nil {
+		t.Fatal(err)
+	} else if err := q.close(); err != nil {
+		t.Fatal(err)
+	} else if q, err = openWriteQueue(dir, 0); err != nil {
+		t.Fatal(err)
+	} else if q.len() != 0 || q.size != 0 {
+		t.Fatalf("unexpected queue: len=%d, size=%d", q.len(), q.size)
+	}
+	_ = q.close()
+}